@@ -51,6 +51,7 @@ type Message struct { // TODO combine with server.message
 	Click      string
 	Icon       string
 	Attachment *Attachment
+	Replaces   string
 
 	// Additional fields
 	TopicURL       string
@@ -68,6 +69,23 @@ type Attachment struct {
 	Owner   string `json:"-"` // IP address of uploader, used for rate limiting
 }
 
+// Schedule represents a recurring message, see Client.Schedule
+type Schedule struct {
+	ID       string
+	Topic    string
+	Schedule string // Cron expression, macro (e.g. @daily) or interval (e.g. "every 1h")
+	Paused   bool
+	Next     int64 // Unix time of the next run, not set if paused
+	Last     int64 // Unix time of the last run, not set if the schedule has not run yet
+	Created  int64
+	Title    string
+	Message  string
+	Priority int
+	Tags     []string
+	Click    string
+	Icon     string
+}
+
 type subscription struct {
 	ID       string
 	topicURL string
@@ -213,6 +231,128 @@ func (c *Client) Unsubscribe(subscriptionID string) {
 	sub.cancel()
 }
 
+// ScheduledMessages returns the scheduled (delayed) messages of a topic that have not been published yet, see
+// WithDelay. Unless the user is an admin, only the messages published by the user (or the IP address, if anonymous)
+// are returned.
+func (c *Client) ScheduledMessages(topic string, options ...RequestOption) ([]*Message, error) {
+	topicURL, err := c.expandTopicURL(topic)
+	if err != nil {
+		return nil, err
+	}
+	messages := make([]*Message, 0)
+	if err := c.request(http.MethodGet, fmt.Sprintf("%s/scheduled", topicURL), nil, &messages, options...); err != nil {
+		return nil, err
+	}
+	return messages, nil
+}
+
+// CancelScheduledMessage cancels the scheduled (delayed) message with the given ID before it is published. Messages
+// that were already published cannot be cancelled.
+func (c *Client) CancelScheduledMessage(topic, id string, options ...RequestOption) error {
+	topicURL, err := c.expandTopicURL(topic)
+	if err != nil {
+		return err
+	}
+	return c.request(http.MethodDelete, fmt.Sprintf("%s/scheduled/%s", topicURL, id), nil, nil, options...)
+}
+
+// Schedule creates a recurring message on the server. Instead of publishing the message right away, the server
+// publishes it every time the schedule is due. The schedule can be a cron expression (e.g. "0 9 * * mon-fri"),
+// a macro (e.g. "@daily"), or an interval (e.g. "every 2h"). See https://ntfy.sh/docs/publish/#recurring-messages
+// for details.
+//
+// The topic and options are handled like in Publish, except that delays, e-mails, calls and file attachments
+// cannot be combined with recurring messages.
+func (c *Client) Schedule(topic, message, schedule string, options ...PublishOption) (*Schedule, error) {
+	topicURL, err := c.expandTopicURL(topic)
+	if err != nil {
+		return nil, err
+	}
+	options = append(options, WithHeader("X-Schedule", schedule))
+	var sc Schedule
+	if err := c.request(http.MethodPost, topicURL, strings.NewReader(message), &sc, options...); err != nil {
+		return nil, err
+	}
+	return &sc, nil
+}
+
+// Schedules returns the recurring messages of a topic. Unless the user is an admin, only the recurring
+// messages created by the user (or the IP address, if anonymous) are returned.
+func (c *Client) Schedules(topic string, options ...RequestOption) ([]*Schedule, error) {
+	topicURL, err := c.expandTopicURL(topic)
+	if err != nil {
+		return nil, err
+	}
+	schedules := make([]*Schedule, 0)
+	if err := c.request(http.MethodGet, fmt.Sprintf("%s/schedules", topicURL), nil, &schedules, options...); err != nil {
+		return nil, err
+	}
+	return schedules, nil
+}
+
+// PauseSchedule pauses the recurring message with the given ID, see Schedule
+func (c *Client) PauseSchedule(topic, id string, options ...RequestOption) (*Schedule, error) {
+	return c.changeSchedulePaused(topic, id, true, options...)
+}
+
+// ResumeSchedule resumes the paused recurring message with the given ID. Runs that were missed while the
+// recurring message was paused are not caught up on.
+func (c *Client) ResumeSchedule(topic, id string, options ...RequestOption) (*Schedule, error) {
+	return c.changeSchedulePaused(topic, id, false, options...)
+}
+
+// DeleteSchedule removes the recurring message with the given ID, see Schedule
+func (c *Client) DeleteSchedule(topic, id string, options ...RequestOption) error {
+	topicURL, err := c.expandTopicURL(topic)
+	if err != nil {
+		return err
+	}
+	return c.request(http.MethodDelete, fmt.Sprintf("%s/schedules/%s", topicURL, id), nil, nil, options...)
+}
+
+func (c *Client) changeSchedulePaused(topic, id string, paused bool, options ...RequestOption) (*Schedule, error) {
+	topicURL, err := c.expandTopicURL(topic)
+	if err != nil {
+		return nil, err
+	}
+	body := fmt.Sprintf(`{"paused":%t}`, paused)
+	var sc Schedule
+	if err := c.request(http.MethodPatch, fmt.Sprintf("%s/schedules/%s", topicURL, id), strings.NewReader(body), &sc, options...); err != nil {
+		return nil, err
+	}
+	return &sc, nil
+}
+
+// request performs a request against the server, and decodes the JSON response into v, unless v is nil.
+// If the server responds with anything other than 200 OK, the response body is returned as error.
+func (c *Client) request(method, url string, body io.Reader, v any, options ...RequestOption) error {
+	req, err := http.NewRequest(method, url, body)
+	if err != nil {
+		return err
+	}
+	for _, option := range options {
+		if err := option(req); err != nil {
+			return err
+		}
+	}
+	log.Debug("Performing %s request to %s", method, url)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		b, err := io.ReadAll(io.LimitReader(resp.Body, maxResponseBytes))
+		if err != nil {
+			return err
+		}
+		return errors.New(strings.TrimSpace(string(b)))
+	} else if v == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
 func (c *Client) expandTopicURL(topic string) (string, error) {
 	if strings.HasPrefix(topic, "http://") || strings.HasPrefix(topic, "https://") {
 		return topic, nil
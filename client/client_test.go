@@ -101,6 +101,74 @@ func TestClient_Publish_Poll(t *testing.T) {
 	require.Equal(t, "some delayed message", messages[1].Message)
 }
 
+func TestClient_Schedule(t *testing.T) {
+	s, port := test.StartServer(t)
+	defer test.StopServer(t, s, port)
+	c := client.New(newTestConfig(port))
+
+	sc, err := c.Schedule("mytopic", "drink some water", "every 2h", client.WithTitle("Reminder"))
+	require.Nil(t, err)
+	require.Equal(t, "mytopic", sc.Topic)
+	require.Equal(t, "every 2h", sc.Schedule)
+	require.Equal(t, "drink some water", sc.Message)
+	require.Equal(t, "Reminder", sc.Title)
+	require.True(t, sc.Next > time.Now().Add(time.Hour).Unix())
+
+	_, err = c.Schedule("mytopic", "oops", "at some point")
+	require.NotNil(t, err)
+	require.Contains(t, err.Error(), "schedule invalid")
+
+	schedules, err := c.Schedules("mytopic")
+	require.Nil(t, err)
+	require.Equal(t, 1, len(schedules))
+	require.Equal(t, sc.ID, schedules[0].ID)
+
+	paused, err := c.PauseSchedule("mytopic", sc.ID)
+	require.Nil(t, err)
+	require.True(t, paused.Paused)
+
+	resumed, err := c.ResumeSchedule("mytopic", sc.ID)
+	require.Nil(t, err)
+	require.False(t, resumed.Paused)
+
+	require.Nil(t, c.DeleteSchedule("mytopic", sc.ID))
+	require.NotNil(t, c.DeleteSchedule("mytopic", sc.ID))
+
+	schedules, err = c.Schedules("mytopic")
+	require.Nil(t, err)
+	require.Equal(t, 0, len(schedules))
+}
+
+func TestClient_ScheduledMessages(t *testing.T) {
+	s, port := test.StartServer(t)
+	defer test.StopServer(t, s, port)
+	c := client.New(newTestConfig(port))
+
+	m1, err := c.Publish("mytopic", "in an hour", client.WithDelay("1h"))
+	require.Nil(t, err)
+	m2, err := c.Publish("mytopic", "in two hours", client.WithDelay("2h"))
+	require.Nil(t, err)
+	_, err = c.Publish("mytopic", "right away")
+	require.Nil(t, err)
+
+	messages, err := c.ScheduledMessages("mytopic")
+	require.Nil(t, err)
+	require.Equal(t, 2, len(messages))
+	require.Equal(t, m1.ID, messages[0].ID)
+	require.Equal(t, "in an hour", messages[0].Message)
+	require.Equal(t, m2.ID, messages[1].ID)
+
+	require.Nil(t, c.CancelScheduledMessage("mytopic", m1.ID))
+	err = c.CancelScheduledMessage("mytopic", m1.ID)
+	require.NotNil(t, err)
+	require.Contains(t, err.Error(), "scheduled message does not exist")
+
+	messages, err = c.ScheduledMessages("mytopic")
+	require.Nil(t, err)
+	require.Equal(t, 1, len(messages))
+	require.Equal(t, m2.ID, messages[0].ID)
+}
+
 func newTestConfig(port int) *client.Config {
 	c := client.NewConfig()
 	c.DefaultHost = fmt.Sprintf("http://127.0.0.1:%d", port)
@@ -51,6 +51,12 @@ func WithDelay(delay string) PublishOption {
 	return WithHeader("X-Delay", delay)
 }
 
+// WithReplaces instructs the server to replace (update) the message with the given ID with the new message.
+// See https://ntfy.sh/docs/publish/#updating-deleting-messages for details.
+func WithReplaces(id string) PublishOption {
+	return WithHeader("X-Replaces", id)
+}
+
 // WithClick makes the notification action open the given URL as opposed to entering the detail view
 func WithClick(url string) PublishOption {
 	return WithHeader("X-Click", url)
@@ -8,6 +8,7 @@ import (
 	"github.com/urfave/cli/v2"
 	"heckel.io/ntfy/v2/user"
 	"heckel.io/ntfy/v2/util"
+	"strings"
 )
 
 func init() {
@@ -197,20 +198,18 @@ func showUsers(c *cli.Context, manager *user.Manager, users []*user.User) error
 		if u.Role == user.RoleAdmin {
 			fmt.Fprintf(c.App.ErrWriter, "- read-write access to all topics (admin role)\n")
 		} else if len(grants) > 0 {
-			for _, grant := range grants {
-				if grant.Allow.IsReadWrite() {
-					fmt.Fprintf(c.App.ErrWriter, "- read-write access to topic %s\n", grant.TopicPattern)
-				} else if grant.Allow.IsRead() {
-					fmt.Fprintf(c.App.ErrWriter, "- read-only access to topic %s\n", grant.TopicPattern)
-				} else if grant.Allow.IsWrite() {
-					fmt.Fprintf(c.App.ErrWriter, "- write-only access to topic %s\n", grant.TopicPattern)
-				} else {
-					fmt.Fprintf(c.App.ErrWriter, "- no access to topic %s\n", grant.TopicPattern)
-				}
-			}
+			printGrants(c, grants)
 		} else {
 			fmt.Fprintf(c.App.ErrWriter, "- no topic-specific permissions\n")
 		}
+		if u.Name != user.Everyone {
+			groups, err := manager.UserGroups(u.Name)
+			if err != nil {
+				return err
+			} else if len(groups) > 0 {
+				fmt.Fprintf(c.App.ErrWriter, "- member of groups: %s\n", strings.Join(groups, ", "))
+			}
+		}
 		if u.Name == user.Everyone {
 			access := manager.DefaultAccess()
 			if access.IsReadWrite() {
@@ -226,3 +225,17 @@ func showUsers(c *cli.Context, manager *user.Manager, users []*user.User) error
 	}
 	return nil
 }
+
+func printGrants(c *cli.Context, grants []user.Grant) {
+	for _, grant := range grants {
+		if grant.Allow.IsReadWrite() {
+			fmt.Fprintf(c.App.ErrWriter, "- read-write access to topic %s\n", grant.TopicPattern)
+		} else if grant.Allow.IsRead() {
+			fmt.Fprintf(c.App.ErrWriter, "- read-only access to topic %s\n", grant.TopicPattern)
+		} else if grant.Allow.IsWrite() {
+			fmt.Fprintf(c.App.ErrWriter, "- write-only access to topic %s\n", grant.TopicPattern)
+		} else {
+			fmt.Fprintf(c.App.ErrWriter, "- no access to topic %s\n", grant.TopicPattern)
+		}
+	}
+}
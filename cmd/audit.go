@@ -0,0 +1,114 @@
+//go:build !noserver
+
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/urfave/cli/v2"
+	"heckel.io/ntfy/v2/log"
+	"heckel.io/ntfy/v2/user"
+	"heckel.io/ntfy/v2/util"
+)
+
+func init() {
+	commands = append(commands, cmdAudit)
+}
+
+var flagsAudit = append(
+	append([]cli.Flag{}, flagsUser...),
+	&cli.StringFlag{Name: "user", Aliases: []string{"u"}, Usage: "only show events performed by or affecting this user"},
+	&cli.StringFlag{Name: "action", Aliases: []string{"a"}, Usage: "only show events with this action, e.g. user.add or login.failed"},
+	&cli.StringFlag{Name: "since", Aliases: []string{"s"}, Usage: "only show events after this time (unix timestamp or duration, e.g. 24h or 7d)"},
+	&cli.StringFlag{Name: "until", Usage: "only show events before this time (unix timestamp or duration, e.g. 24h or 7d)"},
+	&cli.IntFlag{Name: "limit", Aliases: []string{"n"}, Value: 100, Usage: "maximum number of events to show, 0 for all"},
+)
+
+var cmdAudit = &cli.Command{
+	Name:      "audit",
+	Usage:     "Show the audit log of security-relevant events",
+	UsageText: "ntfy audit [--user=..] [--action=..] [--since=..] [--until=..] [--limit=..]",
+	Flags:     flagsAudit,
+	Before:    initConfigFileInputSourceFunc("config", flagsAudit, initLogFunc),
+	Action:    execAudit,
+	Category:  categoryServer,
+	Description: `Shows the audit log of security-relevant events, newest first.
+
+The audit log records changes to users, access control entries, tokens, tiers and topic
+reservations, as well as failed logins, including who made the change (actor) and from where
+(origin). Changes made via the ntfy CLI have the origin "cli". The audit log is append-only;
+events cannot be changed or deleted.
+
+This is a server-only command. It directly reads from user.db as defined in the server config
+file server.yml. The command only works if 'auth-file' is properly defined.
+
+Examples:
+  ntfy audit                             # Shows the latest 100 events
+  ntfy audit --user=phil                 # Shows events performed by or affecting user phil
+  ntfy audit --action=login.failed -s 1d # Shows failed logins in the last 24 hours
+  ntfy audit --since=7d --limit=0        # Shows all events in the last 7 days
+`,
+}
+
+func execAudit(c *cli.Context) error {
+	now := time.Now()
+	filter := &user.AuditFilter{
+		User:   c.String("user"),
+		Action: user.AuditAction(c.String("action")),
+		Limit:  c.Int("limit"),
+	}
+	var err error
+	if since := c.String("since"); since != "" {
+		if filter.Since, err = util.ParsePastTime(since, now); err != nil {
+			return errors.New("invalid --since, must be unix timestamp or duration, e.g. 24h or 7d")
+		}
+	}
+	if until := c.String("until"); until != "" {
+		if filter.Until, err = util.ParsePastTime(until, now); err != nil {
+			return errors.New("invalid --until, must be unix timestamp or duration, e.g. 24h or 7d")
+		}
+	}
+	if filter.Limit < 0 {
+		return errors.New("--limit must be 0 or greater")
+	}
+	manager, err := createUserManager(c)
+	if err != nil {
+		return err
+	}
+	events, err := manager.AuditEvents(filter)
+	if err != nil {
+		return err
+	} else if len(events) == 0 {
+		fmt.Fprintln(c.App.ErrWriter, "no audit events found")
+		return nil
+	}
+	for _, e := range events {
+		actor, target := e.Actor, e.Target
+		if actor == "" {
+			actor = "-"
+		}
+		if target == "" {
+			target = "-"
+		}
+		line := fmt.Sprintf("%s %s actor=%s origin=%s target=%s %s", e.Time.Format(time.RFC3339), e.Action, actor, e.Origin, target, e.Details)
+		fmt.Fprintln(c.App.Writer, strings.TrimSpace(line))
+	}
+	return nil
+}
+
+// auditCLI appends an event to the audit log for an action via the CLI that does not change the user database,
+// e.g. creating a signed URL. Changes are audited by the manager returned by createUserManager. Errors are only logged.
+func auditCLI(manager *user.Manager, action user.AuditAction, target, details string) {
+	event := &user.AuditEvent{
+		Origin:  user.AuditOriginCLI,
+		Action:  action,
+		Target:  target,
+		Details: details,
+	}
+	if err := manager.AddAuditEvent(event); err != nil {
+		log.Warn("Unable to write audit log event %s: %s", action, err.Error())
+	}
+}
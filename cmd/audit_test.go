@@ -0,0 +1,60 @@
+package cmd
+
+import (
+	"github.com/stretchr/testify/require"
+	"github.com/urfave/cli/v2"
+	"heckel.io/ntfy/v2/server"
+	"heckel.io/ntfy/v2/test"
+	"strings"
+	"testing"
+)
+
+func TestCLI_Audit(t *testing.T) {
+	s, conf, port := newTestServerWithAuth(t)
+	defer test.StopServer(t, s, port)
+
+	app, _, stdout, stderr := newTestApp()
+	require.Nil(t, runAuditCommand(app, conf))
+	require.Equal(t, "", stdout.String())
+	require.Equal(t, "no audit events found\n", stderr.String())
+
+	app, stdin, _, _ := newTestApp()
+	stdin.WriteString("mypass\nmypass")
+	require.Nil(t, runUserCommand(app, conf, "add", "phil"))
+	app, _, _, _ = newTestApp()
+	require.Nil(t, runAccessCommand(app, conf, "phil", "mytopic", "ro"))
+	app, _, _, _ = newTestApp()
+	require.Nil(t, runUserCommand(app, conf, "change-role", "phil", "admin"))
+
+	app, _, stdout, _ = newTestApp()
+	require.Nil(t, runAuditCommand(app, conf))
+	lines := strings.Split(strings.TrimSpace(stdout.String()), "\n")
+	require.Len(t, lines, 3)
+	require.Regexp(t, `^\S+ user.change-role actor=- origin=cli target=phil role=admin$`, lines[0])
+	require.Regexp(t, `^\S+ access.allow actor=- origin=cli target=phil topic=mytopic permission=read-only$`, lines[1])
+	require.Regexp(t, `^\S+ user.add actor=- origin=cli target=phil role=user$`, lines[2])
+
+	app, _, stdout, _ = newTestApp()
+	require.Nil(t, runAuditCommand(app, conf, "--action=access.allow", "--since=1h"))
+	require.Contains(t, stdout.String(), "access.allow")
+	require.NotContains(t, stdout.String(), "user.add")
+
+	app, _, stdout, _ = newTestApp()
+	require.Nil(t, runAuditCommand(app, conf, "--limit=1"))
+	require.Contains(t, stdout.String(), "user.change-role")
+	require.NotContains(t, stdout.String(), "access.allow")
+
+	app, _, _, _ = newTestApp()
+	require.EqualError(t, runAuditCommand(app, conf, "--since=last week"), "invalid --since, must be unix timestamp or duration, e.g. 24h or 7d")
+}
+
+func runAuditCommand(app *cli.App, conf *server.Config, args ...string) error {
+	auditArgs := []string{
+		"ntfy",
+		"--log-level=ERROR",
+		"audit",
+		"--config=" + conf.File, // Dummy config file to avoid lookups of real file
+		"--auth-file=" + conf.AuthFile,
+	}
+	return app.Run(append(auditArgs, args...))
+}
@@ -1,6 +1,7 @@
 package cmd
 
 import (
+	"encoding/json"
 	"fmt"
 	"github.com/urfave/cli/v2"
 	"github.com/urfave/cli/v2/altsrc"
@@ -39,6 +40,9 @@ func initConfigFileInputSourceFunc(configFlag string, flags []cli.Flag, next cli
 //
 // This function also maps aliases, so a .yml file can contain short options, or options with underscores
 // instead of dashes. See https://github.com/binwiederhier/ntfy/issues/255.
+//
+// Since altsrc only supports lists of strings, list entries that are YAML objects (e.g. in message-templates)
+// are converted to JSON strings.
 func newYamlSourceFromFile(file string, flags []cli.Flag) (altsrc.InputSourceContext, error) {
 	var rawConfig map[any]any
 	b, err := os.ReadFile(file)
@@ -48,6 +52,22 @@ func newYamlSourceFromFile(file string, flags []cli.Flag) (altsrc.InputSourceCon
 	if err := yaml.Unmarshal(b, &rawConfig); err != nil {
 		return nil, err
 	}
+	for key, value := range rawConfig {
+		list, ok := value.([]any)
+		if !ok {
+			continue
+		}
+		for i, entry := range list {
+			if _, ok := entry.(map[any]any); !ok {
+				continue
+			}
+			b, err := json.Marshal(toJSONCompatible(entry))
+			if err != nil {
+				return nil, fmt.Errorf("invalid entry in %v: %s", key, err.Error())
+			}
+			list[i] = string(b)
+		}
+	}
 	for _, f := range flags {
 		flagName := f.Names()[0]
 		for _, flagAlias := range f.Names()[1:] {
@@ -58,3 +78,22 @@ func newYamlSourceFromFile(file string, flags []cli.Flag) (altsrc.InputSourceCon
 	}
 	return altsrc.NewMapInputSource(file, rawConfig), nil
 }
+
+// toJSONCompatible recursively converts the map[any]any values returned by yaml.v2 to map[string]any,
+// so that they can be marshalled as JSON
+func toJSONCompatible(value any) any {
+	switch v := value.(type) {
+	case map[any]any:
+		m := make(map[string]any, len(v))
+		for key, entry := range v {
+			m[fmt.Sprintf("%v", key)] = toJSONCompatible(entry)
+		}
+		return m
+	case []any:
+		for i, entry := range v {
+			v[i] = toJSONCompatible(entry)
+		}
+		return v
+	}
+	return value
+}
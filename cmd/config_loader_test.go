@@ -36,3 +36,29 @@ K: /some/file.pem
 	require.Nil(t, err)
 	require.Equal(t, "/some/file.pem", keyFile)
 }
+
+func TestNewYamlSourceFromFile_ListOfObjects(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "server.yml")
+	contents := `
+message-templates:
+  - name: github
+    title: "{{.repository.full_name}}"
+    message: |
+      {{.sender.login}} starred the repo
+  - name: nested
+    message: "{{.a}}"
+    extra:
+      key: [1, 2]
+`
+	require.Nil(t, os.WriteFile(filename, []byte(contents), 0600))
+
+	ctx, err := newYamlSourceFromFile(filename, flagsServe)
+	require.Nil(t, err)
+
+	templates, err := ctx.StringSlice("message-templates")
+	require.Nil(t, err)
+	require.Equal(t, []string{
+		`{"message":"{{.sender.login}} starred the repo\n","name":"github","title":"{{.repository.full_name}}"}`,
+		`{"extra":{"key":[1,2]},"message":"{{.a}}","name":"nested"}`,
+	}, templates)
+}
@@ -0,0 +1,366 @@
+//go:build !noserver
+
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/urfave/cli/v2"
+	"heckel.io/ntfy/v2/user"
+	"heckel.io/ntfy/v2/util"
+)
+
+func init() {
+	commands = append(commands, cmdGroup)
+}
+
+var (
+	flagsGroup = append([]cli.Flag{}, flagsUser...)
+)
+
+var cmdGroup = &cli.Command{
+	Name:      "group",
+	Usage:     "Manage/show user groups",
+	UsageText: "ntfy group [list|add|remove|add-member|remove-member|access] ...",
+	Flags:     flagsGroup,
+	Before:    initConfigFileInputSourceFunc("config", flagsGroup, initLogFunc),
+	Category:  categoryServer,
+	Subcommands: []*cli.Command{
+		{
+			Name:      "list",
+			Aliases:   []string{"l"},
+			Usage:     "Shows a list of groups",
+			UsageText: "ntfy group list [GROUP]",
+			Action:    execGroupList,
+			Description: `Shows a list of all groups, or a single group, including their members and access
+control entries.
+
+Examples:
+  ntfy group list       # Shows all groups
+  ntfy group list ops   # Shows group ops
+`,
+		},
+		{
+			Name:      "add",
+			Aliases:   []string{"a"},
+			Usage:     "Adds a new group",
+			UsageText: "ntfy group add GROUP",
+			Action:    execGroupAdd,
+			Flags: []cli.Flag{
+				&cli.BoolFlag{Name: "ignore-exists", Usage: "if the group already exists, perform no action and exit"},
+			},
+			Description: `Add a new group to the ntfy user database.
+
+A new group has no members and no access control entries. Use 'ntfy group add-member' and
+'ntfy group access' to change that.
+
+Example:
+  ntfy group add ops
+`,
+		},
+		{
+			Name:      "remove",
+			Aliases:   []string{"del", "rm"},
+			Usage:     "Removes a group",
+			UsageText: "ntfy group remove GROUP",
+			Action:    execGroupDel,
+			Description: `Remove a group from the ntfy user database, including its access control entries.
+
+The members of the group are not removed, but they lose the access granted via the group.
+
+Example:
+  ntfy group del ops
+`,
+		},
+		{
+			Name:      "add-member",
+			Aliases:   []string{"am"},
+			Usage:     "Adds users to a group",
+			UsageText: "ntfy group add-member GROUP USERNAME...",
+			Action:    execGroupAddMember,
+			Description: `Add one or more existing users to a group.
+
+Example:
+  ntfy group add-member ops phil ben
+`,
+		},
+		{
+			Name:      "remove-member",
+			Aliases:   []string{"rm-member", "rmm"},
+			Usage:     "Removes users from a group",
+			UsageText: "ntfy group remove-member GROUP USERNAME...",
+			Action:    execGroupRemoveMember,
+			Description: `Remove one or more users from a group.
+
+Example:
+  ntfy group remove-member ops ben
+`,
+		},
+		{
+			Name:      "access",
+			Usage:     "Grant/revoke access to a topic for a group",
+			UsageText: "ntfy group access [--reset] GROUP [TOPIC [PERMISSION]]",
+			Action:    execGroupAccess,
+			Flags: []cli.Flag{
+				&cli.BoolFlag{Name: "reset", Aliases: []string{"r"}, Usage: "reset access for group (and topic)"},
+			},
+			Description: `Grant or revoke access to a topic for all members of a group.
+
+Group access control entries work just like the ones of users (see 'ntfy access'), and
+apply to all members of the group. If a user has access control entries itself, and is
+also a member of groups, the following rules apply for a topic:
+
+1. If any of the user's own entries matches the topic, it is used.
+2. Otherwise, if any of the entries of the user's groups matches, it is used. If entries
+   of several groups match equally specific, their permissions are combined.
+3. Otherwise, the entries of everyone (anonymous users) and the default access apply.
+
+Within each step, more specific entries (e.g. "alerts-db") win over more generic ones
+(e.g. "alerts*").
+
+Arguments:
+  GROUP        an existing group, as created with 'ntfy group add'
+  TOPIC        name of a topic with optional wildcards, e.g. "mytopic*"
+  PERMISSION   one of the following:
+               - read-write (alias: rw)
+               - read-only (aliases: read, ro)
+               - write-only (aliases: write, wo)
+               - deny (alias: none)
+
+Examples:
+  ntfy group access ops "alerts*" rw        # Allow read-write access to topics "alerts..." for group ops
+  ntfy group access ops alerts-secret deny  # Deny access to alerts-secret for group ops
+  ntfy group access --reset ops alerts-*    # Reset access for group ops and topic pattern alerts-*
+  ntfy group access --reset ops             # Reset all access for group ops
+`,
+		},
+	},
+	Description: `Manage user groups of the ntfy server.
+
+Groups allow you to grant access to topics to many users at once: users that are members of
+a group get the access that is granted to the group, in addition to their own access control
+entries. See 'ntfy group access --help' for details.
+
+This is a server-only command. It directly manages the user.db as defined in the server config
+file server.yml. The command only works if 'auth-file' is properly defined. Please also refer
+to the related commands 'ntfy user' and 'ntfy access'.
+
+Examples:
+  ntfy group list                           # Shows list of groups
+  ntfy group add ops                        # Add group ops
+  ntfy group del ops                        # Delete group ops
+  ntfy group add-member ops phil ben        # Add users phil and ben to group ops
+  ntfy group remove-member ops ben          # Remove user ben from group ops
+  ntfy group access ops "alerts*" rw        # Allow read-write access to topics "alerts..." for group ops
+  ntfy group access --reset ops             # Reset all access for group ops
+`,
+}
+
+func execGroupList(c *cli.Context) error {
+	if c.NArg() > 1 {
+		return errors.New("too many arguments, please check 'ntfy group list --help' for usage details")
+	}
+	manager, err := createUserManager(c)
+	if err != nil {
+		return err
+	}
+	if name := c.Args().Get(0); name != "" {
+		return showGroup(c, manager, name)
+	}
+	groups, err := manager.Groups()
+	if err != nil {
+		return err
+	} else if len(groups) == 0 {
+		fmt.Fprintln(c.App.ErrWriter, "no groups configured")
+		return nil
+	}
+	for _, group := range groups {
+		printGroup(c, group)
+	}
+	return nil
+}
+
+func execGroupAdd(c *cli.Context) error {
+	name := c.Args().Get(0)
+	if name == "" {
+		return errors.New("group expected, type 'ntfy group add --help' for help")
+	} else if !user.AllowedGroup(name) {
+		return errors.New("group name not allowed, must only contain letters, numbers, '-', '_' and '.'")
+	}
+	manager, err := createUserManager(c)
+	if err != nil {
+		return err
+	}
+	if err := manager.AddGroup(name); errors.Is(err, user.ErrGroupExists) {
+		if c.Bool("ignore-exists") {
+			fmt.Fprintf(c.App.ErrWriter, "group %s already exists (exited successfully)\n", name)
+			return nil
+		}
+		return fmt.Errorf("group %s already exists", name)
+	} else if err != nil {
+		return err
+	}
+	fmt.Fprintf(c.App.ErrWriter, "group %s added\n", name)
+	return nil
+}
+
+func execGroupDel(c *cli.Context) error {
+	name := c.Args().Get(0)
+	if name == "" {
+		return errors.New("group expected, type 'ntfy group del --help' for help")
+	}
+	manager, err := createUserManager(c)
+	if err != nil {
+		return err
+	}
+	if _, err := manager.Group(name); errors.Is(err, user.ErrGroupNotFound) {
+		return fmt.Errorf("group %s does not exist", name)
+	} else if err != nil {
+		return err
+	}
+	if err := manager.RemoveGroup(name); err != nil {
+		return err
+	}
+	fmt.Fprintf(c.App.ErrWriter, "group %s removed\n", name)
+	return nil
+}
+
+func execGroupAddMember(c *cli.Context) error {
+	name := c.Args().Get(0)
+	usernames := c.Args().Tail()
+	if name == "" || len(usernames) == 0 {
+		return errors.New("group and username expected, type 'ntfy group add-member --help' for help")
+	}
+	manager, err := createUserManager(c)
+	if err != nil {
+		return err
+	}
+	for _, username := range usernames {
+		if username == userEveryone || username == user.Everyone {
+			return errors.New("username not allowed")
+		}
+		if err := manager.AddGroupMember(name, username); errors.Is(err, user.ErrGroupNotFound) {
+			return fmt.Errorf("group %s does not exist", name)
+		} else if errors.Is(err, user.ErrUserNotFound) {
+			return fmt.Errorf("user %s does not exist", username)
+		} else if err != nil {
+			return err
+		}
+		fmt.Fprintf(c.App.ErrWriter, "added user %s to group %s\n", username, name)
+	}
+	return nil
+}
+
+func execGroupRemoveMember(c *cli.Context) error {
+	name := c.Args().Get(0)
+	usernames := c.Args().Tail()
+	if name == "" || len(usernames) == 0 {
+		return errors.New("group and username expected, type 'ntfy group remove-member --help' for help")
+	}
+	manager, err := createUserManager(c)
+	if err != nil {
+		return err
+	}
+	group, err := manager.Group(name)
+	if errors.Is(err, user.ErrGroupNotFound) {
+		return fmt.Errorf("group %s does not exist", name)
+	} else if err != nil {
+		return err
+	}
+	for _, username := range usernames {
+		if !util.Contains(group.Members, username) {
+			return fmt.Errorf("user %s is not a member of group %s", username, name)
+		}
+		if err := manager.RemoveGroupMember(name, username); err != nil {
+			return err
+		}
+		fmt.Fprintf(c.App.ErrWriter, "removed user %s from group %s\n", username, name)
+	}
+	return nil
+}
+
+func execGroupAccess(c *cli.Context) error {
+	if c.NArg() > 3 {
+		return errors.New("too many arguments, please check 'ntfy group access --help' for usage details")
+	}
+	name, topic, perms := c.Args().Get(0), c.Args().Get(1), c.Args().Get(2)
+	if name == "" {
+		return errors.New("group expected, type 'ntfy group access --help' for help")
+	}
+	manager, err := createUserManager(c)
+	if err != nil {
+		return err
+	}
+	if _, err := manager.Group(name); errors.Is(err, user.ErrGroupNotFound) {
+		return fmt.Errorf("group %s does not exist", name)
+	} else if err != nil {
+		return err
+	}
+	if c.Bool("reset") {
+		if perms != "" {
+			return errors.New("too many arguments, please check 'ntfy group access --help' for usage details")
+		}
+		if err := manager.ResetGroupAccess(name, topic); err != nil {
+			return err
+		}
+		if topic == "" {
+			fmt.Fprintf(c.App.ErrWriter, "reset access for group %s\n\n", name)
+		} else {
+			fmt.Fprintf(c.App.ErrWriter, "reset access for group %s and topic %s\n\n", name, topic)
+		}
+		return showGroup(c, manager, name)
+	} else if perms == "" {
+		if topic != "" {
+			return errors.New("invalid syntax, please check 'ntfy group access --help' for usage details")
+		}
+		return showGroup(c, manager, name)
+	}
+	if !util.Contains([]string{"read-write", "rw", "read-only", "read", "ro", "write-only", "write", "wo", "none", "deny"}, perms) {
+		return errors.New("permission must be one of: read-write, read-only, write-only, or deny (or the aliases: read, ro, write, wo, none)")
+	}
+	permission, err := user.ParsePermission(perms)
+	if err != nil {
+		return err
+	}
+	if err := manager.AllowGroupAccess(name, topic, permission); errors.Is(err, user.ErrInvalidArgument) {
+		return fmt.Errorf("invalid topic %s", topic)
+	} else if err != nil {
+		return err
+	}
+	if permission.IsReadWrite() {
+		fmt.Fprintf(c.App.ErrWriter, "granted read-write access to topic %s\n\n", topic)
+	} else if permission.IsRead() {
+		fmt.Fprintf(c.App.ErrWriter, "granted read-only access to topic %s\n\n", topic)
+	} else if permission.IsWrite() {
+		fmt.Fprintf(c.App.ErrWriter, "granted write-only access to topic %s\n\n", topic)
+	} else {
+		fmt.Fprintf(c.App.ErrWriter, "revoked all access to topic %s\n\n", topic)
+	}
+	return showGroup(c, manager, name)
+}
+
+func showGroup(c *cli.Context, manager *user.Manager, name string) error {
+	group, err := manager.Group(name)
+	if errors.Is(err, user.ErrGroupNotFound) {
+		return fmt.Errorf("group %s does not exist", name)
+	} else if err != nil {
+		return err
+	}
+	printGroup(c, group)
+	return nil
+}
+
+func printGroup(c *cli.Context, group *user.Group) {
+	members := "none"
+	if len(group.Members) > 0 {
+		members = strings.Join(group.Members, ", ")
+	}
+	fmt.Fprintf(c.App.ErrWriter, "group %s (members: %s)\n", group.Name, members)
+	if len(group.Grants) > 0 {
+		printGrants(c, group.Grants)
+	} else {
+		fmt.Fprintf(c.App.ErrWriter, "- no topic-specific permissions\n")
+	}
+}
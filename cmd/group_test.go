@@ -0,0 +1,89 @@
+package cmd
+
+import (
+	"github.com/stretchr/testify/require"
+	"github.com/urfave/cli/v2"
+	"heckel.io/ntfy/v2/server"
+	"heckel.io/ntfy/v2/test"
+	"testing"
+)
+
+func TestCLI_Group_Add_Member_Access(t *testing.T) {
+	s, conf, port := newTestServerWithAuth(t)
+	defer test.StopServer(t, s, port)
+
+	app, stdin, _, _ := newTestApp()
+	stdin.WriteString("philpass\nphilpass\nbenpass\nbenpass")
+	require.Nil(t, runUserCommand(app, conf, "add", "phil"))
+	require.Nil(t, runUserCommand(app, conf, "add", "ben"))
+
+	app, _, _, stderr := newTestApp()
+	require.Nil(t, runGroupCommand(app, conf, "list"))
+	require.Contains(t, stderr.String(), "no groups configured")
+
+	app, _, _, stderr = newTestApp()
+	require.Nil(t, runGroupCommand(app, conf, "add", "ops"))
+	require.Contains(t, stderr.String(), "group ops added")
+
+	app, _, _, stderr = newTestApp()
+	require.Nil(t, runGroupCommand(app, conf, "add-member", "ops", "phil", "ben"))
+	require.Contains(t, stderr.String(), "added user phil to group ops\nadded user ben to group ops")
+
+	app, _, _, stderr = newTestApp()
+	require.Nil(t, runGroupCommand(app, conf, "access", "ops", "alerts*", "rw"))
+	require.Contains(t, stderr.String(), "granted read-write access to topic alerts*\n\ngroup ops (members: ben, phil)\n- read-write access to topic alerts*")
+
+	app, _, _, stderr = newTestApp()
+	require.Nil(t, runGroupCommand(app, conf, "access", "ops", "alerts-secret", "deny"))
+	require.Contains(t, stderr.String(), "group ops (members: ben, phil)\n- no access to topic alerts-secret\n- read-write access to topic alerts*")
+
+	app, _, _, stderr = newTestApp()
+	require.Nil(t, runGroupCommand(app, conf, "remove-member", "ops", "ben"))
+	require.Contains(t, stderr.String(), "removed user ben from group ops")
+
+	app, _, _, stderr = newTestApp()
+	require.Nil(t, runAccessCommand(app, conf, "phil"))
+	require.Contains(t, stderr.String(), "user phil (role: user, tier: none)\n- no topic-specific permissions\n- member of groups: ops")
+
+	app, _, _, stderr = newTestApp()
+	require.Nil(t, runGroupCommand(app, conf, "access", "--reset", "ops"))
+	require.Contains(t, stderr.String(), "reset access for group ops\n\ngroup ops (members: phil)\n- no topic-specific permissions")
+
+	app, _, _, stderr = newTestApp()
+	require.Nil(t, runGroupCommand(app, conf, "remove", "ops"))
+	require.Contains(t, stderr.String(), "group ops removed")
+}
+
+func TestCLI_Group_Failures(t *testing.T) {
+	s, conf, port := newTestServerWithAuth(t)
+	defer test.StopServer(t, s, port)
+
+	app, stdin, _, _ := newTestApp()
+	stdin.WriteString("philpass\nphilpass")
+	require.Nil(t, runUserCommand(app, conf, "add", "phil"))
+	require.Nil(t, runGroupCommand(app, conf, "add", "ops"))
+
+	app, _, _, _ = newTestApp()
+	require.Equal(t, "group ops already exists", runGroupCommand(app, conf, "add", "ops").Error())
+	require.Nil(t, runGroupCommand(app, conf, "add", "--ignore-exists", "ops"))
+	require.Equal(t, "group name not allowed, must only contain letters, numbers, '-', '_' and '.'", runGroupCommand(app, conf, "add", "ops team").Error())
+	require.Equal(t, "group dev does not exist", runGroupCommand(app, conf, "add-member", "dev", "phil").Error())
+	require.Equal(t, "user ben does not exist", runGroupCommand(app, conf, "add-member", "ops", "ben").Error())
+	require.Equal(t, "username not allowed", runGroupCommand(app, conf, "add-member", "ops", "everyone").Error())
+	require.Equal(t, "user phil is not a member of group ops", runGroupCommand(app, conf, "remove-member", "ops", "phil").Error())
+	require.Equal(t, "group dev does not exist", runGroupCommand(app, conf, "access", "dev", "alerts", "rw").Error())
+	require.Equal(t, "invalid topic alerts/db", runGroupCommand(app, conf, "access", "ops", "alerts/db", "rw").Error())
+	require.Equal(t, "group dev does not exist", runGroupCommand(app, conf, "remove", "dev").Error())
+}
+
+func runGroupCommand(app *cli.App, conf *server.Config, args ...string) error {
+	userArgs := []string{
+		"ntfy",
+		"--log-level=ERROR",
+		"group",
+		"--config=" + conf.File, // Dummy config file to avoid lookups of real file
+		"--auth-file=" + conf.AuthFile,
+		"--auth-default-access=" + conf.AuthDefault.String(),
+	}
+	return app.Run(append(userArgs, args...))
+}
@@ -27,6 +27,8 @@ var flagsPublish = append(
 	&cli.StringFlag{Name: "priority", Aliases: []string{"p"}, EnvVars: []string{"NTFY_PRIORITY"}, Usage: "priority of the message (1=min, 2=low, 3=default, 4=high, 5=max)"},
 	&cli.StringFlag{Name: "tags", Aliases: []string{"tag", "T"}, EnvVars: []string{"NTFY_TAGS"}, Usage: "comma separated list of tags and emojis"},
 	&cli.StringFlag{Name: "delay", Aliases: []string{"at", "in", "D"}, EnvVars: []string{"NTFY_DELAY"}, Usage: "delay/schedule message"},
+	&cli.StringFlag{Name: "replaces", EnvVars: []string{"NTFY_REPLACES"}, Usage: "ID of the message to update"},
+	&cli.StringFlag{Name: "cancel", EnvVars: []string{"NTFY_CANCEL"}, Usage: "ID of the scheduled message to cancel"},
 	&cli.StringFlag{Name: "click", Aliases: []string{"U"}, EnvVars: []string{"NTFY_CLICK"}, Usage: "URL to open when notification is clicked"},
 	&cli.StringFlag{Name: "icon", Aliases: []string{"i"}, EnvVars: []string{"NTFY_ICON"}, Usage: "URL to use as notification icon"},
 	&cli.StringFlag{Name: "actions", Aliases: []string{"A"}, EnvVars: []string{"NTFY_ACTIONS"}, Usage: "actions JSON array or simple definition"},
@@ -50,7 +52,8 @@ var cmdPublish = &cli.Command{
 	Usage:   "Send message via a ntfy server",
 	UsageText: `ntfy publish [OPTIONS..] TOPIC [MESSAGE...]
 ntfy publish [OPTIONS..] --wait-cmd COMMAND...
-NTFY_TOPIC=.. ntfy publish [OPTIONS..] [MESSAGE...]`,
+NTFY_TOPIC=.. ntfy publish [OPTIONS..] [MESSAGE...]
+ntfy publish [OPTIONS..] --cancel ID TOPIC`,
 	Action:   execPublish,
 	Category: categoryClient,
 	Flags:    flagsPublish,
@@ -64,6 +67,8 @@ Examples:
   ntfy pub --tags=warning,skull backups "Backups failed"  # Add tags/emojis to message
   ntfy pub --delay=10s delayed_topic Laterzz              # Delay message by 10s
   ntfy pub --at=8:30am delayed_topic Laterzz              # Send message at 8:30am
+  ntfy pub --cancel=hwQ2YpKdmg delayed_topic              # Cancel a scheduled message before it is sent
+  ntfy pub --replaces=hwQ2YpKdmg backups "Backup done"   # Update a previously published message
   ntfy pub -e phil@example.com alerts 'App is down!'      # Also send email to phil@example.com
   ntfy pub --click="https://reddit.com" redd 'New msg'    # Opens Reddit when notification is clicked
   ntfy pub --icon="http://some.tld/icon.png" 'Icon!'      # Send notification with custom icon
@@ -92,6 +97,7 @@ func execPublish(c *cli.Context) error {
 	priority := c.String("priority")
 	tags := c.String("tags")
 	delay := c.String("delay")
+	replaces := c.String("replaces")
 	click := c.String("click")
 	icon := c.String("icon")
 	actions := c.String("actions")
@@ -100,6 +106,7 @@ func execPublish(c *cli.Context) error {
 	filename := c.String("filename")
 	file := c.String("file")
 	email := c.String("email")
+	cancel := c.String("cancel")
 	user := c.String("user")
 	token := c.String("token")
 	noCache := c.Bool("no-cache")
@@ -112,6 +119,11 @@ func execPublish(c *cli.Context) error {
 		return errors.New("cannot set both --user and --token")
 	}
 
+	// Cancel a scheduled message
+	if cancel != "" {
+		return execPublishCancel(c, conf, cancel)
+	}
+
 	// Do the things
 	topic, message, command, err := parseTopicMessageCommand(c)
 	if err != nil {
@@ -130,6 +142,9 @@ func execPublish(c *cli.Context) error {
 	if delay != "" {
 		options = append(options, client.WithDelay(delay))
 	}
+	if replaces != "" {
+		options = append(options, client.WithReplaces(replaces))
+	}
 	if click != "" {
 		options = append(options, client.WithClick(click))
 	}
@@ -157,29 +172,11 @@ func execPublish(c *cli.Context) error {
 	if noFirebase {
 		options = append(options, client.WithNoFirebase())
 	}
-	if token != "" {
-		options = append(options, client.WithBearerAuth(token))
-	} else if user != "" {
-		var pass string
-		parts := strings.SplitN(user, ":", 2)
-		if len(parts) == 2 {
-			user = parts[0]
-			pass = parts[1]
-		} else {
-			fmt.Fprint(c.App.ErrWriter, "Enter Password: ")
-			p, err := util.ReadPassword(c.App.Reader)
-			if err != nil {
-				return err
-			}
-			pass = string(p)
-			fmt.Fprintf(c.App.ErrWriter, "\r%s\r", strings.Repeat(" ", 20))
-		}
-		options = append(options, client.WithBasicAuth(user, pass))
-	} else if conf.DefaultToken != "" {
-		options = append(options, client.WithBearerAuth(conf.DefaultToken))
-	} else if conf.DefaultUser != "" && conf.DefaultPassword != nil {
-		options = append(options, client.WithBasicAuth(conf.DefaultUser, *conf.DefaultPassword))
+	authOptions, err := parseAuthOptions(c, conf)
+	if err != nil {
+		return err
 	}
+	options = append(options, authOptions...)
 	if pid > 0 {
 		newMessage, err := waitForProcess(pid)
 		if err != nil {
@@ -228,6 +225,62 @@ func execPublish(c *cli.Context) error {
 	return nil
 }
 
+// execPublishCancel cancels a scheduled (delayed) message before it is published. The message ID is printed
+// when publishing the message with --delay, and can be found by polling with --scheduled.
+func execPublishCancel(c *cli.Context, conf *client.Config, id string) error {
+	topic, args, err := parseTopicAndArgs(c)
+	if err != nil {
+		return err
+	} else if len(args) > 0 {
+		return errors.New("cannot set message when cancelling a scheduled message")
+	}
+	options, err := parseAuthOptions(c, conf)
+	if err != nil {
+		return err
+	}
+	cl := client.New(conf)
+	if err := cl.CancelScheduledMessage(topic, id, options...); err != nil {
+		return err
+	}
+	if !c.Bool("quiet") {
+		fmt.Fprintf(c.App.ErrWriter, "scheduled message %s cancelled\n", id)
+	}
+	return nil
+}
+
+// parseAuthOptions returns the auth option for the --user or --token flag, or for the default credentials of the
+// client config, if neither is set. If --user does not contain a password, the password is read from stdin.
+func parseAuthOptions(c *cli.Context, conf *client.Config) ([]client.RequestOption, error) {
+	user, token := c.String("user"), c.String("token")
+	if user != "" && token != "" {
+		return nil, errors.New("cannot set both --user and --token")
+	}
+	if token != "" {
+		return []client.RequestOption{client.WithBearerAuth(token)}, nil
+	} else if user != "" {
+		var pass string
+		parts := strings.SplitN(user, ":", 2)
+		if len(parts) == 2 {
+			user = parts[0]
+			pass = parts[1]
+		} else {
+			fmt.Fprint(c.App.ErrWriter, "Enter Password: ")
+			p, err := util.ReadPassword(c.App.Reader)
+			if err != nil {
+				return nil, err
+			}
+			pass = string(p)
+			fmt.Fprintf(c.App.ErrWriter, "\r%s\r", strings.Repeat(" ", 20))
+		}
+		return []client.RequestOption{client.WithBasicAuth(user, pass)}, nil
+	} else if conf.DefaultToken != "" {
+		return []client.RequestOption{client.WithBearerAuth(conf.DefaultToken)}, nil
+	} else if conf.DefaultUser != "" && conf.DefaultPassword != nil {
+		return []client.RequestOption{client.WithBasicAuth(conf.DefaultUser, *conf.DefaultPassword)}, nil
+	}
+	return nil, nil
+}
+
 // parseTopicMessageCommand reads the topic and the remaining arguments from the context.
 
 // There are a few cases to consider:
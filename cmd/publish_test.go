@@ -88,6 +88,49 @@ func TestCLI_Publish_All_The_Things(t *testing.T) {
 	require.Equal(t, "https://ntfy.sh/static/img/ntfy.png", m.Icon)
 }
 
+func TestCLI_Publish_Replaces(t *testing.T) {
+	s, port := test.StartServer(t)
+	defer test.StopServer(t, s, port)
+	topic := fmt.Sprintf("http://127.0.0.1:%d/mytopic", port)
+
+	app, _, stdout, _ := newTestApp()
+	require.Nil(t, app.Run([]string{"ntfy", "publish", topic, "backup started"}))
+	original := toMessage(t, stdout.String())
+
+	app, _, stdout, _ = newTestApp()
+	require.Nil(t, app.Run([]string{"ntfy", "publish", "--replaces", original.ID, topic, "backup done"}))
+	m := toMessage(t, stdout.String())
+	require.Equal(t, "message_update", m.Event)
+	require.Equal(t, original.ID, m.Replaces)
+	require.Equal(t, "backup done", m.Message)
+}
+
+func TestCLI_Publish_Cancel(t *testing.T) {
+	s, port := test.StartServer(t)
+	defer test.StopServer(t, s, port)
+	topic := fmt.Sprintf("http://127.0.0.1:%d/mytopic", port)
+
+	app, _, stdout, _ := newTestApp()
+	require.Nil(t, app.Run([]string{"ntfy", "publish", "--delay", "1h", topic, "backup reminder"}))
+	m := toMessage(t, stdout.String())
+
+	app, _, _, stderr := newTestApp()
+	require.Nil(t, app.Run([]string{"ntfy", "publish", "--cancel", m.ID, topic}))
+	require.Equal(t, fmt.Sprintf("scheduled message %s cancelled\n", m.ID), stderr.String())
+
+	app, _, _, _ = newTestApp()
+	err := app.Run([]string{"ntfy", "publish", "--cancel", m.ID, topic})
+	require.NotNil(t, err)
+	require.Contains(t, err.Error(), "scheduled message does not exist")
+
+	app, _, stdout, _ = newTestApp()
+	require.Nil(t, app.Run([]string{"ntfy", "subscribe", "--poll", "--scheduled", topic}))
+	require.Empty(t, stdout.String())
+
+	app, _, _, _ = newTestApp()
+	require.EqualError(t, app.Run([]string{"ntfy", "publish", "--cancel", m.ID, topic, "a message"}), "cannot set message when cancelling a scheduled message")
+}
+
 func TestCLI_Publish_Wait_PID_And_Cmd(t *testing.T) {
 	s, port := test.StartServer(t)
 	defer test.StopServer(t, s, port)
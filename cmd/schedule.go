@@ -0,0 +1,289 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"github.com/urfave/cli/v2"
+	"heckel.io/ntfy/v2/client"
+	"strings"
+	"time"
+)
+
+func init() {
+	commands = append(commands, cmdSchedule)
+}
+
+var flagsSchedule = append(
+	append([]cli.Flag{}, flagsDefault...),
+	&cli.StringFlag{Name: "config", Aliases: []string{"c"}, EnvVars: []string{"NTFY_CONFIG"}, Usage: "client config file"},
+	&cli.StringFlag{Name: "user", Aliases: []string{"u"}, EnvVars: []string{"NTFY_USER"}, Usage: "username[:password] used to auth against the server"},
+	&cli.StringFlag{Name: "token", Aliases: []string{"k"}, EnvVars: []string{"NTFY_TOKEN"}, Usage: "access token used to auth against the server"},
+)
+
+var flagsScheduleAdd = []cli.Flag{
+	&cli.StringFlag{Name: "title", Aliases: []string{"t"}, EnvVars: []string{"NTFY_TITLE"}, Usage: "message title"},
+	&cli.StringFlag{Name: "message", Aliases: []string{"m"}, EnvVars: []string{"NTFY_MESSAGE"}, Usage: "message body"},
+	&cli.StringFlag{Name: "priority", Aliases: []string{"p"}, EnvVars: []string{"NTFY_PRIORITY"}, Usage: "priority of the message (1=min, 2=low, 3=default, 4=high, 5=max)"},
+	&cli.StringFlag{Name: "tags", Aliases: []string{"tag", "T"}, EnvVars: []string{"NTFY_TAGS"}, Usage: "comma separated list of tags and emojis"},
+	&cli.StringFlag{Name: "click", Aliases: []string{"U"}, EnvVars: []string{"NTFY_CLICK"}, Usage: "URL to open when notification is clicked"},
+	&cli.StringFlag{Name: "icon", Aliases: []string{"i"}, EnvVars: []string{"NTFY_ICON"}, Usage: "URL to use as notification icon"},
+	&cli.StringFlag{Name: "actions", Aliases: []string{"A"}, EnvVars: []string{"NTFY_ACTIONS"}, Usage: "actions JSON array or simple definition"},
+	&cli.StringFlag{Name: "attach", Aliases: []string{"a"}, EnvVars: []string{"NTFY_ATTACH"}, Usage: "URL to send as an external attachment"},
+	&cli.BoolFlag{Name: "markdown", Aliases: []string{"md"}, EnvVars: []string{"NTFY_MARKDOWN"}, Usage: "Message is formatted as Markdown"},
+}
+
+var cmdSchedule = &cli.Command{
+	Name:      "schedule",
+	Aliases:   []string{"sched"},
+	Usage:     "Create and manage recurring messages",
+	UsageText: "ntfy schedule [add|list|pause|resume|remove] ...",
+	Flags:     flagsSchedule,
+	Before:    initLogFunc,
+	Category:  categoryClient,
+	Subcommands: []*cli.Command{
+		{
+			Name:      "add",
+			Usage:     "Create a recurring message",
+			UsageText: "ntfy schedule add [OPTIONS..] TOPIC SCHEDULE [MESSAGE...]",
+			Action:    execScheduleAdd,
+			Flags:     flagsScheduleAdd,
+			Description: `Create a recurring message.
+
+Instead of publishing the message right away, the server publishes it every time the schedule
+is due. SCHEDULE can be a cron expression with five fields (minute, hour, day of month, month,
+day of week), a macro such as @hourly, @daily, @weekly or @monthly, or an interval such as
+"every 2h". Cron expressions are evaluated in the server's time zone, unless they are prefixed
+with CRON_TZ=<zone>, e.g. "CRON_TZ=Europe/Berlin 0 9 * * *".
+
+Examples:
+  ntfy schedule add mytopic "every 2h" Drink some water            # Every 2 hours
+  ntfy schedule add -t Standup mytopic "30 9 * * mon-fri" Standup  # At 9:30am on weekdays
+  ntfy schedule add --tags=wastebasket chores @weekly Trash day    # Every Sunday at midnight
+`,
+		},
+		{
+			Name:      "list",
+			Aliases:   []string{"l"},
+			Usage:     "Shows recurring messages of a topic",
+			UsageText: "ntfy schedule list TOPIC",
+			Action:    execScheduleList,
+			Description: `Shows the recurring messages of a topic.
+
+Unless you are an admin, only the recurring messages you created are shown. Recurring messages
+created anonymously can only be seen (and changed) from the IP address they were created from.
+
+Example:
+  ntfy schedule list mytopic
+`,
+		},
+		{
+			Name:      "pause",
+			Usage:     "Pause a recurring message",
+			UsageText: "ntfy schedule pause TOPIC ID",
+			Action:    execSchedulePause,
+			Description: `Pause a recurring message, so that it is not published until it is resumed.
+
+Example:
+  ntfy schedule pause mytopic sc_Ff2UVDHnxxYh1
+`,
+		},
+		{
+			Name:      "resume",
+			Usage:     "Resume a paused recurring message",
+			UsageText: "ntfy schedule resume TOPIC ID",
+			Action:    execScheduleResume,
+			Description: `Resume a paused recurring message. Runs missed while the message was paused are not caught up on.
+
+Example:
+  ntfy schedule resume mytopic sc_Ff2UVDHnxxYh1
+`,
+		},
+		{
+			Name:      "remove",
+			Aliases:   []string{"del", "rm"},
+			Usage:     "Remove a recurring message",
+			UsageText: "ntfy schedule remove TOPIC ID",
+			Action:    execScheduleRemove,
+			Description: `Remove a recurring message. Messages that were already published are not affected.
+
+Example:
+  ntfy schedule remove mytopic sc_Ff2UVDHnxxYh1
+`,
+		},
+	},
+	Description: `Create and manage recurring messages on a ntfy server.
+
+Recurring messages are published by the server on a schedule, e.g. every day at 9am, or every
+two hours. They can be paused, resumed and removed by the user that created them.
+
+Examples:
+  ntfy schedule add mytopic "every 2h" Drink some water  # Publish a message every 2 hours
+  ntfy schedule list mytopic                             # Show recurring messages of mytopic
+  ntfy schedule pause mytopic sc_Ff2UVDHnxxYh1           # Pause a recurring message
+  ntfy schedule resume mytopic sc_Ff2UVDHnxxYh1          # Resume a recurring message
+  ntfy schedule remove mytopic sc_Ff2UVDHnxxYh1          # Remove a recurring message
+
+Please also check out the docs on recurring messages: https://ntfy.sh/docs/publish/#recurring-messages.
+
+` + clientCommandDescriptionSuffix,
+}
+
+func execScheduleAdd(c *cli.Context) error {
+	if c.NArg() < 2 {
+		return errors.New("must specify topic and schedule, type 'ntfy schedule add --help' for help")
+	}
+	topic, schedule := c.Args().Get(0), c.Args().Get(1)
+	message := strings.Join(remainingArgs(c, 2), " ")
+	if c.String("message") != "" {
+		message = c.String("message")
+	}
+	cl, options, err := newScheduleClient(c)
+	if err != nil {
+		return err
+	}
+	if title := c.String("title"); title != "" {
+		options = append(options, client.WithTitle(title))
+	}
+	if priority := c.String("priority"); priority != "" {
+		options = append(options, client.WithPriority(priority))
+	}
+	if tags := c.String("tags"); tags != "" {
+		options = append(options, client.WithTagsList(tags))
+	}
+	if click := c.String("click"); click != "" {
+		options = append(options, client.WithClick(click))
+	}
+	if icon := c.String("icon"); icon != "" {
+		options = append(options, client.WithIcon(icon))
+	}
+	if actions := c.String("actions"); actions != "" {
+		options = append(options, client.WithActions(strings.ReplaceAll(actions, "\n", " ")))
+	}
+	if attach := c.String("attach"); attach != "" {
+		options = append(options, client.WithAttach(attach))
+	}
+	if c.Bool("markdown") {
+		options = append(options, client.WithMarkdown())
+	}
+	sc, err := cl.Schedule(topic, message, schedule, options...)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(c.App.ErrWriter, "recurring message %s added to topic %s\n\n", sc.ID, sc.Topic)
+	printSchedule(c, sc)
+	return nil
+}
+
+func execScheduleList(c *cli.Context) error {
+	if c.NArg() != 1 {
+		return errors.New("must specify topic, type 'ntfy schedule list --help' for help")
+	}
+	topic := c.Args().Get(0)
+	cl, options, err := newScheduleClient(c)
+	if err != nil {
+		return err
+	}
+	schedules, err := cl.Schedules(topic, options...)
+	if err != nil {
+		return err
+	}
+	if len(schedules) == 0 {
+		fmt.Fprintf(c.App.ErrWriter, "no recurring messages for topic %s\n", topic)
+		return nil
+	}
+	for _, sc := range schedules {
+		printSchedule(c, sc)
+	}
+	return nil
+}
+
+func execSchedulePause(c *cli.Context) error {
+	topic, id, err := parseScheduleTopicAndID(c, "pause")
+	if err != nil {
+		return err
+	}
+	cl, options, err := newScheduleClient(c)
+	if err != nil {
+		return err
+	}
+	if _, err := cl.PauseSchedule(topic, id, options...); err != nil {
+		return err
+	}
+	fmt.Fprintf(c.App.ErrWriter, "recurring message %s paused\n", id)
+	return nil
+}
+
+func execScheduleResume(c *cli.Context) error {
+	topic, id, err := parseScheduleTopicAndID(c, "resume")
+	if err != nil {
+		return err
+	}
+	cl, options, err := newScheduleClient(c)
+	if err != nil {
+		return err
+	}
+	sc, err := cl.ResumeSchedule(topic, id, options...)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(c.App.ErrWriter, "recurring message %s resumed, next run at %s\n", id, formatScheduleTime(sc.Next))
+	return nil
+}
+
+func execScheduleRemove(c *cli.Context) error {
+	topic, id, err := parseScheduleTopicAndID(c, "remove")
+	if err != nil {
+		return err
+	}
+	cl, options, err := newScheduleClient(c)
+	if err != nil {
+		return err
+	}
+	if err := cl.DeleteSchedule(topic, id, options...); err != nil {
+		return err
+	}
+	fmt.Fprintf(c.App.ErrWriter, "recurring message %s removed\n", id)
+	return nil
+}
+
+func parseScheduleTopicAndID(c *cli.Context, command string) (topic, id string, err error) {
+	if c.NArg() != 2 {
+		return "", "", fmt.Errorf("must specify topic and ID, type 'ntfy schedule %s --help' for help", command)
+	}
+	return c.Args().Get(0), c.Args().Get(1), nil
+}
+
+// newScheduleClient creates a client from the client config, and returns the auth option to use for all requests.
+// The --user and --token flags are defined on the parent command, and take precedence over the config file.
+func newScheduleClient(c *cli.Context) (*client.Client, []client.RequestOption, error) {
+	conf, err := loadConfig(c)
+	if err != nil {
+		return nil, nil, err
+	}
+	options, err := parseAuthOptions(c, conf)
+	if err != nil {
+		return nil, nil, err
+	}
+	return client.New(conf), options, nil
+}
+
+func printSchedule(c *cli.Context, sc *client.Schedule) {
+	next, last := formatScheduleTime(sc.Next), formatScheduleTime(sc.Last)
+	if sc.Paused {
+		next = "(paused)"
+	}
+	fmt.Fprintf(c.App.ErrWriter, "%s (%s)\n", sc.ID, sc.Schedule)
+	if sc.Title != "" {
+		fmt.Fprintf(c.App.ErrWriter, "- Title: %s\n", sc.Title)
+	}
+	fmt.Fprintf(c.App.ErrWriter, "- Message: %s\n", sc.Message)
+	fmt.Fprintf(c.App.ErrWriter, "- Next run: %s\n", next)
+	fmt.Fprintf(c.App.ErrWriter, "- Last run: %s\n", last)
+}
+
+func formatScheduleTime(timestamp int64) string {
+	if timestamp == 0 {
+		return "(never)"
+	}
+	return time.Unix(timestamp, 0).Format(time.RFC1123)
+}
@@ -0,0 +1,61 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/require"
+	"heckel.io/ntfy/v2/test"
+	"regexp"
+	"testing"
+)
+
+func TestCLI_Schedule_Add_List_Pause_Resume_Remove(t *testing.T) {
+	s, port := test.StartServer(t)
+	defer test.StopServer(t, s, port)
+	topic := fmt.Sprintf("http://127.0.0.1:%d/mytopic", port)
+
+	app, _, _, stderr := newTestApp()
+	require.Nil(t, app.Run([]string{"ntfy", "schedule", "add", "--title", "Reminder", topic, "every 2h", "drink", "some", "water"}))
+	require.Contains(t, stderr.String(), "added to topic mytopic")
+	require.Contains(t, stderr.String(), "(every 2h)")
+	require.Contains(t, stderr.String(), "- Title: Reminder")
+	require.Contains(t, stderr.String(), "- Message: drink some water")
+	require.Contains(t, stderr.String(), "- Last run: (never)")
+	id := regexp.MustCompile(`sc_[A-Za-z0-9]{13}`).FindString(stderr.String())
+	require.NotEmpty(t, id)
+
+	app, _, _, stderr = newTestApp()
+	require.Nil(t, app.Run([]string{"ntfy", "schedule", "list", topic}))
+	require.Contains(t, stderr.String(), id+" (every 2h)")
+
+	app, _, _, stderr = newTestApp()
+	require.Nil(t, app.Run([]string{"ntfy", "schedule", "pause", topic, id}))
+	require.Contains(t, stderr.String(), "recurring message "+id+" paused")
+
+	app, _, _, stderr = newTestApp()
+	require.Nil(t, app.Run([]string{"ntfy", "schedule", "list", topic}))
+	require.Contains(t, stderr.String(), "- Next run: (paused)")
+
+	app, _, _, stderr = newTestApp()
+	require.Nil(t, app.Run([]string{"ntfy", "schedule", "resume", topic, id}))
+	require.Contains(t, stderr.String(), "recurring message "+id+" resumed, next run at")
+
+	app, _, _, stderr = newTestApp()
+	require.Nil(t, app.Run([]string{"ntfy", "schedule", "remove", topic, id}))
+	require.Contains(t, stderr.String(), "recurring message "+id+" removed")
+
+	app, _, _, stderr = newTestApp()
+	require.Nil(t, app.Run([]string{"ntfy", "schedule", "list", topic}))
+	require.Contains(t, stderr.String(), "no recurring messages for topic")
+}
+
+func TestCLI_Schedule_Add_Invalid(t *testing.T) {
+	s, port := test.StartServer(t)
+	defer test.StopServer(t, s, port)
+	topic := fmt.Sprintf("http://127.0.0.1:%d/mytopic", port)
+
+	app, _, _, _ := newTestApp()
+	require.Error(t, app.Run([]string{"ntfy", "schedule", "add", topic, "every now and then", "hi"}))
+
+	app, _, _, _ = newTestApp()
+	require.EqualError(t, app.Run([]string{"ntfy", "schedule", "add", topic}), "must specify topic and schedule, type 'ntfy schedule add --help' for help")
+}
@@ -3,6 +3,7 @@
 package cmd
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/stripe/stripe-go/v74"
@@ -43,15 +44,33 @@ var flagsServe = append(
 	altsrc.NewIntFlag(&cli.IntFlag{Name: "listen-unix-mode", Aliases: []string{"listen_unix_mode"}, EnvVars: []string{"NTFY_LISTEN_UNIX_MODE"}, DefaultText: "system default", Usage: "file permissions of unix socket, e.g. 0700"}),
 	altsrc.NewStringFlag(&cli.StringFlag{Name: "key-file", Aliases: []string{"key_file", "K"}, EnvVars: []string{"NTFY_KEY_FILE"}, Usage: "private key file, if listen-https is set"}),
 	altsrc.NewStringFlag(&cli.StringFlag{Name: "cert-file", Aliases: []string{"cert_file", "E"}, EnvVars: []string{"NTFY_CERT_FILE"}, Usage: "certificate file, if listen-https is set"}),
+	altsrc.NewStringFlag(&cli.StringFlag{Name: "client-ca-file", Aliases: []string{"client_ca_file"}, EnvVars: []string{"NTFY_CLIENT_CA_FILE"}, Usage: "CA certificate bundle to verify client certificates against, enables client certificate authentication (mutual TLS)"}),
+	altsrc.NewStringFlag(&cli.StringFlag{Name: "client-cert-username", Aliases: []string{"client_cert_username"}, EnvVars: []string{"NTFY_CLIENT_CERT_USERNAME"}, Value: server.ClientCertUsernameCommonName, Usage: "part of the client certificate that contains the username: cn (subject common name), email or dns (subject alternative name)"}),
 	altsrc.NewStringFlag(&cli.StringFlag{Name: "firebase-key-file", Aliases: []string{"firebase_key_file", "F"}, EnvVars: []string{"NTFY_FIREBASE_KEY_FILE"}, Usage: "Firebase credentials file; if set additionally publish to FCM topic"}),
-	altsrc.NewStringFlag(&cli.StringFlag{Name: "cache-file", Aliases: []string{"cache_file", "C"}, EnvVars: []string{"NTFY_CACHE_FILE"}, Usage: "cache file used for message caching"}),
+	altsrc.NewStringFlag(&cli.StringFlag{Name: "cache-file", Aliases: []string{"cache_file", "C"}, EnvVars: []string{"NTFY_CACHE_FILE"}, Usage: "cache file (or PostgreSQL URL) used for message caching"}),
 	altsrc.NewDurationFlag(&cli.DurationFlag{Name: "cache-duration", Aliases: []string{"cache_duration", "b"}, EnvVars: []string{"NTFY_CACHE_DURATION"}, Value: server.DefaultCacheDuration, Usage: "buffer messages for this time to allow `since` requests"}),
 	altsrc.NewIntFlag(&cli.IntFlag{Name: "cache-batch-size", Aliases: []string{"cache_batch_size"}, EnvVars: []string{"NTFY_BATCH_SIZE"}, Usage: "max size of messages to batch together when writing to message cache (if zero, writes are synchronous)"}),
 	altsrc.NewDurationFlag(&cli.DurationFlag{Name: "cache-batch-timeout", Aliases: []string{"cache_batch_timeout"}, EnvVars: []string{"NTFY_CACHE_BATCH_TIMEOUT"}, Usage: "timeout for batched async writes to the message cache (if zero, writes are synchronous)"}),
 	altsrc.NewStringFlag(&cli.StringFlag{Name: "cache-startup-queries", Aliases: []string{"cache_startup_queries"}, EnvVars: []string{"NTFY_CACHE_STARTUP_QUERIES"}, Usage: "queries run when the cache database is initialized"}),
-	altsrc.NewStringFlag(&cli.StringFlag{Name: "auth-file", Aliases: []string{"auth_file", "H"}, EnvVars: []string{"NTFY_AUTH_FILE"}, Usage: "auth database file used for access control"}),
+	altsrc.NewStringFlag(&cli.StringFlag{Name: "auth-file", Aliases: []string{"auth_file", "H"}, EnvVars: []string{"NTFY_AUTH_FILE"}, Usage: "auth database file (or PostgreSQL URL) used for access control"}),
 	altsrc.NewStringFlag(&cli.StringFlag{Name: "auth-startup-queries", Aliases: []string{"auth_startup_queries"}, EnvVars: []string{"NTFY_AUTH_STARTUP_QUERIES"}, Usage: "queries run when the auth database is initialized"}),
 	altsrc.NewStringFlag(&cli.StringFlag{Name: "auth-default-access", Aliases: []string{"auth_default_access", "p"}, EnvVars: []string{"NTFY_AUTH_DEFAULT_ACCESS"}, Value: "read-write", Usage: "default permissions if no matching entries in the auth database are found"}),
+	altsrc.NewStringFlag(&cli.StringFlag{Name: "auth-ldap-url", Aliases: []string{"auth_ldap_url"}, EnvVars: []string{"NTFY_AUTH_LDAP_URL"}, Usage: "LDAP server URL, enables LDAP authentication, e.g. ldaps://ldap.example.com"}),
+	altsrc.NewStringFlag(&cli.StringFlag{Name: "auth-ldap-bind-dn", Aliases: []string{"auth_ldap_bind_dn"}, EnvVars: []string{"NTFY_AUTH_LDAP_BIND_DN"}, Usage: "DN used to search for users in the directory (anonymous if unset)"}),
+	altsrc.NewStringFlag(&cli.StringFlag{Name: "auth-ldap-bind-password", Aliases: []string{"auth_ldap_bind_password"}, EnvVars: []string{"NTFY_AUTH_LDAP_BIND_PASSWORD"}, Usage: "password of the bind DN"}),
+	altsrc.NewStringFlag(&cli.StringFlag{Name: "auth-ldap-base-dn", Aliases: []string{"auth_ldap_base_dn"}, EnvVars: []string{"NTFY_AUTH_LDAP_BASE_DN"}, Usage: "DN under which users are searched, e.g. ou=people,dc=example,dc=com"}),
+	altsrc.NewStringFlag(&cli.StringFlag{Name: "auth-ldap-user-filter", Aliases: []string{"auth_ldap_user_filter"}, EnvVars: []string{"NTFY_AUTH_LDAP_USER_FILTER"}, Value: user.DefaultLDAPUserFilter, Usage: "LDAP filter to find users, %s is replaced with the username"}),
+	altsrc.NewStringFlag(&cli.StringFlag{Name: "auth-ldap-group-attribute", Aliases: []string{"auth_ldap_group_attribute"}, EnvVars: []string{"NTFY_AUTH_LDAP_GROUP_ATTRIBUTE"}, Value: user.DefaultLDAPGroupAttribute, Usage: "user attribute that lists the user's groups"}),
+	altsrc.NewStringSliceFlag(&cli.StringSliceFlag{Name: "auth-ldap-group-access", Aliases: []string{"auth_ldap_group_access"}, EnvVars: []string{"NTFY_AUTH_LDAP_GROUP_ACCESS"}, Usage: "topic access for members of LDAP groups, as group:topic:permission, e.g. ops:alerts*:rw"}),
+	altsrc.NewDurationFlag(&cli.DurationFlag{Name: "auth-ldap-cache-duration", Aliases: []string{"auth_ldap_cache_duration"}, EnvVars: []string{"NTFY_AUTH_LDAP_CACHE_DURATION"}, Value: user.DefaultLDAPCacheDuration, Usage: "duration for which successful LDAP logins are cached"}),
+	altsrc.NewBoolFlag(&cli.BoolFlag{Name: "auth-require-admin-2fa", Aliases: []string{"auth_require_admin_2fa"}, EnvVars: []string{"NTFY_AUTH_REQUIRE_ADMIN_2FA"}, Value: false, Usage: "requires admin users to set up two-factor authentication before they can log in"}),
+	altsrc.NewIntFlag(&cli.IntFlag{Name: "auth-password-min-length", Aliases: []string{"auth_password_min_length"}, EnvVars: []string{"NTFY_AUTH_PASSWORD_MIN_LENGTH"}, Usage: "minimum length of new passwords"}),
+	altsrc.NewIntFlag(&cli.IntFlag{Name: "auth-password-min-classes", Aliases: []string{"auth_password_min_classes"}, EnvVars: []string{"NTFY_AUTH_PASSWORD_MIN_CLASSES"}, Usage: "minimum number of character classes (lowercase, uppercase, digits, other) in new passwords (0-4)"}),
+	altsrc.NewBoolFlag(&cli.BoolFlag{Name: "auth-password-disallow-username", Aliases: []string{"auth_password_disallow_username"}, EnvVars: []string{"NTFY_AUTH_PASSWORD_DISALLOW_USERNAME"}, Value: false, Usage: "disallows new passwords that contain the username"}),
+	altsrc.NewIntFlag(&cli.IntFlag{Name: "auth-lockout-attempts", Aliases: []string{"auth_lockout_attempts"}, EnvVars: []string{"NTFY_AUTH_LOCKOUT_ATTEMPTS"}, Usage: "number of consecutive failed logins after which a user is locked (0 to disable)"}),
+	altsrc.NewDurationFlag(&cli.DurationFlag{Name: "auth-lockout-duration", Aliases: []string{"auth_lockout_duration"}, EnvVars: []string{"NTFY_AUTH_LOCKOUT_DURATION"}, Value: user.DefaultLockoutDuration, Usage: "duration for which a user is locked after too many failed logins"}),
+	altsrc.NewStringFlag(&cli.StringFlag{Name: "signed-url-secret", Aliases: []string{"signed_url_secret"}, EnvVars: []string{"NTFY_SIGNED_URL_SECRET"}, Usage: "secret used to sign publish URLs, enables signed URLs (at least 32 characters)"}),
+	altsrc.NewDurationFlag(&cli.DurationFlag{Name: "signed-url-max-expiry-duration", Aliases: []string{"signed_url_max_expiry_duration"}, EnvVars: []string{"NTFY_SIGNED_URL_MAX_EXPIRY_DURATION"}, Value: server.DefaultSignedURLMaxExpiryDuration, Usage: "maximum validity of signed URLs created by topic owners"}),
 	altsrc.NewStringFlag(&cli.StringFlag{Name: "attachment-cache-dir", Aliases: []string{"attachment_cache_dir"}, EnvVars: []string{"NTFY_ATTACHMENT_CACHE_DIR"}, Usage: "cache directory for attached files"}),
 	altsrc.NewStringFlag(&cli.StringFlag{Name: "attachment-total-size-limit", Aliases: []string{"attachment_total_size_limit", "A"}, EnvVars: []string{"NTFY_ATTACHMENT_TOTAL_SIZE_LIMIT"}, DefaultText: "5G", Usage: "limit of the on-disk attachment cache"}),
 	altsrc.NewStringFlag(&cli.StringFlag{Name: "attachment-file-size-limit", Aliases: []string{"attachment_file_size_limit", "Y"}, EnvVars: []string{"NTFY_ATTACHMENT_FILE_SIZE_LIMIT"}, DefaultText: "15M", Usage: "per-file attachment size limit (e.g. 300k, 2M, 100M)"}),
@@ -63,6 +82,8 @@ var flagsServe = append(
 	altsrc.NewBoolFlag(&cli.BoolFlag{Name: "enable-signup", Aliases: []string{"enable_signup"}, EnvVars: []string{"NTFY_ENABLE_SIGNUP"}, Value: false, Usage: "allows users to sign up via the web app, or API"}),
 	altsrc.NewBoolFlag(&cli.BoolFlag{Name: "enable-login", Aliases: []string{"enable_login"}, EnvVars: []string{"NTFY_ENABLE_LOGIN"}, Value: false, Usage: "allows users to log in via the web app, or API"}),
 	altsrc.NewBoolFlag(&cli.BoolFlag{Name: "enable-reservations", Aliases: []string{"enable_reservations"}, EnvVars: []string{"NTFY_ENABLE_RESERVATIONS"}, Value: false, Usage: "allows users to reserve topics (if their tier allows it)"}),
+	altsrc.NewBoolFlag(&cli.BoolFlag{Name: "enable-webhooks", Aliases: []string{"enable_webhooks"}, EnvVars: []string{"NTFY_ENABLE_WEBHOOKS"}, Value: false, Usage: "allows topic owners to register outbound webhooks for their reserved topics"}),
+	altsrc.NewStringFlag(&cli.StringFlag{Name: "webhook-allowed-hosts", Aliases: []string{"webhook_allowed_hosts"}, EnvVars: []string{"NTFY_WEBHOOK_ALLOWED_HOSTS"}, Value: "", Usage: "hostnames, IP addresses and/or CIDR ranges of private/internal hosts that webhooks may be delivered to"}),
 	altsrc.NewStringFlag(&cli.StringFlag{Name: "upstream-base-url", Aliases: []string{"upstream_base_url"}, EnvVars: []string{"NTFY_UPSTREAM_BASE_URL"}, Value: "", Usage: "forward poll request to an upstream server, this is needed for iOS push notifications for self-hosted servers"}),
 	altsrc.NewStringFlag(&cli.StringFlag{Name: "upstream-access-token", Aliases: []string{"upstream_access_token"}, EnvVars: []string{"NTFY_UPSTREAM_ACCESS_TOKEN"}, Value: "", Usage: "access token to use for the upstream server; needed only if upstream rate limits are exceeded or upstream server requires auth"}),
 	altsrc.NewStringFlag(&cli.StringFlag{Name: "smtp-sender-addr", Aliases: []string{"smtp_sender_addr"}, EnvVars: []string{"NTFY_SMTP_SENDER_ADDR"}, Usage: "SMTP server address (host:port) for outgoing emails"}),
@@ -72,12 +93,23 @@ var flagsServe = append(
 	altsrc.NewStringFlag(&cli.StringFlag{Name: "smtp-server-listen", Aliases: []string{"smtp_server_listen"}, EnvVars: []string{"NTFY_SMTP_SERVER_LISTEN"}, Usage: "SMTP server address (ip:port) for incoming emails, e.g. :25"}),
 	altsrc.NewStringFlag(&cli.StringFlag{Name: "smtp-server-domain", Aliases: []string{"smtp_server_domain"}, EnvVars: []string{"NTFY_SMTP_SERVER_DOMAIN"}, Usage: "SMTP domain for incoming e-mail, e.g. ntfy.sh"}),
 	altsrc.NewStringFlag(&cli.StringFlag{Name: "smtp-server-addr-prefix", Aliases: []string{"smtp_server_addr_prefix"}, EnvVars: []string{"NTFY_SMTP_SERVER_ADDR_PREFIX"}, Usage: "SMTP email address prefix for topics to prevent spam (e.g. 'ntfy-')"}),
+	altsrc.NewStringFlag(&cli.StringFlag{Name: "mqtt-server-listen", Aliases: []string{"mqtt_server_listen"}, EnvVars: []string{"NTFY_MQTT_SERVER_LISTEN"}, Usage: "MQTT server address (ip:port) for publishing and subscribing via MQTT, e.g. :1883"}),
+	altsrc.NewStringSliceFlag(&cli.StringSliceFlag{Name: "gotify-apps", Aliases: []string{"gotify_apps"}, EnvVars: []string{"NTFY_GOTIFY_APPS"}, Usage: "Gotify application tokens, their topics and ntfy users, as token:topic:user, e.g. AbCdEf123:backups:phil; enables the Gotify API"}),
+	altsrc.NewStringSliceFlag(&cli.StringSliceFlag{Name: "message-templates", Aliases: []string{"message_templates"}, Usage: "named message templates (name, title, message), used with ?template=<name>; can only be set in the config file"}),
 	altsrc.NewStringFlag(&cli.StringFlag{Name: "twilio-account", Aliases: []string{"twilio_account"}, EnvVars: []string{"NTFY_TWILIO_ACCOUNT"}, Usage: "Twilio account SID, used for phone calls, e.g. AC123..."}),
 	altsrc.NewStringFlag(&cli.StringFlag{Name: "twilio-auth-token", Aliases: []string{"twilio_auth_token"}, EnvVars: []string{"NTFY_TWILIO_AUTH_TOKEN"}, Usage: "Twilio auth token"}),
 	altsrc.NewStringFlag(&cli.StringFlag{Name: "twilio-phone-number", Aliases: []string{"twilio_phone_number"}, EnvVars: []string{"NTFY_TWILIO_PHONE_NUMBER"}, Usage: "Twilio number to use for outgoing calls"}),
 	altsrc.NewStringFlag(&cli.StringFlag{Name: "twilio-verify-service", Aliases: []string{"twilio_verify_service"}, EnvVars: []string{"NTFY_TWILIO_VERIFY_SERVICE"}, Usage: "Twilio Verify service ID, used for phone number verification"}),
+	altsrc.NewStringFlag(&cli.StringFlag{Name: "oidc-issuer", Aliases: []string{"oidc_issuer"}, EnvVars: []string{"NTFY_OIDC_ISSUER"}, Usage: "OpenID Connect issuer URL, enables login via SSO, e.g. https://auth.example.com/realms/main"}),
+	altsrc.NewStringFlag(&cli.StringFlag{Name: "oidc-client-id", Aliases: []string{"oidc_client_id"}, EnvVars: []string{"NTFY_OIDC_CLIENT_ID"}, Usage: "OpenID Connect client ID"}),
+	altsrc.NewStringFlag(&cli.StringFlag{Name: "oidc-client-secret", Aliases: []string{"oidc_client_secret"}, EnvVars: []string{"NTFY_OIDC_CLIENT_SECRET"}, Usage: "OpenID Connect client secret"}),
+	altsrc.NewStringFlag(&cli.StringFlag{Name: "oidc-username-claim", Aliases: []string{"oidc_username_claim"}, EnvVars: []string{"NTFY_OIDC_USERNAME_CLAIM"}, Value: server.DefaultOIDCUsernameClaim, Usage: "ID token claim used as username"}),
+	altsrc.NewStringFlag(&cli.StringFlag{Name: "oidc-groups-claim", Aliases: []string{"oidc_groups_claim"}, EnvVars: []string{"NTFY_OIDC_GROUPS_CLAIM"}, Value: server.DefaultOIDCGroupsClaim, Usage: "ID token claim that contains the user's groups"}),
+	altsrc.NewStringSliceFlag(&cli.StringSliceFlag{Name: "oidc-admin-groups", Aliases: []string{"oidc_admin_groups"}, EnvVars: []string{"NTFY_OIDC_ADMIN_GROUPS"}, Usage: "groups whose members are given the admin role"}),
+	altsrc.NewStringSliceFlag(&cli.StringSliceFlag{Name: "oidc-tier-groups", Aliases: []string{"oidc_tier_groups"}, EnvVars: []string{"NTFY_OIDC_TIER_GROUPS"}, Usage: "groups whose members are given a tier, as group:tier, e.g. ntfy-pro:pro"}),
 	altsrc.NewIntFlag(&cli.IntFlag{Name: "global-topic-limit", Aliases: []string{"global_topic_limit", "T"}, EnvVars: []string{"NTFY_GLOBAL_TOPIC_LIMIT"}, Value: server.DefaultTotalTopicLimit, Usage: "total number of topics allowed"}),
 	altsrc.NewIntFlag(&cli.IntFlag{Name: "visitor-subscription-limit", Aliases: []string{"visitor_subscription_limit"}, EnvVars: []string{"NTFY_VISITOR_SUBSCRIPTION_LIMIT"}, Value: server.DefaultVisitorSubscriptionLimit, Usage: "number of subscriptions per visitor"}),
+	altsrc.NewIntFlag(&cli.IntFlag{Name: "visitor-schedule-limit", Aliases: []string{"visitor_schedule_limit"}, EnvVars: []string{"NTFY_VISITOR_SCHEDULE_LIMIT"}, Value: server.DefaultVisitorScheduleLimit, Usage: "number of recurring messages per visitor"}),
 	altsrc.NewStringFlag(&cli.StringFlag{Name: "visitor-attachment-total-size-limit", Aliases: []string{"visitor_attachment_total_size_limit"}, EnvVars: []string{"NTFY_VISITOR_ATTACHMENT_TOTAL_SIZE_LIMIT"}, Value: "100M", Usage: "total storage limit used for attachments per visitor"}),
 	altsrc.NewStringFlag(&cli.StringFlag{Name: "visitor-attachment-daily-bandwidth-limit", Aliases: []string{"visitor_attachment_daily_bandwidth_limit"}, EnvVars: []string{"NTFY_VISITOR_ATTACHMENT_DAILY_BANDWIDTH_LIMIT"}, Value: "500M", Usage: "total daily attachment download/upload bandwidth limit per visitor"}),
 	altsrc.NewIntFlag(&cli.IntFlag{Name: "visitor-request-limit-burst", Aliases: []string{"visitor_request_limit_burst"}, EnvVars: []string{"NTFY_VISITOR_REQUEST_LIMIT_BURST"}, Value: server.DefaultVisitorRequestLimitBurst, Usage: "initial limit of requests per visitor"}),
@@ -133,6 +165,8 @@ func execServe(c *cli.Context) error {
 	listenUnixMode := c.Int("listen-unix-mode")
 	keyFile := c.String("key-file")
 	certFile := c.String("cert-file")
+	clientCAFile := c.String("client-ca-file")
+	clientCertUsername := c.String("client-cert-username")
 	firebaseKeyFile := c.String("firebase-key-file")
 	webPushPrivateKey := c.String("web-push-private-key")
 	webPushPublicKey := c.String("web-push-public-key")
@@ -147,6 +181,22 @@ func execServe(c *cli.Context) error {
 	authFile := c.String("auth-file")
 	authStartupQueries := c.String("auth-startup-queries")
 	authDefaultAccess := c.String("auth-default-access")
+	authLDAPURL := c.String("auth-ldap-url")
+	authLDAPBindDN := c.String("auth-ldap-bind-dn")
+	authLDAPBindPassword := c.String("auth-ldap-bind-password")
+	authLDAPBaseDN := c.String("auth-ldap-base-dn")
+	authLDAPUserFilter := c.String("auth-ldap-user-filter")
+	authLDAPGroupAttribute := c.String("auth-ldap-group-attribute")
+	authLDAPGroupAccessList := c.StringSlice("auth-ldap-group-access")
+	authLDAPCacheDuration := c.Duration("auth-ldap-cache-duration")
+	authRequireAdminTwoFactor := c.Bool("auth-require-admin-2fa")
+	authPasswordMinLength := c.Int("auth-password-min-length")
+	authPasswordMinClasses := c.Int("auth-password-min-classes")
+	authPasswordDisallowUsername := c.Bool("auth-password-disallow-username")
+	authLockoutAttempts := c.Int("auth-lockout-attempts")
+	authLockoutDuration := c.Duration("auth-lockout-duration")
+	signedURLSecret := c.String("signed-url-secret")
+	signedURLMaxExpiryDuration := c.Duration("signed-url-max-expiry-duration")
 	attachmentCacheDir := c.String("attachment-cache-dir")
 	attachmentTotalSizeLimitStr := c.String("attachment-total-size-limit")
 	attachmentFileSizeLimitStr := c.String("attachment-file-size-limit")
@@ -158,6 +208,8 @@ func execServe(c *cli.Context) error {
 	enableSignup := c.Bool("enable-signup")
 	enableLogin := c.Bool("enable-login")
 	enableReservations := c.Bool("enable-reservations")
+	enableWebhooks := c.Bool("enable-webhooks")
+	webhookAllowedHosts := util.SplitNoEmpty(c.String("webhook-allowed-hosts"), ",")
 	upstreamBaseURL := c.String("upstream-base-url")
 	upstreamAccessToken := c.String("upstream-access-token")
 	smtpSenderAddr := c.String("smtp-sender-addr")
@@ -167,12 +219,23 @@ func execServe(c *cli.Context) error {
 	smtpServerListen := c.String("smtp-server-listen")
 	smtpServerDomain := c.String("smtp-server-domain")
 	smtpServerAddrPrefix := c.String("smtp-server-addr-prefix")
+	mqttServerListen := c.String("mqtt-server-listen")
+	gotifyAppsList := c.StringSlice("gotify-apps")
+	messageTemplatesList := c.StringSlice("message-templates")
 	twilioAccount := c.String("twilio-account")
 	twilioAuthToken := c.String("twilio-auth-token")
 	twilioPhoneNumber := c.String("twilio-phone-number")
 	twilioVerifyService := c.String("twilio-verify-service")
+	oidcIssuer := c.String("oidc-issuer")
+	oidcClientID := c.String("oidc-client-id")
+	oidcClientSecret := c.String("oidc-client-secret")
+	oidcUsernameClaim := c.String("oidc-username-claim")
+	oidcGroupsClaim := c.String("oidc-groups-claim")
+	oidcAdminGroups := c.StringSlice("oidc-admin-groups")
+	oidcTierGroupsList := c.StringSlice("oidc-tier-groups")
 	totalTopicLimit := c.Int("global-topic-limit")
 	visitorSubscriptionLimit := c.Int("visitor-subscription-limit")
+	visitorScheduleLimit := c.Int("visitor-schedule-limit")
 	visitorSubscriberRateLimiting := c.Bool("visitor-subscriber-rate-limiting")
 	visitorAttachmentTotalSizeLimitStr := c.String("visitor-attachment-total-size-limit")
 	visitorAttachmentDailyBandwidthLimitStr := c.String("visitor-attachment-daily-bandwidth-limit")
@@ -207,6 +270,12 @@ func execServe(c *cli.Context) error {
 		return errors.New("if set, certificate file must exist")
 	} else if listenHTTPS != "" && (keyFile == "" || certFile == "") {
 		return errors.New("if listen-https is set, both key-file and cert-file must be set")
+	} else if clientCAFile != "" && !util.FileExists(clientCAFile) {
+		return errors.New("if set, client CA file must exist")
+	} else if clientCAFile != "" && (listenHTTPS == "" || authFile == "") {
+		return errors.New("if client-ca-file is set, listen-https and auth-file must also be set")
+	} else if !util.Contains([]string{server.ClientCertUsernameCommonName, server.ClientCertUsernameEmail, server.ClientCertUsernameDNS}, clientCertUsername) {
+		return errors.New("if set, client-cert-username must be 'cn', 'email' or 'dns'")
 	} else if smtpSenderAddr != "" && (baseURL == "" || smtpSenderFrom == "") {
 		return errors.New("if smtp-sender-addr is set, base-url, and smtp-sender-from must also be set")
 	} else if smtpServerListen != "" && smtpServerDomain == "" {
@@ -233,6 +302,30 @@ func execServe(c *cli.Context) error {
 		return errors.New("if stripe-secret-key is set, stripe-webhook-key and base-url must also be set")
 	} else if twilioAccount != "" && (twilioAuthToken == "" || twilioPhoneNumber == "" || twilioVerifyService == "" || baseURL == "" || authFile == "") {
 		return errors.New("if twilio-account is set, twilio-auth-token, twilio-phone-number, twilio-verify-service, base-url, and auth-file must also be set")
+	} else if authLDAPURL != "" && (authFile == "" || authLDAPBaseDN == "") {
+		return errors.New("if auth-ldap-url is set, auth-ldap-base-dn and auth-file must also be set")
+	} else if authLDAPURL != "" && !strings.HasPrefix(authLDAPURL, "ldap://") && !strings.HasPrefix(authLDAPURL, "ldaps://") {
+		return errors.New("if set, auth-ldap-url must start with ldap:// or ldaps://")
+	} else if authLDAPURL != "" && strings.Count(authLDAPUserFilter, "%s") != 1 {
+		return errors.New("auth-ldap-user-filter must contain exactly one %s placeholder for the username")
+	} else if authRequireAdminTwoFactor && authFile == "" {
+		return errors.New("if auth-require-admin-2fa is set, auth-file must also be set")
+	} else if authPasswordMinLength < 0 || authPasswordMinClasses < 0 || authPasswordMinClasses > 4 {
+		return errors.New("auth-password-min-length must be 0 or greater, and auth-password-min-classes must be between 0 and 4")
+	} else if (authPasswordMinLength > 0 || authPasswordMinClasses > 0 || authPasswordDisallowUsername) && authFile == "" {
+		return errors.New("if auth-password-min-length, auth-password-min-classes or auth-password-disallow-username is set, auth-file must also be set")
+	} else if authLockoutAttempts < 0 || (authLockoutAttempts > 0 && (authFile == "" || authLockoutDuration <= 0)) {
+		return errors.New("if auth-lockout-attempts is set, auth-file and a positive auth-lockout-duration must also be set")
+	} else if signedURLSecret != "" && (baseURL == "" || authFile == "" || len(signedURLSecret) < server.MinSignedURLSecretLength) {
+		return fmt.Errorf("if signed-url-secret is set, base-url and auth-file must also be set, and the secret must be at least %d characters long", server.MinSignedURLSecretLength)
+	} else if signedURLMaxExpiryDuration <= 0 {
+		return errors.New("signed-url-max-expiry-duration must be positive")
+	} else if oidcIssuer != "" && (oidcClientID == "" || baseURL == "" || authFile == "" || !enableLogin) {
+		return errors.New("if oidc-issuer is set, oidc-client-id, base-url, auth-file, and enable-login must also be set")
+	} else if oidcIssuer != "" && !strings.HasPrefix(oidcIssuer, "https://") && !strings.HasPrefix(oidcIssuer, "http://") {
+		return errors.New("if set, oidc-issuer must start with http:// or https://")
+	} else if enableWebhooks && authFile == "" {
+		return errors.New("if enable-webhooks is set, auth-file must also be set")
 	}
 
 	// Backwards compatibility
@@ -287,6 +380,46 @@ func execServe(c *cli.Context) error {
 		}
 		visitorRequestLimitExemptIPs = append(visitorRequestLimitExemptIPs, ips...)
 	}
+	webhookAllowedIPs := make([]netip.Prefix, 0)
+	for _, host := range webhookAllowedHosts {
+		ips, err := parseIPHostPrefix(host)
+		if err != nil {
+			log.Warn("cannot resolve host %s: %s, ignoring webhook allowed host", host, err.Error())
+			continue
+		}
+		webhookAllowedIPs = append(webhookAllowedIPs, ips...)
+	}
+
+	// Parse LDAP group access entries
+	authLDAPGroupAccess, err := parseLDAPGroupAccess(authLDAPGroupAccessList)
+	if err != nil {
+		return err
+	}
+
+	// Parse OIDC group to tier mapping
+	oidcTierGroups, err := parseOIDCTierGroups(oidcTierGroupsList)
+	if err != nil {
+		return err
+	}
+
+	// Parse Gotify application tokens
+	gotifyApps, err := parseGotifyApps(gotifyAppsList)
+	if err != nil {
+		return err
+	}
+	for token, app := range gotifyApps {
+		if authFile != "" && app.User == "" && !user.IsToken(token) {
+			return fmt.Errorf("gotify-apps entry for topic %s must be mapped to a user or access token as token:topic:user, since auth-file is set", app.Topic)
+		} else if authFile == "" && app.User != "" {
+			return fmt.Errorf("gotify-apps entry for topic %s is mapped to a user, but auth-file is not set", app.Topic)
+		}
+	}
+
+	// Parse message templates
+	messageTemplates, err := parseMessageTemplates(messageTemplatesList)
+	if err != nil {
+		return err
+	}
 
 	// Stripe things
 	if stripeSecretKey != "" {
@@ -296,6 +429,9 @@ func execServe(c *cli.Context) error {
 
 	// Add default forbidden topics
 	disallowedTopics = append(disallowedTopics, server.DefaultDisallowedTopics...)
+	if len(gotifyApps) > 0 {
+		disallowedTopics = append(disallowedTopics, server.GotifyDisallowedTopics...)
+	}
 
 	// Run server
 	conf := server.NewConfig()
@@ -307,6 +443,8 @@ func execServe(c *cli.Context) error {
 	conf.ListenUnixMode = fs.FileMode(listenUnixMode)
 	conf.KeyFile = keyFile
 	conf.CertFile = certFile
+	conf.ClientCAFile = clientCAFile
+	conf.ClientCertUsername = clientCertUsername
 	conf.FirebaseKeyFile = firebaseKeyFile
 	conf.CacheFile = cacheFile
 	conf.CacheDuration = cacheDuration
@@ -316,6 +454,22 @@ func execServe(c *cli.Context) error {
 	conf.AuthFile = authFile
 	conf.AuthStartupQueries = authStartupQueries
 	conf.AuthDefault = authDefault
+	conf.AuthLDAPURL = authLDAPURL
+	conf.AuthLDAPBindDN = authLDAPBindDN
+	conf.AuthLDAPBindPassword = authLDAPBindPassword
+	conf.AuthLDAPBaseDN = authLDAPBaseDN
+	conf.AuthLDAPUserFilter = authLDAPUserFilter
+	conf.AuthLDAPGroupAttribute = authLDAPGroupAttribute
+	conf.AuthLDAPGroupAccess = authLDAPGroupAccess
+	conf.AuthLDAPCacheDuration = authLDAPCacheDuration
+	conf.AuthRequireAdminTwoFactor = authRequireAdminTwoFactor
+	conf.AuthPasswordMinLength = authPasswordMinLength
+	conf.AuthPasswordMinClasses = authPasswordMinClasses
+	conf.AuthPasswordDisallowUsername = authPasswordDisallowUsername
+	conf.AuthLockoutAttempts = authLockoutAttempts
+	conf.AuthLockoutDuration = authLockoutDuration
+	conf.SignedURLSecret = signedURLSecret
+	conf.SignedURLMaxExpiryDuration = signedURLMaxExpiryDuration
 	conf.AttachmentCacheDir = attachmentCacheDir
 	conf.AttachmentTotalSizeLimit = attachmentTotalSizeLimit
 	conf.AttachmentFileSizeLimit = attachmentFileSizeLimit
@@ -333,12 +487,23 @@ func execServe(c *cli.Context) error {
 	conf.SMTPServerListen = smtpServerListen
 	conf.SMTPServerDomain = smtpServerDomain
 	conf.SMTPServerAddrPrefix = smtpServerAddrPrefix
+	conf.MQTTServerListen = mqttServerListen
+	conf.GotifyApps = gotifyApps
+	conf.MessageTemplates = messageTemplates
 	conf.TwilioAccount = twilioAccount
 	conf.TwilioAuthToken = twilioAuthToken
 	conf.TwilioPhoneNumber = twilioPhoneNumber
 	conf.TwilioVerifyService = twilioVerifyService
+	conf.OIDCIssuer = oidcIssuer
+	conf.OIDCClientID = oidcClientID
+	conf.OIDCClientSecret = oidcClientSecret
+	conf.OIDCUsernameClaim = oidcUsernameClaim
+	conf.OIDCGroupsClaim = oidcGroupsClaim
+	conf.OIDCAdminGroups = oidcAdminGroups
+	conf.OIDCTierGroups = oidcTierGroups
 	conf.TotalTopicLimit = totalTopicLimit
 	conf.VisitorSubscriptionLimit = visitorSubscriptionLimit
+	conf.VisitorScheduleLimit = visitorScheduleLimit
 	conf.VisitorAttachmentTotalSizeLimit = visitorAttachmentTotalSizeLimit
 	conf.VisitorAttachmentDailyBandwidthLimit = visitorAttachmentDailyBandwidthLimit
 	conf.VisitorRequestLimitBurst = visitorRequestLimitBurst
@@ -355,6 +520,8 @@ func execServe(c *cli.Context) error {
 	conf.EnableSignup = enableSignup
 	conf.EnableLogin = enableLogin
 	conf.EnableReservations = enableReservations
+	conf.EnableWebhooks = enableWebhooks
+	conf.WebhookAllowedIPAddrs = webhookAllowedIPs
 	conf.EnableMetrics = enableMetrics
 	conf.MetricsListenHTTP = metricsListenHTTP
 	conf.ProfileListenHTTP = profileListenHTTP
@@ -390,6 +557,86 @@ func parseSize(s string, defaultValue int64) (v int64, err error) {
 	return v, nil
 }
 
+// parseLDAPGroupAccess parses a list of group:topic:permission entries. Since group DNs may contain colons,
+// the topic pattern and permission are the last two fields.
+func parseLDAPGroupAccess(entries []string) ([]*user.LDAPGroupAccess, error) {
+	groupAccess := make([]*user.LDAPGroupAccess, 0)
+	for _, entry := range entries {
+		fields := strings.Split(entry, ":")
+		if len(fields) < 3 {
+			return nil, fmt.Errorf("invalid auth-ldap-group-access entry %s, must be group:topic:permission", entry)
+		}
+		group := strings.Join(fields[:len(fields)-2], ":")
+		topicPattern, permissionStr := fields[len(fields)-2], fields[len(fields)-1]
+		permission, err := user.ParsePermission(permissionStr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid permission %s in auth-ldap-group-access entry %s", permissionStr, entry)
+		} else if group == "" || !user.AllowedTopicPattern(topicPattern) {
+			return nil, fmt.Errorf("invalid auth-ldap-group-access entry %s, must be group:topic:permission", entry)
+		}
+		groupAccess = append(groupAccess, &user.LDAPGroupAccess{
+			Group:        group,
+			TopicPattern: topicPattern,
+			Permission:   permission,
+		})
+	}
+	return groupAccess, nil
+}
+
+// parseOIDCTierGroups parses a list of group:tier mappings. Since group names may contain colons, the
+// tier code is everything after the last colon.
+func parseOIDCTierGroups(mappings []string) (map[string]string, error) {
+	tierGroups := make(map[string]string)
+	for _, mapping := range mappings {
+		i := strings.LastIndex(mapping, ":")
+		if i <= 0 || i == len(mapping)-1 {
+			return nil, fmt.Errorf("invalid oidc-tier-groups entry %s, must be group:tier", mapping)
+		}
+		tierGroups[mapping[:i]] = mapping[i+1:]
+	}
+	return tierGroups, nil
+}
+
+// parseGotifyApps parses a list of token:topic[:user] mappings for the Gotify API. The user may be a username
+// or an ntfy access token.
+func parseGotifyApps(mappings []string) (map[string]*server.GotifyApp, error) {
+	apps := make(map[string]*server.GotifyApp)
+	for _, mapping := range mappings {
+		parts := strings.SplitN(mapping, ":", 3)
+		if len(parts) < 2 || parts[0] == "" || !user.AllowedTopic(parts[1]) || util.Contains(server.GotifyDisallowedTopics, parts[1]) {
+			return nil, fmt.Errorf("invalid gotify-apps entry %s, must be token:topic[:user]", mapping)
+		}
+		app := &server.GotifyApp{Topic: parts[1]}
+		if len(parts) == 3 {
+			if !user.IsToken(parts[2]) && !user.AllowedUsername(parts[2]) {
+				return nil, fmt.Errorf("invalid gotify-apps entry %s, user must be a valid username or access token", mapping)
+			}
+			app.User = parts[2]
+		}
+		apps[parts[0]] = app
+	}
+	return apps, nil
+}
+
+// parseMessageTemplates parses a list of message templates. Each entry is a JSON object with a name, and a title
+// and message template. In the config file, entries are YAML objects, which are converted to JSON when the
+// file is loaded, see newYamlSourceFromFile.
+func parseMessageTemplates(entries []string) (map[string]*server.MessageTemplate, error) {
+	templates := make(map[string]*server.MessageTemplate)
+	for _, entry := range entries {
+		var tpl server.MessageTemplate
+		if err := json.Unmarshal([]byte(entry), &tpl); err != nil {
+			return nil, fmt.Errorf("invalid message-templates entry %s, must be a JSON object: %s", entry, err.Error())
+		} else if err := tpl.Validate(); err != nil {
+			return nil, fmt.Errorf("invalid message-templates entry %s: %s", entry, err.Error())
+		} else if _, exists := templates[tpl.Name]; exists {
+			return nil, fmt.Errorf("duplicate message-templates entry %s", tpl.Name)
+		}
+		templates[tpl.Name] = &tpl
+	}
+	return templates, nil
+}
+
 func sigHandlerConfigReload(config string) {
 	sigs := make(chan os.Signal, 1)
 	signal.Notify(sigs, syscall.SIGHUP)
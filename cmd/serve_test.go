@@ -13,7 +13,9 @@ import (
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"heckel.io/ntfy/v2/client"
+	"heckel.io/ntfy/v2/server"
 	"heckel.io/ntfy/v2/test"
+	"heckel.io/ntfy/v2/user"
 	"heckel.io/ntfy/v2/util"
 )
 
@@ -84,6 +86,74 @@ func TestIP_Host_Parsing(t *testing.T) {
 	}
 }
 
+func TestOIDC_TierGroups_Parsing(t *testing.T) {
+	tierGroups, err := parseOIDCTierGroups([]string{"ntfy-pro:pro", "urn:example:group:business"})
+	require.Nil(t, err)
+	assert.Equal(t, map[string]string{"ntfy-pro": "pro", "urn:example:group": "business"}, tierGroups)
+
+	for _, mapping := range []string{"pro", ":pro", "ntfy-pro:"} {
+		_, err := parseOIDCTierGroups([]string{mapping})
+		require.Error(t, err)
+	}
+}
+
+func TestLDAP_GroupAccess_Parsing(t *testing.T) {
+	groupAccess, err := parseLDAPGroupAccess([]string{"ops:alerts*:rw", "cn=dev,ou=groups,dc=example,dc=com:builds:ro"})
+	require.Nil(t, err)
+	require.Equal(t, 2, len(groupAccess))
+	assert.Equal(t, "ops", groupAccess[0].Group)
+	assert.Equal(t, "alerts*", groupAccess[0].TopicPattern)
+	assert.Equal(t, user.PermissionReadWrite, groupAccess[0].Permission)
+	assert.Equal(t, "cn=dev,ou=groups,dc=example,dc=com", groupAccess[1].Group)
+	assert.Equal(t, "builds", groupAccess[1].TopicPattern)
+	assert.Equal(t, user.PermissionRead, groupAccess[1].Permission)
+
+	for _, entry := range []string{"ops:alerts", ":alerts:rw", "ops:alerts:invalid", "ops:al/erts:rw"} {
+		_, err := parseLDAPGroupAccess([]string{entry})
+		require.Error(t, err)
+	}
+}
+
+func TestGotify_Apps_Parsing(t *testing.T) {
+	apps, err := parseGotifyApps([]string{"AbCdEf123:backups", "tk_abc:alerts", "GhIjKl456:alerts:phil", "MnOpQr789:alerts:tk_AgQdq7mVBoFD37zQVN29RhuMzNIz2"})
+	require.Nil(t, err)
+	assert.Equal(t, map[string]*server.GotifyApp{
+		"AbCdEf123": {Topic: "backups"},
+		"tk_abc":    {Topic: "alerts"},
+		"GhIjKl456": {Topic: "alerts", User: "phil"},
+		"MnOpQr789": {Topic: "alerts", User: "tk_AgQdq7mVBoFD37zQVN29RhuMzNIz2"},
+	}, apps)
+
+	for _, mapping := range []string{"AbCdEf123", ":backups", "AbCdEf123:", "AbCdEf123:back/ups", "AbCdEf123:message", "AbCdEf123:stream", "AbCdEf123:backups:", "AbCdEf123:backups:ph/il"} {
+		_, err := parseGotifyApps([]string{mapping})
+		require.Error(t, err)
+	}
+}
+
+func TestMessageTemplates_Parsing(t *testing.T) {
+	templates, err := parseMessageTemplates([]string{
+		`{"name":"github","title":"{{.repository.full_name}}","message":"{{.sender.login}} starred the repo"}`,
+		`{"name":"sentry","message":"{{.message}}"}`,
+	})
+	require.Nil(t, err)
+	require.Len(t, templates, 2)
+	assert.Equal(t, "{{.repository.full_name}}", templates["github"].Title)
+	assert.Equal(t, "{{.message}}", templates["sentry"].Message)
+
+	for _, entry := range []string{
+		`not json`,
+		`{"message":"no name"}`,
+		`{"name":"yes","message":"boolean name"}`,
+		`{"name":"empty"}`,
+		`{"name":"broken","message":"{{.message"}`,
+	} {
+		_, err := parseMessageTemplates([]string{entry})
+		require.Error(t, err)
+	}
+	_, err = parseMessageTemplates([]string{`{"name":"a","message":"1"}`, `{"name":"a","message":"2"}`})
+	require.Error(t, err)
+}
+
 func newEmptyFile(t *testing.T) string {
 	filename := filepath.Join(t.TempDir(), "empty")
 	require.Nil(t, os.WriteFile(filename, []byte{}, 0600))
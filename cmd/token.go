@@ -6,9 +6,13 @@ import (
 	"errors"
 	"fmt"
 	"github.com/urfave/cli/v2"
+	"github.com/urfave/cli/v2/altsrc"
+	"heckel.io/ntfy/v2/server"
 	"heckel.io/ntfy/v2/user"
 	"heckel.io/ntfy/v2/util"
 	"net/netip"
+	"net/url"
+	"strings"
 	"time"
 )
 
@@ -16,12 +20,16 @@ func init() {
 	commands = append(commands, cmdToken)
 }
 
-var flagsToken = append([]cli.Flag{}, flagsUser...)
+var flagsToken = append(
+	append([]cli.Flag{}, flagsUser...),
+	altsrc.NewStringFlag(&cli.StringFlag{Name: "base-url", Aliases: []string{"base_url", "B"}, EnvVars: []string{"NTFY_BASE_URL"}, Usage: "externally visible base URL for this host (e.g. https://ntfy.sh)"}),
+	altsrc.NewStringFlag(&cli.StringFlag{Name: "signed-url-secret", Aliases: []string{"signed_url_secret"}, EnvVars: []string{"NTFY_SIGNED_URL_SECRET"}, Usage: "secret used to sign publish URLs"}),
+)
 
 var cmdToken = &cli.Command{
 	Name:      "token",
 	Usage:     "Create, list or delete user tokens",
-	UsageText: "ntfy token [list|add|remove] ...",
+	UsageText: "ntfy token [list|add|remove|sign-url|revoke-urls] ...",
 	Flags:     flagsToken,
 	Before:    initConfigFileInputSourceFunc("config", flagsToken, initLogFunc),
 	Category:  categoryServer,
@@ -30,17 +38,25 @@ var cmdToken = &cli.Command{
 			Name:      "add",
 			Aliases:   []string{"a"},
 			Usage:     "Create a new token",
-			UsageText: "ntfy token add [--expires=<duration>] [--label=..] USERNAME",
+			UsageText: "ntfy token add [--expires=<duration>] [--label=..] [--topic=.. [--perm=..]] USERNAME",
 			Action:    execTokenAdd,
 			Flags: []cli.Flag{
 				&cli.StringFlag{Name: "expires", Aliases: []string{"e"}, Value: "", Usage: "token expires after"},
 				&cli.StringFlag{Name: "label", Aliases: []string{"l"}, Value: "", Usage: "token label"},
+				&cli.StringSliceFlag{Name: "topic", Aliases: []string{"t"}, Usage: "restrict token to topic or topic pattern (may be repeated)"},
+				&cli.StringSliceFlag{Name: "perm", Aliases: []string{"p"}, Usage: "permission for the topic(s), one of read-write, read-only or write-only (may be repeated)"},
 			},
 			Description: `Create a new user access token.
 
 User access tokens can be used to publish, subscribe, or perform any other user-specific tasks.
-Tokens have full access, and can perform any task a user can do. They are meant to be used to 
-avoid spreading the password to various places.
+Unless restricted via --topic, tokens have full access, and can perform any task a user can do. 
+They are meant to be used to avoid spreading the password to various places.
+
+Scoped tokens: If one or more --topic flags are passed, the token can only be used to publish to
+and subscribe to the given topics or topic patterns (e.g. "ci-*"), and only with the given
+permission (--perm). If a single --perm is passed, it applies to all topics; otherwise --perm must
+be passed once per --topic. The default permission is read-write. Scoped tokens can never grant 
+more than the user itself is allowed to do, and cannot be used to manage the user's account.
 
 This is a server-only command. It directly reads from user.db as defined in the server config
 file server.yml. The command only works if 'auth-file' is properly defined.
@@ -49,7 +65,8 @@ Examples:
   ntfy token add phil                   # Create token for user phil which never expires
   ntfy token add --expires=2d phil      # Create token for user phil which expires in 2 days
   ntfy token add -e "tuesday, 8pm" phil # Create token for user phil which expires next Tuesday
-  ntfy token add -l backups phil        # Create token for user phil with label "backups"`,
+  ntfy token add -l backups phil        # Create token for user phil with label "backups"
+  ntfy token add -t "ci-*" -p wo phil   # Create token for user phil that can only publish to topics "ci-..."`,
 		},
 		{
 			Name:      "remove",
@@ -61,6 +78,49 @@ Examples:
 
 Example:
   ntfy token del phil tk_th2srHVlxrANQHAso5t0HuQ1J1TjN`,
+		},
+		{
+			Name:      "sign-url",
+			Usage:     "Create a signed publish URL for a topic",
+			UsageText: "ntfy token sign-url [--expires=<duration>] TOPIC [PARAM=VALUE...]",
+			Action:    execTokenSignURL,
+			Flags: []cli.Flag{
+				&cli.StringFlag{Name: "expires", Aliases: []string{"e"}, Value: "30d", Usage: "URL expires after"},
+			},
+			Description: `Create a signed, expiring publish URL for a topic.
+
+Signed URLs can be used to publish to a topic without a user account or access token, e.g. for
+webhooks from third-party systems that cannot set headers. Messages can be published to the URL
+via GET, PUT or POST. The URL is valid until it expires, or until all signed URLs of the topic are
+revoked with 'ntfy token revoke-urls', or the topic's reservation is removed.
+
+Optional PARAM=VALUE pairs (e.g. title=Backups, or priority=high) are added to the URL as fixed
+publishing parameters. They are covered by the signature, so they cannot be changed or extended
+by the caller, and they cannot be overridden via headers.
+
+This is a server-only command. It uses the 'signed-url-secret', 'base-url' and 'auth-file' options
+as defined in the server config file server.yml.
+
+Examples:
+  ntfy token sign-url mytopic                           # Create signed URL for mytopic, valid for 30 days
+  ntfy token sign-url --expires=1y mytopic              # Create signed URL for mytopic, valid for 1 year
+  ntfy token sign-url mytopic title=Backups tags=floppy # Create signed URL with fixed title and tags`,
+		},
+		{
+			Name:      "revoke-urls",
+			Usage:     "Revoke all signed publish URLs of a topic",
+			UsageText: "ntfy token revoke-urls TOPIC",
+			Action:    execTokenRevokeURLs,
+			Description: `Revoke all signed publish URLs of a topic.
+
+This invalidates all signed URLs of the topic that were created so far, both via 'ntfy token sign-url'
+and by the topic owner via the API. URLs created afterwards are valid again.
+
+This is a server-only command. It directly writes to user.db as defined in the server config
+file server.yml. The command only works if 'auth-file' is properly defined.
+
+Example:
+  ntfy token revoke-urls mytopic`,
 		},
 		{
 			Name:    "list",
@@ -76,8 +136,8 @@ file server.yml. The command only works if 'auth-file' is properly defined.`,
 	Description: `Manage access tokens for individual users.
 
 User access tokens can be used to publish, subscribe, or perform any other user-specific tasks.
-Tokens have full access, and can perform any task a user can do. They are meant to be used to 
-avoid spreading the password to various places.
+Unless restricted to certain topics (scoped tokens), tokens have full access, and can perform any
+task a user can do. They are meant to be used to avoid spreading the password to various places.
 
 This is a server-only command. It directly manages the user.db as defined in the server config
 file server.yml. The command only works if 'auth-file' is properly defined.
@@ -87,7 +147,10 @@ Examples:
   ntfy token list phil                          # Shows list of tokens for user phil
   ntfy token add phil                           # Create token for user phil which never expires
   ntfy token add --expires=2d phil              # Create token for user phil which expires in 2 days
-  ntfy token remove phil tk_th2srHVlxr...       # Delete token`,
+  ntfy token add --topic="ci-*" --perm=wo phil  # Create token for user phil which can only publish to "ci-..."
+  ntfy token remove phil tk_th2srHVlxr...       # Delete token
+  ntfy token sign-url mytopic                   # Create signed publish URL for mytopic
+  ntfy token revoke-urls mytopic                # Revoke all signed publish URLs for mytopic`,
 }
 
 func execTokenAdd(c *cli.Context) error {
@@ -107,6 +170,10 @@ func execTokenAdd(c *cli.Context) error {
 			return err
 		}
 	}
+	scopes, err := parseTokenScopes(c.StringSlice("topic"), c.StringSlice("perm"))
+	if err != nil {
+		return err
+	}
 	manager, err := createUserManager(c)
 	if err != nil {
 		return err
@@ -117,18 +184,76 @@ func execTokenAdd(c *cli.Context) error {
 	} else if err != nil {
 		return err
 	}
-	token, err := manager.CreateToken(u.ID, label, expires, netip.IPv4Unspecified())
+	token, err := manager.CreateToken(u.ID, label, expires, netip.IPv4Unspecified(), scopes)
 	if err != nil {
 		return err
 	}
 	if expires.Unix() == 0 {
-		fmt.Fprintf(c.App.ErrWriter, "token %s created for user %s, never expires\n", token.Value, u.Name)
+		fmt.Fprintf(c.App.ErrWriter, "token %s created for user %s, never expires%s\n", token.Value, u.Name, formatTokenScopes(token.Scopes))
 	} else {
-		fmt.Fprintf(c.App.ErrWriter, "token %s created for user %s, expires %v\n", token.Value, u.Name, expires.Format(time.UnixDate))
+		fmt.Fprintf(c.App.ErrWriter, "token %s created for user %s, expires %v%s\n", token.Value, u.Name, expires.Format(time.UnixDate), formatTokenScopes(token.Scopes))
 	}
 	return nil
 }
 
+func execTokenSignURL(c *cli.Context) error {
+	topic := c.Args().Get(0)
+	baseURL, secret := c.String("base-url"), c.String("signed-url-secret")
+	if topic == "" {
+		return errors.New("topic expected, type 'ntfy token sign-url --help' for help")
+	} else if !user.AllowedTopic(topic) {
+		return fmt.Errorf("invalid topic %s", topic)
+	} else if baseURL == "" || secret == "" {
+		return errors.New("signed URLs are not enabled, set 'signed-url-secret' and 'base-url' in the server config")
+	}
+	expires, err := util.ParseFutureTime(c.String("expires"), time.Now())
+	if err != nil {
+		return err
+	}
+	params := url.Values{}
+	for _, param := range c.Args().Tail() {
+		key, value, found := strings.Cut(param, "=")
+		if !found || key == "" {
+			return fmt.Errorf("invalid parameter %s, expected PARAM=VALUE", param)
+		}
+		params.Add(key, value)
+	}
+	manager, err := createUserManager(c)
+	if err != nil {
+		return err
+	}
+	generation, err := manager.SignedURLGeneration(topic)
+	if err != nil {
+		return err
+	}
+	signedURL, err := server.SignPublishURL(secret, baseURL, topic, "", generation, params, expires)
+	if err != nil {
+		return err
+	}
+	auditCLI(manager, user.AuditActionSignedURLCreate, "", fmt.Sprintf("topic=%s expires=%d", topic, expires.Unix()))
+	fmt.Fprintf(c.App.ErrWriter, "signed URL for topic %s created, expires %s\n", topic, expires.Format(time.UnixDate))
+	fmt.Fprintln(c.App.Writer, signedURL)
+	return nil
+}
+
+func execTokenRevokeURLs(c *cli.Context) error {
+	topic := c.Args().Get(0)
+	if topic == "" {
+		return errors.New("topic expected, type 'ntfy token revoke-urls --help' for help")
+	} else if !user.AllowedTopic(topic) {
+		return fmt.Errorf("invalid topic %s", topic)
+	}
+	manager, err := createUserManager(c)
+	if err != nil {
+		return err
+	}
+	if err := manager.RevokeSignedURLs(topic); err != nil {
+		return err
+	}
+	fmt.Fprintf(c.App.ErrWriter, "signed URLs for topic %s revoked\n", topic)
+	return nil
+}
+
 func execTokenDel(c *cli.Context) error {
 	username, token := c.Args().Get(0), c.Args().Get(1)
 	if username == "" || token == "" {
@@ -200,7 +325,7 @@ func execTokenList(c *cli.Context) error {
 			} else {
 				expires = fmt.Sprintf("expires %s", t.Expires.Format(time.RFC822))
 			}
-			fmt.Fprintf(c.App.ErrWriter, "- %s%s, %s, accessed from %s at %s\n", t.Value, label, expires, t.LastOrigin.String(), t.LastAccess.Format(time.RFC822))
+			fmt.Fprintf(c.App.ErrWriter, "- %s%s, %s, accessed from %s at %s%s\n", t.Value, label, expires, t.LastOrigin.String(), t.LastAccess.Format(time.RFC822), formatTokenScopes(t.Scopes))
 		}
 	}
 	if usersWithTokens == 0 {
@@ -208,3 +333,43 @@ func execTokenList(c *cli.Context) error {
 	}
 	return nil
 }
+
+func parseTokenScopes(topics, perms []string) ([]user.Grant, error) {
+	if len(topics) == 0 && len(perms) > 0 {
+		return nil, errors.New("--perm requires --topic, type 'ntfy token add --help' for help")
+	} else if len(perms) > 1 && len(perms) != len(topics) {
+		return nil, errors.New("--perm must be passed once, or once per --topic, type 'ntfy token add --help' for help")
+	}
+	scopes := make([]user.Grant, 0, len(topics))
+	for i, topic := range topics {
+		if !user.AllowedTopicPattern(topic) {
+			return nil, fmt.Errorf("invalid topic %s", topic)
+		}
+		perm := "read-write"
+		if len(perms) == 1 {
+			perm = perms[0]
+		} else if len(perms) > 1 {
+			perm = perms[i]
+		}
+		if !util.Contains([]string{"read-write", "rw", "read-only", "read", "ro", "write-only", "write", "wo"}, perm) {
+			return nil, errors.New("permission must be one of: read-write, read-only, or write-only (or the aliases: rw, read, ro, write, wo)")
+		}
+		permission, err := user.ParsePermission(perm)
+		if err != nil {
+			return nil, err
+		}
+		scopes = append(scopes, user.Grant{TopicPattern: topic, Allow: permission})
+	}
+	return scopes, nil
+}
+
+func formatTokenScopes(scopes []user.Grant) string {
+	if len(scopes) == 0 {
+		return ""
+	}
+	formatted := make([]string, 0, len(scopes))
+	for _, scope := range scopes {
+		formatted = append(formatted, fmt.Sprintf("%s (%s)", scope.TopicPattern, scope.Allow.String()))
+	}
+	return ", scoped to " + strings.Join(formatted, ", ")
+}
@@ -38,6 +38,57 @@ func TestCLI_Token_AddListRemove(t *testing.T) {
 	require.Equal(t, "no users with tokens\n", stderr.String())
 }
 
+func TestCLI_Token_AddScoped(t *testing.T) {
+	s, conf, port := newTestServerWithAuth(t)
+	defer test.StopServer(t, s, port)
+
+	app, stdin, _, _ := newTestApp()
+	stdin.WriteString("mypass\nmypass")
+	require.Nil(t, runUserCommand(app, conf, "add", "phil"))
+
+	app, _, _, stderr := newTestApp()
+	require.Nil(t, runTokenCommand(app, conf, "add", "--topic=ci-*", "--topic=ci-results", "--perm=wo", "phil"))
+	require.Regexp(t, `token tk_.+ created for user phil, never expires, scoped to ci-\* \(write-only\), ci-results \(write-only\)`, stderr.String())
+
+	app, _, _, stderr = newTestApp()
+	require.Nil(t, runTokenCommand(app, conf, "add", "-t", "ci-*", "-p", "wo", "-t", "alerts", "-p", "ro", "phil"))
+	require.Regexp(t, `scoped to ci-\* \(write-only\), alerts \(read-only\)`, stderr.String())
+
+	app, _, _, stderr = newTestApp()
+	require.Nil(t, runTokenCommand(app, conf, "list", "phil"))
+	require.Regexp(t, `- tk_.+, never expires, accessed from 0.0.0.0 at .+, scoped to ci-\* \(write-only\), ci-results \(write-only\)`, stderr.String())
+
+	app, _, _, _ = newTestApp()
+	require.Equal(t, "--perm requires --topic, type 'ntfy token add --help' for help", runTokenCommand(app, conf, "add", "--perm=wo", "phil").Error())
+	require.Equal(t, "--perm must be passed once, or once per --topic, type 'ntfy token add --help' for help", runTokenCommand(app, conf, "add", "-t", "a", "-p", "ro", "-p", "wo", "-t", "b", "-t", "c", "phil").Error())
+	require.Equal(t, "invalid topic ci/builds", runTokenCommand(app, conf, "add", "--topic=ci/builds", "phil").Error())
+	require.Contains(t, runTokenCommand(app, conf, "add", "--topic=ci", "--perm=deny", "phil").Error(), "permission must be one of")
+}
+
+func TestCLI_Token_SignURL(t *testing.T) {
+	s, conf, port := newTestServerWithAuth(t)
+	defer test.StopServer(t, s, port)
+
+	app, _, stdout, stderr := newTestApp()
+	require.Nil(t, runTokenCommand(app, conf, "--base-url=https://ntfy.example.com", "--signed-url-secret=Ayee7ieL5ahcee0zohth6aijeiT4eesh", "sign-url", "--expires=2d", "mytopic", "title=CI build", "priority=high"))
+	require.Contains(t, stderr.String(), "signed URL for topic mytopic created, expires ")
+	require.Regexp(t, `^https://ntfy\.example\.com/mytopic/publish\?exp=\d+&gen=0&priority=high&sig=[-_A-Za-z0-9]+&title=CI\+build\n$`, stdout.String())
+
+	app, _, stdout, stderr = newTestApp()
+	require.Nil(t, runTokenCommand(app, conf, "revoke-urls", "mytopic"))
+	require.Equal(t, "signed URLs for topic mytopic revoked\n", stderr.String())
+	app, _, stdout, _ = newTestApp()
+	require.Nil(t, runTokenCommand(app, conf, "--base-url=https://ntfy.example.com", "--signed-url-secret=Ayee7ieL5ahcee0zohth6aijeiT4eesh", "sign-url", "mytopic"))
+	require.Contains(t, stdout.String(), "&gen=1&")
+
+	app, _, _, _ = newTestApp()
+	require.Equal(t, "signed URLs are not enabled, set 'signed-url-secret' and 'base-url' in the server config", runTokenCommand(app, conf, "sign-url", "mytopic").Error())
+	require.Equal(t, "topic expected, type 'ntfy token sign-url --help' for help", runTokenCommand(app, conf, "sign-url").Error())
+	require.Equal(t, "invalid topic my/topic", runTokenCommand(app, conf, "--base-url=https://ntfy.example.com", "--signed-url-secret=Ayee7ieL5ahcee0zohth6aijeiT4eesh", "sign-url", "my/topic").Error())
+	require.Equal(t, "invalid parameter title, expected PARAM=VALUE", runTokenCommand(app, conf, "--base-url=https://ntfy.example.com", "--signed-url-secret=Ayee7ieL5ahcee0zohth6aijeiT4eesh", "sign-url", "mytopic", "title").Error())
+	require.Equal(t, "topic expected, type 'ntfy token revoke-urls --help' for help", runTokenCommand(app, conf, "revoke-urls").Error())
+}
+
 func runTokenCommand(app *cli.App, conf *server.Config, args ...string) error {
 	userArgs := []string{
 		"ntfy",
@@ -0,0 +1,185 @@
+//go:build !noserver
+
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"github.com/urfave/cli/v2"
+	"heckel.io/ntfy/v2/user"
+	"heckel.io/ntfy/v2/util"
+)
+
+func init() {
+	commands = append(commands, cmdTopic)
+}
+
+var (
+	flagsTopic = append([]cli.Flag{}, flagsUser...)
+)
+
+var cmdTopic = &cli.Command{
+	Name:      "topic",
+	Usage:     "Manage/show topic settings",
+	UsageText: "ntfy topic [retention] ...",
+	Flags:     flagsTopic,
+	Before:    initConfigFileInputSourceFunc("config", flagsUser, initLogFunc),
+	Category:  categoryServer,
+	Subcommands: []*cli.Command{
+		{
+			Name:      "retention",
+			Aliases:   []string{"ret"},
+			Usage:     "Set/reset/show per-topic retention policies",
+			UsageText: "ntfy topic retention [--max-age=DURATION] [--max-messages=COUNT] [--reset] [TOPIC]",
+			Action:    execTopicRetention,
+			Flags: []cli.Flag{
+				&cli.StringFlag{Name: "max-age", Usage: "duration after which messages in the topic are deleted, e.g. 7d or 12h (0 = no limit)"},
+				&cli.Int64Flag{Name: "max-messages", Usage: "number of messages to keep in the topic; older messages are deleted (0 = no limit)"},
+				&cli.BoolFlag{Name: "reset", Aliases: []string{"r"}, Usage: "remove the retention policy for the topic"},
+			},
+			Description: `Set, reset or show per-topic retention policies.
+
+A retention policy limits how long messages are kept for a topic (max age), and/or how many messages
+are kept (max messages). Messages exceeding either limit are deleted the next time the server prunes
+messages. Retention policies can only shorten the time messages are kept; messages still expire after
+the duration defined by 'cache-duration' (or the tier's message expiry duration).
+
+Retention policies can also be set by the owner of a reserved topic via the web app or the API. When
+a reservation is removed, its retention policy is removed as well.
+
+This is a server-only command. It directly manages the user.db as defined in the server config
+file server.yml. The command only works if 'auth-file' is properly defined.
+
+Examples:
+  ntfy topic retention                                # Shows all retention policies
+  ntfy topic retention mytopic                        # Shows retention policy for topic mytopic
+  ntfy topic retention --max-age=7d mytopic           # Delete messages in mytopic after 7 days
+  ntfy topic retention --max-messages=100 mytopic     # Only keep the newest 100 messages in mytopic
+  ntfy topic retention --reset mytopic                # Remove the retention policy for mytopic
+`,
+		},
+	},
+	Description: `Manage topic settings of the ntfy server.
+
+The command allows you to change settings of individual topics in the ntfy user database,
+such as per-topic retention policies.
+
+This is a server-only command. It directly manages the user.db as defined in the server config
+file server.yml. The command only works if 'auth-file' is properly defined.
+
+Examples:
+  ntfy topic retention                           # Shows all retention policies
+  ntfy topic retention --max-age=7d mytopic      # Delete messages in mytopic after 7 days
+  ntfy topic retention --reset mytopic           # Remove the retention policy for mytopic
+`,
+}
+
+func execTopicRetention(c *cli.Context) error {
+	if c.NArg() > 1 {
+		return errors.New("too many arguments, please check 'ntfy topic retention --help' for usage details")
+	}
+	topic := c.Args().Get(0)
+	if topic != "" && !user.AllowedTopic(topic) {
+		return errors.New("invalid topic name, topics may only contain letters, numbers, dashes and underscores")
+	}
+	manager, err := createUserManager(c)
+	if err != nil {
+		return err
+	}
+	change := c.IsSet("max-age") || c.IsSet("max-messages")
+	if c.Bool("reset") {
+		if topic == "" {
+			return errors.New("topic expected, type 'ntfy topic retention --help' for help")
+		} else if change {
+			return errors.New("cannot combine --reset with --max-age or --max-messages")
+		}
+		return resetTopicRetention(c, manager, topic)
+	} else if change {
+		if topic == "" {
+			return errors.New("topic expected, type 'ntfy topic retention --help' for help")
+		}
+		return changeTopicRetention(c, manager, topic)
+	} else if topic != "" {
+		return showTopicRetention(c, manager, topic)
+	}
+	return showAllTopicRetentions(c, manager)
+}
+
+func changeTopicRetention(c *cli.Context, manager *user.Manager, topic string) error {
+	retention, err := manager.TopicRetention(topic)
+	if err == user.ErrTopicRetentionNotFound {
+		retention = &user.TopicRetention{Topic: topic}
+	} else if err != nil {
+		return err
+	}
+	if c.IsSet("max-age") {
+		retention.MaxAge, err = util.ParseDuration(c.String("max-age"))
+		if err != nil {
+			return err
+		}
+	}
+	if c.IsSet("max-messages") {
+		retention.MaxMessages = c.Int64("max-messages")
+	}
+	if retention.MaxAge < 0 || retention.MaxMessages < 0 {
+		return errors.New("max-age and max-messages must not be negative")
+	}
+	if err := manager.ChangeTopicRetention(retention); err != nil {
+		return err
+	}
+	if retention.MaxAge == 0 && retention.MaxMessages == 0 {
+		fmt.Fprintf(c.App.ErrWriter, "removed retention policy for topic %s\n", topic)
+		return nil
+	}
+	fmt.Fprintf(c.App.ErrWriter, "changed retention policy for topic %s\n\n", topic)
+	printTopicRetention(c, retention)
+	return nil
+}
+
+func resetTopicRetention(c *cli.Context, manager *user.Manager, topic string) error {
+	if err := manager.ResetTopicRetention(topic); err != nil {
+		return err
+	}
+	fmt.Fprintf(c.App.ErrWriter, "removed retention policy for topic %s\n", topic)
+	return nil
+}
+
+func showTopicRetention(c *cli.Context, manager *user.Manager, topic string) error {
+	retention, err := manager.TopicRetention(topic)
+	if err == user.ErrTopicRetentionNotFound {
+		fmt.Fprintf(c.App.ErrWriter, "no retention policy for topic %s\n", topic)
+		return nil
+	} else if err != nil {
+		return err
+	}
+	printTopicRetention(c, retention)
+	return nil
+}
+
+func showAllTopicRetentions(c *cli.Context, manager *user.Manager) error {
+	retentions, err := manager.TopicRetentions()
+	if err != nil {
+		return err
+	}
+	if len(retentions) == 0 {
+		fmt.Fprintln(c.App.ErrWriter, "no retention policies configured")
+		return nil
+	}
+	for _, retention := range retentions {
+		printTopicRetention(c, retention)
+	}
+	return nil
+}
+
+func printTopicRetention(c *cli.Context, retention *user.TopicRetention) {
+	maxAge, maxMessages := "(no limit)", "(no limit)"
+	if retention.MaxAge > 0 {
+		maxAge = fmt.Sprintf("%s (%d seconds)", retention.MaxAge.String(), int64(retention.MaxAge.Seconds()))
+	}
+	if retention.MaxMessages > 0 {
+		maxMessages = fmt.Sprintf("%d", retention.MaxMessages)
+	}
+	fmt.Fprintf(c.App.ErrWriter, "topic %s\n", retention.Topic)
+	fmt.Fprintf(c.App.ErrWriter, "- Max age: %s\n", maxAge)
+	fmt.Fprintf(c.App.ErrWriter, "- Max messages: %s\n", maxMessages)
+}
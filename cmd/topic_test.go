@@ -0,0 +1,61 @@
+package cmd
+
+import (
+	"github.com/stretchr/testify/require"
+	"github.com/urfave/cli/v2"
+	"heckel.io/ntfy/v2/server"
+	"heckel.io/ntfy/v2/test"
+	"testing"
+)
+
+func TestCLI_Topic_Retention(t *testing.T) {
+	s, conf, port := newTestServerWithAuth(t)
+	defer test.StopServer(t, s, port)
+
+	app, _, _, stderr := newTestApp()
+	require.Nil(t, runTopicCommand(app, conf, "retention"))
+	require.Contains(t, stderr.String(), "no retention policies configured")
+
+	app, _, _, stderr = newTestApp()
+	require.Nil(t, runTopicCommand(app, conf, "retention", "--max-age=2d", "mytopic"))
+	require.Contains(t, stderr.String(), "changed retention policy for topic mytopic")
+	require.Contains(t, stderr.String(), "- Max age: 48h0m0s (172800 seconds)")
+	require.Contains(t, stderr.String(), "- Max messages: (no limit)")
+
+	app, _, _, stderr = newTestApp()
+	require.Nil(t, runTopicCommand(app, conf, "retention", "--max-messages=100", "mytopic"))
+	require.Contains(t, stderr.String(), "- Max age: 48h0m0s (172800 seconds)")
+	require.Contains(t, stderr.String(), "- Max messages: 100")
+
+	app, _, _, stderr = newTestApp()
+	require.Nil(t, runTopicCommand(app, conf, "retention"))
+	require.Contains(t, stderr.String(), "topic mytopic\n- Max age: 48h0m0s (172800 seconds)\n- Max messages: 100")
+
+	app, _, _, stderr = newTestApp()
+	require.Nil(t, runTopicCommand(app, conf, "retention", "--reset", "mytopic"))
+	require.Contains(t, stderr.String(), "removed retention policy for topic mytopic")
+
+	app, _, _, stderr = newTestApp()
+	require.Nil(t, runTopicCommand(app, conf, "retention", "mytopic"))
+	require.Contains(t, stderr.String(), "no retention policy for topic mytopic")
+
+	app, _, _, _ = newTestApp()
+	err := runTopicCommand(app, conf, "retention", "--reset")
+	require.Equal(t, "topic expected, type 'ntfy topic retention --help' for help", err.Error())
+
+	app, _, _, _ = newTestApp()
+	err = runTopicCommand(app, conf, "retention", "--max-messages=-1", "mytopic")
+	require.Equal(t, "max-age and max-messages must not be negative", err.Error())
+}
+
+func runTopicCommand(app *cli.App, conf *server.Config, args ...string) error {
+	userArgs := []string{
+		"ntfy",
+		"--log-level=ERROR",
+		"topic",
+		"--config=" + conf.File, // Dummy config file to avoid lookups of real file
+		"--auth-file=" + conf.AuthFile,
+		"--auth-default-access=" + conf.AuthDefault.String(),
+	}
+	return app.Run(append(userArgs, args...))
+}
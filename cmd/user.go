@@ -26,8 +26,11 @@ func init() {
 var flagsUser = append(
 	append([]cli.Flag{}, flagsDefault...),
 	&cli.StringFlag{Name: "config", Aliases: []string{"c"}, EnvVars: []string{"NTFY_CONFIG_FILE"}, Value: defaultServerConfigFile, DefaultText: defaultServerConfigFile, Usage: "config file"},
-	altsrc.NewStringFlag(&cli.StringFlag{Name: "auth-file", Aliases: []string{"auth_file", "H"}, EnvVars: []string{"NTFY_AUTH_FILE"}, Usage: "auth database file used for access control"}),
+	altsrc.NewStringFlag(&cli.StringFlag{Name: "auth-file", Aliases: []string{"auth_file", "H"}, EnvVars: []string{"NTFY_AUTH_FILE"}, Usage: "auth database file (or PostgreSQL URL) used for access control"}),
 	altsrc.NewStringFlag(&cli.StringFlag{Name: "auth-default-access", Aliases: []string{"auth_default_access", "p"}, EnvVars: []string{"NTFY_AUTH_DEFAULT_ACCESS"}, Value: "read-write", Usage: "default permissions if no matching entries in the auth database are found"}),
+	altsrc.NewIntFlag(&cli.IntFlag{Name: "auth-password-min-length", Aliases: []string{"auth_password_min_length"}, EnvVars: []string{"NTFY_AUTH_PASSWORD_MIN_LENGTH"}, Usage: "minimum length of new passwords"}),
+	altsrc.NewIntFlag(&cli.IntFlag{Name: "auth-password-min-classes", Aliases: []string{"auth_password_min_classes"}, EnvVars: []string{"NTFY_AUTH_PASSWORD_MIN_CLASSES"}, Usage: "minimum number of character classes (lowercase, uppercase, digits, other) in new passwords (0-4)"}),
+	altsrc.NewBoolFlag(&cli.BoolFlag{Name: "auth-password-disallow-username", Aliases: []string{"auth_password_disallow_username"}, EnvVars: []string{"NTFY_AUTH_PASSWORD_DISALLOW_USERNAME"}, Value: false, Usage: "disallows new passwords that contain the username"}),
 )
 
 var cmdUser = &cli.Command{
@@ -131,6 +134,36 @@ as messages per day, attachment file sizes, etc.
 Example:
   ntfy user change-tier phil pro   # Change tier to "pro" for user "phil"  
   ntfy user change-tier phil -     # Remove tier from user "phil" entirely 
+`,
+		},
+		{
+			Name:      "disable-2fa",
+			Usage:     "Disables two-factor authentication for a user",
+			UsageText: "ntfy user disable-2fa USERNAME",
+			Action:    execUserDisableTwoFactor,
+			Description: `Disable two-factor authentication for the given user.
+
+This command can be used if a user lost access to their authenticator app and their recovery
+codes. The TOTP secret and all recovery codes of the user are deleted. The user can then log
+in with their password only, and set up two-factor authentication again.
+
+Example:
+  ntfy user disable-2fa phil   # Disable two-factor authentication for user phil
+`,
+		},
+		{
+			Name:      "unlock",
+			Usage:     "Unlocks a user that was locked due to too many failed logins",
+			UsageText: "ntfy user unlock USERNAME",
+			Action:    execUserUnlock,
+			Description: `Unlock a user that was locked due to too many failed logins.
+
+If 'auth-lockout-attempts' is set in the server config, users are locked for 'auth-lockout-duration'
+after too many consecutive failed logins. This command removes the lock immediately, and resets the
+failed login counter of the user.
+
+Example:
+  ntfy user unlock phil   # Unlock user phil
 `,
 		},
 		{
@@ -165,9 +198,11 @@ Examples:
   ntfy user change-pass phil                   # Change password for user phil
   NTFY_PASSWORD=.. ntfy user change-pass phil  # As above, using env variable to set password (for scripts)
   ntfy user change-role phil admin             # Make user phil an admin 
+  ntfy user unlock phil                        # Unlock user phil after too many failed logins
 
 For the 'ntfy user add' and 'ntfy user change-pass' commands, you may set the NTFY_PASSWORD environment
 variable to pass the new password. This is useful if you are creating/updating users via scripts.
+New passwords must satisfy the password policy ('auth-password-...' options) in the server config.
 `,
 }
 
@@ -278,6 +313,50 @@ func execUserChangeRole(c *cli.Context) error {
 	return nil
 }
 
+func execUserDisableTwoFactor(c *cli.Context) error {
+	username := c.Args().Get(0)
+	if username == "" {
+		return errors.New("username expected, type 'ntfy user disable-2fa --help' for help")
+	} else if username == userEveryone || username == user.Everyone {
+		return errors.New("username not allowed")
+	}
+	manager, err := createUserManager(c)
+	if err != nil {
+		return err
+	}
+	u, err := manager.User(username)
+	if err == user.ErrUserNotFound {
+		return fmt.Errorf("user %s does not exist", username)
+	} else if err != nil {
+		return err
+	}
+	if err := manager.DisableTwoFactor(u.ID); err != nil {
+		return err
+	}
+	fmt.Fprintf(c.App.ErrWriter, "disabled two-factor authentication for user %s\n", username)
+	return nil
+}
+
+func execUserUnlock(c *cli.Context) error {
+	username := c.Args().Get(0)
+	if username == "" {
+		return errors.New("username expected, type 'ntfy user unlock --help' for help")
+	} else if username == userEveryone || username == user.Everyone {
+		return errors.New("username not allowed")
+	}
+	manager, err := createUserManager(c)
+	if err != nil {
+		return err
+	}
+	if err := manager.UnlockUser(username); err == user.ErrUserNotFound {
+		return fmt.Errorf("user %s does not exist", username)
+	} else if err != nil {
+		return err
+	}
+	fmt.Fprintf(c.App.ErrWriter, "unlocked user %s\n", username)
+	return nil
+}
+
 func execUserChangeTier(c *cli.Context) error {
 	username := c.Args().Get(0)
 	tier := c.Args().Get(1)
@@ -327,14 +406,23 @@ func createUserManager(c *cli.Context) (*user.Manager, error) {
 	authDefaultAccess := c.String("auth-default-access")
 	if authFile == "" {
 		return nil, errors.New("option auth-file not set; auth is unconfigured for this server")
-	} else if !util.FileExists(authFile) {
+	} else if !util.IsPostgresURL(authFile) && !util.FileExists(authFile) {
 		return nil, errors.New("auth-file does not exist; please start the server at least once to create it")
 	}
 	authDefault, err := user.ParsePermission(authDefaultAccess)
 	if err != nil {
 		return nil, errors.New("if set, auth-default-access must start set to 'read-write', 'read-only', 'write-only' or 'deny-all'")
 	}
-	return user.NewManager(authFile, authStartupQueries, authDefault, user.DefaultUserPasswordBcryptCost, user.DefaultUserStatsQueueWriterInterval)
+	manager, err := user.NewManager(authFile, authStartupQueries, authDefault, user.DefaultUserPasswordBcryptCost, user.DefaultUserStatsQueueWriterInterval)
+	if err != nil {
+		return nil, err
+	}
+	manager.SetPasswordPolicy(&user.PasswordPolicy{
+		MinLength:        c.Int("auth-password-min-length"),
+		MinClasses:       c.Int("auth-password-min-classes"),
+		DisallowUsername: c.Bool("auth-password-disallow-username"),
+	})
+	return manager.WithActor(&user.Actor{Origin: user.AuditOriginCLI}), nil
 }
 
 func readPasswordAndConfirm(c *cli.Context) (string, error) {
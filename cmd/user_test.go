@@ -91,6 +91,53 @@ func TestCLI_User_ChangeRole(t *testing.T) {
 	require.Contains(t, stderr.String(), "changed role for user phil to admin")
 }
 
+func TestCLI_User_DisableTwoFactor(t *testing.T) {
+	s, conf, port := newTestServerWithAuth(t)
+	defer test.StopServer(t, s, port)
+
+	app, stdin, _, _ := newTestApp()
+	stdin.WriteString("mypass\nmypass")
+	require.Nil(t, runUserCommand(app, conf, "add", "phil"))
+
+	app, _, _, stderr := newTestApp()
+	require.Nil(t, runUserCommand(app, conf, "disable-2fa", "phil"))
+	require.Contains(t, stderr.String(), "disabled two-factor authentication for user phil")
+
+	app, _, _, _ = newTestApp()
+	require.Equal(t, "user ben does not exist", runUserCommand(app, conf, "disable-2fa", "ben").Error())
+}
+
+func TestCLI_User_Unlock(t *testing.T) {
+	s, conf, port := newTestServerWithAuth(t)
+	defer test.StopServer(t, s, port)
+
+	app, stdin, _, _ := newTestApp()
+	stdin.WriteString("mypass\nmypass")
+	require.Nil(t, runUserCommand(app, conf, "add", "phil"))
+
+	app, _, _, stderr := newTestApp()
+	require.Nil(t, runUserCommand(app, conf, "unlock", "phil"))
+	require.Contains(t, stderr.String(), "unlocked user phil")
+
+	app, _, _, _ = newTestApp()
+	require.Equal(t, "user ben does not exist", runUserCommand(app, conf, "unlock", "ben").Error())
+}
+
+func TestCLI_User_Add_PasswordPolicy(t *testing.T) {
+	s, conf, port := newTestServerWithAuth(t)
+	defer test.StopServer(t, s, port)
+
+	app, stdin, _, _ := newTestApp()
+	stdin.WriteString("mypass\nmypass")
+	err := runUserCommand(app, conf, "--auth-password-min-length=10", "add", "phil")
+	require.ErrorIs(t, err, user.ErrPasswordPolicy)
+
+	app, stdin, _, stderr := newTestApp()
+	stdin.WriteString("my long password\nmy long password")
+	require.Nil(t, runUserCommand(app, conf, "--auth-password-min-length=10", "add", "phil"))
+	require.Contains(t, stderr.String(), "user phil added with role user")
+}
+
 func TestCLI_User_Delete(t *testing.T) {
 	s, conf, port := newTestServerWithAuth(t)
 	defer test.StopServer(t, s, port)
@@ -5,6 +5,7 @@ import (
 	"errors"
 	"fmt"
 	"heckel.io/ntfy/v2/util"
+	"maps"
 	"regexp"
 	"strings"
 	"unicode/utf8"
@@ -17,13 +18,14 @@ const (
 )
 
 const (
-	actionView      = "view"
-	actionBroadcast = "broadcast"
-	actionHTTP      = "http"
+	actionView        = "view"
+	actionBroadcast   = "broadcast"
+	actionHTTP        = "http"
+	actionAcknowledge = "acknowledge"
 )
 
 var (
-	actionsAll      = []string{actionView, actionBroadcast, actionHTTP}
+	actionsAll      = []string{actionView, actionBroadcast, actionHTTP, actionAcknowledge}
 	actionsWithURL  = []string{actionView, actionHTTP}
 	actionsKeyRegex = regexp.MustCompile(`^([-.\w]+)\s*=\s*`)
 )
@@ -61,19 +63,36 @@ func parseActions(s string) (actions []*action, err error) {
 	}
 	for _, action := range actions {
 		if !util.Contains(actionsAll, action.Action) {
-			return nil, fmt.Errorf("parameter 'action' cannot be '%s', valid values are 'view', 'broadcast' and 'http'", action.Action)
+			return nil, fmt.Errorf("parameter 'action' cannot be '%s', valid values are 'view', 'broadcast', 'http' and 'acknowledge'", action.Action)
 		} else if action.Label == "" {
 			return nil, fmt.Errorf("parameter 'label' is required")
 		} else if util.Contains(actionsWithURL, action.Action) && action.URL == "" {
 			return nil, fmt.Errorf("parameter 'url' is required for action '%s'", action.Action)
 		} else if action.Action == actionHTTP && util.Contains([]string{"GET", "HEAD"}, action.Method) && action.Body != "" {
 			return nil, fmt.Errorf("parameter 'body' cannot be set if method is %s", action.Method)
+		} else if action.Action == actionAcknowledge && (action.URL != "" || action.Method != "" || action.Body != "" || len(action.Headers) > 0) {
+			return nil, fmt.Errorf("parameters 'url', 'method', 'headers' and 'body' cannot be set for action '%s'", action.Action)
 		}
 	}
 
 	return actions, nil
 }
 
+// copyActions returns a deep copy of the given actions, so that they can be changed without affecting the original
+func copyActions(actions []*action) []*action {
+	if actions == nil {
+		return nil
+	}
+	copied := make([]*action, len(actions))
+	for i, a := range actions {
+		c := *a
+		c.Headers = maps.Clone(a.Headers)
+		c.Extras = maps.Clone(a.Extras)
+		copied[i] = &c
+	}
+	return copied
+}
+
 // parseActionsFromJSON converts a JSON array into an array of actions
 func parseActionsFromJSON(s string) ([]*action, error) {
 	actions := make([]*action, 0)
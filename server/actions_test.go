@@ -32,6 +32,15 @@ func TestParseActions(t *testing.T) {
 	require.Equal(t, "Show portal", actions[1].Label)
 	require.Equal(t, "https://door.lan", actions[1].URL)
 
+	// Acknowledge action, URL and method are set by the server
+	actions, err = parseActions("acknowledge, Got it, clear=true")
+	require.Nil(t, err)
+	require.Equal(t, 1, len(actions))
+	require.Equal(t, "acknowledge", actions[0].Action)
+	require.Equal(t, "Got it", actions[0].Label)
+	require.True(t, actions[0].Clear)
+	require.Equal(t, "", actions[0].URL)
+
 	// Other params
 	actions, err = parseActions("action=http, label=Open door, url=https://door.lan/open, body=this is a body, method=PUT")
 	require.Nil(t, err)
@@ -146,7 +155,7 @@ func TestParseActions(t *testing.T) {
 	require.EqualError(t, err, "term 'what is this anyway' unknown")
 
 	_, err = parseActions(`fdsfdsf`)
-	require.EqualError(t, err, "parameter 'action' cannot be 'fdsfdsf', valid values are 'view', 'broadcast' and 'http'")
+	require.EqualError(t, err, "parameter 'action' cannot be 'fdsfdsf', valid values are 'view', 'broadcast', 'http' and 'acknowledge'")
 
 	_, err = parseActions(`aaa=a, "bbb, 'ccc, ddd, eee "`)
 	require.EqualError(t, err, "key 'aaa' unknown")
@@ -173,7 +182,7 @@ func TestParseActions(t *testing.T) {
 	require.EqualError(t, err, "JSON error: invalid character 'i' looking for beginning of value")
 
 	_, err = parseActions(`[ { "some": "object" } ]`)
-	require.EqualError(t, err, "parameter 'action' cannot be '', valid values are 'view', 'broadcast' and 'http'")
+	require.EqualError(t, err, "parameter 'action' cannot be '', valid values are 'view', 'broadcast', 'http' and 'acknowledge'")
 
 	_, err = parseActions("\x00\x01\xFFx\xFE")
 	require.EqualError(t, err, "invalid utf-8 string")
@@ -181,4 +190,7 @@ func TestParseActions(t *testing.T) {
 	_, err = parseActions(`http, label, http://x.org, clear=x`)
 	require.EqualError(t, err, "parameter 'clear' cannot be 'x', only boolean values are allowed (true/yes/1/false/no/0)")
 
+	_, err = parseActions(`acknowledge, Got it, url=http://example.com`)
+	require.EqualError(t, err, "parameters 'url', 'method', 'headers' and 'body' cannot be set for action 'acknowledge'")
+
 }
@@ -23,6 +23,12 @@ const (
 	DefaultStripePriceCacheDuration             = 3 * time.Hour    // Time to keep Stripe prices cached in memory before a refresh is needed
 )
 
+// Defines default OpenID Connect settings
+const (
+	DefaultOIDCUsernameClaim = "preferred_username"
+	DefaultOIDCGroupsClaim   = "groups"
+)
+
 // Defines default Web Push settings
 const (
 	DefaultWebPushExpiryWarningDuration = 7 * 24 * time.Hour
@@ -49,6 +55,7 @@ const (
 // - per visitor attachment daily bandwidth limit: number of bytes that can be transferred to/from the server
 const (
 	DefaultVisitorSubscriptionLimit             = 30
+	DefaultVisitorScheduleLimit                 = 20
 	DefaultVisitorRequestLimitBurst             = 60
 	DefaultVisitorRequestLimitReplenish         = 5 * time.Second
 	DefaultVisitorMessageDailyLimit             = 0
@@ -69,6 +76,10 @@ var (
 	// DefaultDisallowedTopics defines the topics that are forbidden, because they are used elsewhere. This array can be
 	// extended using the server.yml config. If updated, also update in Android and web app.
 	DefaultDisallowedTopics = []string{"docs", "static", "file", "app", "metrics", "account", "settings", "signup", "login", "v1"}
+
+	// GotifyDisallowedTopics defines the topics that are forbidden if the Gotify API is enabled, because the
+	// Gotify endpoints POST /message and GET /stream would shadow them.
+	GotifyDisallowedTopics = []string{"message", "stream"}
 )
 
 // Config is the main config struct for the application. Use New to instantiate a default config struct.
@@ -81,6 +92,8 @@ type Config struct {
 	ListenUnixMode                       fs.FileMode
 	KeyFile                              string
 	CertFile                             string
+	ClientCAFile                         string // PEM-encoded CA certificates to verify client certificates against (mutual TLS), if set
+	ClientCertUsername                   string // Part of the client certificate that contains the username, see ClientCertUsernameCommonName
 	FirebaseKeyFile                      string
 	CacheFile                            string
 	CacheDuration                        time.Duration
@@ -92,6 +105,22 @@ type Config struct {
 	AuthDefault                          user.Permission
 	AuthBcryptCost                       int
 	AuthStatsQueueWriterInterval         time.Duration
+	AuthLDAPURL                          string // LDAP server URL, enables LDAP authentication if set
+	AuthLDAPBindDN                       string
+	AuthLDAPBindPassword                 string
+	AuthLDAPBaseDN                       string
+	AuthLDAPUserFilter                   string
+	AuthLDAPGroupAttribute               string
+	AuthLDAPGroupAccess                  []*user.LDAPGroupAccess
+	AuthLDAPCacheDuration                time.Duration
+	AuthRequireAdminTwoFactor            bool          // If true, admins must enable two-factor authentication to use their password
+	AuthPasswordMinLength                int           // Minimum password length for new passwords, see user.PasswordPolicy
+	AuthPasswordMinClasses               int           // Minimum number of character classes for new passwords (0-4)
+	AuthPasswordDisallowUsername         bool          // If true, new passwords must not contain the username
+	AuthLockoutAttempts                  int           // Number of consecutive failed logins after which a user is locked, lockout is disabled if 0
+	AuthLockoutDuration                  time.Duration // Duration for which a user is locked, see AuthLockoutAttempts
+	SignedURLSecret                      string        // Secret used to sign publish URLs (see SignPublishURL), signed URLs are disabled if empty
+	SignedURLMaxExpiryDuration           time.Duration // Maximum validity of signed URLs created via the API
 	AttachmentCacheDir                   string
 	AttachmentTotalSizeLimit             int64
 	AttachmentFileSizeLimit              int64
@@ -113,12 +142,22 @@ type Config struct {
 	SMTPServerListen                     string
 	SMTPServerDomain                     string
 	SMTPServerAddrPrefix                 string
+	MQTTServerListen                     string                      // Address for the MQTT server, e.g. :1883, disabled if empty
+	GotifyApps                           map[string]*GotifyApp       // Gotify application token -> app; Gotify API is disabled if empty
+	MessageTemplates                     map[string]*MessageTemplate // Template name -> template, used with ?template=<name>
 	TwilioAccount                        string
 	TwilioAuthToken                      string
 	TwilioPhoneNumber                    string
 	TwilioCallsBaseURL                   string
 	TwilioVerifyBaseURL                  string
 	TwilioVerifyService                  string
+	OIDCIssuer                           string // OpenID Connect issuer URL, enables "Log in with SSO" if set
+	OIDCClientID                         string
+	OIDCClientSecret                     string
+	OIDCUsernameClaim                    string            // ID token claim used as ntfy username
+	OIDCGroupsClaim                      string            // ID token claim that contains the user's groups
+	OIDCAdminGroups                      []string          // Members of these groups get RoleAdmin, everyone else RoleUser
+	OIDCTierGroups                       map[string]string // Group -> tier code; tiers are only managed via OIDC if set
 	MetricsEnable                        bool
 	MetricsListenHTTP                    string
 	ProfileListenHTTP                    string
@@ -128,6 +167,7 @@ type Config struct {
 	TotalTopicLimit                      int
 	TotalAttachmentSizeLimit             int64
 	VisitorSubscriptionLimit             int
+	VisitorScheduleLimit                 int
 	VisitorAttachmentTotalSizeLimit      int64
 	VisitorAttachmentDailyBandwidthLimit int64
 	VisitorRequestLimitBurst             int
@@ -149,7 +189,9 @@ type Config struct {
 	BillingContact                       string
 	EnableSignup                         bool // Enable creation of accounts via API and UI
 	EnableLogin                          bool
-	EnableReservations                   bool // Allow users with role "user" to own/reserve topics
+	EnableReservations                   bool           // Allow users with role "user" to own/reserve topics
+	EnableWebhooks                       bool           // Allow topic owners to register outbound webhooks
+	WebhookAllowedIPAddrs                []netip.Prefix // Private/internal addresses that webhooks may be delivered to anyway
 	EnableMetrics                        bool
 	AccessControlAllowOrigin             string // CORS header field to restrict access from web clients
 	Version                              string // injected by App
@@ -173,6 +215,8 @@ func NewConfig() *Config {
 		ListenUnixMode:                       0,
 		KeyFile:                              "",
 		CertFile:                             "",
+		ClientCAFile:                         "",
+		ClientCertUsername:                   ClientCertUsernameCommonName,
 		FirebaseKeyFile:                      "",
 		CacheFile:                            "",
 		CacheDuration:                        DefaultCacheDuration,
@@ -184,6 +228,22 @@ func NewConfig() *Config {
 		AuthDefault:                          user.PermissionReadWrite,
 		AuthBcryptCost:                       user.DefaultUserPasswordBcryptCost,
 		AuthStatsQueueWriterInterval:         user.DefaultUserStatsQueueWriterInterval,
+		AuthLDAPURL:                          "",
+		AuthLDAPBindDN:                       "",
+		AuthLDAPBindPassword:                 "",
+		AuthLDAPBaseDN:                       "",
+		AuthLDAPUserFilter:                   user.DefaultLDAPUserFilter,
+		AuthLDAPGroupAttribute:               user.DefaultLDAPGroupAttribute,
+		AuthLDAPGroupAccess:                  make([]*user.LDAPGroupAccess, 0),
+		AuthLDAPCacheDuration:                user.DefaultLDAPCacheDuration,
+		AuthRequireAdminTwoFactor:            false,
+		AuthPasswordMinLength:                0,
+		AuthPasswordMinClasses:               0,
+		AuthPasswordDisallowUsername:         false,
+		AuthLockoutAttempts:                  0,
+		AuthLockoutDuration:                  user.DefaultLockoutDuration,
+		SignedURLSecret:                      "",
+		SignedURLMaxExpiryDuration:           DefaultSignedURLMaxExpiryDuration,
 		AttachmentCacheDir:                   "",
 		AttachmentTotalSizeLimit:             DefaultAttachmentTotalSizeLimit,
 		AttachmentFileSizeLimit:              DefaultAttachmentFileSizeLimit,
@@ -205,18 +265,29 @@ func NewConfig() *Config {
 		SMTPServerListen:                     "",
 		SMTPServerDomain:                     "",
 		SMTPServerAddrPrefix:                 "",
+		MQTTServerListen:                     "",
+		GotifyApps:                           make(map[string]*GotifyApp),
+		MessageTemplates:                     make(map[string]*MessageTemplate),
 		TwilioCallsBaseURL:                   "https://api.twilio.com", // Override for tests
 		TwilioAccount:                        "",
 		TwilioAuthToken:                      "",
 		TwilioPhoneNumber:                    "",
 		TwilioVerifyBaseURL:                  "https://verify.twilio.com", // Override for tests
 		TwilioVerifyService:                  "",
+		OIDCIssuer:                           "",
+		OIDCClientID:                         "",
+		OIDCClientSecret:                     "",
+		OIDCUsernameClaim:                    DefaultOIDCUsernameClaim,
+		OIDCGroupsClaim:                      DefaultOIDCGroupsClaim,
+		OIDCAdminGroups:                      make([]string, 0),
+		OIDCTierGroups:                       make(map[string]string),
 		MessageLimit:                         DefaultMessageLengthLimit,
 		MinDelay:                             DefaultMinDelay,
 		MaxDelay:                             DefaultMaxDelay,
 		TotalTopicLimit:                      DefaultTotalTopicLimit,
 		TotalAttachmentSizeLimit:             0,
 		VisitorSubscriptionLimit:             DefaultVisitorSubscriptionLimit,
+		VisitorScheduleLimit:                 DefaultVisitorScheduleLimit,
 		VisitorAttachmentTotalSizeLimit:      DefaultVisitorAttachmentTotalSizeLimit,
 		VisitorAttachmentDailyBandwidthLimit: DefaultVisitorAttachmentDailyBandwidthLimit,
 		VisitorRequestLimitBurst:             DefaultVisitorRequestLimitBurst,
@@ -239,6 +310,8 @@ func NewConfig() *Config {
 		EnableSignup:                         false,
 		EnableLogin:                          false,
 		EnableReservations:                   false,
+		EnableWebhooks:                       false,
+		WebhookAllowedIPAddrs:                make([]netip.Prefix, 0),
 		AccessControlAllowOrigin:             "*",
 		Version:                              "",
 		WebPushPrivateKey:                    "",
@@ -81,7 +81,6 @@ var (
 	errHTTPBadRequest                                = &errHTTP{40000, http.StatusBadRequest, "invalid request", "", nil}
 	errHTTPBadRequestEmailDisabled                   = &errHTTP{40001, http.StatusBadRequest, "e-mail notifications are not enabled", "https://ntfy.sh/docs/config/#e-mail-notifications", nil}
 	errHTTPBadRequestDelayNoCache                    = &errHTTP{40002, http.StatusBadRequest, "cannot disable cache for delayed message", "", nil}
-	errHTTPBadRequestDelayNoEmail                    = &errHTTP{40003, http.StatusBadRequest, "delayed e-mail notifications are not supported", "", nil}
 	errHTTPBadRequestDelayCannotParse                = &errHTTP{40004, http.StatusBadRequest, "invalid delay parameter: unable to parse delay", "https://ntfy.sh/docs/publish/#scheduled-delivery", nil}
 	errHTTPBadRequestDelayTooSmall                   = &errHTTP{40005, http.StatusBadRequest, "invalid delay parameter: too small, please refer to the docs", "https://ntfy.sh/docs/publish/#scheduled-delivery", nil}
 	errHTTPBadRequestDelayTooLarge                   = &errHTTP{40006, http.StatusBadRequest, "invalid delay parameter: too large, please refer to the docs", "https://ntfy.sh/docs/publish/#scheduled-delivery", nil}
@@ -113,21 +112,56 @@ var (
 	errHTTPBadRequestPhoneNumberNotVerified          = &errHTTP{40034, http.StatusBadRequest, "invalid request: phone number not verified, or no matching verified numbers found", "https://ntfy.sh/docs/publish/#phone-calls", nil}
 	errHTTPBadRequestAnonymousCallsNotAllowed        = &errHTTP{40035, http.StatusBadRequest, "invalid request: anonymous phone calls are not allowed", "https://ntfy.sh/docs/publish/#phone-calls", nil}
 	errHTTPBadRequestPhoneNumberVerifyChannelInvalid = &errHTTP{40036, http.StatusBadRequest, "invalid request: verification channel must be 'sms' or 'call'", "https://ntfy.sh/docs/publish/#phone-calls", nil}
-	errHTTPBadRequestDelayNoCall                     = &errHTTP{40037, http.StatusBadRequest, "delayed call notifications are not supported", "", nil}
 	errHTTPBadRequestWebPushSubscriptionInvalid      = &errHTTP{40038, http.StatusBadRequest, "invalid request: web push payload malformed", "", nil}
 	errHTTPBadRequestWebPushEndpointUnknown          = &errHTTP{40039, http.StatusBadRequest, "invalid request: web push endpoint unknown", "", nil}
 	errHTTPBadRequestWebPushTopicCountTooHigh        = &errHTTP{40040, http.StatusBadRequest, "invalid request: too many web push topic subscriptions", "", nil}
+	errHTTPBadRequestReplacesInvalid                 = &errHTTP{40041, http.StatusBadRequest, "invalid request: replaced message ID invalid", "https://ntfy.sh/docs/publish/#updating-deleting-messages", nil}
+	errHTTPBadRequestDelayNoReplaces                 = &errHTTP{40042, http.StatusBadRequest, "delayed message updates are not supported", "https://ntfy.sh/docs/publish/#updating-deleting-messages", nil}
+	errHTTPBadRequestSearchQueryMissing              = &errHTTP{40043, http.StatusBadRequest, "invalid request: search query missing", "https://ntfy.sh/docs/subscribe/api/#search-messages", nil}
+	errHTTPBadRequestPaginationInvalid               = &errHTTP{40044, http.StatusBadRequest, "invalid request: limit or offset invalid", "", nil}
+	errHTTPBadRequestCursorInvalid                   = &errHTTP{40045, http.StatusBadRequest, "invalid request: before/after must be the ID of a cached message, and cannot be combined", "https://ntfy.sh/docs/subscribe/api/#fetch-message-history", nil}
+	errHTTPBadRequestRetentionInvalid                = &errHTTP{40046, http.StatusBadRequest, "invalid request: retention max_age and max_messages must not be negative", "https://ntfy.sh/docs/config/#topic-retention", nil}
+	errHTTPBadRequestScheduleInvalid                 = &errHTTP{40047, http.StatusBadRequest, "invalid request: schedule invalid", "https://ntfy.sh/docs/publish/#recurring-messages", nil}
+	errHTTPBadRequestScheduleNotAllowed              = &errHTTP{40048, http.StatusBadRequest, "invalid request: recurring messages cannot be combined with cache=no, delays, e-mails, phone calls, updates, file uploads or UnifiedPush", "https://ntfy.sh/docs/publish/#recurring-messages", nil}
+	errHTTPBadRequestEscalationInvalid               = &errHTTP{40049, http.StatusBadRequest, "invalid request: escalation policy invalid", "https://ntfy.sh/docs/config/#escalation-policies", nil}
+	errHTTPBadRequestOIDCStateInvalid                = &errHTTP{40050, http.StatusBadRequest, "invalid request: login state missing or expired, please try logging in again", "https://ntfy.sh/docs/config/#openid-connect-oidc", nil}
+	errHTTPBadRequestGroupNotFound                   = &errHTTP{40051, http.StatusBadRequest, "invalid request: group does not exist", "https://ntfy.sh/docs/config/#groups", nil}
+	errHTTPBadRequestTokenScopeInvalid               = &errHTTP{40052, http.StatusBadRequest, "invalid request: token scope invalid", "https://ntfy.sh/docs/config/#access-tokens", nil}
+	errHTTPBadRequestSignedURLInvalid                = &errHTTP{40053, http.StatusBadRequest, "invalid request: expiry time must be in the future and within the allowed maximum, and parameters sig, exp, uid and gen are reserved", "https://ntfy.sh/docs/publish/#signed-publish-urls", nil}
+	errHTTPBadRequestTwoFactorCodeInvalid            = &errHTTP{40054, http.StatusBadRequest, "invalid request: two-factor authentication code invalid", "https://ntfy.sh/docs/config/#two-factor-authentication", nil}
+	errHTTPBadRequestTwoFactorNotSetUp               = &errHTTP{40055, http.StatusBadRequest, "invalid request: two-factor authentication is not set up", "https://ntfy.sh/docs/config/#two-factor-authentication", nil}
+	errHTTPBadRequestAuditFilterInvalid              = &errHTTP{40056, http.StatusBadRequest, "invalid request: audit log filter invalid", "https://ntfy.sh/docs/config/#audit-log", nil}
+	errHTTPBadRequestPasswordPolicy                  = &errHTTP{40057, http.StatusBadRequest, "invalid request: password does not meet the password policy", "https://ntfy.sh/docs/config/#password-policy-and-account-lockout", nil}
+	errHTTPBadRequestGotifyMessageInvalid            = &errHTTP{40058, http.StatusBadRequest, "invalid request: Gotify message invalid", "https://ntfy.sh/docs/config/#gotify-api", nil}
+	errHTTPBadRequestAlertmanagerMessageInvalid      = &errHTTP{40059, http.StatusBadRequest, "invalid request: Alertmanager webhook JSON invalid", "https://ntfy.sh/docs/publish/#alertmanager-webhook", nil}
+	errHTTPBadRequestTemplateInvalid                 = &errHTTP{40060, http.StatusBadRequest, "invalid request: message template invalid", "https://ntfy.sh/docs/publish/#message-templating", nil}
+	errHTTPBadRequestWebhookInvalid                  = &errHTTP{40061, http.StatusBadRequest, "invalid request: webhook invalid", "https://ntfy.sh/docs/config/#outbound-webhooks", nil}
 	errHTTPNotFound                                  = &errHTTP{40401, http.StatusNotFound, "page not found", "", nil}
+	errHTTPNotFoundSchedule                          = &errHTTP{40402, http.StatusNotFound, "not found: recurring message does not exist", "https://ntfy.sh/docs/publish/#recurring-messages", nil}
+	errHTTPNotFoundScheduledMessage                  = &errHTTP{40403, http.StatusNotFound, "not found: scheduled message does not exist or was already published", "https://ntfy.sh/docs/publish/#scheduled-delivery", nil}
+	errHTTPNotFoundMessage                           = &errHTTP{40404, http.StatusNotFound, "not found: message does not exist or cannot be acknowledged", "https://ntfy.sh/docs/publish/#acknowledge-message", nil}
+	errHTTPNotFoundWebhook                           = &errHTTP{40405, http.StatusNotFound, "not found: webhook does not exist", "https://ntfy.sh/docs/config/#outbound-webhooks", nil}
+	errHTTPNotFoundMessageDelete                     = &errHTTP{40406, http.StatusNotFound, "not found: message does not exist in this topic", "https://ntfy.sh/docs/publish/#updating-deleting-messages", nil}
 	errHTTPUnauthorized                              = &errHTTP{40101, http.StatusUnauthorized, "unauthorized", "https://ntfy.sh/docs/publish/#authentication", nil}
+	errHTTPUnauthorizedTwoFactorCodeRequired         = &errHTTP{40102, http.StatusUnauthorized, "unauthorized: two-factor authentication code missing or invalid", "https://ntfy.sh/docs/config/#two-factor-authentication", nil}
+	errHTTPUnauthorizedTwoFactorTokenRequired        = &errHTTP{40103, http.StatusUnauthorized, "unauthorized: two-factor authentication is enabled, please use an access token", "https://ntfy.sh/docs/config/#two-factor-authentication", nil}
+	errHTTPUnauthorizedGotifyToken                   = &errHTTP{40104, http.StatusUnauthorized, "unauthorized: Gotify application token missing or unknown", "https://ntfy.sh/docs/config/#gotify-api", nil}
 	errHTTPForbidden                                 = &errHTTP{40301, http.StatusForbidden, "forbidden", "https://ntfy.sh/docs/publish/#authentication", nil}
+	errHTTPForbiddenScopedToken                      = &errHTTP{40302, http.StatusForbidden, "forbidden: scoped access tokens can only be used to publish and subscribe", "https://ntfy.sh/docs/config/#access-tokens", nil}
+	errHTTPForbiddenSignedURLInvalid                 = &errHTTP{40303, http.StatusForbidden, "forbidden: signed URL invalid or expired", "https://ntfy.sh/docs/publish/#signed-publish-urls", nil}
+	errHTTPForbiddenTwoFactorRequired                = &errHTTP{40304, http.StatusForbidden, "forbidden: two-factor authentication is required for admins", "https://ntfy.sh/docs/config/#two-factor-authentication", nil}
+	errHTTPForbiddenTemplateInline                   = &errHTTP{40305, http.StatusForbidden, "forbidden: inline templates are only allowed for authenticated users, use a named template instead", "https://ntfy.sh/docs/publish/#message-templating", nil}
 	errHTTPConflictUserExists                        = &errHTTP{40901, http.StatusConflict, "conflict: user already exists", "", nil}
 	errHTTPConflictTopicReserved                     = &errHTTP{40902, http.StatusConflict, "conflict: access control entry for topic or topic pattern already exists", "", nil}
 	errHTTPConflictSubscriptionExists                = &errHTTP{40903, http.StatusConflict, "conflict: topic subscription already exists", "", nil}
 	errHTTPConflictPhoneNumberExists                 = &errHTTP{40904, http.StatusConflict, "conflict: phone number already exists", "", nil}
+	errHTTPConflictGroupExists                       = &errHTTP{40905, http.StatusConflict, "conflict: group already exists", "", nil}
+	errHTTPConflictTwoFactorEnabled                  = &errHTTP{40906, http.StatusConflict, "conflict: two-factor authentication already enabled", "", nil}
 	errHTTPGonePhoneVerificationExpired              = &errHTTP{41001, http.StatusGone, "phone number verification expired or does not exist", "", nil}
 	errHTTPEntityTooLargeAttachment                  = &errHTTP{41301, http.StatusRequestEntityTooLarge, "attachment too large, or bandwidth limit reached", "https://ntfy.sh/docs/publish/#limitations", nil}
 	errHTTPEntityTooLargeMatrixRequest               = &errHTTP{41302, http.StatusRequestEntityTooLarge, "Matrix request is larger than the max allowed length", "", nil}
 	errHTTPEntityTooLargeJSONBody                    = &errHTTP{41303, http.StatusRequestEntityTooLarge, "JSON body too large", "", nil}
+	errHTTPEntityTooLargeGotifyRequest               = &errHTTP{41304, http.StatusRequestEntityTooLarge, "Gotify request is larger than the max allowed length", "", nil}
 	errHTTPTooManyRequestsLimitRequests              = &errHTTP{42901, http.StatusTooManyRequests, "limit reached: too many requests", "https://ntfy.sh/docs/publish/#limitations", nil}
 	errHTTPTooManyRequestsLimitEmails                = &errHTTP{42902, http.StatusTooManyRequests, "limit reached: too many emails", "https://ntfy.sh/docs/publish/#limitations", nil}
 	errHTTPTooManyRequestsLimitSubscriptions         = &errHTTP{42903, http.StatusTooManyRequests, "limit reached: too many active subscriptions", "https://ntfy.sh/docs/publish/#limitations", nil}
@@ -138,6 +172,8 @@ var (
 	errHTTPTooManyRequestsLimitMessages              = &errHTTP{42908, http.StatusTooManyRequests, "limit reached: daily message quota reached", "https://ntfy.sh/docs/publish/#limitations", nil}
 	errHTTPTooManyRequestsLimitAuthFailure           = &errHTTP{42909, http.StatusTooManyRequests, "limit reached: too many auth failures", "https://ntfy.sh/docs/publish/#limitations", nil} // FIXME document limit
 	errHTTPTooManyRequestsLimitCalls                 = &errHTTP{42910, http.StatusTooManyRequests, "limit reached: daily phone call quota reached", "https://ntfy.sh/docs/publish/#limitations", nil}
+	errHTTPTooManyRequestsLimitSchedules             = &errHTTP{42911, http.StatusTooManyRequests, "limit reached: too many recurring messages for this topic", "https://ntfy.sh/docs/publish/#recurring-messages", nil}
+	errHTTPTooManyRequestsLimitVisitorSchedules      = &errHTTP{42912, http.StatusTooManyRequests, "limit reached: too many recurring messages for this user", "https://ntfy.sh/docs/publish/#recurring-messages", nil}
 	errHTTPInternalError                             = &errHTTP{50001, http.StatusInternalServerError, "internal server error", "", nil}
 	errHTTPInternalErrorInvalidPath                  = &errHTTP{50002, http.StatusInternalServerError, "internal server error: invalid path", "", nil}
 	errHTTPInternalErrorMissingBaseURL               = &errHTTP{50003, http.StatusInternalServerError, "internal server error: base-url must be be configured for this feature", "https://ntfy.sh/docs/config/", nil}
@@ -30,6 +30,11 @@ const (
 	tagWebsocket    = "websocket"
 	tagMatrix       = "matrix"
 	tagWebPush      = "webpush"
+	tagEscalation   = "escalation"
+	tagMQTT         = "mqtt"
+	tagGotify       = "gotify"
+	tagAlertmanager = "alertmanager"
+	tagWebhook      = "webhook"
 )
 
 var (
@@ -71,6 +76,18 @@ func logem(smtpConn *smtp.Conn) *log.Event {
 	return ev
 }
 
+// logmq creates a new log event with MQTT connection fields, and visitor fields if the client is connected
+func logmq(s *mqttSession) *log.Event {
+	ev := log.Tag(tagMQTT).Fields(log.Context{
+		"mqtt_remote_addr": s.conn.RemoteAddr().String(),
+		"mqtt_client_id":   s.clientID,
+	})
+	if s.visitor != nil {
+		ev.With(s.visitor)
+	}
+	return ev
+}
+
 func httpContext(r *http.Request) log.Context {
 	requestURI := r.RequestURI
 	if requestURI == "" {
@@ -6,7 +6,9 @@ import (
 	"errors"
 	"fmt"
 	"net/netip"
+	"slices"
 	"strings"
+	"sync"
 	"time"
 
 	_ "github.com/mattn/go-sqlite3" // SQLite driver
@@ -18,6 +20,7 @@ var (
 	errUnexpectedMessageType = errors.New("unexpected message type")
 	errMessageNotFound       = errors.New("message not found")
 	errNoRows                = errors.New("no rows found")
+	errScheduleNotFound      = errors.New("schedule not found")
 )
 
 // Messages cache
@@ -47,9 +50,15 @@ const (
 			user TEXT NOT NULL,
 			content_type TEXT NOT NULL,
 			encoding TEXT NOT NULL,
-			published INT NOT NULL
+			published INT NOT NULL,
+			event TEXT NOT NULL,
+			replaces TEXT NOT NULL,
+			superseded INT NOT NULL,
+			email TEXT NOT NULL,
+			call TEXT NOT NULL
 		);
 		CREATE INDEX IF NOT EXISTS idx_mid ON messages (mid);
+		CREATE INDEX IF NOT EXISTS idx_replaces ON messages (replaces);
 		CREATE INDEX IF NOT EXISTS idx_time ON messages (time);
 		CREATE INDEX IF NOT EXISTS idx_topic ON messages (topic);
 		CREATE INDEX IF NOT EXISTS idx_expires ON messages (expires);
@@ -61,52 +70,145 @@ const (
 			value INT
 		);
 		INSERT INTO stats (key, value) VALUES ('messages', 0);
+		CREATE TABLE IF NOT EXISTS schedules (
+			id TEXT PRIMARY KEY,
+			topic TEXT NOT NULL,
+			spec TEXT NOT NULL,
+			message TEXT NOT NULL,
+			sender TEXT NOT NULL,
+			user TEXT NOT NULL,
+			paused INT NOT NULL,
+			next INT NOT NULL,
+			last INT NOT NULL,
+			created INT NOT NULL
+		);
+		CREATE INDEX IF NOT EXISTS idx_schedules_topic ON schedules (topic);
+		CREATE INDEX IF NOT EXISTS idx_schedules_next ON schedules (next);
+		CREATE TABLE IF NOT EXISTS acks (
+			mid TEXT NOT NULL,
+			topic TEXT NOT NULL,
+			username TEXT NOT NULL,
+			token TEXT NOT NULL,
+			sender TEXT NOT NULL,
+			time INT NOT NULL
+		);
+		CREATE INDEX IF NOT EXISTS idx_acks_mid ON acks (mid);
+		CREATE INDEX IF NOT EXISTS idx_acks_topic ON acks (topic);
+		CREATE TABLE IF NOT EXISTS escalations (
+			mid TEXT PRIMARY KEY,
+			topic TEXT NOT NULL,
+			step INT NOT NULL,
+			next INT NOT NULL
+		);
+		CREATE INDEX IF NOT EXISTS idx_escalations_next ON escalations (next);
+		CREATE TABLE IF NOT EXISTS webhook_deliveries (
+			id TEXT PRIMARY KEY,
+			webhook_id TEXT NOT NULL,
+			topic TEXT NOT NULL,
+			mid TEXT NOT NULL,
+			payload TEXT NOT NULL,
+			status TEXT NOT NULL,
+			attempts INT NOT NULL,
+			next INT NOT NULL,
+			status_code INT NOT NULL,
+			error TEXT NOT NULL,
+			updated INT NOT NULL
+		);
+		CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_status_next ON webhook_deliveries (status, next);
+		CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_webhook_id ON webhook_deliveries (webhook_id);
 		COMMIT;
 	`
 	insertMessageQuery = `
-		INSERT INTO messages (mid, time, expires, topic, message, title, priority, tags, click, icon, actions, attachment_name, attachment_type, attachment_size, attachment_expires, attachment_url, attachment_deleted, sender, user, content_type, encoding, published)
-		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		INSERT INTO messages (mid, time, expires, topic, message, title, priority, tags, click, icon, actions, attachment_name, attachment_type, attachment_size, attachment_expires, attachment_url, attachment_deleted, sender, user, content_type, encoding, published, event, replaces, superseded, email, call)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, 0, ?, ?)
+	`
+	updateMessagesForTopicExpiryOlderThanQuery = `
+		UPDATE messages
+		SET expires = ?1
+		WHERE topic = ?2 AND time < ?3 AND published = 1 AND expires > ?1
+	`
+	updateMessagesForTopicExpiryExceedingCountQuery = `
+		UPDATE messages
+		SET expires = ?1
+		WHERE topic = ?2 AND published = 1 AND expires > ?1 AND id NOT IN (
+			SELECT id
+			FROM messages
+			WHERE topic = ?2 AND published = 1 AND superseded = 0 AND expires > ?1
+			ORDER BY time DESC, id DESC
+			LIMIT ?3
+		)
 	`
 	deleteMessageQuery                = `DELETE FROM messages WHERE mid = ?`
+	deleteScheduledMessageQuery       = `DELETE FROM messages WHERE topic = ? AND mid = ? AND published = 0`
 	updateMessagesForTopicExpiryQuery = `UPDATE messages SET expires = ? WHERE topic = ?`
 	selectRowIDFromMessageID          = `SELECT id FROM messages WHERE mid = ?` // Do not include topic, see #336 and TestServer_PollSinceID_MultipleTopics
 	selectMessagesByIDQuery           = `
-		SELECT mid, time, expires, topic, message, title, priority, tags, click, icon, actions, attachment_name, attachment_type, attachment_size, attachment_expires, attachment_url, sender, user, content_type, encoding
+		SELECT mid, time, expires, topic, message, title, priority, tags, click, icon, actions, attachment_name, attachment_type, attachment_size, attachment_expires, attachment_url, sender, user, content_type, encoding, event, replaces, email, call
 		FROM messages 
 		WHERE mid = ?
 	`
 	selectMessagesSinceTimeQuery = `
-		SELECT mid, time, expires, topic, message, title, priority, tags, click, icon, actions, attachment_name, attachment_type, attachment_size, attachment_expires, attachment_url, sender, user, content_type, encoding
+		SELECT mid, time, expires, topic, message, title, priority, tags, click, icon, actions, attachment_name, attachment_type, attachment_size, attachment_expires, attachment_url, sender, user, content_type, encoding, event, replaces, email, call
 		FROM messages 
-		WHERE topic = ? AND time >= ? AND published = 1
+		WHERE topic = ? AND time >= ? AND published = 1 AND superseded = 0
 		ORDER BY time, id
 	`
 	selectMessagesSinceTimeIncludeScheduledQuery = `
-		SELECT mid, time, expires, topic, message, title, priority, tags, click, icon, actions, attachment_name, attachment_type, attachment_size, attachment_expires, attachment_url, sender, user, content_type, encoding
+		SELECT mid, time, expires, topic, message, title, priority, tags, click, icon, actions, attachment_name, attachment_type, attachment_size, attachment_expires, attachment_url, sender, user, content_type, encoding, event, replaces, email, call
 		FROM messages 
-		WHERE topic = ? AND time >= ?
+		WHERE topic = ? AND time >= ? AND superseded = 0
 		ORDER BY time, id
 	`
 	selectMessagesSinceIDQuery = `
-		SELECT mid, time, expires, topic, message, title, priority, tags, click, icon, actions, attachment_name, attachment_type, attachment_size, attachment_expires, attachment_url, sender, user, content_type, encoding
+		SELECT mid, time, expires, topic, message, title, priority, tags, click, icon, actions, attachment_name, attachment_type, attachment_size, attachment_expires, attachment_url, sender, user, content_type, encoding, event, replaces, email, call
 		FROM messages 
-		WHERE topic = ? AND id > ? AND published = 1 
+		WHERE topic = ? AND id > ? AND published = 1 AND superseded = 0
 		ORDER BY time, id
 	`
 	selectMessagesSinceIDIncludeScheduledQuery = `
-		SELECT mid, time, expires, topic, message, title, priority, tags, click, icon, actions, attachment_name, attachment_type, attachment_size, attachment_expires, attachment_url, sender, user, content_type, encoding
+		SELECT mid, time, expires, topic, message, title, priority, tags, click, icon, actions, attachment_name, attachment_type, attachment_size, attachment_expires, attachment_url, sender, user, content_type, encoding, event, replaces, email, call
 		FROM messages 
-		WHERE topic = ? AND (id > ? OR published = 0)
+		WHERE topic = ? AND (id > ? OR published = 0) AND superseded = 0
 		ORDER BY time, id
 	`
 	selectMessagesDueQuery = `
-		SELECT mid, time, expires, topic, message, title, priority, tags, click, icon, actions, attachment_name, attachment_type, attachment_size, attachment_expires, attachment_url, sender, user, content_type, encoding
+		SELECT mid, time, expires, topic, message, title, priority, tags, click, icon, actions, attachment_name, attachment_type, attachment_size, attachment_expires, attachment_url, sender, user, content_type, encoding, event, replaces, email, call
 		FROM messages 
-		WHERE time <= ? AND published = 0
+		WHERE time <= ? AND published = 0 AND superseded = 0
+		ORDER BY time, id
+	`
+	selectMessagesScheduledQuery = `
+		SELECT mid, time, expires, topic, message, title, priority, tags, click, icon, actions, attachment_name, attachment_type, attachment_size, attachment_expires, attachment_url, sender, user, content_type, encoding, event, replaces, email, call
+		FROM messages
+		WHERE topic = ? AND published = 0 AND superseded = 0
 		ORDER BY time, id
 	`
-	selectMessagesExpiredQuery      = `SELECT mid FROM messages WHERE expires <= ? AND published = 1`
-	updateMessagePublishedQuery     = `UPDATE messages SET published = 1 WHERE mid = ?`
+	selectMessagesLatestQuery = `
+		SELECT mid, time, expires, topic, message, title, priority, tags, click, icon, actions, attachment_name, attachment_type, attachment_size, attachment_expires, attachment_url, sender, user, content_type, encoding, event, replaces, email, call
+		FROM messages
+		WHERE topic = ?1 AND published = 1 AND superseded = 0
+		ORDER BY time DESC, id DESC
+		LIMIT ?2
+	`
+	selectMessagesBeforeQuery = `
+		SELECT mid, time, expires, topic, message, title, priority, tags, click, icon, actions, attachment_name, attachment_type, attachment_size, attachment_expires, attachment_url, sender, user, content_type, encoding, event, replaces, email, call
+		FROM messages
+		WHERE topic = ?1 AND (time < ?2 OR (time = ?2 AND id < ?3)) AND published = 1 AND superseded = 0
+		ORDER BY time DESC, id DESC
+		LIMIT ?4
+	`
+	selectMessagesAfterQuery = `
+		SELECT mid, time, expires, topic, message, title, priority, tags, click, icon, actions, attachment_name, attachment_type, attachment_size, attachment_expires, attachment_url, sender, user, content_type, encoding, event, replaces, email, call
+		FROM messages
+		WHERE topic = ?1 AND (time > ?2 OR (time = ?2 AND id > ?3)) AND published = 1 AND superseded = 0
+		ORDER BY time, id
+		LIMIT ?4
+	`
+	selectRowIDAndTimeFromMessageIDQuery = `SELECT id, time FROM messages WHERE topic = ? AND mid = ?`
+
+	selectMessagesExpiredQuery      = `SELECT mid FROM messages WHERE ((expires > 0 AND expires <= ?) OR (expires = 0 AND time <= ?)) AND published = 1`
+	updateMessagePublishedQuery     = `UPDATE messages SET published = 1, email = '', call = '' WHERE mid = ?`
+	updateMessagesSupersededQuery   = `UPDATE messages SET superseded = 1 WHERE topic = ? AND (mid = ? OR replaces = ?)`
 	selectMessagesCountQuery        = `SELECT COUNT(*) FROM messages`
 	selectMessageCountPerTopicQuery = `SELECT topic, COUNT(*) FROM messages GROUP BY topic`
 	selectTopicsQuery               = `SELECT topic FROM messages GROUP BY topic`
@@ -120,9 +222,121 @@ const (
 	updateStatsQuery = `UPDATE stats SET value = ? WHERE key = 'messages'`
 )
 
+// Schedules (recurring messages)
+const (
+	insertScheduleQuery = `
+		INSERT INTO schedules (id, topic, spec, message, sender, user, paused, next, last, created)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`
+	selectSchedulesByTopicQuery = `
+		SELECT id, topic, spec, message, sender, user, paused, next, last, created
+		FROM schedules
+		WHERE topic = ?
+		ORDER BY created, id
+	`
+	selectScheduleByIDQuery = `
+		SELECT id, topic, spec, message, sender, user, paused, next, last, created
+		FROM schedules
+		WHERE id = ?
+	`
+	selectSchedulesDueQuery = `
+		SELECT id, topic, spec, message, sender, user, paused, next, last, created
+		FROM schedules
+		WHERE next <= ? AND paused = 0
+		ORDER BY next, id
+	`
+	updateScheduleRunQuery           = `UPDATE schedules SET last = ?, next = ? WHERE id = ?`
+	updateSchedulePausedQuery        = `UPDATE schedules SET paused = ?, next = ? WHERE id = ?`
+	deleteScheduleQuery              = `DELETE FROM schedules WHERE id = ?`
+	selectScheduleCountByUserQuery   = `SELECT COUNT(*) FROM schedules WHERE user = ?`
+	selectScheduleCountBySenderQuery = `SELECT COUNT(*) FROM schedules WHERE user = '' AND sender = ?`
+)
+
+// Acknowledgements
+const (
+	insertAckQuery             = `INSERT INTO acks (mid, topic, username, token, sender, time) VALUES (?, ?, ?, ?, ?, ?)`
+	selectAcksByMessageIDQuery = `SELECT mid, topic, username, token, sender, time FROM acks WHERE mid = ? ORDER BY time`
+	deleteAcksQuery            = `DELETE FROM acks WHERE mid = ?`
+)
+
+// Escalations
+const (
+	insertEscalationQuery     = `INSERT INTO escalations (mid, topic, step, next) VALUES (?, ?, ?, ?)`
+	selectEscalationsDueQuery = `SELECT mid, topic, step, next FROM escalations WHERE next <= ? ORDER BY next, mid`
+	updateEscalationQuery     = `UPDATE escalations SET step = ?, next = ? WHERE mid = ?`
+	deleteEscalationQuery     = `DELETE FROM escalations WHERE mid = ?`
+)
+
+// Webhook deliveries
+const (
+	insertWebhookDeliveryQuery = `
+		INSERT INTO webhook_deliveries (id, webhook_id, topic, mid, payload, status, attempts, next, status_code, error, updated)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`
+	selectWebhookDeliveriesDueQuery = `
+		SELECT id, webhook_id, topic, mid, payload, status, attempts, next, status_code, error, updated
+		FROM webhook_deliveries
+		WHERE status = 'pending' AND next <= ?
+		ORDER BY next, id
+		LIMIT ?
+	`
+	selectWebhookDeliveriesQuery = `
+		SELECT id, webhook_id, topic, mid, payload, status, attempts, next, status_code, error, updated
+		FROM webhook_deliveries
+		WHERE webhook_id = ?
+		ORDER BY updated DESC, id DESC
+		LIMIT ?
+	`
+	updateWebhookDeliveryQuery                = `UPDATE webhook_deliveries SET status = ?, attempts = ?, next = ?, status_code = ?, error = ?, updated = ? WHERE id = ?`
+	updateWebhookDeliveryNextQuery            = `UPDATE webhook_deliveries SET next = ? WHERE id = ? AND status = 'pending' AND next = ?`
+	deleteWebhookDeliveriesQuery              = `DELETE FROM webhook_deliveries WHERE webhook_id = ?`
+	deleteWebhookDeliveriesUpdatedBeforeQuery = `DELETE FROM webhook_deliveries WHERE status != 'pending' AND updated < ?`
+)
+
+// Search index queries
+//
+// The full-text search index is an FTS5 table, which is only available if go-sqlite3 is built with the "sqlite_fts5"
+// build tag. It is not part of the versioned schema, so that the same cache file can be used with and without FTS5
+// support. If FTS5 is not available, search falls back to a (much slower) LIKE query.
+const (
+	selectSearchIndexAvailableQuery = `SELECT sqlite_compileoption_used('ENABLE_FTS5')`
+	selectSearchIndexTriggerQuery   = `SELECT COUNT(*) FROM sqlite_master WHERE type = 'trigger' AND name = 'messages_fts_insert'`
+	createSearchIndexTableQuery     = `
+		CREATE VIRTUAL TABLE IF NOT EXISTS messages_fts USING fts5(title, message, tags, content='messages', content_rowid='id');
+	`
+	createSearchIndexTriggersQuery = `
+		CREATE TRIGGER IF NOT EXISTS messages_fts_insert AFTER INSERT ON messages BEGIN
+			INSERT INTO messages_fts (rowid, title, message, tags) VALUES (new.id, new.title, new.message, new.tags);
+		END;
+		CREATE TRIGGER IF NOT EXISTS messages_fts_delete AFTER DELETE ON messages BEGIN
+			INSERT INTO messages_fts (messages_fts, rowid, title, message, tags) VALUES ('delete', old.id, old.title, old.message, old.tags);
+		END;
+	`
+	dropSearchIndexTriggersQuery = `
+		DROP TRIGGER IF EXISTS messages_fts_insert;
+		DROP TRIGGER IF EXISTS messages_fts_delete;
+	`
+	rebuildSearchIndexQuery   = `INSERT INTO messages_fts (messages_fts) VALUES ('rebuild')`
+	selectMessagesSearchQuery = `
+		SELECT messages.mid, messages.time, messages.expires, messages.topic, messages.message, messages.title, messages.priority, messages.tags, messages.click, messages.icon, messages.actions, messages.attachment_name, messages.attachment_type, messages.attachment_size, messages.attachment_expires, messages.attachment_url, messages.sender, messages.user, messages.content_type, messages.encoding, messages.event, messages.replaces, messages.email, messages.call
+		FROM messages_fts
+		JOIN messages ON messages.id = messages_fts.rowid
+		WHERE messages.topic = ?1 AND messages_fts MATCH ?2 AND messages.published = 1 AND messages.superseded = 0 AND messages.event != 'message_delete'
+		ORDER BY messages_fts.rank, messages.time DESC
+		LIMIT ?3 OFFSET ?4
+	`
+	selectMessagesSearchLikeQuery = `
+		SELECT mid, time, expires, topic, message, title, priority, tags, click, icon, actions, attachment_name, attachment_type, attachment_size, attachment_expires, attachment_url, sender, user, content_type, encoding, event, replaces, email, call
+		FROM messages
+		WHERE topic = ?1 AND (title LIKE ?2 ESCAPE '\' OR message LIKE ?2 ESCAPE '\' OR tags LIKE ?2 ESCAPE '\') AND published = 1 AND superseded = 0 AND event != 'message_delete'
+		ORDER BY time DESC, id DESC
+		LIMIT ?3 OFFSET ?4
+	`
+)
+
 // Schema management queries
 const (
-	currentSchemaVersion          = 12
+	currentSchemaVersion          = 18
 	createSchemaVersionTableQuery = `
 		CREATE TABLE IF NOT EXISTS schemaVersion (
 			id INT PRIMARY KEY,
@@ -246,6 +460,82 @@ const (
 	migrate11To12AlterMessagesTableQuery = `
 		ALTER TABLE messages ADD COLUMN content_type TEXT NOT NULL DEFAULT('');
 	`
+
+	// 12 -> 13
+	migrate12To13AlterMessagesTableQuery = `
+		ALTER TABLE messages ADD COLUMN event TEXT NOT NULL DEFAULT('message');
+		ALTER TABLE messages ADD COLUMN replaces TEXT NOT NULL DEFAULT('');
+		ALTER TABLE messages ADD COLUMN superseded INT NOT NULL DEFAULT('0');
+		CREATE INDEX IF NOT EXISTS idx_replaces ON messages (replaces);
+	`
+
+	// 13 -> 14
+	migrate13To14CreateSchedulesTableQuery = `
+		CREATE TABLE IF NOT EXISTS schedules (
+			id TEXT PRIMARY KEY,
+			topic TEXT NOT NULL,
+			spec TEXT NOT NULL,
+			message TEXT NOT NULL,
+			sender TEXT NOT NULL,
+			user TEXT NOT NULL,
+			paused INT NOT NULL,
+			next INT NOT NULL,
+			last INT NOT NULL,
+			created INT NOT NULL
+		);
+		CREATE INDEX IF NOT EXISTS idx_schedules_topic ON schedules (topic);
+		CREATE INDEX IF NOT EXISTS idx_schedules_next ON schedules (next);
+	`
+
+	// 14 -> 15
+	migrate14To15AlterMessagesTableQuery = `
+		ALTER TABLE messages ADD COLUMN email TEXT NOT NULL DEFAULT('');
+		ALTER TABLE messages ADD COLUMN call TEXT NOT NULL DEFAULT('');
+	`
+
+	// 15 -> 16
+	migrate15To16CreateAcksTableQuery = `
+		CREATE TABLE IF NOT EXISTS acks (
+			mid TEXT NOT NULL,
+			topic TEXT NOT NULL,
+			username TEXT NOT NULL,
+			token TEXT NOT NULL,
+			sender TEXT NOT NULL,
+			time INT NOT NULL
+		);
+		CREATE INDEX IF NOT EXISTS idx_acks_mid ON acks (mid);
+		CREATE INDEX IF NOT EXISTS idx_acks_topic ON acks (topic);
+	`
+
+	// 16 -> 17
+	migrate16To17CreateEscalationsTableQuery = `
+		CREATE TABLE IF NOT EXISTS escalations (
+			mid TEXT PRIMARY KEY,
+			topic TEXT NOT NULL,
+			step INT NOT NULL,
+			next INT NOT NULL
+		);
+		CREATE INDEX IF NOT EXISTS idx_escalations_next ON escalations (next);
+	`
+
+	// 17 -> 18
+	migrate17To18CreateWebhookDeliveriesTableQuery = `
+		CREATE TABLE IF NOT EXISTS webhook_deliveries (
+			id TEXT PRIMARY KEY,
+			webhook_id TEXT NOT NULL,
+			topic TEXT NOT NULL,
+			mid TEXT NOT NULL,
+			payload TEXT NOT NULL,
+			status TEXT NOT NULL,
+			attempts INT NOT NULL,
+			next INT NOT NULL,
+			status_code INT NOT NULL,
+			error TEXT NOT NULL,
+			updated INT NOT NULL
+		);
+		CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_status_next ON webhook_deliveries (status, next);
+		CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_webhook_id ON webhook_deliveries (webhook_id);
+	`
 )
 
 var (
@@ -262,13 +552,147 @@ var (
 		9:  migrateFrom9,
 		10: migrateFrom10,
 		11: migrateFrom11,
+		12: migrateFrom12,
+		13: migrateFrom13,
+		14: migrateFrom14,
+		15: migrateFrom15,
+		16: migrateFrom16,
+		17: migrateFrom17,
 	}
 )
 
+// messageCacheQueries holds all queries used by the messageCache. Since the SQL dialects of the
+// supported database backends (SQLite, PostgreSQL) differ, each backend brings its own set of queries.
+type messageCacheQueries struct {
+	insertMessage                           string
+	deleteMessage                           string
+	deleteScheduledMessage                  string
+	updateMessagesForTopicExpiry            string
+	updateMessagesForTopicExpiryOlderThan   string
+	updateMessagesForTopicExpiryExceeding   string
+	selectRowIDFromMessageID                string
+	selectMessagesByID                      string
+	selectMessagesSinceTime                 string
+	selectMessagesSinceTimeIncludeScheduled string
+	selectMessagesSinceID                   string
+	selectMessagesSinceIDIncludeScheduled   string
+	selectMessagesDue                       string
+	selectMessagesScheduled                 string
+	selectMessagesLatest                    string
+	selectMessagesBefore                    string
+	selectMessagesAfter                     string
+	selectRowIDAndTimeFromMessageID         string
+	selectMessagesExpired                   string
+	updateMessagePublished                  string
+	updateMessagesSuperseded                string
+	selectMessageCountPerTopic              string
+	selectTopics                            string
+	updateAttachmentDeleted                 string
+	selectAttachmentsExpired                string
+	selectAttachmentsSizeBySender           string
+	selectAttachmentsSizeByUserID           string
+	selectStats                             string
+	updateStats                             string
+	selectMessagesSearch                    string
+	insertSchedule                          string
+	selectSchedulesByTopic                  string
+	selectScheduleByID                      string
+	selectSchedulesDue                      string
+	updateScheduleRun                       string
+	updateSchedulePaused                    string
+	deleteSchedule                          string
+	selectScheduleCountByUser               string
+	selectScheduleCountBySender             string
+	insertAck                               string
+	selectAcksByMessageID                   string
+	deleteAcks                              string
+	insertEscalation                        string
+	selectEscalationsDue                    string
+	updateEscalation                        string
+	deleteEscalation                        string
+	insertWebhookDelivery                   string
+	selectWebhookDeliveriesDue              string
+	selectWebhookDeliveries                 string
+	updateWebhookDelivery                   string
+	updateWebhookDeliveryNext               string
+	deleteWebhookDeliveries                 string
+	deleteWebhookDeliveriesUpdatedBefore    string
+	searchTerm                              func(query string) string // Converts the user's query to the search query parameter
+}
+
+var sqliteMessageCacheQueries = &messageCacheQueries{
+	insertMessage:                           insertMessageQuery,
+	deleteMessage:                           deleteMessageQuery,
+	deleteScheduledMessage:                  deleteScheduledMessageQuery,
+	updateMessagesForTopicExpiry:            updateMessagesForTopicExpiryQuery,
+	updateMessagesForTopicExpiryOlderThan:   updateMessagesForTopicExpiryOlderThanQuery,
+	updateMessagesForTopicExpiryExceeding:   updateMessagesForTopicExpiryExceedingCountQuery,
+	selectRowIDFromMessageID:                selectRowIDFromMessageID,
+	selectMessagesByID:                      selectMessagesByIDQuery,
+	selectMessagesSinceTime:                 selectMessagesSinceTimeQuery,
+	selectMessagesSinceTimeIncludeScheduled: selectMessagesSinceTimeIncludeScheduledQuery,
+	selectMessagesSinceID:                   selectMessagesSinceIDQuery,
+	selectMessagesSinceIDIncludeScheduled:   selectMessagesSinceIDIncludeScheduledQuery,
+	selectMessagesDue:                       selectMessagesDueQuery,
+	selectMessagesScheduled:                 selectMessagesScheduledQuery,
+	selectMessagesLatest:                    selectMessagesLatestQuery,
+	selectMessagesBefore:                    selectMessagesBeforeQuery,
+	selectMessagesAfter:                     selectMessagesAfterQuery,
+	selectRowIDAndTimeFromMessageID:         selectRowIDAndTimeFromMessageIDQuery,
+	selectMessagesExpired:                   selectMessagesExpiredQuery,
+	updateMessagePublished:                  updateMessagePublishedQuery,
+	updateMessagesSuperseded:                updateMessagesSupersededQuery,
+	selectMessageCountPerTopic:              selectMessageCountPerTopicQuery,
+	selectTopics:                            selectTopicsQuery,
+	updateAttachmentDeleted:                 updateAttachmentDeleted,
+	selectAttachmentsExpired:                selectAttachmentsExpiredQuery,
+	selectAttachmentsSizeBySender:           selectAttachmentsSizeBySenderQuery,
+	selectAttachmentsSizeByUserID:           selectAttachmentsSizeByUserIDQuery,
+	selectStats:                             selectStatsQuery,
+	updateStats:                             updateStatsQuery,
+	selectMessagesSearch:                    selectMessagesSearchQuery,
+	insertSchedule:                          insertScheduleQuery,
+	selectSchedulesByTopic:                  selectSchedulesByTopicQuery,
+	selectScheduleByID:                      selectScheduleByIDQuery,
+	selectSchedulesDue:                      selectSchedulesDueQuery,
+	updateScheduleRun:                       updateScheduleRunQuery,
+	updateSchedulePaused:                    updateSchedulePausedQuery,
+	deleteSchedule:                          deleteScheduleQuery,
+	selectScheduleCountByUser:               selectScheduleCountByUserQuery,
+	selectScheduleCountBySender:             selectScheduleCountBySenderQuery,
+	insertAck:                               insertAckQuery,
+	selectAcksByMessageID:                   selectAcksByMessageIDQuery,
+	deleteAcks:                              deleteAcksQuery,
+	insertEscalation:                        insertEscalationQuery,
+	selectEscalationsDue:                    selectEscalationsDueQuery,
+	updateEscalation:                        updateEscalationQuery,
+	deleteEscalation:                        deleteEscalationQuery,
+	insertWebhookDelivery:                   insertWebhookDeliveryQuery,
+	selectWebhookDeliveriesDue:              selectWebhookDeliveriesDueQuery,
+	selectWebhookDeliveries:                 selectWebhookDeliveriesQuery,
+	updateWebhookDelivery:                   updateWebhookDeliveryQuery,
+	updateWebhookDeliveryNext:               updateWebhookDeliveryNextQuery,
+	deleteWebhookDeliveries:                 deleteWebhookDeliveriesQuery,
+	deleteWebhookDeliveriesUpdatedBefore:    deleteWebhookDeliveriesUpdatedBeforeQuery,
+	searchTerm:                              ftsSearchTerm,
+}
+
+// sqliteMessageCacheQueriesWithoutSearchIndex is used if SQLite was built without FTS5 support
+var sqliteMessageCacheQueriesWithoutSearchIndex = func() *messageCacheQueries {
+	queries := *sqliteMessageCacheQueries
+	queries.selectMessagesSearch = selectMessagesSearchLikeQuery
+	queries.searchTerm = likeSearchTerm
+	return &queries
+}()
+
 type messageCache struct {
-	db    *sql.DB
-	queue *util.BatchingQueue[*message]
-	nop   bool
+	db            *sql.DB
+	queries       *messageCacheQueries
+	queue         *util.BatchingQueue[*message]
+	queued        map[string]*message // Messages in the queue that are not written yet, by ID; see Message
+	queuedMu      sync.Mutex
+	cacheDuration time.Duration // Expiry for messages without "expires" value; if zero, they expire right away
+	nop           bool
 }
 
 // newSqliteCache creates a SQLite file-backed cache
@@ -280,17 +704,32 @@ func newSqliteCache(filename, startupQueries string, cacheDuration time.Duration
 	if err := setupMessagesDB(db, startupQueries, cacheDuration); err != nil {
 		return nil, err
 	}
+	queries := sqliteMessageCacheQueries
+	if searchIndex, err := setupSearchIndex(db); err != nil {
+		return nil, err
+	} else if !searchIndex {
+		queries = sqliteMessageCacheQueriesWithoutSearchIndex
+	}
+	return newMessageCache(db, queries, cacheDuration, batchSize, batchTimeout, nop), nil
+}
+
+// newMessageCache wraps an already set up database in a messageCache, and starts the batch writer if
+// "batchSize" or "batchTimeout" are set
+func newMessageCache(db *sql.DB, queries *messageCacheQueries, cacheDuration time.Duration, batchSize int, batchTimeout time.Duration, nop bool) *messageCache {
 	var queue *util.BatchingQueue[*message]
 	if batchSize > 0 || batchTimeout > 0 {
 		queue = util.NewBatchingQueue[*message](batchSize, batchTimeout)
 	}
 	cache := &messageCache{
-		db:    db,
-		queue: queue,
-		nop:   nop,
+		db:            db,
+		queries:       queries,
+		queue:         queue,
+		queued:        make(map[string]*message),
+		cacheDuration: cacheDuration,
+		nop:           nop,
 	}
 	go cache.processMessageBatches()
-	return cache, nil
+	return cache
 }
 
 // newMemCache creates an in-memory cache
@@ -315,9 +754,13 @@ func createMemoryFilename() string {
 }
 
 // AddMessage stores a message to the message cache synchronously, or queues it to be stored at a later date asyncronously.
-// The message is queued only if "batchSize" or "batchTimeout" are passed to the constructor.
+// The message is queued only if "batchSize" or "batchTimeout" are passed to the constructor. Until it is written, a
+// queued message can still be looked up via Message.
 func (c *messageCache) AddMessage(m *message) error {
 	if c.queue != nil {
+		c.queuedMu.Lock()
+		c.queued[m.ID] = m
+		c.queuedMu.Unlock()
 		c.queue.Enqueue(m)
 		return nil
 	}
@@ -325,7 +768,11 @@ func (c *messageCache) AddMessage(m *message) error {
 }
 
 // addMessages synchronously stores a match of messages. If the database is locked, the transaction waits until
-// SQLite's busy_timeout is exceeded before erroring out.
+// SQLite's busy_timeout is exceeded before erroring out (PostgreSQL does not lock the entire database).
+//
+// Updates and deletes (messages that replace another message) hide the original message and all its previous
+// updates from future polls. This happens in the same transaction, so that it works even if the original
+// message was queued in the same batch.
 func (c *messageCache) addMessages(ms []*message) error {
 	if c.nop {
 		return nil
@@ -339,19 +786,24 @@ func (c *messageCache) addMessages(ms []*message) error {
 		return err
 	}
 	defer tx.Rollback()
-	stmt, err := tx.Prepare(insertMessageQuery)
+	stmt, err := tx.Prepare(c.queries.insertMessage)
 	if err != nil {
 		return err
 	}
 	defer stmt.Close()
 	for _, m := range ms {
-		if m.Event != messageEvent {
+		if m.Event != messageEvent && m.Event != messageUpdateEvent && m.Event != messageDeleteEvent {
 			return errUnexpectedMessageType
 		}
+		if m.Replaces != "" && (m.Event == messageUpdateEvent || m.Event == messageDeleteEvent) {
+			if _, err := tx.Exec(c.queries.updateMessagesSuperseded, m.Topic, m.Replaces, m.Replaces); err != nil {
+				return err
+			}
+		}
 		published := m.Time <= time.Now().Unix()
 		tags := strings.Join(m.Tags, ",")
 		var attachmentName, attachmentType, attachmentURL string
-		var attachmentSize, attachmentExpires, attachmentDeleted int64
+		var attachmentSize, attachmentExpires int64
 		if m.Attachment != nil {
 			attachmentName = m.Attachment.Name
 			attachmentType = m.Attachment.Type
@@ -388,12 +840,16 @@ func (c *messageCache) addMessages(ms []*message) error {
 			attachmentSize,
 			attachmentExpires,
 			attachmentURL,
-			attachmentDeleted, // Always zero
+			false, // attachment_deleted
 			sender,
 			m.User,
 			m.ContentType,
 			m.Encoding,
 			published,
+			m.Event,
+			m.Replaces,
+			m.Email,
+			m.Call,
 		)
 		if err != nil {
 			return err
@@ -420,9 +876,9 @@ func (c *messageCache) messagesSinceTime(topic string, since sinceMarker, schedu
 	var rows *sql.Rows
 	var err error
 	if scheduled {
-		rows, err = c.db.Query(selectMessagesSinceTimeIncludeScheduledQuery, topic, since.Time().Unix())
+		rows, err = c.db.Query(c.queries.selectMessagesSinceTimeIncludeScheduled, topic, since.Time().Unix())
 	} else {
-		rows, err = c.db.Query(selectMessagesSinceTimeQuery, topic, since.Time().Unix())
+		rows, err = c.db.Query(c.queries.selectMessagesSinceTime, topic, since.Time().Unix())
 	}
 	if err != nil {
 		return nil, err
@@ -431,7 +887,7 @@ func (c *messageCache) messagesSinceTime(topic string, since sinceMarker, schedu
 }
 
 func (c *messageCache) messagesSinceID(topic string, since sinceMarker, scheduled bool) ([]*message, error) {
-	idrows, err := c.db.Query(selectRowIDFromMessageID, since.ID())
+	idrows, err := c.db.Query(c.queries.selectRowIDFromMessageID, since.ID())
 	if err != nil {
 		return nil, err
 	}
@@ -446,9 +902,9 @@ func (c *messageCache) messagesSinceID(topic string, since sinceMarker, schedule
 	idrows.Close()
 	var rows *sql.Rows
 	if scheduled {
-		rows, err = c.db.Query(selectMessagesSinceIDIncludeScheduledQuery, topic, rowID)
+		rows, err = c.db.Query(c.queries.selectMessagesSinceIDIncludeScheduled, topic, rowID)
 	} else {
-		rows, err = c.db.Query(selectMessagesSinceIDQuery, topic, rowID)
+		rows, err = c.db.Query(c.queries.selectMessagesSinceID, topic, rowID)
 	}
 	if err != nil {
 		return nil, err
@@ -456,17 +912,117 @@ func (c *messageCache) messagesSinceID(topic string, since sinceMarker, schedule
 	return readMessages(rows)
 }
 
+// MessagesLatest returns the latest published messages in the given topic, in chronological order
+func (c *messageCache) MessagesLatest(topic string, limit int) ([]*message, error) {
+	rows, err := c.db.Query(c.queries.selectMessagesLatest, topic, limit)
+	if err != nil {
+		return nil, err
+	}
+	messages, err := readMessages(rows)
+	if err != nil {
+		return nil, err
+	}
+	slices.Reverse(messages) // Query returns newest first
+	return messages, nil
+}
+
+// MessagesBefore returns the published messages in the given topic that were published right before the message with the
+// given ID, in chronological order. If the message does not exist (anymore), errMessageNotFound is returned.
+func (c *messageCache) MessagesBefore(topic, id string, limit int) ([]*message, error) {
+	rowID, timestamp, err := c.rowIDAndTime(topic, id)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := c.db.Query(c.queries.selectMessagesBefore, topic, timestamp, rowID, limit)
+	if err != nil {
+		return nil, err
+	}
+	messages, err := readMessages(rows)
+	if err != nil {
+		return nil, err
+	}
+	slices.Reverse(messages) // Query returns newest first
+	return messages, nil
+}
+
+// MessagesAfter returns the published messages in the given topic that were published right after the message with the
+// given ID, in chronological order. If the message does not exist (anymore), errMessageNotFound is returned.
+func (c *messageCache) MessagesAfter(topic, id string, limit int) ([]*message, error) {
+	rowID, timestamp, err := c.rowIDAndTime(topic, id)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := c.db.Query(c.queries.selectMessagesAfter, topic, timestamp, rowID, limit)
+	if err != nil {
+		return nil, err
+	}
+	return readMessages(rows)
+}
+
+func (c *messageCache) rowIDAndTime(topic, id string) (rowID int64, timestamp int64, err error) {
+	rows, err := c.db.Query(c.queries.selectRowIDAndTimeFromMessageID, topic, id)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer rows.Close()
+	if !rows.Next() {
+		return 0, 0, errMessageNotFound
+	}
+	if err := rows.Scan(&rowID, &timestamp); err != nil {
+		return 0, 0, err
+	}
+	return rowID, timestamp, rows.Err()
+}
+
+// SearchMessages returns the published messages in the given topic matching the search query, best matches first.
+// Deleted messages, as well as messages that have been updated, are not returned.
+func (c *messageCache) SearchMessages(topic, query string, limit, offset int) ([]*message, error) {
+	rows, err := c.db.Query(c.queries.selectMessagesSearch, topic, c.queries.searchTerm(query), limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	return readMessages(rows)
+}
+
 func (c *messageCache) MessagesDue() ([]*message, error) {
-	rows, err := c.db.Query(selectMessagesDueQuery, time.Now().Unix())
+	rows, err := c.db.Query(c.queries.selectMessagesDue, time.Now().Unix())
 	if err != nil {
 		return nil, err
 	}
 	return readMessages(rows)
 }
 
-// MessagesExpired returns a list of IDs for messages that have expires (should be deleted)
+// MessagesScheduled returns the scheduled (delayed) messages in the given topic that have not been published yet,
+// in order of their delivery time
+func (c *messageCache) MessagesScheduled(topic string) ([]*message, error) {
+	rows, err := c.db.Query(c.queries.selectMessagesScheduled, topic)
+	if err != nil {
+		return nil, err
+	}
+	return readMessages(rows)
+}
+
+// DeleteScheduledMessage deletes a scheduled message that has not been published yet. If the message does not
+// exist, or if it was published in the meantime, errMessageNotFound is returned.
+func (c *messageCache) DeleteScheduledMessage(topic, id string) error {
+	res, err := c.db.Exec(c.queries.deleteScheduledMessage, topic, id)
+	if err != nil {
+		return err
+	}
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	} else if rows == 0 {
+		return errMessageNotFound
+	}
+	return nil
+}
+
+// MessagesExpired returns a list of IDs for messages that have expires (should be deleted). Messages without
+// an "expires" value (e.g. written by old versions) expire after the cache duration.
 func (c *messageCache) MessagesExpired() ([]string, error) {
-	rows, err := c.db.Query(selectMessagesExpiredQuery, time.Now().Unix())
+	now := time.Now()
+	rows, err := c.db.Query(c.queries.selectMessagesExpired, now.Unix(), now.Add(-c.cacheDuration).Unix())
 	if err != nil {
 		return nil, err
 	}
@@ -486,24 +1042,37 @@ func (c *messageCache) MessagesExpired() ([]string, error) {
 }
 
 func (c *messageCache) Message(id string) (*message, error) {
-	rows, err := c.db.Query(selectMessagesByIDQuery, id)
+	rows, err := c.db.Query(c.queries.selectMessagesByID, id)
 	if err != nil {
 		return nil, err
 	}
 	if !rows.Next() {
-		return nil, errMessageNotFound
+		rows.Close()
+		return c.queuedMessage(id)
 	}
 	defer rows.Close()
 	return readMessage(rows)
 }
 
 func (c *messageCache) MarkPublished(m *message) error {
-	_, err := c.db.Exec(updateMessagePublishedQuery, m.ID)
+	_, err := c.db.Exec(c.queries.updateMessagePublished, m.ID)
 	return err
 }
 
+// queuedMessage returns a copy of the message with the given ID, if it is queued but not written yet
+func (c *messageCache) queuedMessage(id string) (*message, error) {
+	c.queuedMu.Lock()
+	defer c.queuedMu.Unlock()
+	m, ok := c.queued[id]
+	if !ok {
+		return nil, errMessageNotFound
+	}
+	copied := *m
+	return &copied, nil
+}
+
 func (c *messageCache) MessageCounts() (map[string]int, error) {
-	rows, err := c.db.Query(selectMessageCountPerTopicQuery)
+	rows, err := c.db.Query(c.queries.selectMessageCountPerTopic)
 	if err != nil {
 		return nil, err
 	}
@@ -523,7 +1092,7 @@ func (c *messageCache) MessageCounts() (map[string]int, error) {
 }
 
 func (c *messageCache) Topics() (map[string]*topic, error) {
-	rows, err := c.db.Query(selectTopicsQuery)
+	rows, err := c.db.Query(c.queries.selectTopics)
 	if err != nil {
 		return nil, err
 	}
@@ -549,7 +1118,13 @@ func (c *messageCache) DeleteMessages(ids ...string) error {
 	}
 	defer tx.Rollback()
 	for _, id := range ids {
-		if _, err := tx.Exec(deleteMessageQuery, id); err != nil {
+		if _, err := tx.Exec(c.queries.deleteMessage, id); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(c.queries.deleteAcks, id); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(c.queries.deleteEscalation, id); err != nil {
 			return err
 		}
 	}
@@ -563,7 +1138,30 @@ func (c *messageCache) ExpireMessages(topics ...string) error {
 	}
 	defer tx.Rollback()
 	for _, t := range topics {
-		if _, err := tx.Exec(updateMessagesForTopicExpiryQuery, time.Now().Unix()-1, t); err != nil {
+		if _, err := tx.Exec(c.queries.updateMessagesForTopicExpiry, time.Now().Unix()-1, t); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// ExpireMessagesExceedingRetention marks all messages of a topic as expired that are older than maxAge, or
+// that are not among the newest maxMessages messages. A zero value means no limit. Like with ExpireMessages,
+// the messages (and their attachments) are then deleted by the manager, see pruneMessages.
+func (c *messageCache) ExpireMessagesExceedingRetention(topic string, maxAge time.Duration, maxMessages int64) error {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	now := time.Now()
+	if maxAge > 0 {
+		if _, err := tx.Exec(c.queries.updateMessagesForTopicExpiryOlderThan, now.Unix()-1, topic, now.Add(-maxAge).Unix()); err != nil {
+			return err
+		}
+	}
+	if maxMessages > 0 {
+		if _, err := tx.Exec(c.queries.updateMessagesForTopicExpiryExceeding, now.Unix()-1, topic, maxMessages); err != nil {
 			return err
 		}
 	}
@@ -571,7 +1169,7 @@ func (c *messageCache) ExpireMessages(topics ...string) error {
 }
 
 func (c *messageCache) AttachmentsExpired() ([]string, error) {
-	rows, err := c.db.Query(selectAttachmentsExpiredQuery, time.Now().Unix())
+	rows, err := c.db.Query(c.queries.selectAttachmentsExpired, time.Now().Unix())
 	if err != nil {
 		return nil, err
 	}
@@ -597,7 +1195,7 @@ func (c *messageCache) MarkAttachmentsDeleted(ids ...string) error {
 	}
 	defer tx.Rollback()
 	for _, id := range ids {
-		if _, err := tx.Exec(updateAttachmentDeleted, id); err != nil {
+		if _, err := tx.Exec(c.queries.updateAttachmentDeleted, id); err != nil {
 			return err
 		}
 	}
@@ -605,7 +1203,7 @@ func (c *messageCache) MarkAttachmentsDeleted(ids ...string) error {
 }
 
 func (c *messageCache) AttachmentBytesUsedBySender(sender string) (int64, error) {
-	rows, err := c.db.Query(selectAttachmentsSizeBySenderQuery, sender, time.Now().Unix())
+	rows, err := c.db.Query(c.queries.selectAttachmentsSizeBySender, sender, time.Now().Unix())
 	if err != nil {
 		return 0, err
 	}
@@ -613,7 +1211,7 @@ func (c *messageCache) AttachmentBytesUsedBySender(sender string) (int64, error)
 }
 
 func (c *messageCache) AttachmentBytesUsedByUser(userID string) (int64, error) {
-	rows, err := c.db.Query(selectAttachmentsSizeByUserIDQuery, userID, time.Now().Unix())
+	rows, err := c.db.Query(c.queries.selectAttachmentsSizeByUserID, userID, time.Now().Unix())
 	if err != nil {
 		return 0, err
 	}
@@ -642,6 +1240,11 @@ func (c *messageCache) processMessageBatches() {
 		if err := c.addMessages(messages); err != nil {
 			log.Tag(tagMessageCache).Err(err).Error("Cannot write message batch")
 		}
+		c.queuedMu.Lock()
+		for _, m := range messages {
+			delete(c.queued, m.ID)
+		}
+		c.queuedMu.Unlock()
 	}
 }
 
@@ -664,7 +1267,7 @@ func readMessages(rows *sql.Rows) ([]*message, error) {
 func readMessage(rows *sql.Rows) (*message, error) {
 	var timestamp, expires, attachmentSize, attachmentExpires int64
 	var priority int
-	var id, topic, msg, title, tagsStr, click, icon, actionsStr, attachmentName, attachmentType, attachmentURL, sender, user, contentType, encoding string
+	var id, topic, msg, title, tagsStr, click, icon, actionsStr, attachmentName, attachmentType, attachmentURL, sender, user, contentType, encoding, event, replaces, email, call string
 	err := rows.Scan(
 		&id,
 		&timestamp,
@@ -686,6 +1289,10 @@ func readMessage(rows *sql.Rows) (*message, error) {
 		&user,
 		&contentType,
 		&encoding,
+		&event,
+		&replaces,
+		&email,
+		&call,
 	)
 	if err != nil {
 		return nil, err
@@ -718,7 +1325,7 @@ func readMessage(rows *sql.Rows) (*message, error) {
 		ID:          id,
 		Time:        timestamp,
 		Expires:     expires,
-		Event:       messageEvent,
+		Event:       event,
 		Topic:       topic,
 		Message:     msg,
 		Title:       title,
@@ -728,20 +1335,338 @@ func readMessage(rows *sql.Rows) (*message, error) {
 		Icon:        icon,
 		Actions:     actions,
 		Attachment:  att,
+		Replaces:    replaces,
 		Sender:      senderIP, // Must parse assuming database must be correct
 		User:        user,
 		ContentType: contentType,
 		Encoding:    encoding,
+		Email:       email,
+		Call:        call,
 	}, nil
 }
 
+// AddSchedule stores a recurring message. The message template is stored as JSON.
+func (c *messageCache) AddSchedule(sc *schedule) error {
+	template, err := json.Marshal(sc.Message)
+	if err != nil {
+		return err
+	}
+	sender := ""
+	if sc.Message.Sender.IsValid() {
+		sender = sc.Message.Sender.String()
+	}
+	_, err = c.db.Exec(
+		c.queries.insertSchedule,
+		sc.ID,
+		sc.Topic,
+		sc.Spec,
+		string(template),
+		sender,
+		sc.Message.User,
+		sc.Paused,
+		sc.Next,
+		sc.Last,
+		sc.Created,
+	)
+	return err
+}
+
+// Schedules returns all recurring messages of a topic, oldest first
+func (c *messageCache) Schedules(topic string) ([]*schedule, error) {
+	rows, err := c.db.Query(c.queries.selectSchedulesByTopic, topic)
+	if err != nil {
+		return nil, err
+	}
+	return readSchedules(rows)
+}
+
+// Schedule returns the recurring message with the given ID, or errScheduleNotFound
+func (c *messageCache) Schedule(id string) (*schedule, error) {
+	rows, err := c.db.Query(c.queries.selectScheduleByID, id)
+	if err != nil {
+		return nil, err
+	}
+	schedules, err := readSchedules(rows)
+	if err != nil {
+		return nil, err
+	} else if len(schedules) == 0 {
+		return nil, errScheduleNotFound
+	}
+	return schedules[0], nil
+}
+
+// SchedulesDue returns all recurring messages that are not paused, and whose next run is due
+func (c *messageCache) SchedulesDue() ([]*schedule, error) {
+	rows, err := c.db.Query(c.queries.selectSchedulesDue, time.Now().Unix())
+	if err != nil {
+		return nil, err
+	}
+	return readSchedules(rows)
+}
+
+// MarkScheduleRun records the last run of a recurring message, and sets the time of its next run
+func (c *messageCache) MarkScheduleRun(id string, last, next int64) error {
+	_, err := c.db.Exec(c.queries.updateScheduleRun, last, next, id)
+	return err
+}
+
+// ChangeSchedulePaused pauses or resumes a recurring message. Since missed runs are not caught up on, the
+// time of the next run must be passed when resuming.
+func (c *messageCache) ChangeSchedulePaused(id string, paused bool, next int64) error {
+	_, err := c.db.Exec(c.queries.updateSchedulePaused, paused, next, id)
+	return err
+}
+
+// DeleteSchedule removes a recurring message. Messages that were already created by it are not affected.
+func (c *messageCache) DeleteSchedule(id string) error {
+	_, err := c.db.Exec(c.queries.deleteSchedule, id)
+	return err
+}
+
+// ScheduleCount returns the number of recurring messages created by the given user (across all topics). If
+// the user ID is empty, the recurring messages created anonymously from the given IP address are counted.
+func (c *messageCache) ScheduleCount(userID string, sender netip.Addr) (int, error) {
+	var count int
+	if userID != "" {
+		if err := c.db.QueryRow(c.queries.selectScheduleCountByUser, userID).Scan(&count); err != nil {
+			return 0, err
+		}
+		return count, nil
+	}
+	if err := c.db.QueryRow(c.queries.selectScheduleCountBySender, sender.String()).Scan(&count); err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+func readSchedules(rows *sql.Rows) ([]*schedule, error) {
+	defer rows.Close()
+	schedules := make([]*schedule, 0)
+	for rows.Next() {
+		sc, err := readSchedule(rows)
+		if err != nil {
+			return nil, err
+		}
+		schedules = append(schedules, sc)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return schedules, nil
+}
+
+func readSchedule(rows *sql.Rows) (*schedule, error) {
+	var id, topic, spec, template, sender, user string
+	var paused bool
+	var next, last, created int64
+	if err := rows.Scan(&id, &topic, &spec, &template, &sender, &user, &paused, &next, &last, &created); err != nil {
+		return nil, err
+	}
+	var m message
+	if err := json.Unmarshal([]byte(template), &m); err != nil {
+		return nil, err
+	}
+	senderIP, err := netip.ParseAddr(sender)
+	if err != nil {
+		senderIP = netip.Addr{} // if no IP stored in database, return invalid address
+	}
+	m.Sender = senderIP
+	m.User = user
+	return &schedule{
+		ID:      id,
+		Topic:   topic,
+		Spec:    spec,
+		Message: &m,
+		Paused:  paused,
+		Next:    next,
+		Last:    last,
+		Created: created,
+	}, nil
+}
+
+// AddAck stores the acknowledgement of a message
+func (c *messageCache) AddAck(a *ack) error {
+	sender := ""
+	if a.Sender.IsValid() {
+		sender = a.Sender.String()
+	}
+	_, err := c.db.Exec(c.queries.insertAck, a.MessageID, a.Topic, a.User, a.Token, sender, a.Time)
+	return err
+}
+
+// Acks returns the acknowledgements of the given messages, oldest first, keyed by message ID. Messages without
+// acknowledgements are not included in the result.
+func (c *messageCache) Acks(messageIDs ...string) (map[string][]*ack, error) {
+	acks := make(map[string][]*ack)
+	if len(messageIDs) == 0 {
+		return acks, nil
+	}
+	stmt, err := c.db.Prepare(c.queries.selectAcksByMessageID)
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+	queried := make(map[string]bool)
+	for _, messageID := range messageIDs {
+		if queried[messageID] {
+			continue
+		}
+		queried[messageID] = true
+		if err := readAcks(stmt, messageID, acks); err != nil {
+			return nil, err
+		}
+	}
+	return acks, nil
+}
+
+func readAcks(stmt *sql.Stmt, messageID string, acks map[string][]*ack) error {
+	rows, err := stmt.Query(messageID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var mid, topic, username, token, sender string
+		var timestamp int64
+		if err := rows.Scan(&mid, &topic, &username, &token, &sender, &timestamp); err != nil {
+			return err
+		}
+		senderIP, err := netip.ParseAddr(sender)
+		if err != nil {
+			senderIP = netip.Addr{} // if no IP stored in database, return invalid address
+		}
+		acks[mid] = append(acks[mid], &ack{
+			MessageID: mid,
+			Topic:     topic,
+			User:      username,
+			Token:     token,
+			Sender:    senderIP,
+			Time:      timestamp,
+		})
+	}
+	return rows.Err()
+}
+
+// AddEscalation stores the escalation state of a message
+func (c *messageCache) AddEscalation(e *escalation) error {
+	_, err := c.db.Exec(c.queries.insertEscalation, e.MessageID, e.Topic, e.Step, e.Next)
+	return err
+}
+
+// EscalationsDue returns all escalations whose next step is due
+func (c *messageCache) EscalationsDue() ([]*escalation, error) {
+	rows, err := c.db.Query(c.queries.selectEscalationsDue, time.Now().Unix())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	escalations := make([]*escalation, 0)
+	for rows.Next() {
+		var e escalation
+		if err := rows.Scan(&e.MessageID, &e.Topic, &e.Step, &e.Next); err != nil {
+			return nil, err
+		}
+		escalations = append(escalations, &e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return escalations, nil
+}
+
+// UpdateEscalation sets the next step of an escalation, and the time it is due
+func (c *messageCache) UpdateEscalation(id string, step int, next int64) error {
+	_, err := c.db.Exec(c.queries.updateEscalation, step, next, id)
+	return err
+}
+
+// DeleteEscalation stops the escalation of a message, e.g. because it was acknowledged
+func (c *messageCache) DeleteEscalation(id string) error {
+	_, err := c.db.Exec(c.queries.deleteEscalation, id)
+	return err
+}
+
+// AddWebhookDelivery stores a new (pending) delivery of a message to an outbound webhook
+func (c *messageCache) AddWebhookDelivery(d *webhookDelivery) error {
+	_, err := c.db.Exec(c.queries.insertWebhookDelivery, d.ID, d.WebhookID, d.Topic, d.MessageID, d.Payload, d.Status, d.Attempts, d.Next, d.StatusCode, d.Error, d.Updated)
+	return err
+}
+
+// WebhookDeliveriesDue returns up to limit pending webhook deliveries whose next attempt is due at the given time
+func (c *messageCache) WebhookDeliveriesDue(now time.Time, limit int) ([]*webhookDelivery, error) {
+	rows, err := c.db.Query(c.queries.selectWebhookDeliveriesDue, now.Unix(), limit)
+	if err != nil {
+		return nil, err
+	}
+	return readWebhookDeliveries(rows)
+}
+
+// WebhookDeliveries returns the most recent deliveries of the given webhook, newest first
+func (c *messageCache) WebhookDeliveries(webhookID string, limit int) ([]*webhookDelivery, error) {
+	rows, err := c.db.Query(c.queries.selectWebhookDeliveries, webhookID, limit)
+	if err != nil {
+		return nil, err
+	}
+	return readWebhookDeliveries(rows)
+}
+
+// UpdateWebhookDelivery stores the result of a delivery attempt
+func (c *messageCache) UpdateWebhookDelivery(d *webhookDelivery) error {
+	_, err := c.db.Exec(c.queries.updateWebhookDelivery, d.Status, d.Attempts, d.Next, d.StatusCode, d.Error, d.Updated, d.ID)
+	return err
+}
+
+// ClaimWebhookDelivery moves the next attempt of a pending delivery to the given time, so that it is not picked
+// up again while it is being delivered. It returns false if the delivery was changed in the meantime.
+func (c *messageCache) ClaimWebhookDelivery(d *webhookDelivery, next int64) (bool, error) {
+	result, err := c.db.Exec(c.queries.updateWebhookDeliveryNext, next, d.ID, d.Next)
+	if err != nil {
+		return false, err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	d.Next = next
+	return rows == 1, nil
+}
+
+// DeleteWebhookDeliveries deletes all deliveries of the given webhook, e.g. because the webhook was removed
+func (c *messageCache) DeleteWebhookDeliveries(webhookID string) error {
+	_, err := c.db.Exec(c.queries.deleteWebhookDeliveries, webhookID)
+	return err
+}
+
+// DeleteWebhookDeliveriesUpdatedBefore deletes all completed (delivered or failed) webhook deliveries that
+// were last updated before the given time. Pending deliveries are never deleted.
+func (c *messageCache) DeleteWebhookDeliveriesUpdatedBefore(before time.Time) error {
+	_, err := c.db.Exec(c.queries.deleteWebhookDeliveriesUpdatedBefore, before.Unix())
+	return err
+}
+
+func readWebhookDeliveries(rows *sql.Rows) ([]*webhookDelivery, error) {
+	defer rows.Close()
+	deliveries := make([]*webhookDelivery, 0)
+	for rows.Next() {
+		var d webhookDelivery
+		if err := rows.Scan(&d.ID, &d.WebhookID, &d.Topic, &d.MessageID, &d.Payload, &d.Status, &d.Attempts, &d.Next, &d.StatusCode, &d.Error, &d.Updated); err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, &d)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return deliveries, nil
+}
+
 func (c *messageCache) UpdateStats(messages int64) error {
-	_, err := c.db.Exec(updateStatsQuery, messages)
+	_, err := c.db.Exec(c.queries.updateStats, messages)
 	return err
 }
 
 func (c *messageCache) Stats() (messages int64, err error) {
-	rows, err := c.db.Query(selectStatsQuery)
+	rows, err := c.db.Query(c.queries.selectStats)
 	if err != nil {
 		return 0, err
 	}
@@ -805,6 +1730,55 @@ func setupMessagesDB(db *sql.DB, startupQueries string, cacheDuration time.Durat
 	return nil
 }
 
+// setupSearchIndex creates the full-text search index, if SQLite was built with FTS5 support, and returns true
+// if the index can be used. The index is kept up to date by triggers, so if the triggers are missing (new index, or
+// the cache was previously used without FTS5 support), the index is rebuilt from scratch.
+func setupSearchIndex(db *sql.DB) (bool, error) {
+	var available, triggers int
+	if err := db.QueryRow(selectSearchIndexAvailableQuery).Scan(&available); err != nil {
+		return false, err
+	} else if available == 0 {
+		log.Tag(tagMessageCache).Debug("SQLite was built without FTS5 support, message search will be slow")
+		_, err := db.Exec(dropSearchIndexTriggersQuery) // Triggers would fail without FTS5 support
+		return false, err
+	}
+	if err := db.QueryRow(selectSearchIndexTriggerQuery).Scan(&triggers); err != nil {
+		return false, err
+	} else if triggers > 0 {
+		return true, nil
+	}
+	log.Tag(tagMessageCache).Info("Building message search index")
+	tx, err := db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(createSearchIndexTableQuery); err != nil {
+		return false, err
+	} else if _, err := tx.Exec(createSearchIndexTriggersQuery); err != nil {
+		return false, err
+	} else if _, err := tx.Exec(rebuildSearchIndexQuery); err != nil {
+		return false, err
+	}
+	return true, tx.Commit()
+}
+
+// ftsSearchTerm converts a user query to an FTS5 query. Every word is quoted, so that special characters
+// do not lead to syntax errors, and matched as prefix. All words must match.
+func ftsSearchTerm(query string) string {
+	words := strings.Fields(query)
+	for i, word := range words {
+		words[i] = `"` + strings.ReplaceAll(word, `"`, `""`) + `"*`
+	}
+	return strings.Join(words, " ")
+}
+
+// likeSearchTerm converts a user query to a LIKE pattern, matching the query as a substring
+func likeSearchTerm(query string) string {
+	replacer := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+	return "%" + replacer.Replace(strings.TrimSpace(query)) + "%"
+}
+
 func setupNewCacheDB(db *sql.DB) error {
 	if _, err := db.Exec(createMessagesTableQuery); err != nil {
 		return err
@@ -970,3 +1944,99 @@ func migrateFrom11(db *sql.DB, _ time.Duration) error {
 	}
 	return tx.Commit()
 }
+
+func migrateFrom12(db *sql.DB, _ time.Duration) error {
+	log.Tag(tagMessageCache).Info("Migrating cache database schema: from 12 to 13")
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(migrate12To13AlterMessagesTableQuery); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(updateSchemaVersion, 13); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func migrateFrom13(db *sql.DB, _ time.Duration) error {
+	log.Tag(tagMessageCache).Info("Migrating cache database schema: from 13 to 14")
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(migrate13To14CreateSchedulesTableQuery); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(updateSchemaVersion, 14); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func migrateFrom14(db *sql.DB, _ time.Duration) error {
+	log.Tag(tagMessageCache).Info("Migrating cache database schema: from 14 to 15")
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(migrate14To15AlterMessagesTableQuery); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(updateSchemaVersion, 15); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func migrateFrom15(db *sql.DB, _ time.Duration) error {
+	log.Tag(tagMessageCache).Info("Migrating cache database schema: from 15 to 16")
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(migrate15To16CreateAcksTableQuery); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(updateSchemaVersion, 16); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func migrateFrom16(db *sql.DB, _ time.Duration) error {
+	log.Tag(tagMessageCache).Info("Migrating cache database schema: from 16 to 17")
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(migrate16To17CreateEscalationsTableQuery); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(updateSchemaVersion, 17); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func migrateFrom17(db *sql.DB, _ time.Duration) error {
+	log.Tag(tagMessageCache).Info("Migrating cache database schema: from 17 to 18")
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(migrate17To18CreateWebhookDeliveriesTableQuery); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(updateSchemaVersion, 18); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
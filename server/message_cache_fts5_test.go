@@ -0,0 +1,60 @@
+//go:build sqlite_fts5
+
+package server
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// These tests only run if go-sqlite3 is built with FTS5 support (make test-fts5), since the regular
+// search tests also pass with the LIKE fallback.
+
+func TestSqliteCache_SearchIndex_Available(t *testing.T) {
+	c := newSqliteTestCache(t)
+	require.Same(t, sqliteMessageCacheQueries, c.queries)
+
+	var triggers int
+	require.Nil(t, c.db.QueryRow(selectSearchIndexTriggerQuery).Scan(&triggers))
+	require.Equal(t, 1, triggers)
+
+	require.Nil(t, c.AddMessage(newDefaultMessage("mytopic", "some message")))
+	var indexed int
+	require.Nil(t, c.db.QueryRow(`SELECT COUNT(*) FROM messages_fts`).Scan(&indexed))
+	require.Equal(t, 1, indexed)
+}
+
+func TestSqliteCache_SearchIndex_WordsAndPrefixes(t *testing.T) {
+	c := newSqliteTestCache(t)
+	m1 := newDefaultMessage("mytopic", "Nightly backup of db1 failed")
+	m2 := newDefaultMessage("mytopic", "Nightly backup of db2 succeeded")
+	m2.Title = "Backups"
+	m3 := newDefaultMessage("mytopic", `Quotes "and" (parens) are fine`)
+	require.Nil(t, c.AddMessage(m1))
+	require.Nil(t, c.AddMessage(m2))
+	require.Nil(t, c.AddMessage(m3))
+
+	// Words match in any order, and as prefix; the LIKE fallback only matches substrings
+	messages, err := c.SearchMessages("mytopic", "fail backup", 10, 0)
+	require.Nil(t, err)
+	require.Equal(t, 1, len(messages))
+	require.Equal(t, m1.ID, messages[0].ID)
+
+	messages, err = c.SearchMessages("mytopic", "backups", 10, 0)
+	require.Nil(t, err)
+	require.Equal(t, 1, len(messages))
+	require.Equal(t, m2.ID, messages[0].ID)
+
+	// Special characters do not lead to FTS5 syntax errors
+	messages, err = c.SearchMessages("mytopic", `"and" (parens`, 10, 0)
+	require.Nil(t, err)
+	require.Equal(t, 1, len(messages))
+	require.Equal(t, m3.ID, messages[0].ID)
+
+	// Deleted messages are removed from the index
+	require.Nil(t, c.DeleteMessages(m1.ID))
+	messages, err = c.SearchMessages("mytopic", "failed", 10, 0)
+	require.Nil(t, err)
+	require.Empty(t, messages)
+}
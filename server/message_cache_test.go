@@ -4,12 +4,16 @@ import (
 	"database/sql"
 	"fmt"
 	"net/netip"
+	"net/url"
+	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"heckel.io/ntfy/v2/util"
 )
 
 func TestSqliteCache_Messages(t *testing.T) {
@@ -20,6 +24,10 @@ func TestMemCache_Messages(t *testing.T) {
 	testCacheMessages(t, newMemTestCache(t))
 }
 
+func TestPostgresCache_Messages(t *testing.T) {
+	testCacheMessages(t, newPostgresTestCache(t))
+}
+
 func testCacheMessages(t *testing.T, c *messageCache) {
 	m1 := newDefaultMessage("mytopic", "my message")
 	m1.Time = 1
@@ -94,6 +102,10 @@ func TestMemCache_MessagesScheduled(t *testing.T) {
 	testCacheMessagesScheduled(t, newMemTestCache(t))
 }
 
+func TestPostgresCache_MessagesScheduled(t *testing.T) {
+	testCacheMessagesScheduled(t, newPostgresTestCache(t))
+}
+
 func testCacheMessagesScheduled(t *testing.T, c *messageCache) {
 	m1 := newDefaultMessage("mytopic", "message 1")
 	m2 := newDefaultMessage("mytopic", "message 2")
@@ -118,6 +130,23 @@ func testCacheMessagesScheduled(t *testing.T, c *messageCache) {
 
 	messages, _ = c.MessagesDue()
 	require.Empty(t, messages)
+
+	messages, _ = c.MessagesScheduled("mytopic") // only scheduled
+	require.Equal(t, 2, len(messages))
+	require.Equal(t, "message 3", messages[0].Message)
+	require.Equal(t, "message 2", messages[1].Message)
+
+	require.Equal(t, errMessageNotFound, c.DeleteScheduledMessage("mytopic", m1.ID))        // already published
+	require.Equal(t, errMessageNotFound, c.DeleteScheduledMessage("mytopic2", m2.ID))       // wrong topic
+	require.Equal(t, errMessageNotFound, c.DeleteScheduledMessage("mytopic", "notanid123")) // does not exist
+	require.Nil(t, c.DeleteScheduledMessage("mytopic", m2.ID))
+
+	messages, _ = c.MessagesScheduled("mytopic")
+	require.Equal(t, 1, len(messages))
+	require.Equal(t, "message 3", messages[0].Message)
+
+	messages, _ = c.Messages("mytopic", sinceAllMessages, true)
+	require.Equal(t, 2, len(messages))
 }
 
 func TestSqliteCache_Topics(t *testing.T) {
@@ -128,6 +157,10 @@ func TestMemCache_Topics(t *testing.T) {
 	testCacheTopics(t, newMemTestCache(t))
 }
 
+func TestPostgresCache_Topics(t *testing.T) {
+	testCacheTopics(t, newPostgresTestCache(t))
+}
+
 func testCacheTopics(t *testing.T, c *messageCache) {
 	require.Nil(t, c.AddMessage(newDefaultMessage("topic1", "my example message")))
 	require.Nil(t, c.AddMessage(newDefaultMessage("topic2", "message 1")))
@@ -151,6 +184,10 @@ func TestMemCache_MessagesTagsPrioAndTitle(t *testing.T) {
 	testCacheMessagesTagsPrioAndTitle(t, newMemTestCache(t))
 }
 
+func TestPostgresCache_MessagesTagsPrioAndTitle(t *testing.T) {
+	testCacheMessagesTagsPrioAndTitle(t, newPostgresTestCache(t))
+}
+
 func testCacheMessagesTagsPrioAndTitle(t *testing.T, c *messageCache) {
 	m := newDefaultMessage("mytopic", "some message")
 	m.Tags = []string{"tag1", "tag2"}
@@ -172,6 +209,10 @@ func TestMemCache_MessagesSinceID(t *testing.T) {
 	testCacheMessagesSinceID(t, newMemTestCache(t))
 }
 
+func TestPostgresCache_MessagesSinceID(t *testing.T) {
+	testCacheMessagesSinceID(t, newPostgresTestCache(t))
+}
+
 func testCacheMessagesSinceID(t *testing.T, c *messageCache) {
 	m1 := newDefaultMessage("mytopic", "message 1")
 	m1.Time = 100
@@ -242,6 +283,10 @@ func TestMemCache_Prune(t *testing.T) {
 	testCachePrune(t, newMemTestCache(t))
 }
 
+func TestPostgresCache_Prune(t *testing.T) {
+	testCachePrune(t, newPostgresTestCache(t))
+}
+
 func testCachePrune(t *testing.T, c *messageCache) {
 	now := time.Now().Unix()
 
@@ -281,6 +326,156 @@ func testCachePrune(t *testing.T, c *messageCache) {
 	require.Equal(t, "my other message", messages[0].Message)
 }
 
+func TestSqliteCache_PruneWithoutExpires(t *testing.T) {
+	testCachePruneWithoutExpires(t, newSqliteTestCache(t))
+}
+
+func TestPostgresCache_PruneWithoutExpires(t *testing.T) {
+	testCachePruneWithoutExpires(t, newPostgresTestCache(t))
+}
+
+func testCachePruneWithoutExpires(t *testing.T, c *messageCache) {
+	now := time.Now().Unix()
+
+	m1 := newDefaultMessage("mytopic", "older than cache duration")
+	m1.Time = now - 7200
+	m1.Expires = 0
+
+	m2 := newDefaultMessage("mytopic", "newer than cache duration")
+	m2.Time = now - 60
+	m2.Expires = 0
+
+	require.Nil(t, c.AddMessage(m1))
+	require.Nil(t, c.AddMessage(m2))
+
+	expiredMessageIDs, err := c.MessagesExpired()
+	require.Nil(t, err)
+	require.Equal(t, []string{m1.ID}, expiredMessageIDs)
+}
+
+func TestSqliteCache_ExpireMessagesExceedingRetention(t *testing.T) {
+	testCacheExpireMessagesExceedingRetention(t, newSqliteTestCache(t))
+}
+
+func TestMemCache_ExpireMessagesExceedingRetention(t *testing.T) {
+	testCacheExpireMessagesExceedingRetention(t, newMemTestCache(t))
+}
+
+func TestPostgresCache_ExpireMessagesExceedingRetention(t *testing.T) {
+	testCacheExpireMessagesExceedingRetention(t, newPostgresTestCache(t))
+}
+
+func testCacheExpireMessagesExceedingRetention(t *testing.T, c *messageCache) {
+	now := time.Now().Unix()
+	for i := 0; i < 5; i++ {
+		m := newDefaultMessage("mytopic", fmt.Sprintf("message %d", i))
+		m.Time = now - int64(5-i)*3600 // 5h ago, 4h ago, ..., 1h ago
+		m.Expires = now + 3600
+		require.Nil(t, c.AddMessage(m))
+	}
+	m := newDefaultMessage("another_topic", "old, but not affected")
+	m.Time = now - 10*3600
+	m.Expires = now + 3600
+	require.Nil(t, c.AddMessage(m))
+
+	// Max age: messages older than 3.5h are expired
+	require.Nil(t, c.ExpireMessagesExceedingRetention("mytopic", 3*time.Hour+30*time.Minute, 0))
+	expiredMessageIDs, err := c.MessagesExpired()
+	require.Nil(t, err)
+	require.Equal(t, 2, len(expiredMessageIDs))
+	require.Nil(t, c.DeleteMessages(expiredMessageIDs...))
+
+	messages, err := c.Messages("mytopic", sinceAllMessages, false)
+	require.Nil(t, err)
+	require.Equal(t, 3, len(messages))
+	require.Equal(t, "message 2", messages[0].Message)
+
+	// Max messages: only the newest message is kept
+	require.Nil(t, c.ExpireMessagesExceedingRetention("mytopic", 0, 1))
+	expiredMessageIDs, err = c.MessagesExpired()
+	require.Nil(t, err)
+	require.Equal(t, 2, len(expiredMessageIDs))
+	require.Nil(t, c.DeleteMessages(expiredMessageIDs...))
+
+	messages, err = c.Messages("mytopic", sinceAllMessages, false)
+	require.Nil(t, err)
+	require.Equal(t, 1, len(messages))
+	require.Equal(t, "message 4", messages[0].Message)
+
+	messages, err = c.Messages("another_topic", sinceAllMessages, false)
+	require.Nil(t, err)
+	require.Equal(t, 1, len(messages))
+}
+
+func TestSqliteCache_Schedules(t *testing.T) {
+	testCacheSchedules(t, newSqliteTestCache(t))
+}
+
+func TestMemCache_Schedules(t *testing.T) {
+	testCacheSchedules(t, newMemTestCache(t))
+}
+
+func TestPostgresCache_Schedules(t *testing.T) {
+	testCacheSchedules(t, newPostgresTestCache(t))
+}
+
+func testCacheSchedules(t *testing.T, c *messageCache) {
+	now := time.Now().Unix()
+	m := newDefaultMessage("mytopic", "take out the trash")
+	m.Title = "Reminder"
+	m.Tags = []string{"wastebasket"}
+	m.Sender = netip.MustParseAddr("1.2.3.4")
+	m.User = "u_abc"
+	sc1 := newSchedule("mytopic", "0 8 * * mon", now-10, m)
+	require.Nil(t, c.AddSchedule(sc1))
+	sc2 := newSchedule("mytopic", "every 1h", now+3600, newDefaultMessage("mytopic", "hourly"))
+	sc2.Created = sc1.Created + 1
+	require.Nil(t, c.AddSchedule(sc2))
+	require.Nil(t, c.AddSchedule(newSchedule("another_topic", "@daily", now-5, newDefaultMessage("another_topic", "daily"))))
+
+	schedules, err := c.Schedules("mytopic")
+	require.Nil(t, err)
+	require.Equal(t, 2, len(schedules))
+	require.Equal(t, sc1.ID, schedules[0].ID)
+	require.Equal(t, "0 8 * * mon", schedules[0].Spec)
+	require.Equal(t, "take out the trash", schedules[0].Message.Message)
+	require.Equal(t, "Reminder", schedules[0].Message.Title)
+	require.Equal(t, []string{"wastebasket"}, schedules[0].Message.Tags)
+	require.Equal(t, "1.2.3.4", schedules[0].Message.Sender.String())
+	require.Equal(t, "u_abc", schedules[0].Message.User)
+	require.Equal(t, now-10, schedules[0].Next)
+	require.False(t, schedules[0].Paused)
+	require.Equal(t, sc2.ID, schedules[1].ID)
+	require.False(t, schedules[1].Message.Sender.IsValid())
+
+	due, err := c.SchedulesDue()
+	require.Nil(t, err)
+	require.Equal(t, 2, len(due))
+	require.Equal(t, "mytopic", due[0].Topic)
+	require.Equal(t, "another_topic", due[1].Topic)
+
+	// Run, pause and delete
+	require.Nil(t, c.MarkScheduleRun(sc1.ID, now, now+7*86400))
+	require.Nil(t, c.ChangeSchedulePaused(due[1].ID, true, now-10))
+	due, err = c.SchedulesDue()
+	require.Nil(t, err)
+	require.Equal(t, 0, len(due))
+
+	sc, err := c.Schedule(sc1.ID)
+	require.Nil(t, err)
+	require.Equal(t, now, sc.Last)
+	require.Equal(t, now+7*86400, sc.Next)
+
+	require.Nil(t, c.DeleteSchedule(sc1.ID))
+	_, err = c.Schedule(sc1.ID)
+	require.Equal(t, errScheduleNotFound, err)
+
+	schedules, err = c.Schedules("another_topic")
+	require.Nil(t, err)
+	require.Equal(t, 1, len(schedules))
+	require.True(t, schedules[0].Paused)
+}
+
 func TestSqliteCache_Attachments(t *testing.T) {
 	testCacheAttachments(t, newSqliteTestCache(t))
 }
@@ -289,6 +484,10 @@ func TestMemCache_Attachments(t *testing.T) {
 	testCacheAttachments(t, newMemTestCache(t))
 }
 
+func TestPostgresCache_Attachments(t *testing.T) {
+	testCacheAttachments(t, newPostgresTestCache(t))
+}
+
 func testCacheAttachments(t *testing.T, c *messageCache) {
 	expires1 := time.Now().Add(-4 * time.Hour).Unix() // Expired
 	m := newDefaultMessage("mytopic", "flower for you")
@@ -371,6 +570,10 @@ func TestMemCache_Attachments_Expired(t *testing.T) {
 	testCacheAttachmentsExpired(t, newMemTestCache(t))
 }
 
+func TestPostgresCache_Attachments_Expired(t *testing.T) {
+	testCacheAttachmentsExpired(t, newPostgresTestCache(t))
+}
+
 func testCacheAttachmentsExpired(t *testing.T, c *messageCache) {
 	m := newDefaultMessage("mytopic", "flower for you")
 	m.ID = "m1"
@@ -647,6 +850,10 @@ func TestMemCache_Sender(t *testing.T) {
 	testSender(t, newMemTestCache(t))
 }
 
+func TestPostgresCache_Sender(t *testing.T) {
+	testSender(t, newPostgresTestCache(t))
+}
+
 func testSender(t *testing.T, c *messageCache) {
 	m1 := newDefaultMessage("mytopic", "mymessage")
 	m1.Sender = netip.MustParseAddr("1.2.3.4")
@@ -662,6 +869,403 @@ func testSender(t *testing.T, c *messageCache) {
 	require.Equal(t, messages[1].Sender, netip.Addr{})
 }
 
+func TestSqliteCache_MessagesSuperseded(t *testing.T) {
+	testCacheMessagesSuperseded(t, newSqliteTestCache(t))
+}
+
+func TestMemCache_MessagesSuperseded(t *testing.T) {
+	testCacheMessagesSuperseded(t, newMemTestCache(t))
+}
+
+func TestPostgresCache_MessagesSuperseded(t *testing.T) {
+	testCacheMessagesSuperseded(t, newPostgresTestCache(t))
+}
+
+func testCacheMessagesSuperseded(t *testing.T, c *messageCache) {
+	m1 := newDefaultMessage("mytopic", "original message")
+	m1.Time = 1
+	m2 := newDefaultMessage("mytopic", "other message")
+	m2.Time = 2
+	require.Nil(t, c.AddMessage(m1))
+	require.Nil(t, c.AddMessage(m2))
+
+	// Update m1 twice
+	m3 := newMessage(messageUpdateEvent, "mytopic", "updated message")
+	m3.Time = 3
+	m3.Replaces = m1.ID
+	require.Nil(t, c.AddMessage(m3))
+
+	m4 := newMessage(messageUpdateEvent, "mytopic", "updated message again")
+	m4.Time = 4
+	m4.Replaces = m1.ID
+	require.Nil(t, c.AddMessage(m4))
+
+	messages, err := c.Messages("mytopic", sinceAllMessages, false)
+	require.Nil(t, err)
+	require.Equal(t, 2, len(messages))
+	require.Equal(t, m2.ID, messages[0].ID)
+	require.Equal(t, m4.ID, messages[1].ID)
+	require.Equal(t, messageUpdateEvent, messages[1].Event)
+	require.Equal(t, m1.ID, messages[1].Replaces)
+	require.Equal(t, "updated message again", messages[1].Message)
+
+	// Delete m1, only the tombstone is left
+	m5 := newDeleteMessage("mytopic", m1.ID)
+	m5.Time = 5
+	require.Nil(t, c.AddMessage(m5))
+
+	messages, err = c.Messages("mytopic", newSinceID(m2.ID), false)
+	require.Nil(t, err)
+	require.Equal(t, 1, len(messages))
+	require.Equal(t, m5.ID, messages[0].ID)
+	require.Equal(t, messageDeleteEvent, messages[0].Event)
+	require.Equal(t, m1.ID, messages[0].Replaces)
+
+	// Superseded messages can still be looked up by ID
+	m, err := c.Message(m1.ID)
+	require.Nil(t, err)
+	require.Equal(t, "original message", m.Message)
+
+	// Other topics are not affected
+	require.Nil(t, c.AddMessage(newDefaultMessage("othertopic", "unrelated")))
+	require.Nil(t, c.AddMessage(newDeleteMessage("mytopic", m2.ID)))
+	messages, err = c.Messages("othertopic", sinceAllMessages, false)
+	require.Nil(t, err)
+	require.Equal(t, 1, len(messages))
+}
+
+func TestSqliteCache_MessagesBeforeAfter(t *testing.T) {
+	testCacheMessagesBeforeAfter(t, newSqliteTestCache(t))
+}
+
+func TestMemCache_MessagesBeforeAfter(t *testing.T) {
+	testCacheMessagesBeforeAfter(t, newMemTestCache(t))
+}
+
+func TestPostgresCache_MessagesBeforeAfter(t *testing.T) {
+	testCacheMessagesBeforeAfter(t, newPostgresTestCache(t))
+}
+
+func testCacheMessagesBeforeAfter(t *testing.T, c *messageCache) {
+	ms := make([]*message, 0)
+	for i := 0; i < 5; i++ {
+		m := newDefaultMessage("mytopic", fmt.Sprintf("message %d", i))
+		m.Time = 100 + int64(i/2) // Some messages have the same timestamp
+		require.Nil(t, c.AddMessage(m))
+		ms = append(ms, m)
+	}
+	require.Nil(t, c.AddMessage(newDefaultMessage("othertopic", "other message")))
+	scheduled := newDefaultMessage("mytopic", "scheduled message")
+	scheduled.Time = time.Now().Add(time.Hour).Unix()
+	require.Nil(t, c.AddMessage(scheduled))
+
+	messages, err := c.MessagesLatest("mytopic", 2)
+	require.Nil(t, err)
+	require.Equal(t, 2, len(messages))
+	require.Equal(t, ms[3].ID, messages[0].ID)
+	require.Equal(t, ms[4].ID, messages[1].ID)
+
+	messages, err = c.MessagesBefore("mytopic", ms[3].ID, 2)
+	require.Nil(t, err)
+	require.Equal(t, 2, len(messages))
+	require.Equal(t, ms[1].ID, messages[0].ID)
+	require.Equal(t, ms[2].ID, messages[1].ID)
+
+	messages, err = c.MessagesBefore("mytopic", ms[1].ID, 10)
+	require.Nil(t, err)
+	require.Equal(t, 1, len(messages))
+	require.Equal(t, ms[0].ID, messages[0].ID)
+
+	messages, err = c.MessagesAfter("mytopic", ms[0].ID, 3)
+	require.Nil(t, err)
+	require.Equal(t, 3, len(messages))
+	require.Equal(t, ms[1].ID, messages[0].ID)
+	require.Equal(t, ms[3].ID, messages[2].ID)
+
+	messages, err = c.MessagesAfter("mytopic", ms[4].ID, 3)
+	require.Nil(t, err)
+	require.Empty(t, messages)
+
+	_, err = c.MessagesAfter("othertopic", ms[4].ID, 3)
+	require.Equal(t, errMessageNotFound, err)
+	_, err = c.MessagesBefore("mytopic", "doesnotexist", 3)
+	require.Equal(t, errMessageNotFound, err)
+}
+
+func TestSqliteCache_SearchMessages(t *testing.T) {
+	testCacheSearchMessages(t, newSqliteTestCache(t))
+}
+
+func TestSqliteCache_SearchMessages_WithoutSearchIndex(t *testing.T) {
+	c := newSqliteTestCache(t)
+	c.queries = sqliteMessageCacheQueriesWithoutSearchIndex
+	testCacheSearchMessages(t, c)
+}
+
+func TestMemCache_SearchMessages(t *testing.T) {
+	testCacheSearchMessages(t, newMemTestCache(t))
+}
+
+func TestPostgresCache_SearchMessages(t *testing.T) {
+	testCacheSearchMessages(t, newPostgresTestCache(t))
+}
+
+func testCacheSearchMessages(t *testing.T, c *messageCache) {
+	m1 := newDefaultMessage("mytopic", "Backup of /home finished successfully")
+	m1.Time = 1
+	m1.Title = "Backup job"
+	m2 := newDefaultMessage("mytopic", "Disk space is low")
+	m2.Time = 2
+	m2.Tags = []string{"warning", "backup"}
+	m3 := newDefaultMessage("mytopic", "Backup of /var failed")
+	m3.Time = 3
+	m4 := newDefaultMessage("othertopic", "Backup of other things")
+	m4.Time = 4
+	m5 := newDefaultMessage("mytopic", "Unrelated message")
+	m5.Time = 5
+	for _, m := range []*message{m1, m2, m3, m4, m5} {
+		require.Nil(t, c.AddMessage(m))
+	}
+
+	messages, err := c.SearchMessages("mytopic", "backup", 10, 0)
+	require.Nil(t, err)
+	require.Equal(t, 3, len(messages))
+	ids := []string{messages[0].ID, messages[1].ID, messages[2].ID}
+	require.ElementsMatch(t, []string{m1.ID, m2.ID, m3.ID}, ids)
+
+	messages, err = c.SearchMessages("mytopic", "var failed", 10, 0)
+	require.Nil(t, err)
+	require.Equal(t, 1, len(messages))
+	require.Equal(t, m3.ID, messages[0].ID)
+
+	messages, err = c.SearchMessages("mytopic", `"this does not" exist%`, 10, 0)
+	require.Nil(t, err)
+	require.Empty(t, messages)
+
+	// Pagination
+	messages, err = c.SearchMessages("mytopic", "backup", 2, 0)
+	require.Nil(t, err)
+	require.Equal(t, 2, len(messages))
+	messages2, err := c.SearchMessages("mytopic", "backup", 2, 2)
+	require.Nil(t, err)
+	require.Equal(t, 1, len(messages2))
+	require.NotContains(t, []string{messages[0].ID, messages[1].ID}, messages2[0].ID)
+
+	// Deleted messages are not returned
+	require.Nil(t, c.AddMessage(&message{ID: "deletedmsg12", Time: 6, Event: messageDeleteEvent, Topic: "mytopic", Replaces: m3.ID}))
+	messages, err = c.SearchMessages("mytopic", "backup", 10, 0)
+	require.Nil(t, err)
+	require.Equal(t, 2, len(messages))
+
+	// Expired (pruned) messages are removed from the index
+	require.Nil(t, c.DeleteMessages(m1.ID))
+	messages, err = c.SearchMessages("mytopic", "backup", 10, 0)
+	require.Nil(t, err)
+	require.Equal(t, 1, len(messages))
+	require.Equal(t, m2.ID, messages[0].ID)
+}
+
+func TestSqliteCache_Acks(t *testing.T) {
+	testCacheAcks(t, newSqliteTestCache(t))
+}
+
+func TestMemCache_Acks(t *testing.T) {
+	testCacheAcks(t, newMemTestCache(t))
+}
+
+func TestPostgresCache_Acks(t *testing.T) {
+	testCacheAcks(t, newPostgresTestCache(t))
+}
+
+func testCacheAcks(t *testing.T, c *messageCache) {
+	m1 := newDefaultMessage("mytopic", "message 1")
+	m2 := newDefaultMessage("mytopic", "message 2")
+	require.Nil(t, c.AddMessage(m1))
+	require.Nil(t, c.AddMessage(m2))
+	require.Nil(t, c.AddAck(&ack{MessageID: m1.ID, Topic: "mytopic", User: "phil", Token: "laptop", Sender: netip.MustParseAddr("1.2.3.4"), Time: 2}))
+	require.Nil(t, c.AddAck(&ack{MessageID: m1.ID, Topic: "mytopic", Sender: netip.MustParseAddr("5.6.7.8"), Time: 1}))
+	require.Nil(t, c.AddAck(&ack{MessageID: "othermessage", Topic: "othertopic", User: "ben", Time: 3}))
+
+	acks, err := c.Acks(m1.ID, m2.ID, m1.ID)
+	require.Nil(t, err)
+	require.Equal(t, 1, len(acks))
+	require.Equal(t, 2, len(acks[m1.ID]))
+	require.Equal(t, "", acks[m1.ID][0].User)
+	require.Equal(t, netip.MustParseAddr("5.6.7.8"), acks[m1.ID][0].Sender)
+	require.Equal(t, int64(1), acks[m1.ID][0].Time)
+	require.Equal(t, "phil", acks[m1.ID][1].User)
+	require.Equal(t, "laptop", acks[m1.ID][1].Token)
+	require.Empty(t, acks[m2.ID])
+
+	// Acks are removed with the message
+	acks, err = c.Acks("othermessage")
+	require.Nil(t, err)
+	require.Equal(t, 1, len(acks["othermessage"]))
+	require.Equal(t, "ben", acks["othermessage"][0].User)
+
+	require.Nil(t, c.DeleteMessages(m1.ID))
+	acks, err = c.Acks(m1.ID)
+	require.Nil(t, err)
+	require.Empty(t, acks)
+}
+
+func TestSqliteCache_Escalations(t *testing.T) {
+	testCacheEscalations(t, newSqliteTestCache(t))
+}
+
+func TestMemCache_Escalations(t *testing.T) {
+	testCacheEscalations(t, newMemTestCache(t))
+}
+
+func TestPostgresCache_Escalations(t *testing.T) {
+	testCacheEscalations(t, newPostgresTestCache(t))
+}
+
+func testCacheEscalations(t *testing.T, c *messageCache) {
+	m1 := newDefaultMessage("mytopic", "message 1")
+	m2 := newDefaultMessage("mytopic", "message 2")
+	require.Nil(t, c.AddMessage(m1))
+	require.Nil(t, c.AddMessage(m2))
+	require.Nil(t, c.AddEscalation(&escalation{MessageID: m1.ID, Topic: "mytopic", Step: escalationStepForward, Next: time.Now().Add(-time.Second).Unix()}))
+	require.Nil(t, c.AddEscalation(&escalation{MessageID: m2.ID, Topic: "mytopic", Step: escalationStepForward, Next: time.Now().Add(time.Hour).Unix()}))
+
+	escalations, err := c.EscalationsDue()
+	require.Nil(t, err)
+	require.Equal(t, 1, len(escalations))
+	require.Equal(t, m1.ID, escalations[0].MessageID)
+	require.Equal(t, "mytopic", escalations[0].Topic)
+	require.Equal(t, escalationStepForward, escalations[0].Step)
+
+	// Next step is not due yet
+	require.Nil(t, c.UpdateEscalation(m1.ID, escalationStepEmail, time.Now().Add(time.Hour).Unix()))
+	escalations, err = c.EscalationsDue()
+	require.Nil(t, err)
+	require.Empty(t, escalations)
+
+	// Escalations are removed when stopped, or with the message
+	require.Nil(t, c.UpdateEscalation(m1.ID, escalationStepEmail, 1))
+	require.Nil(t, c.UpdateEscalation(m2.ID, escalationStepEmail, 1))
+	require.Nil(t, c.DeleteEscalation(m1.ID))
+	escalations, err = c.EscalationsDue()
+	require.Nil(t, err)
+	require.Equal(t, 1, len(escalations))
+	require.Equal(t, escalationStepEmail, escalations[0].Step)
+	require.Nil(t, c.DeleteMessages(m2.ID))
+	escalations, err = c.EscalationsDue()
+	require.Nil(t, err)
+	require.Empty(t, escalations)
+}
+
+func TestSqliteCache_WebhookDeliveries(t *testing.T) {
+	testCacheWebhookDeliveries(t, newSqliteTestCache(t))
+}
+
+func TestMemCache_WebhookDeliveries(t *testing.T) {
+	testCacheWebhookDeliveries(t, newMemTestCache(t))
+}
+
+func TestPostgresCache_WebhookDeliveries(t *testing.T) {
+	testCacheWebhookDeliveries(t, newPostgresTestCache(t))
+}
+
+func testCacheWebhookDeliveries(t *testing.T, c *messageCache) {
+	now := time.Now().Unix()
+	d1 := &webhookDelivery{ID: "wd_1", WebhookID: "wh_1", Topic: "mytopic", MessageID: "m1", Payload: `{"id":"m1"}`, Status: webhookDeliveryStatusPending, Next: now - 10, Updated: now - 20}
+	d2 := &webhookDelivery{ID: "wd_2", WebhookID: "wh_1", Topic: "mytopic", MessageID: "m2", Payload: `{"id":"m2"}`, Status: webhookDeliveryStatusPending, Next: now + 3600, Updated: now - 10}
+	d3 := &webhookDelivery{ID: "wd_3", WebhookID: "wh_2", Topic: "othertopic", MessageID: "m3", Payload: `{"id":"m3"}`, Status: webhookDeliveryStatusPending, Next: now - 5, Updated: now}
+	require.Nil(t, c.AddWebhookDelivery(d1))
+	require.Nil(t, c.AddWebhookDelivery(d2))
+	require.Nil(t, c.AddWebhookDelivery(d3))
+
+	deliveries, err := c.WebhookDeliveriesDue(time.Now(), 10)
+	require.Nil(t, err)
+	require.Equal(t, 2, len(deliveries))
+	require.Equal(t, "wd_1", deliveries[0].ID)
+	require.Equal(t, "wh_1", deliveries[0].WebhookID)
+	require.Equal(t, "mytopic", deliveries[0].Topic)
+	require.Equal(t, "m1", deliveries[0].MessageID)
+	require.Equal(t, `{"id":"m1"}`, deliveries[0].Payload)
+	require.Equal(t, "wd_3", deliveries[1].ID)
+
+	// Claiming succeeds only once
+	claimed, err := c.ClaimWebhookDelivery(deliveries[0], now+60)
+	require.Nil(t, err)
+	require.True(t, claimed)
+	claimed, err = c.ClaimWebhookDelivery(&webhookDelivery{ID: "wd_1", Next: now - 10}, now+60)
+	require.Nil(t, err)
+	require.False(t, claimed)
+	deliveries, err = c.WebhookDeliveriesDue(time.Now(), 10)
+	require.Nil(t, err)
+	require.Equal(t, 1, len(deliveries))
+	require.Equal(t, "wd_3", deliveries[0].ID)
+
+	// Completed deliveries are no longer due
+	d1.Status, d1.Attempts, d1.StatusCode, d1.Updated = webhookDeliveryStatusDelivered, 1, 200, now
+	require.Nil(t, c.UpdateWebhookDelivery(d1))
+	deliveries, err = c.WebhookDeliveries("wh_1", 10)
+	require.Nil(t, err)
+	require.Equal(t, 2, len(deliveries))
+	require.Equal(t, "wd_1", deliveries[0].ID) // Most recently updated first
+	require.Equal(t, webhookDeliveryStatusDelivered, deliveries[0].Status)
+	require.Equal(t, 1, deliveries[0].Attempts)
+	require.Equal(t, 200, deliveries[0].StatusCode)
+	require.Equal(t, "wd_2", deliveries[1].ID)
+
+	// Pruning only removes completed deliveries
+	require.Nil(t, c.DeleteWebhookDeliveriesUpdatedBefore(time.Unix(now+1, 0)))
+	deliveries, err = c.WebhookDeliveries("wh_1", 10)
+	require.Nil(t, err)
+	require.Equal(t, 1, len(deliveries))
+	require.Equal(t, "wd_2", deliveries[0].ID)
+
+	require.Nil(t, c.DeleteWebhookDeliveries("wh_1"))
+	deliveries, err = c.WebhookDeliveries("wh_1", 10)
+	require.Nil(t, err)
+	require.Empty(t, deliveries)
+	deliveries, err = c.WebhookDeliveries("wh_2", 10)
+	require.Nil(t, err)
+	require.Equal(t, 1, len(deliveries))
+}
+
+func TestSqliteCache_SearchIndex_Rebuild(t *testing.T) {
+	filename := newSqliteTestCacheFile(t)
+	c := newSqliteTestCacheFromFile(t, filename, "")
+	require.Nil(t, c.AddMessage(newDefaultMessage("mytopic", "some backup message")))
+	_, err := c.db.Exec(dropSearchIndexTriggersQuery) // Simulate cache used without FTS5 support
+	require.Nil(t, err)
+	require.Nil(t, c.AddMessage(newDefaultMessage("mytopic", "another backup message")))
+	require.Nil(t, c.Close())
+
+	c = newSqliteTestCacheFromFile(t, filename, "")
+	messages, err := c.SearchMessages("mytopic", "backup", 10, 0)
+	require.Nil(t, err)
+	require.Equal(t, 2, len(messages))
+}
+
+func TestPostgresCache_Reopen(t *testing.T) {
+	databaseURL := newPostgresTestDatabaseURL(t)
+	c, err := newPostgresCache(databaseURL, "", time.Hour, 0, 0)
+	require.Nil(t, err)
+	require.Nil(t, c.AddMessage(newDefaultMessage("mytopic", "some message")))
+	require.Nil(t, c.Close())
+
+	c, err = newPostgresCache(databaseURL, "", time.Hour, 0, 0) // Schema exists, no migration required
+	require.Nil(t, err)
+	defer c.Close()
+	messages, err := c.Messages("mytopic", sinceAllMessages, false)
+	require.Nil(t, err)
+	require.Equal(t, 1, len(messages))
+	require.Equal(t, "some message", messages[0].Message)
+
+	rows, err := c.db.Query(`SELECT version FROM schema_version WHERE store = 'message'`)
+	require.Nil(t, err)
+	require.True(t, rows.Next())
+	var schemaVersion int
+	require.Nil(t, rows.Scan(&schemaVersion))
+	require.Equal(t, postgresCurrentMessageSchemaVersion, schemaVersion)
+	require.Nil(t, rows.Close())
+}
+
 func checkSchemaVersion(t *testing.T, db *sql.DB) {
 	rows, err := db.Query(`SELECT version FROM schemaVersion`)
 	require.Nil(t, err)
@@ -706,6 +1310,45 @@ func newSqliteTestCacheFromFile(t *testing.T, filename, startupQueries string) *
 	return c
 }
 
+// newPostgresTestCache creates a PostgreSQL-backed cache in a fresh schema of the database given in
+// the NTFY_TEST_DATABASE_URL environment variable. If the variable is not set, the test is skipped.
+func newPostgresTestCache(t *testing.T) *messageCache {
+	c, err := newPostgresCache(newPostgresTestDatabaseURL(t), "", time.Hour, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		c.Close()
+	})
+	return c
+}
+
+func newPostgresTestDatabaseURL(t *testing.T) string {
+	databaseURL := os.Getenv("NTFY_TEST_DATABASE_URL")
+	if databaseURL == "" {
+		t.Skip("NTFY_TEST_DATABASE_URL not set, skipping PostgreSQL test")
+	}
+	db, err := sql.Open("postgres", databaseURL)
+	require.Nil(t, err)
+	defer db.Close()
+	schema := fmt.Sprintf("test_%s", strings.ToLower(util.RandomString(10)))
+	_, err = db.Exec(fmt.Sprintf("CREATE SCHEMA %s", schema))
+	require.Nil(t, err)
+	t.Cleanup(func() {
+		db, err := sql.Open("postgres", databaseURL)
+		require.Nil(t, err)
+		defer db.Close()
+		_, err = db.Exec(fmt.Sprintf("DROP SCHEMA %s CASCADE", schema))
+		require.Nil(t, err)
+	})
+	u, err := url.Parse(databaseURL)
+	require.Nil(t, err)
+	q := u.Query()
+	q.Set("search_path", schema)
+	u.RawQuery = q.Encode()
+	return u.String()
+}
+
 func newMemTestCache(t *testing.T) *messageCache {
 	c, err := newMemCache()
 	if err != nil {
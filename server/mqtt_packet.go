@@ -0,0 +1,265 @@
+package server
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// MQTT control packet types, see MQTT 3.1.1, section 2.2.1
+const (
+	mqttPacketConnect     = 1
+	mqttPacketConnAck     = 2
+	mqttPacketPublish     = 3
+	mqttPacketPubAck      = 4
+	mqttPacketPubRec      = 5
+	mqttPacketPubRel      = 6
+	mqttPacketPubComp     = 7
+	mqttPacketSubscribe   = 8
+	mqttPacketSubAck      = 9
+	mqttPacketUnsubscribe = 10
+	mqttPacketUnsubAck    = 11
+	mqttPacketPingReq     = 12
+	mqttPacketPingResp    = 13
+	mqttPacketDisconnect  = 14
+)
+
+// MQTT CONNACK return codes, see MQTT 3.1.1, section 3.2.2.3
+const (
+	mqttConnAccepted              = 0x00
+	mqttConnRefusedProtocol       = 0x01
+	mqttConnRefusedIdentifier     = 0x02
+	mqttConnRefusedBadCredentials = 0x04
+	mqttConnRefusedNotAuthorized  = 0x05
+)
+
+const (
+	mqttProtocolName      = "MQTT"
+	mqttProtocolLevel     = 4    // MQTT 3.1.1
+	mqttSubAckFailure     = 0x80 // SUBACK return code for a rejected subscription
+	mqttMaxRemainingBytes = 4    // Max number of bytes of the "remaining length" field
+)
+
+var (
+	errMQTTMalformedPacket = errors.New("malformed MQTT packet")
+	errMQTTPacketTooLarge  = errors.New("MQTT packet too large")
+)
+
+// mqttPacket is a raw MQTT control packet, consisting of the fixed header (type and flags), and
+// the rest of the packet (variable header and payload)
+type mqttPacket struct {
+	Type  byte
+	Flags byte
+	Body  []byte
+}
+
+// mqttConnectPacket is a parsed CONNECT packet. Will messages are parsed, but not supported.
+type mqttConnectPacket struct {
+	ProtocolName  string
+	ProtocolLevel byte
+	CleanSession  bool
+	KeepAlive     uint16 // Seconds
+	ClientID      string
+	Username      string
+	Password      string
+}
+
+// mqttPublishPacket is a parsed PUBLISH packet
+type mqttPublishPacket struct {
+	Topic    string
+	QoS      byte
+	Retain   bool
+	PacketID uint16 // Only set if QoS > 0
+	Payload  []byte
+}
+
+// mqttSubscribePacket is a parsed SUBSCRIBE or UNSUBSCRIBE packet. The requested QoS levels of a
+// SUBSCRIBE packet are ignored, since all subscriptions are granted with QoS 0.
+type mqttSubscribePacket struct {
+	PacketID uint16
+	Topics   []string
+}
+
+// readMQTTPacket reads a single control packet, rejecting packets larger than maxSize bytes
+func readMQTTPacket(r *bufio.Reader, maxSize int) (*mqttPacket, error) {
+	header, err := r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	length, multiplier := 0, 1
+	for i := 0; ; i++ {
+		if i == mqttMaxRemainingBytes {
+			return nil, errMQTTMalformedPacket
+		}
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		length += int(b&0x7f) * multiplier
+		multiplier *= 128
+		if b&0x80 == 0 {
+			break
+		}
+	}
+	if length > maxSize {
+		return nil, errMQTTPacketTooLarge
+	}
+	body := make([]byte, length)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, err
+	}
+	return &mqttPacket{Type: header >> 4, Flags: header & 0x0f, Body: body}, nil
+}
+
+// writeMQTTPacket writes a control packet with the given type, flags and body
+func writeMQTTPacket(w io.Writer, packetType, flags byte, body []byte) error {
+	buf := make([]byte, 0, len(body)+1+mqttMaxRemainingBytes)
+	buf = append(buf, packetType<<4|flags)
+	length := len(body)
+	for {
+		b := byte(length % 128)
+		length /= 128
+		if length > 0 {
+			b |= 0x80
+		}
+		buf = append(buf, b)
+		if length == 0 {
+			break
+		}
+	}
+	buf = append(buf, body...)
+	_, err := w.Write(buf)
+	return err
+}
+
+func parseMQTTConnect(p *mqttPacket) (*mqttConnectPacket, error) {
+	d := &mqttDecoder{buf: p.Body}
+	c := &mqttConnectPacket{
+		ProtocolName:  d.String(),
+		ProtocolLevel: d.Byte(),
+	}
+	flags := d.Byte()
+	c.CleanSession = flags&0x02 != 0
+	c.KeepAlive = d.Uint16()
+	c.ClientID = d.String()
+	if flags&0x04 != 0 { // Will flag: will topic and will message are skipped
+		d.Binary()
+		d.Binary()
+	}
+	if flags&0x80 != 0 {
+		c.Username = d.String()
+	}
+	if flags&0x40 != 0 {
+		c.Password = string(d.Binary())
+	}
+	if d.err != nil || flags&0x01 != 0 {
+		return nil, errMQTTMalformedPacket
+	}
+	return c, nil
+}
+
+func parseMQTTPublish(p *mqttPacket) (*mqttPublishPacket, error) {
+	d := &mqttDecoder{buf: p.Body}
+	m := &mqttPublishPacket{
+		Topic:  d.String(),
+		QoS:    (p.Flags >> 1) & 0x03,
+		Retain: p.Flags&0x01 != 0,
+	}
+	if m.QoS > 2 {
+		return nil, errMQTTMalformedPacket
+	} else if m.QoS > 0 {
+		m.PacketID = d.Uint16()
+	}
+	m.Payload = d.Rest()
+	if d.err != nil {
+		return nil, errMQTTMalformedPacket
+	}
+	return m, nil
+}
+
+// parseMQTTSubscribe parses a SUBSCRIBE or UNSUBSCRIBE packet, which only differ in the
+// requested QoS byte after each topic filter
+func parseMQTTSubscribe(p *mqttPacket) (*mqttSubscribePacket, error) {
+	if p.Flags != 0x02 {
+		return nil, errMQTTMalformedPacket
+	}
+	d := &mqttDecoder{buf: p.Body}
+	s := &mqttSubscribePacket{
+		PacketID: d.Uint16(),
+		Topics:   make([]string, 0),
+	}
+	for d.err == nil && len(d.buf) > 0 {
+		s.Topics = append(s.Topics, d.String())
+		if p.Type == mqttPacketSubscribe {
+			d.Byte()
+		}
+	}
+	if d.err != nil || len(s.Topics) == 0 {
+		return nil, errMQTTMalformedPacket
+	}
+	return s, nil
+}
+
+// newMQTTPublishBody returns the body of a PUBLISH packet with QoS 0
+func newMQTTPublishBody(topic string, payload []byte) []byte {
+	body := appendMQTTString(make([]byte, 0, len(topic)+len(payload)+2), topic)
+	return append(body, payload...)
+}
+
+func newMQTTPacketIDBody(packetID uint16, rest ...byte) []byte {
+	return append(binary.BigEndian.AppendUint16(nil, packetID), rest...)
+}
+
+func appendMQTTString(buf []byte, s string) []byte {
+	buf = binary.BigEndian.AppendUint16(buf, uint16(len(s)))
+	return append(buf, s...)
+}
+
+// mqttDecoder reads fields from the body of a control packet. Once a read fails, all
+// subsequent reads return zero values, and err is set.
+type mqttDecoder struct {
+	buf []byte
+	err error
+}
+
+func (d *mqttDecoder) Byte() byte {
+	if d.err != nil || len(d.buf) < 1 {
+		d.err = errMQTTMalformedPacket
+		return 0
+	}
+	b := d.buf[0]
+	d.buf = d.buf[1:]
+	return b
+}
+
+func (d *mqttDecoder) Uint16() uint16 {
+	if d.err != nil || len(d.buf) < 2 {
+		d.err = errMQTTMalformedPacket
+		return 0
+	}
+	v := binary.BigEndian.Uint16(d.buf)
+	d.buf = d.buf[2:]
+	return v
+}
+
+func (d *mqttDecoder) Binary() []byte {
+	length := int(d.Uint16())
+	if d.err != nil || len(d.buf) < length {
+		d.err = errMQTTMalformedPacket
+		return nil
+	}
+	b := d.buf[:length]
+	d.buf = d.buf[length:]
+	return b
+}
+
+func (d *mqttDecoder) String() string {
+	return string(d.Binary())
+}
+
+func (d *mqttDecoder) Rest() []byte {
+	b := d.buf
+	d.buf = nil
+	return b
+}
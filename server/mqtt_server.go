@@ -0,0 +1,372 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"time"
+
+	"heckel.io/ntfy/v2/user"
+	"heckel.io/ntfy/v2/util"
+)
+
+const (
+	mqttConnectTimeout = 10 * time.Second // Time a client has to send the CONNECT packet
+	mqttWriteTimeout   = 10 * time.Second
+	mqttMaxPacketSize  = 1024 * 1024 // Must be much larger than message size, same as for the SMTP server
+)
+
+var (
+	errMQTTUnexpectedPacket  = errors.New("unexpected MQTT packet")
+	errMQTTProtocolMismatch  = errors.New("unsupported MQTT protocol version, only MQTT 3.1.1 is supported")
+	errMQTTClientIDMissing   = errors.New("client identifier required for persistent sessions")
+	errMQTTSubscriptionLimit = errors.New("too many subscriptions")
+)
+
+// mqttServer is a minimal MQTT 3.1.1 broker that maps MQTT topics to ntfy topics. Published messages are passed
+// to the HTTP handler (like e-mails received via the SMTP server), so that rate limits and access control apply
+// as for HTTP requests. Subscribers receive messages as JSON payloads.
+//
+// Only what is needed for publishing and subscribing is implemented: subscriptions are always granted with QoS 0,
+// wildcards and retained messages are not supported, and sessions are not persisted.
+type mqttServer struct {
+	server   *Server
+	listener net.Listener
+	sessions map[*mqttSession]struct{}
+	mu       sync.Mutex
+}
+
+func newMQTTServer(s *Server) *mqttServer {
+	return &mqttServer{
+		server:   s,
+		sessions: make(map[*mqttSession]struct{}),
+	}
+}
+
+// ListenAndServe listens on the given TCP address, and handles MQTT connections until Close is called
+func (m *mqttServer) ListenAndServe(addr string) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return m.Serve(listener)
+}
+
+// Serve handles MQTT connections on the given listener until Close is called
+func (m *mqttServer) Serve(listener net.Listener) error {
+	m.mu.Lock()
+	m.listener = listener
+	m.mu.Unlock()
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return err
+		}
+		go m.handleConn(conn)
+	}
+}
+
+// Close stops the listener and closes all client connections
+func (m *mqttServer) Close() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.listener != nil {
+		m.listener.Close()
+	}
+	for s := range m.sessions {
+		s.conn.Close()
+	}
+}
+
+func (m *mqttServer) handleConn(conn net.Conn) {
+	s := newMQTTSession(m.server, conn)
+	m.mu.Lock()
+	m.sessions[s] = struct{}{}
+	m.mu.Unlock()
+	defer func() {
+		m.mu.Lock()
+		delete(m.sessions, s)
+		m.mu.Unlock()
+		conn.Close()
+	}()
+	logmq(s).Debug("MQTT connection opened")
+	if err := s.run(); err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, net.ErrClosed) {
+		logmq(s).Err(err).Debug("MQTT connection closed with error")
+		return
+	}
+	logmq(s).Debug("MQTT connection closed")
+}
+
+// mqttSession is a single MQTT client connection
+type mqttSession struct {
+	server        *Server
+	conn          net.Conn
+	reader        *bufio.Reader
+	remoteAddr    string // IP address of the client, without the port (for rate limiting)
+	clientID      string
+	authorization string   // Authorization header passed to the HTTP handler, empty for anonymous clients
+	visitor       *visitor // Set after a successful CONNECT
+	subscriptions map[string]*mqttSubscription
+	mu            sync.Mutex // Serializes writes to conn
+}
+
+type mqttSubscription struct {
+	topic        *topic
+	subscriberID int
+}
+
+func newMQTTSession(s *Server, conn net.Conn) *mqttSession {
+	remoteAddr, _, err := net.SplitHostPort(conn.RemoteAddr().String())
+	if err != nil {
+		remoteAddr = conn.RemoteAddr().String()
+	}
+	return &mqttSession{
+		server:        s,
+		conn:          conn,
+		reader:        bufio.NewReader(conn),
+		remoteAddr:    remoteAddr,
+		subscriptions: make(map[string]*mqttSubscription),
+	}
+}
+
+func (s *mqttSession) run() error {
+	defer s.unsubscribeAll()
+	if err := s.conn.SetReadDeadline(time.Now().Add(mqttConnectTimeout)); err != nil {
+		return err
+	}
+	p, err := readMQTTPacket(s.reader, mqttMaxPacketSize)
+	if err != nil {
+		return err
+	} else if p.Type != mqttPacketConnect {
+		return errMQTTUnexpectedPacket
+	}
+	keepAlive, err := s.handleConnect(p)
+	if err != nil {
+		return err
+	}
+	for {
+		var deadline time.Time
+		if keepAlive > 0 {
+			deadline = time.Now().Add(keepAlive * 3 / 2) // Grace period, see MQTT 3.1.1, section 3.1.2.10
+		}
+		if err := s.conn.SetReadDeadline(deadline); err != nil {
+			return err
+		}
+		p, err := readMQTTPacket(s.reader, mqttMaxPacketSize)
+		if err != nil {
+			return err
+		}
+		switch p.Type {
+		case mqttPacketPublish:
+			err = s.handlePublish(p)
+		case mqttPacketPubRel:
+			err = s.write(mqttPacketPubComp, 0, p.Body) // Body is the packet ID
+		case mqttPacketSubscribe:
+			err = s.handleSubscribe(p)
+		case mqttPacketUnsubscribe:
+			err = s.handleUnsubscribe(p)
+		case mqttPacketPingReq:
+			s.keepalive()
+			err = s.write(mqttPacketPingResp, 0, nil)
+		case mqttPacketDisconnect:
+			return nil
+		default:
+			return errMQTTUnexpectedPacket
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// handleConnect authenticates the client with the username and password of the CONNECT packet, the
+// same way as HTTP basic auth: if the username is empty, the password is treated as an access token.
+func (s *mqttSession) handleConnect(p *mqttPacket) (keepAlive time.Duration, err error) {
+	connect, err := parseMQTTConnect(p)
+	if err != nil {
+		return 0, err
+	} else if connect.ProtocolName != mqttProtocolName || connect.ProtocolLevel != mqttProtocolLevel {
+		s.connAck(mqttConnRefusedProtocol)
+		return 0, errMQTTProtocolMismatch
+	} else if connect.ClientID == "" && !connect.CleanSession {
+		s.connAck(mqttConnRefusedIdentifier)
+		return 0, errMQTTClientIDMissing
+	}
+	s.clientID = connect.ClientID
+	if connect.Username != "" || connect.Password != "" {
+		s.authorization = util.BasicAuth(connect.Username, connect.Password)
+	}
+	req, err := s.newRequest(http.MethodGet, "/", nil)
+	if err != nil {
+		return 0, err
+	}
+	v, err := s.server.maybeAuthenticate(req)
+	if err != nil {
+		code := byte(mqttConnRefusedNotAuthorized) // Rate limited, or two-factor authentication required
+		if err == errHTTPUnauthorized {
+			code = mqttConnRefusedBadCredentials
+		}
+		s.connAck(code)
+		return 0, err
+	}
+	s.visitor = v
+	logmq(s).Debug("MQTT client connected")
+	return time.Duration(connect.KeepAlive) * time.Second, s.connAck(mqttConnAccepted)
+}
+
+// handlePublish passes the message on to the HTTP handler. Since MQTT 3.1.1 has no way to reject a message,
+// messages that are rejected (e.g. due to rate limits or access control) are acknowledged and dropped. QoS 2
+// is only implemented as far as the protocol requires, so messages are delivered at least once.
+func (s *mqttSession) handlePublish(p *mqttPacket) error {
+	m, err := parseMQTTPublish(p)
+	if err != nil {
+		return err
+	}
+	if err := s.publish(m.Topic, m.Payload); err != nil {
+		logmq(s).Field("mqtt_topic", m.Topic).Err(err).Debug("Dropping MQTT message")
+	}
+	switch m.QoS {
+	case 1:
+		return s.write(mqttPacketPubAck, 0, newMQTTPacketIDBody(m.PacketID))
+	case 2:
+		return s.write(mqttPacketPubRec, 0, newMQTTPacketIDBody(m.PacketID))
+	}
+	return nil
+}
+
+func (s *mqttSession) publish(topicID string, payload []byte) error {
+	if !topicRegex.MatchString(topicID) {
+		return errInvalidTopic
+	}
+	req, err := s.newRequest(http.MethodPost, "/"+topicID, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	rr := httptest.NewRecorder()
+	s.server.handle(rr, req)
+	if rr.Code != http.StatusOK {
+		return errors.New("error: " + strings.TrimSpace(rr.Body.String()))
+	}
+	return nil
+}
+
+func (s *mqttSession) handleSubscribe(p *mqttPacket) error {
+	sub, err := parseMQTTSubscribe(p)
+	if err != nil {
+		return err
+	}
+	codes := make([]byte, len(sub.Topics)) // 0x00 means granted with QoS 0
+	for i, topicID := range sub.Topics {
+		if err := s.subscribe(topicID); err != nil {
+			logmq(s).Field("mqtt_topic", topicID).Err(err).Debug("Rejecting MQTT subscription")
+			codes[i] = mqttSubAckFailure
+		}
+	}
+	return s.write(mqttPacketSubAck, 0, newMQTTPacketIDBody(sub.PacketID, codes...))
+}
+
+// subscribe subscribes the client to the given ntfy topic, if the client is allowed to read it. Topic filters
+// with wildcards (+ and #) are rejected, since they are not valid topic names.
+func (s *mqttSession) subscribe(topicID string) error {
+	if _, ok := s.subscriptions[topicID]; ok {
+		return nil
+	} else if !topicRegex.MatchString(topicID) {
+		return errInvalidTopic
+	}
+	t, err := s.server.topicFromID(topicID)
+	if err != nil {
+		return err
+	}
+	if s.server.userManager != nil {
+		if err := s.server.userManager.Authorize(s.visitor.User(), t.ID, user.PermissionRead); err != nil {
+			return err
+		}
+	}
+	if !s.visitor.SubscriptionAllowed() {
+		return errMQTTSubscriptionLimit
+	}
+	cancel := func() {
+		s.conn.Close() // Access was revoked; the client has to reconnect and subscribe again
+	}
+	s.subscriptions[topicID] = &mqttSubscription{
+		topic:        t,
+		subscriberID: t.Subscribe(s.forward, s.visitor.MaybeUserID(), cancel),
+	}
+	return nil
+}
+
+func (s *mqttSession) handleUnsubscribe(p *mqttPacket) error {
+	unsub, err := parseMQTTSubscribe(p)
+	if err != nil {
+		return err
+	}
+	for _, topicID := range unsub.Topics {
+		if sub, ok := s.subscriptions[topicID]; ok {
+			sub.topic.Unsubscribe(sub.subscriberID)
+			s.visitor.RemoveSubscription()
+			delete(s.subscriptions, topicID)
+		}
+	}
+	return s.write(mqttPacketUnsubAck, 0, newMQTTPacketIDBody(unsub.PacketID))
+}
+
+func (s *mqttSession) unsubscribeAll() {
+	for topicID, sub := range s.subscriptions {
+		sub.topic.Unsubscribe(sub.subscriberID)
+		s.visitor.RemoveSubscription()
+		delete(s.subscriptions, topicID)
+	}
+}
+
+// forward sends a message to the client as a JSON payload, in the same format as the JSON stream
+func (s *mqttSession) forward(_ *visitor, m *message) error {
+	payload, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	return s.write(mqttPacketPublish, 0, newMQTTPublishBody(m.Topic, payload))
+}
+
+func (s *mqttSession) keepalive() {
+	s.visitor.Keepalive()
+	for _, sub := range s.subscriptions {
+		sub.topic.Keepalive()
+	}
+}
+
+func (s *mqttSession) connAck(code byte) error {
+	return s.write(mqttPacketConnAck, 0, []byte{0x00, code}) // Session present flag is never set
+}
+
+func (s *mqttSession) write(packetType, flags byte, body []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.conn.SetWriteDeadline(time.Now().Add(mqttWriteTimeout)); err != nil {
+		return err
+	}
+	return writeMQTTPacket(s.conn, packetType, flags, body)
+}
+
+// newRequest creates a fake HTTP request on behalf of the client, including the client's IP address
+// (for rate limiting) and credentials
+func (s *mqttSession) newRequest(method, path string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequest(method, fmt.Sprintf("%s%s", s.server.config.BaseURL, path), body)
+	if err != nil {
+		return nil, err
+	}
+	req.RequestURI = path         // just for the logs
+	req.RemoteAddr = s.remoteAddr // rate limiting!!
+	req.Header.Set("X-Forwarded-For", s.remoteAddr)
+	if s.authorization != "" {
+		req.Header.Set("Authorization", s.authorization)
+	}
+	return req, nil
+}
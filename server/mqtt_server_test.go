@@ -0,0 +1,237 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"net"
+	"net/netip"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"heckel.io/ntfy/v2/user"
+	"heckel.io/ntfy/v2/util"
+)
+
+func TestMQTTServer_PublishAndSubscribe(t *testing.T) {
+	s, addr := newTestMQTTServer(t, newTestConfig(t))
+
+	subscriber := newTestMQTTClient(t, addr)
+	require.Equal(t, byte(mqttConnAccepted), subscriber.Connect("subscriber", "", ""))
+	require.Equal(t, []byte{0x00, 0x00}, subscriber.Subscribe(1, "mytopic", "othertopic"))
+
+	publisher := newTestMQTTClient(t, addr)
+	require.Equal(t, byte(mqttConnAccepted), publisher.Connect("publisher", "", ""))
+	publisher.Publish(7, "mytopic", "hi there") // QoS 1, waits for PUBACK
+
+	topic, payload := subscriber.ReadPublish()
+	require.Equal(t, "mytopic", topic)
+	var m message
+	require.Nil(t, json.Unmarshal(payload, &m))
+	require.Equal(t, messageEvent, m.Event)
+	require.Equal(t, "mytopic", m.Topic)
+	require.Equal(t, "hi there", m.Message)
+
+	// Message went through the regular publishing path, and was cached
+	rr := request(t, s, "GET", "/mytopic/json?poll=1", "", nil)
+	require.Equal(t, "hi there", toMessage(t, rr.Body.String()).Message)
+
+	// Unsubscribe, and ping
+	require.Nil(t, writeMQTTPacket(subscriber.conn, mqttPacketUnsubscribe, 0x02, newMQTTPacketIDBody(2, appendMQTTString(nil, "mytopic")...)))
+	require.Equal(t, byte(mqttPacketUnsubAck), subscriber.Read().Type)
+	require.Nil(t, writeMQTTPacket(subscriber.conn, mqttPacketPingReq, 0, nil))
+	require.Equal(t, byte(mqttPacketPingResp), subscriber.Read().Type)
+}
+
+func TestMQTTServer_PublishQoS0AndQoS2(t *testing.T) {
+	s, addr := newTestMQTTServer(t, newTestConfig(t))
+	c := newTestMQTTClient(t, addr)
+	require.Equal(t, byte(mqttConnAccepted), c.Connect("client", "", ""))
+
+	require.Nil(t, writeMQTTPacket(c.conn, mqttPacketPublish, 0, newMQTTPublishBody("mytopic", []byte("qos 0"))))
+	body := appendMQTTString(nil, "mytopic")
+	body = binary.BigEndian.AppendUint16(body, 9)
+	require.Nil(t, writeMQTTPacket(c.conn, mqttPacketPublish, 2<<1, append(body, "qos 2"...)))
+	p := c.Read()
+	require.Equal(t, byte(mqttPacketPubRec), p.Type)
+	require.Equal(t, []byte{0x00, 0x09}, p.Body)
+	require.Nil(t, writeMQTTPacket(c.conn, mqttPacketPubRel, 0x02, p.Body))
+	p = c.Read()
+	require.Equal(t, byte(mqttPacketPubComp), p.Type)
+	require.Equal(t, []byte{0x00, 0x09}, p.Body)
+
+	rr := request(t, s, "GET", "/mytopic/json?poll=1", "", nil)
+	lines := strings.Split(strings.TrimSpace(rr.Body.String()), "\n")
+	require.Len(t, lines, 2)
+	require.Equal(t, "qos 0", toMessage(t, lines[0]).Message)
+	require.Equal(t, "qos 2", toMessage(t, lines[1]).Message)
+}
+
+func TestMQTTServer_Auth(t *testing.T) {
+	conf := newTestConfigWithAuthFile(t)
+	conf.AuthDefault = user.PermissionDenyAll
+	s, addr := newTestMQTTServer(t, conf)
+	require.Nil(t, s.userManager.AddUser("phil", "phil", user.RoleUser))
+	require.Nil(t, s.userManager.AllowAccess("phil", "mytopic", user.PermissionReadWrite))
+	u, err := s.userManager.User("phil")
+	require.Nil(t, err)
+	token, err := s.userManager.CreateToken(u.ID, "", time.Unix(0, 0), netip.IPv4Unspecified(), nil)
+	require.Nil(t, err)
+
+	// Wrong password
+	c := newTestMQTTClient(t, addr)
+	require.Equal(t, byte(mqttConnRefusedBadCredentials), c.Connect("client", "phil", "wrong"))
+
+	// Anonymous: cannot subscribe or publish
+	anon := newTestMQTTClient(t, addr)
+	require.Equal(t, byte(mqttConnAccepted), anon.Connect("anon", "", ""))
+	require.Equal(t, []byte{mqttSubAckFailure}, anon.Subscribe(1, "mytopic"))
+	anon.Publish(2, "mytopic", "not allowed")
+
+	// User: can subscribe and publish
+	phil := newTestMQTTClient(t, addr)
+	require.Equal(t, byte(mqttConnAccepted), phil.Connect("phil", "phil", "phil"))
+	require.Equal(t, []byte{0x00, mqttSubAckFailure, mqttSubAckFailure}, phil.Subscribe(1, "mytopic", "othertopic", "my/+"))
+
+	// Token as password
+	tokenClient := newTestMQTTClient(t, addr)
+	require.Equal(t, byte(mqttConnAccepted), tokenClient.Connect("token", "", token.Value))
+	tokenClient.Publish(1, "mytopic", "from token")
+
+	topic, payload := phil.ReadPublish()
+	require.Equal(t, "mytopic", topic)
+	require.Contains(t, string(payload), `"message":"from token"`)
+
+	rr := request(t, s, "GET", "/mytopic/json?poll=1", "", map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 200, rr.Code)
+	require.Equal(t, 1, strings.Count(rr.Body.String(), `"event":"message"`))
+	require.NotContains(t, rr.Body.String(), "not allowed")
+}
+
+func TestMQTTServer_UnsupportedProtocol(t *testing.T) {
+	_, addr := newTestMQTTServer(t, newTestConfig(t))
+	c := newTestMQTTClient(t, addr)
+	body := appendMQTTString(nil, "MQTT")
+	body = append(body, 5, 0x02, 0, 60) // MQTT 5
+	body = appendMQTTString(body, "client")
+	require.Nil(t, writeMQTTPacket(c.conn, mqttPacketConnect, 0, body))
+	p := c.Read()
+	require.Equal(t, byte(mqttPacketConnAck), p.Type)
+	require.Equal(t, []byte{0x00, mqttConnRefusedProtocol}, p.Body)
+}
+
+func TestMQTTPacket_ReadWrite(t *testing.T) {
+	for _, size := range []int{0, 127, 128, 16383, 16384, 200000} {
+		var buf bytes.Buffer
+		body := bytes.Repeat([]byte("x"), size)
+		require.Nil(t, writeMQTTPacket(&buf, mqttPacketPublish, 0x03, body))
+		p, err := readMQTTPacket(bufio.NewReader(&buf), mqttMaxPacketSize)
+		require.Nil(t, err)
+		require.Equal(t, byte(mqttPacketPublish), p.Type)
+		require.Equal(t, byte(0x03), p.Flags)
+		require.Equal(t, body, p.Body)
+	}
+
+	var buf bytes.Buffer
+	require.Nil(t, writeMQTTPacket(&buf, mqttPacketPublish, 0, make([]byte, 1000)))
+	_, err := readMQTTPacket(bufio.NewReader(&buf), 999)
+	require.Equal(t, errMQTTPacketTooLarge, err)
+
+	_, err = readMQTTPacket(bufio.NewReader(bytes.NewReader([]byte{0x30, 0xff, 0xff, 0xff, 0xff, 0x01})), mqttMaxPacketSize)
+	require.Equal(t, errMQTTMalformedPacket, err)
+
+	_, err = parseMQTTSubscribe(&mqttPacket{Type: mqttPacketSubscribe, Flags: 0x02, Body: []byte{0x00, 0x01}})
+	require.Equal(t, errMQTTMalformedPacket, err)
+	_, err = parseMQTTConnect(&mqttPacket{Type: mqttPacketConnect, Body: appendMQTTString(nil, "MQTT")})
+	require.Equal(t, errMQTTMalformedPacket, err)
+}
+
+func newTestMQTTServer(t *testing.T, conf *Config) (*Server, string) {
+	s := newTestServer(t, conf)
+	m := newMQTTServer(s)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err)
+	go m.Serve(listener)
+	t.Cleanup(func() {
+		m.Close()
+		s.closeDatabases()
+	})
+	return s, listener.Addr().String()
+}
+
+type testMQTTClient struct {
+	t      *testing.T
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+func newTestMQTTClient(t *testing.T, addr string) *testMQTTClient {
+	conn, err := net.Dial("tcp", addr)
+	require.Nil(t, err)
+	t.Cleanup(func() { conn.Close() })
+	return &testMQTTClient{t: t, conn: conn, reader: bufio.NewReader(conn)}
+}
+
+func (c *testMQTTClient) Connect(clientID, username, password string) byte {
+	flags := byte(0x02) // Clean session
+	if username != "" {
+		flags |= 0x80
+	}
+	if password != "" {
+		flags |= 0x40
+	}
+	body := appendMQTTString(nil, mqttProtocolName)
+	body = append(body, mqttProtocolLevel, flags, 0, 60)
+	body = appendMQTTString(body, clientID)
+	if username != "" {
+		body = appendMQTTString(body, username)
+	}
+	if password != "" {
+		body = appendMQTTString(body, password)
+	}
+	require.Nil(c.t, writeMQTTPacket(c.conn, mqttPacketConnect, 0, body))
+	p := c.Read()
+	require.Equal(c.t, byte(mqttPacketConnAck), p.Type)
+	require.Len(c.t, p.Body, 2)
+	return p.Body[1]
+}
+
+func (c *testMQTTClient) Subscribe(packetID uint16, topics ...string) []byte {
+	body := binary.BigEndian.AppendUint16(nil, packetID)
+	for _, topic := range topics {
+		body = append(appendMQTTString(body, topic), 0x01) // Requested QoS 1, granted QoS 0
+	}
+	require.Nil(c.t, writeMQTTPacket(c.conn, mqttPacketSubscribe, 0x02, body))
+	p := c.Read()
+	require.Equal(c.t, byte(mqttPacketSubAck), p.Type)
+	require.Equal(c.t, packetID, binary.BigEndian.Uint16(p.Body))
+	return p.Body[2:]
+}
+
+func (c *testMQTTClient) Publish(packetID uint16, topic, message string) {
+	body := binary.BigEndian.AppendUint16(appendMQTTString(nil, topic), packetID)
+	require.Nil(c.t, writeMQTTPacket(c.conn, mqttPacketPublish, 1<<1, append(body, message...)))
+	p := c.Read()
+	require.Equal(c.t, byte(mqttPacketPubAck), p.Type)
+	require.Equal(c.t, packetID, binary.BigEndian.Uint16(p.Body))
+}
+
+func (c *testMQTTClient) ReadPublish() (topic string, payload []byte) {
+	p := c.Read()
+	require.Equal(c.t, byte(mqttPacketPublish), p.Type)
+	m, err := parseMQTTPublish(p)
+	require.Nil(c.t, err)
+	return m.Topic, m.Payload
+}
+
+func (c *testMQTTClient) Read() *mqttPacket {
+	require.Nil(c.t, c.conn.SetReadDeadline(time.Now().Add(5*time.Second)))
+	p, err := readMQTTPacket(c.reader, mqttMaxPacketSize)
+	require.Nil(c.t, err)
+	return p
+}
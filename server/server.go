@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"context"
 	"crypto/sha256"
+	"crypto/x509"
 	"embed"
 	"encoding/base64"
 	"encoding/json"
@@ -46,17 +47,22 @@ type Server struct {
 	smtpServer        *smtp.Server
 	smtpServerBackend *smtpBackend
 	smtpSender        mailer
+	mqttServer        *mqttServer
 	topics            map[string]*topic
 	visitors          map[string]*visitor // ip:<ip> or user:<user>
 	firebaseClient    *firebaseClient
+	webhookClient     *http.Client                        // Refuses to connect to non-public addresses, see newWebhookClient
 	messages          int64                               // Total number of messages (persisted if messageCache enabled)
 	messagesHistory   []int64                             // Last n values of the messages counter, used to determine rate
 	userManager       *user.Manager                       // Might be nil!
+	auther            user.Auther                         // Authenticates username/password; userManager, or LDAP (might be nil!)
 	messageCache      *messageCache                       // Database that stores the messages
 	webPush           *webPushStore                       // Database that stores web push subscriptions
 	fileCache         *fileCache                          // File system based cache that stores attachments
 	stripe            stripeAPI                           // Stripe API, can be replaced with a mock
 	priceCache        *util.LookupCache[map[string]int64] // Stripe price ID -> price as cents (USD implied!)
+	oidcProvider      *util.LookupCache[*oidcProvider]    // OpenID Connect endpoints and signing keys
+	clientCAs         *x509.CertPool                      // CAs to verify client certificates against (might be nil!)
 	metricsHandler    http.Handler                        // Handles /metrics if enable-metrics set, and listen-metrics-http not set
 	closeChan         chan bool
 	mu                sync.RWMutex
@@ -67,15 +73,24 @@ type handleFunc func(http.ResponseWriter, *http.Request, *visitor) error
 
 var (
 	// If changed, don't forget to update Android App and auth_sqlite.go
-	topicRegex             = regexp.MustCompile(`^[-_A-Za-z0-9]{1,64}$`)               // No /!
-	topicPathRegex         = regexp.MustCompile(`^/[-_A-Za-z0-9]{1,64}$`)              // Regex must match JS & Android app!
-	externalTopicPathRegex = regexp.MustCompile(`^/[^/]+\.[^/]+/[-_A-Za-z0-9]{1,64}$`) // Extended topic path, for web-app, e.g. /example.com/mytopic
-	jsonPathRegex          = regexp.MustCompile(`^/[-_A-Za-z0-9]{1,64}(,[-_A-Za-z0-9]{1,64})*/json$`)
-	ssePathRegex           = regexp.MustCompile(`^/[-_A-Za-z0-9]{1,64}(,[-_A-Za-z0-9]{1,64})*/sse$`)
-	rawPathRegex           = regexp.MustCompile(`^/[-_A-Za-z0-9]{1,64}(,[-_A-Za-z0-9]{1,64})*/raw$`)
-	wsPathRegex            = regexp.MustCompile(`^/[-_A-Za-z0-9]{1,64}(,[-_A-Za-z0-9]{1,64})*/ws$`)
-	authPathRegex          = regexp.MustCompile(`^/[-_A-Za-z0-9]{1,64}(,[-_A-Za-z0-9]{1,64})*/auth$`)
-	publishPathRegex       = regexp.MustCompile(`^/[-_A-Za-z0-9]{1,64}/(publish|send|trigger)$`)
+	topicRegex                = regexp.MustCompile(`^[-_A-Za-z0-9]{1,64}$`)               // No /!
+	topicPathRegex            = regexp.MustCompile(`^/[-_A-Za-z0-9]{1,64}$`)              // Regex must match JS & Android app!
+	externalTopicPathRegex    = regexp.MustCompile(`^/[^/]+\.[^/]+/[-_A-Za-z0-9]{1,64}$`) // Extended topic path, for web-app, e.g. /example.com/mytopic
+	jsonPathRegex             = regexp.MustCompile(`^/[-_A-Za-z0-9]{1,64}(,[-_A-Za-z0-9]{1,64})*/json$`)
+	ssePathRegex              = regexp.MustCompile(`^/[-_A-Za-z0-9]{1,64}(,[-_A-Za-z0-9]{1,64})*/sse$`)
+	rawPathRegex              = regexp.MustCompile(`^/[-_A-Za-z0-9]{1,64}(,[-_A-Za-z0-9]{1,64})*/raw$`)
+	wsPathRegex               = regexp.MustCompile(`^/[-_A-Za-z0-9]{1,64}(,[-_A-Za-z0-9]{1,64})*/ws$`)
+	authPathRegex             = regexp.MustCompile(`^/[-_A-Za-z0-9]{1,64}(,[-_A-Za-z0-9]{1,64})*/auth$`)
+	publishPathRegex          = regexp.MustCompile(`^/[-_A-Za-z0-9]{1,64}/(publish|send|trigger)$`)
+	messagePathRegex          = regexp.MustCompile(`^/[-_A-Za-z0-9]{1,64}/([-_A-Za-z0-9]{12})$`)
+	searchPathRegex           = regexp.MustCompile(`^/([-_A-Za-z0-9]{1,64})/search$`)
+	messagesPathRegex         = regexp.MustCompile(`^/([-_A-Za-z0-9]{1,64})/messages$`)
+	schedulesPathRegex        = regexp.MustCompile(`^/([-_A-Za-z0-9]{1,64})/schedules$`)
+	scheduleSinglePathRegex   = regexp.MustCompile(`^/([-_A-Za-z0-9]{1,64})/schedules/(sc_[A-Za-z0-9]{13})$`)
+	scheduledPathRegex        = regexp.MustCompile(`^/([-_A-Za-z0-9]{1,64})/scheduled$`)
+	scheduledMessagePathRegex = regexp.MustCompile(`^/([-_A-Za-z0-9]{1,64})/scheduled/([-_A-Za-z0-9]{12})$`)
+	ackPathRegex              = regexp.MustCompile(`^/[-_A-Za-z0-9]{1,64}/([-_A-Za-z0-9]{12})/ack$`)
+	alertmanagerPathRegex     = regexp.MustCompile(`^/[-_A-Za-z0-9]{1,64}/alertmanager$`)
 
 	webConfigPath                                        = "/config.js"
 	webManifestPath                                      = "/manifest.webmanifest"
@@ -83,6 +98,8 @@ var (
 	webServiceWorkerPath                                 = "/sw.js"
 	accountPath                                          = "/account"
 	matrixPushPath                                       = "/_matrix/push/v1/notify"
+	gotifyMessagePath                                    = "/message"
+	gotifyStreamPath                                     = "/stream"
 	metricsPath                                          = "/metrics"
 	apiHealthPath                                        = "/v1/health"
 	apiStatsPath                                         = "/v1/stats"
@@ -90,20 +107,33 @@ var (
 	apiTiersPath                                         = "/v1/tiers"
 	apiUsersPath                                         = "/v1/users"
 	apiUsersAccessPath                                   = "/v1/users/access"
+	apiGroupsPath                                        = "/v1/groups"
+	apiGroupsMembersPath                                 = "/v1/groups/members"
+	apiGroupsAccessPath                                  = "/v1/groups/access"
+	apiAuditPath                                         = "/v1/audit"
+	apiTopicsRetentionPath                               = "/v1/topics/retention"
 	apiAccountPath                                       = "/v1/account"
 	apiAccountTokenPath                                  = "/v1/account/token"
+	apiAccountTwoFactorPath                              = "/v1/account/2fa"
 	apiAccountPasswordPath                               = "/v1/account/password"
 	apiAccountSettingsPath                               = "/v1/account/settings"
 	apiAccountSubscriptionPath                           = "/v1/account/subscription"
 	apiAccountReservationPath                            = "/v1/account/reservation"
 	apiAccountPhonePath                                  = "/v1/account/phone"
 	apiAccountPhoneVerifyPath                            = "/v1/account/phone/verify"
+	apiAccountOIDCPath                                   = "/v1/account/oidc"
+	apiAccountOIDCLoginPath                              = "/v1/account/oidc/login"
+	apiAccountOIDCCallbackPath                           = "/v1/account/oidc/callback"
 	apiAccountBillingPortalPath                          = "/v1/account/billing/portal"
 	apiAccountBillingWebhookPath                         = "/v1/account/billing/webhook"
 	apiAccountBillingSubscriptionPath                    = "/v1/account/billing/subscription"
 	apiAccountBillingSubscriptionCheckoutSuccessTemplate = "/v1/account/billing/subscription/success/{CHECKOUT_SESSION_ID}"
 	apiAccountBillingSubscriptionCheckoutSuccessRegex    = regexp.MustCompile(`/v1/account/billing/subscription/success/(.+)$`)
 	apiAccountReservationSingleRegex                     = regexp.MustCompile(`/v1/account/reservation/([-_A-Za-z0-9]{1,64})$`)
+	apiAccountReservationSignedURLRegex                  = regexp.MustCompile(`/v1/account/reservation/([-_A-Za-z0-9]{1,64})/signed-url$`)
+	apiAccountReservationWebhookRegex                    = regexp.MustCompile(`/v1/account/reservation/([-_A-Za-z0-9]{1,64})/webhook$`)
+	apiAccountReservationWebhookSingleRegex              = regexp.MustCompile(`/v1/account/reservation/([-_A-Za-z0-9]{1,64})/webhook/(wh_[A-Za-z0-9]{9})$`)
+	apiAccountReservationWebhookDeliveriesRegex          = regexp.MustCompile(`/v1/account/reservation/([-_A-Za-z0-9]{1,64})/webhook/(wh_[A-Za-z0-9]{9})/deliveries$`)
 	staticRegex                                          = regexp.MustCompile(`^/static/.+`)
 	docsRegex                                            = regexp.MustCompile(`^/docs(|/.*)$`)
 	fileRegex                                            = regexp.MustCompile(`^/file/([-_A-Za-z0-9]{1,64})(?:\.[A-Za-z0-9]{1,16})?$`)
@@ -132,6 +162,10 @@ const (
 	unifiedPushTopicPrefix   = "up"                      // Temporarily, we rate limit all "up*" topics based on the subscriber
 	unifiedPushTopicLength   = 14                        // Length of UnifiedPush topics, including the "up" part
 	messagesHistoryMax       = 10                        // Number of message count values to keep in memory
+	searchLimitDefault       = 20                        // Number of search results returned, if no limit is passed
+	searchLimitMax           = 100                       // Max number of search results per page
+	messagesLimitDefault     = 50                        // Number of messages per history page, if no limit is passed
+	messagesLimitMax         = 500                       // Max number of messages per history page
 )
 
 // WebSocket constants
@@ -185,6 +219,36 @@ func New(conf *Config) (*Server, error) {
 		if err != nil {
 			return nil, err
 		}
+		userManager.SetPasswordPolicy(&user.PasswordPolicy{
+			MinLength:        conf.AuthPasswordMinLength,
+			MinClasses:       conf.AuthPasswordMinClasses,
+			DisallowUsername: conf.AuthPasswordDisallowUsername,
+		})
+		userManager.SetLockout(conf.AuthLockoutAttempts, conf.AuthLockoutDuration)
+	}
+	// This awkward logic is required because Go is weird about nil types and interfaces.
+	// See issue #641, and https://go.dev/play/p/uur1flrv1t3 for an example
+	var auther user.Auther
+	if userManager != nil && conf.AuthLDAPURL != "" {
+		auther = user.NewLDAPAuther(userManager, &user.LDAPConfig{
+			URL:            conf.AuthLDAPURL,
+			BindDN:         conf.AuthLDAPBindDN,
+			BindPassword:   conf.AuthLDAPBindPassword,
+			BaseDN:         conf.AuthLDAPBaseDN,
+			UserFilter:     conf.AuthLDAPUserFilter,
+			GroupAttribute: conf.AuthLDAPGroupAttribute,
+			GroupAccess:    conf.AuthLDAPGroupAccess,
+			CacheDuration:  conf.AuthLDAPCacheDuration,
+		})
+	} else if userManager != nil {
+		auther = userManager
+	}
+	var clientCAs *x509.CertPool
+	if conf.ClientCAFile != "" {
+		clientCAs, err = newClientCAPool(conf.ClientCAFile)
+		if err != nil {
+			return nil, err
+		}
 	}
 	var firebaseClient *firebaseClient
 	if conf.FirebaseKeyFile != "" {
@@ -192,12 +256,6 @@ func New(conf *Config) (*Server, error) {
 		if err != nil {
 			return nil, err
 		}
-		// This awkward logic is required because Go is weird about nil types and interfaces.
-		// See issue #641, and https://go.dev/play/p/uur1flrv1t3 for an example
-		var auther user.Auther
-		if userManager != nil {
-			auther = userManager
-		}
 		firebaseClient = newFirebaseClient(sender, auther)
 	}
 	s := &Server{
@@ -209,18 +267,24 @@ func New(conf *Config) (*Server, error) {
 		smtpSender:      mailer,
 		topics:          topics,
 		userManager:     userManager,
+		auther:          auther,
 		messages:        messages,
 		messagesHistory: []int64{messages},
 		visitors:        make(map[string]*visitor),
 		stripe:          stripe,
+		clientCAs:       clientCAs,
+		webhookClient:   newWebhookClient(conf),
 	}
 	s.priceCache = util.NewLookupCache(s.fetchStripePrices, conf.StripePriceCacheDuration)
+	s.oidcProvider = util.NewLookupCache(s.fetchOIDCProvider, oidcProviderCacheDuration)
 	return s, nil
 }
 
 func createMessageCache(conf *Config) (*messageCache, error) {
 	if conf.CacheDuration == 0 {
 		return newNopCache()
+	} else if util.IsPostgresURL(conf.CacheFile) {
+		return newPostgresCache(conf.CacheFile, conf.CacheStartupQueries, conf.CacheDuration, conf.CacheBatchSize, conf.CacheBatchTimeout)
 	} else if conf.CacheFile != "" {
 		return newSqliteCache(conf.CacheFile, conf.CacheStartupQueries, conf.CacheDuration, conf.CacheBatchSize, conf.CacheBatchTimeout, false)
 	}
@@ -243,6 +307,9 @@ func (s *Server) Run() error {
 	if s.config.SMTPServerListen != "" {
 		listenStr += fmt.Sprintf(" %s[smtp]", s.config.SMTPServerListen)
 	}
+	if s.config.MQTTServerListen != "" {
+		listenStr += fmt.Sprintf(" %s[mqtt]", s.config.MQTTServerListen)
+	}
 	if s.config.MetricsListenHTTP != "" {
 		listenStr += fmt.Sprintf(" %s[http/metrics]", s.config.MetricsListenHTTP)
 	}
@@ -266,7 +333,7 @@ func (s *Server) Run() error {
 		}()
 	}
 	if s.config.ListenHTTPS != "" {
-		s.httpsServer = &http.Server{Addr: s.config.ListenHTTPS, Handler: mux}
+		s.httpsServer = &http.Server{Addr: s.config.ListenHTTPS, Handler: mux, TLSConfig: s.tlsConfig()}
 		go func() {
 			errChan <- s.httpsServer.ListenAndServeTLS(s.config.CertFile, s.config.KeyFile)
 		}()
@@ -322,6 +389,12 @@ func (s *Server) Run() error {
 			errChan <- s.runSMTPServer()
 		}()
 	}
+	if s.config.MQTTServerListen != "" {
+		s.mqttServer = newMQTTServer(s)
+		go func() {
+			errChan <- s.mqttServer.ListenAndServe(s.config.MQTTServerListen)
+		}()
+	}
 	s.mu.Unlock()
 	go s.runManager()
 	go s.runStatsResetter()
@@ -347,6 +420,9 @@ func (s *Server) Stop() {
 	if s.smtpServer != nil {
 		s.smtpServer.Close()
 	}
+	if s.mqttServer != nil {
+		s.mqttServer.Close()
+	}
 	s.closeDatabases()
 	close(s.closeChan)
 }
@@ -445,10 +521,32 @@ func (s *Server) handleInternal(w http.ResponseWriter, r *http.Request, v *visit
 		return s.ensureAdmin(s.handleAccessAllow)(w, r, v)
 	} else if r.Method == http.MethodDelete && r.URL.Path == apiUsersAccessPath {
 		return s.ensureAdmin(s.handleAccessReset)(w, r, v)
+	} else if r.Method == http.MethodGet && r.URL.Path == apiGroupsPath {
+		return s.ensureAdmin(s.handleGroupsGet)(w, r, v)
+	} else if r.Method == http.MethodPut && r.URL.Path == apiGroupsPath {
+		return s.ensureAdmin(s.handleGroupsAdd)(w, r, v)
+	} else if r.Method == http.MethodDelete && r.URL.Path == apiGroupsPath {
+		return s.ensureAdmin(s.handleGroupsDelete)(w, r, v)
+	} else if (r.Method == http.MethodPut || r.Method == http.MethodPost) && r.URL.Path == apiGroupsMembersPath {
+		return s.ensureAdmin(s.handleGroupMemberAdd)(w, r, v)
+	} else if r.Method == http.MethodDelete && r.URL.Path == apiGroupsMembersPath {
+		return s.ensureAdmin(s.handleGroupMemberRemove)(w, r, v)
+	} else if (r.Method == http.MethodPut || r.Method == http.MethodPost) && r.URL.Path == apiGroupsAccessPath {
+		return s.ensureAdmin(s.handleGroupAccessAllow)(w, r, v)
+	} else if r.Method == http.MethodDelete && r.URL.Path == apiGroupsAccessPath {
+		return s.ensureAdmin(s.handleGroupAccessReset)(w, r, v)
+	} else if r.Method == http.MethodGet && r.URL.Path == apiAuditPath {
+		return s.ensureAdmin(s.handleAuditGet)(w, r, v)
+	} else if r.Method == http.MethodGet && r.URL.Path == apiTopicsRetentionPath {
+		return s.ensureAdmin(s.handleTopicRetentionGet)(w, r, v)
+	} else if (r.Method == http.MethodPut || r.Method == http.MethodPost) && r.URL.Path == apiTopicsRetentionPath {
+		return s.ensureAdmin(s.handleTopicRetentionChange)(w, r, v)
+	} else if r.Method == http.MethodDelete && r.URL.Path == apiTopicsRetentionPath {
+		return s.ensureAdmin(s.handleTopicRetentionReset)(w, r, v)
 	} else if r.Method == http.MethodPost && r.URL.Path == apiAccountPath {
 		return s.ensureUserManager(s.handleAccountCreate)(w, r, v)
 	} else if r.Method == http.MethodGet && r.URL.Path == apiAccountPath {
-		return s.handleAccountGet(w, r, v) // Allowed by anonymous
+		return s.ensureUnscopedToken(s.handleAccountGet)(w, r, v) // Allowed by anonymous
 	} else if r.Method == http.MethodDelete && r.URL.Path == apiAccountPath {
 		return s.ensureUser(s.withAccountSync(s.handleAccountDelete))(w, r, v)
 	} else if r.Method == http.MethodPost && r.URL.Path == apiAccountPasswordPath {
@@ -459,6 +557,12 @@ func (s *Server) handleInternal(w http.ResponseWriter, r *http.Request, v *visit
 		return s.ensureUser(s.withAccountSync(s.handleAccountTokenUpdate))(w, r, v)
 	} else if r.Method == http.MethodDelete && r.URL.Path == apiAccountTokenPath {
 		return s.ensureUser(s.withAccountSync(s.handleAccountTokenDelete))(w, r, v)
+	} else if r.Method == http.MethodPost && r.URL.Path == apiAccountTwoFactorPath {
+		return s.ensureUser(s.handleAccountTwoFactorSetup)(w, r, v)
+	} else if r.Method == http.MethodPut && r.URL.Path == apiAccountTwoFactorPath {
+		return s.ensureUser(s.withAccountSync(s.handleAccountTwoFactorEnable))(w, r, v)
+	} else if r.Method == http.MethodDelete && r.URL.Path == apiAccountTwoFactorPath {
+		return s.ensureUser(s.withAccountSync(s.handleAccountTwoFactorDisable))(w, r, v)
 	} else if r.Method == http.MethodPatch && r.URL.Path == apiAccountSettingsPath {
 		return s.ensureUser(s.withAccountSync(s.handleAccountSettingsChange))(w, r, v)
 	} else if r.Method == http.MethodPost && r.URL.Path == apiAccountSubscriptionPath {
@@ -471,6 +575,18 @@ func (s *Server) handleInternal(w http.ResponseWriter, r *http.Request, v *visit
 		return s.ensureUser(s.withAccountSync(s.handleAccountReservationAdd))(w, r, v)
 	} else if r.Method == http.MethodDelete && apiAccountReservationSingleRegex.MatchString(r.URL.Path) {
 		return s.ensureUser(s.withAccountSync(s.handleAccountReservationDelete))(w, r, v)
+	} else if r.Method == http.MethodPost && apiAccountReservationSignedURLRegex.MatchString(r.URL.Path) {
+		return s.ensureSignedURLsEnabled(s.ensureUser(s.handleAccountReservationSignedURLCreate))(w, r, v)
+	} else if r.Method == http.MethodDelete && apiAccountReservationSignedURLRegex.MatchString(r.URL.Path) {
+		return s.ensureSignedURLsEnabled(s.ensureUser(s.handleAccountReservationSignedURLRevoke))(w, r, v)
+	} else if r.Method == http.MethodGet && apiAccountReservationWebhookRegex.MatchString(r.URL.Path) {
+		return s.ensureWebhooksEnabled(s.ensureUser(s.handleAccountReservationWebhooksGet))(w, r, v)
+	} else if r.Method == http.MethodPost && apiAccountReservationWebhookRegex.MatchString(r.URL.Path) {
+		return s.ensureWebhooksEnabled(s.ensureUser(s.handleAccountReservationWebhookAdd))(w, r, v)
+	} else if r.Method == http.MethodDelete && apiAccountReservationWebhookSingleRegex.MatchString(r.URL.Path) {
+		return s.ensureWebhooksEnabled(s.ensureUser(s.handleAccountReservationWebhookDelete))(w, r, v)
+	} else if r.Method == http.MethodGet && apiAccountReservationWebhookDeliveriesRegex.MatchString(r.URL.Path) {
+		return s.ensureWebhooksEnabled(s.ensureUser(s.handleAccountReservationWebhookDeliveriesGet))(w, r, v)
 	} else if r.Method == http.MethodPost && r.URL.Path == apiAccountBillingSubscriptionPath {
 		return s.ensurePaymentsEnabled(s.ensureUser(s.handleAccountBillingSubscriptionCreate))(w, r, v) // Account sync via incoming Stripe webhook
 	} else if r.Method == http.MethodGet && apiAccountBillingSubscriptionCheckoutSuccessRegex.MatchString(r.URL.Path) {
@@ -483,6 +599,10 @@ func (s *Server) handleInternal(w http.ResponseWriter, r *http.Request, v *visit
 		return s.ensurePaymentsEnabled(s.ensureStripeCustomer(s.handleAccountBillingPortalSessionCreate))(w, r, v)
 	} else if r.Method == http.MethodPost && r.URL.Path == apiAccountBillingWebhookPath {
 		return s.ensurePaymentsEnabled(s.ensureUserManager(s.handleAccountBillingWebhook))(w, r, v) // This request comes from Stripe!
+	} else if r.Method == http.MethodGet && r.URL.Path == apiAccountOIDCLoginPath {
+		return s.ensureOIDCEnabled(s.limitRequests(s.handleAccountOIDCLogin))(w, r, v)
+	} else if r.Method == http.MethodGet && r.URL.Path == apiAccountOIDCCallbackPath {
+		return s.ensureOIDCEnabled(s.limitRequests(s.handleAccountOIDCCallback))(w, r, v) // Redirect from the identity provider
 	} else if r.Method == http.MethodPut && r.URL.Path == apiAccountPhoneVerifyPath {
 		return s.ensureUser(s.ensureCallsEnabled(s.withAccountSync(s.handleAccountPhoneNumberVerify)))(w, r, v)
 	} else if r.Method == http.MethodPut && r.URL.Path == apiAccountPhonePath {
@@ -513,10 +633,30 @@ func (s *Server) handleInternal(w http.ResponseWriter, r *http.Request, v *visit
 		return s.transformBodyJSON(s.limitRequestsWithTopic(s.authorizeTopicWrite(s.handlePublish)))(w, r, v)
 	} else if r.Method == http.MethodPost && r.URL.Path == matrixPushPath {
 		return s.transformMatrixJSON(s.limitRequestsWithTopic(s.authorizeTopicWrite(s.handlePublishMatrix)))(w, r, v)
+	} else if r.Method == http.MethodPost && alertmanagerPathRegex.MatchString(r.URL.Path) {
+		return s.transformAlertmanagerJSON(s.limitRequestsWithTopic(s.authorizeTopicWrite(s.handlePublishAlertmanager)))(w, r, v)
+	} else if r.Method == http.MethodPost && r.URL.Path == gotifyMessagePath && len(s.config.GotifyApps) > 0 {
+		return s.authenticateGotifyToken(s.transformGotifyMessage(s.limitRequestsWithTopic(s.authorizeTopicWrite(s.handlePublishGotify))))(w, r, v)
+	} else if r.Method == http.MethodGet && r.URL.Path == gotifyStreamPath && len(s.config.GotifyApps) > 0 {
+		return s.authenticateGotifyToken(s.limitRequests(s.handleSubscribeGotify))(w, r, v)
 	} else if (r.Method == http.MethodPut || r.Method == http.MethodPost) && topicPathRegex.MatchString(r.URL.Path) {
 		return s.limitRequestsWithTopic(s.authorizeTopicWrite(s.handlePublish))(w, r, v)
-	} else if r.Method == http.MethodGet && publishPathRegex.MatchString(r.URL.Path) {
-		return s.limitRequestsWithTopic(s.authorizeTopicWrite(s.handlePublish))(w, r, v)
+	} else if (r.Method == http.MethodGet || r.Method == http.MethodPut || r.Method == http.MethodPost) && publishPathRegex.MatchString(r.URL.Path) {
+		return s.limitRequestsWithTopic(s.authorizeTopicWriteOrSignedURL(s.handlePublish))(w, r, v)
+	} else if r.Method == http.MethodDelete && messagePathRegex.MatchString(r.URL.Path) {
+		return s.limitRequestsWithTopic(s.authorizeTopicWrite(s.handleDeleteMessage))(w, r, v)
+	} else if r.Method == http.MethodPost && ackPathRegex.MatchString(r.URL.Path) {
+		return s.limitRequestsWithTopic(s.authorizeTopicRead(s.handleMessageAck))(w, r, v)
+	} else if r.Method == http.MethodGet && scheduledPathRegex.MatchString(r.URL.Path) {
+		return s.limitRequests(s.authorizeTopicWrite(s.handleScheduledMessagesGet))(w, r, v)
+	} else if r.Method == http.MethodDelete && scheduledMessagePathRegex.MatchString(r.URL.Path) {
+		return s.limitRequests(s.authorizeTopicWrite(s.handleScheduledMessageDelete))(w, r, v)
+	} else if r.Method == http.MethodGet && schedulesPathRegex.MatchString(r.URL.Path) {
+		return s.limitRequests(s.authorizeTopicWrite(s.handleSchedulesGet))(w, r, v)
+	} else if r.Method == http.MethodPatch && scheduleSinglePathRegex.MatchString(r.URL.Path) {
+		return s.limitRequests(s.authorizeTopicWrite(s.handleScheduleUpdate))(w, r, v)
+	} else if r.Method == http.MethodDelete && scheduleSinglePathRegex.MatchString(r.URL.Path) {
+		return s.limitRequests(s.authorizeTopicWrite(s.handleScheduleDelete))(w, r, v)
 	} else if r.Method == http.MethodGet && jsonPathRegex.MatchString(r.URL.Path) {
 		return s.limitRequests(s.authorizeTopicRead(s.handleSubscribeJSON))(w, r, v)
 	} else if r.Method == http.MethodGet && ssePathRegex.MatchString(r.URL.Path) {
@@ -525,6 +665,10 @@ func (s *Server) handleInternal(w http.ResponseWriter, r *http.Request, v *visit
 		return s.limitRequests(s.authorizeTopicRead(s.handleSubscribeRaw))(w, r, v)
 	} else if r.Method == http.MethodGet && wsPathRegex.MatchString(r.URL.Path) {
 		return s.limitRequests(s.authorizeTopicRead(s.handleSubscribeWS))(w, r, v)
+	} else if r.Method == http.MethodGet && messagesPathRegex.MatchString(r.URL.Path) {
+		return s.limitRequests(s.authorizeTopicRead(s.handleMessages))(w, r, v)
+	} else if r.Method == http.MethodGet && searchPathRegex.MatchString(r.URL.Path) {
+		return s.limitRequests(s.authorizeTopicRead(s.handleSearch))(w, r, v)
 	} else if r.Method == http.MethodGet && authPathRegex.MatchString(r.URL.Path) {
 		return s.limitRequests(s.authorizeTopicRead(s.handleTopicAuth))(w, r, v)
 	} else if r.Method == http.MethodGet && (topicPathRegex.MatchString(r.URL.Path) || externalTopicPathRegex.MatchString(r.URL.Path)) {
@@ -576,6 +720,7 @@ func (s *Server) handleWebConfig(w http.ResponseWriter, _ *http.Request, _ *visi
 		EnableEmails:       s.config.SMTPSenderFrom != "",
 		EnableReservations: s.config.EnableReservations,
 		EnableWebPush:      s.config.WebPushPublicKey != "",
+		EnableOIDC:         s.config.OIDCIssuer != "",
 		BillingContact:     s.config.BillingContact,
 		WebPushPublicKey:   s.config.WebPushPublicKey,
 		DisallowedTopics:   s.config.DisallowedTopics,
@@ -733,7 +878,15 @@ func (s *Server) handlePublishInternal(r *http.Request, v *visitor) (*message, e
 	if err != nil {
 		return nil, err
 	}
-	body, err := util.Peek(r.Body, s.config.MessageLimit)
+	tpl, e := parseTemplateParam(r, v, s.config.MessageTemplates)
+	if e != nil {
+		return nil, e.With(t)
+	}
+	bodyLimit := s.config.MessageLimit
+	if tpl != nil {
+		bodyLimit = templateBodyBytesLimit
+	}
+	body, err := util.Peek(r.Body, bodyLimit)
 	if err != nil {
 		return nil, err
 	}
@@ -742,6 +895,7 @@ func (s *Server) handlePublishInternal(r *http.Request, v *visitor) (*message, e
 	if e != nil {
 		return nil, e.With(t)
 	}
+	delayed := m.Time > time.Now().Unix()
 	if unifiedpush && s.config.VisitorSubscriberRateLimiting && t.RateVisitor() == nil {
 		// UnifiedPush clients must subscribe before publishing to allow proper subscriber-based rate limiting (see
 		// Rate-Topics header). The 5xx response is because some app servers (in particular Mastodon) will remove
@@ -750,32 +904,34 @@ func (s *Server) handlePublishInternal(r *http.Request, v *visitor) (*message, e
 		return nil, errHTTPInsufficientStorageUnifiedPush.With(t)
 	} else if !util.ContainsIP(s.config.VisitorRequestExemptIPAddrs, v.ip) && !vrate.MessageAllowed() {
 		return nil, errHTTPTooManyRequestsLimitMessages.With(t)
-	} else if email != "" && !vrate.EmailAllowed() {
+	} else if email != "" && !delayed && !vrate.EmailAllowed() { // Delayed e-mails are counted when they are sent
 		return nil, errHTTPTooManyRequestsLimitEmails.With(t)
 	} else if call != "" {
 		var httpErr *errHTTP
 		call, httpErr = s.convertPhoneNumber(v.User(), call)
 		if httpErr != nil {
 			return nil, httpErr.With(t)
-		} else if !vrate.CallAllowed() {
+		} else if !delayed && !vrate.CallAllowed() { // Delayed calls are counted when they are made
 			return nil, errHTTPTooManyRequestsLimitCalls.With(t)
 		}
 	}
 	if m.PollID != "" {
 		m = newPollRequestMessage(t.ID, m.PollID)
+	} else if m.Replaces != "" {
+		m.Event = messageUpdateEvent
+		m.Replaces = s.originalMessageID(t, m.Replaces)
 	}
 	m.Sender = v.IP()
 	m.User = v.MaybeUserID()
 	if cache {
 		m.Expires = time.Unix(m.Time, 0).Add(v.Limits().MessageExpiryDuration).Unix()
 	}
-	if err := s.handlePublishBody(r, v, m, body, unifiedpush); err != nil {
+	if err := s.handlePublishBody(r, v, m, body, tpl, unifiedpush); err != nil {
 		return nil, err
 	}
 	if m.Message == "" {
 		m.Message = emptyMessageBody
 	}
-	delayed := m.Time > time.Now().Unix()
 	ev := logvrm(v, r, m).
 		Tag(tagPublish).
 		With(t).
@@ -802,7 +958,7 @@ func (s *Server) handlePublishInternal(r *http.Request, v *visitor) (*message, e
 			go s.sendEmail(v, m, email)
 		}
 		if s.config.TwilioAccount != "" && call != "" {
-			go s.callPhone(v, r, m, call)
+			go s.callPhone(v, m, call)
 		}
 		if s.config.UpstreamBaseURL != "" && !unifiedpush { // UP messages are not sent to upstream
 			go s.forwardPollRequest(v, m)
@@ -810,14 +966,19 @@ func (s *Server) handlePublishInternal(r *http.Request, v *visitor) (*message, e
 		if s.config.WebPushPublicKey != "" {
 			go s.publishToWebPushEndpoints(v, m)
 		}
+		if s.config.EnableWebhooks && s.userManager != nil {
+			go s.publishToWebhooks(v, m)
+		}
 	} else {
 		logvrm(v, r, m).Tag(tagPublish).Debug("Message delayed, will process later")
+		m.Email, m.Call = email, call // Stored with the message, see sendDelayedMessage
 	}
 	if cache {
-		logvrm(v, r, m).Tag(tagPublish).Debug("Adding message to cache")
+		logvrm(v, r, m).Tag(tagPublish).Debug("Adding message to cache") // Also marks replaced messages as superseded
 		if err := s.messageCache.AddMessage(m); err != nil {
 			return nil, err
 		}
+		s.maybeStartEscalation(m)
 	}
 	u := v.User()
 	if s.userManager != nil && u != nil && u.Tier != nil {
@@ -834,6 +995,9 @@ func (s *Server) handlePublishInternal(r *http.Request, v *visitor) (*message, e
 }
 
 func (s *Server) handlePublish(w http.ResponseWriter, r *http.Request, v *visitor) error {
+	if readParam(r, "x-schedule", "schedule") != "" {
+		return s.handlePublishSchedule(w, r, v)
+	}
 	m, err := s.handlePublishInternal(r, v)
 	if err != nil {
 		minc(metricMessagesPublishedFailure)
@@ -843,6 +1007,182 @@ func (s *Server) handlePublish(w http.ResponseWriter, r *http.Request, v *visito
 	return s.writeJSON(w, m)
 }
 
+// handleDeleteMessage marks a previously published message as deleted. Subscribers receive a "message_delete" event
+// referencing the original message, and the message (including all of its updates) is no longer returned when polling.
+// The message must exist in the topic's cache. Like publishing, deleting counts against the visitor's message limit.
+func (s *Server) handleDeleteMessage(w http.ResponseWriter, r *http.Request, v *visitor) error {
+	t, err := fromContext[*topic](r, contextTopic)
+	if err != nil {
+		return err
+	}
+	vrate, err := fromContext[*visitor](r, contextRateVisitor)
+	if err != nil {
+		return err
+	}
+	matches := messagePathRegex.FindStringSubmatch(r.URL.Path)
+	if len(matches) != 2 {
+		return errHTTPInternalErrorInvalidPath
+	}
+	if !util.ContainsIP(s.config.VisitorRequestExemptIPAddrs, v.ip) && !vrate.MessageAllowed() {
+		return errHTTPTooManyRequestsLimitMessages.With(t)
+	}
+	original, err := s.messageCache.Message(matches[1])
+	if errors.Is(err, errMessageNotFound) || (err == nil && original.Topic != t.ID) {
+		return errHTTPNotFoundMessageDelete.With(t)
+	} else if err != nil {
+		return err
+	}
+	m := newDeleteMessage(t.ID, s.originalMessageID(t, original.ID))
+	m.Sender = v.IP()
+	m.User = v.MaybeUserID()
+	m.Expires = time.Unix(m.Time, 0).Add(v.Limits().MessageExpiryDuration).Unix()
+	logvrm(v, r, m).Tag(tagPublish).Debug("Deleting message %s", m.Replaces)
+	if err := t.Publish(v, m); err != nil {
+		return err
+	}
+	if s.firebaseClient != nil {
+		go s.sendToFirebase(v, m)
+	}
+	if s.config.UpstreamBaseURL != "" {
+		go s.forwardPollRequest(v, m)
+	}
+	if s.config.WebPushPublicKey != "" {
+		go s.publishToWebPushEndpoints(v, m)
+	}
+	if s.config.EnableWebhooks && s.userManager != nil {
+		go s.publishToWebhooks(v, m)
+	}
+	if err := s.messageCache.DeleteEscalation(m.Replaces); err != nil {
+		return err
+	}
+	if err := s.messageCache.AddMessage(m); err != nil {
+		return err
+	}
+	u := v.User()
+	if s.userManager != nil && u != nil && u.Tier != nil {
+		go s.userManager.EnqueueUserStats(u.ID, v.Stats())
+	}
+	s.mu.Lock()
+	s.messages++
+	s.mu.Unlock()
+	minc(metricMessagesPublishedSuccess)
+	return s.writeJSON(w, m)
+}
+
+// setAcknowledgeURLs points the "acknowledge" actions of the message to the message's ack endpoint. It must be
+// called again whenever the message gets a new ID, e.g. for every run of a recurring message.
+func (s *Server) setAcknowledgeURLs(m *message) {
+	for _, a := range m.Actions {
+		if a.Action == actionAcknowledge {
+			a.URL = fmt.Sprintf("%s/%s/%s/ack", s.config.BaseURL, m.Topic, m.ID)
+			a.Method = http.MethodPost
+		}
+	}
+}
+
+// handleMessageAck records that the user (or anonymous visitor) has acknowledged a message, e.g. via an "acknowledge"
+// action button. Subscribers receive a "message_ack" event referencing the original message, so that they can clear
+// the notification. Acknowledging a message more than once has no effect.
+func (s *Server) handleMessageAck(w http.ResponseWriter, r *http.Request, v *visitor) error {
+	t, err := fromContext[*topic](r, contextTopic)
+	if err != nil {
+		return err
+	}
+	matches := ackPathRegex.FindStringSubmatch(r.URL.Path)
+	if len(matches) != 2 {
+		return errHTTPInternalErrorInvalidPath
+	}
+	messageID := s.originalMessageID(t, matches[1])
+	m, err := s.messageCache.Message(messageID)
+	if errors.Is(err, errMessageNotFound) || (err == nil && (m.Topic != t.ID || m.Event != messageEvent || m.Time > time.Now().Unix())) {
+		return errHTTPNotFoundMessage.With(t)
+	} else if err != nil {
+		return err
+	}
+	acks, err := s.messageCache.Acks(messageID)
+	if err != nil {
+		return err
+	}
+	u := v.User()
+	for _, a := range acks[messageID] {
+		if (u != nil && a.User == u.Name) || (u == nil && a.User == "" && a.Sender == v.IP()) {
+			return s.writeJSON(w, a)
+		}
+	}
+	a := &ack{
+		MessageID: messageID,
+		Topic:     t.ID,
+		Sender:    v.IP(),
+		Time:      time.Now().Unix(),
+	}
+	if u != nil {
+		a.User = u.Name
+		if u.Token != "" {
+			if token, err := s.userManager.Token(u.ID, u.Token); err == nil {
+				a.Token = token.Label
+			}
+		}
+	}
+	logvrm(v, r, m).Tag(tagPublish).Debug("Acknowledging message %s", messageID)
+	if err := s.messageCache.AddAck(a); err != nil {
+		return err
+	}
+	if err := s.messageCache.DeleteEscalation(messageID); err != nil {
+		return err
+	}
+	ackMessage := newAckMessage(t.ID, messageID, a)
+	if err := t.Publish(v, ackMessage); err != nil {
+		return err
+	}
+	if s.firebaseClient != nil {
+		go s.sendToFirebase(v, ackMessage)
+	}
+	if s.config.EnableWebhooks && s.userManager != nil {
+		go s.publishToWebhooks(v, ackMessage)
+	}
+	return s.writeJSON(w, a)
+}
+
+// populateAcks adds the acknowledgements of the original messages to the given messages, so that clients
+// can show which messages were acknowledged, and by whom. Updates of a message share the original's acknowledgements.
+func (s *Server) populateAcks(messages []*message) error {
+	messageIDs := make([]string, 0)
+	for _, m := range messages {
+		if m.Event == messageEvent || m.Event == messageUpdateEvent {
+			messageIDs = append(messageIDs, originalMessageIDOf(m))
+		}
+	}
+	acks, err := s.messageCache.Acks(messageIDs...)
+	if err != nil {
+		return err
+	}
+	for _, m := range messages {
+		if m.Event == messageEvent || m.Event == messageUpdateEvent {
+			m.Acks = acks[originalMessageIDOf(m)]
+		}
+	}
+	return nil
+}
+
+// originalMessageIDOf returns the ID of the original message, i.e. the ID of the message it replaces (if any)
+func originalMessageIDOf(m *message) string {
+	if m.Replaces != "" {
+		return m.Replaces
+	}
+	return m.ID
+}
+
+// originalMessageID returns the ID of the original message that the given message ID refers to. If the given ID belongs
+// to an update of a message, the ID of the updated message is returned, so that all updates reference the same message.
+// Unknown IDs (e.g. of messages that are no longer cached) are returned unchanged.
+func (s *Server) originalMessageID(t *topic, id string) string {
+	m, err := s.messageCache.Message(id)
+	if err != nil || m.Topic != t.ID || m.Replaces == "" {
+		return id
+	}
+	return m.Replaces
+}
+
 func (s *Server) handlePublishMatrix(w http.ResponseWriter, r *http.Request, v *visitor) error {
 	_, err := s.handlePublishInternal(r, v)
 	if err != nil {
@@ -868,6 +1208,61 @@ func (s *Server) handlePublishMatrix(w http.ResponseWriter, r *http.Request, v *
 	return writeMatrixSuccess(w)
 }
 
+// handlePublishAlertmanager publishes the notifications that were derived from an Alertmanager webhook (see
+// transformAlertmanagerJSON). The published messages are returned as JSON, one per line.
+func (s *Server) handlePublishAlertmanager(w http.ResponseWriter, r *http.Request, v *visitor) error {
+	notifications, err := fromContext[[]*alertmanagerNotification](r, contextAlertmanagerNotifications)
+	if err != nil {
+		return err
+	}
+	vrate, err := fromContext[*visitor](r, contextRateVisitor)
+	if err != nil {
+		return err
+	}
+	// Check the message limit for the whole batch up front. Once the first alert is published, errors are only logged,
+	// since Alertmanager retries the entire webhook on non-2xx responses, which would publish the other alerts again.
+	if !util.ContainsIP(s.config.VisitorRequestExemptIPAddrs, v.ip) && !vrate.MessagesAllowed(len(notifications)) {
+		return errHTTPTooManyRequestsLimitMessages
+	}
+	messages := make([]*message, 0, len(notifications))
+	var firstErr error
+	for _, n := range notifications {
+		req := r.Clone(r.Context())
+		req.Body = io.NopCloser(strings.NewReader(n.Message))
+		n.apply(req)
+		m, err := s.handlePublishInternal(req, v)
+		if err != nil {
+			minc(metricMessagesPublishedFailure)
+			logvr(v, r).Tag(tagAlertmanager).Err(err).Warn("Unable to publish alert %s", n.Title)
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		minc(metricMessagesPublishedSuccess)
+		messages = append(messages, m)
+	}
+	if len(messages) == 0 && firstErr != nil {
+		return firstErr // Nothing was published, so it is safe for Alertmanager to retry
+	}
+	for _, m := range messages {
+		if err := s.writeJSON(w, m); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Server) handlePublishGotify(w http.ResponseWriter, r *http.Request, v *visitor) error {
+	m, err := s.handlePublishInternal(r, v)
+	if err != nil {
+		minc(metricMessagesPublishedFailure)
+		return err
+	}
+	minc(metricMessagesPublishedSuccess)
+	return s.writeJSON(w, newGotifyMessage(m))
+}
+
 func (s *Server) sendToFirebase(v *visitor, m *message) {
 	logvm(v, m).Tag(tagFirebase).Debug("Publishing to Firebase")
 	if err := s.firebaseClient.Send(v, m); err != nil {
@@ -962,6 +1357,10 @@ func (s *Server) parsePublishParams(r *http.Request, m *message) (cache bool, fi
 		}
 		m.Icon = icon
 	}
+	m.Replaces = readParam(r, "x-replaces", "replaces")
+	if m.Replaces != "" && !validMessageID(m.Replaces) {
+		return false, false, "", "", false, errHTTPBadRequestReplacesInvalid
+	}
 	email = readParam(r, "x-email", "x-e-mail", "email", "e-mail", "mail", "e")
 	if s.smtpSender == nil && email != "" {
 		return false, false, "", "", false, errHTTPBadRequestEmailDisabled
@@ -987,11 +1386,8 @@ func (s *Server) parsePublishParams(r *http.Request, m *message) (cache bool, fi
 		if !cache {
 			return false, false, "", "", false, errHTTPBadRequestDelayNoCache
 		}
-		if email != "" {
-			return false, false, "", "", false, errHTTPBadRequestDelayNoEmail // we cannot store the email address (yet)
-		}
-		if call != "" {
-			return false, false, "", "", false, errHTTPBadRequestDelayNoCall // we cannot store the phone number (yet)
+		if m.Replaces != "" {
+			return false, false, "", "", false, errHTTPBadRequestDelayNoReplaces
 		}
 		delay, err := util.ParseFutureTime(delayStr, time.Now())
 		if err != nil {
@@ -1009,6 +1405,12 @@ func (s *Server) parsePublishParams(r *http.Request, m *message) (cache bool, fi
 		if e != nil {
 			return false, false, "", "", false, errHTTPBadRequestActionsInvalid.Wrap(e.Error())
 		}
+		for _, a := range m.Actions {
+			if a.Action == actionAcknowledge && s.config.BaseURL == "" {
+				return false, false, "", "", false, errHTTPBadRequestActionsInvalid.Wrap("action 'acknowledge' requires base-url to be set")
+			}
+		}
+		s.setAcknowledgeURLs(m)
 	}
 	contentType, markdown := readParam(r, "content-type", "content_type"), readBoolParam(r, false, "x-markdown", "markdown", "md")
 	if markdown || strings.ToLower(contentType) == "text/markdown" {
@@ -1042,9 +1444,13 @@ func (s *Server) parsePublishParams(r *http.Request, m *message) (cache bool, fi
 //     If file.txt is <= 4096 (message limit) and valid UTF-8, treat it as a message
 //  6. curl -T file.txt ntfy.sh/mytopic
 //     If file.txt is > message limit, treat it as an attachment
-func (s *Server) handlePublishBody(r *http.Request, v *visitor, m *message, body *util.PeekedReadCloser, unifiedpush bool) error {
+//  7. curl -d '{"alert":"Disk full"}' "ntfy.sh/mytopic?template=yes&message={{.alert}}"
+//     If a template is set, the body is JSON, and the title/message are rendered from it
+func (s *Server) handlePublishBody(r *http.Request, v *visitor, m *message, body *util.PeekedReadCloser, tpl *MessageTemplate, unifiedpush bool) error {
 	if m.Event == pollRequestEvent { // Case 1
 		return s.handleBodyDiscard(body)
+	} else if tpl != nil {
+		return s.handleBodyAsTemplatedTextMessage(r.Context(), m, tpl, body) // Case 7
 	} else if unifiedpush {
 		return s.handleBodyAsMessageAutoDetect(m, body) // Case 2
 	} else if m.Attachment != nil && m.Attachment.URL != "" {
@@ -1136,6 +1542,90 @@ func (s *Server) handleBodyAsAttachment(r *http.Request, v *visitor, m *message,
 	return nil
 }
 
+// handleMessages returns a page of cached messages of a topic, in chronological order. Without cursor, the latest
+// messages are returned. The "prev" and "next" cursors in the response can be passed as "before" and "after" to
+// fetch the previous (older) or next (newer) page. They are only set if there are more messages in that direction.
+func (s *Server) handleMessages(w http.ResponseWriter, r *http.Request, _ *visitor) error {
+	matches := messagesPathRegex.FindStringSubmatch(r.URL.Path)
+	if len(matches) != 2 {
+		return errHTTPInternalErrorInvalidPath
+	}
+	topic := matches[1]
+	before, after := readQueryParam(r, "before"), readQueryParam(r, "after")
+	if (before != "" && after != "") || (before != "" && !validMessageID(before)) || (after != "" && !validMessageID(after)) {
+		return errHTTPBadRequestCursorInvalid
+	}
+	limit, err := parseLimit(r, messagesLimitDefault, messagesLimitMax)
+	if err != nil {
+		return err
+	}
+	var messages []*message
+	if after != "" {
+		messages, err = s.messageCache.MessagesAfter(topic, after, limit+1) // +1 to check if there is a next page
+	} else if before != "" {
+		messages, err = s.messageCache.MessagesBefore(topic, before, limit+1) // +1 to check if there is a previous page
+	} else {
+		messages, err = s.messageCache.MessagesLatest(topic, limit+1)
+	}
+	if errors.Is(err, errMessageNotFound) {
+		return errHTTPBadRequestCursorInvalid
+	} else if err != nil {
+		return err
+	}
+	if err := s.populateAcks(messages); err != nil {
+		return err
+	}
+	response := &apiMessagesResponse{}
+	if len(messages) > limit {
+		if after != "" {
+			messages = messages[:limit]
+			response.Next = messages[len(messages)-1].ID
+		} else {
+			messages = messages[1:]
+			response.Prev = messages[0].ID
+		}
+	}
+	if len(messages) > 0 && after != "" {
+		response.Prev = messages[0].ID // The "after" message itself is older
+	} else if len(messages) > 0 && before != "" {
+		response.Next = messages[len(messages)-1].ID // The "before" message itself is newer
+	}
+	response.Messages = messages
+	return s.writeJSON(w, response)
+}
+
+// handleSearch performs a full-text search over the cached messages of a topic, and returns a page of results,
+// best matches first. If there are more results, the "next" field contains the offset of the next page.
+func (s *Server) handleSearch(w http.ResponseWriter, r *http.Request, _ *visitor) error {
+	matches := searchPathRegex.FindStringSubmatch(r.URL.Path)
+	if len(matches) != 2 {
+		return errHTTPInternalErrorInvalidPath
+	}
+	query := readQueryParam(r, "q", "query")
+	if strings.TrimSpace(query) == "" {
+		return errHTTPBadRequestSearchQueryMissing
+	}
+	limit, offset, err := parseLimitOffset(r, searchLimitDefault, searchLimitMax)
+	if err != nil {
+		return err
+	}
+	messages, err := s.messageCache.SearchMessages(matches[1], query, limit+1, offset) // +1 to check if there is a next page
+	if err != nil {
+		return err
+	}
+	if err := s.populateAcks(messages); err != nil {
+		return err
+	}
+	response := &apiSearchResponse{
+		Messages: messages,
+	}
+	if len(messages) > limit {
+		response.Messages = messages[:limit]
+		response.Next = offset + limit
+	}
+	return s.writeJSON(w, response)
+}
+
 func (s *Server) handleSubscribeJSON(w http.ResponseWriter, r *http.Request, v *visitor) error {
 	encoder := func(msg *message) (string, error) {
 		var buf bytes.Buffer
@@ -1163,7 +1653,7 @@ func (s *Server) handleSubscribeSSE(w http.ResponseWriter, r *http.Request, v *v
 
 func (s *Server) handleSubscribeRaw(w http.ResponseWriter, r *http.Request, v *visitor) error {
 	encoder := func(msg *message) (string, error) {
-		if msg.Event == messageEvent { // only handle default events
+		if msg.Event == messageEvent || msg.Event == messageUpdateEvent { // only handle default events
 			return strings.ReplaceAll(msg.Message, "\n", " ") + "\n", nil
 		}
 		return "\n", nil // "keepalive" and "open" events just send an empty line
@@ -1265,6 +1755,19 @@ func (s *Server) handleSubscribeHTTP(w http.ResponseWriter, r *http.Request, v *
 }
 
 func (s *Server) handleSubscribeWS(w http.ResponseWriter, r *http.Request, v *visitor) error {
+	topics, topicsStr, err := s.topicsFromPath(r.URL.Path)
+	if err != nil {
+		return err
+	}
+	return s.subscribeWS(w, r, v, topics, topicsStr, func(m *message) any {
+		return m
+	})
+}
+
+// subscribeWS upgrades the connection to a WebSocket and streams messages of the given topics to it. Each message
+// is passed to encode before it is written as JSON, so that it can be converted to a different format (e.g. for the
+// Gotify API). If encode returns nil, the message is not sent.
+func (s *Server) subscribeWS(w http.ResponseWriter, r *http.Request, v *visitor, topics []*topic, topicsStr string, encode func(m *message) any) error {
 	if strings.ToLower(r.Header.Get("Upgrade")) != "websocket" {
 		return errHTTPBadRequestWebSocketsUpgradeHeaderMissing
 	}
@@ -1274,10 +1777,6 @@ func (s *Server) handleSubscribeWS(w http.ResponseWriter, r *http.Request, v *vi
 	defer v.RemoveSubscription()
 	logvr(v, r).Tag(tagWebsocket).Debug("WebSocket connection opened")
 	defer logvr(v, r).Tag(tagWebsocket).Debug("WebSocket connection closed")
-	topics, topicsStr, err := s.topicsFromPath(r.URL.Path)
-	if err != nil {
-		return err
-	}
 	poll, since, scheduled, filters, rateTopics, err := parseSubscribeParams(r)
 	if err != nil {
 		return err
@@ -1357,12 +1856,16 @@ func (s *Server) handleSubscribeWS(w http.ResponseWriter, r *http.Request, v *vi
 		if !filters.Pass(msg) {
 			return nil
 		}
+		payload := encode(msg)
+		if payload == nil {
+			return nil
+		}
 		wlock.Lock()
 		defer wlock.Unlock()
 		if err := conn.SetWriteDeadline(time.Now().Add(wsWriteWait)); err != nil {
 			return err
 		}
-		return conn.WriteJSON(msg)
+		return conn.WriteJSON(payload)
 	}
 	if err := s.maybeSetRateVisitors(r, v, topics, rateTopics); err != nil {
 		return err
@@ -1397,6 +1900,33 @@ func (s *Server) handleSubscribeWS(w http.ResponseWriter, r *http.Request, v *vi
 	return err
 }
 
+// handleSubscribeGotify streams messages of all Gotify application topics that the visitor is allowed to read
+// via WebSocket, in the Gotify message format (see server_gotify.go)
+func (s *Server) handleSubscribeGotify(w http.ResponseWriter, r *http.Request, v *visitor) error {
+	topicIDs := make([]string, 0)
+	for _, topicID := range gotifyTopics(s.config.GotifyApps) {
+		if s.userManager != nil {
+			if err := s.userManager.Authorize(v.User(), topicID, user.PermissionRead); err != nil {
+				continue
+			}
+		}
+		topicIDs = append(topicIDs, topicID)
+	}
+	if len(topicIDs) == 0 {
+		return errHTTPForbidden
+	}
+	topics, err := s.topicsFromIDs(topicIDs...)
+	if err != nil {
+		return err
+	}
+	return s.subscribeWS(w, r, v, topics, strings.Join(topicIDs, ","), func(m *message) any {
+		if m.Event != messageEvent {
+			return nil
+		}
+		return newGotifyMessage(m)
+	})
+}
+
 func parseSubscribeParams(r *http.Request) (poll bool, since sinceMarker, scheduled bool, filters *queryFilter, rateTopics []string, err error) {
 	poll = readBoolParam(r, false, "x-poll", "poll", "po")
 	scheduled = readBoolParam(r, false, "x-scheduled", "scheduled", "sched")
@@ -1492,6 +2022,9 @@ func (s *Server) sendOldMessages(topics []*topic, since sinceMarker, scheduled b
 	sort.Slice(messages, func(i, j int) bool {
 		return messages[i].Time < messages[j].Time
 	})
+	if err := s.populateAcks(messages); err != nil {
+		return err
+	}
 	for _, m := range messages {
 		if err := sub(v, m); err != nil {
 			return err
@@ -1696,6 +2229,15 @@ func (s *Server) runDelayedSender() {
 			if err := s.sendDelayedMessages(); err != nil {
 				log.Tag(tagPublish).Err(err).Warn("Error sending delayed messages")
 			}
+			if err := s.sendScheduledMessages(); err != nil {
+				log.Tag(tagPublish).Err(err).Warn("Error sending scheduled messages")
+			}
+			if err := s.sendEscalations(); err != nil {
+				log.Tag(tagEscalation).Err(err).Warn("Error sending escalations")
+			}
+			if err := s.sendWebhookDeliveries(time.Now()); err != nil {
+				log.Tag(tagWebhook).Err(err).Warn("Error sending webhook deliveries")
+			}
 		case <-s.closeChan:
 			return
 		}
@@ -1726,12 +2268,50 @@ func (s *Server) sendDelayedMessages() error {
 
 func (s *Server) sendDelayedMessage(v *visitor, m *message) error {
 	logvm(v, m).Debug("Sending delayed message")
+	s.deliverMessage(v, m) // We do not rate-limit messages here, since we've rate limited them in the PUT/POST handler
+	if m.Email != "" || m.Call != "" {
+		s.notifyDelayedMessageRecipients(v, m)
+	}
+	if err := s.messageCache.MarkPublished(m); err != nil {
+		return err
+	}
+	return nil
+}
+
+// notifyDelayedMessageRecipients sends the e-mail and makes the phone call of a delayed message. Unlike the message
+// itself, e-mails and calls are counted against the visitor's limits when they are sent, not when the message is
+// published. Phone numbers are checked again, since the user may have removed them in the meantime.
+func (s *Server) notifyDelayedMessageRecipients(v *visitor, m *message) {
+	if s.smtpSender != nil && m.Email != "" {
+		if v.EmailAllowed() {
+			go s.sendEmail(v, m, m.Email)
+		} else {
+			logvm(v, m).Tag(tagEmail).Field("email", m.Email).Info("Not sending e-mail for delayed message, e-mail limit reached")
+		}
+	}
+	if s.config.TwilioAccount != "" && s.userManager != nil && m.Call != "" {
+		if to, err := s.convertPhoneNumber(v.User(), m.Call); err != nil {
+			logvm(v, m).Tag(tagTwilio).Err(err).Info("Not calling phone number for delayed message, phone number not verified")
+		} else if v.CallAllowed() {
+			go s.callPhone(v, m, to)
+		} else {
+			logvm(v, m).Tag(tagTwilio).Info("Not calling phone number for delayed message, call limit reached")
+		}
+	}
+	u := v.User()
+	if s.userManager != nil && u != nil && u.Tier != nil {
+		go s.userManager.EnqueueUserStats(u.ID, v.Stats())
+	}
+}
+
+// deliverMessage publishes a message that was not published by the PUT/POST handler (delayed and scheduled
+// messages) to subscribers, Firebase, the upstream server, Web Push endpoints and webhooks
+func (s *Server) deliverMessage(v *visitor, m *message) {
 	s.mu.RLock()
-	t, ok := s.topics[m.Topic] // If no subscribers, just mark message as published
+	t, ok := s.topics[m.Topic] // If no subscribers, there is nobody to publish to
 	s.mu.RUnlock()
 	if ok {
 		go func() {
-			// We do not rate-limit messages here, since we've rate limited them in the PUT/POST handler
 			if err := t.Publish(v, m); err != nil {
 				logvm(v, m).Err(err).Warn("Unable to publish message")
 			}
@@ -1746,10 +2326,9 @@ func (s *Server) sendDelayedMessage(v *visitor, m *message) error {
 	if s.config.WebPushPublicKey != "" {
 		go s.publishToWebPushEndpoints(v, m)
 	}
-	if err := s.messageCache.MarkPublished(m); err != nil {
-		return err
+	if s.config.EnableWebhooks && s.userManager != nil {
+		go s.publishToWebhooks(v, m)
 	}
-	return nil
 }
 
 // transformBodyJSON peeks the request body, reads the JSON, and converts it to headers
@@ -1808,6 +2387,12 @@ func (s *Server) transformBodyJSON(next handleFunc) handleFunc {
 		if m.Call != "" {
 			r.Header.Set("X-Call", m.Call)
 		}
+		if m.Replaces != "" {
+			r.Header.Set("X-Replaces", m.Replaces)
+		}
+		if m.Schedule != "" {
+			r.Header.Set("X-Schedule", m.Schedule)
+		}
 		return next(w, r, v)
 	}
 }
@@ -1830,6 +2415,70 @@ func (s *Server) transformMatrixJSON(next handleFunc) handleFunc {
 	}
 }
 
+// transformAlertmanagerJSON parses an Alertmanager webhook request, and stores the notifications derived from it
+// in the request context, so they can be published by handlePublishAlertmanager
+func (s *Server) transformAlertmanagerJSON(next handleFunc) handleFunc {
+	return func(w http.ResponseWriter, r *http.Request, v *visitor) error {
+		webhook, err := readJSONWithLimit[alertmanagerWebhook](r.Body, alertmanagerBodyBytesLimit, false)
+		if err == errHTTPBadRequestJSONInvalid {
+			return errHTTPBadRequestAlertmanagerMessageInvalid
+		} else if err != nil {
+			return err
+		} else if len(webhook.Alerts) == 0 {
+			return errHTTPBadRequestAlertmanagerMessageInvalid.Wrap("no alerts")
+		}
+		grouped := readBoolParam(r, false, "x-grouped", "grouped", "group")
+		notifications := newAlertmanagerNotifications(webhook, grouped, s.config.MessageLimit)
+		logvr(v, r).Tag(tagAlertmanager).Debug("Received Alertmanager webhook with %d alert(s), publishing %d notification(s)", len(webhook.Alerts), len(notifications))
+		return next(w, withContext(r, map[contextKey]any{
+			contextAlertmanagerNotifications: notifications,
+		}), v)
+	}
+}
+
+func (s *Server) transformGotifyMessage(next handleFunc) handleFunc {
+	return func(w http.ResponseWriter, r *http.Request, v *visitor) error {
+		if err := transformGotifyRequest(r, s.config.GotifyApps, s.config.MessageLimit); err != nil {
+			logvr(v, r).Tag(tagGotify).Err(err).Debug("Invalid Gotify request")
+			return err
+		}
+		return next(w, r, v)
+	}
+}
+
+// authenticateGotifyToken authenticates the visitor as the user the Gotify application is mapped to, or with the
+// Gotify token itself if it is an ntfy access token, unless the request has already been authenticated. This is
+// necessary because Gotify clients pass the token as query parameter or X-Gotify-Key header, which are not
+// considered by maybeAuthenticate.
+func (s *Server) authenticateGotifyToken(next handleFunc) handleFunc {
+	return func(w http.ResponseWriter, r *http.Request, v *visitor) error {
+		if s.userManager == nil || v.User() != nil {
+			return next(w, r, v)
+		}
+		token := readGotifyToken(r)
+		if app, ok := s.config.GotifyApps[token]; ok && app.User != "" {
+			if !user.IsToken(app.User) {
+				u, err := s.userManager.User(app.User)
+				if err != nil || u.Deleted {
+					logvr(v, r).Tag(tagGotify).Err(err).Warn("Unable to authenticate Gotify application as user %s", app.User)
+					return errHTTPUnauthorized
+				}
+				return next(w, r, s.visitor(v.IP(), u))
+			}
+			token = app.User
+		}
+		if !user.IsToken(token) {
+			return next(w, r, v)
+		}
+		r.Header.Set("Authorization", "Bearer "+token)
+		v, err := s.maybeAuthenticate(r)
+		if err != nil {
+			return err
+		}
+		return next(w, r, v)
+	}
+}
+
 func (s *Server) authorizeTopicWrite(next handleFunc) handleFunc {
 	return s.autorizeTopic(next, user.PermissionWrite)
 }
@@ -1880,6 +2529,9 @@ func (s *Server) maybeAuthenticate(r *http.Request) (*visitor, error) {
 	if err != nil {
 		return vip, err
 	} else if !supportedAuthHeader(header) {
+		if s.hasVerifiedClientCert(r) {
+			return s.maybeAuthenticateClientCert(r, ip, vip)
+		}
 		return vip, nil
 	}
 	// If we're trying to auth, check the rate limiter first
@@ -1890,12 +2542,33 @@ func (s *Server) maybeAuthenticate(r *http.Request) (*visitor, error) {
 	if err != nil {
 		vip.AuthFailed()
 		logr(r).Err(err).Debug("Authentication failed")
+		s.auditLoginFailed(r, vip, header, err)
 		return vip, errHTTPUnauthorized // Always return visitor, even when error occurs!
 	}
+	if err := s.checkTwoFactorPasswordAuth(r, u); err != nil {
+		logr(r).Err(err).Debug("Authentication failed, two-factor authentication required")
+		return vip, err
+	}
 	// Authentication with user was successful
 	return s.visitor(ip, u), nil
 }
 
+// maybeAuthenticateClientCert authenticates a user based on a verified client certificate (mutual TLS). It is only used if
+// no Authorization header is passed, i.e. passwords and tokens take precedence over client certificates.
+func (s *Server) maybeAuthenticateClientCert(r *http.Request, ip netip.Addr, vip *visitor) (*visitor, error) {
+	if !vip.AuthAllowed() {
+		return vip, errHTTPTooManyRequestsLimitAuthFailure // Always return visitor, even when error occurs!
+	}
+	u, err := s.authenticateClientCert(r)
+	if err != nil {
+		vip.AuthFailed()
+		logr(r).Err(err).Debug("Authentication via client certificate failed")
+		s.audit(r, vip, user.AuditActionLoginFailed, clientCertUsername(r.TLS.VerifiedChains[0][0], s.config.ClientCertUsername), "client certificate")
+		return vip, errHTTPUnauthorized // Always return visitor, even when error occurs!
+	}
+	return s.visitor(ip, u), nil
+}
+
 // authenticate a user based on basic auth username/password (Authorization: Basic ...), or token auth (Authorization: Bearer ...).
 // The Authorization header can be passed as a header or the ?auth=... query param. The latter is required only to
 // support the WebSocket JavaScript class, which does not support passing headers during the initial request. The auth
@@ -1938,7 +2611,7 @@ func (s *Server) authenticateBasicAuth(r *http.Request, value string) (user *use
 	} else if username == "" {
 		return s.authenticateBearerAuth(r, password) // Treat password as token
 	}
-	return s.userManager.Authenticate(username, password)
+	return s.auther.Authenticate(username, password)
 }
 
 func (s *Server) authenticateBearerAuth(r *http.Request, token string) (*user.User, error) {
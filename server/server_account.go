@@ -2,6 +2,7 @@ package server
 
 import (
 	"encoding/json"
+	"errors"
 	"heckel.io/ntfy/v2/log"
 	"heckel.io/ntfy/v2/user"
 	"heckel.io/ntfy/v2/util"
@@ -36,13 +37,22 @@ func (s *Server) handleAccountCreate(w http.ResponseWriter, r *http.Request, v *
 		return errHTTPConflictUserExists
 	}
 	logvr(v, r).Tag(tagAccount).Field("user_name", newAccount.Username).Info("Creating user %s", newAccount.Username)
-	if err := s.userManager.AddUser(newAccount.Username, newAccount.Password, user.RoleUser); err != nil {
-		return err
+	if err := s.userManagerAs(v, "").AddUser(newAccount.Username, newAccount.Password, user.RoleUser); err != nil {
+		return passwordPolicyError(err)
 	}
 	v.AccountCreated()
 	return s.writeJSON(w, newSuccessResponse())
 }
 
+// passwordPolicyError converts a password policy violation returned by AddUser or ChangePassword into
+// an HTTP error that includes the violated rule. Other errors are returned as is.
+func passwordPolicyError(err error) error {
+	if errors.Is(err, user.ErrPasswordPolicy) {
+		return errHTTPBadRequestPasswordPolicy.Wrap("%s", strings.TrimPrefix(err.Error(), user.ErrPasswordPolicy.Error()+": "))
+	}
+	return err
+}
+
 func (s *Server) handleAccountGet(w http.ResponseWriter, r *http.Request, v *visitor) error {
 	info, err := v.Info()
 	if err != nil {
@@ -108,18 +118,46 @@ func (s *Server) handleAccountGet(w http.ResponseWriter, r *http.Request, v *vis
 				CancelAt:     u.Billing.StripeSubscriptionCancelAt.Unix(),
 			}
 		}
+		twoFactor, err := s.userManager.TwoFactorEnabled(u.ID)
+		if err != nil {
+			return err
+		}
+		response.TwoFactor = twoFactor
 		if s.config.EnableReservations {
 			reservations, err := s.userManager.Reservations(u.Name)
 			if err != nil {
 				return err
 			}
 			if len(reservations) > 0 {
+				retentions, err := s.topicRetentions()
+				if err != nil {
+					return err
+				}
+				escalations, err := s.topicEscalations()
+				if err != nil {
+					return err
+				}
 				response.Reservations = make([]*apiAccountReservation, 0)
 				for _, r := range reservations {
-					response.Reservations = append(response.Reservations, &apiAccountReservation{
+					reservation := &apiAccountReservation{
 						Topic:    r.Topic,
 						Everyone: r.Everyone.String(),
-					})
+					}
+					if retention, ok := retentions[r.Topic]; ok {
+						reservation.Retention = &apiAccountReservationRetention{
+							MaxAge:      int64(retention.MaxAge.Seconds()),
+							MaxMessages: retention.MaxMessages,
+						}
+					}
+					if escalation, ok := escalations[r.Topic]; ok {
+						reservation.Escalation = &apiAccountReservationEscalation{
+							Delay:        int64(escalation.Delay.Seconds()),
+							ForwardTopic: escalation.ForwardTopic,
+							Email:        escalation.Email,
+							Call:         escalation.PhoneNumber,
+						}
+					}
+					response.Reservations = append(response.Reservations, reservation)
 				}
 			}
 		}
@@ -140,6 +178,7 @@ func (s *Server) handleAccountGet(w http.ResponseWriter, r *http.Request, v *vis
 					LastAccess: t.LastAccess.Unix(),
 					LastOrigin: lastOrigin,
 					Expires:    t.Expires.Unix(),
+					Scopes:     toAPITokenScopes(t.Scopes),
 				})
 			}
 		}
@@ -185,7 +224,7 @@ func (s *Server) handleAccountDelete(w http.ResponseWriter, r *http.Request, v *
 		return err
 	}
 	logvr(v, r).Tag(tagAccount).Info("Marking user %s as deleted", u.Name)
-	if err := s.userManager.MarkUserRemoved(u); err != nil {
+	if err := s.userManagerAs(v, "").MarkUserRemoved(u); err != nil {
 		return err
 	}
 	return s.writeJSON(w, newSuccessResponse())
@@ -203,8 +242,8 @@ func (s *Server) handleAccountPasswordChange(w http.ResponseWriter, r *http.Requ
 		return errHTTPBadRequestIncorrectPasswordConfirmation
 	}
 	logvr(v, r).Tag(tagAccount).Debug("Changing password for user %s", u.Name)
-	if err := s.userManager.ChangePassword(u.Name, req.NewPassword); err != nil {
-		return err
+	if err := s.userManagerAs(v, "").ChangePassword(u.Name, req.NewPassword); err != nil {
+		return passwordPolicyError(err)
 	}
 	return s.writeJSON(w, newSuccessResponse())
 }
@@ -222,15 +261,30 @@ func (s *Server) handleAccountTokenCreate(w http.ResponseWriter, r *http.Request
 	if req.Expires != nil {
 		expires = time.Unix(*req.Expires, 0)
 	}
+	scopes := make([]user.Grant, 0, len(req.Scopes))
+	for _, scope := range req.Scopes {
+		if scope == nil || !user.AllowedTopicPattern(scope.Topic) {
+			return errHTTPBadRequestTokenScopeInvalid
+		}
+		permission, err := user.ParsePermission(scope.Permission)
+		if err != nil {
+			return errHTTPBadRequestTokenScopeInvalid
+		}
+		scopes = append(scopes, user.Grant{TopicPattern: scope.Topic, Allow: permission})
+	}
 	u := v.User()
+	if err := s.verifyTwoFactorLogin(r, v, u, req.Code); err != nil {
+		return err
+	}
 	logvr(v, r).
 		Tag(tagAccount).
 		Fields(log.Context{
 			"token_label":   label,
 			"token_expires": expires,
+			"token_scopes":  len(scopes),
 		}).
 		Debug("Creating token for user %s", u.Name)
-	token, err := s.userManager.CreateToken(u.ID, label, expires, v.IP())
+	token, err := s.userManagerAs(v, "").CreateToken(u.ID, label, expires, v.IP(), scopes)
 	if err != nil {
 		return err
 	}
@@ -240,6 +294,7 @@ func (s *Server) handleAccountTokenCreate(w http.ResponseWriter, r *http.Request
 		LastAccess: token.LastAccess.Unix(),
 		LastOrigin: token.LastOrigin.String(),
 		Expires:    token.Expires.Unix(),
+		Scopes:     toAPITokenScopes(token.Scopes),
 	}
 	return s.writeJSON(w, response)
 }
@@ -268,7 +323,7 @@ func (s *Server) handleAccountTokenUpdate(w http.ResponseWriter, r *http.Request
 			"token_expires": expires,
 		}).
 		Debug("Updating token for user %s as deleted", u.Name)
-	token, err := s.userManager.ChangeToken(u.ID, req.Token, req.Label, expires)
+	token, err := s.userManagerAs(v, "").ChangeToken(u.ID, req.Token, req.Label, expires)
 	if err != nil {
 		return err
 	}
@@ -278,10 +333,40 @@ func (s *Server) handleAccountTokenUpdate(w http.ResponseWriter, r *http.Request
 		LastAccess: token.LastAccess.Unix(),
 		LastOrigin: token.LastOrigin.String(),
 		Expires:    token.Expires.Unix(),
+		Scopes:     toAPITokenScopes(token.Scopes),
 	}
 	return s.writeJSON(w, response)
 }
 
+// isScopedToken returns true if the user logged in with a token that is restricted to
+// certain topics (see user.Token.Scopes)
+func (s *Server) isScopedToken(u *user.User) (bool, error) {
+	if u == nil || u.Token == "" {
+		return false, nil
+	}
+	token, err := s.userManager.Token(u.ID, u.Token)
+	if errors.Is(err, user.ErrTokenNotFound) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	return len(token.Scopes) > 0, nil
+}
+
+func toAPITokenScopes(scopes []user.Grant) []*apiAccountTokenScope {
+	if len(scopes) == 0 {
+		return nil
+	}
+	apiScopes := make([]*apiAccountTokenScope, 0, len(scopes))
+	for _, scope := range scopes {
+		apiScopes = append(apiScopes, &apiAccountTokenScope{
+			Topic:      scope.TopicPattern,
+			Permission: scope.Allow.String(),
+		})
+	}
+	return apiScopes
+}
+
 func (s *Server) handleAccountTokenDelete(w http.ResponseWriter, r *http.Request, v *visitor) error {
 	u := v.User()
 	token := readParam(r, "X-Token", "Token") // DELETEs cannot have a body, and we don't want it in the path
@@ -291,7 +376,7 @@ func (s *Server) handleAccountTokenDelete(w http.ResponseWriter, r *http.Request
 			return errHTTPBadRequestNoTokenProvided
 		}
 	}
-	if err := s.userManager.RemoveToken(u.ID, token); err != nil {
+	if err := s.userManagerAs(v, "").RemoveToken(u.ID, token); err != nil {
 		return err
 	}
 	logvr(v, r).
@@ -428,6 +513,16 @@ func (s *Server) handleAccountReservationAdd(w http.ResponseWriter, r *http.Requ
 	if err != nil {
 		return errHTTPBadRequestPermissionInvalid
 	}
+	if req.Retention != nil && (req.Retention.MaxAge < 0 || req.Retention.MaxMessages < 0) {
+		return errHTTPBadRequestRetentionInvalid
+	}
+	var escalation *user.TopicEscalation
+	if req.Escalation != nil {
+		escalation, err = s.parseReservationEscalation(u, req.Topic, req.Escalation)
+		if err != nil {
+			return err
+		}
+	}
 	// Check if we are allowed to reserve this topic
 	if u.IsUser() && u.Tier == nil {
 		return errHTTPUnauthorized
@@ -455,9 +550,23 @@ func (s *Server) handleAccountReservationAdd(w http.ResponseWriter, r *http.Requ
 			"everyone": everyone.String(),
 		}).
 		Debug("Adding topic reservation")
-	if err := s.userManager.AddReservation(u.Name, req.Topic, everyone); err != nil {
+	if err := s.userManagerAs(v, "").AddReservation(u.Name, req.Topic, everyone); err != nil {
 		return err
 	}
+	if req.Retention != nil {
+		if err := s.userManagerAs(v, "").ChangeTopicRetention(&user.TopicRetention{
+			Topic:       req.Topic,
+			MaxAge:      time.Duration(req.Retention.MaxAge) * time.Second,
+			MaxMessages: req.Retention.MaxMessages,
+		}); err != nil {
+			return err
+		}
+	}
+	if escalation != nil {
+		if err := s.userManager.ChangeTopicEscalation(escalation); err != nil {
+			return err
+		}
+	}
 	// Kill existing subscribers
 	t, err := s.topicFromID(req.Topic)
 	if err != nil {
@@ -492,7 +601,7 @@ func (s *Server) handleAccountReservationDelete(w http.ResponseWriter, r *http.R
 			"delete_messages": deleteMessages,
 		}).
 		Debug("Removing topic reservation")
-	if err := s.userManager.RemoveReservations(u.Name, topic); err != nil {
+	if err := s.userManagerAs(v, "").RemoveReservations(u.Name, topic); err != nil {
 		return err
 	}
 	if deleteMessages {
@@ -504,6 +613,70 @@ func (s *Server) handleAccountReservationDelete(w http.ResponseWriter, r *http.R
 	return s.writeJSON(w, newSuccessResponse())
 }
 
+// topicRetentions returns all per-topic retention policies, keyed by topic
+func (s *Server) topicRetentions() (map[string]*user.TopicRetention, error) {
+	retentions, err := s.userManager.TopicRetentions()
+	if err != nil {
+		return nil, err
+	}
+	retentionsByTopic := make(map[string]*user.TopicRetention)
+	for _, retention := range retentions {
+		retentionsByTopic[retention.Topic] = retention
+	}
+	return retentionsByTopic, nil
+}
+
+// topicEscalations returns all per-topic escalation policies, keyed by topic
+func (s *Server) topicEscalations() (map[string]*user.TopicEscalation, error) {
+	escalations, err := s.userManager.TopicEscalations()
+	if err != nil {
+		return nil, err
+	}
+	escalationsByTopic := make(map[string]*user.TopicEscalation)
+	for _, escalation := range escalations {
+		escalationsByTopic[escalation.Topic] = escalation
+	}
+	return escalationsByTopic, nil
+}
+
+// parseReservationEscalation validates the escalation policy of a reservation request. The user must be allowed
+// to write to the forward topic, and the phone number must be one of the user's verified phone numbers. If no
+// escalation steps are set, the returned policy removes the topic's escalation policy.
+func (s *Server) parseReservationEscalation(u *user.User, topic string, req *apiAccountReservationEscalation) (*user.TopicEscalation, error) {
+	escalation := &user.TopicEscalation{
+		Topic:        topic,
+		Delay:        time.Duration(req.Delay) * time.Second,
+		ForwardTopic: req.ForwardTopic,
+		Email:        req.Email,
+	}
+	if req.ForwardTopic == "" && req.Email == "" && req.Call == "" {
+		return escalation, nil
+	} else if escalation.Delay < escalationDelayMin {
+		return nil, errHTTPBadRequestEscalationInvalid.Wrap("delay must be at least %s", escalationDelayMin)
+	}
+	if req.ForwardTopic != "" {
+		if !topicRegex.MatchString(req.ForwardTopic) || req.ForwardTopic == topic {
+			return nil, errHTTPBadRequestEscalationInvalid.Wrap("invalid forward topic")
+		} else if err := s.userManager.Authorize(u, req.ForwardTopic, user.PermissionWrite); err != nil {
+			return nil, errHTTPBadRequestEscalationInvalid.Wrap("no write access to forward topic")
+		}
+	}
+	if req.Email != "" && s.smtpSender == nil {
+		return nil, errHTTPBadRequestEmailDisabled
+	}
+	if req.Call != "" {
+		if s.config.TwilioAccount == "" {
+			return nil, errHTTPBadRequestPhoneCallsDisabled
+		}
+		phoneNumber, err := s.convertPhoneNumber(u, req.Call)
+		if err != nil {
+			return nil, err
+		}
+		escalation.PhoneNumber = phoneNumber
+	}
+	return escalation, nil
+}
+
 // maybeRemoveMessagesAndExcessReservations deletes topic reservations for the given user (if too many for tier),
 // and marks associated messages for the topics as deleted. This also eventually deletes attachments.
 // The process relies on the manager to perform the actual deletions (see runManager).
@@ -520,7 +693,7 @@ func (s *Server) maybeRemoveMessagesAndExcessReservations(r *http.Request, v *vi
 		topics = append(topics, reservations[i].Topic)
 	}
 	logvr(v, r).Tag(tagAccount).Info("Removing excess reservations for topics %s", strings.Join(topics, ", "))
-	if err := s.userManager.RemoveReservations(u.Name, topics...); err != nil {
+	if err := s.userManagerAs(v, "excess").RemoveReservations(u.Name, topics...); err != nil {
 		return err
 	}
 	if err := s.messageCache.ExpireMessages(topics...); err != nil {
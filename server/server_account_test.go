@@ -176,7 +176,7 @@ func TestAccount_ChangeSettings(t *testing.T) {
 
 	require.Nil(t, s.userManager.AddUser("phil", "phil", user.RoleUser))
 	u, _ := s.userManager.User("phil")
-	token, _ := s.userManager.CreateToken(u.ID, "", time.Unix(0, 0), netip.IPv4Unspecified())
+	token, _ := s.userManager.CreateToken(u.ID, "", time.Unix(0, 0), netip.IPv4Unspecified(), nil)
 
 	rr := request(t, s, "PATCH", "/v1/account/settings", `{"notification": {"sound": "juntos"},"ignored": true}`, map[string]string{
 		"Authorization": util.BasicAuth("phil", "phil"),
@@ -283,6 +283,74 @@ func TestAccount_ChangePassword(t *testing.T) {
 	require.Equal(t, 200, rr.Code)
 }
 
+func TestAccount_PasswordPolicy(t *testing.T) {
+	conf := newTestConfigWithAuthFile(t)
+	conf.EnableSignup = true
+	conf.AuthPasswordMinLength = 10
+	conf.AuthPasswordDisallowUsername = true
+	s := newTestServer(t, conf)
+	defer s.closeDatabases()
+
+	rr := request(t, s, "POST", "/v1/account", `{"username":"phil", "password":"short"}`, nil)
+	require.Equal(t, 400, rr.Code)
+	err := toHTTPError(t, rr.Body.String())
+	require.Equal(t, 40057, err.Code)
+	require.Equal(t, "invalid request: password does not meet the password policy; password must be at least 10 characters long", err.Message)
+
+	rr = request(t, s, "POST", "/v1/account", `{"username":"phil", "password":"phil's password"}`, nil)
+	require.Equal(t, 400, rr.Code)
+	require.Equal(t, 40057, toHTTPError(t, rr.Body.String()).Code)
+
+	rr = request(t, s, "POST", "/v1/account", `{"username":"phil", "password":"long password"}`, nil)
+	require.Equal(t, 200, rr.Code)
+
+	rr = request(t, s, "POST", "/v1/account/password", `{"password": "long password", "new_password": "short"}`, map[string]string{
+		"Authorization": util.BasicAuth("phil", "long password"),
+	})
+	require.Equal(t, 400, rr.Code)
+	require.Equal(t, 40057, toHTTPError(t, rr.Body.String()).Code)
+
+	require.Nil(t, s.userManager.AddUser("admin", "secret password", user.RoleAdmin))
+	rr = request(t, s, "PUT", "/v1/users", `{"username": "ben", "password":"ben"}`, map[string]string{
+		"Authorization": util.BasicAuth("admin", "secret password"),
+	})
+	require.Equal(t, 400, rr.Code)
+	require.Equal(t, 40057, toHTTPError(t, rr.Body.String()).Code)
+}
+
+func TestAccount_Lockout(t *testing.T) {
+	conf := newTestConfigWithAuthFile(t)
+	conf.AuthLockoutAttempts = 2
+	conf.AuthLockoutDuration = time.Hour
+	s := newTestServer(t, conf)
+	defer s.closeDatabases()
+	require.Nil(t, s.userManager.AddUser("phil", "phil", user.RoleUser))
+
+	for i := 0; i < 2; i++ {
+		rr := request(t, s, "GET", "/v1/account", "", map[string]string{
+			"Authorization": util.BasicAuth("phil", "wrong"),
+		})
+		require.Equal(t, 401, rr.Code)
+	}
+	rr := request(t, s, "GET", "/v1/account", "", map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 401, rr.Code)
+	require.Equal(t, 40101, toHTTPError(t, rr.Body.String()).Code) // Does not reveal that the user exists
+
+	events, err := s.userManager.AuditEvents(&user.AuditFilter{Action: user.AuditActionLoginFailed})
+	require.Nil(t, err)
+	require.Len(t, events, 3)
+	require.Equal(t, "password, user locked", events[0].Details)
+	require.Equal(t, "password", events[1].Details)
+
+	require.Nil(t, s.userManager.UnlockUser("phil"))
+	rr = request(t, s, "GET", "/v1/account", "", map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 200, rr.Code)
+}
+
 func TestAccount_ChangePassword_NoAccount(t *testing.T) {
 	s := newTestServer(t, newTestConfigWithAuthFile(t))
 	defer s.closeDatabases()
@@ -341,6 +409,71 @@ func TestAccount_ExtendToken_NoTokenProvided(t *testing.T) {
 	require.Equal(t, 40023, toHTTPError(t, rr.Body.String()).Code)
 }
 
+func TestAccount_ScopedToken(t *testing.T) {
+	s := newTestServer(t, newTestConfigWithAuthFile(t))
+	defer s.closeDatabases()
+
+	require.Nil(t, s.userManager.AddUser("phil", "phil", user.RoleUser))
+	require.Nil(t, s.userManager.AllowAccess("phil", "ci-*", user.PermissionReadWrite))
+	require.Nil(t, s.userManager.AllowAccess("phil", "private", user.PermissionReadWrite))
+
+	rr := request(t, s, "POST", "/v1/account/token", `{"label":"ci","scopes":[{"topic":"ci-*","permission":"write-only"}]}`, map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 200, rr.Code)
+	token, err := util.UnmarshalJSON[apiAccountTokenResponse](io.NopCloser(rr.Body))
+	require.Nil(t, err)
+	require.Equal(t, []*apiAccountTokenScope{{Topic: "ci-*", Permission: "write-only"}}, token.Scopes)
+
+	// Publishing to a topic within the scope works, everything else does not
+	rr = request(t, s, "PUT", "/ci-builds", "build passed", map[string]string{
+		"Authorization": util.BearerAuth(token.Token),
+	})
+	require.Equal(t, 200, rr.Code)
+	rr = request(t, s, "GET", "/ci-builds/json?poll=1", "", map[string]string{
+		"Authorization": util.BearerAuth(token.Token),
+	})
+	require.Equal(t, 403, rr.Code)
+	rr = request(t, s, "PUT", "/private", "secret", map[string]string{
+		"Authorization": util.BearerAuth(token.Token),
+	})
+	require.Equal(t, 403, rr.Code)
+
+	// Scoped tokens cannot be used to manage the account
+	rr = request(t, s, "GET", "/v1/account", "", map[string]string{
+		"Authorization": util.BearerAuth(token.Token),
+	})
+	require.Equal(t, 403, rr.Code)
+	require.Equal(t, 40302, toHTTPError(t, rr.Body.String()).Code)
+	rr = request(t, s, "POST", "/v1/account/token", "", map[string]string{
+		"Authorization": util.BearerAuth(token.Token),
+	})
+	require.Equal(t, 403, rr.Code)
+	require.Equal(t, 40302, toHTTPError(t, rr.Body.String()).Code)
+
+	// Scopes are listed in the account
+	rr = request(t, s, "GET", "/v1/account", "", map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 200, rr.Code)
+	account, err := util.UnmarshalJSON[apiAccountResponse](io.NopCloser(rr.Body))
+	require.Nil(t, err)
+	require.Equal(t, 1, len(account.Tokens))
+	require.Equal(t, []*apiAccountTokenScope{{Topic: "ci-*", Permission: "write-only"}}, account.Tokens[0].Scopes)
+
+	// Invalid scopes
+	rr = request(t, s, "POST", "/v1/account/token", `{"scopes":[{"topic":"ci/*","permission":"write-only"}]}`, map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 400, rr.Code)
+	require.Equal(t, 40052, toHTTPError(t, rr.Body.String()).Code)
+	rr = request(t, s, "POST", "/v1/account/token", `{"scopes":[{"topic":"ci-*","permission":"everything"}]}`, map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 400, rr.Code)
+	require.Equal(t, 40052, toHTTPError(t, rr.Body.String()).Code)
+}
+
 func TestAccount_DeleteToken(t *testing.T) {
 	s := newTestServer(t, newTestConfigWithAuthFile(t))
 	defer s.closeDatabases()
@@ -583,6 +716,160 @@ func TestAccount_Reservation_AddRemoveUserWithTierSuccess(t *testing.T) {
 	require.Equal(t, "mytopic", account.Reservations[0].Topic)
 }
 
+func TestAccount_Reservation_Retention(t *testing.T) {
+	conf := newTestConfigWithAuthFile(t)
+	conf.EnableSignup = true
+	conf.EnableReservations = true
+	s := newTestServer(t, conf)
+
+	// Create user with tier
+	rr := request(t, s, "POST", "/v1/account", `{"username":"phil", "password":"mypass"}`, nil)
+	require.Equal(t, 200, rr.Code)
+	require.Nil(t, s.userManager.AddTier(&user.Tier{
+		Code:                  "pro",
+		MessageLimit:          20,
+		MessageExpiryDuration: time.Hour,
+		ReservationLimit:      2,
+	}))
+	require.Nil(t, s.userManager.ChangeTier("phil", "pro"))
+
+	// Reserve topic with retention
+	rr = request(t, s, "POST", "/v1/account/reservation", `{"topic": "mytopic", "everyone":"deny-all", "retention": {"max_age": 3600, "max_messages": 2}}`, map[string]string{
+		"Authorization": util.BasicAuth("phil", "mypass"),
+	})
+	require.Equal(t, 200, rr.Code)
+
+	// Invalid retention fails
+	rr = request(t, s, "POST", "/v1/account/reservation", `{"topic": "mytopic", "everyone":"deny-all", "retention": {"max_messages": -1}}`, map[string]string{
+		"Authorization": util.BasicAuth("phil", "mypass"),
+	})
+	require.Equal(t, 400, rr.Code)
+	require.Equal(t, 40046, toHTTPError(t, rr.Body.String()).Code)
+
+	// Changing the reservation without retention leaves retention unchanged
+	rr = request(t, s, "POST", "/v1/account/reservation", `{"topic": "mytopic", "everyone":"read-only"}`, map[string]string{
+		"Authorization": util.BasicAuth("phil", "mypass"),
+	})
+	require.Equal(t, 200, rr.Code)
+
+	rr = request(t, s, "GET", "/v1/account", "", map[string]string{
+		"Authorization": util.BasicAuth("phil", "mypass"),
+	})
+	require.Equal(t, 200, rr.Code)
+	account, _ := util.UnmarshalJSON[apiAccountResponse](io.NopCloser(rr.Body))
+	require.Equal(t, 1, len(account.Reservations))
+	require.Equal(t, "read-only", account.Reservations[0].Everyone)
+	require.Equal(t, int64(3600), account.Reservations[0].Retention.MaxAge)
+	require.Equal(t, int64(2), account.Reservations[0].Retention.MaxMessages)
+
+	// Publish three messages, and prune: only the newest two remain
+	for i := 1; i <= 3; i++ {
+		rr = request(t, s, "PUT", "/mytopic", fmt.Sprintf("message %d", i), map[string]string{
+			"Authorization": util.BasicAuth("phil", "mypass"),
+		})
+		require.Equal(t, 200, rr.Code)
+	}
+	s.execManager()
+
+	rr = request(t, s, "GET", "/mytopic/json?poll=1", "", map[string]string{
+		"Authorization": util.BasicAuth("phil", "mypass"),
+	})
+	require.Equal(t, 200, rr.Code)
+	messages := toMessages(t, rr.Body.String())
+	require.Equal(t, 2, len(messages))
+	require.Equal(t, "message 2", messages[0].Message)
+	require.Equal(t, "message 3", messages[1].Message)
+
+	// Setting no limits removes the retention
+	rr = request(t, s, "POST", "/v1/account/reservation", `{"topic": "mytopic", "everyone":"read-only", "retention": {}}`, map[string]string{
+		"Authorization": util.BasicAuth("phil", "mypass"),
+	})
+	require.Equal(t, 200, rr.Code)
+
+	rr = request(t, s, "GET", "/v1/account", "", map[string]string{
+		"Authorization": util.BasicAuth("phil", "mypass"),
+	})
+	require.Equal(t, 200, rr.Code)
+	account, _ = util.UnmarshalJSON[apiAccountResponse](io.NopCloser(rr.Body))
+	require.Equal(t, 1, len(account.Reservations))
+	require.Nil(t, account.Reservations[0].Retention)
+}
+
+func TestAccount_Reservation_Escalation(t *testing.T) {
+	conf := newTestConfigWithAuthFile(t)
+	conf.EnableSignup = true
+	conf.EnableReservations = true
+	s := newTestServer(t, conf)
+	s.smtpSender = &testMailer{}
+
+	// Create user with tier
+	rr := request(t, s, "POST", "/v1/account", `{"username":"phil", "password":"mypass"}`, nil)
+	require.Equal(t, 200, rr.Code)
+	require.Nil(t, s.userManager.AddTier(&user.Tier{
+		Code:             "pro",
+		MessageLimit:     20,
+		ReservationLimit: 2,
+	}))
+	require.Nil(t, s.userManager.ChangeTier("phil", "pro"))
+
+	// Reserve topic with escalation policy
+	rr = request(t, s, "POST", "/v1/account/reservation", `{"topic": "mytopic", "everyone":"deny-all", "escalation": {"delay": 300, "forward_topic": "oncall", "email": "phil@example.com"}}`, map[string]string{
+		"Authorization": util.BasicAuth("phil", "mypass"),
+	})
+	require.Equal(t, 200, rr.Code)
+
+	// Invalid escalation policies fail
+	rr = request(t, s, "POST", "/v1/account/reservation", `{"topic": "mytopic", "everyone":"deny-all", "escalation": {"delay": 10, "forward_topic": "oncall"}}`, map[string]string{
+		"Authorization": util.BasicAuth("phil", "mypass"),
+	})
+	require.Equal(t, 400, rr.Code)
+	require.Equal(t, 40049, toHTTPError(t, rr.Body.String()).Code)
+
+	rr = request(t, s, "POST", "/v1/account/reservation", `{"topic": "mytopic", "everyone":"deny-all", "escalation": {"delay": 300, "forward_topic": "mytopic"}}`, map[string]string{
+		"Authorization": util.BasicAuth("phil", "mypass"),
+	})
+	require.Equal(t, 400, rr.Code)
+	require.Equal(t, 40049, toHTTPError(t, rr.Body.String()).Code)
+
+	rr = request(t, s, "POST", "/v1/account/reservation", `{"topic": "mytopic", "everyone":"deny-all", "escalation": {"delay": 300, "call": "+11122233344"}}`, map[string]string{
+		"Authorization": util.BasicAuth("phil", "mypass"),
+	})
+	require.Equal(t, 400, rr.Code)
+	require.Equal(t, 40032, toHTTPError(t, rr.Body.String()).Code)
+
+	// Changing the reservation without escalation leaves the policy unchanged
+	rr = request(t, s, "POST", "/v1/account/reservation", `{"topic": "mytopic", "everyone":"read-only"}`, map[string]string{
+		"Authorization": util.BasicAuth("phil", "mypass"),
+	})
+	require.Equal(t, 200, rr.Code)
+
+	rr = request(t, s, "GET", "/v1/account", "", map[string]string{
+		"Authorization": util.BasicAuth("phil", "mypass"),
+	})
+	require.Equal(t, 200, rr.Code)
+	account, _ := util.UnmarshalJSON[apiAccountResponse](io.NopCloser(rr.Body))
+	require.Equal(t, 1, len(account.Reservations))
+	require.NotNil(t, account.Reservations[0].Escalation)
+	require.Equal(t, int64(300), account.Reservations[0].Escalation.Delay)
+	require.Equal(t, "oncall", account.Reservations[0].Escalation.ForwardTopic)
+	require.Equal(t, "phil@example.com", account.Reservations[0].Escalation.Email)
+	require.Equal(t, "", account.Reservations[0].Escalation.Call)
+
+	// Setting no steps removes the policy
+	rr = request(t, s, "POST", "/v1/account/reservation", `{"topic": "mytopic", "everyone":"read-only", "escalation": {}}`, map[string]string{
+		"Authorization": util.BasicAuth("phil", "mypass"),
+	})
+	require.Equal(t, 200, rr.Code)
+
+	rr = request(t, s, "GET", "/v1/account", "", map[string]string{
+		"Authorization": util.BasicAuth("phil", "mypass"),
+	})
+	require.Equal(t, 200, rr.Code)
+	account, _ = util.UnmarshalJSON[apiAccountResponse](io.NopCloser(rr.Body))
+	require.Equal(t, 1, len(account.Reservations))
+	require.Nil(t, account.Reservations[0].Escalation)
+}
+
 func TestAccount_Reservation_PublishByAnonymousFails(t *testing.T) {
 	conf := newTestConfigWithAuthFile(t)
 	conf.AuthDefault = user.PermissionReadWrite
@@ -2,7 +2,9 @@ package server
 
 import (
 	"heckel.io/ntfy/v2/user"
+	"heckel.io/ntfy/v2/util"
 	"net/http"
+	"time"
 )
 
 func (s *Server) handleUsersGet(w http.ResponseWriter, r *http.Request, v *visitor) error {
@@ -59,11 +61,11 @@ func (s *Server) handleUsersAdd(w http.ResponseWriter, r *http.Request, v *visit
 			return err
 		}
 	}
-	if err := s.userManager.AddUser(req.Username, req.Password, user.RoleUser); err != nil {
-		return err
+	if err := s.userManagerAs(v, "").AddUser(req.Username, req.Password, user.RoleUser); err != nil {
+		return passwordPolicyError(err)
 	}
 	if tier != nil {
-		if err := s.userManager.ChangeTier(req.Username, req.Tier); err != nil {
+		if err := s.userManagerAs(v, "").ChangeTier(req.Username, req.Tier); err != nil {
 			return err
 		}
 	}
@@ -83,7 +85,7 @@ func (s *Server) handleUsersDelete(w http.ResponseWriter, r *http.Request, v *vi
 	} else if !u.IsUser() {
 		return errHTTPUnauthorized.Wrap("can only remove regular users from API")
 	}
-	if err := s.userManager.RemoveUser(req.Username); err != nil {
+	if err := s.userManagerAs(v, "").RemoveUser(req.Username); err != nil {
 		return err
 	}
 	if err := s.killUserSubscriber(u, "*"); err != nil { // FIXME super inefficient
@@ -107,7 +109,7 @@ func (s *Server) handleAccessAllow(w http.ResponseWriter, r *http.Request, v *vi
 	if err != nil {
 		return errHTTPBadRequestPermissionInvalid
 	}
-	if err := s.userManager.AllowAccess(req.Username, req.Topic, permission); err != nil {
+	if err := s.userManagerAs(v, "").AllowAccess(req.Username, req.Topic, permission); err != nil {
 		return err
 	}
 	return s.writeJSON(w, newSuccessResponse())
@@ -122,7 +124,7 @@ func (s *Server) handleAccessReset(w http.ResponseWriter, r *http.Request, v *vi
 	if err != nil {
 		return err
 	}
-	if err := s.userManager.ResetAccess(req.Username, req.Topic); err != nil {
+	if err := s.userManagerAs(v, "").ResetAccess(req.Username, req.Topic); err != nil {
 		return err
 	}
 	if err := s.killUserSubscriber(u, req.Topic); err != nil { // This may be a pattern
@@ -131,6 +133,219 @@ func (s *Server) handleAccessReset(w http.ResponseWriter, r *http.Request, v *vi
 	return s.writeJSON(w, newSuccessResponse())
 }
 
+func (s *Server) handleGroupsGet(w http.ResponseWriter, r *http.Request, v *visitor) error {
+	groups, err := s.userManager.Groups()
+	if err != nil {
+		return err
+	}
+	response := make([]*apiGroupResponse, len(groups))
+	for i, g := range groups {
+		grants := make([]*apiUserGrantResponse, len(g.Grants))
+		for j, grant := range g.Grants {
+			grants[j] = &apiUserGrantResponse{
+				Topic:      grant.TopicPattern,
+				Permission: grant.Allow.String(),
+			}
+		}
+		response[i] = &apiGroupResponse{
+			Name:    g.Name,
+			Members: g.Members,
+			Grants:  grants,
+		}
+	}
+	return s.writeJSON(w, response)
+}
+
+func (s *Server) handleGroupsAdd(w http.ResponseWriter, r *http.Request, v *visitor) error {
+	req, err := readJSONWithLimit[apiGroupRequest](r.Body, jsonBodyBytesLimit, false)
+	if err != nil {
+		return err
+	} else if !user.AllowedGroup(req.Name) {
+		return errHTTPBadRequest.Wrap("group name invalid")
+	}
+	if err := s.userManagerAs(v, "").AddGroup(req.Name); err == user.ErrGroupExists {
+		return errHTTPConflictGroupExists
+	} else if err != nil {
+		return err
+	}
+	return s.writeJSON(w, newSuccessResponse())
+}
+
+func (s *Server) handleGroupsDelete(w http.ResponseWriter, r *http.Request, v *visitor) error {
+	req, err := readJSONWithLimit[apiGroupRequest](r.Body, jsonBodyBytesLimit, false)
+	if err != nil {
+		return err
+	}
+	group, err := s.userManager.Group(req.Name)
+	if err == user.ErrGroupNotFound {
+		return errHTTPBadRequestGroupNotFound
+	} else if err != nil {
+		return err
+	}
+	if err := s.userManagerAs(v, "").RemoveGroup(req.Name); err != nil {
+		return err
+	}
+	for _, username := range group.Members {
+		if err := s.killGroupMemberSubscriber(username, group.Grants); err != nil {
+			return err
+		}
+	}
+	return s.writeJSON(w, newSuccessResponse())
+}
+
+func (s *Server) handleGroupMemberAdd(w http.ResponseWriter, r *http.Request, v *visitor) error {
+	req, err := readJSONWithLimit[apiGroupMemberRequest](r.Body, jsonBodyBytesLimit, false)
+	if err != nil {
+		return err
+	}
+	if _, err := s.userManager.Group(req.Group); err == user.ErrGroupNotFound {
+		return errHTTPBadRequestGroupNotFound
+	} else if err != nil {
+		return err
+	}
+	if _, err := s.userManager.User(req.Username); err == user.ErrUserNotFound || req.Username == user.Everyone {
+		return errHTTPBadRequestUserNotFound
+	} else if err != nil {
+		return err
+	}
+	if err := s.userManagerAs(v, "").AddGroupMember(req.Group, req.Username); err != nil {
+		return err
+	}
+	return s.writeJSON(w, newSuccessResponse())
+}
+
+func (s *Server) handleGroupMemberRemove(w http.ResponseWriter, r *http.Request, v *visitor) error {
+	req, err := readJSONWithLimit[apiGroupMemberRequest](r.Body, jsonBodyBytesLimit, false)
+	if err != nil {
+		return err
+	}
+	group, err := s.userManager.Group(req.Group)
+	if err == user.ErrGroupNotFound {
+		return errHTTPBadRequestGroupNotFound
+	} else if err != nil {
+		return err
+	} else if !util.Contains(group.Members, req.Username) {
+		return errHTTPBadRequestUserNotFound
+	}
+	if err := s.userManagerAs(v, "").RemoveGroupMember(req.Group, req.Username); err != nil {
+		return err
+	}
+	if err := s.killGroupMemberSubscriber(req.Username, group.Grants); err != nil {
+		return err
+	}
+	return s.writeJSON(w, newSuccessResponse())
+}
+
+func (s *Server) handleGroupAccessAllow(w http.ResponseWriter, r *http.Request, v *visitor) error {
+	req, err := readJSONWithLimit[apiGroupAccessAllowRequest](r.Body, jsonBodyBytesLimit, false)
+	if err != nil {
+		return err
+	}
+	permission, err := user.ParsePermission(req.Permission)
+	if err != nil {
+		return errHTTPBadRequestPermissionInvalid
+	}
+	if err := s.userManagerAs(v, "").AllowGroupAccess(req.Group, req.Topic, permission); err == user.ErrGroupNotFound {
+		return errHTTPBadRequestGroupNotFound
+	} else if err == user.ErrInvalidArgument {
+		return errHTTPBadRequestTopicInvalid
+	} else if err != nil {
+		return err
+	}
+	return s.writeJSON(w, newSuccessResponse())
+}
+
+func (s *Server) handleGroupAccessReset(w http.ResponseWriter, r *http.Request, v *visitor) error {
+	req, err := readJSONWithLimit[apiGroupAccessResetRequest](r.Body, jsonBodyBytesLimit, false)
+	if err != nil {
+		return err
+	}
+	group, err := s.userManager.Group(req.Group)
+	if err == user.ErrGroupNotFound {
+		return errHTTPBadRequestGroupNotFound
+	} else if err != nil {
+		return err
+	}
+	if err := s.userManagerAs(v, "").ResetGroupAccess(req.Group, req.Topic); err == user.ErrInvalidArgument {
+		return errHTTPBadRequestTopicInvalid
+	} else if err != nil {
+		return err
+	}
+	grants := group.Grants
+	if req.Topic != "" {
+		grants = []user.Grant{{TopicPattern: req.Topic}}
+	}
+	for _, username := range group.Members {
+		if err := s.killGroupMemberSubscriber(username, grants); err != nil {
+			return err
+		}
+	}
+	return s.writeJSON(w, newSuccessResponse())
+}
+
+func (s *Server) handleTopicRetentionGet(w http.ResponseWriter, r *http.Request, v *visitor) error {
+	retentions, err := s.userManager.TopicRetentions()
+	if err != nil {
+		return err
+	}
+	response := make([]*apiTopicRetentionResponse, len(retentions))
+	for i, retention := range retentions {
+		response[i] = &apiTopicRetentionResponse{
+			Topic:       retention.Topic,
+			MaxAge:      int64(retention.MaxAge.Seconds()),
+			MaxMessages: retention.MaxMessages,
+		}
+	}
+	return s.writeJSON(w, response)
+}
+
+func (s *Server) handleTopicRetentionChange(w http.ResponseWriter, r *http.Request, v *visitor) error {
+	req, err := readJSONWithLimit[apiTopicRetentionRequest](r.Body, jsonBodyBytesLimit, false)
+	if err != nil {
+		return err
+	} else if !topicRegex.MatchString(req.Topic) {
+		return errHTTPBadRequestTopicInvalid
+	} else if req.MaxAge < 0 || req.MaxMessages < 0 {
+		return errHTTPBadRequestRetentionInvalid
+	}
+	if err := s.userManagerAs(v, "").ChangeTopicRetention(&user.TopicRetention{
+		Topic:       req.Topic,
+		MaxAge:      time.Duration(req.MaxAge) * time.Second,
+		MaxMessages: req.MaxMessages,
+	}); err != nil {
+		return err
+	}
+	return s.writeJSON(w, newSuccessResponse())
+}
+
+func (s *Server) handleTopicRetentionReset(w http.ResponseWriter, r *http.Request, v *visitor) error {
+	req, err := readJSONWithLimit[apiTopicRetentionDeleteRequest](r.Body, jsonBodyBytesLimit, false)
+	if err != nil {
+		return err
+	} else if !topicRegex.MatchString(req.Topic) {
+		return errHTTPBadRequestTopicInvalid
+	}
+	if err := s.userManagerAs(v, "").ResetTopicRetention(req.Topic); err != nil {
+		return err
+	}
+	return s.writeJSON(w, newSuccessResponse())
+}
+
+// killGroupMemberSubscriber cancels the subscriptions of the given user to all topics matching the given grants,
+// after the user lost access via a group. The subscriber reconnects if it still has access by other means.
+func (s *Server) killGroupMemberSubscriber(username string, grants []user.Grant) error {
+	u, err := s.userManager.User(username)
+	if err != nil {
+		return err
+	}
+	for _, grant := range grants {
+		if err := s.killUserSubscriber(u, grant.TopicPattern); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func (s *Server) killUserSubscriber(u *user.User, topicPattern string) error {
 	topics, err := s.topicsFromPattern(topicPattern)
 	if err != nil {
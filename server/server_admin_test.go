@@ -4,6 +4,7 @@ import (
 	"github.com/stretchr/testify/require"
 	"heckel.io/ntfy/v2/user"
 	"heckel.io/ntfy/v2/util"
+	"io"
 	"sync/atomic"
 	"testing"
 	"time"
@@ -147,6 +148,166 @@ func TestAccess_AllowReset_NonAdminAttempt(t *testing.T) {
 	require.Equal(t, 401, rr.Code)
 }
 
+func TestGroups_AddMemberAccess(t *testing.T) {
+	c := newTestConfigWithAuthFile(t)
+	c.AuthDefault = user.PermissionDenyAll
+	s := newTestServer(t, c)
+	defer s.closeDatabases()
+
+	// User and admin
+	require.Nil(t, s.userManager.AddUser("phil", "phil", user.RoleAdmin))
+	require.Nil(t, s.userManager.AddUser("ben", "ben", user.RoleUser))
+	admin := map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	}
+
+	// Create group, add member, grant access
+	rr := request(t, s, "PUT", "/v1/groups", `{"name": "ops"}`, admin)
+	require.Equal(t, 200, rr.Code)
+	rr = request(t, s, "PUT", "/v1/groups", `{"name": "ops"}`, admin)
+	require.Equal(t, 409, rr.Code)
+	rr = request(t, s, "PUT", "/v1/groups/members", `{"group": "ops", "username": "ben"}`, admin)
+	require.Equal(t, 200, rr.Code)
+	rr = request(t, s, "PUT", "/v1/groups/access", `{"group": "ops", "topic": "gold*", "permission": "ro"}`, admin)
+	require.Equal(t, 200, rr.Code)
+
+	rr = request(t, s, "GET", "/v1/groups", "", admin)
+	require.Equal(t, 200, rr.Code)
+	groups, err := util.UnmarshalJSON[[]*apiGroupResponse](io.NopCloser(rr.Body))
+	require.Nil(t, err)
+	require.Equal(t, 1, len(*groups))
+	require.Equal(t, "ops", (*groups)[0].Name)
+	require.Equal(t, []string{"ben"}, (*groups)[0].Members)
+	require.Equal(t, "gold*", (*groups)[0].Grants[0].Topic)
+	require.Equal(t, "read-only", (*groups)[0].Grants[0].Permission)
+
+	// Member can subscribe, but not publish
+	rr = request(t, s, "GET", "/gold-prices/json?poll=1", "", map[string]string{
+		"Authorization": util.BasicAuth("ben", "ben"),
+	})
+	require.Equal(t, 200, rr.Code)
+	rr = request(t, s, "PUT", "/gold-prices", "up", map[string]string{
+		"Authorization": util.BasicAuth("ben", "ben"),
+	})
+	require.Equal(t, 403, rr.Code)
+
+	// Removing the member revokes access
+	rr = request(t, s, "DELETE", "/v1/groups/members", `{"group": "ops", "username": "ben"}`, admin)
+	require.Equal(t, 200, rr.Code)
+	rr = request(t, s, "GET", "/gold-prices/json?poll=1", "", map[string]string{
+		"Authorization": util.BasicAuth("ben", "ben"),
+	})
+	require.Equal(t, 403, rr.Code)
+
+	// Reset access and delete group
+	rr = request(t, s, "DELETE", "/v1/groups/access", `{"group": "ops", "topic": "gold*"}`, admin)
+	require.Equal(t, 200, rr.Code)
+	rr = request(t, s, "DELETE", "/v1/groups", `{"name": "ops"}`, admin)
+	require.Equal(t, 200, rr.Code)
+	_, err = s.userManager.Group("ops")
+	require.Equal(t, user.ErrGroupNotFound, err)
+}
+
+func TestGroups_Failures(t *testing.T) {
+	c := newTestConfigWithAuthFile(t)
+	s := newTestServer(t, c)
+	defer s.closeDatabases()
+
+	require.Nil(t, s.userManager.AddUser("phil", "phil", user.RoleAdmin))
+	require.Nil(t, s.userManager.AddUser("ben", "ben", user.RoleUser))
+	admin := map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	}
+
+	// Non-admin
+	rr := request(t, s, "PUT", "/v1/groups", `{"name": "ops"}`, map[string]string{
+		"Authorization": util.BasicAuth("ben", "ben"),
+	})
+	require.Equal(t, 401, rr.Code)
+
+	// Invalid group name
+	rr = request(t, s, "PUT", "/v1/groups", `{"name": "not a group"}`, admin)
+	require.Equal(t, 400, rr.Code)
+
+	// Group does not exist
+	rr = request(t, s, "PUT", "/v1/groups/members", `{"group": "ops", "username": "ben"}`, admin)
+	require.Equal(t, 400, rr.Code)
+	require.Equal(t, 40051, toHTTPError(t, rr.Body.String()).Code)
+	rr = request(t, s, "PUT", "/v1/groups/access", `{"group": "ops", "topic": "gold", "permission": "rw"}`, admin)
+	require.Equal(t, 40051, toHTTPError(t, rr.Body.String()).Code)
+	rr = request(t, s, "DELETE", "/v1/groups", `{"name": "ops"}`, admin)
+	require.Equal(t, 40051, toHTTPError(t, rr.Body.String()).Code)
+
+	// User does not exist, invalid permission and topic
+	require.Nil(t, s.userManager.AddGroup("ops"))
+	rr = request(t, s, "PUT", "/v1/groups/members", `{"group": "ops", "username": "nobody"}`, admin)
+	require.Equal(t, 40031, toHTTPError(t, rr.Body.String()).Code)
+	rr = request(t, s, "PUT", "/v1/groups/members", `{"group": "ops", "username": "*"}`, admin)
+	require.Equal(t, 40031, toHTTPError(t, rr.Body.String()).Code)
+	rr = request(t, s, "PUT", "/v1/groups/access", `{"group": "ops", "topic": "gold", "permission": "everything"}`, admin)
+	require.Equal(t, 40025, toHTTPError(t, rr.Body.String()).Code)
+	rr = request(t, s, "PUT", "/v1/groups/access", `{"group": "ops", "topic": "gold/silver", "permission": "rw"}`, admin)
+	require.Equal(t, 40009, toHTTPError(t, rr.Body.String()).Code)
+}
+
+func TestTopicRetention_ChangeReset(t *testing.T) {
+	c := newTestConfigWithAuthFile(t)
+	s := newTestServer(t, c)
+	defer s.closeDatabases()
+
+	// User and admin
+	require.Nil(t, s.userManager.AddUser("phil", "phil", user.RoleAdmin))
+	require.Nil(t, s.userManager.AddUser("ben", "ben", user.RoleUser))
+
+	// Set retention
+	rr := request(t, s, "PUT", "/v1/topics/retention", `{"topic": "gold", "max_age": 86400}`, map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 200, rr.Code)
+
+	rr = request(t, s, "PUT", "/v1/topics/retention", `{"topic": "silver", "max_messages": 10}`, map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 200, rr.Code)
+
+	rr = request(t, s, "PUT", "/v1/topics/retention", `{"topic": "silver", "max_age": -1}`, map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 400, rr.Code)
+	require.Equal(t, 40046, toHTTPError(t, rr.Body.String()).Code)
+
+	// Non-admins cannot list or change retention
+	rr = request(t, s, "GET", "/v1/topics/retention", "", map[string]string{
+		"Authorization": util.BasicAuth("ben", "ben"),
+	})
+	require.Equal(t, 401, rr.Code)
+
+	rr = request(t, s, "PUT", "/v1/topics/retention", `{"topic": "gold", "max_age": 1}`, map[string]string{
+		"Authorization": util.BasicAuth("ben", "ben"),
+	})
+	require.Equal(t, 401, rr.Code)
+
+	// List retention
+	rr = request(t, s, "GET", "/v1/topics/retention", "", map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 200, rr.Code)
+	retentions, err := util.UnmarshalJSON[[]apiTopicRetentionResponse](io.NopCloser(rr.Body))
+	require.Nil(t, err)
+	require.Equal(t, 2, len(*retentions))
+	require.Equal(t, apiTopicRetentionResponse{Topic: "gold", MaxAge: 86400}, (*retentions)[0])
+	require.Equal(t, apiTopicRetentionResponse{Topic: "silver", MaxMessages: 10}, (*retentions)[1])
+
+	// Reset retention
+	rr = request(t, s, "DELETE", "/v1/topics/retention", `{"topic": "gold"}`, map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 200, rr.Code)
+
+	_, err = s.userManager.TopicRetention("gold")
+	require.Equal(t, user.ErrTopicRetentionNotFound, err)
+}
+
 func TestAccess_AllowReset_KillConnection(t *testing.T) {
 	c := newTestConfigWithAuthFile(t)
 	c.AuthDefault = user.PermissionDenyAll
@@ -0,0 +1,250 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Prometheus Alertmanager integration:
+//
+// ntfy implements a receiver for Alertmanager's webhook notifications (see
+// https://prometheus.io/docs/alerting/latest/configuration/#webhook_config) at POST /<topic>/alertmanager.
+// By default, one notification is published per alert. If the "grouped" param is set, one notification is
+// published for the entire alert group instead.
+
+const (
+	alertmanagerBodyBytesLimit = 262144 // Max number of bytes for an Alertmanager webhook request body
+	alertmanagerStatusFiring   = "firing"
+	alertmanagerStatusResolved = "resolved"
+	alertmanagerTagFiring      = "rotating_light"   // Emoji tag for firing alerts
+	alertmanagerTagResolved    = "white_check_mark" // Emoji tag for resolved alerts
+)
+
+// alertmanagerSeverityPriorities maps the values of the "severity" label to message priorities. Resolved
+// alerts and alerts with an unknown severity are published with the default priority.
+var alertmanagerSeverityPriorities = map[string]int{
+	"critical": 5,
+	"page":     5,
+	"error":    4,
+	"high":     4,
+	"warning":  3,
+	"info":     2,
+	"low":      2,
+	"none":     1,
+}
+
+// alertmanagerWebhook represents an Alertmanager webhook request (version 4), e.g.
+//
+//	{
+//	  "version": "4",
+//	  "status": "firing",
+//	  "groupLabels": { "alertname": "HighCPU" },
+//	  "alerts": [
+//	    {
+//	      "status": "firing",
+//	      "labels": { "alertname": "HighCPU", "severity": "critical", "instance": "db1:9100" },
+//	      "annotations": { "summary": "CPU usage above 90% on db1" },
+//	      "generatorURL": "https://prometheus.example.com/graph?g0.expr=...",
+//	      ...
+//	    }
+//	  ],
+//	  ...
+//	}
+type alertmanagerWebhook struct {
+	Version      string               `json:"version"`
+	Status       string               `json:"status"`
+	Receiver     string               `json:"receiver"`
+	GroupLabels  map[string]string    `json:"groupLabels"`
+	CommonLabels map[string]string    `json:"commonLabels"`
+	ExternalURL  string               `json:"externalURL"`
+	Alerts       []*alertmanagerAlert `json:"alerts"`
+}
+
+type alertmanagerAlert struct {
+	Status       string            `json:"status"`
+	Labels       map[string]string `json:"labels"`
+	Annotations  map[string]string `json:"annotations"`
+	GeneratorURL string            `json:"generatorURL"`
+	Fingerprint  string            `json:"fingerprint"`
+}
+
+// alertmanagerNotification is a notification derived from one or more alerts, see newAlertmanagerNotifications
+type alertmanagerNotification struct {
+	Title    string
+	Message  string
+	Priority int // 0 means default priority
+	Tags     []string
+	Click    string
+}
+
+// newAlertmanagerNotifications converts an Alertmanager webhook to one notification per alert, or to a single
+// notification for the entire group, if grouped is true. Grouped messages are cut off after maxLength bytes.
+func newAlertmanagerNotifications(webhook *alertmanagerWebhook, grouped bool, maxLength int) []*alertmanagerNotification {
+	if grouped {
+		return []*alertmanagerNotification{newAlertmanagerGroupNotification(webhook, maxLength)}
+	}
+	notifications := make([]*alertmanagerNotification, 0, len(webhook.Alerts))
+	for _, alert := range webhook.Alerts {
+		notifications = append(notifications, newAlertmanagerAlertNotification(alert))
+	}
+	return notifications
+}
+
+func newAlertmanagerAlertNotification(alert *alertmanagerAlert) *alertmanagerNotification {
+	title := alert.Labels["alertname"]
+	if title == "" {
+		title = "Alert"
+	}
+	return &alertmanagerNotification{
+		Title:    title,
+		Message:  alertmanagerAlertMessage(alert),
+		Priority: alertmanagerPriority(alert),
+		Tags:     alertmanagerTags(alert.Status),
+		Click:    alert.GeneratorURL,
+	}
+}
+
+// newAlertmanagerGroupNotification creates a single notification for all alerts of the webhook. The title
+// follows Alertmanager's default format, e.g. "[FIRING:2] HighCPU", and the message lists all alerts.
+func newAlertmanagerGroupNotification(webhook *alertmanagerWebhook, maxLength int) *alertmanagerNotification {
+	firing, priority := 0, 0
+	for _, alert := range webhook.Alerts {
+		if alert.Status == alertmanagerStatusFiring {
+			firing++
+		}
+		if p := alertmanagerPriority(alert); p > priority {
+			priority = p
+		}
+	}
+	status := strings.ToUpper(webhook.Status)
+	if webhook.Status == alertmanagerStatusFiring {
+		status = fmt.Sprintf("%s:%d", status, firing)
+	}
+	title := fmt.Sprintf("[%s] %s", status, alertmanagerGroupName(webhook))
+	var message strings.Builder
+	for i, alert := range webhook.Alerts {
+		line := fmt.Sprintf("[%s] %s", strings.ToUpper(alert.Status), alertmanagerAlertSummary(alert))
+		if i > 0 {
+			line = "\n" + line
+		}
+		length := message.Len() + len(line)
+		if remaining := len(webhook.Alerts) - i - 1; remaining > 0 {
+			length += len(alertmanagerMoreAlerts(remaining)) // Leave room for the "more" line
+		}
+		if i > 0 && length > maxLength {
+			message.WriteString(alertmanagerMoreAlerts(len(webhook.Alerts) - i))
+			break
+		}
+		message.WriteString(line)
+	}
+	var click string
+	if len(webhook.Alerts) > 0 {
+		click = webhook.Alerts[0].GeneratorURL
+	}
+	return &alertmanagerNotification{
+		Title:    title,
+		Message:  message.String(),
+		Priority: priority,
+		Tags:     alertmanagerTags(webhook.Status),
+		Click:    click,
+	}
+}
+
+// apply sets the notification's title, priority, tags and click URL as headers on the given request, unless
+// they were already passed by the user as headers or query params. Tags are appended to the user's tags.
+func (n *alertmanagerNotification) apply(r *http.Request) {
+	if n.Title != "" && readParam(r, "x-title", "title", "t") == "" {
+		r.Header.Set("X-Title", n.Title)
+	}
+	if n.Priority > 0 && readParam(r, "x-priority", "priority", "prio", "p") == "" {
+		r.Header.Set("X-Priority", strconv.Itoa(n.Priority))
+	}
+	if n.Click != "" && readParam(r, "x-click", "click") == "" {
+		r.Header.Set("X-Click", n.Click)
+	}
+	tags := append(readCommaSeparatedParam(r, "x-tags", "tags", "tag", "ta"), n.Tags...)
+	r.Header.Set("X-Tags", strings.Join(tags, ","))
+}
+
+// alertmanagerAlertMessage returns the summary and description annotations of the alert, or its labels
+// if neither is set
+func alertmanagerAlertMessage(alert *alertmanagerAlert) string {
+	parts := make([]string, 0)
+	for _, annotation := range []string{"summary", "description"} {
+		if value := alert.Annotations[annotation]; value != "" {
+			parts = append(parts, value)
+		}
+	}
+	if len(parts) > 0 {
+		return strings.Join(parts, "\n\n")
+	}
+	for _, name := range sortedLabelNames(alert.Labels) {
+		if name != "alertname" {
+			parts = append(parts, fmt.Sprintf("%s=%s", name, alert.Labels[name]))
+		}
+	}
+	return strings.Join(parts, "\n")
+}
+
+// alertmanagerAlertSummary returns a one-line summary of the alert, used in grouped notifications
+func alertmanagerAlertSummary(alert *alertmanagerAlert) string {
+	for _, annotation := range []string{"summary", "description"} {
+		if value := alert.Annotations[annotation]; value != "" {
+			return strings.ReplaceAll(value, "\n", " ")
+		}
+	}
+	if alertname := alert.Labels["alertname"]; alertname != "" {
+		return alertname
+	}
+	return "Alert"
+}
+
+// alertmanagerGroupName returns the values of the group labels (sorted by label name), or the common
+// "alertname" label if the alerts are not grouped by any label
+func alertmanagerGroupName(webhook *alertmanagerWebhook) string {
+	values := make([]string, 0)
+	for _, name := range sortedLabelNames(webhook.GroupLabels) {
+		values = append(values, webhook.GroupLabels[name])
+	}
+	if len(values) == 0 && webhook.CommonLabels["alertname"] != "" {
+		return webhook.CommonLabels["alertname"]
+	} else if len(values) == 0 {
+		return "Alerts"
+	}
+	return strings.Join(values, " ")
+}
+
+// alertmanagerPriority maps the "severity" label of a firing alert to a message priority, see
+// alertmanagerSeverityPriorities. It returns 0 (default priority) for resolved alerts.
+func alertmanagerPriority(alert *alertmanagerAlert) int {
+	if alert.Status != alertmanagerStatusFiring {
+		return 0
+	}
+	return alertmanagerSeverityPriorities[strings.ToLower(alert.Labels["severity"])]
+}
+
+func alertmanagerMoreAlerts(count int) string {
+	return fmt.Sprintf("\n(%d more)", count)
+}
+
+func alertmanagerTags(status string) []string {
+	switch status {
+	case alertmanagerStatusFiring:
+		return []string{alertmanagerTagFiring, alertmanagerStatusFiring}
+	case alertmanagerStatusResolved:
+		return []string{alertmanagerTagResolved, alertmanagerStatusResolved}
+	}
+	return []string{}
+}
+
+func sortedLabelNames(labels map[string]string) []string {
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
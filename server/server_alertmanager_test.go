@@ -0,0 +1,151 @@
+package server
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"heckel.io/ntfy/v2/user"
+	"heckel.io/ntfy/v2/util"
+)
+
+const testAlertmanagerWebhook = `{
+  "version": "4",
+  "groupKey": "{}:{alertname=\"HighCPU\"}",
+  "truncatedAlerts": 0,
+  "status": "firing",
+  "receiver": "ntfy",
+  "groupLabels": {"alertname": "HighCPU"},
+  "commonLabels": {"alertname": "HighCPU"},
+  "commonAnnotations": {},
+  "externalURL": "https://alertmanager.example.com",
+  "alerts": [
+    {
+      "status": "firing",
+      "labels": {"alertname": "HighCPU", "instance": "db1:9100", "severity": "critical"},
+      "annotations": {"summary": "CPU usage above 90% on db1", "description": "CPU usage is 97%"},
+      "startsAt": "2024-01-01T10:00:00Z",
+      "endsAt": "0001-01-01T00:00:00Z",
+      "generatorURL": "https://prometheus.example.com/graph?g0.expr=cpu",
+      "fingerprint": "c0ffee"
+    },
+    {
+      "status": "resolved",
+      "labels": {"alertname": "HighCPU", "instance": "web1:9100", "severity": "warning"},
+      "annotations": {},
+      "startsAt": "2024-01-01T09:00:00Z",
+      "endsAt": "2024-01-01T09:30:00Z",
+      "generatorURL": "https://prometheus.example.com/graph?g0.expr=cpu2",
+      "fingerprint": "decaf"
+    }
+  ]
+}`
+
+func TestAlertmanager_PublishPerAlert(t *testing.T) {
+	s := newTestServer(t, newTestConfig(t))
+
+	response := request(t, s, "POST", "/alerts/alertmanager", testAlertmanagerWebhook, nil)
+	require.Equal(t, 200, response.Code)
+	require.Len(t, toMessages(t, response.Body.String()), 2)
+
+	response = request(t, s, "GET", "/alerts/json?poll=1", "", nil)
+	messages := toMessages(t, response.Body.String())
+	require.Len(t, messages, 2)
+
+	require.Equal(t, "HighCPU", messages[0].Title)
+	require.Equal(t, "CPU usage above 90% on db1\n\nCPU usage is 97%", messages[0].Message)
+	require.Equal(t, 5, messages[0].Priority)
+	require.Equal(t, []string{"rotating_light", "firing"}, messages[0].Tags)
+	require.Equal(t, "https://prometheus.example.com/graph?g0.expr=cpu", messages[0].Click)
+
+	require.Equal(t, "HighCPU", messages[1].Title)
+	require.Equal(t, "instance=web1:9100\nseverity=warning", messages[1].Message)
+	require.Equal(t, 0, messages[1].Priority) // Resolved alerts have default priority
+	require.Equal(t, []string{"white_check_mark", "resolved"}, messages[1].Tags)
+	require.Equal(t, "https://prometheus.example.com/graph?g0.expr=cpu2", messages[1].Click)
+}
+
+func TestAlertmanager_PublishGrouped(t *testing.T) {
+	s := newTestServer(t, newTestConfig(t))
+
+	response := request(t, s, "POST", "/alerts/alertmanager?grouped=1&tags=prod&title=Prometheus", testAlertmanagerWebhook, nil)
+	require.Equal(t, 200, response.Code)
+	m := toMessage(t, response.Body.String())
+	require.Equal(t, "Prometheus", m.Title) // User-defined title takes precedence
+	require.Equal(t, "[FIRING] CPU usage above 90% on db1\n[RESOLVED] HighCPU", m.Message)
+	require.Equal(t, 5, m.Priority)
+	require.Equal(t, []string{"prod", "rotating_light", "firing"}, m.Tags)
+	require.Equal(t, "https://prometheus.example.com/graph?g0.expr=cpu", m.Click)
+
+	response = request(t, s, "POST", "/alerts/alertmanager", testAlertmanagerWebhook, map[string]string{
+		"X-Grouped":  "yes",
+		"X-Priority": "2",
+	})
+	require.Equal(t, 200, response.Code)
+	m = toMessage(t, response.Body.String())
+	require.Equal(t, "[FIRING:1] HighCPU", m.Title)
+	require.Equal(t, 2, m.Priority)
+}
+
+func TestAlertmanager_GroupedMessageTooLong(t *testing.T) {
+	alerts := make([]*alertmanagerAlert, 0)
+	for i := 0; i < 100; i++ {
+		alerts = append(alerts, &alertmanagerAlert{
+			Status:      "firing",
+			Annotations: map[string]string{"summary": strings.Repeat("x", 90)},
+		})
+	}
+	n := newAlertmanagerGroupNotification(&alertmanagerWebhook{Status: "firing", Alerts: alerts}, 1000)
+	require.Equal(t, "[FIRING:100] Alerts", n.Title)
+	require.LessOrEqual(t, len(n.Message), 1000)
+	require.True(t, strings.HasSuffix(n.Message, "\n(91 more)"))
+}
+
+func TestAlertmanager_PublishInvalid(t *testing.T) {
+	s := newTestServer(t, newTestConfig(t))
+
+	response := request(t, s, "POST", "/alerts/alertmanager", "not json", nil)
+	require.Equal(t, 400, response.Code)
+	require.Equal(t, 40059, toHTTPError(t, response.Body.String()).Code)
+
+	response = request(t, s, "POST", "/alerts/alertmanager", `{"version":"4","status":"firing","alerts":[]}`, nil)
+	require.Equal(t, 400, response.Code)
+	require.Equal(t, 40059, toHTTPError(t, response.Body.String()).Code)
+}
+
+func TestAlertmanager_PublishMessageLimit(t *testing.T) {
+	c := newTestConfig(t)
+	c.VisitorMessageDailyLimit = 3
+	s := newTestServer(t, c)
+
+	response := request(t, s, "POST", "/alerts/alertmanager", testAlertmanagerWebhook, nil)
+	require.Equal(t, 200, response.Code)
+
+	// The second webhook exceeds the limit, so none of its alerts are published
+	response = request(t, s, "POST", "/alerts/alertmanager", testAlertmanagerWebhook, nil)
+	require.Equal(t, 429, response.Code)
+	require.Equal(t, 42908, toHTTPError(t, response.Body.String()).Code)
+
+	response = request(t, s, "GET", "/alerts/json?poll=1", "", nil)
+	require.Len(t, toMessages(t, response.Body.String()), 2)
+
+	// A grouped webhook is a single message, so it still fits
+	response = request(t, s, "POST", "/alerts/alertmanager?grouped=1", testAlertmanagerWebhook, nil)
+	require.Equal(t, 200, response.Code)
+}
+
+func TestAlertmanager_PublishAccessControl(t *testing.T) {
+	c := newTestConfigWithAuthFile(t)
+	c.AuthDefault = user.PermissionDenyAll
+	s := newTestServer(t, c)
+	require.Nil(t, s.userManager.AddUser("phil", "phil", user.RoleUser))
+	require.Nil(t, s.userManager.AllowAccess("phil", "alerts", user.PermissionWrite))
+
+	response := request(t, s, "POST", "/alerts/alertmanager", testAlertmanagerWebhook, nil)
+	require.Equal(t, 403, response.Code)
+
+	response = request(t, s, "POST", "/alerts/alertmanager", testAlertmanagerWebhook, map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 200, response.Code)
+}
@@ -0,0 +1,118 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"heckel.io/ntfy/v2/user"
+	"heckel.io/ntfy/v2/util"
+)
+
+const (
+	auditEventsLimitDefault = 100
+	auditEventsLimitMax     = 1000
+)
+
+// userManagerAs returns a view of the user manager that writes audit events for all changes on behalf of the
+// visitor, in the same transaction as the change itself. The reason is optional and is added to the event details.
+func (s *Server) userManagerAs(v *visitor, reason string) *user.Manager {
+	actor := &user.Actor{
+		Origin: v.IP().String(),
+		Reason: reason,
+	}
+	if u := v.User(); u != nil {
+		actor.Name = u.Name
+	}
+	return s.userManager.WithActor(actor)
+}
+
+// audit appends an event to the audit log for actions that do not change the user database, e.g. failed logins or
+// signed URLs; all other changes are audited by the user manager, see userManagerAs. The actor is the user of the
+// visitor (if any), and the origin its IP address. Failing to write the audit log does not fail the request.
+func (s *Server) audit(r *http.Request, v *visitor, action user.AuditAction, target, details string) {
+	if s.userManager == nil {
+		return
+	}
+	actor := ""
+	if u := v.User(); u != nil {
+		actor = u.Name
+	}
+	event := &user.AuditEvent{
+		Actor:   actor,
+		Origin:  v.IP().String(),
+		Action:  action,
+		Target:  target,
+		Details: details,
+	}
+	if err := s.userManager.AddAuditEvent(event); err != nil {
+		logvr(v, r).Err(err).Warn("Unable to write audit log event %s", action)
+	}
+}
+
+// auditLoginFailed records a failed authentication attempt via the Authorization header, including the
+// attempted username for basic auth, and whether the user is locked. Tokens are never written to the audit log.
+func (s *Server) auditLoginFailed(r *http.Request, v *visitor, header string, err error) {
+	if username, _, ok := r.BasicAuth(); ok && username != "" && !strings.HasPrefix(header, "Bearer") {
+		details := "password"
+		if errors.Is(err, user.ErrUserLocked) {
+			details = "password, user locked"
+		}
+		s.audit(r, v, user.AuditActionLoginFailed, username, details)
+		return
+	}
+	s.audit(r, v, user.AuditActionLoginFailed, "", "token")
+}
+
+func (s *Server) handleAuditGet(w http.ResponseWriter, r *http.Request, v *visitor) error {
+	filter, err := parseAuditFilter(r, time.Now())
+	if err != nil {
+		return err
+	}
+	events, err := s.userManager.AuditEvents(filter)
+	if err != nil {
+		return err
+	}
+	response := make([]*apiAuditEventResponse, len(events))
+	for i, e := range events {
+		response[i] = &apiAuditEventResponse{
+			ID:      e.ID,
+			Time:    e.Time.Unix(),
+			Actor:   e.Actor,
+			Origin:  e.Origin,
+			Action:  string(e.Action),
+			Target:  e.Target,
+			Details: e.Details,
+		}
+	}
+	return s.writeJSON(w, response)
+}
+
+// parseAuditFilter reads the query parameters user, action, since, until and limit. The since and until parameters
+// may be unix timestamps or durations (e.g. "24h" or "7d"), which are relative to now.
+func parseAuditFilter(r *http.Request, now time.Time) (*user.AuditFilter, error) {
+	filter := &user.AuditFilter{
+		User:   readQueryParam(r, "user"),
+		Action: user.AuditAction(readQueryParam(r, "action")),
+		Limit:  auditEventsLimitDefault,
+	}
+	var err error
+	if since := readQueryParam(r, "since"); since != "" {
+		if filter.Since, err = util.ParsePastTime(since, now); err != nil {
+			return nil, errHTTPBadRequestAuditFilterInvalid.Wrap("invalid since parameter")
+		}
+	}
+	if until := readQueryParam(r, "until"); until != "" {
+		if filter.Until, err = util.ParsePastTime(until, now); err != nil {
+			return nil, errHTTPBadRequestAuditFilterInvalid.Wrap("invalid until parameter")
+		}
+	}
+	if limit := readQueryParam(r, "limit"); limit != "" {
+		if filter.Limit, err = strconv.Atoi(limit); err != nil || filter.Limit <= 0 || filter.Limit > auditEventsLimitMax {
+			return nil, errHTTPBadRequestAuditFilterInvalid.Wrap("limit must be between 1 and %d", auditEventsLimitMax)
+		}
+	}
+	return filter, nil
+}
@@ -0,0 +1,167 @@
+package server
+
+import (
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"heckel.io/ntfy/v2/user"
+	"heckel.io/ntfy/v2/util"
+)
+
+func TestServer_Audit(t *testing.T) {
+	s := newTestServer(t, newTestConfigWithAuthFile(t))
+	defer s.closeDatabases()
+	require.Nil(t, s.userManager.AddUser("phil", "phil", user.RoleAdmin))
+
+	// Some security-relevant actions
+	rr := request(t, s, "PUT", "/v1/users", `{"username": "ben", "password":"ben"}`, map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 200, rr.Code)
+	rr = request(t, s, "PUT", "/v1/users/access", `{"username": "ben", "topic":"gold", "permission":"ro"}`, map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 200, rr.Code)
+	rr = request(t, s, "POST", "/v1/account/token", `{"label":"laptop"}`, map[string]string{
+		"Authorization": util.BasicAuth("ben", "ben"),
+	})
+	require.Equal(t, 200, rr.Code)
+	token, err := util.UnmarshalJSON[apiAccountTokenResponse](io.NopCloser(rr.Body))
+	require.Nil(t, err)
+	rr = request(t, s, "GET", "/gold/json?poll=1", "", map[string]string{
+		"Authorization": util.BasicAuth("ben", "wrong"),
+	})
+	require.Equal(t, 401, rr.Code)
+	rr = request(t, s, "GET", "/gold/json?poll=1", "", map[string]string{
+		"Authorization": util.BearerAuth("tk_invalid"),
+	})
+	require.Equal(t, 401, rr.Code)
+
+	// Only admins can read the audit log
+	rr = request(t, s, "GET", "/v1/audit", "", map[string]string{
+		"Authorization": util.BearerAuth(token.Token),
+	})
+	require.Equal(t, 401, rr.Code)
+
+	rr = request(t, s, "GET", "/v1/audit", "", map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 200, rr.Code)
+	events, err := util.UnmarshalJSON[[]*apiAuditEventResponse](io.NopCloser(rr.Body))
+	require.Nil(t, err)
+	require.Len(t, *events, 6)
+	require.Equal(t, "login.failed", (*events)[0].Action)
+	require.Equal(t, "", (*events)[0].Actor)
+	require.Equal(t, "", (*events)[0].Target)
+	require.Equal(t, "token", (*events)[0].Details)
+	require.Equal(t, "login.failed", (*events)[1].Action)
+	require.Equal(t, "ben", (*events)[1].Target)
+	require.Equal(t, "password", (*events)[1].Details)
+	require.Equal(t, "9.9.9.9", (*events)[1].Origin)
+	require.Equal(t, "token.create", (*events)[2].Action)
+	require.Equal(t, "ben", (*events)[2].Actor)
+	require.Equal(t, `label="laptop" scopes=0`, (*events)[2].Details)
+	require.NotContains(t, (*events)[2].Details, token.Token)
+	require.Equal(t, "access.allow", (*events)[3].Action)
+	require.Equal(t, "phil", (*events)[3].Actor)
+	require.Equal(t, "ben", (*events)[3].Target)
+	require.Equal(t, "topic=gold permission=read-only", (*events)[3].Details)
+	require.Equal(t, "user.add", (*events)[4].Action)
+	require.Equal(t, "phil", (*events)[4].Actor)
+	require.Equal(t, "ben", (*events)[4].Target)
+	require.Equal(t, "user.add", (*events)[5].Action)
+	require.Equal(t, "", (*events)[5].Actor)
+	require.Equal(t, "phil", (*events)[5].Target)
+
+	// Filters
+	rr = request(t, s, "GET", "/v1/audit?user=ben&action=login.failed", "", map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 200, rr.Code)
+	events, err = util.UnmarshalJSON[[]*apiAuditEventResponse](io.NopCloser(rr.Body))
+	require.Nil(t, err)
+	require.Len(t, *events, 1)
+
+	rr = request(t, s, "GET", "/v1/audit?since=1h&limit=2", "", map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 200, rr.Code)
+	events, err = util.UnmarshalJSON[[]*apiAuditEventResponse](io.NopCloser(rr.Body))
+	require.Nil(t, err)
+	require.Len(t, *events, 2)
+
+	rr = request(t, s, "GET", "/v1/audit?until=1h", "", map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 200, rr.Code)
+	events, err = util.UnmarshalJSON[[]*apiAuditEventResponse](io.NopCloser(rr.Body))
+	require.Nil(t, err)
+	require.Len(t, *events, 0)
+
+	rr = request(t, s, "GET", "/v1/audit?limit=5000", "", map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 400, rr.Code)
+	require.Equal(t, 40056, toHTTPError(t, rr.Body.String()).Code)
+	rr = request(t, s, "GET", "/v1/audit?since=yesterday", "", map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 400, rr.Code)
+	require.Equal(t, 40056, toHTTPError(t, rr.Body.String()).Code)
+}
+
+func TestServer_Audit_AccountChanges(t *testing.T) {
+	conf := newTestConfigWithAuthFile(t)
+	conf.EnableSignup = true
+	s := newTestServer(t, conf)
+	defer s.closeDatabases()
+
+	rr := request(t, s, "POST", "/v1/account", `{"username":"phil", "password":"mypass"}`, nil)
+	require.Equal(t, 200, rr.Code)
+	rr = request(t, s, "POST", "/v1/account/password", `{"password": "mypass", "new_password": "changed"}`, map[string]string{
+		"Authorization": util.BasicAuth("phil", "mypass"),
+	})
+	require.Equal(t, 200, rr.Code)
+
+	events, err := s.userManager.AuditEvents(&user.AuditFilter{User: "phil"})
+	require.Nil(t, err)
+	require.Len(t, events, 2)
+	require.Equal(t, user.AuditActionUserChangePassword, events[0].Action)
+	require.Equal(t, "phil", events[0].Actor)
+	require.Equal(t, user.AuditActionUserAdd, events[1].Action)
+	require.Equal(t, "", events[1].Actor)
+	require.Equal(t, "phil", events[1].Target)
+}
+
+func TestServer_Audit_AdminChanges(t *testing.T) {
+	s := newTestServer(t, newTestConfigWithAuthFile(t))
+	defer s.closeDatabases()
+	require.Nil(t, s.userManager.AddUser("phil", "phil", user.RoleAdmin))
+	require.Nil(t, s.userManager.AddUser("ben", "ben", user.RoleUser))
+	admin := map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	}
+
+	rr := request(t, s, "PUT", "/v1/groups", `{"name": "ops"}`, admin)
+	require.Equal(t, 200, rr.Code)
+	rr = request(t, s, "PUT", "/v1/groups/members", `{"group": "ops", "username": "ben"}`, admin)
+	require.Equal(t, 200, rr.Code)
+	rr = request(t, s, "PUT", "/v1/topics/retention", `{"topic": "alerts", "max_age": 3600, "max_messages": 10}`, admin)
+	require.Equal(t, 200, rr.Code)
+
+	events, err := s.userManager.AuditEvents(&user.AuditFilter{User: "phil"})
+	require.Nil(t, err)
+	require.Len(t, events, 4)
+	require.Equal(t, user.AuditActionTopicRetentionChange, events[0].Action)
+	require.Equal(t, "phil", events[0].Actor)
+	require.Equal(t, "9.9.9.9", events[0].Origin)
+	require.Equal(t, "topic=alerts max_age=1h0m0s max_messages=10", events[0].Details)
+	require.Equal(t, user.AuditActionGroupMemberAdd, events[1].Action)
+	require.Equal(t, "ben", events[1].Target)
+	require.Equal(t, "group=ops", events[1].Details)
+	require.Equal(t, user.AuditActionGroupAdd, events[2].Action)
+	require.Equal(t, "group=ops", events[2].Details)
+	require.Equal(t, user.AuditActionUserAdd, events[3].Action)
+	require.Equal(t, "phil", events[3].Target)
+}
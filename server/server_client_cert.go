@@ -0,0 +1,88 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+
+	"heckel.io/ntfy/v2/user"
+)
+
+// Sources of the username in a client certificate, see clientCertUsername
+const (
+	ClientCertUsernameCommonName = "cn"    // Subject common name (CN)
+	ClientCertUsernameEmail      = "email" // First e-mail address in the subject alternative names (SAN)
+	ClientCertUsernameDNS        = "dns"   // First DNS name in the subject alternative names (SAN)
+)
+
+var (
+	errClientCertUsernameMissing = errors.New("client certificate does not contain a valid username")
+	errClientCertUserDeleted     = errors.New("user of client certificate is marked deleted")
+)
+
+// newClientCAPool reads the PEM-encoded CA certificates that client certificates are verified against
+func newClientCAPool(filename string) (*x509.CertPool, error) {
+	b, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(b) {
+		return nil, fmt.Errorf("no valid PEM-encoded certificates found in %s", filename)
+	}
+	return pool, nil
+}
+
+// tlsConfig returns the TLS config for the HTTPS listener. If client CAs are configured, clients may (but don't have to)
+// present a certificate signed by one of them. Clients without a certificate can still use passwords and tokens.
+func (s *Server) tlsConfig() *tls.Config {
+	if s.clientCAs == nil {
+		return nil
+	}
+	return &tls.Config{
+		ClientAuth: tls.VerifyClientCertIfGiven,
+		ClientCAs:  s.clientCAs,
+	}
+}
+
+// hasVerifiedClientCert returns true if the client presented a certificate, and if it was verified
+// against the configured client CAs during the TLS handshake
+func (s *Server) hasVerifiedClientCert(r *http.Request) bool {
+	return s.clientCAs != nil && r.TLS != nil && len(r.TLS.VerifiedChains) > 0 && len(r.TLS.VerifiedChains[0]) > 0
+}
+
+// authenticateClientCert maps the verified client certificate of the request to an existing user, based on
+// the subject or subject alternative names of the certificate (see Config.ClientCertUsername)
+func (s *Server) authenticateClientCert(r *http.Request) (*user.User, error) {
+	cert := r.TLS.VerifiedChains[0][0]
+	username := clientCertUsername(cert, s.config.ClientCertUsername)
+	if !user.AllowedUsername(username) {
+		return nil, errClientCertUsernameMissing
+	}
+	u, err := s.userManager.User(username)
+	if err != nil {
+		return nil, err
+	} else if u.Deleted {
+		return nil, errClientCertUserDeleted
+	}
+	return u, nil
+}
+
+func clientCertUsername(cert *x509.Certificate, source string) string {
+	switch source {
+	case ClientCertUsernameEmail:
+		if len(cert.EmailAddresses) > 0 {
+			return cert.EmailAddresses[0]
+		}
+	case ClientCertUsernameDNS:
+		if len(cert.DNSNames) > 0 {
+			return cert.DNSNames[0]
+		}
+	default:
+		return cert.Subject.CommonName
+	}
+	return ""
+}
@@ -0,0 +1,169 @@
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"heckel.io/ntfy/v2/user"
+	"heckel.io/ntfy/v2/util"
+)
+
+func TestServer_ClientCert_PublishAndSubscribe(t *testing.T) {
+	ca, caKey := newTestCA(t)
+	conf := newTestConfigWithAuthFile(t)
+	conf.AuthDefault = user.PermissionDenyAll
+	conf.ClientCAFile = writeTestCA(t, ca)
+	s := newTestServer(t, conf)
+	defer s.closeDatabases()
+	require.Nil(t, s.userManager.AddUser("sensor-1", "", user.RoleUser))
+	require.Nil(t, s.userManager.AllowAccess("sensor-1", "sensors", user.PermissionReadWrite))
+
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(s.handle))
+	ts.TLS = s.tlsConfig()
+	ts.StartTLS()
+	defer ts.Close()
+
+	// Valid client certificate
+	client := newTestTLSClient(t, ts, newTestClientCert(t, ca, caKey, "sensor-1", nil))
+	resp, err := client.Post(ts.URL+"/sensors", "text/plain", strings.NewReader("temperature 21.5"))
+	require.Nil(t, err)
+	require.Equal(t, 200, resp.StatusCode)
+	resp, err = client.Get(ts.URL + "/sensors/json?poll=1")
+	require.Nil(t, err)
+	require.Equal(t, 200, resp.StatusCode)
+	body, _ := io.ReadAll(resp.Body)
+	require.Equal(t, "temperature 21.5", toMessage(t, string(body)).Message)
+	resp, err = client.Post(ts.URL+"/other", "text/plain", strings.NewReader("nope"))
+	require.Nil(t, err)
+	require.Equal(t, 403, resp.StatusCode)
+
+	// Unknown user
+	client = newTestTLSClient(t, ts, newTestClientCert(t, ca, caKey, "sensor-2", nil))
+	resp, err = client.Post(ts.URL+"/sensors", "text/plain", strings.NewReader("hi"))
+	require.Nil(t, err)
+	require.Equal(t, 401, resp.StatusCode)
+
+	// No client certificate: anonymous, as usual
+	client = newTestTLSClient(t, ts, nil)
+	resp, err = client.Post(ts.URL+"/sensors", "text/plain", strings.NewReader("hi"))
+	require.Nil(t, err)
+	require.Equal(t, 403, resp.StatusCode)
+
+	// Certificate from another CA is rejected during the handshake
+	otherCA, otherCAKey := newTestCA(t)
+	client = newTestTLSClient(t, ts, newTestClientCert(t, otherCA, otherCAKey, "sensor-1", nil))
+	_, err = client.Post(ts.URL+"/sensors", "text/plain", strings.NewReader("hi"))
+	require.NotNil(t, err)
+}
+
+func TestServer_ClientCert_UsernameSources(t *testing.T) {
+	ca, caKey := newTestCA(t)
+	conf := newTestConfigWithAuthFile(t)
+	conf.AuthDefault = user.PermissionDenyAll
+	conf.ClientCAFile = writeTestCA(t, ca)
+	conf.ClientCertUsername = ClientCertUsernameEmail
+	s := newTestServer(t, conf)
+	defer s.closeDatabases()
+	require.Nil(t, s.userManager.AddUser("phil@example.com", "phil", user.RoleUser))
+	require.Nil(t, s.userManager.AddUser("ben", "ben", user.RoleUser))
+	require.Nil(t, s.userManager.AllowAccess("phil@example.com", "mytopic", user.PermissionReadWrite))
+
+	cert := newTestClientCert(t, ca, caKey, "ignored", func(c *x509.Certificate) {
+		c.EmailAddresses = []string{"phil@example.com"}
+	})
+	withCert := func(r *http.Request) {
+		r.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{cert.Leaf}}}
+	}
+	rr := request(t, s, "PUT", "/mytopic", "hi", nil, withCert)
+	require.Equal(t, 200, rr.Code)
+
+	// Authorization header takes precedence
+	rr = request(t, s, "PUT", "/mytopic", "hi", map[string]string{
+		"Authorization": util.BasicAuth("ben", "ben"),
+	}, withCert)
+	require.Equal(t, 403, rr.Code)
+
+	// Certificate without e-mail address
+	cert = newTestClientCert(t, ca, caKey, "phil@example.com", nil)
+	rr = request(t, s, "PUT", "/mytopic", "hi", nil, withCert)
+	require.Equal(t, 401, rr.Code)
+}
+
+func TestServer_ClientCert_InvalidCAFile(t *testing.T) {
+	conf := newTestConfigWithAuthFile(t)
+	conf.ClientCAFile = filepath.Join(t.TempDir(), "ca.pem")
+	require.Nil(t, os.WriteFile(conf.ClientCAFile, []byte("not a certificate"), 0600))
+	_, err := New(conf)
+	require.NotNil(t, err)
+}
+
+func newTestCA(t *testing.T) (*x509.Certificate, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.Nil(t, err)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "ntfy test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.Nil(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.Nil(t, err)
+	return cert, key
+}
+
+func newTestClientCert(t *testing.T, ca *x509.Certificate, caKey *ecdsa.PrivateKey, commonName string, fn func(c *x509.Certificate)) *tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.Nil(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	if fn != nil {
+		fn(template)
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca, &key.PublicKey, caKey)
+	require.Nil(t, err)
+	leaf, err := x509.ParseCertificate(der)
+	require.Nil(t, err)
+	return &tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}
+}
+
+func newTestTLSClient(t *testing.T, ts *httptest.Server, cert *tls.Certificate) *http.Client {
+	rootCAs := x509.NewCertPool()
+	rootCAs.AddCert(ts.Certificate())
+	tlsConfig := &tls.Config{RootCAs: rootCAs}
+	if cert != nil {
+		tlsConfig.Certificates = []tls.Certificate{*cert}
+	}
+	return &http.Client{Transport: &http.Transport{TLSClientConfig: tlsConfig}}
+}
+
+func writeTestCA(t *testing.T, ca *x509.Certificate) string {
+	filename := filepath.Join(t.TempDir(), "ca.pem")
+	require.Nil(t, os.WriteFile(filename, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.Raw}), 0600))
+	return filename
+}
@@ -0,0 +1,184 @@
+package server
+
+import (
+	"errors"
+	"time"
+
+	"heckel.io/ntfy/v2/log"
+	"heckel.io/ntfy/v2/user"
+	"heckel.io/ntfy/v2/util"
+)
+
+// Escalation steps, in the order in which they are performed. Steps that are not set in the topic's escalation
+// policy are skipped.
+const (
+	escalationStepForward = iota // Re-publish the message to another topic
+	escalationStepEmail          // Send the message as e-mail
+	escalationStepCall           // Call a phone number
+	escalationStepDone
+)
+
+const (
+	escalationPriority = 5           // Only messages with this priority are escalated
+	escalationDelayMin = time.Minute // Minimum delay between escalation steps
+)
+
+// maybeStartEscalation starts the escalation of a message, if it has the highest priority and its topic has an
+// escalation policy. The first step is due after the policy's delay, see sendEscalations.
+func (s *Server) maybeStartEscalation(m *message) {
+	if s.userManager == nil || m.Event != messageEvent || m.Priority != escalationPriority {
+		return
+	}
+	policy, err := s.userManager.TopicEscalation(m.Topic)
+	if errors.Is(err, user.ErrTopicEscalationNotFound) {
+		return
+	} else if err != nil {
+		log.Tag(tagEscalation).With(m).Err(err).Warn("Unable to read escalation policy")
+		return
+	}
+	e := &escalation{
+		MessageID: m.ID,
+		Topic:     m.Topic,
+		Step:      escalationStepForward,
+		Next:      time.Unix(m.Time, 0).Add(policy.Delay).Unix(),
+	}
+	log.Tag(tagEscalation).With(m).Debug("Starting escalation, first step due in %s", policy.Delay)
+	if err := s.messageCache.AddEscalation(e); err != nil {
+		log.Tag(tagEscalation).With(m).Err(err).Warn("Unable to start escalation")
+	}
+}
+
+// sendEscalations performs the next step of all escalations that are due. It is called by the delayed sender.
+func (s *Server) sendEscalations() error {
+	if s.userManager == nil {
+		return nil
+	}
+	escalations, err := s.messageCache.EscalationsDue()
+	if err != nil {
+		return err
+	}
+	for _, e := range escalations {
+		if err := s.sendEscalation(e); err != nil {
+			log.Tag(tagEscalation).Field("message_id", e.MessageID).Err(err).Warn("Error escalating message")
+		}
+	}
+	return nil
+}
+
+// sendEscalation performs the next step of an escalation, and schedules the step after it. Steps are performed on
+// behalf of the topic owner, so the e-mails and calls count against the owner's limits. The escalation is stopped if
+// the message, the escalation policy or the topic reservation no longer exist, or if there are no steps left.
+func (s *Server) sendEscalation(e *escalation) error {
+	m, err := s.messageCache.Message(e.MessageID)
+	if errors.Is(err, errMessageNotFound) {
+		return s.messageCache.DeleteEscalation(e.MessageID)
+	} else if err != nil {
+		return err
+	}
+	policy, err := s.userManager.TopicEscalation(e.Topic)
+	if errors.Is(err, user.ErrTopicEscalationNotFound) {
+		return s.messageCache.DeleteEscalation(e.MessageID)
+	} else if err != nil {
+		return err
+	}
+	ownerID, err := s.userManager.ReservationOwner(e.Topic)
+	if err != nil {
+		return err
+	} else if ownerID == "" {
+		return s.messageCache.DeleteEscalation(e.MessageID)
+	}
+	owner, err := s.userManager.UserByID(ownerID)
+	if err != nil {
+		return err
+	}
+	v := s.visitor(m.Sender, owner)
+	step := nextEscalationStep(policy, e.Step)
+	switch step {
+	case escalationStepForward:
+		s.escalateToTopic(v, m, policy.ForwardTopic)
+	case escalationStepEmail:
+		s.escalateToEmail(v, m, policy.Email)
+	case escalationStepCall:
+		s.escalateToPhone(v, m, policy.PhoneNumber)
+	}
+	if owner.Tier != nil {
+		go s.userManager.EnqueueUserStats(owner.ID, v.Stats())
+	}
+	if step == escalationStepDone || nextEscalationStep(policy, step+1) == escalationStepDone {
+		logvm(v, m).Tag(tagEscalation).Debug("No escalation steps left, stopping escalation")
+		return s.messageCache.DeleteEscalation(e.MessageID)
+	}
+	return s.messageCache.UpdateEscalation(e.MessageID, step+1, time.Now().Add(policy.Delay).Unix())
+}
+
+// escalateToTopic re-publishes a copy of the message to the given topic. Action buttons are copied as well, so that
+// acknowledging the copy acknowledges the original message.
+func (s *Server) escalateToTopic(v *visitor, m *message, topic string) {
+	if err := s.userManager.Authorize(v.User(), topic, user.PermissionWrite); err != nil {
+		logvm(v, m).Tag(tagEscalation).Err(err).Info("Not re-publishing message to topic %s, topic owner cannot write to it", topic)
+		return
+	} else if !util.ContainsIP(s.config.VisitorRequestExemptIPAddrs, v.ip) && !v.MessageAllowed() {
+		logvm(v, m).Tag(tagEscalation).Info("Not re-publishing message to topic %s, message limit reached", topic)
+		return
+	}
+	now := time.Now()
+	fm := *m
+	fm.ID = util.RandomString(messageIDLength)
+	fm.Time = now.Unix()
+	fm.Expires = now.Add(v.Limits().MessageExpiryDuration).Unix()
+	fm.Topic = topic
+	fm.Acks = nil
+	logvm(v, &fm).Tag(tagEscalation).Debug("Escalating message %s, re-publishing to topic %s", m.ID, topic)
+	if err := s.messageCache.AddMessage(&fm); err != nil {
+		logvm(v, &fm).Tag(tagEscalation).Err(err).Warn("Unable to re-publish message")
+		return
+	}
+	s.deliverMessage(v, &fm)
+}
+
+// escalateToEmail sends the message as e-mail to the given address
+func (s *Server) escalateToEmail(v *visitor, m *message, email string) {
+	if s.smtpSender == nil {
+		logvm(v, m).Tag(tagEscalation).Info("Not sending e-mail to %s, e-mail notifications are not enabled", email)
+		return
+	} else if !v.EmailAllowed() {
+		logvm(v, m).Tag(tagEscalation).Info("Not sending e-mail to %s, e-mail limit reached", email)
+		return
+	}
+	logvm(v, m).Tag(tagEscalation).Debug("Escalating message, sending e-mail to %s", email)
+	go s.sendEmail(v, m, email)
+}
+
+// escalateToPhone calls the given phone number, which must be a verified phone number of the topic owner
+func (s *Server) escalateToPhone(v *visitor, m *message, phoneNumber string) {
+	if s.config.TwilioAccount == "" {
+		logvm(v, m).Tag(tagEscalation).Info("Not calling %s, calling is not enabled", phoneNumber)
+		return
+	}
+	to, err := s.convertPhoneNumber(v.User(), phoneNumber)
+	if err != nil {
+		logvm(v, m).Tag(tagEscalation).Err(err).Info("Not calling %s, phone number not verified", phoneNumber)
+		return
+	} else if !v.CallAllowed() {
+		logvm(v, m).Tag(tagEscalation).Info("Not calling %s, call limit reached", phoneNumber)
+		return
+	}
+	logvm(v, m).Tag(tagEscalation).Debug("Escalating message, calling %s", to)
+	go s.callPhone(v, m, to)
+}
+
+// nextEscalationStep returns the first step starting at the given step that is set in the escalation policy,
+// or escalationStepDone if there is none
+func nextEscalationStep(policy *user.TopicEscalation, step int) int {
+	for ; step < escalationStepDone; step++ {
+		switch {
+		case step == escalationStepForward && policy.ForwardTopic != "":
+			return step
+		case step == escalationStepEmail && policy.Email != "":
+			return step
+		case step == escalationStepCall && policy.PhoneNumber != "":
+			return step
+		}
+	}
+	return escalationStepDone
+}
@@ -0,0 +1,115 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"heckel.io/ntfy/v2/user"
+	"heckel.io/ntfy/v2/util"
+)
+
+func TestServer_Escalation(t *testing.T) {
+	conf := newTestConfigWithAuthFile(t)
+	conf.EnableReservations = true
+	s := newTestServer(t, conf)
+	mailer := &testMailer{}
+	s.smtpSender = mailer
+
+	// Create user with tier, and reserve topic with escalation policy
+	require.Nil(t, s.userManager.AddTier(&user.Tier{
+		Code:             "pro",
+		MessageLimit:     20,
+		EmailLimit:       5,
+		ReservationLimit: 2,
+	}))
+	require.Nil(t, s.userManager.AddUser("phil", "phil", user.RoleUser))
+	require.Nil(t, s.userManager.ChangeTier("phil", "pro"))
+	response := request(t, s, "POST", "/v1/account/reservation", `{"topic": "alerts", "everyone":"read-only", "escalation": {"delay": 600, "forward_topic": "oncall", "email": "phil@example.com"}}`, map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 200, response.Code)
+
+	// Only messages with the highest priority are escalated
+	response = request(t, s, "PUT", "/alerts", "disk is almost full", map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+		"Priority":      "4",
+	})
+	require.Equal(t, 200, response.Code)
+	response = request(t, s, "PUT", "/alerts", "disk is full", map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+		"Priority":      "5",
+	})
+	require.Equal(t, 200, response.Code)
+	m := toMessage(t, response.Body.String())
+
+	// Nothing happens before the escalation is due
+	require.Nil(t, s.sendEscalations())
+	response = request(t, s, "GET", "/oncall/json?poll=1", "", nil)
+	require.Equal(t, 0, len(toMessages(t, response.Body.String())))
+
+	// First step: Message is re-published to the forward topic
+	_, err := s.messageCache.db.Exec(`UPDATE escalations SET next = ?`, time.Now().Add(-time.Second).Unix())
+	require.Nil(t, err)
+	require.Nil(t, s.sendEscalations())
+	response = request(t, s, "GET", "/oncall/json?poll=1", "", nil)
+	messages := toMessages(t, response.Body.String())
+	require.Equal(t, 1, len(messages))
+	require.Equal(t, "disk is full", messages[0].Message)
+	require.Equal(t, 5, messages[0].Priority)
+	require.NotEqual(t, m.ID, messages[0].ID)
+	require.Equal(t, 0, mailer.Count())
+
+	// Second step: Message is sent as e-mail, and the escalation is done
+	_, err = s.messageCache.db.Exec(`UPDATE escalations SET next = ?`, time.Now().Add(-time.Second).Unix())
+	require.Nil(t, err)
+	require.Nil(t, s.sendEscalations())
+	waitFor(t, func() bool {
+		return mailer.Count() == 1
+	})
+	escalations, err := s.messageCache.db.Query(`SELECT mid FROM escalations`)
+	require.Nil(t, err)
+	require.False(t, escalations.Next())
+	require.Nil(t, escalations.Close())
+
+	// Forwarded messages are not escalated again
+	response = request(t, s, "GET", "/oncall/json?poll=1", "", nil)
+	require.Equal(t, 1, len(toMessages(t, response.Body.String())))
+}
+
+func TestServer_Escalation_StoppedByAck(t *testing.T) {
+	conf := newTestConfigWithAuthFile(t)
+	conf.EnableReservations = true
+	s := newTestServer(t, conf)
+
+	require.Nil(t, s.userManager.AddTier(&user.Tier{
+		Code:             "pro",
+		MessageLimit:     20,
+		ReservationLimit: 2,
+	}))
+	require.Nil(t, s.userManager.AddUser("phil", "phil", user.RoleUser))
+	require.Nil(t, s.userManager.ChangeTier("phil", "pro"))
+	response := request(t, s, "POST", "/v1/account/reservation", `{"topic": "alerts", "everyone":"read-only", "escalation": {"delay": 600, "forward_topic": "oncall"}}`, map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 200, response.Code)
+
+	response = request(t, s, "PUT", "/alerts", "disk is full", map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+		"Priority":      "5",
+	})
+	require.Equal(t, 200, response.Code)
+	m := toMessage(t, response.Body.String())
+
+	// Acknowledging the message stops the escalation
+	response = request(t, s, "POST", "/alerts/"+m.ID+"/ack", "", map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 200, response.Code)
+
+	_, err := s.messageCache.db.Exec(`UPDATE escalations SET next = ?`, time.Now().Add(-time.Second).Unix())
+	require.Nil(t, err)
+	require.Nil(t, s.sendEscalations())
+	response = request(t, s, "GET", "/oncall/json?poll=1", "", nil)
+	require.Equal(t, 0, len(toMessages(t, response.Body.String())))
+}
@@ -104,6 +104,11 @@ func (c *firebaseSenderImpl) Send(m *messaging.Message) error {
 //     message), and still send the rest of the data along in the "aps" attribute. We can then locally modify the
 //     message in the Notification Service Extension.
 //
+// Message updates and deletions ("message_update", "message_delete"):
+//   - Updates are sent like normal messages, with the "replaces" field pointing to the original message.
+//   - Deletions only carry the ID of the original message in the "replaces" field, and are sent as background
+//     messages on iOS, since there is nothing to display.
+//
 // Keepalive messages ("keepalive"):
 //   - On Android, we subscribe to the "~control" topic, which is used to restart the foreground service (if it died,
 //     e.g. after an app update). We send these keepalive messages regularly (see Config.FirebaseKeepaliveInterval).
@@ -137,7 +142,16 @@ func toFirebaseMessage(m *message, auther user.Auther) (*messaging.Message, erro
 			"poll_id": m.PollID,
 		}
 		apnsConfig = createAPNSAlertConfig(m, data)
-	case messageEvent:
+	case messageDeleteEvent, messageAckEvent:
+		data = map[string]string{
+			"id":       m.ID,
+			"time":     fmt.Sprintf("%d", m.Time),
+			"event":    m.Event,
+			"topic":    m.Topic,
+			"replaces": m.Replaces,
+		}
+		apnsConfig = createAPNSBackgroundConfig(data)
+	case messageEvent, messageUpdateEvent:
 		allowForward := true
 		if auther != nil {
 			allowForward = auther.Authorize(nil, m.Topic, user.PermissionRead) == nil
@@ -157,6 +171,9 @@ func toFirebaseMessage(m *message, auther user.Auther) (*messaging.Message, erro
 				"content_type": m.ContentType,
 				"encoding":     m.Encoding,
 			}
+			if m.Replaces != "" {
+				data["replaces"] = m.Replaces
+			}
 			if len(m.Actions) > 0 {
 				actions, err := json.Marshal(m.Actions)
 				if err != nil {
@@ -268,6 +268,51 @@ func TestToFirebaseMessage_PollRequest(t *testing.T) {
 	}, fbm.Data)
 }
 
+func TestToFirebaseMessage_MessageUpdate(t *testing.T) {
+	m := newMessage(messageUpdateEvent, "mytopic", "updated message")
+	m.Replaces = "fOv6k1QbCzo6"
+	fbm, err := toFirebaseMessage(m, nil)
+	require.Nil(t, err)
+	require.Equal(t, "mytopic", fbm.Topic)
+	require.Equal(t, "message_update", fbm.Data["event"])
+	require.Equal(t, "updated message", fbm.Data["message"])
+	require.Equal(t, "fOv6k1QbCzo6", fbm.Data["replaces"])
+	require.Equal(t, "fOv6k1QbCzo6", fbm.APNS.Payload.CustomData["replaces"])
+}
+
+func TestToFirebaseMessage_MessageDelete(t *testing.T) {
+	m := newDeleteMessage("mytopic", "fOv6k1QbCzo6")
+	fbm, err := toFirebaseMessage(m, nil)
+	require.Nil(t, err)
+	require.Equal(t, "mytopic", fbm.Topic)
+	require.Nil(t, fbm.Android)
+	require.Equal(t, &messaging.APNSConfig{
+		Headers: map[string]string{
+			"apns-push-type": "background",
+			"apns-priority":  "5",
+		},
+		Payload: &messaging.APNSPayload{
+			Aps: &messaging.Aps{
+				ContentAvailable: true,
+			},
+			CustomData: map[string]any{
+				"id":       m.ID,
+				"time":     fmt.Sprintf("%d", m.Time),
+				"event":    "message_delete",
+				"topic":    "mytopic",
+				"replaces": "fOv6k1QbCzo6",
+			},
+		},
+	}, fbm.APNS)
+	require.Equal(t, map[string]string{
+		"id":       m.ID,
+		"time":     fmt.Sprintf("%d", m.Time),
+		"event":    "message_delete",
+		"topic":    "mytopic",
+		"replaces": "fOv6k1QbCzo6",
+	}, fbm.Data)
+}
+
 func TestMaybeTruncateFCMMessage(t *testing.T) {
 	origMessage := strings.Repeat("this is a long string", 300)
 	origFCMMessage := &messaging.Message{
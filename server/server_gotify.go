@@ -0,0 +1,250 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"hash/crc32"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"heckel.io/ntfy/v2/util"
+)
+
+// Gotify API compatibility layer:
+//
+// ntfy implements the parts of the Gotify API (https://gotify.net/api-docs) that are needed to publish messages
+// from existing Gotify integrations, and to stream messages to read-only Gotify clients:
+//
+//   - POST /message publishes a message. The Gotify application token selects the target topic and the ntfy user,
+//     as defined in the gotify-apps config option (token:topic:user). The message is translated to a regular ntfy
+//     publish request, so rate limits and access control apply as usual.
+//   - GET /stream is a WebSocket that streams messages of all Gotify application topics the client is allowed
+//     to read, in the Gotify message format.
+//
+// Tokens can be passed as ?token=..., via the X-Gotify-Key header, or as "Authorization: Bearer ..." header. The
+// request is authenticated as the user the application is mapped to, or, if the token itself is an ntfy access
+// token (tk_...), as the token's user. Since the endpoints shadow the topics "message" and "stream", these topics
+// are disallowed if the Gotify API is enabled (see GotifyDisallowedTopics).
+
+const (
+	gotifyExtrasDisplayContentTypeMarkdown = "text/markdown"
+)
+
+// GotifyApp is a Gotify application, as defined in the gotify-apps config option
+type GotifyApp struct {
+	Topic string // Topic the application publishes to
+	User  string // Username or ntfy access token (tk_...) the application is authenticated as, may be empty
+}
+
+// gotifyMessageRequest represents a Gotify message, as it is sent to POST /message (as per
+// https://gotify.net/api-docs#/message/createMessage), e.g.
+//
+//	{
+//	  "title": "Backup failed",
+//	  "message": "Disk full",
+//	  "priority": 8,
+//	  "extras": { "client::notification": { "click": { "url": "https://example.com" } } }
+//	}
+type gotifyMessageRequest struct {
+	Title    string        `json:"title"`
+	Message  string        `json:"message"`
+	Priority *int          `json:"priority"`
+	Extras   *gotifyExtras `json:"extras"`
+}
+
+// gotifyMessage is a message in the Gotify message format, as returned by POST /message and GET /stream
+type gotifyMessage struct {
+	ID       int64         `json:"id"`
+	AppID    int64         `json:"appid"`
+	Message  string        `json:"message"`
+	Title    string        `json:"title"`
+	Priority int           `json:"priority"`
+	Extras   *gotifyExtras `json:"extras,omitempty"`
+	Date     string        `json:"date"`
+}
+
+// gotifyExtras contains the message extras (see https://gotify.net/docs/msgextras) that can be mapped to ntfy
+type gotifyExtras struct {
+	Display      *gotifyDisplayExtras      `json:"client::display,omitempty"`
+	Notification *gotifyNotificationExtras `json:"client::notification,omitempty"`
+}
+
+type gotifyDisplayExtras struct {
+	ContentType string `json:"contentType,omitempty"`
+}
+
+type gotifyNotificationExtras struct {
+	Click *gotifyClickExtras `json:"click,omitempty"`
+}
+
+type gotifyClickExtras struct {
+	URL string `json:"url"`
+}
+
+// newGotifyMessage converts an ntfy message to a Gotify message. Since Gotify IDs are numeric, the message ID
+// and application ID are derived from the ntfy message ID and topic.
+func newGotifyMessage(m *message) *gotifyMessage {
+	var extras *gotifyExtras
+	if m.ContentType == gotifyExtrasDisplayContentTypeMarkdown || m.Click != "" {
+		extras = &gotifyExtras{}
+		if m.ContentType == gotifyExtrasDisplayContentTypeMarkdown {
+			extras.Display = &gotifyDisplayExtras{ContentType: m.ContentType}
+		}
+		if m.Click != "" {
+			extras.Notification = &gotifyNotificationExtras{Click: &gotifyClickExtras{URL: m.Click}}
+		}
+	}
+	return &gotifyMessage{
+		ID:       gotifyID(m.ID),
+		AppID:    gotifyID(m.Topic),
+		Message:  m.Message,
+		Title:    m.Title,
+		Priority: toGotifyPriority(m.Priority),
+		Extras:   extras,
+		Date:     time.Unix(m.Time, 0).Format(time.RFC3339),
+	}
+}
+
+// transformGotifyRequest rewrites a Gotify publish request (JSON or form) in place to look like a normal ntfy
+// publish request to the topic of the Gotify application token, i.e. it converts
+//
+//	POST /message?token=AbC123 HTTP/1.1
+//	{ "title": "Backup failed", "message": "Disk full", "priority": 8 }
+//
+// to a ntfy request, looking like this:
+//
+//	POST /backups HTTP/1.1
+//	X-Title: Backup failed
+//	X-Priority: 4
+//	Disk full
+func transformGotifyRequest(r *http.Request, apps map[string]*GotifyApp, messageLimit int) error {
+	app, ok := apps[readGotifyToken(r)]
+	if !ok {
+		return errHTTPUnauthorizedGotifyToken
+	}
+	m, err := readGotifyMessageRequest(r, messageLimit)
+	if err != nil {
+		return err
+	} else if m.Message == "" {
+		return errHTTPBadRequestGotifyMessageInvalid.Wrap("message must not be empty")
+	}
+	r.URL.Path = "/" + app.Topic
+	r.URL.RawQuery = ""
+	r.Body = io.NopCloser(strings.NewReader(m.Message))
+	if m.Title != "" {
+		r.Header.Set("X-Title", m.Title)
+	}
+	if m.Priority != nil {
+		r.Header.Set("X-Priority", strconv.Itoa(fromGotifyPriority(*m.Priority)))
+	}
+	if m.Extras != nil && m.Extras.Display != nil && m.Extras.Display.ContentType == gotifyExtrasDisplayContentTypeMarkdown {
+		r.Header.Set("X-Markdown", "yes")
+	}
+	if m.Extras != nil && m.Extras.Notification != nil && m.Extras.Notification.Click != nil && m.Extras.Notification.Click.URL != "" {
+		r.Header.Set("X-Click", m.Extras.Notification.Click.URL)
+	}
+	return nil
+}
+
+// readGotifyMessageRequest reads a Gotify message from the request body, which may either be JSON,
+// or a form (URL-encoded or multipart), as supported by Gotify
+func readGotifyMessageRequest(r *http.Request, messageLimit int) (*gotifyMessageRequest, error) {
+	body, err := util.Peek(r.Body, messageLimit*2) // 2x to account for JSON or form encoding overhead
+	if err != nil {
+		return nil, err
+	}
+	defer r.Body.Close()
+	if body.LimitReached {
+		return nil, errHTTPEntityTooLargeGotifyRequest
+	}
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+		var m gotifyMessageRequest
+		if err := json.Unmarshal(body.PeekedBytes, &m); err != nil {
+			return nil, errHTTPBadRequestGotifyMessageInvalid
+		}
+		return &m, nil
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body.PeekedBytes))
+	if err := r.ParseMultipartForm(int64(messageLimit * 2)); err != nil && err != http.ErrNotMultipart {
+		return nil, errHTTPBadRequestGotifyMessageInvalid
+	}
+	m := &gotifyMessageRequest{
+		Title:   r.PostFormValue("title"),
+		Message: r.PostFormValue("message"),
+	}
+	if priorityStr := r.PostFormValue("priority"); priorityStr != "" {
+		priority, err := strconv.Atoi(priorityStr)
+		if err != nil {
+			return nil, errHTTPBadRequestGotifyMessageInvalid.Wrap("invalid priority %s", priorityStr)
+		}
+		m.Priority = &priority
+	}
+	return m, nil
+}
+
+// readGotifyToken reads the Gotify token from the query string, the X-Gotify-Key header, or the
+// Authorization header (bearer token only)
+func readGotifyToken(r *http.Request) string {
+	if token := readParam(r, "x-gotify-key", "token"); token != "" {
+		return token
+	}
+	header := strings.TrimSpace(r.Header.Get("Authorization"))
+	if strings.HasPrefix(strings.ToLower(header), "bearer ") {
+		return strings.TrimSpace(header[len("bearer "):])
+	}
+	return ""
+}
+
+// gotifyTopics returns the distinct topics of all Gotify applications, sorted by name
+func gotifyTopics(apps map[string]*GotifyApp) []string {
+	topics := make([]string, 0)
+	for _, app := range apps {
+		if !util.Contains(topics, app.Topic) {
+			topics = append(topics, app.Topic)
+		}
+	}
+	sort.Strings(topics)
+	return topics
+}
+
+// fromGotifyPriority maps a Gotify priority (0-10) to an ntfy priority (1-5). In Gotify, 0 means no notification,
+// 1-3 show an icon in the notification bar, 4-7 additionally play a sound, and 8-10 additionally vibrate.
+func fromGotifyPriority(priority int) int {
+	switch {
+	case priority <= 0:
+		return 1
+	case priority <= 3:
+		return 2
+	case priority <= 7:
+		return 3
+	case priority <= 9:
+		return 4
+	default:
+		return 5
+	}
+}
+
+// toGotifyPriority maps an ntfy priority (1-5, or 0 for default) to a Gotify priority (0-10)
+func toGotifyPriority(priority int) int {
+	switch priority {
+	case 1:
+		return 0
+	case 2:
+		return 2
+	case 4:
+		return 8
+	case 5:
+		return 10
+	default:
+		return 5
+	}
+}
+
+// gotifyID derives a stable numeric ID from a string, since Gotify message and application IDs are numeric
+func gotifyID(s string) int64 {
+	return int64(crc32.ChecksumIEEE([]byte(s)))
+}
@@ -0,0 +1,276 @@
+package server
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/require"
+	"heckel.io/ntfy/v2/user"
+	"heckel.io/ntfy/v2/util"
+)
+
+func TestGotify_PublishJSON(t *testing.T) {
+	c := newTestConfig(t)
+	c.GotifyApps = map[string]*GotifyApp{"AbCdEf123": {Topic: "backups"}}
+	s := newTestServer(t, c)
+
+	response := request(t, s, "POST", "/message?token=AbCdEf123", `{"title":"Backup failed","message":"Disk full","priority":8,"extras":{"client::display":{"contentType":"text/markdown"},"client::notification":{"click":{"url":"https://example.com"}}}}`, map[string]string{
+		"Content-Type": "application/json",
+	})
+	require.Equal(t, 200, response.Code)
+	gm, err := util.UnmarshalJSON[gotifyMessage](io.NopCloser(response.Body))
+	require.Nil(t, err)
+	require.Equal(t, "Disk full", gm.Message)
+	require.Equal(t, "Backup failed", gm.Title)
+	require.Equal(t, 8, gm.Priority)
+	require.Equal(t, gotifyID("backups"), gm.AppID)
+	require.Equal(t, "text/markdown", gm.Extras.Display.ContentType)
+	require.Equal(t, "https://example.com", gm.Extras.Notification.Click.URL)
+
+	response = request(t, s, "GET", "/backups/json?poll=1", "", nil)
+	m := toMessage(t, response.Body.String())
+	require.Equal(t, "Disk full", m.Message)
+	require.Equal(t, "Backup failed", m.Title)
+	require.Equal(t, 4, m.Priority)
+	require.Equal(t, "text/markdown", m.ContentType)
+	require.Equal(t, "https://example.com", m.Click)
+	require.Equal(t, gotifyID(m.ID), gm.ID)
+}
+
+func TestGotify_PublishForm(t *testing.T) {
+	c := newTestConfig(t)
+	c.GotifyApps = map[string]*GotifyApp{"AbCdEf123": {Topic: "backups"}}
+	s := newTestServer(t, c)
+
+	response := request(t, s, "POST", "/message", "title=Backup+done&message=All+good&priority=0", map[string]string{
+		"Content-Type": "application/x-www-form-urlencoded",
+		"X-Gotify-Key": "AbCdEf123",
+	})
+	require.Equal(t, 200, response.Code)
+	gm, err := util.UnmarshalJSON[gotifyMessage](io.NopCloser(response.Body))
+	require.Nil(t, err)
+	require.Equal(t, "All good", gm.Message)
+	require.Equal(t, 0, gm.Priority)
+	require.Nil(t, gm.Extras)
+
+	response = request(t, s, "GET", "/backups/json?poll=1", "", nil)
+	m := toMessage(t, response.Body.String())
+	require.Equal(t, "All good", m.Message)
+	require.Equal(t, "Backup done", m.Title)
+	require.Equal(t, 1, m.Priority)
+}
+
+func TestGotify_PublishInvalid(t *testing.T) {
+	c := newTestConfig(t)
+	c.GotifyApps = map[string]*GotifyApp{"AbCdEf123": {Topic: "backups"}}
+	s := newTestServer(t, c)
+
+	response := request(t, s, "POST", "/message?token=unknown", `{"message":"hi"}`, map[string]string{
+		"Content-Type": "application/json",
+	})
+	require.Equal(t, 401, response.Code)
+	require.Equal(t, 40104, toHTTPError(t, response.Body.String()).Code)
+
+	response = request(t, s, "POST", "/message?token=AbCdEf123", `{"title":"no message"}`, map[string]string{
+		"Content-Type": "application/json",
+	})
+	require.Equal(t, 400, response.Code)
+	require.Equal(t, 40058, toHTTPError(t, response.Body.String()).Code)
+
+	response = request(t, s, "POST", "/message?token=AbCdEf123", `not json`, map[string]string{
+		"Content-Type": "application/json",
+	})
+	require.Equal(t, 400, response.Code)
+	require.Equal(t, 40058, toHTTPError(t, response.Body.String()).Code)
+
+	response = request(t, s, "POST", "/message?token=AbCdEf123", "message=hi&priority=high", map[string]string{
+		"Content-Type": "application/x-www-form-urlencoded",
+	})
+	require.Equal(t, 400, response.Code)
+	require.Equal(t, 40058, toHTTPError(t, response.Body.String()).Code)
+}
+
+func TestGotify_Disabled(t *testing.T) {
+	s := newTestServer(t, newTestConfig(t))
+
+	// Without gotify-apps, /message is a regular topic
+	response := request(t, s, "POST", "/message?token=AbCdEf123", `{"message":"hi"}`, nil)
+	require.Equal(t, 200, response.Code)
+	require.Equal(t, "message", toMessage(t, response.Body.String()).Topic)
+}
+
+func TestGotify_PublishWithAccessToken(t *testing.T) {
+	c := newTestConfigWithAuthFile(t)
+	c.AuthDefault = user.PermissionDenyAll
+	s := newTestServer(t, c)
+	require.Nil(t, s.userManager.AddUser("phil", "phil", user.RoleUser))
+	require.Nil(t, s.userManager.AllowAccess("phil", "backups", user.PermissionReadWrite))
+	u, err := s.userManager.User("phil")
+	require.Nil(t, err)
+	token, err := s.userManager.CreateToken(u.ID, "gotify", time.Unix(0, 0), netip.IPv4Unspecified(), nil)
+	require.Nil(t, err)
+	s.config.GotifyApps = map[string]*GotifyApp{
+		"AbCdEf123": {Topic: "backups"},
+		token.Value: {Topic: "backups"},
+	}
+
+	// Non-ntfy token: anonymous, not allowed to write
+	response := request(t, s, "POST", "/message?token=AbCdEf123", `{"message":"hi"}`, map[string]string{
+		"Content-Type": "application/json",
+	})
+	require.Equal(t, 403, response.Code)
+
+	// ntfy token, as query param, header and bearer token
+	response = request(t, s, "POST", "/message?token="+token.Value, `{"message":"via query"}`, map[string]string{
+		"Content-Type": "application/json",
+	})
+	require.Equal(t, 200, response.Code)
+	response = request(t, s, "POST", "/message", `{"message":"via header"}`, map[string]string{
+		"Content-Type": "application/json",
+		"X-Gotify-Key": token.Value,
+	})
+	require.Equal(t, 200, response.Code)
+	response = request(t, s, "POST", "/message", `{"message":"via bearer"}`, map[string]string{
+		"Content-Type":  "application/json",
+		"Authorization": util.BearerAuth(token.Value),
+	})
+	require.Equal(t, 200, response.Code)
+
+	response = request(t, s, "GET", "/backups/json?poll=1", "", map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	messages := toMessages(t, response.Body.String())
+	require.Len(t, messages, 3)
+	require.Equal(t, "via query", messages[0].Message)
+	require.Equal(t, "via header", messages[1].Message)
+	require.Equal(t, "via bearer", messages[2].Message)
+}
+
+func TestGotify_PublishAsMappedUser(t *testing.T) {
+	c := newTestConfigWithAuthFile(t)
+	c.AuthDefault = user.PermissionDenyAll
+	s := newTestServer(t, c)
+	require.Nil(t, s.userManager.AddUser("phil", "phil", user.RoleUser))
+	require.Nil(t, s.userManager.AddUser("ben", "ben", user.RoleUser))
+	require.Nil(t, s.userManager.AllowAccess("phil", "backups", user.PermissionReadWrite))
+	require.Nil(t, s.userManager.AllowAccess("ben", "alerts", user.PermissionReadWrite))
+	u, err := s.userManager.User("ben")
+	require.Nil(t, err)
+	token, err := s.userManager.CreateToken(u.ID, "gotify", time.Unix(0, 0), netip.IPv4Unspecified(), nil)
+	require.Nil(t, err)
+	s.config.GotifyApps = map[string]*GotifyApp{
+		"AbCdEf123": {Topic: "backups", User: "phil"},
+		"GhIjKl456": {Topic: "alerts", User: token.Value},
+		"MnOpQr789": {Topic: "alerts", User: "phil"},
+		"StUvWx012": {Topic: "backups", User: "nobody"},
+	}
+
+	// Mapped to a username
+	response := request(t, s, "POST", "/message?token=AbCdEf123", `{"message":"as phil"}`, map[string]string{
+		"Content-Type": "application/json",
+	})
+	require.Equal(t, 200, response.Code)
+
+	// Mapped to an access token
+	response = request(t, s, "POST", "/message?token=GhIjKl456", `{"message":"as ben"}`, map[string]string{
+		"Content-Type": "application/json",
+	})
+	require.Equal(t, 200, response.Code)
+
+	// Mapped user may not write to the topic, or does not exist
+	response = request(t, s, "POST", "/message?token=MnOpQr789", `{"message":"as phil"}`, map[string]string{
+		"Content-Type": "application/json",
+	})
+	require.Equal(t, 403, response.Code)
+	response = request(t, s, "POST", "/message?token=StUvWx012", `{"message":"as nobody"}`, map[string]string{
+		"Content-Type": "application/json",
+	})
+	require.Equal(t, 401, response.Code)
+
+	response = request(t, s, "GET", "/backups/json?poll=1", "", map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, "as phil", toMessage(t, response.Body.String()).Message)
+	response = request(t, s, "GET", "/alerts/json?poll=1", "", map[string]string{
+		"Authorization": util.BasicAuth("ben", "ben"),
+	})
+	require.Equal(t, "as ben", toMessage(t, response.Body.String()).Message)
+}
+
+func TestGotify_Stream(t *testing.T) {
+	c := newTestConfigWithAuthFile(t)
+	c.AuthDefault = user.PermissionDenyAll
+	c.GotifyApps = map[string]*GotifyApp{
+		"AbCdEf123": {Topic: "backups"},
+		"GhIjKl456": {Topic: "alerts"},
+		"MnOpQr789": {Topic: "secret"},
+	}
+	s := newTestServer(t, c)
+	require.Nil(t, s.userManager.AddUser("phil", "phil", user.RoleUser))
+	require.Nil(t, s.userManager.AllowAccess("phil", "backups", user.PermissionRead))
+	require.Nil(t, s.userManager.AllowAccess("phil", "alerts", user.PermissionRead))
+	require.Nil(t, s.userManager.AllowAccess(user.Everyone, "backups", user.PermissionWrite))
+	require.Nil(t, s.userManager.AllowAccess(user.Everyone, "alerts", user.PermissionWrite))
+	require.Nil(t, s.userManager.AllowAccess(user.Everyone, "secret", user.PermissionWrite))
+	u, err := s.userManager.User("phil")
+	require.Nil(t, err)
+	token, err := s.userManager.CreateToken(u.ID, "gotify", time.Unix(0, 0), netip.IPv4Unspecified(), nil)
+	require.Nil(t, err)
+
+	httpServer := httptest.NewServer(http.HandlerFunc(s.handle))
+	defer httpServer.Close()
+	streamURL := "ws" + strings.TrimPrefix(httpServer.URL, "http") + "/stream"
+
+	// Anonymous users cannot read any of the topics
+	_, _, err = websocket.DefaultDialer.Dial(streamURL, nil)
+	require.Equal(t, websocket.ErrBadHandshake, err)
+
+	// Client with token receives messages of the readable topics only
+	conn, _, err := websocket.DefaultDialer.Dial(streamURL+"?token="+token.Value, nil)
+	require.Nil(t, err)
+	defer conn.Close()
+
+	waitFor(t, func() bool {
+		backups, err := s.topicFromID("backups") // Subscribed last, topics are sorted
+		require.Nil(t, err)
+		subscribers, _ := backups.Stats()
+		return subscribers == 1
+	})
+	for _, app := range []string{"MnOpQr789", "AbCdEf123", "GhIjKl456"} {
+		response := request(t, s, "POST", "/message?token="+app, `{"message":"from `+app+`","priority":10}`, map[string]string{
+			"Content-Type": "application/json",
+		})
+		require.Equal(t, 200, response.Code)
+	}
+	received := make([]string, 0)
+	for i := 0; i < 2; i++ {
+		var gm gotifyMessage
+		require.Nil(t, conn.SetReadDeadline(time.Now().Add(5*time.Second)))
+		require.Nil(t, conn.ReadJSON(&gm))
+		require.Equal(t, 10, gm.Priority)
+		received = append(received, gm.Message)
+	}
+	require.ElementsMatch(t, []string{"from AbCdEf123", "from GhIjKl456"}, received)
+
+	// Message to "secret" topic is never delivered
+	var gm gotifyMessage
+	require.Nil(t, conn.SetReadDeadline(time.Now().Add(300*time.Millisecond)))
+	require.NotNil(t, conn.ReadJSON(&gm))
+}
+
+func TestGotify_Priority(t *testing.T) {
+	for gotifyPriority, ntfyPriority := range []int{1, 2, 2, 2, 3, 3, 3, 3, 4, 4, 5} {
+		require.Equal(t, ntfyPriority, fromGotifyPriority(gotifyPriority))
+	}
+	require.Equal(t, 1, fromGotifyPriority(-1))
+	require.Equal(t, 5, fromGotifyPriority(11))
+	for ntfyPriority, gotifyPriority := range []int{5, 0, 2, 5, 8, 10} {
+		require.Equal(t, gotifyPriority, toGotifyPriority(ntfyPriority))
+	}
+}
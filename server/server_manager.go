@@ -16,6 +16,7 @@ func (s *Server) execManager() {
 	s.pruneAttachments()
 	s.pruneMessages()
 	s.pruneAndNotifyWebPushSubscriptions()
+	s.pruneWebhookDeliveries()
 
 	// Message count per topic
 	var messagesCached int
@@ -172,6 +173,7 @@ func (s *Server) pruneMessages() {
 	log.
 		Tag(tagManager).
 		Timing(func() {
+			s.expireMessagesExceedingRetention()
 			expiredMessageIDs, err := s.messageCache.MessagesExpired()
 			if err != nil {
 				log.Tag(tagManager).Err(err).Warn("Error retrieving expired messages")
@@ -190,3 +192,21 @@ func (s *Server) pruneMessages() {
 		}).
 		Debug("Pruned messages")
 }
+
+// expireMessagesExceedingRetention marks all messages as expired that exceed the retention policy of their
+// topic (see 'ntfy topic retention'), so that they are deleted along with all other expired messages
+func (s *Server) expireMessagesExceedingRetention() {
+	if s.userManager == nil {
+		return
+	}
+	retentions, err := s.userManager.TopicRetentions()
+	if err != nil {
+		log.Tag(tagManager).Err(err).Warn("Error retrieving topic retention policies")
+		return
+	}
+	for _, retention := range retentions {
+		if err := s.messageCache.ExpireMessagesExceedingRetention(retention.Topic, retention.MaxAge, retention.MaxMessages); err != nil {
+			log.Tag(tagManager).Field("topic", retention.Topic).Err(err).Warn("Error expiring messages exceeding topic retention")
+		}
+	}
+}
@@ -12,6 +12,7 @@ const (
 	contextRateVisitor contextKey = iota + 2586
 	contextTopic
 	contextMatrixPushKey
+	contextAlertmanagerNotifications
 )
 
 func (s *Server) limitRequests(next handleFunc) handleFunc {
@@ -77,21 +78,38 @@ func (s *Server) ensureUserManager(next handleFunc) handleFunc {
 }
 
 func (s *Server) ensureUser(next handleFunc) handleFunc {
-	return s.ensureUserManager(func(w http.ResponseWriter, r *http.Request, v *visitor) error {
+	return s.ensureUserManager(s.ensureUnscopedToken(func(w http.ResponseWriter, r *http.Request, v *visitor) error {
 		if v.User() == nil {
 			return errHTTPUnauthorized
 		}
 		return next(w, r, v)
-	})
+	}))
 }
 
 func (s *Server) ensureAdmin(next handleFunc) handleFunc {
-	return s.ensureUserManager(func(w http.ResponseWriter, r *http.Request, v *visitor) error {
+	return s.ensureUserManager(s.ensureUnscopedToken(func(w http.ResponseWriter, r *http.Request, v *visitor) error {
 		if !v.User().IsAdmin() {
 			return errHTTPUnauthorized
 		}
 		return next(w, r, v)
-	})
+	}))
+}
+
+// ensureUnscopedToken rejects requests authenticated with a scoped access token, since those may only
+// be used to publish and subscribe, and not to manage the account (which would allow creating unscoped tokens)
+func (s *Server) ensureUnscopedToken(next handleFunc) handleFunc {
+	return func(w http.ResponseWriter, r *http.Request, v *visitor) error {
+		if s.userManager == nil {
+			return next(w, r, v)
+		}
+		scoped, err := s.isScopedToken(v.User())
+		if err != nil {
+			return err
+		} else if scoped {
+			return errHTTPForbiddenScopedToken
+		}
+		return next(w, r, v)
+	}
 }
 
 func (s *Server) ensureCallsEnabled(next handleFunc) handleFunc {
@@ -103,6 +121,33 @@ func (s *Server) ensureCallsEnabled(next handleFunc) handleFunc {
 	}
 }
 
+func (s *Server) ensureOIDCEnabled(next handleFunc) handleFunc {
+	return func(w http.ResponseWriter, r *http.Request, v *visitor) error {
+		if s.config.OIDCIssuer == "" || s.userManager == nil {
+			return errHTTPNotFound
+		}
+		return next(w, r, v)
+	}
+}
+
+func (s *Server) ensureSignedURLsEnabled(next handleFunc) handleFunc {
+	return func(w http.ResponseWriter, r *http.Request, v *visitor) error {
+		if s.config.SignedURLSecret == "" || s.config.BaseURL == "" || s.userManager == nil {
+			return errHTTPNotFound
+		}
+		return next(w, r, v)
+	}
+}
+
+func (s *Server) ensureWebhooksEnabled(next handleFunc) handleFunc {
+	return func(w http.ResponseWriter, r *http.Request, v *visitor) error {
+		if !s.config.EnableWebhooks || s.userManager == nil {
+			return errHTTPNotFound
+		}
+		return next(w, r, v)
+	}
+}
+
 func (s *Server) ensurePaymentsEnabled(next handleFunc) handleFunc {
 	return func(w http.ResponseWriter, r *http.Request, v *visitor) error {
 		if s.config.StripeSecretKey == "" || s.stripe == nil {
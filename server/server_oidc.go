@@ -0,0 +1,285 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/MicahParks/keyfunc"
+	"github.com/golang-jwt/jwt/v4"
+	"golang.org/x/oauth2"
+	"heckel.io/ntfy/v2/log"
+	"heckel.io/ntfy/v2/user"
+	"heckel.io/ntfy/v2/util"
+)
+
+const (
+	oidcDiscoveryPath           = "/.well-known/openid-configuration"
+	oidcCookieName              = "ntfy-oidc"
+	oidcCookieMaxAge            = 10 * time.Minute // Time a user has to log in with the identity provider
+	oidcProviderCacheDuration   = time.Hour        // Re-read discovery document and signing keys to pick up key rotations
+	oidcStateLength             = 32
+	oidcDiscoveryBodyBytesLimit = 65536
+	oidcHTTPTimeout             = 10 * time.Second // Timeout for requests to the identity provider (discovery, keys, token)
+)
+
+var (
+	errOIDCInvalidIDToken = errors.New("invalid ID token")
+	oidcSigningMethods    = []string{"RS256", "RS384", "RS512", "ES256", "ES384", "ES512", "PS256", "PS384", "PS512", "EdDSA"}
+	oidcHTTPClient        = &http.Client{Timeout: oidcHTTPTimeout}
+)
+
+// oidcProvider holds the endpoints and signing keys of the OpenID Connect identity provider, as read from
+// its discovery document (see https://openid.net/specs/openid-connect-discovery-1_0.html)
+type oidcProvider struct {
+	config *oauth2.Config
+	jwks   *keyfunc.JWKS
+	issuer string
+}
+
+// oidcDiscoveryResponse is the relevant subset of the identity provider's discovery document
+type oidcDiscoveryResponse struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// handleAccountOIDCLogin starts the OpenID Connect authorization code flow by redirecting to the identity provider.
+// The state, nonce and PKCE code verifier are stored in a short-lived cookie, and checked in handleAccountOIDCCallback.
+func (s *Server) handleAccountOIDCLogin(w http.ResponseWriter, r *http.Request, v *visitor) error {
+	provider, err := s.oidcProvider.Value()
+	if err != nil {
+		return err
+	}
+	state, nonce, verifier := util.RandomString(oidcStateLength), util.RandomString(oidcStateLength), oauth2.GenerateVerifier()
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcCookieName,
+		Value:    fmt.Sprintf("%s.%s.%s", state, nonce, verifier),
+		Path:     apiAccountOIDCPath,
+		MaxAge:   int(oidcCookieMaxAge.Seconds()),
+		Secure:   strings.HasPrefix(s.config.BaseURL, "https://"),
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode, // Must be sent on the redirect back from the identity provider
+	})
+	logvr(v, r).Tag(tagAccount).Debug("Redirecting to OIDC provider for login")
+	http.Redirect(w, r, provider.config.AuthCodeURL(state, oauth2.SetAuthURLParam("nonce", nonce), oauth2.S256ChallengeOption(verifier)), http.StatusFound)
+	return nil
+}
+
+// handleAccountOIDCCallback completes the authorization code flow: It exchanges the code for an ID token, verifies it,
+// provisions or updates the ntfy user, and issues a regular ntfy access token. If the web app is enabled, the user is
+// redirected to the web app's login page, which picks up the token from the URL fragment.
+func (s *Server) handleAccountOIDCCallback(w http.ResponseWriter, r *http.Request, v *visitor) error {
+	cookie, err := r.Cookie(oidcCookieName)
+	if err != nil {
+		return errHTTPBadRequestOIDCStateInvalid
+	}
+	parts := strings.Split(cookie.Value, ".")
+	if len(parts) != 3 || parts[0] == "" || readQueryParam(r, "state") != parts[0] {
+		return errHTTPBadRequestOIDCStateInvalid
+	}
+	nonce, verifier := parts[1], parts[2]
+	http.SetCookie(w, &http.Cookie{Name: oidcCookieName, Path: apiAccountOIDCPath, MaxAge: -1})
+	if e := readQueryParam(r, "error"); e != "" {
+		logvr(v, r).Tag(tagAccount).Field("oidc_error", e).Debug("OIDC provider returned error")
+		return errHTTPUnauthorized
+	} else if !v.AuthAllowed() {
+		return errHTTPTooManyRequestsLimitAuthFailure
+	}
+	provider, err := s.oidcProvider.Value()
+	if err != nil {
+		return err
+	}
+	claims, err := provider.exchange(r, readQueryParam(r, "code"), nonce, verifier)
+	if err != nil {
+		v.AuthFailed()
+		logvr(v, r).Tag(tagAccount).Err(err).Debug("OIDC authentication failed")
+		return errHTTPUnauthorized
+	}
+	u, err := s.oidcProvisionUser(v, r, provider.issuer, claims)
+	if err != nil {
+		return err
+	}
+	token, err := s.userManagerAs(v, "oidc").CreateToken(u.ID, "", time.Now().Add(tokenExpiryDuration), v.IP(), nil)
+	if err != nil {
+		return err
+	}
+	if s.config.WebRoot == "" {
+		return s.writeJSON(w, &apiAccountTokenResponse{
+			Token:      token.Value,
+			Label:      token.Label,
+			LastAccess: token.LastAccess.Unix(),
+			LastOrigin: token.LastOrigin.String(),
+			Expires:    token.Expires.Unix(),
+		})
+	}
+	fragment := url.Values{"user": {u.Name}, "token": {token.Value}}
+	http.Redirect(w, r, fmt.Sprintf("%s/login#%s", s.config.BaseURL, fragment.Encode()), http.StatusFound)
+	return nil
+}
+
+// oidcProvisionUser creates the user from the ID token claims on first login, and keeps the user's role and tier
+// in sync with the groups claim on every login after that. Users are identified by the issuer and the immutable
+// "sub" claim, never by the (mutable) username claim, so existing local users can never be taken over via OIDC.
+func (s *Server) oidcProvisionUser(v *visitor, r *http.Request, issuer string, claims jwt.MapClaims) (*user.User, error) {
+	subject, _ := claims["sub"].(string)
+	if subject == "" {
+		return nil, errHTTPUnauthorized.Wrap("claim sub missing")
+	}
+	groups := oidcGroups(claims[s.config.OIDCGroupsClaim])
+	role := user.RoleUser
+	for _, group := range groups {
+		if util.Contains(s.config.OIDCAdminGroups, group) {
+			role = user.RoleAdmin
+			break
+		}
+	}
+	u, err := s.userManager.UserByOIDCSubject(issuer, subject)
+	if errors.Is(err, user.ErrUserNotFound) {
+		username, _ := claims[s.config.OIDCUsernameClaim].(string)
+		if !user.AllowedUsername(username) {
+			return nil, errHTTPUnauthorized.Wrap("claim %s missing or not a valid username", s.config.OIDCUsernameClaim)
+		}
+		ev := logvr(v, r).Tag(tagAccount).Fields(log.Context{"user_name": username, "user_role": role, "oidc_subject": subject})
+		ev.Info("Creating user %s from OIDC login", username)
+		if err := s.userManagerAs(v, "oidc").AddOIDCUser(username, role, issuer, subject); errors.Is(err, user.ErrUserExists) {
+			ev.Warn("Refusing OIDC login, user %s already exists and was not created via OIDC", username)
+			return nil, errHTTPConflictUserExists
+		} else if err != nil {
+			return nil, err
+		}
+		u, err = s.userManager.User(username)
+		if err != nil {
+			return nil, err
+		}
+	} else if err != nil {
+		return nil, err
+	} else if u.Deleted {
+		logvr(v, r).Tag(tagAccount).Field("user_name", u.Name).Info("Refusing OIDC login, user %s is marked as deleted", u.Name)
+		return nil, errHTTPUnauthorized
+	} else if u.Role != role {
+		logvr(v, r).Tag(tagAccount).Fields(log.Context{"user_name": u.Name, "user_role": role}).Info("Changing role of user %s to %s, as per OIDC groups", u.Name, role)
+		if err := s.userManagerAs(v, "oidc").ChangeRole(u.Name, role); err != nil {
+			return nil, err
+		}
+	}
+	if len(s.config.OIDCTierGroups) > 0 {
+		if err := s.oidcSyncTier(v, u.Name, groups); err != nil {
+			logvr(v, r).Tag(tagAccount).Err(err).Warn("Unable to change tier of user %s, as per OIDC groups", u.Name)
+		}
+	}
+	return s.userManager.UserByID(u.ID)
+}
+
+// oidcSyncTier sets the tier of the first group that has a tier mapping, or removes the tier if there is none
+func (s *Server) oidcSyncTier(v *visitor, username string, groups []string) error {
+	u, err := s.userManager.User(username)
+	if err != nil {
+		return err
+	}
+	var tier string
+	for _, group := range groups {
+		if code, ok := s.config.OIDCTierGroups[group]; ok {
+			tier = code
+			break
+		}
+	}
+	if tier == "" && u.Tier != nil {
+		return s.userManagerAs(v, "oidc").ResetTier(username)
+	} else if tier != "" && (u.Tier == nil || u.Tier.Code != tier) {
+		return s.userManagerAs(v, "oidc").ChangeTier(username, tier)
+	}
+	return nil
+}
+
+// fetchOIDCProvider reads the identity provider's discovery document and signing keys. It is called via the
+// oidcProvider lookup cache, so keys are refreshed periodically.
+func (s *Server) fetchOIDCProvider() (*oidcProvider, error) {
+	log.Tag(tagAccount).Debug("Fetching OIDC provider configuration from %s", s.config.OIDCIssuer)
+	resp, err := oidcHTTPClient.Get(strings.TrimSuffix(s.config.OIDCIssuer, "/") + oidcDiscoveryPath)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status code %d when fetching OIDC discovery document", resp.StatusCode)
+	}
+	discovery, err := readJSONWithLimit[oidcDiscoveryResponse](resp.Body, oidcDiscoveryBodyBytesLimit, false)
+	if err != nil {
+		return nil, err
+	} else if discovery.Issuer != s.config.OIDCIssuer {
+		return nil, fmt.Errorf("OIDC issuer %s in discovery document does not match configured issuer %s", discovery.Issuer, s.config.OIDCIssuer)
+	}
+	jwks, err := keyfunc.Get(discovery.JWKSURI, keyfunc.Options{Client: oidcHTTPClient})
+	if err != nil {
+		return nil, err
+	}
+	return &oidcProvider{
+		config: &oauth2.Config{
+			ClientID:     s.config.OIDCClientID,
+			ClientSecret: s.config.OIDCClientSecret,
+			Endpoint: oauth2.Endpoint{
+				AuthURL:  discovery.AuthorizationEndpoint,
+				TokenURL: discovery.TokenEndpoint,
+			},
+			RedirectURL: s.config.BaseURL + apiAccountOIDCCallbackPath,
+			Scopes:      []string{"openid", "profile", "email"},
+		},
+		jwks:   jwks,
+		issuer: discovery.Issuer,
+	}, nil
+}
+
+// exchange exchanges the authorization code (and the PKCE code verifier) for tokens, and returns the claims
+// of the verified ID token
+func (p *oidcProvider) exchange(r *http.Request, code, nonce, verifier string) (jwt.MapClaims, error) {
+	if code == "" {
+		return nil, errOIDCInvalidIDToken
+	}
+	ctx := context.WithValue(r.Context(), oauth2.HTTPClient, oidcHTTPClient)
+	token, err := p.config.Exchange(ctx, code, oauth2.VerifierOption(verifier))
+	if err != nil {
+		return nil, err
+	}
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok {
+		return nil, errOIDCInvalidIDToken
+	}
+	return p.verify(rawIDToken, nonce)
+}
+
+// verify checks the ID token's signature, issuer, audience, expiry and nonce
+func (p *oidcProvider) verify(rawIDToken, nonce string) (jwt.MapClaims, error) {
+	claims := jwt.MapClaims{}
+	if _, err := jwt.ParseWithClaims(rawIDToken, claims, p.jwks.Keyfunc, jwt.WithValidMethods(oidcSigningMethods)); err != nil {
+		return nil, err
+	} else if !claims.VerifyIssuer(p.issuer, true) || !claims.VerifyAudience(p.config.ClientID, true) {
+		return nil, errOIDCInvalidIDToken
+	} else if tokenNonce, _ := claims["nonce"].(string); tokenNonce != nonce {
+		return nil, errOIDCInvalidIDToken
+	}
+	return claims, nil
+}
+
+// oidcGroups converts the groups claim to a string slice. Identity providers send groups as a JSON array,
+// but some send a single group as a string.
+func oidcGroups(claim any) []string {
+	switch groups := claim.(type) {
+	case string:
+		return []string{groups}
+	case []any:
+		result := make([]string, 0, len(groups))
+		for _, group := range groups {
+			if g, ok := group.(string); ok {
+				result = append(result, g)
+			}
+		}
+		return result
+	}
+	return nil
+}
@@ -0,0 +1,273 @@
+package server
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/oauth2"
+	"heckel.io/ntfy/v2/user"
+	"heckel.io/ntfy/v2/util"
+)
+
+func TestServer_OIDC_Login(t *testing.T) {
+	provider := newTestOIDCProvider(t)
+	s := newTestServer(t, newTestConfigWithOIDC(t, provider))
+	require.Nil(t, s.userManager.AddTier(&user.Tier{Code: "pro", MessageLimit: 123}))
+
+	// First login creates the user, as admin
+	rr := testOIDCLogin(t, s, provider, jwt.MapClaims{"preferred_username": "phil", "groups": []string{"ntfy-admins"}})
+	require.Equal(t, 302, rr.Code)
+	location, err := url.Parse(rr.Header().Get("Location"))
+	require.Nil(t, err)
+	require.Equal(t, "/login", location.Path)
+	fragment, err := url.ParseQuery(location.Fragment)
+	require.Nil(t, err)
+	require.Equal(t, "phil", fragment.Get("user"))
+
+	rr = request(t, s, "GET", "/v1/account", "", map[string]string{
+		"Authorization": util.BearerAuth(fragment.Get("token")),
+	})
+	require.Equal(t, 200, rr.Code)
+	account, _ := util.UnmarshalJSON[apiAccountResponse](io.NopCloser(rr.Body))
+	require.Equal(t, "phil", account.Username)
+	require.Equal(t, "admin", account.Role)
+	require.Nil(t, account.Tier)
+
+	// Users cannot log in with a password
+	rr = request(t, s, "GET", "/v1/account", "", map[string]string{
+		"Authorization": util.BasicAuth("phil", ""),
+	})
+	require.Equal(t, 401, rr.Code)
+
+	// Second login syncs role and tier from the groups claim
+	rr = testOIDCLogin(t, s, provider, jwt.MapClaims{"preferred_username": "phil", "groups": []string{"staff", "ntfy-pro"}})
+	require.Equal(t, 302, rr.Code)
+	u, err := s.userManager.User("phil")
+	require.Nil(t, err)
+	require.Equal(t, user.RoleUser, u.Role)
+	require.Equal(t, "pro", u.Tier.Code)
+
+	// Removing the user from the group removes the tier
+	rr = testOIDCLogin(t, s, provider, jwt.MapClaims{"preferred_username": "phil", "groups": "staff"})
+	require.Equal(t, 302, rr.Code)
+	u, err = s.userManager.User("phil")
+	require.Nil(t, err)
+	require.Nil(t, u.Tier)
+}
+
+func TestServer_OIDC_Login_ExistingLocalUser(t *testing.T) {
+	provider := newTestOIDCProvider(t)
+	s := newTestServer(t, newTestConfigWithOIDC(t, provider))
+	require.Nil(t, s.userManager.AddUser("phil", "phil", user.RoleAdmin))
+
+	// A matching username claim does not adopt (or demote) a local user that was not created via OIDC
+	rr := testOIDCLogin(t, s, provider, jwt.MapClaims{"preferred_username": "phil"})
+	require.Equal(t, 409, rr.Code)
+	require.Equal(t, 40901, toHTTPError(t, rr.Body.String()).Code)
+	u, err := s.userManager.User("phil")
+	require.Nil(t, err)
+	require.Equal(t, user.RoleAdmin, u.Role)
+}
+
+func TestServer_OIDC_Login_LinkedBySubject(t *testing.T) {
+	provider := newTestOIDCProvider(t)
+	s := newTestServer(t, newTestConfigWithOIDC(t, provider))
+
+	rr := testOIDCLogin(t, s, provider, jwt.MapClaims{"preferred_username": "phil", "sub": "sub-phil"})
+	require.Equal(t, 302, rr.Code)
+
+	// A changed username claim still logs in the linked user
+	rr = testOIDCLogin(t, s, provider, jwt.MapClaims{"preferred_username": "philipp", "sub": "sub-phil"})
+	require.Equal(t, 302, rr.Code)
+	location, err := url.Parse(rr.Header().Get("Location"))
+	require.Nil(t, err)
+	fragment, err := url.ParseQuery(location.Fragment)
+	require.Nil(t, err)
+	require.Equal(t, "phil", fragment.Get("user"))
+	_, err = s.userManager.User("philipp")
+	require.Equal(t, user.ErrUserNotFound, err)
+
+	// Another identity claiming the same username is rejected
+	rr = testOIDCLogin(t, s, provider, jwt.MapClaims{"preferred_username": "phil", "sub": "sub-mallory"})
+	require.Equal(t, 409, rr.Code)
+}
+
+func TestServer_OIDC_Login_DeletedUser(t *testing.T) {
+	provider := newTestOIDCProvider(t)
+	s := newTestServer(t, newTestConfigWithOIDC(t, provider))
+
+	rr := testOIDCLogin(t, s, provider, jwt.MapClaims{"preferred_username": "phil", "sub": "sub-phil"})
+	require.Equal(t, 302, rr.Code)
+	u, err := s.userManager.User("phil")
+	require.Nil(t, err)
+	require.Nil(t, s.userManager.MarkUserRemoved(u))
+
+	// A user that deleted their account does not get a new token
+	rr = testOIDCLogin(t, s, provider, jwt.MapClaims{"preferred_username": "phil", "sub": "sub-phil"})
+	require.Equal(t, 401, rr.Code)
+	tokens, err := s.userManager.Tokens(u.ID)
+	require.Nil(t, err)
+	require.Empty(t, tokens)
+}
+
+func TestServer_OIDC_Login_WebDisabled(t *testing.T) {
+	provider := newTestOIDCProvider(t)
+	conf := newTestConfigWithOIDC(t, provider)
+	conf.WebRoot = ""
+	s := newTestServer(t, conf)
+
+	rr := testOIDCLogin(t, s, provider, jwt.MapClaims{"preferred_username": "ben"})
+	require.Equal(t, 200, rr.Code)
+	token, err := util.UnmarshalJSON[apiAccountTokenResponse](io.NopCloser(rr.Body))
+	require.Nil(t, err)
+	require.NotEmpty(t, token.Token)
+	require.Greater(t, token.Expires, time.Now().Unix())
+}
+
+func TestServer_OIDC_Callback_InvalidState(t *testing.T) {
+	provider := newTestOIDCProvider(t)
+	s := newTestServer(t, newTestConfigWithOIDC(t, provider))
+
+	rr := request(t, s, "GET", "/v1/account/oidc/callback?state=abc&code=123", "", nil)
+	require.Equal(t, 400, rr.Code)
+	require.Equal(t, 40050, toHTTPError(t, rr.Body.String()).Code)
+
+	rr = request(t, s, "GET", "/v1/account/oidc/callback?state=abc&code=123", "", map[string]string{
+		"Cookie": oidcCookieName + "=xyz.nonce",
+	})
+	require.Equal(t, 400, rr.Code)
+	require.Equal(t, 40050, toHTTPError(t, rr.Body.String()).Code)
+}
+
+func TestServer_OIDC_Callback_InvalidIDToken(t *testing.T) {
+	provider := newTestOIDCProvider(t)
+	s := newTestServer(t, newTestConfigWithOIDC(t, provider))
+
+	// Wrong nonce
+	rr := testOIDCLogin(t, s, provider, jwt.MapClaims{"preferred_username": "phil", "nonce": "wrong"})
+	require.Equal(t, 401, rr.Code)
+
+	// Wrong audience
+	rr = testOIDCLogin(t, s, provider, jwt.MapClaims{"preferred_username": "phil", "aud": "another-client"})
+	require.Equal(t, 401, rr.Code)
+
+	// Expired
+	rr = testOIDCLogin(t, s, provider, jwt.MapClaims{"preferred_username": "phil", "exp": time.Now().Add(-time.Minute).Unix()})
+	require.Equal(t, 401, rr.Code)
+
+	// Invalid username
+	rr = testOIDCLogin(t, s, provider, jwt.MapClaims{"preferred_username": "phil heckel"})
+	require.Equal(t, 401, rr.Code)
+
+	_, err := s.userManager.User("phil")
+	require.Equal(t, user.ErrUserNotFound, err)
+}
+
+func TestServer_OIDC_Disabled(t *testing.T) {
+	s := newTestServer(t, newTestConfigWithAuthFile(t))
+	rr := request(t, s, "GET", "/v1/account/oidc/login", "", nil)
+	require.Equal(t, 404, rr.Code)
+}
+
+type testOIDCProvider struct {
+	server    *httptest.Server
+	key       *rsa.PrivateKey
+	claims    atomic.Pointer[jwt.MapClaims] // Claims of the next ID token
+	challenge atomic.Pointer[string]        // PKCE code challenge of the last authorization request
+}
+
+func newTestOIDCProvider(t *testing.T) *testOIDCProvider {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.Nil(t, err)
+	p := &testOIDCProvider{key: key}
+	p.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case oidcDiscoveryPath:
+			json.NewEncoder(w).Encode(&oidcDiscoveryResponse{
+				Issuer:                p.server.URL,
+				AuthorizationEndpoint: p.server.URL + "/authorize",
+				TokenEndpoint:         p.server.URL + "/token",
+				JWKSURI:               p.server.URL + "/jwks",
+			})
+		case "/jwks":
+			fmt.Fprintf(w, `{"keys":[{"kty":"RSA","kid":"test","use":"sig","alg":"RS256","n":"%s","e":"%s"}]}`,
+				base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()))
+		case "/token":
+			require.Nil(t, r.ParseForm())
+			require.Equal(t, "authorization_code", r.PostForm.Get("grant_type"))
+			require.Equal(t, "code123", r.PostForm.Get("code"))
+			require.Equal(t, *p.challenge.Load(), oauth2.S256ChallengeFromVerifier(r.PostForm.Get("code_verifier")))
+			token := jwt.NewWithClaims(jwt.SigningMethodRS256, *p.claims.Load())
+			token.Header["kid"] = "test"
+			idToken, err := token.SignedString(key)
+			require.Nil(t, err)
+			fmt.Fprintf(w, `{"access_token":"abc","token_type":"Bearer","expires_in":3600,"id_token":"%s"}`, idToken)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(p.server.Close)
+	return p
+}
+
+func newTestConfigWithOIDC(t *testing.T, provider *testOIDCProvider) *Config {
+	conf := newTestConfigWithAuthFile(t)
+	conf.EnableLogin = true
+	conf.OIDCIssuer = provider.server.URL
+	conf.OIDCClientID = "ntfy"
+	conf.OIDCClientSecret = "secret"
+	conf.OIDCAdminGroups = []string{"ntfy-admins"}
+	conf.OIDCTierGroups = map[string]string{"ntfy-pro": "pro"}
+	return conf
+}
+
+// testOIDCLogin performs the authorization code flow, with the identity provider returning an ID token with the
+// given claims. Default claims (issuer, audience, expiry, nonce) are added, unless they are given.
+func testOIDCLogin(t *testing.T, s *Server, provider *testOIDCProvider, claims jwt.MapClaims) *httptest.ResponseRecorder {
+	rr := request(t, s, "GET", "/v1/account/oidc/login", "", nil)
+	require.Equal(t, 302, rr.Code)
+	location, err := url.Parse(rr.Header().Get("Location"))
+	require.Nil(t, err)
+	require.Equal(t, provider.server.URL+"/authorize", fmt.Sprintf("%s://%s%s", location.Scheme, location.Host, location.Path))
+	require.Equal(t, "ntfy", location.Query().Get("client_id"))
+	require.Equal(t, "http://127.0.0.1:12345/v1/account/oidc/callback", location.Query().Get("redirect_uri"))
+	require.Equal(t, "S256", location.Query().Get("code_challenge_method"))
+	state, nonce, challenge := location.Query().Get("state"), location.Query().Get("nonce"), location.Query().Get("code_challenge")
+	provider.challenge.Store(&challenge)
+	cookies := rr.Result().Cookies()
+	require.Equal(t, 1, len(cookies))
+	require.Equal(t, oidcCookieName, cookies[0].Name)
+
+	defaults := jwt.MapClaims{
+		"iss":   provider.server.URL,
+		"aud":   "ntfy",
+		"sub":   "1234",
+		"iat":   time.Now().Unix(),
+		"exp":   time.Now().Add(time.Hour).Unix(),
+		"nonce": nonce,
+	}
+	for k, v := range defaults {
+		if _, ok := claims[k]; !ok {
+			claims[k] = v
+		}
+	}
+	provider.claims.Store(&claims)
+	return request(t, s, "GET", "/v1/account/oidc/callback?code=code123&state="+state, "", map[string]string{
+		"Cookie": fmt.Sprintf("%s=%s", cookies[0].Name, cookies[0].Value),
+	})
+}
@@ -445,7 +445,7 @@ func (s *Server) updateSubscriptionAndTier(r *http.Request, v *visitor, u *user.
 	}
 	if tier == nil && u.Tier != nil {
 		logvr(v, r).Tag(tagStripe).Info("Resetting tier for user %s", u.Name)
-		if err := s.userManager.ResetTier(u.Name); err != nil {
+		if err := s.userManagerAs(v, "billing").ResetTier(u.Name); err != nil {
 			return err
 		}
 	} else if tier != nil && u.TierID() != tier.ID {
@@ -456,7 +456,7 @@ func (s *Server) updateSubscriptionAndTier(r *http.Request, v *visitor, u *user.
 				"new_tier_code": tier.Code,
 			}).
 			Info("Changing tier to tier %s (%s) for user %s", tier.ID, tier.Name, u.Name)
-		if err := s.userManager.ChangeTier(u.Name, tier.Code); err != nil {
+		if err := s.userManagerAs(v, "billing").ChangeTier(u.Name, tier.Code); err != nil {
 			return err
 		}
 	}
@@ -0,0 +1,319 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"time"
+	"unicode/utf8"
+
+	"heckel.io/ntfy/v2/log"
+	"heckel.io/ntfy/v2/user"
+	"heckel.io/ntfy/v2/util"
+)
+
+const (
+	scheduleTopicLimit = 50 // Max number of recurring messages per topic
+)
+
+// handlePublishSchedule creates a recurring message from a publish request with the X-Schedule header. Instead of
+// publishing the message right away, the message is stored as a template, and the delayed sender publishes a copy
+// of it every time the schedule is due (see sendScheduledMessages).
+func (s *Server) handlePublishSchedule(w http.ResponseWriter, r *http.Request, v *visitor) error {
+	t, err := fromContext[*topic](r, contextTopic)
+	if err != nil {
+		return err
+	}
+	vrate, err := fromContext[*visitor](r, contextRateVisitor)
+	if err != nil {
+		return err
+	}
+	body, err := util.Peek(r.Body, s.config.MessageLimit)
+	if err != nil {
+		return err
+	}
+	spec := readParam(r, "x-schedule", "schedule")
+	sched, err := util.ParseSchedule(spec)
+	if err != nil {
+		return errHTTPBadRequestScheduleInvalid.Wrap("%s", err.Error()).With(t)
+	}
+	next := sched.Next(time.Now())
+	if next.IsZero() {
+		return errHTTPBadRequestScheduleInvalid.Wrap("schedule never runs").With(t)
+	}
+	m := newDefaultMessage(t.ID, "")
+	cache, _, email, call, unifiedpush, e := s.parsePublishParams(r, m)
+	if e != nil {
+		return e.With(t)
+	}
+	delay := readParam(r, "x-delay", "delay", "x-at", "at", "x-in", "in")
+	upload := (m.Attachment != nil && m.Attachment.URL == "") || body.LimitReached || !utf8.Valid(body.PeekedBytes)
+	if !cache || delay != "" || email != "" || call != "" || unifiedpush || m.Replaces != "" || m.PollID != "" || upload {
+		return errHTTPBadRequestScheduleNotAllowed.With(t)
+	} else if !util.ContainsIP(s.config.VisitorRequestExemptIPAddrs, v.ip) && !vrate.MessageAllowed() {
+		return errHTTPTooManyRequestsLimitMessages.With(t)
+	}
+	schedules, err := s.messageCache.Schedules(t.ID)
+	if err != nil {
+		return err
+	} else if len(schedules) >= scheduleTopicLimit {
+		return errHTTPTooManyRequestsLimitSchedules.With(t)
+	}
+	visitorSchedules, err := s.messageCache.ScheduleCount(v.MaybeUserID(), v.IP())
+	if err != nil {
+		return err
+	} else if visitorSchedules >= s.config.VisitorScheduleLimit {
+		return errHTTPTooManyRequestsLimitVisitorSchedules.With(t)
+	}
+	if err := s.handleBodyAsTextMessage(m, body); err != nil {
+		return err
+	}
+	if m.Message == "" {
+		m.Message = emptyMessageBody
+	}
+	m.ID, m.Time = "", 0 // Set for every run, see sendScheduledMessage
+	m.Sender = v.IP()
+	m.User = v.MaybeUserID()
+	sc := newSchedule(t.ID, spec, next.Unix(), m)
+	logvr(v, r).With(sc).Tag(tagPublish).Debug("Adding recurring message, next run at %s", next.String())
+	if err := s.messageCache.AddSchedule(sc); err != nil {
+		return err
+	}
+	return s.writeJSON(w, newScheduleResponse(sc))
+}
+
+// handleSchedulesGet returns the recurring messages of a topic. Admins see all recurring messages, everyone
+// else only sees their own, see schedule.OwnedBy.
+func (s *Server) handleSchedulesGet(w http.ResponseWriter, r *http.Request, v *visitor) error {
+	matches := schedulesPathRegex.FindStringSubmatch(r.URL.Path)
+	if len(matches) != 2 {
+		return errHTTPInternalErrorInvalidPath
+	}
+	schedules, err := s.messageCache.Schedules(matches[1])
+	if err != nil {
+		return err
+	}
+	response := make([]*apiScheduleResponse, 0)
+	for _, sc := range schedules {
+		if sc.OwnedBy(v) {
+			response = append(response, newScheduleResponse(sc))
+		}
+	}
+	return s.writeJSON(w, response)
+}
+
+// handleScheduleUpdate pauses or resumes a recurring message. Runs that were missed while the recurring message
+// was paused are not caught up on.
+func (s *Server) handleScheduleUpdate(w http.ResponseWriter, r *http.Request, v *visitor) error {
+	sc, err := s.scheduleFromPath(r, v)
+	if err != nil {
+		return err
+	}
+	req, err := readJSONWithLimit[apiScheduleUpdateRequest](r.Body, jsonBodyBytesLimit, false)
+	if err != nil {
+		return err
+	} else if req.Paused == nil {
+		return errHTTPBadRequestJSONInvalid
+	}
+	if !*req.Paused {
+		sched, err := util.ParseSchedule(sc.Spec)
+		if err != nil {
+			return err
+		}
+		sc.Next = sched.Next(time.Now()).Unix()
+	}
+	sc.Paused = *req.Paused
+	logvr(v, r).With(sc).Tag(tagPublish).Debug("Changing recurring message, paused: %t", sc.Paused)
+	if err := s.messageCache.ChangeSchedulePaused(sc.ID, sc.Paused, sc.Next); err != nil {
+		return err
+	}
+	return s.writeJSON(w, newScheduleResponse(sc))
+}
+
+// handleScheduleDelete removes a recurring message. Messages that were already published are not affected.
+func (s *Server) handleScheduleDelete(w http.ResponseWriter, r *http.Request, v *visitor) error {
+	sc, err := s.scheduleFromPath(r, v)
+	if err != nil {
+		return err
+	}
+	logvr(v, r).With(sc).Tag(tagPublish).Debug("Deleting recurring message")
+	if err := s.messageCache.DeleteSchedule(sc.ID); err != nil {
+		return err
+	}
+	return s.writeJSON(w, newSuccessResponse())
+}
+
+// scheduleFromPath reads the recurring message referenced in the path (/<topic>/schedules/<id>). To not
+// leak which IDs exist, recurring messages of other topics or users are reported as not found.
+func (s *Server) scheduleFromPath(r *http.Request, v *visitor) (*schedule, error) {
+	matches := scheduleSinglePathRegex.FindStringSubmatch(r.URL.Path)
+	if len(matches) != 3 {
+		return nil, errHTTPInternalErrorInvalidPath
+	}
+	sc, err := s.messageCache.Schedule(matches[2])
+	if errors.Is(err, errScheduleNotFound) {
+		return nil, errHTTPNotFoundSchedule
+	} else if err != nil {
+		return nil, err
+	} else if sc.Topic != matches[1] || !sc.OwnedBy(v) {
+		return nil, errHTTPNotFoundSchedule
+	}
+	return sc, nil
+}
+
+// sendScheduledMessages publishes a message for every recurring message that is due. It is called by the
+// delayed sender, see runDelayedSender. Since access to the topic may have been revoked after the recurring
+// message was created, write access is checked before every run. If it was revoked, the recurring message is
+// paused (and can be resumed by the user once access is restored). If the user was deleted, it is deleted.
+func (s *Server) sendScheduledMessages() error {
+	schedules, err := s.messageCache.SchedulesDue()
+	if err != nil {
+		return err
+	}
+	for _, sc := range schedules {
+		var u *user.User
+		if s.userManager != nil && sc.Message.User != "" {
+			u, err = s.userManager.UserByID(sc.Message.User)
+			if errors.Is(err, user.ErrUserNotFound) {
+				log.With(sc).Info("User of recurring message does not exist anymore, deleting recurring message")
+				if err := s.messageCache.DeleteSchedule(sc.ID); err != nil {
+					log.With(sc).Err(err).Warn("Error deleting recurring message")
+				}
+				continue
+			} else if err != nil {
+				log.With(sc).Err(err).Warn("Error sending scheduled message")
+				continue
+			}
+		}
+		if s.userManager != nil {
+			if err := s.userManager.Authorize(u, sc.Topic, user.PermissionWrite); err != nil {
+				log.With(sc).Err(err).Info("Creator of recurring message is not allowed to publish to topic anymore, pausing recurring message")
+				if err := s.messageCache.ChangeSchedulePaused(sc.ID, true, sc.Next); err != nil {
+					log.With(sc).Err(err).Warn("Error pausing recurring message")
+				}
+				continue
+			}
+		}
+		v := s.visitor(sc.Message.Sender, u)
+		if err := s.sendScheduledMessage(v, sc); err != nil {
+			logv(v).With(sc).Err(err).Warn("Error sending scheduled message")
+		}
+	}
+	return nil
+}
+
+// sendScheduledMessage publishes a copy of the recurring message's template, and sets the time of its next run.
+// The next run is calculated from the current time, so runs that were missed (e.g. because the server was down)
+// are not caught up on. Unlike delayed messages, scheduled messages are rate limited when they are published.
+func (s *Server) sendScheduledMessage(v *visitor, sc *schedule) error {
+	sched, err := util.ParseSchedule(sc.Spec)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	next := sched.Next(now)
+	if next.IsZero() {
+		logv(v).With(sc).Info("Recurring message will not run again, deleting it")
+		if err := s.messageCache.DeleteSchedule(sc.ID); err != nil {
+			return err
+		}
+	} else if err := s.messageCache.MarkScheduleRun(sc.ID, now.Unix(), next.Unix()); err != nil {
+		return err
+	}
+	if !util.ContainsIP(s.config.VisitorRequestExemptIPAddrs, v.ip) && !v.MessageAllowed() {
+		return errHTTPTooManyRequestsLimitMessages
+	}
+	m := *sc.Message
+	m.ID = util.RandomString(messageIDLength)
+	m.Time = now.Unix()
+	m.Expires = now.Add(v.Limits().MessageExpiryDuration).Unix()
+	m.Actions = copyActions(sc.Message.Actions)
+	s.setAcknowledgeURLs(&m) // The template's ack URLs point to the template's ID, not to this run
+	logvm(v, &m).With(sc).Debug("Sending scheduled message")
+	if err := s.messageCache.AddMessage(&m); err != nil {
+		return err
+	}
+	s.deliverMessage(v, &m)
+	s.maybeStartEscalation(&m)
+	u := v.User()
+	if s.userManager != nil && u != nil && u.Tier != nil {
+		go s.userManager.EnqueueUserStats(u.ID, v.Stats())
+	}
+	s.mu.Lock()
+	s.messages++
+	s.mu.Unlock()
+	return nil
+}
+
+// handleScheduledMessagesGet returns the scheduled (delayed) messages of a topic that have not been published yet.
+// Admins see all scheduled messages, everyone else only sees their own, see message.OwnedBy.
+func (s *Server) handleScheduledMessagesGet(w http.ResponseWriter, r *http.Request, v *visitor) error {
+	matches := scheduledPathRegex.FindStringSubmatch(r.URL.Path)
+	if len(matches) != 2 {
+		return errHTTPInternalErrorInvalidPath
+	}
+	messages, err := s.messageCache.MessagesScheduled(matches[1])
+	if err != nil {
+		return err
+	}
+	response := make([]*message, 0)
+	for _, m := range messages {
+		if m.OwnedBy(v) {
+			response = append(response, m)
+		}
+	}
+	return s.writeJSON(w, response)
+}
+
+// handleScheduledMessageDelete cancels a scheduled (delayed) message before it is published, and removes its
+// attachment, if any. Unlike deleting a published message, no event is sent to subscribers.
+func (s *Server) handleScheduledMessageDelete(w http.ResponseWriter, r *http.Request, v *visitor) error {
+	matches := scheduledMessagePathRegex.FindStringSubmatch(r.URL.Path)
+	if len(matches) != 3 {
+		return errHTTPInternalErrorInvalidPath
+	}
+	topicID, messageID := matches[1], matches[2]
+	m, err := s.messageCache.Message(messageID)
+	if errors.Is(err, errMessageNotFound) {
+		return errHTTPNotFoundScheduledMessage
+	} else if err != nil {
+		return err
+	} else if m.Topic != topicID || !m.OwnedBy(v) {
+		return errHTTPNotFoundScheduledMessage
+	}
+	logvrm(v, r, m).Tag(tagPublish).Debug("Cancelling scheduled message")
+	if err := s.messageCache.DeleteScheduledMessage(topicID, messageID); errors.Is(err, errMessageNotFound) {
+		return errHTTPNotFoundScheduledMessage // Published in the meantime
+	} else if err != nil {
+		return err
+	}
+	if s.fileCache != nil && m.Attachment != nil {
+		if err := s.fileCache.Remove(m.ID); err != nil {
+			logvrm(v, r, m).Tag(tagPublish).Err(err).Warn("Error removing attachment of scheduled message")
+		}
+	}
+	return s.writeJSON(w, newSuccessResponse())
+}
+
+func newScheduleResponse(sc *schedule) *apiScheduleResponse {
+	response := &apiScheduleResponse{
+		ID:          sc.ID,
+		Topic:       sc.Topic,
+		Schedule:    sc.Spec,
+		Paused:      sc.Paused,
+		Last:        sc.Last,
+		Created:     sc.Created,
+		Title:       sc.Message.Title,
+		Message:     sc.Message.Message,
+		Priority:    sc.Message.Priority,
+		Tags:        sc.Message.Tags,
+		Click:       sc.Message.Click,
+		Icon:        sc.Message.Icon,
+		Actions:     sc.Message.Actions,
+		Attachment:  sc.Message.Attachment,
+		ContentType: sc.Message.ContentType,
+	}
+	if !sc.Paused {
+		response.Next = sc.Next
+	}
+	return response
+}
@@ -0,0 +1,462 @@
+package server
+
+import (
+	"io"
+	"net/http"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"heckel.io/ntfy/v2/user"
+	"heckel.io/ntfy/v2/util"
+)
+
+func TestServer_PublishSchedule(t *testing.T) {
+	t.Parallel()
+	s := newTestServer(t, newTestConfig(t))
+
+	response := request(t, s, "PUT", "/mytopic", "drink some water", map[string]string{
+		"Schedule": "every 1h",
+		"Title":    "Reminder",
+		"Tags":     "droplet",
+	})
+	require.Equal(t, 200, response.Code)
+	sc, err := util.UnmarshalJSON[apiScheduleResponse](io.NopCloser(response.Body))
+	require.Nil(t, err)
+	require.Regexp(t, `^sc_[A-Za-z0-9]{13}$`, sc.ID)
+	require.Equal(t, "mytopic", sc.Topic)
+	require.Equal(t, "every 1h", sc.Schedule)
+	require.Equal(t, "drink some water", sc.Message)
+	require.Equal(t, "Reminder", sc.Title)
+	require.Equal(t, []string{"droplet"}, sc.Tags)
+	require.False(t, sc.Paused)
+	require.Equal(t, int64(0), sc.Last)
+	require.InDelta(t, time.Now().Add(time.Hour).Unix(), sc.Next, 2)
+
+	// Nothing is published right away, or before the schedule is due
+	require.Nil(t, s.sendScheduledMessages())
+	response = request(t, s, "GET", "/mytopic/json?poll=1", "", nil)
+	require.Equal(t, 0, len(toMessages(t, response.Body.String())))
+
+	// Make schedule due, and trigger the delayed sender
+	_, err = s.messageCache.db.Exec(`UPDATE schedules SET next = ?`, time.Now().Add(-time.Second).Unix())
+	require.Nil(t, err)
+	require.Nil(t, s.sendScheduledMessages())
+	response = request(t, s, "GET", "/mytopic/json?poll=1", "", nil)
+	messages := toMessages(t, response.Body.String())
+	require.Equal(t, 1, len(messages))
+	require.Equal(t, "drink some water", messages[0].Message)
+	require.Equal(t, "Reminder", messages[0].Title)
+	require.Equal(t, []string{"droplet"}, messages[0].Tags)
+	require.True(t, validMessageID(messages[0].ID))
+	require.True(t, messages[0].Expires > time.Now().Unix())
+
+	// Next run is in an hour, so no new message
+	require.Nil(t, s.sendScheduledMessages())
+	response = request(t, s, "GET", "/mytopic/json?poll=1", "", nil)
+	require.Equal(t, 1, len(toMessages(t, response.Body.String())))
+
+	schedule, err := s.messageCache.Schedule(sc.ID)
+	require.Nil(t, err)
+	require.InDelta(t, time.Now().Unix(), schedule.Last, 2)
+	require.InDelta(t, time.Now().Add(time.Hour).Unix(), schedule.Next, 2)
+	require.Equal(t, "9.9.9.9", schedule.Message.Sender.String())
+}
+
+func TestServer_PublishSchedule_Acknowledge(t *testing.T) {
+	t.Parallel()
+	s := newTestServer(t, newTestConfig(t))
+
+	response := request(t, s, "PUT", "/mytopic", "check the backups", map[string]string{
+		"Schedule": "every 1h",
+		"Actions":  "acknowledge, Done",
+	})
+	require.Equal(t, 200, response.Code)
+
+	// Every run gets an ack URL with its own message ID
+	for i := 1; i <= 2; i++ {
+		_, err := s.messageCache.db.Exec(`UPDATE schedules SET next = ?`, time.Now().Add(-time.Second).Unix())
+		require.Nil(t, err)
+		require.Nil(t, s.sendScheduledMessages())
+		response = request(t, s, "GET", "/mytopic/json?poll=1", "", nil)
+		messages := toMessages(t, response.Body.String())
+		require.Equal(t, i, len(messages))
+		m := messages[i-1]
+		require.Equal(t, 1, len(m.Actions))
+		require.Equal(t, "http://127.0.0.1:12345/mytopic/"+m.ID+"/ack", m.Actions[0].URL)
+		require.Equal(t, "POST", m.Actions[0].Method)
+
+		response = request(t, s, "POST", "/mytopic/"+m.ID+"/ack", "", nil)
+		require.Equal(t, 200, response.Code)
+	}
+}
+
+func TestServer_PublishSchedule_JSON(t *testing.T) {
+	t.Parallel()
+	s := newTestServer(t, newTestConfig(t))
+
+	response := request(t, s, "POST", "/", `{"topic":"mytopic","message":"standup time","schedule":"CRON_TZ=UTC 0 9 * * mon-fri","priority":4}`, nil)
+	require.Equal(t, 200, response.Code)
+	sc, err := util.UnmarshalJSON[apiScheduleResponse](io.NopCloser(response.Body))
+	require.Nil(t, err)
+	require.Equal(t, "CRON_TZ=UTC 0 9 * * mon-fri", sc.Schedule)
+	require.Equal(t, "standup time", sc.Message)
+	require.Equal(t, 4, sc.Priority)
+	require.Equal(t, 9, time.Unix(sc.Next, 0).UTC().Hour())
+}
+
+func TestServer_PublishSchedule_Invalid(t *testing.T) {
+	t.Parallel()
+	s := newTestServer(t, newTestConfig(t))
+
+	response := request(t, s, "PUT", "/mytopic", "hi", map[string]string{"Schedule": "sometimes"})
+	require.Equal(t, 400, response.Code)
+	require.Equal(t, 40047, toHTTPError(t, response.Body.String()).Code)
+
+	response = request(t, s, "PUT", "/mytopic", "hi", map[string]string{"Schedule": "every 10s"})
+	require.Equal(t, 400, response.Code)
+	require.Equal(t, 40047, toHTTPError(t, response.Body.String()).Code)
+
+	response = request(t, s, "PUT", "/mytopic", "hi", map[string]string{"Schedule": "0 0 30 2 *"})
+	require.Equal(t, 400, response.Code)
+	require.Equal(t, 40047, toHTTPError(t, response.Body.String()).Code)
+
+	for _, headers := range []map[string]string{
+		{"Schedule": "@daily", "In": "1h"},
+		{"Schedule": "@daily", "Cache": "no"},
+		{"Schedule": "@daily", "Replaces": "abcdefghijkl"},
+		{"Schedule": "@daily", "Filename": "file.txt"},
+		{"Schedule": "@daily", "Poll-ID": "abc"},
+	} {
+		response = request(t, s, "PUT", "/mytopic", "hi", headers)
+		require.Equal(t, 400, response.Code)
+		require.Equal(t, 40048, toHTTPError(t, response.Body.String()).Code)
+	}
+
+	schedules, err := s.messageCache.Schedules("mytopic")
+	require.Nil(t, err)
+	require.Equal(t, 0, len(schedules))
+}
+
+func TestServer_PublishSchedule_Limit(t *testing.T) {
+	t.Parallel()
+	s := newTestServer(t, newTestConfig(t))
+
+	for i := 0; i < scheduleTopicLimit; i++ {
+		require.Nil(t, s.messageCache.AddSchedule(newSchedule("mytopic", "@daily", time.Now().Add(time.Hour).Unix(), newDefaultMessage("mytopic", "hi"))))
+	}
+	response := request(t, s, "PUT", "/mytopic", "hi", map[string]string{"Schedule": "@daily"})
+	require.Equal(t, 429, response.Code)
+	require.Equal(t, 42911, toHTTPError(t, response.Body.String()).Code)
+}
+
+func TestServer_PublishSchedule_VisitorLimit(t *testing.T) {
+	t.Parallel()
+	c := newTestConfig(t)
+	c.VisitorScheduleLimit = 2
+	s := newTestServer(t, c)
+
+	for _, topic := range []string{"topic1", "topic2"} {
+		response := request(t, s, "PUT", "/"+topic, "hi", map[string]string{"Schedule": "@daily"})
+		require.Equal(t, 200, response.Code)
+	}
+	response := request(t, s, "PUT", "/topic3", "hi", map[string]string{"Schedule": "@daily"})
+	require.Equal(t, 429, response.Code)
+	require.Equal(t, 42912, toHTTPError(t, response.Body.String()).Code)
+
+	// Other visitors are not affected
+	response = request(t, s, "PUT", "/topic3", "hi", map[string]string{"Schedule": "@daily"}, func(r *http.Request) {
+		r.RemoteAddr = "1.2.3.4:1234"
+	})
+	require.Equal(t, 200, response.Code)
+}
+
+func TestServer_Schedules_AccessRevoked(t *testing.T) {
+	t.Parallel()
+	conf := newTestConfigWithAuthFile(t)
+	conf.AuthDefault = user.PermissionDenyAll
+	s := newTestServer(t, conf)
+	defer s.closeDatabases()
+
+	require.Nil(t, s.userManager.AddUser("ben", "ben", user.RoleUser))
+	require.Nil(t, s.userManager.AllowAccess("ben", "mytopic", user.PermissionReadWrite))
+	response := request(t, s, "PUT", "/mytopic", "ben's reminder", map[string]string{
+		"Authorization": util.BasicAuth("ben", "ben"),
+		"Schedule":      "@hourly",
+	})
+	require.Equal(t, 200, response.Code)
+	sc, err := util.UnmarshalJSON[apiScheduleResponse](io.NopCloser(response.Body))
+	require.Nil(t, err)
+
+	// Access was revoked: recurring message is paused instead of sent
+	require.Nil(t, s.userManager.AllowAccess("ben", "mytopic", user.PermissionRead))
+	_, err = s.messageCache.db.Exec(`UPDATE schedules SET next = ?`, time.Now().Add(-time.Second).Unix())
+	require.Nil(t, err)
+	require.Nil(t, s.sendScheduledMessages())
+	messages, err := s.messageCache.Messages("mytopic", sinceAllMessages, false)
+	require.Nil(t, err)
+	require.Empty(t, messages)
+	paused, err := s.messageCache.Schedule(sc.ID)
+	require.Nil(t, err)
+	require.True(t, paused.Paused)
+
+	// Access was restored: recurring message can be resumed
+	require.Nil(t, s.userManager.AllowAccess("ben", "mytopic", user.PermissionReadWrite))
+	response = request(t, s, "PATCH", "/mytopic/schedules/"+sc.ID, `{"paused":false}`, map[string]string{
+		"Authorization": util.BasicAuth("ben", "ben"),
+	})
+	require.Equal(t, 200, response.Code)
+	_, err = s.messageCache.db.Exec(`UPDATE schedules SET next = ?`, time.Now().Add(-time.Second).Unix())
+	require.Nil(t, err)
+	require.Nil(t, s.sendScheduledMessages())
+	messages, err = s.messageCache.Messages("mytopic", sinceAllMessages, false)
+	require.Nil(t, err)
+	require.Equal(t, 1, len(messages))
+	require.Equal(t, "ben's reminder", messages[0].Message)
+}
+
+func TestServer_Schedules_ListPauseDelete(t *testing.T) {
+	t.Parallel()
+	conf := newTestConfigWithAuthFile(t)
+	conf.AuthDefault = user.PermissionDenyAll
+	s := newTestServer(t, conf)
+	defer s.closeDatabases()
+
+	require.Nil(t, s.userManager.AddUser("phil", "phil", user.RoleAdmin))
+	require.Nil(t, s.userManager.AddUser("ben", "ben", user.RoleUser))
+	require.Nil(t, s.userManager.AddUser("emma", "emma", user.RoleUser))
+	require.Nil(t, s.userManager.AllowAccess("ben", "mytopic", user.PermissionReadWrite))
+	require.Nil(t, s.userManager.AllowAccess("emma", "mytopic", user.PermissionReadWrite))
+
+	response := request(t, s, "PUT", "/mytopic", "ben's reminder", map[string]string{
+		"Authorization": util.BasicAuth("ben", "ben"),
+		"Schedule":      "@hourly",
+	})
+	require.Equal(t, 200, response.Code)
+	sc, err := util.UnmarshalJSON[apiScheduleResponse](io.NopCloser(response.Body))
+	require.Nil(t, err)
+
+	response = request(t, s, "PUT", "/mytopic", "emma's reminder", map[string]string{
+		"Authorization": util.BasicAuth("emma", "emma"),
+		"Schedule":      "@daily",
+	})
+	require.Equal(t, 200, response.Code)
+
+	// Users only see their own schedules, admins see all of them
+	response = request(t, s, "GET", "/mytopic/schedules", "", map[string]string{
+		"Authorization": util.BasicAuth("ben", "ben"),
+	})
+	require.Equal(t, 200, response.Code)
+	schedules, err := util.UnmarshalJSON[[]*apiScheduleResponse](io.NopCloser(response.Body))
+	require.Nil(t, err)
+	require.Equal(t, 1, len(*schedules))
+	require.Equal(t, "ben's reminder", (*schedules)[0].Message)
+
+	response = request(t, s, "GET", "/mytopic/schedules", "", map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 200, response.Code)
+	schedules, err = util.UnmarshalJSON[[]*apiScheduleResponse](io.NopCloser(response.Body))
+	require.Nil(t, err)
+	require.Equal(t, 2, len(*schedules))
+
+	// Anonymous users have no write access
+	response = request(t, s, "GET", "/mytopic/schedules", "", nil)
+	require.Equal(t, 403, response.Code)
+
+	// Emma cannot pause or delete Ben's schedule
+	response = request(t, s, "PATCH", "/mytopic/schedules/"+sc.ID, `{"paused":true}`, map[string]string{
+		"Authorization": util.BasicAuth("emma", "emma"),
+	})
+	require.Equal(t, 404, response.Code)
+	require.Equal(t, 40402, toHTTPError(t, response.Body.String()).Code)
+
+	response = request(t, s, "DELETE", "/mytopic/schedules/"+sc.ID, "", map[string]string{
+		"Authorization": util.BasicAuth("emma", "emma"),
+	})
+	require.Equal(t, 404, response.Code)
+
+	// Pause: paused schedules are not sent
+	response = request(t, s, "PATCH", "/mytopic/schedules/"+sc.ID, `{"paused":true}`, map[string]string{
+		"Authorization": util.BasicAuth("ben", "ben"),
+	})
+	require.Equal(t, 200, response.Code)
+	updated, err := util.UnmarshalJSON[apiScheduleResponse](io.NopCloser(response.Body))
+	require.Nil(t, err)
+	require.True(t, updated.Paused)
+	require.Equal(t, int64(0), updated.Next)
+
+	_, err = s.messageCache.db.Exec(`UPDATE schedules SET next = ?`, time.Now().Add(-time.Second).Unix())
+	require.Nil(t, err)
+	require.Nil(t, s.sendScheduledMessages())
+	response = request(t, s, "GET", "/mytopic/json?poll=1", "", map[string]string{
+		"Authorization": util.BasicAuth("ben", "ben"),
+	})
+	messages := toMessages(t, response.Body.String())
+	require.Equal(t, 1, len(messages))
+	require.Equal(t, "emma's reminder", messages[0].Message)
+
+	// Resume: missed runs are not caught up on
+	response = request(t, s, "PATCH", "/mytopic/schedules/"+sc.ID, `{"paused":false}`, map[string]string{
+		"Authorization": util.BasicAuth("ben", "ben"),
+	})
+	require.Equal(t, 200, response.Code)
+	updated, err = util.UnmarshalJSON[apiScheduleResponse](io.NopCloser(response.Body))
+	require.Nil(t, err)
+	require.False(t, updated.Paused)
+	require.True(t, updated.Next > time.Now().Unix())
+
+	// Delete
+	response = request(t, s, "DELETE", "/mytopic/schedules/"+sc.ID, "", map[string]string{
+		"Authorization": util.BasicAuth("ben", "ben"),
+	})
+	require.Equal(t, 200, response.Code)
+
+	response = request(t, s, "DELETE", "/mytopic/schedules/"+sc.ID, "", map[string]string{
+		"Authorization": util.BasicAuth("ben", "ben"),
+	})
+	require.Equal(t, 404, response.Code)
+
+	// Schedules of deleted users are removed
+	require.Nil(t, s.userManager.RemoveUser("emma"))
+	_, err = s.messageCache.db.Exec(`UPDATE schedules SET next = ?`, time.Now().Add(-time.Second).Unix())
+	require.Nil(t, err)
+	require.Nil(t, s.sendScheduledMessages())
+	all, err := s.messageCache.Schedules("mytopic")
+	require.Nil(t, err)
+	require.Equal(t, 0, len(all))
+}
+
+func TestServer_Schedules_WrongTopic(t *testing.T) {
+	t.Parallel()
+	s := newTestServer(t, newTestConfig(t))
+
+	response := request(t, s, "PUT", "/mytopic", "hi", map[string]string{"Schedule": "@daily"})
+	require.Equal(t, 200, response.Code)
+	sc, err := util.UnmarshalJSON[apiScheduleResponse](io.NopCloser(response.Body))
+	require.Nil(t, err)
+
+	response = request(t, s, "DELETE", "/othertopic/schedules/"+sc.ID, "", nil)
+	require.Equal(t, 404, response.Code)
+
+	response = request(t, s, "DELETE", "/mytopic/schedules/"+sc.ID, "", nil)
+	require.Equal(t, 200, response.Code)
+}
+
+func TestServer_ScheduledMessages_ListCancel(t *testing.T) {
+	t.Parallel()
+	conf := newTestConfigWithAuthFile(t)
+	conf.AuthDefault = user.PermissionDenyAll
+	s := newTestServer(t, conf)
+	defer s.closeDatabases()
+
+	require.Nil(t, s.userManager.AddUser("phil", "phil", user.RoleAdmin))
+	require.Nil(t, s.userManager.AddUser("ben", "ben", user.RoleUser))
+	require.Nil(t, s.userManager.AddUser("emma", "emma", user.RoleUser))
+	require.Nil(t, s.userManager.AllowAccess("ben", "mytopic", user.PermissionReadWrite))
+	require.Nil(t, s.userManager.AllowAccess("emma", "mytopic", user.PermissionReadWrite))
+
+	response := request(t, s, "PUT", "/mytopic", "ben's message", map[string]string{
+		"Authorization": util.BasicAuth("ben", "ben"),
+		"In":            "1h",
+	})
+	require.Equal(t, 200, response.Code)
+	m := toMessage(t, response.Body.String())
+
+	response = request(t, s, "PUT", "/mytopic", "emma's message", map[string]string{
+		"Authorization": util.BasicAuth("emma", "emma"),
+		"In":            "2h",
+	})
+	require.Equal(t, 200, response.Code)
+
+	response = request(t, s, "PUT", "/mytopic", "published right away", map[string]string{
+		"Authorization": util.BasicAuth("ben", "ben"),
+	})
+	require.Equal(t, 200, response.Code)
+	published := toMessage(t, response.Body.String())
+
+	// Users only see their own scheduled messages, admins see all of them
+	response = request(t, s, "GET", "/mytopic/scheduled", "", map[string]string{
+		"Authorization": util.BasicAuth("ben", "ben"),
+	})
+	require.Equal(t, 200, response.Code)
+	messages, err := util.UnmarshalJSON[[]*message](io.NopCloser(response.Body))
+	require.Nil(t, err)
+	require.Equal(t, 1, len(*messages))
+	require.Equal(t, m.ID, (*messages)[0].ID)
+	require.Equal(t, "ben's message", (*messages)[0].Message)
+
+	response = request(t, s, "GET", "/mytopic/scheduled", "", map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 200, response.Code)
+	messages, err = util.UnmarshalJSON[[]*message](io.NopCloser(response.Body))
+	require.Nil(t, err)
+	require.Equal(t, 2, len(*messages))
+	require.Equal(t, "ben's message", (*messages)[0].Message)
+	require.Equal(t, "emma's message", (*messages)[1].Message)
+
+	// Anonymous users have no write access
+	response = request(t, s, "GET", "/mytopic/scheduled", "", nil)
+	require.Equal(t, 403, response.Code)
+
+	// Emma cannot cancel Ben's message, and published messages cannot be cancelled
+	response = request(t, s, "DELETE", "/mytopic/scheduled/"+m.ID, "", map[string]string{
+		"Authorization": util.BasicAuth("emma", "emma"),
+	})
+	require.Equal(t, 404, response.Code)
+	require.Equal(t, 40403, toHTTPError(t, response.Body.String()).Code)
+
+	response = request(t, s, "DELETE", "/mytopic/scheduled/"+published.ID, "", map[string]string{
+		"Authorization": util.BasicAuth("ben", "ben"),
+	})
+	require.Equal(t, 404, response.Code)
+	require.Equal(t, 40403, toHTTPError(t, response.Body.String()).Code)
+
+	// Cancel: cancelled messages are never sent
+	response = request(t, s, "DELETE", "/mytopic/scheduled/"+m.ID, "", map[string]string{
+		"Authorization": util.BasicAuth("ben", "ben"),
+	})
+	require.Equal(t, 200, response.Code)
+
+	response = request(t, s, "DELETE", "/mytopic/scheduled/"+m.ID, "", map[string]string{
+		"Authorization": util.BasicAuth("ben", "ben"),
+	})
+	require.Equal(t, 404, response.Code)
+
+	_, err = s.messageCache.db.Exec(`UPDATE messages SET time = ? WHERE published = 0`, time.Now().Unix())
+	require.Nil(t, err)
+	require.Nil(t, s.sendDelayedMessages())
+	response = request(t, s, "GET", "/mytopic/json?poll=1", "", map[string]string{
+		"Authorization": util.BasicAuth("ben", "ben"),
+	})
+	messagesPolled := toMessages(t, response.Body.String())
+	require.Equal(t, 2, len(messagesPolled))
+	require.ElementsMatch(t, []string{"published right away", "emma's message"}, []string{messagesPolled[0].Message, messagesPolled[1].Message})
+}
+
+func TestServer_ScheduledMessages_CancelWithAttachment(t *testing.T) {
+	t.Parallel()
+	s := newTestServer(t, newTestConfig(t))
+
+	response := request(t, s, "PUT", "/mytopic", "some file content", map[string]string{
+		"Filename": "file.txt",
+		"Delay":    "1h",
+	})
+	require.Equal(t, 200, response.Code)
+	m := toMessage(t, response.Body.String())
+	require.NotNil(t, m.Attachment)
+	require.FileExists(t, filepath.Join(s.config.AttachmentCacheDir, m.ID))
+
+	response = request(t, s, "DELETE", "/othertopic/scheduled/"+m.ID, "", nil)
+	require.Equal(t, 404, response.Code)
+
+	response = request(t, s, "DELETE", "/mytopic/scheduled/"+m.ID, "", nil)
+	require.Equal(t, 200, response.Code)
+	require.NoFileExists(t, filepath.Join(s.config.AttachmentCacheDir, m.ID))
+
+	response = request(t, s, "GET", "/mytopic/scheduled", "", nil)
+	require.Equal(t, 200, response.Code)
+	require.Equal(t, "[]\n", response.Body.String())
+}
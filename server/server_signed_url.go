@@ -0,0 +1,231 @@
+package server
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"heckel.io/ntfy/v2/log"
+	"heckel.io/ntfy/v2/user"
+	"heckel.io/ntfy/v2/util"
+)
+
+const (
+	// MinSignedURLSecretLength is the minimum length of the secret used to sign publish URLs
+	MinSignedURLSecretLength = 32
+
+	// DefaultSignedURLExpiryDuration is the validity of a signed URL if no expiry time is passed
+	DefaultSignedURLExpiryDuration = 30 * 24 * time.Hour
+
+	// DefaultSignedURLMaxExpiryDuration is the maximum validity of signed URLs created by topic owners via the API
+	DefaultSignedURLMaxExpiryDuration = 90 * 24 * time.Hour
+
+	signedURLSignatureParam  = "sig"
+	signedURLExpiresParam    = "exp"
+	signedURLUserParam       = "uid"
+	signedURLGenerationParam = "gen"
+)
+
+var (
+	// signedURLAllowedHeaders are the only request headers that are kept for requests to signed URLs, so that
+	// fixed parameters cannot be overridden via headers (e.g. X-Priority instead of ?priority=...)
+	signedURLAllowedHeaders = []string{"Content-Type", "Content-Length", "Content-Encoding", "User-Agent"}
+
+	signedURLReservedParams   = []string{signedURLSignatureParam, signedURLExpiresParam, signedURLUserParam, signedURLGenerationParam}
+	errSignedURLReservedParam = errors.New("parameters sig, exp, uid and gen are reserved")
+)
+
+// SignPublishURL creates an HMAC-signed publish URL (<baseURL>/<topic>/publish?...&exp=...&sig=...) for the
+// given topic. The URL can be used to publish to the topic without a user account until it expires, or until
+// the topic's signed URL generation changes (see user.Manager.RevokeSignedURLs). If userID is set, the URL is
+// only valid as long as this user still owns the topic reservation (or is an admin). The optional params are
+// fixed, i.e. they are covered by the signature and cannot be changed by the caller.
+func SignPublishURL(secret, baseURL, topic, userID string, generation int64, params url.Values, expires time.Time) (string, error) {
+	if !topicRegex.MatchString(topic) {
+		return "", errHTTPBadRequestTopicInvalid
+	}
+	signedParams := url.Values{}
+	for key, values := range params {
+		if util.Contains(signedURLReservedParams, strings.ToLower(key)) {
+			return "", errSignedURLReservedParam
+		}
+		signedParams[strings.ToLower(key)] = values
+	}
+	if userID != "" {
+		signedParams.Set(signedURLUserParam, userID)
+	}
+	signedParams.Set(signedURLGenerationParam, strconv.FormatInt(generation, 10))
+	signedParams.Set(signedURLExpiresParam, strconv.FormatInt(expires.Unix(), 10))
+	signedParams.Set(signedURLSignatureParam, signedURLSignature(secret, topic, signedParams))
+	return fmt.Sprintf("%s/%s/publish?%s", strings.TrimSuffix(baseURL, "/"), topic, signedParams.Encode()), nil
+}
+
+// signedURLSignature calculates the signature over the topic and all query parameters, except for the signature itself
+func signedURLSignature(secret, topic string, params url.Values) string {
+	unsigned := url.Values{}
+	for key, values := range params {
+		if key != signedURLSignatureParam {
+			unsigned[key] = values
+		}
+	}
+	h := hmac.New(sha256.New, []byte(secret))
+	h.Write([]byte(topic + "\n" + unsigned.Encode())) // Encode sorts by key
+	return base64.RawURLEncoding.EncodeToString(h.Sum(nil))
+}
+
+// authorizeTopicWriteOrSignedURL authorizes requests to signed publish URLs (see SignPublishURL) via the URL's
+// signature, instead of the access control list. All other requests are authorized via authorizeTopicWrite.
+func (s *Server) authorizeTopicWriteOrSignedURL(next handleFunc) handleFunc {
+	authorizeTopicWrite := s.authorizeTopicWrite(next)
+	return func(w http.ResponseWriter, r *http.Request, v *visitor) error {
+		if !r.URL.Query().Has(signedURLSignatureParam) {
+			return authorizeTopicWrite(w, r, v)
+		}
+		topic, err := s.verifySignedURL(r)
+		if err != nil {
+			logvr(v, r).Tag(tagPublish).Err(err).Debug("Signed URL rejected")
+			return errHTTPForbiddenSignedURLInvalid
+		}
+		header := http.Header{}
+		for _, name := range signedURLAllowedHeaders {
+			if value := r.Header.Get(name); value != "" {
+				header.Set(name, value)
+			}
+		}
+		r.Header = header
+		logvr(v, r).Tag(tagPublish).Field("topic", topic).Debug("Authorized publishing to topic %s via signed URL", topic)
+		return next(w, r, v)
+	}
+}
+
+// verifySignedURL checks the signature, expiry time and generation of a signed publish URL and returns the topic.
+// For URLs created by a user, it also checks that the user still owns the topic reservation (or is an admin).
+func (s *Server) verifySignedURL(r *http.Request) (string, error) {
+	if s.config.SignedURLSecret == "" || s.userManager == nil {
+		return "", errors.New("signed URLs are not enabled")
+	}
+	topic := strings.Split(strings.TrimPrefix(r.URL.Path, "/"), "/")[0]
+	params := r.URL.Query()
+	expires, err := strconv.ParseInt(params.Get(signedURLExpiresParam), 10, 64)
+	if err != nil {
+		return "", errors.New("expiry time missing or invalid")
+	} else if time.Now().Unix() > expires {
+		return "", errors.New("signed URL expired")
+	}
+	expected := signedURLSignature(s.config.SignedURLSecret, topic, params)
+	if !hmac.Equal([]byte(params.Get(signedURLSignatureParam)), []byte(expected)) {
+		return "", errors.New("signature invalid")
+	}
+	generation, err := s.userManager.SignedURLGeneration(topic)
+	if err != nil {
+		return "", err
+	} else if params.Get(signedURLGenerationParam) != strconv.FormatInt(generation, 10) {
+		return "", errors.New("signed URL revoked")
+	}
+	if userID := params.Get(signedURLUserParam); userID != "" {
+		u, err := s.userManager.UserByID(userID)
+		if err != nil {
+			return "", fmt.Errorf("owner of signed URL not found: %w", err)
+		} else if !u.IsAdmin() {
+			hasReservation, err := s.userManager.HasReservation(u.Name, topic)
+			if err != nil {
+				return "", err
+			} else if !hasReservation {
+				return "", errors.New("owner of signed URL no longer owns the topic")
+			}
+		}
+	}
+	return topic, nil
+}
+
+func (s *Server) handleAccountReservationSignedURLCreate(w http.ResponseWriter, r *http.Request, v *visitor) error {
+	u := v.User()
+	req, err := readJSONWithLimit[apiAccountSignedURLRequest](r.Body, jsonBodyBytesLimit, true) // Allow empty body!
+	if err != nil {
+		return err
+	}
+	matches := apiAccountReservationSignedURLRegex.FindStringSubmatch(r.URL.Path)
+	if len(matches) != 2 {
+		return errHTTPInternalErrorInvalidPath
+	}
+	topic := matches[1]
+	if err := s.checkSignedURLOwner(u, topic); err != nil {
+		return err
+	}
+	maxExpires := time.Now().Add(s.config.SignedURLMaxExpiryDuration)
+	expires := time.Now().Add(DefaultSignedURLExpiryDuration)
+	if req.Expires != nil {
+		expires = time.Unix(*req.Expires, 0)
+	} else if expires.After(maxExpires) {
+		expires = maxExpires
+	}
+	if !expires.After(time.Now()) || expires.After(maxExpires) {
+		return errHTTPBadRequestSignedURLInvalid
+	}
+	params := url.Values{}
+	for key, value := range req.Params {
+		params.Set(key, value)
+	}
+	generation, err := s.userManager.SignedURLGeneration(topic)
+	if err != nil {
+		return err
+	}
+	signedURL, err := SignPublishURL(s.config.SignedURLSecret, s.config.BaseURL, topic, u.ID, generation, params, expires)
+	if errors.Is(err, errSignedURLReservedParam) {
+		return errHTTPBadRequestSignedURLInvalid
+	} else if err != nil {
+		return err
+	}
+	logvr(v, r).
+		Tag(tagAccount).
+		Fields(log.Context{
+			"topic":   topic,
+			"expires": expires,
+		}).
+		Debug("Created signed publish URL for topic %s", topic)
+	s.audit(r, v, user.AuditActionSignedURLCreate, u.Name, fmt.Sprintf("topic=%s expires=%d", topic, expires.Unix()))
+	return s.writeJSON(w, &apiAccountSignedURLResponse{
+		URL:     signedURL,
+		Expires: expires.Unix(),
+	})
+}
+
+// handleAccountReservationSignedURLRevoke invalidates all signed URLs of a topic, including the ones
+// created with "ntfy token sign-url"
+func (s *Server) handleAccountReservationSignedURLRevoke(w http.ResponseWriter, r *http.Request, v *visitor) error {
+	u := v.User()
+	matches := apiAccountReservationSignedURLRegex.FindStringSubmatch(r.URL.Path)
+	if len(matches) != 2 {
+		return errHTTPInternalErrorInvalidPath
+	}
+	topic := matches[1]
+	if err := s.checkSignedURLOwner(u, topic); err != nil {
+		return err
+	}
+	if err := s.userManagerAs(v, "").RevokeSignedURLs(topic); err != nil {
+		return err
+	}
+	logvr(v, r).Tag(tagAccount).Field("topic", topic).Debug("Revoked signed publish URLs for topic %s", topic)
+	return s.writeJSON(w, newSuccessResponse())
+}
+
+// checkSignedURLOwner returns errHTTPForbidden, unless the user is an admin or owns the topic reservation
+func (s *Server) checkSignedURLOwner(u *user.User, topic string) error {
+	if u.IsAdmin() {
+		return nil
+	}
+	hasReservation, err := s.userManager.HasReservation(u.Name, topic)
+	if err != nil {
+		return err
+	} else if !hasReservation {
+		return errHTTPForbidden
+	}
+	return nil
+}
@@ -0,0 +1,258 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"heckel.io/ntfy/v2/user"
+	"heckel.io/ntfy/v2/util"
+)
+
+func TestServer_SignedURL_Publish(t *testing.T) {
+	s := newTestServer(t, newTestConfigWithSignedURLs(t))
+	defer s.closeDatabases()
+	require.Nil(t, s.userManager.AddUser("phil", "phil", user.RoleAdmin))
+
+	// Publish via GET with fixed parameters
+	signedURL, err := SignPublishURL(s.config.SignedURLSecret, s.config.BaseURL, "mytopic", "", 0, url.Values{"title": {"Backups"}, "message": {"Backup done"}}, time.Now().Add(time.Hour))
+	require.Nil(t, err)
+	require.True(t, strings.HasPrefix(signedURL, "http://127.0.0.1:12345/mytopic/publish?"))
+	rr := request(t, s, "GET", strings.TrimPrefix(signedURL, s.config.BaseURL), "", map[string]string{
+		"X-Title": "Overridden", // Ignored, fixed parameters cannot be overridden
+	})
+	require.Equal(t, 200, rr.Code)
+	m := toMessage(t, rr.Body.String())
+	require.Equal(t, "Backups", m.Title)
+	require.Equal(t, "Backup done", m.Message)
+
+	// Publish via POST, the body is the message
+	signedURL, err = SignPublishURL(s.config.SignedURLSecret, s.config.BaseURL, "mytopic", "", 0, nil, time.Now().Add(time.Hour))
+	require.Nil(t, err)
+	rr = request(t, s, "POST", strings.TrimPrefix(signedURL, s.config.BaseURL), "webhook body", nil)
+	require.Equal(t, 200, rr.Code)
+	require.Equal(t, "webhook body", toMessage(t, rr.Body.String()).Message)
+
+	// Both messages were published
+	rr = request(t, s, "GET", "/mytopic/json?poll=1", "", map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 200, rr.Code)
+	messages := toMessages(t, rr.Body.String())
+	require.Equal(t, 2, len(messages))
+	require.Equal(t, "Backup done", messages[0].Message)
+	require.Equal(t, "webhook body", messages[1].Message)
+
+	// Without signature, the ACL applies
+	rr = request(t, s, "POST", "/mytopic/publish", "not allowed", nil)
+	require.Equal(t, 403, rr.Code)
+	require.Equal(t, 40301, toHTTPError(t, rr.Body.String()).Code)
+}
+
+func TestServer_SignedURL_Invalid(t *testing.T) {
+	s := newTestServer(t, newTestConfigWithSignedURLs(t))
+	defer s.closeDatabases()
+
+	signedURL, err := SignPublishURL(s.config.SignedURLSecret, "", "mytopic", "", 0, url.Values{"priority": {"low"}}, time.Now().Add(time.Hour))
+	require.Nil(t, err)
+
+	// Tampered parameters, added parameters, other topic
+	for _, path := range []string{
+		strings.Replace(signedURL, "priority=low", "priority=urgent", 1),
+		signedURL + "&tags=skull",
+		strings.Replace(signedURL, "/mytopic/", "/othertopic/", 1),
+		strings.Replace(signedURL, "sig=", "sig=x", 1),
+		strings.Replace(signedURL, "gen=0", "gen=1", 1),
+	} {
+		rr := request(t, s, "GET", path, "", nil)
+		require.Equal(t, 403, rr.Code, path)
+		require.Equal(t, 40303, toHTTPError(t, rr.Body.String()).Code)
+	}
+
+	// Expired
+	signedURL, err = SignPublishURL(s.config.SignedURLSecret, "", "mytopic", "", 0, nil, time.Now().Add(-time.Minute))
+	require.Nil(t, err)
+	rr := request(t, s, "GET", signedURL, "", nil)
+	require.Equal(t, 403, rr.Code)
+	require.Equal(t, 40303, toHTTPError(t, rr.Body.String()).Code)
+
+	// Signed with a different secret
+	signedURL, err = SignPublishURL("some other secret that is long enough", "", "mytopic", "", 0, nil, time.Now().Add(time.Hour))
+	require.Nil(t, err)
+	rr = request(t, s, "GET", signedURL, "", nil)
+	require.Equal(t, 403, rr.Code)
+	require.Equal(t, 40303, toHTTPError(t, rr.Body.String()).Code)
+
+	// Reserved parameters
+	_, err = SignPublishURL(s.config.SignedURLSecret, "", "mytopic", "", 0, url.Values{"exp": {"123"}}, time.Now().Add(time.Hour))
+	require.Equal(t, errSignedURLReservedParam, err)
+	_, err = SignPublishURL(s.config.SignedURLSecret, "", "mytopic", "", 0, url.Values{"UID": {"u_123"}}, time.Now().Add(time.Hour))
+	require.Equal(t, errSignedURLReservedParam, err)
+}
+
+func TestServer_SignedURL_Disabled(t *testing.T) {
+	conf := newTestConfigWithSignedURLs(t)
+	secret := conf.SignedURLSecret
+	conf.SignedURLSecret = ""
+	s := newTestServer(t, conf)
+	defer s.closeDatabases()
+	require.Nil(t, s.userManager.AddUser("phil", "phil", user.RoleAdmin))
+
+	signedURL, err := SignPublishURL(secret, "", "mytopic", "", 0, nil, time.Now().Add(time.Hour))
+	require.Nil(t, err)
+	rr := request(t, s, "GET", signedURL, "", nil)
+	require.Equal(t, 403, rr.Code)
+	require.Equal(t, 40303, toHTTPError(t, rr.Body.String()).Code)
+
+	rr = request(t, s, "POST", "/v1/account/reservation/mytopic/signed-url", "", map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 404, rr.Code)
+}
+
+func TestAccount_Reservation_SignedURL(t *testing.T) {
+	s := newTestServer(t, newTestConfigWithSignedURLs(t))
+	defer s.closeDatabases()
+
+	require.Nil(t, s.userManager.AddUser("phil", "phil", user.RoleUser))
+	require.Nil(t, s.userManager.AddUser("ben", "ben", user.RoleUser))
+	require.Nil(t, s.userManager.AddReservation("phil", "mytopic", user.PermissionDenyAll))
+
+	// Owner can create signed URLs
+	expires := time.Now().Add(2 * time.Hour).Unix()
+	rr := request(t, s, "POST", "/v1/account/reservation/mytopic/signed-url", fmt.Sprintf(`{"expires":%d,"params":{"title":"CI"}}`, expires), map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 200, rr.Code)
+	resp, err := util.UnmarshalJSON[apiAccountSignedURLResponse](io.NopCloser(rr.Body))
+	require.Nil(t, err)
+	require.Equal(t, expires, resp.Expires)
+	require.Contains(t, resp.URL, "title=CI")
+
+	rr = request(t, s, "POST", strings.TrimPrefix(resp.URL, s.config.BaseURL), "build failed", nil)
+	require.Equal(t, 200, rr.Code)
+	m := toMessage(t, rr.Body.String())
+	require.Equal(t, "CI", m.Title)
+	require.Equal(t, "build failed", m.Message)
+
+	// Default expiry
+	rr = request(t, s, "POST", "/v1/account/reservation/mytopic/signed-url", "", map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 200, rr.Code)
+	resp, err = util.UnmarshalJSON[apiAccountSignedURLResponse](io.NopCloser(rr.Body))
+	require.Nil(t, err)
+	require.True(t, resp.Expires > time.Now().Add(DefaultSignedURLExpiryDuration-time.Minute).Unix())
+
+	// Other users cannot
+	rr = request(t, s, "POST", "/v1/account/reservation/mytopic/signed-url", "", map[string]string{
+		"Authorization": util.BasicAuth("ben", "ben"),
+	})
+	require.Equal(t, 403, rr.Code)
+
+	// Invalid requests
+	rr = request(t, s, "POST", "/v1/account/reservation/mytopic/signed-url", `{"expires":1}`, map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 400, rr.Code)
+	require.Equal(t, 40053, toHTTPError(t, rr.Body.String()).Code)
+	rr = request(t, s, "POST", "/v1/account/reservation/mytopic/signed-url", `{"params":{"sig":"abc"}}`, map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 400, rr.Code)
+	require.Equal(t, 40053, toHTTPError(t, rr.Body.String()).Code)
+	rr = request(t, s, "POST", "/v1/account/reservation/mytopic/signed-url", fmt.Sprintf(`{"expires":%d}`, time.Now().Add(DefaultSignedURLMaxExpiryDuration+time.Hour).Unix()), map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 400, rr.Code)
+	require.Equal(t, 40053, toHTTPError(t, rr.Body.String()).Code)
+}
+
+func TestAccount_Reservation_SignedURL_MaxExpiry(t *testing.T) {
+	conf := newTestConfigWithSignedURLs(t)
+	conf.SignedURLMaxExpiryDuration = time.Hour
+	s := newTestServer(t, conf)
+	defer s.closeDatabases()
+	require.Nil(t, s.userManager.AddUser("phil", "phil", user.RoleAdmin))
+
+	// The default expiry is capped at the maximum
+	rr := request(t, s, "POST", "/v1/account/reservation/mytopic/signed-url", "", map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 200, rr.Code)
+	resp, err := util.UnmarshalJSON[apiAccountSignedURLResponse](io.NopCloser(rr.Body))
+	require.Nil(t, err)
+	require.LessOrEqual(t, resp.Expires, time.Now().Add(time.Hour).Unix())
+
+	// URLs created by an admin stop working when the user is no longer an admin
+	rr = request(t, s, "POST", strings.TrimPrefix(resp.URL, s.config.BaseURL), "admin", nil)
+	require.Equal(t, 200, rr.Code)
+	require.Nil(t, s.userManager.ChangeRole("phil", user.RoleUser))
+	rr = request(t, s, "POST", strings.TrimPrefix(resp.URL, s.config.BaseURL), "no longer admin", nil)
+	require.Equal(t, 403, rr.Code)
+}
+
+func TestAccount_Reservation_SignedURL_Revoke(t *testing.T) {
+	s := newTestServer(t, newTestConfigWithSignedURLs(t))
+	defer s.closeDatabases()
+	require.Nil(t, s.userManager.AddUser("phil", "phil", user.RoleUser))
+	require.Nil(t, s.userManager.AddUser("ben", "ben", user.RoleUser))
+	require.Nil(t, s.userManager.AddReservation("phil", "mytopic", user.PermissionDenyAll))
+
+	createSignedURL := func() string {
+		rr := request(t, s, "POST", "/v1/account/reservation/mytopic/signed-url", "", map[string]string{
+			"Authorization": util.BasicAuth("phil", "phil"),
+		})
+		require.Equal(t, 200, rr.Code)
+		resp, err := util.UnmarshalJSON[apiAccountSignedURLResponse](io.NopCloser(rr.Body))
+		require.Nil(t, err)
+		return strings.TrimPrefix(resp.URL, s.config.BaseURL)
+	}
+	signedURL := createSignedURL()
+	rr := request(t, s, "POST", signedURL, "works", nil)
+	require.Equal(t, 200, rr.Code)
+
+	// Other users cannot revoke
+	rr = request(t, s, "DELETE", "/v1/account/reservation/mytopic/signed-url", "", map[string]string{
+		"Authorization": util.BasicAuth("ben", "ben"),
+	})
+	require.Equal(t, 403, rr.Code)
+
+	// Revoking invalidates existing URLs, but not new ones
+	rr = request(t, s, "DELETE", "/v1/account/reservation/mytopic/signed-url", "", map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 200, rr.Code)
+	rr = request(t, s, "POST", signedURL, "revoked", nil)
+	require.Equal(t, 403, rr.Code)
+	require.Equal(t, 40303, toHTTPError(t, rr.Body.String()).Code)
+	signedURL = createSignedURL()
+	rr = request(t, s, "POST", signedURL, "works again", nil)
+	require.Equal(t, 200, rr.Code)
+
+	// URLs stop working when the owner loses the reservation, even if it is reserved again
+	require.Nil(t, s.userManager.RemoveReservations("phil", "mytopic"))
+	rr = request(t, s, "POST", signedURL, "no longer owner", nil)
+	require.Equal(t, 403, rr.Code)
+	require.Nil(t, s.userManager.AddReservation("phil", "mytopic", user.PermissionDenyAll))
+	rr = request(t, s, "POST", signedURL, "reserved again", nil)
+	require.Equal(t, 403, rr.Code)
+
+	// URLs stop working when the owner is removed
+	signedURL = createSignedURL()
+	require.Nil(t, s.userManager.RemoveUser("phil"))
+	rr = request(t, s, "POST", signedURL, "owner removed", nil)
+	require.Equal(t, 403, rr.Code)
+}
+
+func newTestConfigWithSignedURLs(t *testing.T) *Config {
+	conf := newTestConfigWithAuthFile(t)
+	conf.BaseURL = "http://127.0.0.1:12345"
+	conf.AuthDefault = user.PermissionDenyAll
+	conf.SignedURLSecret = "Ayee7ieL5ahcee0zohth6aijeiT4eesh"
+	return conf
+}
@@ -0,0 +1,268 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"text/template"
+	"text/template/parse"
+	"time"
+	"unicode/utf8"
+
+	"heckel.io/ntfy/v2/util"
+)
+
+// Message templates:
+//
+// If the "template" param is set, the request body is not used as the message. Instead, it is parsed as JSON, and the
+// title and message are rendered as Go templates (see https://pkg.go.dev/text/template) with the JSON body as data.
+// This allows services that can only send a fixed JSON payload (GitHub, Grafana, Sentry, ...) to publish readable
+// messages without a proxy in between:
+//
+//   - ?template=yes: The "title" and "message" params are the templates, e.g. ?message={{.alert.title}}. Inline
+//     templates are only allowed for authenticated users.
+//   - ?template=<name>: The title and message templates are taken from the named template defined in the
+//     message-templates config option
+//
+// Since templates can loop over the data, each template has an execution budget: defining and calling other
+// templates is not allowed, control structures can only be nested templateMaxDepth levels deep, and the estimated
+// number of steps must not exceed templateMaxSteps (see checkTemplateBudget). Rendering is also aborted if the
+// output exceeds the message limit, or if it takes longer than templateExecTimeout.
+
+const (
+	templateBodyBytesLimit = 262144          // Max number of bytes for a JSON request body when using templates
+	templateMaxDepth       = 4               // Max nesting depth of range, if and with actions
+	templateMaxSteps       = 100000          // Max estimated number of evaluation steps, see checkTemplateBudget
+	templateMaxFormatWidth = 999             // Max width and precision of printf verbs, e.g. %999d
+	templateExecTimeout    = 2 * time.Second // Max time to render a template
+)
+
+var (
+	templateFormatVerbRegex = regexp.MustCompile(`%[-+# 0]*(?:\[\d+])?(\*|\d+)?(?:\.(\*|\d+))?`)
+)
+
+// MessageTemplate is a named server-side template, as defined in the message-templates config option. The
+// title and message are Go templates that are rendered with the JSON request body as data.
+type MessageTemplate struct {
+	Name    string `json:"name"`
+	Title   string `json:"title"`
+	Message string `json:"message"`
+}
+
+// Validate checks that the template has a name, and that the title and message templates can be parsed
+func (t *MessageTemplate) Validate() error {
+	if t.Name == "" || isBoolValue(strings.ToLower(t.Name)) {
+		return errors.New("name must be set, and must not be a boolean value like 'yes' or '1'")
+	} else if t.Message == "" {
+		return errors.New("message must be set")
+	}
+	if _, err := template.New("title").Parse(t.Title); err != nil {
+		return err
+	}
+	if _, err := template.New("message").Parse(t.Message); err != nil {
+		return err
+	}
+	return nil
+}
+
+// parseTemplateParam returns the message template for the request, if the "template" param is set. If it is a
+// boolean value, the title and message params are used as templates, which is only allowed for authenticated
+// visitors. Otherwise, the param is the name of a server-side template. If the param is not set (or false), nil
+// is returned.
+func parseTemplateParam(r *http.Request, v *visitor, templates map[string]*MessageTemplate) (*MessageTemplate, *errHTTP) {
+	value := readParam(r, "x-template", "template", "tpl")
+	if value == "" || (isBoolValue(strings.ToLower(value)) && !toBool(strings.ToLower(value))) {
+		return nil, nil
+	} else if toBool(strings.ToLower(value)) {
+		if v.User() == nil {
+			return nil, errHTTPForbiddenTemplateInline
+		}
+		return &MessageTemplate{
+			Title:   readParam(r, "x-title", "title", "t"),
+			Message: strings.ReplaceAll(readParam(r, "x-message", "message", "m"), "\\n", "\n"),
+		}, nil
+	}
+	tpl, ok := templates[value]
+	if !ok {
+		return nil, errHTTPBadRequestTemplateInvalid.Wrap("unknown template %s", value)
+	}
+	return tpl, nil
+}
+
+// handleBodyAsTemplatedTextMessage parses the body as JSON, and renders the title and message templates with it.
+// Rendered messages longer than the message limit are rejected.
+func (s *Server) handleBodyAsTemplatedTextMessage(ctx context.Context, m *message, tpl *MessageTemplate, body *util.PeekedReadCloser) error {
+	if body.LimitReached {
+		return errHTTPEntityTooLargeJSONBody.With(m)
+	} else if !utf8.Valid(body.PeekedBytes) {
+		return errHTTPBadRequestMessageNotUTF8.With(m)
+	}
+	var data any
+	if err := json.Unmarshal(body.PeekedBytes, &data); err != nil {
+		return errHTTPBadRequestTemplateInvalid.Wrap("request body must be valid JSON").With(m)
+	}
+	if tpl.Title != "" {
+		title, err := renderTemplate(ctx, tpl.Title, data, s.config.MessageLimit)
+		if err != nil {
+			return err.With(m)
+		}
+		m.Title = title
+	}
+	message, err := renderTemplate(ctx, tpl.Message, data, s.config.MessageLimit)
+	if err != nil {
+		return err.With(m)
+	}
+	m.Message = message
+	return nil
+}
+
+// renderTemplate renders the given template string with data. To protect against runaway templates, rendering fails
+// if the template exceeds its execution budget (see checkTemplateBudget), if the output is longer than limit bytes,
+// or if rendering takes longer than templateExecTimeout.
+func renderTemplate(ctx context.Context, tpl string, data any, limit int) (string, *errHTTP) {
+	t, err := template.New("").Funcs(template.FuncMap{"printf": templatePrintf}).Parse(tpl)
+	if err != nil {
+		return "", errHTTPBadRequestTemplateInvalid.Wrap("%s", err.Error())
+	} else if err := checkTemplateBudget(t, data); err != nil {
+		return "", errHTTPBadRequestTemplateInvalid.Wrap("%s", err.Error())
+	}
+	ctx, cancel := context.WithTimeout(ctx, templateExecTimeout)
+	defer cancel()
+	var buf bytes.Buffer
+	w := &contextWriter{ctx: ctx, w: util.NewLimitWriter(&buf, util.NewFixedLimiter(int64(limit)))}
+	if err := t.Execute(w, data); err != nil {
+		if errors.Is(err, util.ErrLimitReached) {
+			return "", errHTTPBadRequestTemplateInvalid.Wrap("rendered template exceeds %d bytes", limit)
+		} else if errors.Is(err, context.DeadlineExceeded) {
+			return "", errHTTPBadRequestTemplateInvalid.Wrap("rendering template took longer than %s", templateExecTimeout)
+		}
+		return "", errHTTPBadRequestTemplateInvalid.Wrap("%s", err.Error())
+	}
+	return strings.TrimSpace(buf.String()), nil
+}
+
+// checkTemplateBudget rejects templates that define or call other templates, that nest control structures deeper
+// than templateMaxDepth, or whose estimated number of evaluation steps exceeds templateMaxSteps. Since a range may
+// iterate over any array or object in the data, its number of iterations is estimated as the size of the largest
+// array or object in the data (or as the value of a number in its pipeline, e.g. {{range 10}}).
+func checkTemplateBudget(t *template.Template, data any) error {
+	if len(t.Templates()) > 1 {
+		return errors.New("defining templates is not allowed")
+	} else if t.Tree == nil {
+		return nil
+	}
+	b := &templateBudget{iterations: maxCollectionSize(data)}
+	return b.walk(t.Tree.Root, 1, 0)
+}
+
+type templateBudget struct {
+	iterations int // Estimated number of iterations of each range
+	steps      int // Estimated number of evaluation steps so far
+}
+
+func (b *templateBudget) walk(node parse.Node, multiplier, depth int) error {
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return nil
+		}
+		for _, child := range n.Nodes {
+			if err := b.walk(child, multiplier, depth); err != nil {
+				return err
+			}
+		}
+		return nil
+	case *parse.TemplateNode:
+		return errors.New("calling templates is not allowed")
+	case *parse.RangeNode:
+		return b.walkBranch(&n.BranchNode, min(multiplier*max(b.iterations, pipeNumber(n.Pipe)), templateMaxSteps+1), multiplier, depth)
+	case *parse.IfNode:
+		return b.walkBranch(&n.BranchNode, multiplier, multiplier, depth)
+	case *parse.WithNode:
+		return b.walkBranch(&n.BranchNode, multiplier, multiplier, depth)
+	}
+	return b.add(multiplier)
+}
+
+func (b *templateBudget) walkBranch(n *parse.BranchNode, listMultiplier, elseMultiplier, depth int) error {
+	if depth >= templateMaxDepth {
+		return fmt.Errorf("range, if and with cannot be nested more than %d levels deep", templateMaxDepth)
+	} else if err := b.add(listMultiplier); err != nil { // Each iteration is a step, even if the list is empty
+		return err
+	} else if err := b.walk(n.List, listMultiplier, depth+1); err != nil {
+		return err
+	}
+	return b.walk(n.ElseList, elseMultiplier, depth+1)
+}
+
+func (b *templateBudget) add(steps int) error {
+	b.steps += steps
+	if b.steps > templateMaxSteps {
+		return fmt.Errorf("template is too complex for the given data, it exceeds %d evaluation steps", templateMaxSteps)
+	}
+	return nil
+}
+
+// pipeNumber returns the largest integer constant in the pipeline, or 0 if there is none
+func pipeNumber(pipe *parse.PipeNode) int {
+	number := 0
+	for _, cmd := range pipe.Cmds {
+		for _, arg := range cmd.Args {
+			if n, ok := arg.(*parse.NumberNode); ok && n.IsInt {
+				number = max(number, int(min(max(n.Int64, 0), templateMaxSteps+1)))
+			}
+		}
+	}
+	return number
+}
+
+// maxCollectionSize returns the size of the largest array or object in the (JSON) data
+func maxCollectionSize(data any) int {
+	size := 0
+	switch d := data.(type) {
+	case []any:
+		size = len(d)
+		for _, v := range d {
+			size = max(size, maxCollectionSize(v))
+		}
+	case map[string]any:
+		size = len(d)
+		for _, v := range d {
+			size = max(size, maxCollectionSize(v))
+		}
+	}
+	return size
+}
+
+// templatePrintf replaces the built-in printf function, and rejects large widths and precisions (e.g. %999999999d),
+// which would otherwise allocate the output in memory before the output limit applies
+func templatePrintf(format string, args ...any) (string, error) {
+	for _, match := range templateFormatVerbRegex.FindAllStringSubmatch(format, -1) {
+		for _, value := range match[1:] {
+			if n, err := strconv.Atoi(value); value == "*" || (err == nil && n > templateMaxFormatWidth) {
+				return "", fmt.Errorf("printf width and precision must be at most %d", templateMaxFormatWidth)
+			}
+		}
+	}
+	return fmt.Sprintf(format, args...), nil
+}
+
+// contextWriter fails all writes once the context is done, which aborts the execution of a template
+type contextWriter struct {
+	ctx context.Context
+	w   io.Writer
+}
+
+func (w *contextWriter) Write(p []byte) (int, error) {
+	if err := w.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return w.w.Write(p)
+}
@@ -0,0 +1,226 @@
+package server
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"heckel.io/ntfy/v2/user"
+	"heckel.io/ntfy/v2/util"
+)
+
+const testTemplateGrafanaWebhook = `{
+  "receiver": "ntfy",
+  "status": "firing",
+  "title": "[FIRING:1] Disk full",
+  "alerts": [
+    {"status": "firing", "labels": {"instance": "db1"}, "annotations": {"summary": "Disk 98% full"}},
+    {"status": "firing", "labels": {"instance": "db2"}, "annotations": {"summary": "Disk 95% full"}}
+  ],
+  "externalURL": "https://grafana.example.com"
+}`
+
+func TestServer_PublishWithInlineTemplate(t *testing.T) {
+	s := newTestServerWithTemplateUser(t)
+
+	response := request(t, s, "POST", "/mytopic", testTemplateGrafanaWebhook, map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+		"X-Template":    "yes",
+		"X-Title":       "{{.title}}",
+		"X-Message":     `{{range .alerts}}{{.labels.instance}}: {{.annotations.summary}}\n{{end}}`,
+		"X-Tags":        "grafana",
+	})
+	require.Equal(t, 200, response.Code)
+	m := toMessage(t, response.Body.String())
+	require.Equal(t, "[FIRING:1] Disk full", m.Title)
+	require.Equal(t, "db1: Disk 98% full\ndb2: Disk 95% full", m.Message)
+	require.Equal(t, []string{"grafana"}, m.Tags)
+
+	response = request(t, s, "GET", "/mytopic/json?poll=1", "", nil)
+	m = toMessage(t, response.Body.String())
+	require.Equal(t, "[FIRING:1] Disk full", m.Title)
+	require.Equal(t, "db1: Disk 98% full\ndb2: Disk 95% full", m.Message)
+}
+
+func TestServer_PublishWithInlineTemplate_QueryParams(t *testing.T) {
+	s := newTestServerWithTemplateUser(t)
+
+	response := request(t, s, "PUT", `/mytopic?tpl=1&m=Status+is+{{.status}}+({{len+.alerts}}+alerts)`, testTemplateGrafanaWebhook, map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 200, response.Code)
+	m := toMessage(t, response.Body.String())
+	require.Equal(t, "", m.Title)
+	require.Equal(t, "Status is firing (2 alerts)", m.Message)
+}
+
+func TestServer_PublishWithTemplateDisabled(t *testing.T) {
+	s := newTestServer(t, newTestConfig(t))
+
+	response := request(t, s, "PUT", "/mytopic?template=no&title={{.title}}", `{"title":"hi"}`, nil)
+	require.Equal(t, 200, response.Code)
+	m := toMessage(t, response.Body.String())
+	require.Equal(t, "{{.title}}", m.Title)
+	require.Equal(t, `{"title":"hi"}`, m.Message)
+}
+
+func TestServer_PublishWithNamedTemplate(t *testing.T) {
+	c := newTestConfig(t)
+	c.MessageTemplates = map[string]*MessageTemplate{
+		"github": {
+			Name:    "github",
+			Title:   "{{.repository.full_name}}",
+			Message: "{{.sender.login}} {{.action}} the repo",
+		},
+		"notitle": {
+			Name:    "notitle",
+			Message: "{{.action}}",
+		},
+	}
+	s := newTestServer(t, c)
+	body := `{"action":"started","repository":{"full_name":"binwiederhier/ntfy"},"sender":{"login":"phil"}}`
+
+	response := request(t, s, "POST", "/mytopic?template=github", body, nil)
+	require.Equal(t, 200, response.Code)
+	m := toMessage(t, response.Body.String())
+	require.Equal(t, "binwiederhier/ntfy", m.Title)
+	require.Equal(t, "phil started the repo", m.Message)
+
+	// Title param is used if the template has no title
+	response = request(t, s, "POST", "/mytopic", body, map[string]string{
+		"X-Template": "notitle",
+		"X-Title":    "GitHub",
+	})
+	require.Equal(t, 200, response.Code)
+	m = toMessage(t, response.Body.String())
+	require.Equal(t, "GitHub", m.Title)
+	require.Equal(t, "started", m.Message)
+
+	response = request(t, s, "POST", "/mytopic?template=unknown", body, nil)
+	require.Equal(t, 400, response.Code)
+	require.Equal(t, 40060, toHTTPError(t, response.Body.String()).Code)
+}
+
+func TestServer_PublishWithInlineTemplate_Anonymous(t *testing.T) {
+	s := newTestServerWithTemplateUser(t)
+
+	// Inline templates are not allowed for anonymous users, not even if the topic is writable
+	response := request(t, s, "PUT", "/mytopic?template=yes&message={{.message}}", `{"message":"hi"}`, nil)
+	require.Equal(t, 403, response.Code)
+	require.Equal(t, 40305, toHTTPError(t, response.Body.String()).Code)
+
+	s = newTestServer(t, newTestConfig(t))
+	response = request(t, s, "PUT", "/mytopic?template=yes&message={{.message}}", `{"message":"hi"}`, nil)
+	require.Equal(t, 403, response.Code)
+	require.Equal(t, 40305, toHTTPError(t, response.Body.String()).Code)
+}
+
+func TestServer_PublishWithTemplate_LargeBody(t *testing.T) {
+	s := newTestServerWithTemplateUser(t)
+	auth := map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	}
+
+	// Bodies larger than the message limit are allowed, since only the rendered message counts
+	body := `{"padding":"` + strings.Repeat("x", 10000) + `","message":"small"}`
+	response := request(t, s, "POST", "/mytopic?template=yes&message={{.message}}", body, auth)
+	require.Equal(t, 200, response.Code)
+	m := toMessage(t, response.Body.String())
+	require.Equal(t, "small", m.Message)
+	require.Nil(t, m.Attachment)
+
+	body = `{"padding":"` + strings.Repeat("x", templateBodyBytesLimit) + `"}`
+	response = request(t, s, "POST", "/mytopic?template=yes&message={{.padding}}", body, auth)
+	require.Equal(t, 413, response.Code)
+	require.Equal(t, 41303, toHTTPError(t, response.Body.String()).Code)
+}
+
+func TestServer_PublishWithTemplate_Invalid(t *testing.T) {
+	s := newTestServerWithTemplateUser(t)
+
+	for _, tc := range []struct {
+		url  string
+		body string
+	}{
+		{"/mytopic?template=yes&message={{.message}}", "not json"},
+		{"/mytopic?template=yes&message={{.message", `{"message":"hi"}`},
+		{"/mytopic?template=yes&message={{.message.nested.deep}}", `{"message":"not a map"}`},
+		{"/mytopic?template=yes&message={{range .items}}{{.}}{{end}}", `{"items":["` + strings.Repeat("x", 5000) + `"]}`}, // Exceeds message limit
+		{`/mytopic?template=yes&message={{define "x"}}{{.}}{{end}}{{template "x" .}}`, `{"message":"hi"}`},
+		{"/mytopic?template=yes&message={{if .a}}{{if .a}}{{if .a}}{{if .a}}{{if .a}}{{end}}{{end}}{{end}}{{end}}{{end}}", `{"a":true}`},
+		{`/mytopic?template=yes&message={{printf+"%25999999999d"+1}}`, `{"message":"hi"}`},
+	} {
+		response := request(t, s, "POST", tc.url, tc.body, map[string]string{
+			"Authorization": util.BasicAuth("phil", "phil"),
+		})
+		require.Equal(t, 400, response.Code, tc.url)
+		require.Equal(t, 40060, toHTTPError(t, response.Body.String()).Code, tc.url)
+	}
+}
+
+func TestServer_PublishWithTemplate_ExecutionBudget(t *testing.T) {
+	s := newTestServerWithTemplateUser(t)
+	items := `[` + strings.Repeat(`1,`, 2000) + `1]`
+
+	// Nested range over 2001 items would take minutes to render, so it is rejected before rendering
+	start := time.Now()
+	response := request(t, s, "POST", "/mytopic?template=yes&message={{range .items}}{{range $.items}}{{end}}{{end}}", `{"items":`+items+`}`, map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 400, response.Code)
+	require.Equal(t, 40060, toHTTPError(t, response.Body.String()).Code)
+	require.Contains(t, toHTTPError(t, response.Body.String()).Message, "too complex")
+	require.Less(t, time.Since(start), time.Second)
+
+	response = request(t, s, "POST", "/mytopic?template=yes&message={{range 1000000000}}{{end}}", `{}`, map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 400, response.Code)
+	require.Equal(t, 40060, toHTTPError(t, response.Body.String()).Code)
+
+	// A single range over the same data is fine
+	response = request(t, s, "POST", "/mytopic?template=yes&message={{len .items}}+items", `{"items":`+items+`}`, map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 200, response.Code)
+	require.Equal(t, "2001 items", toMessage(t, response.Body.String()).Message)
+}
+
+func TestServer_CheckTemplateBudget(t *testing.T) {
+	data := map[string]any{"items": []any{1, 2, 3}, "nested": map[string]any{"list": make([]any, 100)}}
+	require.Equal(t, 100, maxCollectionSize(data))
+
+	for _, tpl := range []string{
+		"{{.items}}",
+		"{{range .items}}{{.}}{{end}}",
+		"{{range .nested.list}}{{range $.items}}{{.}}{{end}}{{end}}",
+		"{{if .items}}{{with .nested}}{{range .list}}{{end}}{{end}}{{end}}",
+		`{{printf "%5.2f" 1.0}}`,
+	} {
+		_, err := renderTemplate(context.Background(), tpl, data, 4096)
+		require.Nil(t, err, tpl)
+	}
+	for _, tpl := range []string{
+		"{{range .nested.list}}{{range $.nested.list}}{{range $.items}}{{end}}{{end}}{{end}}", // 100*100*100 steps
+		`{{block "x" .}}{{end}}`,
+		`{{printf "%*d" 1000000 1}}`,
+		`{{printf "%.1000f" 1.0}}`,
+	} {
+		_, err := renderTemplate(context.Background(), tpl, data, 4096)
+		require.NotNil(t, err, tpl)
+	}
+
+	// Rendering stops once the context is done
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := renderTemplate(ctx, "{{.items}}", data, 4096)
+	require.NotNil(t, err)
+}
+
+func newTestServerWithTemplateUser(t *testing.T) *Server {
+	s := newTestServer(t, newTestConfigWithAuthFile(t))
+	require.Nil(t, s.userManager.AddUser("phil", "phil", user.RoleUser))
+	return s
+}
@@ -598,6 +598,504 @@ func TestServer_PublishAndPollSince(t *testing.T) {
 	require.Equal(t, 40008, toHTTPError(t, response.Body.String()).Code)
 }
 
+func TestServer_PublishUpdateAndDelete(t *testing.T) {
+	t.Parallel()
+	s := newTestServer(t, newTestConfig(t))
+
+	subscribeRR := httptest.NewRecorder()
+	subscribeCancel := subscribe(t, s, "/mytopic/json", subscribeRR)
+
+	response := request(t, s, "PUT", "/mytopic", "original message", nil)
+	require.Equal(t, 200, response.Code)
+	original := toMessage(t, response.Body.String())
+	require.Equal(t, messageEvent, original.Event)
+
+	response = request(t, s, "PUT", "/mytopic", "updated message", map[string]string{
+		"X-Replaces": original.ID,
+	})
+	require.Equal(t, 200, response.Code)
+	update1 := toMessage(t, response.Body.String())
+	require.Equal(t, messageUpdateEvent, update1.Event)
+	require.Equal(t, original.ID, update1.Replaces)
+	require.NotEqual(t, original.ID, update1.ID)
+
+	// Replacing an update references the original message
+	response = request(t, s, "PUT", "/mytopic?replaces="+update1.ID, "updated message again", nil)
+	require.Equal(t, 200, response.Code)
+	update2 := toMessage(t, response.Body.String())
+	require.Equal(t, messageUpdateEvent, update2.Event)
+	require.Equal(t, original.ID, update2.Replaces)
+
+	response = request(t, s, "PUT", "/mytopic", "other message", nil)
+	require.Equal(t, 200, response.Code)
+
+	// Poll returns the current state
+	response = request(t, s, "GET", "/mytopic/json?poll=1", "", nil)
+	messages := toMessages(t, response.Body.String())
+	require.Equal(t, 2, len(messages))
+	require.Equal(t, update2.ID, messages[0].ID)
+	require.Equal(t, messageUpdateEvent, messages[0].Event)
+	require.Equal(t, "updated message again", messages[0].Message)
+	require.Equal(t, "other message", messages[1].Message)
+
+	// Delete the message
+	response = request(t, s, "DELETE", "/mytopic/"+update2.ID, "", nil)
+	require.Equal(t, 200, response.Code)
+	deleted := toMessage(t, response.Body.String())
+	require.Equal(t, messageDeleteEvent, deleted.Event)
+	require.Equal(t, original.ID, deleted.Replaces)
+	require.Equal(t, "", deleted.Message)
+
+	response = request(t, s, "GET", "/mytopic/json?poll=1", "", nil)
+	messages = toMessages(t, response.Body.String())
+	require.Equal(t, 2, len(messages))
+	require.Equal(t, "other message", messages[0].Message)
+	require.Equal(t, deleted.ID, messages[1].ID)
+	require.Equal(t, messageDeleteEvent, messages[1].Event)
+	require.Equal(t, original.ID, messages[1].Replaces)
+
+	// Subscribers receive all events
+	subscribeCancel()
+	messages = toMessages(t, subscribeRR.Body.String())
+	require.Equal(t, 6, len(messages))
+	require.Equal(t, openEvent, messages[0].Event)
+	require.Equal(t, messageEvent, messages[1].Event)
+	require.Equal(t, messageUpdateEvent, messages[2].Event)
+	require.Equal(t, messageUpdateEvent, messages[3].Event)
+	require.Equal(t, messageEvent, messages[4].Event)
+	require.Equal(t, messageDeleteEvent, messages[5].Event)
+	require.Equal(t, original.ID, messages[5].Replaces)
+}
+
+func TestServer_PublishUpdateAndDelete_CacheBatching(t *testing.T) {
+	t.Parallel()
+	c := newTestConfig(t)
+	c.CacheBatchSize = 5 // Written with the fifth message, the delete
+	c.CacheBatchTimeout = time.Hour
+	s := newTestServer(t, c)
+
+	// Updates and deletes work while the original message is still queued
+	response := request(t, s, "PUT", "/mytopic", "original message", nil)
+	require.Equal(t, 200, response.Code)
+	original := toMessage(t, response.Body.String())
+	response = request(t, s, "PUT", "/mytopic", "updated message", map[string]string{
+		"X-Replaces": original.ID,
+	})
+	require.Equal(t, 200, response.Code)
+	update1 := toMessage(t, response.Body.String())
+	response = request(t, s, "PUT", "/mytopic?replaces="+update1.ID, "updated message again", nil)
+	require.Equal(t, 200, response.Code)
+	update2 := toMessage(t, response.Body.String())
+	require.Equal(t, original.ID, update2.Replaces)
+	response = request(t, s, "PUT", "/mytopic", "other message", nil)
+	require.Equal(t, 200, response.Code)
+	other := toMessage(t, response.Body.String())
+	response = request(t, s, "GET", "/mytopic/json?poll=1", "", nil)
+	require.Equal(t, 0, len(toMessages(t, response.Body.String())))
+	response = request(t, s, "DELETE", "/mytopic/"+other.ID, "", nil)
+	require.Equal(t, 200, response.Code)
+	deleted := toMessage(t, response.Body.String())
+	require.Equal(t, other.ID, deleted.Replaces)
+
+	// Once the batch is written, polls return the current state
+	var messages []*message
+	waitFor(t, func() bool {
+		response = request(t, s, "GET", "/mytopic/json?poll=1", "", nil)
+		messages = toMessages(t, response.Body.String())
+		return len(messages) == 2
+	})
+	require.Equal(t, update2.ID, messages[0].ID)
+	require.Equal(t, "updated message again", messages[0].Message)
+	require.Equal(t, deleted.ID, messages[1].ID)
+	require.Equal(t, messageDeleteEvent, messages[1].Event)
+}
+
+func TestServer_PublishUpdate_SSE(t *testing.T) {
+	t.Parallel()
+	s := newTestServer(t, newTestConfig(t))
+
+	subscribeRR := httptest.NewRecorder()
+	subscribeCancel := subscribe(t, s, "/mytopic/sse", subscribeRR)
+
+	response := request(t, s, "PUT", "/mytopic", "original message", nil)
+	original := toMessage(t, response.Body.String())
+	request(t, s, "PUT", "/mytopic", "updated message", map[string]string{
+		"Replaces": original.ID,
+	})
+	request(t, s, "DELETE", "/mytopic/"+original.ID, "", nil)
+
+	subscribeCancel()
+	require.Contains(t, subscribeRR.Body.String(), "event: message_update\n")
+	require.Contains(t, subscribeRR.Body.String(), "event: message_delete\n")
+}
+
+func TestServer_PublishUpdate_Invalid(t *testing.T) {
+	t.Parallel()
+	s := newTestServer(t, newTestConfig(t))
+
+	response := request(t, s, "PUT", "/mytopic", "updated message", map[string]string{
+		"X-Replaces": "not-an-id",
+	})
+	require.Equal(t, 400, response.Code)
+	require.Equal(t, 40041, toHTTPError(t, response.Body.String()).Code)
+
+	response = request(t, s, "PUT", "/mytopic", "updated message", map[string]string{
+		"X-Replaces": "abcdefghijkl",
+		"X-Delay":    "1h",
+	})
+	require.Equal(t, 400, response.Code)
+	require.Equal(t, 40042, toHTTPError(t, response.Body.String()).Code)
+}
+
+func TestServer_PublishUpdate_JSON(t *testing.T) {
+	t.Parallel()
+	s := newTestServer(t, newTestConfig(t))
+
+	response := request(t, s, "PUT", "/mytopic", "original message", nil)
+	original := toMessage(t, response.Body.String())
+
+	response = request(t, s, "PUT", "/", `{"topic":"mytopic","message":"updated message","replaces":"`+original.ID+`"}`, nil)
+	require.Equal(t, 200, response.Code)
+	update := toMessage(t, response.Body.String())
+	require.Equal(t, messageUpdateEvent, update.Event)
+	require.Equal(t, original.ID, update.Replaces)
+}
+
+func TestServer_DeleteMessage_Auth(t *testing.T) {
+	c := newTestConfigWithAuthFile(t)
+	c.AuthDefault = user.PermissionRead
+	s := newTestServer(t, c)
+	require.Nil(t, s.userManager.AddUser("phil", "phil", user.RoleAdmin))
+
+	response := request(t, s, "PUT", "/mytopic", "some message", map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 200, response.Code)
+	msg := toMessage(t, response.Body.String())
+
+	response = request(t, s, "DELETE", "/mytopic/"+msg.ID, "", nil)
+	require.Equal(t, 403, response.Code) // Anonymous cannot write
+
+	response = request(t, s, "DELETE", "/mytopic/"+msg.ID, "", map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 200, response.Code)
+
+	response = request(t, s, "GET", "/mytopic/json?poll=1", "", nil)
+	messages := toMessages(t, response.Body.String())
+	require.Equal(t, 1, len(messages))
+	require.Equal(t, messageDeleteEvent, messages[0].Event)
+	require.Equal(t, msg.ID, messages[0].Replaces)
+}
+
+func TestServer_DeleteMessage_NotFound(t *testing.T) {
+	t.Parallel()
+	s := newTestServer(t, newTestConfig(t))
+
+	response := request(t, s, "PUT", "/mytopic", "some message", nil)
+	require.Equal(t, 200, response.Code)
+	msg := toMessage(t, response.Body.String())
+
+	response = request(t, s, "DELETE", "/mytopic/abcdefghijkl", "", nil)
+	require.Equal(t, 404, response.Code)
+	require.Equal(t, 40406, toHTTPError(t, response.Body.String()).Code)
+
+	response = request(t, s, "DELETE", "/othertopic/"+msg.ID, "", nil)
+	require.Equal(t, 404, response.Code)
+	require.Equal(t, 40406, toHTTPError(t, response.Body.String()).Code)
+
+	response = request(t, s, "GET", "/othertopic/json?poll=1", "", nil)
+	require.Empty(t, toMessages(t, response.Body.String()))
+}
+
+func TestServer_DeleteMessage_MessageLimit(t *testing.T) {
+	c := newTestConfig(t)
+	c.VisitorMessageDailyLimit = 2
+	s := newTestServer(t, c)
+
+	response := request(t, s, "PUT", "/mytopic", "some message", nil)
+	require.Equal(t, 200, response.Code)
+	msg := toMessage(t, response.Body.String())
+
+	response = request(t, s, "DELETE", "/mytopic/"+msg.ID, "", nil)
+	require.Equal(t, 200, response.Code)
+
+	response = request(t, s, "DELETE", "/mytopic/"+msg.ID, "", nil)
+	require.Equal(t, 429, response.Code)
+	require.Equal(t, 42908, toHTTPError(t, response.Body.String()).Code)
+}
+
+func TestServer_MessageAck(t *testing.T) {
+	t.Parallel()
+	s := newTestServer(t, newTestConfig(t))
+
+	subscribeRR := httptest.NewRecorder()
+	subscribeCancel := subscribe(t, s, "/mytopic/json", subscribeRR)
+
+	response := request(t, s, "PUT", "/mytopic", "disk is full", map[string]string{
+		"Actions": "acknowledge, Got it, clear=true",
+	})
+	require.Equal(t, 200, response.Code)
+	original := toMessage(t, response.Body.String())
+	require.Equal(t, 1, len(original.Actions))
+	require.Equal(t, "acknowledge", original.Actions[0].Action)
+	require.Equal(t, "http://127.0.0.1:12345/mytopic/"+original.ID+"/ack", original.Actions[0].URL)
+	require.Equal(t, "POST", original.Actions[0].Method)
+
+	response = request(t, s, "PUT", "/mytopic", "disk is still full", map[string]string{
+		"Replaces": original.ID,
+	})
+	update := toMessage(t, response.Body.String())
+
+	// Acknowledging an update acknowledges the original message
+	response = request(t, s, "POST", "/mytopic/"+update.ID+"/ack", "", nil)
+	require.Equal(t, 200, response.Code)
+	a, err := util.UnmarshalJSON[ack](io.NopCloser(response.Body))
+	require.Nil(t, err)
+	require.Equal(t, "", a.User)
+	require.Greater(t, a.Time, int64(0))
+
+	// Acknowledging again has no effect
+	response = request(t, s, "POST", "/mytopic/"+original.ID+"/ack", "", nil)
+	require.Equal(t, 200, response.Code)
+
+	response = request(t, s, "GET", "/mytopic/json?poll=1", "", nil)
+	messages := toMessages(t, response.Body.String())
+	require.Equal(t, 1, len(messages))
+	require.Equal(t, update.ID, messages[0].ID)
+	require.Equal(t, 1, len(messages[0].Acks))
+	require.Equal(t, a.Time, messages[0].Acks[0].Time)
+
+	// Subscribers receive a single "message_ack" event
+	subscribeCancel()
+	messages = toMessages(t, subscribeRR.Body.String())
+	require.Equal(t, 4, len(messages))
+	acks := make([]*message, 0)
+	for _, m := range messages {
+		if m.Event == messageAckEvent {
+			acks = append(acks, m)
+		}
+	}
+	require.Equal(t, 1, len(acks))
+	require.Equal(t, original.ID, acks[0].Replaces)
+	require.Equal(t, 1, len(acks[0].Acks))
+}
+
+func TestServer_MessageAck_NotFound(t *testing.T) {
+	t.Parallel()
+	s := newTestServer(t, newTestConfig(t))
+
+	response := request(t, s, "POST", "/mytopic/abcdefghijkl/ack", "", nil)
+	require.Equal(t, 404, response.Code)
+	require.Equal(t, 40404, toHTTPError(t, response.Body.String()).Code)
+
+	response = request(t, s, "PUT", "/othertopic", "some message", nil)
+	msg := toMessage(t, response.Body.String())
+	response = request(t, s, "POST", "/mytopic/"+msg.ID+"/ack", "", nil)
+	require.Equal(t, 404, response.Code)
+
+	response = request(t, s, "PUT", "/mytopic", "some message", map[string]string{
+		"Delay": "1h",
+	})
+	msg = toMessage(t, response.Body.String())
+	response = request(t, s, "POST", "/mytopic/"+msg.ID+"/ack", "", nil)
+	require.Equal(t, 404, response.Code)
+}
+
+func TestServer_MessageAck_Auth(t *testing.T) {
+	c := newTestConfigWithAuthFile(t)
+	c.AuthDefault = user.PermissionDenyAll
+	s := newTestServer(t, c)
+	require.Nil(t, s.userManager.AddUser("phil", "phil", user.RoleAdmin))
+	require.Nil(t, s.userManager.AddUser("ben", "ben", user.RoleUser))
+	require.Nil(t, s.userManager.AllowAccess("ben", "mytopic", user.PermissionRead))
+	u, err := s.userManager.User("ben")
+	require.Nil(t, err)
+	token, err := s.userManager.CreateToken(u.ID, "laptop", time.Unix(0, 0), netip.IPv4Unspecified(), nil)
+	require.Nil(t, err)
+
+	response := request(t, s, "PUT", "/mytopic", "some message", map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	msg := toMessage(t, response.Body.String())
+
+	response = request(t, s, "POST", "/mytopic/"+msg.ID+"/ack", "", nil)
+	require.Equal(t, 403, response.Code) // Anonymous cannot read
+
+	response = request(t, s, "POST", "/mytopic/"+msg.ID+"/ack", "", map[string]string{
+		"Authorization": util.BearerAuth(token.Value),
+	})
+	require.Equal(t, 200, response.Code)
+
+	response = request(t, s, "GET", "/mytopic/messages", "", map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	history, err := util.UnmarshalJSON[apiMessagesResponse](io.NopCloser(response.Body))
+	require.Nil(t, err)
+	require.Equal(t, 1, len(history.Messages))
+	require.Equal(t, 1, len(history.Messages[0].Acks))
+	require.Equal(t, "ben", history.Messages[0].Acks[0].User)
+	require.Equal(t, "laptop", history.Messages[0].Acks[0].Token)
+}
+
+func TestServer_Messages(t *testing.T) {
+	t.Parallel()
+	s := newTestServer(t, newTestConfig(t))
+
+	ids := make([]string, 0)
+	for i := 0; i < 5; i++ {
+		response := request(t, s, "PUT", "/mytopic", fmt.Sprintf("message %d", i), nil)
+		ids = append(ids, toMessage(t, response.Body.String()).ID)
+	}
+
+	// Latest page
+	response := request(t, s, "GET", "/mytopic/messages?limit=2", "", nil)
+	require.Equal(t, 200, response.Code)
+	page, _ := util.UnmarshalJSON[apiMessagesResponse](io.NopCloser(response.Body))
+	require.Equal(t, 2, len(page.Messages))
+	require.Equal(t, "message 3", page.Messages[0].Message)
+	require.Equal(t, "message 4", page.Messages[1].Message)
+	require.Equal(t, ids[3], page.Prev)
+	require.Equal(t, "", page.Next)
+
+	// Scroll back
+	response = request(t, s, "GET", "/mytopic/messages?limit=2&before="+page.Prev, "", nil)
+	require.Equal(t, 200, response.Code)
+	page, _ = util.UnmarshalJSON[apiMessagesResponse](io.NopCloser(response.Body))
+	require.Equal(t, 2, len(page.Messages))
+	require.Equal(t, "message 1", page.Messages[0].Message)
+	require.Equal(t, "message 2", page.Messages[1].Message)
+	require.Equal(t, ids[1], page.Prev)
+	require.Equal(t, ids[2], page.Next)
+
+	response = request(t, s, "GET", "/mytopic/messages?limit=2&before="+page.Prev, "", nil)
+	require.Equal(t, 200, response.Code)
+	page, _ = util.UnmarshalJSON[apiMessagesResponse](io.NopCloser(response.Body))
+	require.Equal(t, 1, len(page.Messages))
+	require.Equal(t, "message 0", page.Messages[0].Message)
+	require.Equal(t, "", page.Prev)
+	require.Equal(t, ids[0], page.Next)
+
+	// Scroll forward
+	response = request(t, s, "GET", "/mytopic/messages?limit=3&after="+page.Next, "", nil)
+	require.Equal(t, 200, response.Code)
+	page, _ = util.UnmarshalJSON[apiMessagesResponse](io.NopCloser(response.Body))
+	require.Equal(t, 3, len(page.Messages))
+	require.Equal(t, "message 1", page.Messages[0].Message)
+	require.Equal(t, "message 3", page.Messages[2].Message)
+	require.Equal(t, ids[1], page.Prev)
+	require.Equal(t, ids[3], page.Next)
+
+	response = request(t, s, "GET", "/mytopic/messages?after="+page.Next, "", nil)
+	require.Equal(t, 200, response.Code)
+	page, _ = util.UnmarshalJSON[apiMessagesResponse](io.NopCloser(response.Body))
+	require.Equal(t, 1, len(page.Messages))
+	require.Equal(t, "message 4", page.Messages[0].Message)
+	require.Equal(t, "", page.Next)
+
+	// Invalid requests
+	response = request(t, s, "GET", "/mytopic/messages?before="+ids[1]+"&after="+ids[2], "", nil)
+	require.Equal(t, 40045, toHTTPError(t, response.Body.String()).Code)
+
+	response = request(t, s, "GET", "/mytopic/messages?before=doesnotexist", "", nil)
+	require.Equal(t, 40045, toHTTPError(t, response.Body.String()).Code)
+
+	response = request(t, s, "GET", "/othertopic/messages?before="+ids[1], "", nil)
+	require.Equal(t, 40045, toHTTPError(t, response.Body.String()).Code)
+
+	response = request(t, s, "GET", "/mytopic/messages?limit=0", "", nil)
+	require.Equal(t, 40044, toHTTPError(t, response.Body.String()).Code)
+}
+
+func TestServer_Messages_Auth(t *testing.T) {
+	c := newTestConfigWithAuthFile(t)
+	c.AuthDefault = user.PermissionDenyAll
+	s := newTestServer(t, c)
+	require.Nil(t, s.userManager.AddUser("phil", "phil", user.RoleAdmin))
+
+	response := request(t, s, "PUT", "/mytopic", "some message", map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 200, response.Code)
+
+	response = request(t, s, "GET", "/mytopic/messages", "", nil)
+	require.Equal(t, 403, response.Code)
+
+	response = request(t, s, "GET", "/mytopic/messages", "", map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 200, response.Code)
+	page, _ := util.UnmarshalJSON[apiMessagesResponse](io.NopCloser(response.Body))
+	require.Equal(t, 1, len(page.Messages))
+}
+
+func TestServer_Search(t *testing.T) {
+	t.Parallel()
+	s := newTestServer(t, newTestConfig(t))
+
+	request(t, s, "PUT", "/mytopic", "Backup of /home finished", map[string]string{"Title": "Backup job"})
+	request(t, s, "PUT", "/mytopic", "Disk space is low", nil)
+	request(t, s, "PUT", "/mytopic", "Backup of /var failed", nil)
+	request(t, s, "PUT", "/othertopic", "Backup of other things", nil)
+
+	response := request(t, s, "GET", "/mytopic/search?q=backup", "", nil)
+	require.Equal(t, 200, response.Code)
+	result, _ := util.UnmarshalJSON[apiSearchResponse](io.NopCloser(response.Body))
+	require.Equal(t, 2, len(result.Messages))
+	require.Equal(t, 0, result.Next)
+	for _, m := range result.Messages {
+		require.Equal(t, "mytopic", m.Topic)
+		require.Contains(t, m.Message, "Backup")
+	}
+
+	response = request(t, s, "GET", "/mytopic/search?q=backup&limit=1", "", nil)
+	require.Equal(t, 200, response.Code)
+	result, _ = util.UnmarshalJSON[apiSearchResponse](io.NopCloser(response.Body))
+	require.Equal(t, 1, len(result.Messages))
+	require.Equal(t, 1, result.Next)
+
+	response = request(t, s, "GET", "/mytopic/search?q=backup&limit=1&offset=1", "", nil)
+	require.Equal(t, 200, response.Code)
+	result, _ = util.UnmarshalJSON[apiSearchResponse](io.NopCloser(response.Body))
+	require.Equal(t, 1, len(result.Messages))
+	require.Equal(t, 0, result.Next)
+
+	response = request(t, s, "GET", "/mytopic/search?q=doesnotexist", "", nil)
+	require.Equal(t, 200, response.Code)
+	require.Equal(t, `{"messages":[]}`+"\n", response.Body.String())
+
+	response = request(t, s, "GET", "/mytopic/search", "", nil)
+	require.Equal(t, 40043, toHTTPError(t, response.Body.String()).Code)
+
+	response = request(t, s, "GET", "/mytopic/search?q=backup&limit=-1", "", nil)
+	require.Equal(t, 40044, toHTTPError(t, response.Body.String()).Code)
+
+	response = request(t, s, "GET", "/mytopic/search?q=backup&offset=abc", "", nil)
+	require.Equal(t, 40044, toHTTPError(t, response.Body.String()).Code)
+}
+
+func TestServer_Search_Auth(t *testing.T) {
+	c := newTestConfigWithAuthFile(t)
+	c.AuthDefault = user.PermissionDenyAll
+	s := newTestServer(t, c)
+	require.Nil(t, s.userManager.AddUser("phil", "phil", user.RoleAdmin))
+
+	response := request(t, s, "PUT", "/mytopic", "Backup done", map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 200, response.Code)
+
+	response = request(t, s, "GET", "/mytopic/search?q=backup", "", nil)
+	require.Equal(t, 403, response.Code)
+
+	response = request(t, s, "GET", "/mytopic/search?q=backup", "", map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 200, response.Code)
+	result, _ := util.UnmarshalJSON[apiSearchResponse](io.NopCloser(response.Body))
+	require.Equal(t, 1, len(result.Messages))
+}
+
 func newMessageWithTimestamp(topic, message string, timestamp int64) *message {
 	m := newDefaultMessage(topic, message)
 	m.Time = timestamp
@@ -824,6 +1322,27 @@ func TestServer_Auth_Success_User_MultipleTopics(t *testing.T) {
 	require.Equal(t, 403, response.Code)
 }
 
+func TestServer_Auth_LDAP_Unreachable(t *testing.T) {
+	c := newTestConfigWithAuthFile(t)
+	c.AuthDefault = user.PermissionDenyAll
+	c.AuthLDAPURL = "ldap://127.0.0.1:1" // Nothing listening here
+	c.AuthLDAPBaseDN = "ou=people,dc=example,dc=com"
+	s := newTestServer(t, c)
+
+	// Local users can still log in if the directory is down
+	require.Nil(t, s.userManager.AddUser("ben", "ben", user.RoleUser))
+	require.Nil(t, s.userManager.AllowAccess("ben", "mytopic", user.PermissionReadWrite))
+	response := request(t, s, "GET", "/mytopic/auth", "", map[string]string{
+		"Authorization": util.BasicAuth("ben", "ben"),
+	})
+	require.Equal(t, 200, response.Code)
+
+	response = request(t, s, "GET", "/mytopic/auth", "", map[string]string{
+		"Authorization": util.BasicAuth("ben", "INVALID"),
+	})
+	require.Equal(t, 401, response.Code)
+}
+
 func TestServer_Auth_Fail_InvalidPass(t *testing.T) {
 	c := newTestConfig(t)
 	c.AuthFile = filepath.Join(t.TempDir(), "user.db")
@@ -1243,27 +1762,65 @@ func TestServer_PublishTooManyEmails_Replenish(t *testing.T) {
 	require.Equal(t, 429, response.Code)
 }
 
-func TestServer_PublishDelayedEmail_Fail(t *testing.T) {
+func TestServer_PublishDelayedEmail(t *testing.T) {
 	s := newTestServer(t, newTestConfig(t))
-	s.smtpSender = &testMailer{}
-	response := request(t, s, "PUT", "/mytopic", "fail", map[string]string{
+	mailer := &testMailer{}
+	s.smtpSender = mailer
+	response := request(t, s, "PUT", "/mytopic", "delayed email", map[string]string{
 		"E-Mail": "test@example.com",
 		"Delay":  "20 min",
 	})
-	require.Equal(t, 40003, toHTTPError(t, response.Body.String()).Code)
+	require.Equal(t, 200, response.Code)
+	m := toMessage(t, response.Body.String())
+
+	// Not sent or counted before the message is due
+	require.Nil(t, s.sendDelayedMessages())
+	require.Equal(t, 0, mailer.Count())
+	v := s.visitor(netip.MustParseAddr("9.9.9.9"), nil)
+	require.Equal(t, int64(0), v.Stats().Emails)
+
+	// E-mail address is stored until the message is sent
+	stored, err := s.messageCache.Message(m.ID)
+	require.Nil(t, err)
+	require.Equal(t, "test@example.com", stored.Email)
+
+	_, err = s.messageCache.db.Exec(`UPDATE messages SET time = ?`, time.Now().Unix())
+	require.Nil(t, err)
+	require.Nil(t, s.sendDelayedMessages())
+	waitFor(t, func() bool {
+		return mailer.Count() == 1
+	})
+	require.Equal(t, int64(1), v.Stats().Emails)
+
+	stored, err = s.messageCache.Message(m.ID)
+	require.Nil(t, err)
+	require.Equal(t, "", stored.Email)
 }
 
-func TestServer_PublishDelayedCall_Fail(t *testing.T) {
-	c := newTestConfigWithAuthFile(t)
-	c.TwilioAccount = "AC1234567890"
-	c.TwilioAuthToken = "AAEAA1234567890"
-	c.TwilioPhoneNumber = "+1234567890"
+func TestServer_PublishDelayedEmail_LimitReachedOnDelivery(t *testing.T) {
+	c := newTestConfig(t)
+	c.VisitorEmailLimitBurst = 2
 	s := newTestServer(t, c)
-	response := request(t, s, "PUT", "/mytopic", "fail", map[string]string{
-		"Call":  "yes",
-		"Delay": "20 min",
+	mailer := &testMailer{}
+	s.smtpSender = mailer
+	for i := 0; i < 3; i++ {
+		response := request(t, s, "PUT", "/mytopic", fmt.Sprintf("delayed email %d", i), map[string]string{
+			"E-Mail": "test@example.com",
+			"Delay":  "20 min",
+		})
+		require.Equal(t, 200, response.Code) // Not counted when published
+	}
+	_, err := s.messageCache.db.Exec(`UPDATE messages SET time = ?`, time.Now().Unix())
+	require.Nil(t, err)
+	require.Nil(t, s.sendDelayedMessages())
+	waitFor(t, func() bool {
+		return mailer.Count() == 2
 	})
-	require.Equal(t, 40037, toHTTPError(t, response.Body.String()).Code)
+	time.Sleep(100 * time.Millisecond)
+	require.Equal(t, 2, mailer.Count())
+
+	response := request(t, s, "GET", "/mytopic/json?poll=1", "", nil)
+	require.Equal(t, 3, len(toMessages(t, response.Body.String()))) // Messages are still delivered
 }
 
 func TestServer_PublishEmailNoMailer_Fail(t *testing.T) {
@@ -7,8 +7,10 @@ import (
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"net/netip"
 	"sync/atomic"
 	"testing"
+	"time"
 )
 
 func TestServer_Twilio_Call_Add_Verify_Call_Delete_Success(t *testing.T) {
@@ -202,6 +204,95 @@ func TestServer_Twilio_Call_Success_With_Yes(t *testing.T) {
 	})
 }
 
+func TestServer_Twilio_Call_Delayed(t *testing.T) {
+	var calls atomic.Int32
+	twilioServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		require.Nil(t, err)
+		require.Contains(t, string(body), "To=%2B11122233344")
+		require.Contains(t, string(body), "hi+later")
+		calls.Add(1)
+	}))
+	defer twilioServer.Close()
+
+	c := newTestConfigWithAuthFile(t)
+	c.TwilioCallsBaseURL = twilioServer.URL
+	c.TwilioAccount = "AC1234567890"
+	c.TwilioAuthToken = "AAEAA1234567890"
+	c.TwilioPhoneNumber = "+1234567890"
+	s := newTestServer(t, c)
+
+	// Add tier and user
+	require.Nil(t, s.userManager.AddTier(&user.Tier{
+		Code:         "pro",
+		MessageLimit: 10,
+		CallLimit:    1,
+	}))
+	require.Nil(t, s.userManager.AddUser("phil", "phil", user.RoleUser))
+	require.Nil(t, s.userManager.ChangeTier("phil", "pro"))
+	u, err := s.userManager.User("phil")
+	require.Nil(t, err)
+	require.Nil(t, s.userManager.AddPhoneNumber(u.ID, "+11122233344"))
+
+	// Publish two delayed calls; the call limit is only checked when the calls are made
+	for i := 0; i < 2; i++ {
+		response := request(t, s, "POST", "/mytopic", "hi later", map[string]string{
+			"authorization": util.BasicAuth("phil", "phil"),
+			"x-call":        "yes",
+			"x-delay":       "20 min",
+		})
+		require.Equal(t, 200, response.Code)
+	}
+	require.Nil(t, s.sendDelayedMessages())
+	require.Equal(t, int32(0), calls.Load())
+
+	_, err = s.messageCache.db.Exec(`UPDATE messages SET time = ?`, time.Now().Unix())
+	require.Nil(t, err)
+	require.Nil(t, s.sendDelayedMessages())
+	waitFor(t, func() bool {
+		return calls.Load() == 1
+	})
+	time.Sleep(100 * time.Millisecond)
+	require.Equal(t, int32(1), calls.Load())
+}
+
+func TestServer_Twilio_Call_Delayed_NumberRemoved(t *testing.T) {
+	c := newTestConfigWithAuthFile(t)
+	c.TwilioCallsBaseURL = "http://dummy.invalid"
+	c.TwilioAccount = "AC1234567890"
+	c.TwilioAuthToken = "AAEAA1234567890"
+	c.TwilioPhoneNumber = "+1234567890"
+	s := newTestServer(t, c)
+
+	// Add tier and user
+	require.Nil(t, s.userManager.AddTier(&user.Tier{
+		Code:         "pro",
+		MessageLimit: 10,
+		CallLimit:    1,
+	}))
+	require.Nil(t, s.userManager.AddUser("phil", "phil", user.RoleUser))
+	require.Nil(t, s.userManager.ChangeTier("phil", "pro"))
+	u, err := s.userManager.User("phil")
+	require.Nil(t, err)
+	require.Nil(t, s.userManager.AddPhoneNumber(u.ID, "+11122233344"))
+
+	response := request(t, s, "POST", "/mytopic", "hi later", map[string]string{
+		"authorization": util.BasicAuth("phil", "phil"),
+		"x-call":        "+11122233344",
+		"x-delay":       "20 min",
+	})
+	require.Equal(t, 200, response.Code)
+
+	// Phone number is removed before the message is due, so the call is not made or counted
+	require.Nil(t, s.userManager.RemovePhoneNumber(u.ID, "+11122233344"))
+	_, err = s.messageCache.db.Exec(`UPDATE messages SET time = ?`, time.Now().Unix())
+	require.Nil(t, err)
+	require.Nil(t, s.sendDelayedMessages())
+	u, err = s.userManager.User("phil")
+	require.Nil(t, err)
+	require.Equal(t, int64(0), s.visitor(netip.MustParseAddr("9.9.9.9"), u).Stats().Calls)
+}
+
 func TestServer_Twilio_Call_UnverifiedNumber(t *testing.T) {
 	c := newTestConfigWithAuthFile(t)
 	c.TwilioCallsBaseURL = "http://dummy.invalid"
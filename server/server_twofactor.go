@@ -0,0 +1,146 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+
+	"heckel.io/ntfy/v2/user"
+)
+
+const (
+	twoFactorDefaultIssuer = "ntfy"
+)
+
+// checkTwoFactorPasswordAuth restricts what a user can do when authenticating with a password (as opposed to
+// an access token). If the user has enabled two-factor authentication, the password can only be used to log in,
+// i.e. to create a token, which additionally requires a code (see verifyTwoFactorLogin). If two-factor authentication
+// is required for admins, admins without it can only use their password to set it up.
+func (s *Server) checkTwoFactorPasswordAuth(r *http.Request, u *user.User) error {
+	if u.Token != "" {
+		return nil
+	}
+	enabled, err := s.userManager.TwoFactorEnabled(u.ID)
+	if err != nil {
+		return err
+	} else if enabled {
+		if r.Method == http.MethodPost && r.URL.Path == apiAccountTokenPath {
+			return nil
+		}
+		return errHTTPUnauthorizedTwoFactorTokenRequired
+	} else if u.IsAdmin() && s.config.AuthRequireAdminTwoFactor {
+		if r.URL.Path == apiAccountTwoFactorPath || (r.Method == http.MethodGet && r.URL.Path == apiAccountPath) {
+			return nil
+		}
+		return errHTTPForbiddenTwoFactorRequired
+	}
+	return nil
+}
+
+// verifyTwoFactorLogin checks the two-factor authentication code when a user logs in with a password. Logins with
+// an access token (e.g. when creating another token) do not require a code, since the token itself is the proof.
+func (s *Server) verifyTwoFactorLogin(r *http.Request, v *visitor, u *user.User, code string) error {
+	if u.Token != "" {
+		return nil
+	}
+	enabled, err := s.userManager.TwoFactorEnabled(u.ID)
+	if err != nil {
+		return err
+	} else if !enabled {
+		return nil
+	} else if code == "" {
+		return errHTTPUnauthorizedTwoFactorCodeRequired
+	}
+	if err := s.userManager.VerifyTwoFactor(u.ID, code); errors.Is(err, user.ErrTwoFactorCodeInvalid) {
+		s.visitor(v.IP(), nil).AuthFailed() // Count towards the auth failure limit to prevent guessing codes
+		s.audit(r, v, user.AuditActionLoginFailed, u.Name, "two-factor code")
+		return errHTTPUnauthorizedTwoFactorCodeRequired
+	} else if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (s *Server) handleAccountTwoFactorSetup(w http.ResponseWriter, r *http.Request, v *visitor) error {
+	u := v.User()
+	secret, err := s.userManager.BeginTwoFactor(u.ID)
+	if errors.Is(err, user.ErrTwoFactorEnabled) {
+		return errHTTPConflictTwoFactorEnabled
+	} else if err != nil {
+		return err
+	}
+	logvr(v, r).Tag(tagAccount).Debug("Starting two-factor authentication setup for user %s", u.Name)
+	return s.writeJSON(w, &apiAccountTwoFactorSetupResponse{
+		Secret: secret,
+		URI:    user.TwoFactorURI(s.twoFactorIssuer(), u.Name, secret),
+	})
+}
+
+func (s *Server) handleAccountTwoFactorEnable(w http.ResponseWriter, r *http.Request, v *visitor) error {
+	req, err := readJSONWithLimit[apiAccountTwoFactorEnableRequest](r.Body, jsonBodyBytesLimit, false)
+	if err != nil {
+		return err
+	}
+	u := v.User()
+	recoveryCodes, err := s.userManagerAs(v, "").EnableTwoFactor(u.ID, req.Code)
+	if errors.Is(err, user.ErrTwoFactorNotFound) {
+		return errHTTPBadRequestTwoFactorNotSetUp
+	} else if errors.Is(err, user.ErrTwoFactorEnabled) {
+		return errHTTPConflictTwoFactorEnabled
+	} else if errors.Is(err, user.ErrTwoFactorCodeInvalid) {
+		return errHTTPBadRequestTwoFactorCodeInvalid
+	} else if err != nil {
+		return err
+	}
+	logvr(v, r).Tag(tagAccount).Info("Enabled two-factor authentication for user %s", u.Name)
+	return s.writeJSON(w, &apiAccountTwoFactorEnableResponse{
+		RecoveryCodes: recoveryCodes,
+	})
+}
+
+func (s *Server) handleAccountTwoFactorDisable(w http.ResponseWriter, r *http.Request, v *visitor) error {
+	req, err := readJSONWithLimit[apiAccountTwoFactorDisableRequest](r.Body, jsonBodyBytesLimit, false)
+	if err != nil {
+		return err
+	} else if req.Code == "" {
+		return errHTTPBadRequest
+	}
+	u := v.User()
+	if u.IsAdmin() && s.config.AuthRequireAdminTwoFactor {
+		return errHTTPForbiddenTwoFactorRequired
+	}
+	oidcUser, err := s.userManager.IsOIDCUser(u)
+	if err != nil {
+		return err
+	}
+	if !oidcUser {
+		// OIDC users have no password, so the code alone has to do. Everyone else confirms their password
+		// via the auther, so that LDAP users can use their directory password.
+		if req.Password == "" {
+			return errHTTPBadRequest
+		} else if _, err := s.auther.Authenticate(u.Name, req.Password); err != nil {
+			return errHTTPBadRequestIncorrectPasswordConfirmation
+		}
+	}
+	if err := s.userManager.VerifyTwoFactor(u.ID, req.Code); errors.Is(err, user.ErrTwoFactorNotFound) {
+		return errHTTPBadRequestTwoFactorNotSetUp
+	} else if errors.Is(err, user.ErrTwoFactorCodeInvalid) {
+		return errHTTPBadRequestTwoFactorCodeInvalid
+	} else if err != nil {
+		return err
+	}
+	logvr(v, r).Tag(tagAccount).Info("Disabling two-factor authentication for user %s", u.Name)
+	if err := s.userManagerAs(v, "").DisableTwoFactor(u.ID); err != nil {
+		return err
+	}
+	return s.writeJSON(w, newSuccessResponse())
+}
+
+// twoFactorIssuer returns the issuer shown in authenticator apps, which is the host name of the
+// server (if base-url is set), so that users can tell multiple ntfy servers apart
+func (s *Server) twoFactorIssuer() string {
+	if baseURL, err := url.Parse(s.config.BaseURL); err == nil && baseURL.Host != "" {
+		return baseURL.Host
+	}
+	return twoFactorDefaultIssuer
+}
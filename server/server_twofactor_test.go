@@ -0,0 +1,295 @@
+package server
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net/netip"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"heckel.io/ntfy/v2/user"
+	"heckel.io/ntfy/v2/util"
+)
+
+func TestAccount_TwoFactor_SetupLoginDisable(t *testing.T) {
+	conf := newTestConfigWithAuthFile(t)
+	conf.BaseURL = "https://ntfy.example.com"
+	s := newTestServer(t, conf)
+	defer s.closeDatabases()
+	require.Nil(t, s.userManager.AddUser("phil", "phil", user.RoleUser))
+	require.Nil(t, s.userManager.AllowAccess("phil", "mytopic", user.PermissionReadWrite))
+
+	// Begin setup, and confirm with a code
+	rr := request(t, s, "POST", "/v1/account/2fa", "", map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 200, rr.Code)
+	setup, err := util.UnmarshalJSON[apiAccountTwoFactorSetupResponse](io.NopCloser(rr.Body))
+	require.Nil(t, err)
+	require.Equal(t, fmt.Sprintf("otpauth://totp/ntfy.example.com:phil?issuer=ntfy.example.com&secret=%s", setup.Secret), setup.URI)
+
+	rr = request(t, s, "PUT", "/v1/account/2fa", `{"code":"000000"}`, map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 400, rr.Code)
+	require.Equal(t, 40054, toHTTPError(t, rr.Body.String()).Code)
+
+	rr = request(t, s, "PUT", "/v1/account/2fa", fmt.Sprintf(`{"code":"%s"}`, testTOTPCode(t, setup.Secret, time.Now())), map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 200, rr.Code)
+	enable, err := util.UnmarshalJSON[apiAccountTwoFactorEnableResponse](io.NopCloser(rr.Body))
+	require.Nil(t, err)
+	require.Equal(t, 10, len(enable.RecoveryCodes))
+
+	// Password alone is not enough anymore, neither for logins nor for anything else
+	rr = request(t, s, "POST", "/v1/account/token", "", map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 401, rr.Code)
+	require.Equal(t, 40102, toHTTPError(t, rr.Body.String()).Code)
+	rr = request(t, s, "POST", "/v1/account/token", `{"code":"123456"}`, map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 401, rr.Code)
+	require.Equal(t, 40102, toHTTPError(t, rr.Body.String()).Code)
+	rr = request(t, s, "PUT", "/mytopic", "hi", map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 401, rr.Code)
+	require.Equal(t, 40103, toHTTPError(t, rr.Body.String()).Code)
+	rr = request(t, s, "POST", "/v1/account/2fa", "", map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 401, rr.Code)
+
+	// Log in with a code (the code used during setup cannot be reused), and with a recovery code
+	rr = request(t, s, "POST", "/v1/account/token", fmt.Sprintf(`{"code":"%s"}`, testTOTPCode(t, setup.Secret, time.Now().Add(30*time.Second))), map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 200, rr.Code)
+	token, err := util.UnmarshalJSON[apiAccountTokenResponse](io.NopCloser(rr.Body))
+	require.Nil(t, err)
+
+	rr = request(t, s, "POST", "/v1/account/token", fmt.Sprintf(`{"code":"%s"}`, enable.RecoveryCodes[0]), map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 200, rr.Code)
+
+	// Tokens work as usual, also via basic auth (for scripts), and do not require a code to create more tokens
+	rr = request(t, s, "PUT", "/mytopic", "hi", map[string]string{
+		"Authorization": util.BasicAuth("", token.Token),
+	})
+	require.Equal(t, 200, rr.Code)
+	rr = request(t, s, "POST", "/v1/account/token", `{"label":"script"}`, map[string]string{
+		"Authorization": util.BearerAuth(token.Token),
+	})
+	require.Equal(t, 200, rr.Code)
+	rr = request(t, s, "GET", "/v1/account", "", map[string]string{
+		"Authorization": util.BearerAuth(token.Token),
+	})
+	require.Equal(t, 200, rr.Code)
+	account, err := util.UnmarshalJSON[apiAccountResponse](io.NopCloser(rr.Body))
+	require.Nil(t, err)
+	require.True(t, account.TwoFactor)
+
+	// Setting it up again is not possible
+	rr = request(t, s, "POST", "/v1/account/2fa", "", map[string]string{
+		"Authorization": util.BearerAuth(token.Token),
+	})
+	require.Equal(t, 409, rr.Code)
+	require.Equal(t, 40906, toHTTPError(t, rr.Body.String()).Code)
+
+	// Disable requires password and code
+	rr = request(t, s, "DELETE", "/v1/account/2fa", fmt.Sprintf(`{"password":"wrong","code":"%s"}`, enable.RecoveryCodes[1]), map[string]string{
+		"Authorization": util.BearerAuth(token.Token),
+	})
+	require.Equal(t, 400, rr.Code)
+	require.Equal(t, 40026, toHTTPError(t, rr.Body.String()).Code)
+	rr = request(t, s, "DELETE", "/v1/account/2fa", fmt.Sprintf(`{"password":"phil","code":"%s"}`, enable.RecoveryCodes[0]), map[string]string{
+		"Authorization": util.BearerAuth(token.Token),
+	})
+	require.Equal(t, 400, rr.Code)
+	require.Equal(t, 40054, toHTTPError(t, rr.Body.String()).Code)
+	rr = request(t, s, "DELETE", "/v1/account/2fa", fmt.Sprintf(`{"password":"phil","code":"%s"}`, enable.RecoveryCodes[1]), map[string]string{
+		"Authorization": util.BearerAuth(token.Token),
+	})
+	require.Equal(t, 200, rr.Code)
+
+	rr = request(t, s, "PUT", "/mytopic", "hi", map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 200, rr.Code)
+}
+
+func TestAccount_TwoFactor_RequiredForAdmins(t *testing.T) {
+	conf := newTestConfigWithAuthFile(t)
+	conf.AuthRequireAdminTwoFactor = true
+	s := newTestServer(t, conf)
+	defer s.closeDatabases()
+	require.Nil(t, s.userManager.AddUser("phil", "phil", user.RoleAdmin))
+	require.Nil(t, s.userManager.AddUser("ben", "ben", user.RoleUser))
+
+	// Admins without two-factor authentication can only set it up
+	rr := request(t, s, "POST", "/v1/account/token", "", map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 403, rr.Code)
+	require.Equal(t, 40304, toHTTPError(t, rr.Body.String()).Code)
+	rr = request(t, s, "PUT", "/mytopic", "hi", map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 403, rr.Code)
+	rr = request(t, s, "GET", "/v1/account", "", map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 200, rr.Code)
+
+	// Regular users are not affected
+	rr = request(t, s, "POST", "/v1/account/token", "", map[string]string{
+		"Authorization": util.BasicAuth("ben", "ben"),
+	})
+	require.Equal(t, 200, rr.Code)
+
+	// Existing tokens keep working
+	phil, err := s.userManager.User("phil")
+	require.Nil(t, err)
+	token, err := s.userManager.CreateToken(phil.ID, "script", time.Unix(0, 0), netip.IPv4Unspecified(), nil)
+	require.Nil(t, err)
+	rr = request(t, s, "PUT", "/mytopic", "hi", map[string]string{
+		"Authorization": util.BearerAuth(token.Value),
+	})
+	require.Equal(t, 200, rr.Code)
+
+	// Set up, then log in
+	rr = request(t, s, "POST", "/v1/account/2fa", "", map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 200, rr.Code)
+	setup, err := util.UnmarshalJSON[apiAccountTwoFactorSetupResponse](io.NopCloser(rr.Body))
+	require.Nil(t, err)
+	require.True(t, strings.HasPrefix(setup.URI, "otpauth://totp/"))
+	require.Contains(t, setup.URI, ":phil?")
+	rr = request(t, s, "PUT", "/v1/account/2fa", fmt.Sprintf(`{"code":"%s"}`, testTOTPCode(t, setup.Secret, time.Now())), map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 200, rr.Code)
+	enable, err := util.UnmarshalJSON[apiAccountTwoFactorEnableResponse](io.NopCloser(rr.Body))
+	require.Nil(t, err)
+	rr = request(t, s, "POST", "/v1/account/token", fmt.Sprintf(`{"code":"%s"}`, enable.RecoveryCodes[0]), map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 200, rr.Code)
+
+	// Admins cannot disable it
+	rr = request(t, s, "DELETE", "/v1/account/2fa", fmt.Sprintf(`{"password":"phil","code":"%s"}`, enable.RecoveryCodes[1]), map[string]string{
+		"Authorization": util.BearerAuth(token.Value),
+	})
+	require.Equal(t, 403, rr.Code)
+	require.Equal(t, 40304, toHTTPError(t, rr.Body.String()).Code)
+}
+
+func TestAccount_TwoFactor_DisableWithAutherPassword(t *testing.T) {
+	s := newTestServer(t, newTestConfigWithAuthFile(t))
+	defer s.closeDatabases()
+	require.Nil(t, s.userManager.AddUser("phil", "phil", user.RoleUser))
+	s.auther = &testDirectoryAuther{Manager: s.userManager, passwords: map[string]string{"phil": "directory-pass"}}
+	phil, err := s.userManager.User("phil")
+	require.Nil(t, err)
+	token, err := s.userManager.CreateToken(phil.ID, "", time.Unix(0, 0), netip.IPv4Unspecified(), nil)
+	require.Nil(t, err)
+	recoveryCodes := testTwoFactorEnable(t, s, token.Value)
+
+	// The password is checked by the auther (e.g. LDAP), not against the local password
+	rr := request(t, s, "DELETE", "/v1/account/2fa", fmt.Sprintf(`{"password":"phil","code":"%s"}`, recoveryCodes[0]), map[string]string{
+		"Authorization": util.BearerAuth(token.Value),
+	})
+	require.Equal(t, 400, rr.Code)
+	require.Equal(t, 40026, toHTTPError(t, rr.Body.String()).Code)
+	rr = request(t, s, "DELETE", "/v1/account/2fa", fmt.Sprintf(`{"code":"%s"}`, recoveryCodes[0]), map[string]string{
+		"Authorization": util.BearerAuth(token.Value),
+	})
+	require.Equal(t, 400, rr.Code)
+	rr = request(t, s, "DELETE", "/v1/account/2fa", fmt.Sprintf(`{"password":"directory-pass","code":"%s"}`, recoveryCodes[0]), map[string]string{
+		"Authorization": util.BearerAuth(token.Value),
+	})
+	require.Equal(t, 200, rr.Code)
+}
+
+func TestAccount_TwoFactor_DisableOIDCUser(t *testing.T) {
+	s := newTestServer(t, newTestConfigWithAuthFile(t))
+	defer s.closeDatabases()
+	require.Nil(t, s.userManager.AddOIDCUser("phil", user.RoleUser, "https://idp.example.com", "sub-1234"))
+	phil, err := s.userManager.User("phil")
+	require.Nil(t, err)
+	token, err := s.userManager.CreateToken(phil.ID, "", time.Unix(0, 0), netip.IPv4Unspecified(), nil)
+	require.Nil(t, err)
+	recoveryCodes := testTwoFactorEnable(t, s, token.Value)
+
+	// OIDC users have no password, so the code alone is enough, but it must be valid
+	rr := request(t, s, "DELETE", "/v1/account/2fa", `{"code":"000000"}`, map[string]string{
+		"Authorization": util.BearerAuth(token.Value),
+	})
+	require.Equal(t, 400, rr.Code)
+	require.Equal(t, 40054, toHTTPError(t, rr.Body.String()).Code)
+	rr = request(t, s, "DELETE", "/v1/account/2fa", fmt.Sprintf(`{"code":"%s"}`, recoveryCodes[0]), map[string]string{
+		"Authorization": util.BearerAuth(token.Value),
+	})
+	require.Equal(t, 200, rr.Code)
+
+	rr = request(t, s, "GET", "/v1/account", "", map[string]string{
+		"Authorization": util.BearerAuth(token.Value),
+	})
+	require.Equal(t, 200, rr.Code)
+	account, err := util.UnmarshalJSON[apiAccountResponse](io.NopCloser(rr.Body))
+	require.Nil(t, err)
+	require.False(t, account.TwoFactor)
+}
+
+// testDirectoryAuther is an auther that checks passwords against a fixed list instead of the user database,
+// like the LDAP auther does with the directory
+type testDirectoryAuther struct {
+	*user.Manager
+	passwords map[string]string
+}
+
+func (a *testDirectoryAuther) Authenticate(username, password string) (*user.User, error) {
+	if p, ok := a.passwords[username]; !ok || p != password {
+		return nil, user.ErrUnauthenticated
+	}
+	return a.Manager.User(username)
+}
+
+func testTwoFactorEnable(t *testing.T, s *Server, token string) []string {
+	rr := request(t, s, "POST", "/v1/account/2fa", "", map[string]string{
+		"Authorization": util.BearerAuth(token),
+	})
+	require.Equal(t, 200, rr.Code)
+	setup, err := util.UnmarshalJSON[apiAccountTwoFactorSetupResponse](io.NopCloser(rr.Body))
+	require.Nil(t, err)
+	rr = request(t, s, "PUT", "/v1/account/2fa", fmt.Sprintf(`{"code":"%s"}`, testTOTPCode(t, setup.Secret, time.Now())), map[string]string{
+		"Authorization": util.BearerAuth(token),
+	})
+	require.Equal(t, 200, rr.Code)
+	enable, err := util.UnmarshalJSON[apiAccountTwoFactorEnableResponse](io.NopCloser(rr.Body))
+	require.Nil(t, err)
+	return enable.RecoveryCodes
+}
+
+func testTOTPCode(t *testing.T, secret string, now time.Time) string {
+	key, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(secret)
+	require.Nil(t, err)
+	var counter [8]byte
+	binary.BigEndian.PutUint64(counter[:], uint64(now.Unix()/30))
+	h := hmac.New(sha1.New, key)
+	h.Write(counter[:])
+	sum := h.Sum(nil)
+	offset := sum[len(sum)-1] & 0x0f
+	return fmt.Sprintf("%06d", (binary.BigEndian.Uint32(sum[offset:offset+4])&0x7fffffff)%1000000)
+}
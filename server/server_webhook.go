@@ -0,0 +1,450 @@
+package server
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"regexp"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"heckel.io/ntfy/v2/log"
+	"heckel.io/ntfy/v2/user"
+	"heckel.io/ntfy/v2/util"
+)
+
+// Outbound webhooks:
+//
+// Topic owners can register webhooks for their reserved topics (see handleAccountReservationWebhookAdd). Every
+// message published to the topic is then POSTed as JSON to the webhook URL, in the same format as the JSON stream.
+// This includes updates, deletes and acknowledgements (message_update, message_delete and message_ack events).
+// The request is signed with the webhook's secret:
+//
+//	X-Ntfy-Timestamp: 1700000000
+//	X-Ntfy-Signature: sha256=<hex(hmac_sha256(secret, timestamp + "." + body))>
+//
+// Deliveries are stored in the message cache, so they survive restarts. If a delivery fails (no 2xx response),
+// it is retried with exponential backoff by the delayed sender (see sendWebhookDeliveries), until it succeeds or
+// webhookDeliveryMaxAttempts is reached.
+//
+// Webhooks are never delivered to loopback, private, link-local (incl. cloud metadata endpoints) or otherwise
+// non-public addresses, unless they are listed in webhook-allowed-hosts. This is checked at dial time against the
+// resolved IP address, so that DNS names pointing to internal hosts (or redirecting DNS responses) are caught too.
+
+const (
+	webhookDeliveryStatusPending   = "pending"
+	webhookDeliveryStatusDelivered = "delivered"
+	webhookDeliveryStatusFailed    = "failed"
+)
+
+const (
+	webhookDeliveryIDPrefix       = "wd_"
+	webhookDeliveryIDLength       = 16
+	webhookDeliveryMaxAttempts    = 10
+	webhookDeliveryBackoffMin     = 30 * time.Second // Delay before the first retry; doubled with every attempt
+	webhookDeliveryBackoffMax     = 6 * time.Hour
+	webhookDeliveryTimeout        = 15 * time.Second
+	webhookDeliveryClaimDuration  = time.Minute // Pending deliveries are not retried while an attempt is in progress
+	webhookDeliveryBatchSize      = 100
+	webhookDeliveryErrorMaxLength = 256
+	webhookDeliveriesKeepDuration = 7 * 24 * time.Hour // Completed deliveries are kept this long, for the status API
+	webhookDeliveriesListLimit    = 100
+	webhookTopicLimit             = 10 // Max number of webhooks per topic
+	webhookTimestampHeader        = "X-Ntfy-Timestamp"
+	webhookSignatureHeader        = "X-Ntfy-Signature"
+	webhookIDHeader               = "X-Ntfy-Webhook"
+	webhookDeliveryIDHeader       = "X-Ntfy-Delivery"
+)
+
+var (
+	errWebhookAddressNotAllowed = errors.New("address not allowed")
+
+	// webhookDisallowedPrefixes are non-public ranges that are not covered by the netip.Addr Is* functions
+	webhookDisallowedPrefixes = []netip.Prefix{
+		netip.MustParsePrefix("0.0.0.0/8"),     // "This" network
+		netip.MustParsePrefix("100.64.0.0/10"), // Carrier-grade NAT
+		netip.MustParsePrefix("192.0.0.0/24"),  // IETF protocol assignments
+		netip.MustParsePrefix("198.18.0.0/15"), // Benchmarking
+		netip.MustParsePrefix("240.0.0.0/4"),   // Reserved, incl. broadcast
+		netip.MustParsePrefix("64:ff9b::/96"),  // NAT64, may map to any IPv4 address
+	}
+)
+
+// publishToWebhooks creates a delivery for every webhook of the message's topic, and attempts to deliver
+// them right away. Failed deliveries are retried by sendWebhookDeliveries.
+func (s *Server) publishToWebhooks(v *visitor, m *message) {
+	if m.Event == pollRequestEvent {
+		return
+	}
+	webhooks, err := s.userManager.TopicWebhooks(m.Topic)
+	if err != nil {
+		logvm(v, m).Tag(tagWebhook).Err(err).Warn("Unable to read webhooks")
+		return
+	} else if len(webhooks) == 0 {
+		return
+	}
+	if owner, err := s.userManager.ReservationOwner(m.Topic); err != nil {
+		logvm(v, m).Tag(tagWebhook).Err(err).Warn("Unable to read topic owner")
+		return
+	} else if owner == "" {
+		logvm(v, m).Tag(tagWebhook).Debug("Topic is no longer reserved, not delivering to webhooks")
+		return
+	}
+	payload, err := json.Marshal(m)
+	if err != nil {
+		logvm(v, m).Tag(tagWebhook).Err(err).Warn("Unable to marshal message")
+		return
+	}
+	now := time.Now().Unix()
+	for _, webhook := range webhooks {
+		d := &webhookDelivery{
+			ID:        util.RandomStringPrefix(webhookDeliveryIDPrefix, webhookDeliveryIDLength),
+			WebhookID: webhook.ID,
+			Topic:     m.Topic,
+			MessageID: m.ID,
+			Payload:   string(payload),
+			Status:    webhookDeliveryStatusPending,
+			Next:      now + int64(webhookDeliveryClaimDuration.Seconds()), // Claimed by the first attempt below
+			Updated:   now,
+		}
+		if err := s.messageCache.AddWebhookDelivery(d); err != nil {
+			logvm(v, m).Tag(tagWebhook).Field("webhook_id", webhook.ID).Err(err).Warn("Unable to add webhook delivery")
+			continue
+		}
+		go s.attemptWebhookDelivery(webhook, d)
+	}
+}
+
+// sendWebhookDeliveries retries all pending webhook deliveries that are due at the given time. It is called
+// by the delayed sender.
+func (s *Server) sendWebhookDeliveries(now time.Time) error {
+	if s.userManager == nil || !s.config.EnableWebhooks {
+		return nil
+	}
+	deliveries, err := s.messageCache.WebhookDeliveriesDue(now, webhookDeliveryBatchSize)
+	if err != nil {
+		return err
+	}
+	for _, d := range deliveries {
+		ev := log.Tag(tagWebhook).Fields(log.Context{"webhook_id": d.WebhookID, "webhook_delivery_id": d.ID, "message_id": d.MessageID})
+		claimed, err := s.messageCache.ClaimWebhookDelivery(d, now.Add(webhookDeliveryClaimDuration).Unix())
+		if err != nil {
+			ev.Err(err).Warn("Unable to claim webhook delivery")
+			continue
+		} else if !claimed {
+			continue // Picked up elsewhere
+		}
+		webhook, err := s.userManager.TopicWebhook(d.WebhookID)
+		if errors.Is(err, user.ErrTopicWebhookNotFound) || (err == nil && webhook.Topic != d.Topic) {
+			ev.Debug("Webhook was removed, giving up delivery")
+			s.failWebhookDelivery(d, "webhook removed")
+			continue
+		} else if err != nil {
+			ev.Err(err).Warn("Unable to read webhook")
+			continue
+		}
+		owner, err := s.userManager.ReservationOwner(d.Topic)
+		if err != nil {
+			ev.Err(err).Warn("Unable to read topic owner")
+			continue
+		} else if owner == "" {
+			ev.Debug("Topic is no longer reserved, giving up delivery")
+			s.failWebhookDelivery(d, "topic no longer reserved")
+			continue
+		}
+		go s.attemptWebhookDelivery(webhook, d)
+	}
+	return nil
+}
+
+// failWebhookDelivery marks a pending delivery as failed without attempting it
+func (s *Server) failWebhookDelivery(d *webhookDelivery, reason string) {
+	d.Status, d.Error, d.Updated = webhookDeliveryStatusFailed, reason, time.Now().Unix()
+	if err := s.messageCache.UpdateWebhookDelivery(d); err != nil {
+		log.Tag(tagWebhook).Field("webhook_delivery_id", d.ID).Err(err).Warn("Unable to update webhook delivery")
+	}
+}
+
+// attemptWebhookDelivery POSTs the delivery's payload to the webhook URL, and stores the result. If the attempt
+// fails, the next attempt is scheduled with exponential backoff, unless the max number of attempts is reached.
+func (s *Server) attemptWebhookDelivery(webhook *user.TopicWebhook, d *webhookDelivery) {
+	ev := log.Tag(tagWebhook).Fields(log.Context{
+		"webhook_id":          webhook.ID,
+		"webhook_delivery_id": d.ID,
+		"message_id":          d.MessageID,
+		"topic":               d.Topic,
+	})
+	statusCode, err := s.postWebhook(webhook, d)
+	now := time.Now()
+	d.Attempts++
+	d.StatusCode = statusCode
+	d.Updated = now.Unix()
+	if err == nil {
+		ev.Debug("Delivered message to webhook %s (attempt %d)", webhook.URL, d.Attempts)
+		d.Status, d.Error = webhookDeliveryStatusDelivered, ""
+	} else if d.Attempts >= webhookDeliveryMaxAttempts {
+		ev.Err(err).Info("Unable to deliver message to webhook %s, giving up after %d attempts", webhook.URL, d.Attempts)
+		d.Status, d.Error = webhookDeliveryStatusFailed, webhookDeliveryError(err)
+	} else {
+		backoff := webhookDeliveryBackoff(d.Attempts)
+		ev.Err(err).Debug("Unable to deliver message to webhook %s (attempt %d), retrying in %s", webhook.URL, d.Attempts, backoff)
+		d.Error = webhookDeliveryError(err)
+		d.Next = now.Add(backoff).Unix()
+	}
+	if err := s.messageCache.UpdateWebhookDelivery(d); err != nil {
+		ev.Err(err).Warn("Unable to update webhook delivery")
+	}
+}
+
+// postWebhook sends a single signed webhook request, and returns the response status code (0 if there was no
+// response). Any non-2xx response is considered a failure.
+func (s *Server) postWebhook(webhook *user.TopicWebhook, d *webhookDelivery) (int, error) {
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req, err := http.NewRequest(http.MethodPost, webhook.URL, strings.NewReader(d.Payload))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("User-Agent", "ntfy/"+s.config.Version)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(webhookIDHeader, webhook.ID)
+	req.Header.Set(webhookDeliveryIDHeader, d.ID)
+	req.Header.Set(webhookTimestampHeader, timestamp)
+	req.Header.Set(webhookSignatureHeader, "sha256="+webhookSignature(webhook.Secret, timestamp, d.Payload))
+	resp, err := s.webhookClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("unexpected response status %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
+
+// newWebhookClient creates the HTTP client used to deliver webhooks. It does not use a proxy, and refuses to
+// connect to non-public addresses (see webhookAddrAllowed).
+func newWebhookClient(conf *Config) *http.Client {
+	dialer := &net.Dialer{
+		Timeout: webhookDeliveryTimeout,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			ip, err := netip.ParseAddr(host)
+			if err != nil {
+				return err
+			}
+			if !webhookAddrAllowed(ip, conf.WebhookAllowedIPAddrs) {
+				return errWebhookAddressNotAllowed
+			}
+			return nil
+		},
+	}
+	return &http.Client{
+		Timeout: webhookDeliveryTimeout,
+		Transport: &http.Transport{
+			DialContext:         dialer.DialContext,
+			TLSHandshakeTimeout: webhookDeliveryTimeout,
+		},
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse // Redirects are failures, the signature would be leaked otherwise
+		},
+	}
+}
+
+// webhookAddrAllowed returns true if a webhook may be delivered to the given IP address, i.e. if it is a public
+// address, or if it is explicitly allowed
+func webhookAddrAllowed(ip netip.Addr, allowed []netip.Prefix) bool {
+	ip = ip.Unmap()
+	if util.ContainsIP(allowed, ip) {
+		return true
+	}
+	if !ip.IsValid() || ip.IsUnspecified() || ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() ||
+		ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() || ip.IsMulticast() {
+		return false
+	}
+	return !util.ContainsIP(webhookDisallowedPrefixes, ip)
+}
+
+// webhookDeliveryError returns the error message of a failed attempt, cut off after webhookDeliveryErrorMaxLength
+func webhookDeliveryError(err error) string {
+	if len(err.Error()) > webhookDeliveryErrorMaxLength {
+		return err.Error()[:webhookDeliveryErrorMaxLength]
+	}
+	return err.Error()
+}
+
+// webhookSignature calculates the HMAC-SHA256 signature over the timestamp and the request body
+func webhookSignature(secret, timestamp, payload string) string {
+	h := hmac.New(sha256.New, []byte(secret))
+	h.Write([]byte(timestamp + "." + payload))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// webhookDeliveryBackoff returns the delay before the next attempt, after the given number of failed attempts
+func webhookDeliveryBackoff(attempts int) time.Duration {
+	backoff := webhookDeliveryBackoffMin
+	for i := 1; i < attempts && backoff < webhookDeliveryBackoffMax; i++ {
+		backoff *= 2
+	}
+	if backoff > webhookDeliveryBackoffMax {
+		return webhookDeliveryBackoffMax
+	}
+	return backoff
+}
+
+// pruneWebhookDeliveries deletes completed webhook deliveries that are older than webhookDeliveriesKeepDuration
+func (s *Server) pruneWebhookDeliveries() {
+	if err := s.messageCache.DeleteWebhookDeliveriesUpdatedBefore(time.Now().Add(-webhookDeliveriesKeepDuration)); err != nil {
+		log.Tag(tagManager).Err(err).Warn("Error deleting old webhook deliveries")
+	}
+}
+
+func (s *Server) handleAccountReservationWebhooksGet(w http.ResponseWriter, r *http.Request, v *visitor) error {
+	topic, err := s.webhookTopicFromPath(r, v, apiAccountReservationWebhookRegex)
+	if err != nil {
+		return err
+	}
+	webhooks, err := s.userManager.TopicWebhooks(topic)
+	if err != nil {
+		return err
+	}
+	response := make([]*apiAccountWebhookResponse, 0)
+	for _, webhook := range webhooks {
+		response = append(response, newAPIAccountWebhookResponse(webhook, false))
+	}
+	return s.writeJSON(w, response)
+}
+
+func (s *Server) handleAccountReservationWebhookAdd(w http.ResponseWriter, r *http.Request, v *visitor) error {
+	topic, err := s.webhookTopicFromPath(r, v, apiAccountReservationWebhookRegex)
+	if err != nil {
+		return err
+	}
+	req, err := readJSONWithLimit[apiAccountWebhookRequest](r.Body, jsonBodyBytesLimit, false)
+	if err != nil {
+		return err
+	} else if !urlRegex.MatchString(req.URL) {
+		return errHTTPBadRequestWebhookInvalid.Wrap("url must start with http:// or https://")
+	}
+	webhooks, err := s.userManager.TopicWebhooks(topic)
+	if err != nil {
+		return err
+	} else if len(webhooks) >= webhookTopicLimit {
+		return errHTTPBadRequestWebhookInvalid.Wrap("too many webhooks for topic, max %d", webhookTopicLimit)
+	}
+	webhook, err := s.userManager.AddTopicWebhook(topic, req.URL)
+	if err != nil {
+		return err
+	}
+	logvr(v, r).
+		Tag(tagWebhook).
+		Fields(log.Context{
+			"topic":      topic,
+			"webhook_id": webhook.ID,
+		}).
+		Info("Added webhook %s for topic %s", webhook.ID, topic)
+	return s.writeJSON(w, newAPIAccountWebhookResponse(webhook, true))
+}
+
+func (s *Server) handleAccountReservationWebhookDelete(w http.ResponseWriter, r *http.Request, v *visitor) error {
+	topic, err := s.webhookTopicFromPath(r, v, apiAccountReservationWebhookSingleRegex)
+	if err != nil {
+		return err
+	}
+	webhookID := apiAccountReservationWebhookSingleRegex.FindStringSubmatch(r.URL.Path)[2]
+	if err := s.userManager.RemoveTopicWebhook(topic, webhookID); errors.Is(err, user.ErrTopicWebhookNotFound) {
+		return errHTTPNotFoundWebhook
+	} else if err != nil {
+		return err
+	}
+	if err := s.messageCache.DeleteWebhookDeliveries(webhookID); err != nil {
+		return err
+	}
+	logvr(v, r).
+		Tag(tagWebhook).
+		Fields(log.Context{
+			"topic":      topic,
+			"webhook_id": webhookID,
+		}).
+		Info("Removed webhook %s from topic %s", webhookID, topic)
+	return s.writeJSON(w, newSuccessResponse())
+}
+
+func (s *Server) handleAccountReservationWebhookDeliveriesGet(w http.ResponseWriter, r *http.Request, v *visitor) error {
+	topic, err := s.webhookTopicFromPath(r, v, apiAccountReservationWebhookDeliveriesRegex)
+	if err != nil {
+		return err
+	}
+	webhookID := apiAccountReservationWebhookDeliveriesRegex.FindStringSubmatch(r.URL.Path)[2]
+	webhook, err := s.userManager.TopicWebhook(webhookID)
+	if errors.Is(err, user.ErrTopicWebhookNotFound) || (err == nil && webhook.Topic != topic) {
+		return errHTTPNotFoundWebhook
+	} else if err != nil {
+		return err
+	}
+	deliveries, err := s.messageCache.WebhookDeliveries(webhookID, webhookDeliveriesListLimit)
+	if err != nil {
+		return err
+	}
+	response := make([]*apiAccountWebhookDeliveryResponse, 0)
+	for _, d := range deliveries {
+		delivery := &apiAccountWebhookDeliveryResponse{
+			ID:         d.ID,
+			MessageID:  d.MessageID,
+			Status:     d.Status,
+			Attempts:   d.Attempts,
+			StatusCode: d.StatusCode,
+			Error:      d.Error,
+			Updated:    d.Updated,
+		}
+		if d.Status == webhookDeliveryStatusPending {
+			delivery.Next = d.Next
+		}
+		response = append(response, delivery)
+	}
+	return s.writeJSON(w, response)
+}
+
+// webhookTopicFromPath extracts the topic from the request path, and checks that the user owns the topic
+// (i.e. has a reservation for it). Admins may manage webhooks of all topics.
+func (s *Server) webhookTopicFromPath(r *http.Request, v *visitor, pathRegex *regexp.Regexp) (string, error) {
+	matches := pathRegex.FindStringSubmatch(r.URL.Path)
+	if len(matches) < 2 {
+		return "", errHTTPInternalErrorInvalidPath
+	}
+	topic, u := matches[1], v.User()
+	if !u.IsAdmin() {
+		hasReservation, err := s.userManager.HasReservation(u.Name, topic)
+		if err != nil {
+			return "", err
+		} else if !hasReservation {
+			return "", errHTTPForbidden
+		}
+	}
+	return topic, nil
+}
+
+func newAPIAccountWebhookResponse(webhook *user.TopicWebhook, withSecret bool) *apiAccountWebhookResponse {
+	response := &apiAccountWebhookResponse{
+		ID:      webhook.ID,
+		Topic:   webhook.Topic,
+		URL:     webhook.URL,
+		Created: webhook.Created.Unix(),
+	}
+	if withSecret {
+		response.Secret = webhook.Secret
+	}
+	return response
+}
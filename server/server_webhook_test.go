@@ -0,0 +1,457 @@
+package server
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"heckel.io/ntfy/v2/user"
+	"heckel.io/ntfy/v2/util"
+)
+
+func TestServer_Webhooks_Disabled(t *testing.T) {
+	s := newTestServer(t, newTestConfigWithAuthFile(t))
+	defer s.closeDatabases()
+
+	require.Nil(t, s.userManager.AddUser("phil", "phil", user.RoleAdmin))
+	rr := request(t, s, "POST", "/v1/account/reservation/mytopic/webhook", `{"url":"https://example.com"}`, map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 404, rr.Code)
+}
+
+func TestServer_Webhooks_PublishAndDeliver(t *testing.T) {
+	var mu sync.Mutex
+	var received []*http.Request
+	var bodies []string
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		defer mu.Unlock()
+		received = append(received, r)
+		bodies = append(bodies, string(body))
+	}))
+	defer target.Close()
+
+	s := newTestServer(t, newTestConfigWithWebhooks(t))
+	defer s.closeDatabases()
+
+	require.Nil(t, s.userManager.AddUser("phil", "phil", user.RoleUser))
+	require.Nil(t, s.userManager.AddReservation("phil", "mytopic", user.PermissionDenyAll))
+
+	// Register webhook, secret is only returned once
+	rr := request(t, s, "POST", "/v1/account/reservation/mytopic/webhook", `{"url":"`+target.URL+`/hook"}`, map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 200, rr.Code)
+	webhook, err := util.UnmarshalJSON[apiAccountWebhookResponse](io.NopCloser(rr.Body))
+	require.Nil(t, err)
+	require.True(t, strings.HasPrefix(webhook.ID, "wh_"))
+	require.Equal(t, "mytopic", webhook.Topic)
+	require.Equal(t, target.URL+"/hook", webhook.URL)
+	require.NotEmpty(t, webhook.Secret)
+
+	rr = request(t, s, "GET", "/v1/account/reservation/mytopic/webhook", "", map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 200, rr.Code)
+	var webhooks []*apiAccountWebhookResponse
+	require.Nil(t, json.NewDecoder(rr.Body).Decode(&webhooks))
+	require.Equal(t, 1, len(webhooks))
+	require.Equal(t, webhook.ID, webhooks[0].ID)
+	require.Empty(t, webhooks[0].Secret)
+
+	// Publishing delivers the message as signed JSON
+	rr = request(t, s, "POST", "/mytopic", "disk full", map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+		"Title":         "db1",
+	})
+	require.Equal(t, 200, rr.Code)
+	m := toMessage(t, rr.Body.String())
+	waitFor(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(received) == 1
+	})
+	mu.Lock()
+	r, body := received[0], bodies[0]
+	mu.Unlock()
+	require.Equal(t, "/hook", r.URL.Path)
+	require.Equal(t, "application/json", r.Header.Get("Content-Type"))
+	require.Equal(t, webhook.ID, r.Header.Get("X-Ntfy-Webhook"))
+	require.True(t, strings.HasPrefix(r.Header.Get("X-Ntfy-Delivery"), "wd_"))
+	require.Equal(t, "sha256="+webhookSignature(webhook.Secret, r.Header.Get("X-Ntfy-Timestamp"), body), r.Header.Get("X-Ntfy-Signature"))
+	delivered := toMessage(t, body)
+	require.Equal(t, m.ID, delivered.ID)
+	require.Equal(t, "db1", delivered.Title)
+	require.Equal(t, "disk full", delivered.Message)
+
+	// Delivery status is visible
+	var deliveries []*apiAccountWebhookDeliveryResponse
+	waitFor(t, func() bool {
+		rr = request(t, s, "GET", "/v1/account/reservation/mytopic/webhook/"+webhook.ID+"/deliveries", "", map[string]string{
+			"Authorization": util.BasicAuth("phil", "phil"),
+		})
+		require.Equal(t, 200, rr.Code)
+		require.Nil(t, json.NewDecoder(rr.Body).Decode(&deliveries))
+		return len(deliveries) == 1 && deliveries[0].Status == webhookDeliveryStatusDelivered
+	})
+	require.Equal(t, m.ID, deliveries[0].MessageID)
+	require.Equal(t, 1, deliveries[0].Attempts)
+	require.Equal(t, 200, deliveries[0].StatusCode)
+	require.Equal(t, int64(0), deliveries[0].Next)
+
+	// Removing the webhook stops deliveries
+	rr = request(t, s, "DELETE", "/v1/account/reservation/mytopic/webhook/"+webhook.ID, "", map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 200, rr.Code)
+	rr = request(t, s, "DELETE", "/v1/account/reservation/mytopic/webhook/"+webhook.ID, "", map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 404, rr.Code)
+	require.Equal(t, 40405, toHTTPError(t, rr.Body.String()).Code)
+
+	rr = request(t, s, "POST", "/mytopic", "disk still full", map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 200, rr.Code)
+	time.Sleep(200 * time.Millisecond)
+	mu.Lock()
+	require.Equal(t, 1, len(received))
+	mu.Unlock()
+}
+
+func TestServer_Webhooks_Retry(t *testing.T) {
+	var count atomic.Int32
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if count.Add(1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer target.Close()
+
+	s := newTestServer(t, newTestConfigWithWebhooks(t))
+	defer s.closeDatabases()
+
+	require.Nil(t, s.userManager.AddUser("phil", "phil", user.RoleAdmin))
+	require.Nil(t, s.userManager.AddReservation("phil", "mytopic", user.PermissionDenyAll))
+	webhook, err := s.userManager.AddTopicWebhook("mytopic", target.URL)
+	require.Nil(t, err)
+
+	// First attempt fails, and is rescheduled with backoff
+	rr := request(t, s, "POST", "/mytopic", "hi", map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 200, rr.Code)
+	var deliveries []*webhookDelivery
+	waitFor(t, func() bool {
+		deliveries, err = s.messageCache.WebhookDeliveries(webhook.ID, 10)
+		require.Nil(t, err)
+		return len(deliveries) == 1 && deliveries[0].Attempts == 1
+	})
+	require.Equal(t, webhookDeliveryStatusPending, deliveries[0].Status)
+	require.Equal(t, 503, deliveries[0].StatusCode)
+	require.Contains(t, deliveries[0].Error, "503")
+	require.InDelta(t, time.Now().Add(webhookDeliveryBackoffMin).Unix(), deliveries[0].Next, 2)
+
+	// Not retried before it is due (not claimed, so next is unchanged)
+	next := deliveries[0].Next
+	require.Nil(t, s.sendWebhookDeliveries(time.Now()))
+	deliveries, err = s.messageCache.WebhookDeliveries(webhook.ID, 10)
+	require.Nil(t, err)
+	require.Equal(t, next, deliveries[0].Next)
+	require.Equal(t, 1, deliveries[0].Attempts)
+
+	// Retried once it is due
+	require.Nil(t, s.sendWebhookDeliveries(time.Now().Add(webhookDeliveryBackoffMin+time.Second)))
+	waitFor(t, func() bool {
+		deliveries, err = s.messageCache.WebhookDeliveries(webhook.ID, 10)
+		require.Nil(t, err)
+		return len(deliveries) == 1 && deliveries[0].Status == webhookDeliveryStatusDelivered
+	})
+	require.Equal(t, 2, deliveries[0].Attempts)
+	require.Equal(t, 200, deliveries[0].StatusCode)
+	require.Equal(t, "", deliveries[0].Error)
+	require.Equal(t, int32(2), count.Load())
+}
+
+func TestServer_Webhooks_DeleteAndAck(t *testing.T) {
+	var mu sync.Mutex
+	var events []*message
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		defer mu.Unlock()
+		events = append(events, toMessage(t, string(body)))
+	}))
+	defer target.Close()
+
+	s := newTestServer(t, newTestConfigWithWebhooks(t))
+	defer s.closeDatabases()
+
+	require.Nil(t, s.userManager.AddUser("phil", "phil", user.RoleAdmin))
+	require.Nil(t, s.userManager.AddReservation("phil", "mytopic", user.PermissionDenyAll))
+	_, err := s.userManager.AddTopicWebhook("mytopic", target.URL)
+	require.Nil(t, err)
+
+	// Messages, acknowledgements and deletes are all delivered
+	rr := request(t, s, "PUT", "/mytopic", "disk full", map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+		"Actions":       "acknowledge, Got it",
+	})
+	require.Equal(t, 200, rr.Code)
+	m := toMessage(t, rr.Body.String())
+	waitFor(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(events) == 1
+	})
+	rr = request(t, s, "POST", "/mytopic/"+m.ID+"/ack", "", map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 200, rr.Code)
+	waitFor(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(events) == 2
+	})
+	rr = request(t, s, "DELETE", "/mytopic/"+m.ID, "", map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 200, rr.Code)
+	waitFor(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(events) == 3
+	})
+	mu.Lock()
+	defer mu.Unlock()
+	require.Equal(t, messageEvent, events[0].Event)
+	require.Equal(t, messageAckEvent, events[1].Event)
+	require.Equal(t, m.ID, events[1].Replaces)
+	require.Equal(t, "phil", events[1].Acks[0].User)
+	require.Equal(t, messageDeleteEvent, events[2].Event)
+	require.Equal(t, m.ID, events[2].Replaces)
+}
+
+func TestServer_Webhooks_PrivateAddressNotAllowed(t *testing.T) {
+	var count atomic.Int32
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		count.Add(1)
+	}))
+	defer target.Close()
+
+	conf := newTestConfigWithWebhooks(t)
+	conf.WebhookAllowedIPAddrs = make([]netip.Prefix, 0)
+	s := newTestServer(t, conf)
+	defer s.closeDatabases()
+
+	require.Nil(t, s.userManager.AddUser("phil", "phil", user.RoleAdmin))
+	require.Nil(t, s.userManager.AddReservation("phil", "mytopic", user.PermissionDenyAll))
+	webhook, err := s.userManager.AddTopicWebhook("mytopic", target.URL)
+	require.Nil(t, err)
+
+	rr := request(t, s, "POST", "/mytopic", "hi", map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 200, rr.Code)
+	var deliveries []*webhookDelivery
+	waitFor(t, func() bool {
+		deliveries, err = s.messageCache.WebhookDeliveries(webhook.ID, 10)
+		require.Nil(t, err)
+		return len(deliveries) == 1 && deliveries[0].Attempts == 1
+	})
+	require.Equal(t, webhookDeliveryStatusPending, deliveries[0].Status)
+	require.Equal(t, 0, deliveries[0].StatusCode)
+	require.Contains(t, deliveries[0].Error, "address not allowed")
+	require.Equal(t, int32(0), count.Load())
+}
+
+func TestServer_Webhooks_RemovedWithOwner(t *testing.T) {
+	var count atomic.Int32
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		count.Add(1)
+	}))
+	defer target.Close()
+
+	s := newTestServer(t, newTestConfigWithWebhooks(t))
+	defer s.closeDatabases()
+
+	require.Nil(t, s.userManager.AddUser("phil", "phil", user.RoleAdmin))
+	require.Nil(t, s.userManager.AddUser("ben", "ben", user.RoleUser))
+	require.Nil(t, s.userManager.AddUser("lisa", "lisa", user.RoleUser))
+	require.Nil(t, s.userManager.AddReservation("ben", "mytopic", user.PermissionDenyAll))
+	_, err := s.userManager.AddTopicWebhook("mytopic", target.URL)
+	require.Nil(t, err)
+
+	// Admin removes the owner, and another user reserves the topic
+	rr := request(t, s, "DELETE", "/v1/users", `{"username": "ben"}`, map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 200, rr.Code)
+	webhooks, err := s.userManager.TopicWebhooks("mytopic")
+	require.Nil(t, err)
+	require.Empty(t, webhooks)
+	require.Nil(t, s.userManager.AddReservation("lisa", "mytopic", user.PermissionDenyAll))
+
+	// Nothing is delivered to the old owner's webhook
+	rr = request(t, s, "POST", "/mytopic", "for lisa only", map[string]string{
+		"Authorization": util.BasicAuth("lisa", "lisa"),
+	})
+	require.Equal(t, 200, rr.Code)
+	deliveries, err := s.messageCache.WebhookDeliveriesDue(time.Now().Add(time.Hour), 10)
+	require.Nil(t, err)
+	require.Empty(t, deliveries)
+	require.Equal(t, int32(0), count.Load())
+}
+
+func TestServer_Webhooks_TopicNotReserved(t *testing.T) {
+	var count atomic.Int32
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		count.Add(1)
+	}))
+	defer target.Close()
+
+	s := newTestServer(t, newTestConfigWithWebhooks(t))
+	defer s.closeDatabases()
+
+	require.Nil(t, s.userManager.AddUser("phil", "phil", user.RoleAdmin))
+	webhook, err := s.userManager.AddTopicWebhook("mytopic", target.URL)
+	require.Nil(t, err)
+
+	// A webhook of a topic without owner is not delivered to
+	rr := request(t, s, "POST", "/mytopic", "hi", map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 200, rr.Code)
+	deliveries, err := s.messageCache.WebhookDeliveries(webhook.ID, 10)
+	require.Nil(t, err)
+	require.Empty(t, deliveries)
+
+	// Pending deliveries are given up once the topic is no longer reserved
+	require.Nil(t, s.messageCache.AddWebhookDelivery(&webhookDelivery{
+		ID:        "wd_1234",
+		WebhookID: webhook.ID,
+		Topic:     "mytopic",
+		MessageID: "m1",
+		Payload:   `{"id":"m1"}`,
+		Status:    webhookDeliveryStatusPending,
+		Next:      time.Now().Unix(),
+		Updated:   time.Now().Unix(),
+	}))
+	require.Nil(t, s.sendWebhookDeliveries(time.Now()))
+	deliveries, err = s.messageCache.WebhookDeliveries(webhook.ID, 10)
+	require.Nil(t, err)
+	require.Equal(t, 1, len(deliveries))
+	require.Equal(t, webhookDeliveryStatusFailed, deliveries[0].Status)
+	require.Equal(t, "topic no longer reserved", deliveries[0].Error)
+	require.Equal(t, int32(0), count.Load())
+}
+
+func TestWebhookAddrAllowed(t *testing.T) {
+	for _, addr := range []string{"127.0.0.1", "::1", "10.0.0.1", "172.16.5.4", "192.168.1.1", "169.254.169.254", "100.64.0.1", "0.0.0.0", "255.255.255.255", "::", "fd00::1", "fe80::1", "::ffff:127.0.0.1", "::ffff:169.254.169.254", "64:ff9b::a9fe:a9fe", "224.0.0.1"} {
+		require.False(t, webhookAddrAllowed(netip.MustParseAddr(addr), nil), addr)
+	}
+	for _, addr := range []string{"8.8.8.8", "1.1.1.1", "2606:4700:4700::1111", "::ffff:8.8.8.8"} {
+		require.True(t, webhookAddrAllowed(netip.MustParseAddr(addr), nil), addr)
+	}
+	allowed := []netip.Prefix{netip.MustParsePrefix("10.1.0.0/16")}
+	require.True(t, webhookAddrAllowed(netip.MustParseAddr("10.1.2.3"), allowed))
+	require.False(t, webhookAddrAllowed(netip.MustParseAddr("10.2.2.3"), allowed))
+}
+
+func TestServer_Webhooks_Permissions(t *testing.T) {
+	s := newTestServer(t, newTestConfigWithWebhooks(t))
+	defer s.closeDatabases()
+
+	require.Nil(t, s.userManager.AddUser("phil", "phil", user.RoleUser))
+	require.Nil(t, s.userManager.AddUser("ben", "ben", user.RoleUser))
+	require.Nil(t, s.userManager.AddReservation("phil", "mytopic", user.PermissionDenyAll))
+	require.Nil(t, s.userManager.AddReservation("ben", "bentopic", user.PermissionDenyAll))
+	webhook, err := s.userManager.AddTopicWebhook("mytopic", "https://example.com/hook")
+	require.Nil(t, err)
+
+	// Other users cannot manage the topic's webhooks
+	rr := request(t, s, "POST", "/v1/account/reservation/mytopic/webhook", `{"url":"https://example.com"}`, map[string]string{
+		"Authorization": util.BasicAuth("ben", "ben"),
+	})
+	require.Equal(t, 403, rr.Code)
+	rr = request(t, s, "GET", "/v1/account/reservation/mytopic/webhook", "", map[string]string{
+		"Authorization": util.BasicAuth("ben", "ben"),
+	})
+	require.Equal(t, 403, rr.Code)
+	rr = request(t, s, "DELETE", "/v1/account/reservation/mytopic/webhook/"+webhook.ID, "", map[string]string{
+		"Authorization": util.BasicAuth("ben", "ben"),
+	})
+	require.Equal(t, 403, rr.Code)
+
+	// Webhooks of other topics cannot be accessed through the user's own topic
+	rr = request(t, s, "GET", "/v1/account/reservation/bentopic/webhook/"+webhook.ID+"/deliveries", "", map[string]string{
+		"Authorization": util.BasicAuth("ben", "ben"),
+	})
+	require.Equal(t, 404, rr.Code)
+	require.Equal(t, 40405, toHTTPError(t, rr.Body.String()).Code)
+	rr = request(t, s, "DELETE", "/v1/account/reservation/bentopic/webhook/"+webhook.ID, "", map[string]string{
+		"Authorization": util.BasicAuth("ben", "ben"),
+	})
+	require.Equal(t, 404, rr.Code)
+
+	// Anonymous users cannot either
+	rr = request(t, s, "GET", "/v1/account/reservation/mytopic/webhook", "", nil)
+	require.Equal(t, 401, rr.Code)
+
+	// Invalid URLs are rejected
+	rr = request(t, s, "POST", "/v1/account/reservation/mytopic/webhook", `{"url":"ftp://example.com"}`, map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 400, rr.Code)
+	require.Equal(t, 40061, toHTTPError(t, rr.Body.String()).Code)
+
+	// Webhooks are removed with the reservation
+	require.Nil(t, s.userManager.RemoveReservations("phil", "mytopic"))
+	webhooks, err := s.userManager.TopicWebhooks("mytopic")
+	require.Nil(t, err)
+	require.Empty(t, webhooks)
+}
+
+func TestServer_Webhooks_Limit(t *testing.T) {
+	s := newTestServer(t, newTestConfigWithWebhooks(t))
+	defer s.closeDatabases()
+
+	require.Nil(t, s.userManager.AddUser("phil", "phil", user.RoleAdmin))
+	for i := 0; i < webhookTopicLimit; i++ {
+		rr := request(t, s, "POST", "/v1/account/reservation/mytopic/webhook", `{"url":"https://example.com"}`, map[string]string{
+			"Authorization": util.BasicAuth("phil", "phil"),
+		})
+		require.Equal(t, 200, rr.Code)
+	}
+	rr := request(t, s, "POST", "/v1/account/reservation/mytopic/webhook", `{"url":"https://example.com"}`, map[string]string{
+		"Authorization": util.BasicAuth("phil", "phil"),
+	})
+	require.Equal(t, 400, rr.Code)
+	require.Equal(t, 40061, toHTTPError(t, rr.Body.String()).Code)
+}
+
+func TestServer_Webhooks_Backoff(t *testing.T) {
+	require.Equal(t, 30*time.Second, webhookDeliveryBackoff(1))
+	require.Equal(t, time.Minute, webhookDeliveryBackoff(2))
+	require.Equal(t, 4*time.Minute, webhookDeliveryBackoff(4))
+	require.Equal(t, 6*time.Hour, webhookDeliveryBackoff(20))
+}
+
+func newTestConfigWithWebhooks(t *testing.T) *Config {
+	conf := newTestConfigWithAuthFile(t)
+	conf.AuthDefault = user.PermissionDenyAll
+	conf.EnableWebhooks = true
+	conf.WebhookAllowedIPAddrs = []netip.Prefix{netip.MustParsePrefix("127.0.0.0/8"), netip.MustParsePrefix("::1/128")} // httptest servers
+	return conf
+}
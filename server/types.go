@@ -13,14 +13,19 @@ import (
 
 // List of possible events
 const (
-	openEvent        = "open"
-	keepaliveEvent   = "keepalive"
-	messageEvent     = "message"
-	pollRequestEvent = "poll_request"
+	openEvent          = "open"
+	keepaliveEvent     = "keepalive"
+	messageEvent       = "message"
+	messageUpdateEvent = "message_update"
+	messageDeleteEvent = "message_delete"
+	messageAckEvent    = "message_ack"
+	pollRequestEvent   = "poll_request"
 )
 
 const (
-	messageIDLength = 12
+	messageIDLength  = 12
+	scheduleIDPrefix = "sc_"
+	scheduleIDLength = 16
 )
 
 // message represents a message published to a topic
@@ -39,10 +44,14 @@ type message struct {
 	Actions     []*action   `json:"actions,omitempty"`
 	Attachment  *attachment `json:"attachment,omitempty"`
 	PollID      string      `json:"poll_id,omitempty"`
+	Replaces    string      `json:"replaces,omitempty"`     // ID of the original message, for "message_update", "message_delete" and "message_ack" events
+	Acks        []*ack      `json:"acks,omitempty"`         // Acknowledgements of the message, or the new acknowledgement for "message_ack" events
 	ContentType string      `json:"content_type,omitempty"` // text/plain by default (if empty), or text/markdown
 	Encoding    string      `json:"encoding,omitempty"`     // empty for raw UTF-8, or "base64" for encoded bytes
 	Sender      netip.Addr  `json:"-"`                      // IP address of uploader, used for rate limiting
 	User        string      `json:"-"`                      // UserID of the uploader, used to associated attachments
+	Email       string      `json:"-"`                      // E-mail address to notify, only stored for delayed messages
+	Call        string      `json:"-"`                      // Phone number to call, only stored for delayed messages
 }
 
 func (m *message) Context() log.Context {
@@ -62,6 +71,53 @@ func (m *message) Context() log.Context {
 	return fields
 }
 
+// OwnedBy returns true if the visitor published the message, or if the visitor is an admin. Messages published
+// by anonymous users belong to the IP address they were published from.
+func (m *message) OwnedBy(v *visitor) bool {
+	if v.User().IsAdmin() {
+		return true
+	} else if m.User != "" {
+		return m.User == v.MaybeUserID()
+	}
+	return v.User() == nil && m.Sender == v.IP()
+}
+
+// ack is the acknowledgement of a message by a user or an anonymous visitor, see handleMessageAck
+type ack struct {
+	MessageID string     `json:"-"` // ID of the original message
+	Topic     string     `json:"-"`
+	User      string     `json:"user,omitempty"`  // Username, empty if the message was acknowledged anonymously
+	Token     string     `json:"token,omitempty"` // Label of the access token that was used to acknowledge the message, if any
+	Sender    netip.Addr `json:"-"`               // IP address of the visitor, used to identify anonymous visitors
+	Time      int64      `json:"time"`            // Unix time in seconds
+}
+
+// escalation is the escalation state of a single message, see sendEscalations. Escalations are started when a
+// message with the highest priority is published to a topic with an escalation policy (see user.TopicEscalation),
+// and stopped when the message is acknowledged or deleted.
+type escalation struct {
+	MessageID string
+	Topic     string
+	Step      int   // Next escalation step, see escalationStep* constants in server_escalation.go
+	Next      int64 // Unix time in seconds of the next step
+}
+
+// webhookDelivery is the delivery state of a message to an outbound webhook (see user.TopicWebhook). Deliveries
+// are retried with exponential backoff until they succeed, or until webhookDeliveryMaxAttempts is reached.
+type webhookDelivery struct {
+	ID         string
+	WebhookID  string
+	Topic      string
+	MessageID  string
+	Payload    string // JSON-encoded message, as POSTed to the webhook URL
+	Status     string // See webhookDeliveryStatus*
+	Attempts   int
+	Next       int64 // Unix time in seconds of the next attempt, if pending
+	StatusCode int   // HTTP status code of the last attempt, or 0 if there was no response
+	Error      string
+	Updated    int64 // Unix time in seconds
+}
+
 type attachment struct {
 	Name    string `json:"name"`
 	Type    string `json:"type,omitempty"`
@@ -72,11 +128,11 @@ type attachment struct {
 
 type action struct {
 	ID      string            `json:"id"`
-	Action  string            `json:"action"`            // "view", "broadcast", or "http"
+	Action  string            `json:"action"`            // "view", "broadcast", "http" or "acknowledge"
 	Label   string            `json:"label"`             // action button label
 	Clear   bool              `json:"clear"`             // clear notification after successful execution
-	URL     string            `json:"url,omitempty"`     // used in "view" and "http" actions
-	Method  string            `json:"method,omitempty"`  // used in "http" action, default is POST (!)
+	URL     string            `json:"url,omitempty"`     // used in "view" and "http" actions, set by the server for "acknowledge" actions
+	Method  string            `json:"method,omitempty"`  // used in "http" action, default is POST (!); always POST for "acknowledge" actions
 	Headers map[string]string `json:"headers,omitempty"` // used in "http" action
 	Body    string            `json:"body,omitempty"`    // used in "http" action
 	Intent  string            `json:"intent,omitempty"`  // used in "broadcast" action
@@ -106,6 +162,8 @@ type publishMessage struct {
 	Email    string   `json:"email"`
 	Call     string   `json:"call"`
 	Delay    string   `json:"delay"`
+	Replaces string   `json:"replaces"`
+	Schedule string   `json:"schedule"`
 }
 
 // messageEncoder is a function that knows how to encode a message
@@ -137,6 +195,22 @@ func newDefaultMessage(topic, msg string) *message {
 	return newMessage(messageEvent, topic, msg)
 }
 
+// newDeleteMessage is a convenience method to create a message that marks the message with the given ID as deleted
+func newDeleteMessage(topic, replaces string) *message {
+	m := newMessage(messageDeleteEvent, topic, "")
+	m.Replaces = replaces
+	return m
+}
+
+// newAckMessage is a convenience method to create a message that informs subscribers that the message with the
+// given ID was acknowledged
+func newAckMessage(topic, replaces string, a *ack) *message {
+	m := newMessage(messageAckEvent, topic, "")
+	m.Replaces = replaces
+	m.Acks = []*ack{a}
+	return m
+}
+
 // newPollRequestMessage is a convenience method to create a poll request message
 func newPollRequestMessage(topic, pollID string) *message {
 	m := newMessage(pollRequestEvent, topic, newMessageBody)
@@ -148,6 +222,52 @@ func validMessageID(s string) bool {
 	return util.ValidRandomString(s, messageIDLength)
 }
 
+// schedule is a recurring message. The message is a template for the messages that are created by the
+// delayed sender every time the schedule is due, see sendScheduledMessages.
+type schedule struct {
+	ID      string
+	Topic   string
+	Spec    string   // Cron expression, macro or interval, see util.ParseSchedule
+	Message *message // Template, including Sender and User; ID, Time and Expires are set for every run
+	Paused  bool
+	Next    int64 // Unix time in seconds of the next run
+	Last    int64 // Unix time in seconds of the last run, or 0 if it has not run yet
+	Created int64 // Unix time in seconds
+}
+
+func newSchedule(topic, spec string, next int64, m *message) *schedule {
+	return &schedule{
+		ID:      util.RandomStringPrefix(scheduleIDPrefix, scheduleIDLength),
+		Topic:   topic,
+		Spec:    spec,
+		Message: m,
+		Next:    next,
+		Created: time.Now().Unix(),
+	}
+}
+
+// OwnedBy returns true if the visitor created the recurring message, or if the visitor is an admin, see message.OwnedBy
+func (s *schedule) OwnedBy(v *visitor) bool {
+	return s.Message.OwnedBy(v)
+}
+
+func (s *schedule) Context() log.Context {
+	fields := map[string]any{
+		"topic":           s.Topic,
+		"schedule_id":     s.ID,
+		"schedule_spec":   s.Spec,
+		"schedule_next":   s.Next,
+		"schedule_paused": s.Paused,
+	}
+	if s.Message.Sender.IsValid() {
+		fields["schedule_sender"] = s.Message.Sender.String()
+	}
+	if s.Message.User != "" {
+		fields["schedule_user"] = s.Message.User
+	}
+	return fields
+}
+
 type sinceMarker struct {
 	time time.Time
 	id   string
@@ -217,7 +337,7 @@ func parseQueryFilters(r *http.Request) (*queryFilter, error) {
 }
 
 func (q *queryFilter) Pass(msg *message) bool {
-	if msg.Event != messageEvent {
+	if msg.Event != messageEvent && msg.Event != messageUpdateEvent {
 		return true // filters only apply to messages
 	} else if q.ID != "" && msg.ID != q.ID {
 		return false
@@ -239,6 +359,40 @@ func (q *queryFilter) Pass(msg *message) bool {
 	return true
 }
 
+type apiMessagesResponse struct {
+	Messages []*message `json:"messages"`
+	Prev     string     `json:"prev,omitempty"` // Pass as "before" to fetch older messages, not set if there are none
+	Next     string     `json:"next,omitempty"` // Pass as "after" to fetch newer messages, not set if there are none
+}
+
+type apiSearchResponse struct {
+	Messages []*message `json:"messages"`
+	Next     int        `json:"next,omitempty"` // Offset of the next page, not set if there are no more results
+}
+
+type apiScheduleResponse struct {
+	ID          string      `json:"id"`
+	Topic       string      `json:"topic"`
+	Schedule    string      `json:"schedule"`
+	Paused      bool        `json:"paused"`
+	Next        int64       `json:"next,omitempty"` // Not set if paused
+	Last        int64       `json:"last,omitempty"` // Not set if the schedule has not run yet
+	Created     int64       `json:"created"`
+	Title       string      `json:"title,omitempty"`
+	Message     string      `json:"message,omitempty"`
+	Priority    int         `json:"priority,omitempty"`
+	Tags        []string    `json:"tags,omitempty"`
+	Click       string      `json:"click,omitempty"`
+	Icon        string      `json:"icon,omitempty"`
+	Actions     []*action   `json:"actions,omitempty"`
+	Attachment  *attachment `json:"attachment,omitempty"`
+	ContentType string      `json:"content_type,omitempty"`
+}
+
+type apiScheduleUpdateRequest struct {
+	Paused *bool `json:"paused"`
+}
+
 type apiHealthResponse struct {
 	Healthy bool `json:"healthy"`
 }
@@ -282,6 +436,58 @@ type apiAccessResetRequest struct {
 	Topic    string `json:"topic"`
 }
 
+type apiGroupRequest struct {
+	Name string `json:"name"`
+}
+
+type apiAuditEventResponse struct {
+	ID      int64  `json:"id"`
+	Time    int64  `json:"time"`
+	Actor   string `json:"actor,omitempty"`
+	Origin  string `json:"origin"`
+	Action  string `json:"action"`
+	Target  string `json:"target,omitempty"`
+	Details string `json:"details,omitempty"`
+}
+
+type apiGroupResponse struct {
+	Name    string                  `json:"name"`
+	Members []string                `json:"members"`
+	Grants  []*apiUserGrantResponse `json:"grants,omitempty"`
+}
+
+type apiGroupMemberRequest struct {
+	Group    string `json:"group"`
+	Username string `json:"username"`
+}
+
+type apiGroupAccessAllowRequest struct {
+	Group      string `json:"group"`
+	Topic      string `json:"topic"` // This may be a pattern
+	Permission string `json:"permission"`
+}
+
+type apiGroupAccessResetRequest struct {
+	Group string `json:"group"`
+	Topic string `json:"topic"`
+}
+
+type apiTopicRetentionRequest struct {
+	Topic       string `json:"topic"`
+	MaxAge      int64  `json:"max_age"` // Seconds
+	MaxMessages int64  `json:"max_messages"`
+}
+
+type apiTopicRetentionResponse struct {
+	Topic       string `json:"topic"`
+	MaxAge      int64  `json:"max_age,omitempty"` // Seconds
+	MaxMessages int64  `json:"max_messages,omitempty"`
+}
+
+type apiTopicRetentionDeleteRequest struct {
+	Topic string `json:"topic"`
+}
+
 type apiAccountCreateRequest struct {
 	Username string `json:"username"`
 	Password string `json:"password"`
@@ -296,9 +502,29 @@ type apiAccountDeleteRequest struct {
 	Password string `json:"password"`
 }
 
+type apiAccountTwoFactorSetupResponse struct {
+	Secret string `json:"secret"`
+	URI    string `json:"uri"`
+}
+
+type apiAccountTwoFactorEnableRequest struct {
+	Code string `json:"code"`
+}
+
+type apiAccountTwoFactorEnableResponse struct {
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+type apiAccountTwoFactorDisableRequest struct {
+	Password string `json:"password"`
+	Code     string `json:"code"`
+}
+
 type apiAccountTokenIssueRequest struct {
-	Label   *string `json:"label"`
-	Expires *int64  `json:"expires"` // Unix timestamp
+	Label   *string                 `json:"label"`
+	Expires *int64                  `json:"expires"` // Unix timestamp
+	Scopes  []*apiAccountTokenScope `json:"scopes"`
+	Code    string                  `json:"code"` // Two-factor authentication code, if enabled
 }
 
 type apiAccountTokenUpdateRequest struct {
@@ -308,11 +534,50 @@ type apiAccountTokenUpdateRequest struct {
 }
 
 type apiAccountTokenResponse struct {
-	Token      string `json:"token"`
-	Label      string `json:"label,omitempty"`
-	LastAccess int64  `json:"last_access,omitempty"`
-	LastOrigin string `json:"last_origin,omitempty"`
-	Expires    int64  `json:"expires,omitempty"` // Unix timestamp
+	Token      string                  `json:"token"`
+	Label      string                  `json:"label,omitempty"`
+	LastAccess int64                   `json:"last_access,omitempty"`
+	LastOrigin string                  `json:"last_origin,omitempty"`
+	Expires    int64                   `json:"expires,omitempty"` // Unix timestamp
+	Scopes     []*apiAccountTokenScope `json:"scopes,omitempty"`
+}
+
+type apiAccountTokenScope struct {
+	Topic      string `json:"topic"`
+	Permission string `json:"permission"`
+}
+
+type apiAccountSignedURLRequest struct {
+	Expires *int64            `json:"expires"` // Unix timestamp
+	Params  map[string]string `json:"params"`
+}
+
+type apiAccountSignedURLResponse struct {
+	URL     string `json:"url"`
+	Expires int64  `json:"expires"` // Unix timestamp
+}
+
+type apiAccountWebhookRequest struct {
+	URL string `json:"url"`
+}
+
+type apiAccountWebhookResponse struct {
+	ID      string `json:"id"`
+	Topic   string `json:"topic"`
+	URL     string `json:"url"`
+	Secret  string `json:"secret,omitempty"` // Only returned when the webhook is created
+	Created int64  `json:"created"`
+}
+
+type apiAccountWebhookDeliveryResponse struct {
+	ID         string `json:"id"`
+	MessageID  string `json:"message_id"`
+	Status     string `json:"status"`
+	Attempts   int    `json:"attempts"`
+	Next       int64  `json:"next,omitempty"` // Only set for pending deliveries
+	StatusCode int    `json:"status_code,omitempty"`
+	Error      string `json:"error,omitempty"`
+	Updated    int64  `json:"updated"`
 }
 
 type apiAccountPhoneNumberVerifyRequest struct {
@@ -357,8 +622,22 @@ type apiAccountStats struct {
 }
 
 type apiAccountReservation struct {
-	Topic    string `json:"topic"`
-	Everyone string `json:"everyone"`
+	Topic      string                           `json:"topic"`
+	Everyone   string                           `json:"everyone"`
+	Retention  *apiAccountReservationRetention  `json:"retention,omitempty"`
+	Escalation *apiAccountReservationEscalation `json:"escalation,omitempty"`
+}
+
+type apiAccountReservationRetention struct {
+	MaxAge      int64 `json:"max_age,omitempty"` // Seconds
+	MaxMessages int64 `json:"max_messages,omitempty"`
+}
+
+type apiAccountReservationEscalation struct {
+	Delay        int64  `json:"delay"` // Seconds
+	ForwardTopic string `json:"forward_topic,omitempty"`
+	Email        string `json:"email,omitempty"`
+	Call         string `json:"call,omitempty"`
 }
 
 type apiAccountBilling struct {
@@ -380,6 +659,7 @@ type apiAccountResponse struct {
 	Reservations  []*apiAccountReservation   `json:"reservations,omitempty"`
 	Tokens        []*apiAccountTokenResponse `json:"tokens,omitempty"`
 	PhoneNumbers  []string                   `json:"phone_numbers,omitempty"`
+	TwoFactor     bool                       `json:"two_factor,omitempty"`
 	Tier          *apiAccountTier            `json:"tier,omitempty"`
 	Limits        *apiAccountLimits          `json:"limits,omitempty"`
 	Stats         *apiAccountStats           `json:"stats,omitempty"`
@@ -387,8 +667,10 @@ type apiAccountResponse struct {
 }
 
 type apiAccountReservationRequest struct {
-	Topic    string `json:"topic"`
-	Everyone string `json:"everyone"`
+	Topic      string                           `json:"topic"`
+	Everyone   string                           `json:"everyone"`
+	Retention  *apiAccountReservationRetention  `json:"retention,omitempty"`  // Retention is left unchanged if nil
+	Escalation *apiAccountReservationEscalation `json:"escalation,omitempty"` // Escalation is left unchanged if nil
 }
 
 type apiConfigResponse struct {
@@ -401,6 +683,7 @@ type apiConfigResponse struct {
 	EnableEmails       bool     `json:"enable_emails"`
 	EnableReservations bool     `json:"enable_reservations"`
 	EnableWebPush      bool     `json:"enable_web_push"`
+	EnableOIDC         bool     `json:"enable_oidc"`
 	BillingContact     string   `json:"billing_contact"`
 	WebPushPublicKey   string   `json:"web_push_public_key"`
 	DisallowedTopics   []string `json:"disallowed_topics"`
@@ -477,8 +760,9 @@ type apiWebPushUpdateSubscriptionRequest struct {
 
 // List of possible Web Push events (see sw.js)
 const (
-	webPushMessageEvent  = "message"
-	webPushExpiringEvent = "subscription_expiring"
+	webPushMessageEvent       = "message"
+	webPushMessageDeleteEvent = "message_delete"
+	webPushExpiringEvent      = "subscription_expiring"
 )
 
 type webPushPayload struct {
@@ -488,8 +772,12 @@ type webPushPayload struct {
 }
 
 func newWebPushPayload(subscriptionID string, message *message) *webPushPayload {
+	event := webPushMessageEvent
+	if message.Event == messageDeleteEvent {
+		event = webPushMessageDeleteEvent
+	}
 	return &webPushPayload{
-		Event:          webPushMessageEvent,
+		Event:          event,
 		SubscriptionID: subscriptionID,
 		Message:        message,
 	}
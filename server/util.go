@@ -9,6 +9,7 @@ import (
 	"net/http"
 	"net/netip"
 	"regexp"
+	"strconv"
 	"strings"
 )
 
@@ -62,6 +63,37 @@ func readHeaderParam(r *http.Request, names ...string) string {
 	return ""
 }
 
+// parseLimit reads the "limit" query parameter used for pagination. If no limit is passed, defaultLimit is used.
+// Limits larger than maxLimit are capped.
+func parseLimit(r *http.Request, defaultLimit, maxLimit int) (int, error) {
+	limitStr := readQueryParam(r, "limit")
+	if limitStr == "" {
+		return defaultLimit, nil
+	}
+	limit, err := strconv.Atoi(limitStr)
+	if err != nil || limit <= 0 {
+		return 0, errHTTPBadRequestPaginationInvalid
+	} else if limit > maxLimit {
+		return maxLimit, nil
+	}
+	return limit, nil
+}
+
+// parseLimitOffset reads the "limit" and "offset" query parameters used for pagination, see parseLimit
+func parseLimitOffset(r *http.Request, defaultLimit, maxLimit int) (limit int, offset int, err error) {
+	limit, err = parseLimit(r, defaultLimit, maxLimit)
+	if err != nil {
+		return 0, 0, err
+	}
+	if offsetStr := readQueryParam(r, "offset"); offsetStr != "" {
+		offset, err = strconv.Atoi(offsetStr)
+		if err != nil || offset < 0 {
+			return 0, 0, errHTTPBadRequestPaginationInvalid
+		}
+	}
+	return limit, offset, nil
+}
+
 func readQueryParam(r *http.Request, names ...string) string {
 	for _, name := range names {
 		value := r.URL.Query().Get(strings.ToLower(name))
@@ -227,6 +227,14 @@ func (v *visitor) MessageAllowed() bool {
 	return v.messagesLimiter.Allow()
 }
 
+// MessagesAllowed returns true if the visitor may publish n more messages. Unlike MessageAllowed, it does not
+// count the messages against the limit, since they are counted when they are published.
+func (v *visitor) MessagesAllowed(n int) bool {
+	v.mu.RLock() // limiters could be replaced!
+	defer v.mu.RUnlock()
+	return v.messagesLimiter.Value()+int64(n) <= v.limitsNoLock().MessageLimit
+}
+
 func (v *visitor) EmailAllowed() bool {
 	v.mu.RLock() // limiters could be replaced!
 	defer v.mu.RUnlock()
@@ -0,0 +1,303 @@
+package user
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+	"heckel.io/ntfy/v2/log"
+	"heckel.io/ntfy/v2/util"
+)
+
+const (
+	ldapTag            = "ldap"
+	ldapTimeout        = 10 * time.Second
+	ldapCacheKeyLength = 32
+)
+
+// Default constants that may be overridden by configs
+const (
+	DefaultLDAPUserFilter     = "(uid=%s)"
+	DefaultLDAPGroupAttribute = "memberOf"
+	DefaultLDAPCacheDuration  = 5 * time.Minute
+)
+
+var (
+	errLDAPUserNotFound = errors.New("user not found in directory")
+)
+
+// LDAPConfig is the configuration of the LDAPAuther
+type LDAPConfig struct {
+	URL            string             // Server URL, e.g. ldap://ldap.example.com or ldaps://ldap.example.com:636
+	BindDN         string             // DN used to search for users; anonymous search if empty
+	BindPassword   string             // Password of BindDN
+	BaseDN         string             // DN under which users are searched, e.g. ou=people,dc=example,dc=com
+	UserFilter     string             // Search filter for users, %s is replaced with the username
+	GroupAttribute string             // User attribute that lists the user's groups, as DNs
+	GroupAccess    []*LDAPGroupAccess // Access control entries granted to members of a group
+	CacheDuration  time.Duration      // Time successful logins are cached before the directory is asked again
+}
+
+// LDAPGroupAccess grants members of an LDAP group access to a topic pattern. The group is either the
+// full group DN, or the value of its first RDN, e.g. "cn=ops,ou=groups,dc=example,dc=com" or "ops".
+type LDAPGroupAccess struct {
+	Group        string
+	TopicPattern string
+	Permission   Permission
+}
+
+// LDAPAuther is an Auther that authenticates users by binding against an LDAP directory. Users are created in the
+// Manager on their first login, so that tokens, tiers and reservations work just like for local users. If configured,
+// the access control entries of the user are derived from the user's LDAP groups on every login.
+//
+// Successful logins are cached for LDAPConfig.CacheDuration, since clients send their credentials with every request.
+// Users that are not found in the directory, or if the directory is unreachable, are authenticated against the
+// Manager, so that local users (e.g. admins created via the CLI) continue to work. Local users are never taken over
+// by a directory entry with the same name: only users that were created from the directory are synced.
+type LDAPAuther struct {
+	manager *Manager
+	config  *LDAPConfig
+	search  func(username, password string) (groups []string, err error) // Can be replaced in tests
+	cache   map[string]*ldapCacheEntry
+	salt    string
+	mu      sync.Mutex
+}
+
+type ldapCacheEntry struct {
+	hash    [sha256.Size]byte
+	expires time.Time
+}
+
+var _ Auther = (*LDAPAuther)(nil)
+
+// NewLDAPAuther creates a new LDAPAuther, using the given Manager to store users and access control entries.
+// Changes made when syncing users from the directory are recorded in the audit log with AuditOriginLDAP.
+func NewLDAPAuther(manager *Manager, config *LDAPConfig) *LDAPAuther {
+	a := &LDAPAuther{
+		manager: manager.WithActor(&Actor{Origin: AuditOriginLDAP}),
+		config:  config,
+		cache:   make(map[string]*ldapCacheEntry),
+		salt:    util.RandomString(ldapCacheKeyLength),
+	}
+	a.search = a.bind
+	return a
+}
+
+// Authenticate checks username and password against the directory (or the cache), and returns the user
+// if they are correct. If the user is not in the directory, it falls back to the Manager.
+func (a *LDAPAuther) Authenticate(username, password string) (*User, error) {
+	if username == Everyone || !AllowedUsername(username) {
+		return nil, ErrUnauthenticated
+	} else if a.cached(username, password) {
+		u, err := a.manager.User(username)
+		if err != nil {
+			return nil, err
+		} else if err := a.checkUser(u); err != nil {
+			return nil, err
+		}
+		return u, nil
+	}
+	groups, err := a.search(username, password)
+	if err != nil {
+		ev := log.Tag(ldapTag).Field("user_name", username).Err(err)
+		if errors.Is(err, errLDAPUserNotFound) {
+			ev.Trace("User not found in directory, authenticating against user database")
+		} else if ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) || errors.Is(err, ErrUnauthenticated) {
+			ev.Trace("Authentication of user failed")
+			a.addLoginFailure(username)
+			return nil, ErrUnauthenticated
+		} else {
+			ev.Warn("Cannot authenticate against directory, authenticating against user database")
+		}
+		return a.manager.Authenticate(username, password)
+	}
+	u, err := a.provision(username, groups)
+	if errors.Is(err, ErrUserExists) {
+		log.Tag(ldapTag).Field("user_name", username).Info("User %s exists locally, but was not created from directory, authenticating against user database", username)
+		return a.manager.Authenticate(username, password)
+	} else if err != nil {
+		return nil, err
+	}
+	a.mu.Lock()
+	a.cache[username] = &ldapCacheEntry{
+		hash:    a.hash(username, password),
+		expires: time.Now().Add(a.config.CacheDuration),
+	}
+	a.mu.Unlock()
+	return u, nil
+}
+
+// Authorize returns nil if the given user has access to the given topic, see Manager.Authorize
+func (a *LDAPAuther) Authorize(user *User, topic string, perm Permission) error {
+	return a.manager.Authorize(user, topic, perm)
+}
+
+// provision creates the user if it does not exist yet, and syncs the user's access control entries with
+// the configured group access entries. Only topic patterns that are listed in the config are changed. If a
+// user with the same name exists, but was not created from the directory, ErrUserExists is returned.
+func (a *LDAPAuther) provision(username string, groups []string) (*User, error) {
+	if u, err := a.manager.User(username); errors.Is(err, ErrUserNotFound) {
+		log.Tag(ldapTag).Field("user_name", username).Info("Creating user %s from directory", username)
+		if err := a.manager.AddLDAPUser(username, RoleUser); err != nil {
+			return nil, err
+		}
+	} else if err != nil {
+		return nil, err
+	} else if err := a.checkUser(u); err != nil {
+		return nil, err
+	} else if ldapUser, err := a.manager.IsLDAPUser(u); err != nil {
+		return nil, err
+	} else if !ldapUser {
+		return nil, ErrUserExists
+	}
+	if len(a.config.GroupAccess) > 0 {
+		if err := a.syncAccess(username, groups); err != nil {
+			return nil, err
+		}
+	}
+	u, err := a.manager.User(username)
+	if err != nil {
+		return nil, err
+	} else if err := a.manager.resetLoginFailures(u.ID); err != nil {
+		return nil, err
+	}
+	return u, nil
+}
+
+// checkUser returns an error if the user is marked as deleted, or locked due to too many failed logins
+func (a *LDAPAuther) checkUser(u *User) error {
+	if u.Deleted {
+		return ErrUnauthenticated
+	}
+	_, lockedUntil, err := a.manager.userLockout(u.ID)
+	if err != nil {
+		return err
+	} else if lockedUntil > time.Now().Unix() {
+		return ErrUserLocked
+	}
+	return nil
+}
+
+// addLoginFailure counts a failed directory login towards the account lockout, if the user was created
+// from the directory. Failed logins of local users are counted by Manager.Authenticate.
+func (a *LDAPAuther) addLoginFailure(username string) {
+	u, err := a.manager.User(username)
+	if err != nil {
+		return
+	}
+	ev := log.Tag(ldapTag).Field("user_name", username)
+	if ldapUser, err := a.manager.IsLDAPUser(u); err != nil {
+		ev.Err(err).Warn("Unable to read user")
+	} else if ldapUser {
+		if err := a.manager.addLoginFailure(u.ID); err != nil {
+			ev.Err(err).Warn("Unable to record failed login")
+		}
+	}
+}
+
+func (a *LDAPAuther) syncAccess(username string, groups []string) error {
+	names := ldapGroupNames(groups)
+	patterns := make([]string, 0)
+	permissions := make(map[string]Permission)
+	for _, access := range a.config.GroupAccess {
+		if _, ok := permissions[access.TopicPattern]; !ok {
+			patterns = append(patterns, access.TopicPattern)
+			permissions[access.TopicPattern] = PermissionDenyAll
+		}
+		if util.Contains(names, strings.ToLower(access.Group)) {
+			permissions[access.TopicPattern] |= access.Permission
+		}
+	}
+	for _, pattern := range patterns {
+		var err error
+		if permissions[pattern] == PermissionDenyAll {
+			err = a.manager.ResetAccess(username, pattern)
+		} else {
+			err = a.manager.AllowAccess(username, pattern, permissions[pattern])
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// bind searches the user in the directory, binds as the user to check the password, and returns the user's groups
+func (a *LDAPAuther) bind(username, password string) ([]string, error) {
+	if password == "" {
+		return nil, ErrUnauthenticated // An empty password is an anonymous bind, which always succeeds!
+	}
+	conn, err := ldap.DialURL(a.config.URL, ldap.DialWithDialer(&net.Dialer{Timeout: ldapTimeout}))
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	conn.SetTimeout(ldapTimeout)
+	if a.config.BindDN != "" {
+		if err := conn.Bind(a.config.BindDN, a.config.BindPassword); err != nil {
+			return nil, fmt.Errorf("cannot bind as %s: %w", a.config.BindDN, err)
+		}
+	}
+	request := ldap.NewSearchRequest(
+		a.config.BaseDN,
+		ldap.ScopeWholeSubtree,
+		ldap.NeverDerefAliases,
+		2, // Size limit, to detect ambiguous filters
+		int(ldapTimeout.Seconds()),
+		false,
+		fmt.Sprintf(a.config.UserFilter, ldap.EscapeFilter(username)),
+		[]string{a.config.GroupAttribute},
+		nil,
+	)
+	result, err := conn.Search(request)
+	if err != nil && !ldap.IsErrorWithCode(err, ldap.LDAPResultSizeLimitExceeded) {
+		return nil, err
+	} else if result == nil || len(result.Entries) == 0 {
+		return nil, errLDAPUserNotFound
+	} else if len(result.Entries) > 1 {
+		return nil, fmt.Errorf("user filter matches more than one entry for user %s", username)
+	}
+	entry := result.Entries[0]
+	if err := conn.Bind(entry.DN, password); err != nil {
+		return nil, err
+	}
+	return entry.GetAttributeValues(a.config.GroupAttribute), nil
+}
+
+func (a *LDAPAuther) cached(username, password string) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	entry, ok := a.cache[username]
+	if !ok {
+		return false
+	} else if time.Now().After(entry.expires) {
+		delete(a.cache, username)
+		return false
+	}
+	hash := a.hash(username, password)
+	return subtle.ConstantTimeCompare(entry.hash[:], hash[:]) == 1
+}
+
+func (a *LDAPAuther) hash(username, password string) [sha256.Size]byte {
+	return sha256.Sum256([]byte(a.salt + "\x00" + username + "\x00" + password))
+}
+
+// ldapGroupNames returns the lowercase DNs of the given groups, and the values of their first RDNs,
+// e.g. "cn=ops,ou=groups,dc=example,dc=com" and "ops"
+func ldapGroupNames(groups []string) []string {
+	names := make([]string, 0, len(groups)*2)
+	for _, group := range groups {
+		names = append(names, strings.ToLower(group))
+		dn, err := ldap.ParseDN(group)
+		if err == nil && len(dn.RDNs) > 0 && len(dn.RDNs[0].Attributes) > 0 {
+			names = append(names, strings.ToLower(dn.RDNs[0].Attributes[0].Value))
+		}
+	}
+	return names
+}
@@ -0,0 +1,254 @@
+package user
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLDAPAuther_Authenticate(t *testing.T) {
+	a := newTestLDAPAuther(t, nil)
+	searches := 0
+	a.search = func(username, password string) ([]string, error) {
+		searches++
+		if username == "phil" && password == "phil" {
+			return []string{}, nil
+		}
+		return nil, ldap.NewError(ldap.LDAPResultInvalidCredentials, errors.New("invalid credentials"))
+	}
+
+	// First login creates the user
+	u, err := a.Authenticate("phil", "phil")
+	require.Nil(t, err)
+	require.Equal(t, "phil", u.Name)
+	require.Equal(t, RoleUser, u.Role)
+	require.Equal(t, 1, searches)
+
+	// Second login is cached
+	u2, err := a.Authenticate("phil", "phil")
+	require.Nil(t, err)
+	require.Equal(t, u.ID, u2.ID)
+	require.Equal(t, 1, searches)
+
+	// Wrong password is not cached, and does not fall back to the user database
+	_, err = a.Authenticate("phil", "wrong")
+	require.Equal(t, ErrUnauthenticated, err)
+	require.Equal(t, 2, searches)
+
+	// Cache expires
+	a.cache["phil"].expires = time.Now().Add(-time.Second)
+	_, err = a.Authenticate("phil", "phil")
+	require.Nil(t, err)
+	require.Equal(t, 3, searches)
+
+	// Provisioned users cannot log in with a local password
+	_, err = a.manager.Authenticate("phil", "phil")
+	require.Equal(t, ErrUnauthenticated, err)
+}
+
+func TestLDAPAuther_Authenticate_FallbackToManager(t *testing.T) {
+	a := newTestLDAPAuther(t, nil)
+	require.Nil(t, a.manager.AddUser("admin", "admin", RoleAdmin))
+
+	// User not in directory
+	a.search = func(username, password string) ([]string, error) {
+		return nil, errLDAPUserNotFound
+	}
+	u, err := a.Authenticate("admin", "admin")
+	require.Nil(t, err)
+	require.Equal(t, RoleAdmin, u.Role)
+	_, err = a.Authenticate("admin", "wrong")
+	require.Equal(t, ErrUnauthenticated, err)
+	_, err = a.Authenticate("ben", "ben")
+	require.Equal(t, ErrUnauthenticated, err)
+
+	// Directory unreachable
+	a.search = func(username, password string) ([]string, error) {
+		return nil, ldap.NewError(ldap.ErrorNetwork, errors.New("connection refused"))
+	}
+	_, err = a.Authenticate("admin", "admin")
+	require.Nil(t, err)
+
+	// Everyone and invalid usernames are never looked up
+	_, err = a.Authenticate(Everyone, "")
+	require.Equal(t, ErrUnauthenticated, err)
+	_, err = a.Authenticate("phil heckel", "phil")
+	require.Equal(t, ErrUnauthenticated, err)
+}
+
+func TestLDAPAuther_Authenticate_LocalUserNotTakenOver(t *testing.T) {
+	a := newTestLDAPAuther(t, []*LDAPGroupAccess{
+		{Group: "ops", TopicPattern: "alerts*", Permission: PermissionReadWrite},
+	})
+	require.Nil(t, a.manager.AddUser("admin", "local-pass", RoleAdmin))
+	require.Nil(t, a.manager.AllowAccess("admin", "alerts*", PermissionRead))
+	a.search = func(username, password string) ([]string, error) {
+		if password == "directory-pass" {
+			return []string{"cn=ops,ou=groups,dc=example,dc=com"}, nil
+		}
+		return nil, ldap.NewError(ldap.LDAPResultInvalidCredentials, errors.New("invalid credentials"))
+	}
+
+	// A directory entry with the same name does not log in as, or sync the access of, the local user
+	_, err := a.provision("admin", []string{"cn=ops,ou=groups,dc=example,dc=com"})
+	require.Equal(t, ErrUserExists, err)
+	_, err = a.Authenticate("admin", "directory-pass")
+	require.Equal(t, ErrUnauthenticated, err)
+	grants, err := a.manager.Grants("admin")
+	require.Nil(t, err)
+	require.Equal(t, []Grant{{TopicPattern: "alerts*", Allow: PermissionRead}}, grants)
+
+	// The local user can still log in with the local password
+	a.search = func(username, password string) ([]string, error) {
+		return []string{}, nil
+	}
+	u, err := a.Authenticate("admin", "local-pass")
+	require.Nil(t, err)
+	require.Equal(t, RoleAdmin, u.Role)
+}
+
+func TestLDAPAuther_Authenticate_DeletedUser(t *testing.T) {
+	a := newTestLDAPAuther(t, nil)
+	a.search = func(username, password string) ([]string, error) {
+		return []string{}, nil
+	}
+	u, err := a.Authenticate("phil", "phil")
+	require.Nil(t, err)
+	require.Nil(t, a.manager.MarkUserRemoved(u))
+
+	// Rejected on the cached path
+	_, err = a.Authenticate("phil", "phil")
+	require.Equal(t, ErrUnauthenticated, err)
+
+	// Rejected on the uncached path
+	a.cache = make(map[string]*ldapCacheEntry)
+	_, err = a.Authenticate("phil", "phil")
+	require.Equal(t, ErrUnauthenticated, err)
+}
+
+func TestLDAPAuther_Authenticate_Lockout(t *testing.T) {
+	a := newTestLDAPAuther(t, nil)
+	a.manager.SetLockout(2, time.Hour)
+	a.search = func(username, password string) ([]string, error) {
+		if password == "phil" {
+			return []string{}, nil
+		}
+		return nil, ldap.NewError(ldap.LDAPResultInvalidCredentials, errors.New("invalid credentials"))
+	}
+	_, err := a.Authenticate("phil", "phil")
+	require.Nil(t, err)
+
+	// Failed directory logins lock the user, on the cached and the uncached path
+	_, err = a.Authenticate("phil", "wrong1")
+	require.Equal(t, ErrUnauthenticated, err)
+	_, err = a.Authenticate("phil", "wrong2")
+	require.Equal(t, ErrUnauthenticated, err)
+	_, err = a.Authenticate("phil", "phil")
+	require.Equal(t, ErrUserLocked, err)
+	a.cache = make(map[string]*ldapCacheEntry)
+	_, err = a.Authenticate("phil", "phil")
+	require.Equal(t, ErrUserLocked, err)
+
+	// Unlocking allows logins again
+	require.Nil(t, a.manager.UnlockUser("phil"))
+	_, err = a.Authenticate("phil", "phil")
+	require.Nil(t, err)
+}
+
+func TestLDAPAuther_GroupAccess(t *testing.T) {
+	a := newTestLDAPAuther(t, []*LDAPGroupAccess{
+		{Group: "ops", TopicPattern: "alerts*", Permission: PermissionRead},
+		{Group: "cn=oncall,ou=groups,dc=example,dc=com", TopicPattern: "alerts*", Permission: PermissionWrite},
+		{Group: "dev", TopicPattern: "builds", Permission: PermissionReadWrite},
+	})
+	var groups []string
+	a.search = func(username, password string) ([]string, error) {
+		return groups, nil
+	}
+
+	// Groups are matched by DN or by first RDN value, and permissions for the same pattern are combined
+	groups = []string{"cn=ops,ou=groups,dc=example,dc=com", "CN=OnCall,OU=Groups,DC=example,DC=com", "cn=sales,ou=groups,dc=example,dc=com"}
+	_, err := a.Authenticate("phil", "phil")
+	require.Nil(t, err)
+	require.Nil(t, a.manager.AllowAccess("phil", "private", PermissionRead)) // Not managed by group access, must be kept
+	a.cache = make(map[string]*ldapCacheEntry)
+	_, err = a.Authenticate("phil", "phil")
+	require.Nil(t, err)
+	grants, err := a.manager.Grants("phil")
+	require.Nil(t, err)
+	require.Equal(t, []Grant{
+		{TopicPattern: "alerts*", Allow: PermissionReadWrite},
+		{TopicPattern: "private", Allow: PermissionRead},
+	}, grants)
+	require.Nil(t, a.Authorize(mustUser(t, a.manager, "phil"), "alerts-db", PermissionWrite))
+
+	// Leaving a group removes the grant, joining one adds it
+	groups = []string{"cn=dev,ou=groups,dc=example,dc=com"}
+	a.cache = make(map[string]*ldapCacheEntry)
+	_, err = a.Authenticate("phil", "phil")
+	require.Nil(t, err)
+	grants, err = a.manager.Grants("phil")
+	require.Nil(t, err)
+	require.Equal(t, []Grant{
+		{TopicPattern: "private", Allow: PermissionRead},
+		{TopicPattern: "builds", Allow: PermissionReadWrite},
+	}, grants)
+	require.Equal(t, ErrUnauthorized, a.Authorize(mustUser(t, a.manager, "phil"), "alerts-db", PermissionRead))
+
+	// Grants synced from the directory are recorded in the audit log
+	events, err := a.manager.AuditEvents(&AuditFilter{User: "phil", Action: AuditActionAccessAllow})
+	require.Nil(t, err)
+	require.Equal(t, "topic=builds permission=read-write", events[0].Details)
+	require.Equal(t, AuditOriginLDAP, events[0].Origin)
+}
+
+// TestLDAPAuther_OpenLDAP runs against a real directory, e.g. the bitnami/openldap container with its default users:
+//
+//	docker run -p 1389:1389 bitnami/openldap
+//	NTFY_TEST_LDAP_URL=ldap://localhost:1389 go test ./user -run OpenLDAP
+func TestLDAPAuther_OpenLDAP(t *testing.T) {
+	url := os.Getenv("NTFY_TEST_LDAP_URL")
+	if url == "" {
+		t.Skip("NTFY_TEST_LDAP_URL not set, skipping LDAP test")
+	}
+	manager := newTestManager(t, filepath.Join(t.TempDir(), "user.db"), PermissionDenyAll)
+	a := NewLDAPAuther(manager, &LDAPConfig{
+		URL:            url,
+		BindDN:         "cn=admin,dc=example,dc=org",
+		BindPassword:   "adminpassword",
+		BaseDN:         "ou=users,dc=example,dc=org",
+		UserFilter:     "(cn=%s)",
+		GroupAttribute: DefaultLDAPGroupAttribute,
+		CacheDuration:  DefaultLDAPCacheDuration,
+	})
+	u, err := a.Authenticate("user01", "password1")
+	require.Nil(t, err)
+	require.Equal(t, "user01", u.Name)
+	_, err = a.Authenticate("user01", "wrong")
+	require.Equal(t, ErrUnauthenticated, err)
+	_, err = a.Authenticate("user01", "")
+	require.Equal(t, ErrUnauthenticated, err)
+}
+
+func newTestLDAPAuther(t *testing.T, groupAccess []*LDAPGroupAccess) *LDAPAuther {
+	manager := newTestManager(t, filepath.Join(t.TempDir(), "user.db"), PermissionDenyAll)
+	return NewLDAPAuther(manager, &LDAPConfig{
+		URL:            "ldap://127.0.0.1:1",
+		BaseDN:         "ou=people,dc=example,dc=com",
+		UserFilter:     DefaultLDAPUserFilter,
+		GroupAttribute: DefaultLDAPGroupAttribute,
+		GroupAccess:    groupAccess,
+		CacheDuration:  DefaultLDAPCacheDuration,
+	})
+}
+
+func mustUser(t *testing.T, manager *Manager, username string) *User {
+	u, err := manager.User(username)
+	require.Nil(t, err)
+	return u
+}
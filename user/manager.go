@@ -6,12 +6,15 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"github.com/lib/pq"
 	"github.com/mattn/go-sqlite3"
 	"github.com/stripe/stripe-go/v74"
 	"golang.org/x/crypto/bcrypt"
 	"heckel.io/ntfy/v2/log"
 	"heckel.io/ntfy/v2/util"
+	"math"
 	"net/netip"
+	"regexp"
 	"strings"
 	"sync"
 	"time"
@@ -24,18 +27,26 @@ const (
 	syncTopicLength                 = 16
 	userIDPrefix                    = "u_"
 	userIDLength                    = 12
+	groupIDPrefix                   = "gr_"
+	groupIDLength                   = 12
+	webhookIDPrefix                 = "wh_"
+	webhookIDLength                 = 12
+	webhookSecretLength             = 32
 	userAuthIntentionalSlowDownHash = "$2a$10$YFCQvqQDwIIwnJM1xkAYOeih0dg17UVGanaTStnrSzC8NCWxcLDwy" // Cost should match DefaultUserPasswordBcryptCost
 	userHardDeleteAfterDuration     = 7 * 24 * time.Hour
 	tokenPrefix                     = "tk_"
 	tokenLength                     = 32
 	tokenMaxCount                   = 20 // Only keep this many tokens in the table per user
 	tag                             = "user_manager"
+	topicPermsPriorityGroup         = 2  // See selectTopicPermsQuery
+	provisionedPasswordLength       = 64 // Random password for users provisioned via LDAP or OIDC, see AddProvisionedUser
 )
 
 // Default constants that may be overridden by configs
 const (
 	DefaultUserStatsQueueWriterInterval = 33 * time.Second
 	DefaultUserPasswordBcryptCost       = 10
+	DefaultLockoutDuration              = 15 * time.Minute
 )
 
 var (
@@ -108,6 +119,7 @@ const (
 			last_access INT NOT NULL,
 			last_origin TEXT NOT NULL,
 			expires INT NOT NULL,
+			scopes TEXT NOT NULL DEFAULT '',
 			PRIMARY KEY (user_id, token),
 			FOREIGN KEY (user_id) REFERENCES user (id) ON DELETE CASCADE
 		);
@@ -117,6 +129,92 @@ const (
 			PRIMARY KEY (user_id, phone_number),
 			FOREIGN KEY (user_id) REFERENCES user (id) ON DELETE CASCADE
 		);
+		CREATE TABLE IF NOT EXISTS topic_retention (
+			topic TEXT PRIMARY KEY,
+			max_age INT NOT NULL,
+			max_messages INT NOT NULL
+		);
+		CREATE TABLE IF NOT EXISTS topic_escalation (
+			topic TEXT PRIMARY KEY,
+			delay INT NOT NULL,
+			forward_topic TEXT NOT NULL,
+			email TEXT NOT NULL,
+			phone_number TEXT NOT NULL
+		);
+		CREATE TABLE IF NOT EXISTS user_group (
+			id TEXT PRIMARY KEY,
+			name TEXT NOT NULL,
+			created INT NOT NULL
+		);
+		CREATE UNIQUE INDEX idx_user_group_name ON user_group (name);
+		CREATE TABLE IF NOT EXISTS user_group_member (
+			group_id TEXT NOT NULL,
+			user_id TEXT NOT NULL,
+			PRIMARY KEY (group_id, user_id),
+			FOREIGN KEY (group_id) REFERENCES user_group (id) ON DELETE CASCADE,
+			FOREIGN KEY (user_id) REFERENCES user (id) ON DELETE CASCADE
+		);
+		CREATE INDEX idx_user_group_member_user_id ON user_group_member (user_id);
+		CREATE TABLE IF NOT EXISTS user_group_access (
+			group_id TEXT NOT NULL,
+			topic TEXT NOT NULL,
+			read INT NOT NULL,
+			write INT NOT NULL,
+			PRIMARY KEY (group_id, topic),
+			FOREIGN KEY (group_id) REFERENCES user_group (id) ON DELETE CASCADE
+		);
+		CREATE TABLE IF NOT EXISTS user_2fa (
+			user_id TEXT PRIMARY KEY,
+			secret TEXT NOT NULL,
+			enabled INT NOT NULL,
+			last_step INT NOT NULL,
+			FOREIGN KEY (user_id) REFERENCES user (id) ON DELETE CASCADE
+		);
+		CREATE TABLE IF NOT EXISTS user_2fa_recovery_code (
+			user_id TEXT NOT NULL,
+			code_hash TEXT NOT NULL,
+			PRIMARY KEY (user_id, code_hash),
+			FOREIGN KEY (user_id) REFERENCES user (id) ON DELETE CASCADE
+		);
+		CREATE TABLE IF NOT EXISTS audit_log (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			time INT NOT NULL,
+			actor TEXT NOT NULL,
+			origin TEXT NOT NULL,
+			action TEXT NOT NULL,
+			target TEXT NOT NULL,
+			details TEXT NOT NULL
+		);
+		CREATE INDEX idx_audit_log_time ON audit_log (time);
+		CREATE TABLE IF NOT EXISTS user_lockout (
+			user_id TEXT PRIMARY KEY,
+			failures INT NOT NULL,
+			locked_until INT NOT NULL,
+			FOREIGN KEY (user_id) REFERENCES user (id) ON DELETE CASCADE
+		);
+		CREATE TABLE IF NOT EXISTS topic_webhook (
+			id TEXT PRIMARY KEY,
+			topic TEXT NOT NULL,
+			url TEXT NOT NULL,
+			secret TEXT NOT NULL,
+			created INT NOT NULL
+		);
+		CREATE INDEX idx_topic_webhook_topic ON topic_webhook (topic);
+		CREATE TABLE IF NOT EXISTS user_oidc (
+			user_id TEXT PRIMARY KEY,
+			issuer TEXT NOT NULL,
+			subject TEXT NOT NULL,
+			FOREIGN KEY (user_id) REFERENCES user (id) ON DELETE CASCADE
+		);
+		CREATE UNIQUE INDEX idx_user_oidc_issuer_subject ON user_oidc (issuer, subject);
+		CREATE TABLE IF NOT EXISTS topic_signed_url (
+			topic TEXT PRIMARY KEY,
+			generation INT NOT NULL
+		);
+		CREATE TABLE IF NOT EXISTS user_ldap (
+			user_id TEXT PRIMARY KEY,
+			FOREIGN KEY (user_id) REFERENCES user (id) ON DELETE CASCADE
+		);
 		CREATE TABLE IF NOT EXISTS schemaVersion (
 			id INT PRIMARY KEY,
 			version INT NOT NULL
@@ -157,11 +255,20 @@ const (
 		WHERE u.stripe_customer_id = ?
 	`
 	selectTopicPermsQuery = `
-		SELECT read, write
-		FROM user_access a
-		JOIN user u ON u.id = a.user_id
-		WHERE (u.user = ? OR u.user = ?) AND ? LIKE a.topic ESCAPE '\'
-		ORDER BY u.user DESC, LENGTH(a.topic) DESC, a.write DESC
+		SELECT read, write, priority, LENGTH(topic) AS topic_length
+		FROM (
+			SELECT a.topic, a.read, a.write, IIF(u.user = ?, 3, 1) AS priority
+			FROM user_access a
+			JOIN user u ON u.id = a.user_id
+			WHERE (u.user = ? OR u.user = ?) AND ? LIKE a.topic ESCAPE '\'
+			UNION ALL
+			SELECT ga.topic, ga.read, ga.write, 2 AS priority
+			FROM user_group_access ga
+			JOIN user_group_member m ON m.group_id = ga.group_id
+			JOIN user u ON u.id = m.user_id
+			WHERE u.user = ? AND ? LIKE ga.topic ESCAPE '\'
+		) AS p
+		ORDER BY priority DESC, topic_length DESC, write DESC
 	`
 
 	insertUserQuery = `
@@ -225,6 +332,13 @@ const (
 		WHERE topic = ?
 		  AND user_id = owner_user_id
 	`
+	selectUserReservedTopicsQuery = `
+		SELECT topic
+		FROM user_access
+		WHERE user_id = owner_user_id
+		  AND owner_user_id = (SELECT id FROM user WHERE user = ?)
+	`
+	selectReservedTopicsQuery     = `SELECT topic FROM user_access WHERE user_id = owner_user_id`
 	selectUserHasReservationQuery = `
 		SELECT COUNT(*)
 		FROM user_access
@@ -251,9 +365,9 @@ const (
   	`
 
 	selectTokenCountQuery      = `SELECT COUNT(*) FROM user_token WHERE user_id = ?`
-	selectTokensQuery          = `SELECT token, label, last_access, last_origin, expires FROM user_token WHERE user_id = ?`
-	selectTokenQuery           = `SELECT token, label, last_access, last_origin, expires FROM user_token WHERE user_id = ? AND token = ?`
-	insertTokenQuery           = `INSERT INTO user_token (user_id, token, label, last_access, last_origin, expires) VALUES (?, ?, ?, ?, ?, ?)`
+	selectTokensQuery          = `SELECT token, label, last_access, last_origin, expires, scopes FROM user_token WHERE user_id = ?`
+	selectTokenQuery           = `SELECT token, label, last_access, last_origin, expires, scopes FROM user_token WHERE user_id = ? AND token = ?`
+	insertTokenQuery           = `INSERT INTO user_token (user_id, token, label, last_access, last_origin, expires, scopes) VALUES (?, ?, ?, ?, ?, ?, ?)`
 	updateTokenExpiryQuery     = `UPDATE user_token SET expires = ? WHERE user_id = ? AND token = ?`
 	updateTokenLabelQuery      = `UPDATE user_token SET label = ? WHERE user_id = ? AND token = ?`
 	updateTokenLastAccessQuery = `UPDATE user_token SET last_access = ?, last_origin = ? WHERE token = ?`
@@ -276,6 +390,128 @@ const (
 	insertPhoneNumberQuery  = `INSERT INTO user_phone (user_id, phone_number) VALUES (?, ?)`
 	deletePhoneNumberQuery  = `DELETE FROM user_phone WHERE user_id = ? AND phone_number = ?`
 
+	selectTopicRetentionsQuery = `SELECT topic, max_age, max_messages FROM topic_retention ORDER BY topic`
+	selectTopicRetentionQuery  = `SELECT topic, max_age, max_messages FROM topic_retention WHERE topic = ?`
+	upsertTopicRetentionQuery  = `
+		INSERT INTO topic_retention (topic, max_age, max_messages)
+		VALUES (?, ?, ?)
+		ON CONFLICT (topic)
+		DO UPDATE SET max_age = excluded.max_age, max_messages = excluded.max_messages
+	`
+	deleteTopicRetentionQuery = `DELETE FROM topic_retention WHERE topic = ?`
+
+	selectTopicEscalationsQuery = `SELECT topic, delay, forward_topic, email, phone_number FROM topic_escalation ORDER BY topic`
+	selectTopicEscalationQuery  = `SELECT topic, delay, forward_topic, email, phone_number FROM topic_escalation WHERE topic = ?`
+	upsertTopicEscalationQuery  = `
+		INSERT INTO topic_escalation (topic, delay, forward_topic, email, phone_number)
+		VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT (topic)
+		DO UPDATE SET delay = excluded.delay, forward_topic = excluded.forward_topic, email = excluded.email, phone_number = excluded.phone_number
+	`
+	deleteTopicEscalationQuery = `DELETE FROM topic_escalation WHERE topic = ?`
+
+	insertGroupQuery        = `INSERT INTO user_group (id, name, created) VALUES (?, ?, ?)`
+	selectGroupsQuery       = `SELECT id, name FROM user_group ORDER BY name`
+	selectGroupByNameQuery  = `SELECT id, name FROM user_group WHERE name = ?`
+	deleteGroupQuery        = `DELETE FROM user_group WHERE name = ?`
+	selectGroupMembersQuery = `
+		SELECT u.user
+		FROM user_group_member m
+		JOIN user u ON u.id = m.user_id
+		WHERE m.group_id = ?
+		ORDER BY u.user
+	`
+	selectUserGroupsQuery = `
+		SELECT g.name
+		FROM user_group g
+		JOIN user_group_member m ON m.group_id = g.id
+		JOIN user u ON u.id = m.user_id
+		WHERE u.user = ?
+		ORDER BY g.name
+	`
+	insertGroupMemberQuery = `
+		INSERT INTO user_group_member (group_id, user_id)
+		VALUES ((SELECT id FROM user_group WHERE name = ?), (SELECT id FROM user WHERE user = ?))
+		ON CONFLICT (group_id, user_id) DO NOTHING
+	`
+	deleteGroupMemberQuery = `
+		DELETE FROM user_group_member
+		WHERE group_id = (SELECT id FROM user_group WHERE name = ?)
+		  AND user_id = (SELECT id FROM user WHERE user = ?)
+	`
+	upsertGroupAccessQuery = `
+		INSERT INTO user_group_access (group_id, topic, read, write)
+		VALUES ((SELECT id FROM user_group WHERE name = ?), ?, ?, ?)
+		ON CONFLICT (group_id, topic)
+		DO UPDATE SET read = excluded.read, write = excluded.write
+	`
+	selectGroupAccessQuery = `
+		SELECT topic, read, write
+		FROM user_group_access
+		WHERE group_id = ?
+		ORDER BY LENGTH(topic) DESC, write DESC, read DESC, topic
+	`
+	deleteGroupAccessQuery      = `DELETE FROM user_group_access WHERE group_id = (SELECT id FROM user_group WHERE name = ?)`
+	deleteGroupTopicAccessQuery = `DELETE FROM user_group_access WHERE group_id = (SELECT id FROM user_group WHERE name = ?) AND topic = ?`
+
+	selectTwoFactorQuery = `SELECT secret, enabled, last_step FROM user_2fa WHERE user_id = ?`
+	upsertTwoFactorQuery = `
+		INSERT INTO user_2fa (user_id, secret, enabled, last_step)
+		VALUES (?, ?, ?, ?)
+		ON CONFLICT (user_id)
+		DO UPDATE SET secret = excluded.secret, enabled = excluded.enabled, last_step = excluded.last_step
+	`
+	updateTwoFactorEnabledQuery  = `UPDATE user_2fa SET enabled = ? WHERE user_id = ?`
+	updateTwoFactorLastStepQuery = `UPDATE user_2fa SET last_step = ? WHERE user_id = ? AND last_step < ?`
+	deleteTwoFactorQuery         = `DELETE FROM user_2fa WHERE user_id = ?`
+	insertRecoveryCodeQuery      = `INSERT INTO user_2fa_recovery_code (user_id, code_hash) VALUES (?, ?)`
+	deleteRecoveryCodeQuery      = `DELETE FROM user_2fa_recovery_code WHERE user_id = ? AND code_hash = ?`
+	deleteRecoveryCodesQuery     = `DELETE FROM user_2fa_recovery_code WHERE user_id = ?`
+
+	selectUsernameByIDQuery = `SELECT user FROM user WHERE id = ?`
+	insertAuditEventQuery   = `
+		INSERT INTO audit_log (time, actor, origin, action, target, details)
+		VALUES (?, ?, ?, ?, ?, ?)
+	`
+	selectAuditEventsQuery = `
+		SELECT id, time, actor, origin, action, target, details
+		FROM audit_log
+		WHERE (?1 = '' OR actor = ?1 OR target = ?1) AND (?2 = '' OR action = ?2) AND time >= ?3 AND time <= ?4
+		ORDER BY id DESC
+		LIMIT ?5
+	`
+
+	selectUserLockoutQuery = `SELECT failures, locked_until FROM user_lockout WHERE user_id = ?`
+	upsertUserLockoutQuery = `
+		INSERT INTO user_lockout (user_id, failures, locked_until)
+		VALUES (?, 1, 0)
+		ON CONFLICT (user_id)
+		DO UPDATE SET failures = user_lockout.failures + 1
+	`
+	updateUserLockoutLockedQuery = `UPDATE user_lockout SET failures = 0, locked_until = ? WHERE user_id = ?`
+	deleteUserLockoutQuery       = `DELETE FROM user_lockout WHERE user_id = ?`
+
+	selectTopicWebhooksQuery = `SELECT id, topic, url, secret, created FROM topic_webhook WHERE topic = ? ORDER BY created, id`
+	selectTopicWebhookQuery  = `SELECT id, topic, url, secret, created FROM topic_webhook WHERE id = ?`
+	insertTopicWebhookQuery  = `INSERT INTO topic_webhook (id, topic, url, secret, created) VALUES (?, ?, ?, ?, ?)`
+	deleteTopicWebhookQuery  = `DELETE FROM topic_webhook WHERE topic = ? AND id = ?`
+	deleteTopicWebhooksQuery = `DELETE FROM topic_webhook WHERE topic = ?`
+
+	selectUserIDByOIDCSubjectQuery = `SELECT user_id FROM user_oidc WHERE issuer = ? AND subject = ?`
+	insertUserOIDCQuery            = `INSERT INTO user_oidc (user_id, issuer, subject) VALUES (?, ?, ?)`
+	selectUserOIDCCountQuery       = `SELECT COUNT(*) FROM user_oidc WHERE user_id = ?`
+
+	selectUserLDAPCountQuery = `SELECT COUNT(*) FROM user_ldap WHERE user_id = ?`
+	insertUserLDAPQuery      = `INSERT INTO user_ldap (user_id) VALUES (?)`
+
+	selectSignedURLGenerationQuery = `SELECT generation FROM topic_signed_url WHERE topic = ?`
+	updateSignedURLGenerationQuery = `
+		INSERT INTO topic_signed_url (topic, generation)
+		VALUES (?, 1)
+		ON CONFLICT (topic)
+		DO UPDATE SET generation = topic_signed_url.generation + 1
+	`
+
 	insertTierQuery = `
 		INSERT INTO tier (id, code, name, messages_limit, messages_expiry_duration, emails_limit, calls_limit, reservations_limit, attachment_file_size_limit, attachment_total_size_limit, attachment_expiry_duration, attachment_bandwidth_limit, stripe_monthly_price_id, stripe_yearly_price_id)
 		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
@@ -312,7 +548,7 @@ const (
 
 // Schema management queries
 const (
-	currentSchemaVersion     = 5
+	currentSchemaVersion     = 16
 	insertSchemaVersion      = `INSERT INTO schemaVersion VALUES (1, ?)`
 	updateSchemaVersion      = `UPDATE schemaVersion SET version = ? WHERE id = 1`
 	selectSchemaVersionQuery = `SELECT version FROM schemaVersion WHERE id = 1`
@@ -427,32 +663,402 @@ const (
 	migrate4To5UpdateQueries = `
 		UPDATE user_access SET topic = REPLACE(topic, '_', '\_');
 	`
+
+	// 5 -> 6
+	migrate5To6UpdateQueries = `
+		CREATE TABLE IF NOT EXISTS topic_retention (
+			topic TEXT PRIMARY KEY,
+			max_age INT NOT NULL,
+			max_messages INT NOT NULL
+		);
+	`
+
+	// 6 -> 7
+	migrate6To7UpdateQueries = `
+		CREATE TABLE IF NOT EXISTS topic_escalation (
+			topic TEXT PRIMARY KEY,
+			delay INT NOT NULL,
+			forward_topic TEXT NOT NULL,
+			email TEXT NOT NULL,
+			phone_number TEXT NOT NULL
+		);
+	`
+
+	// 7 -> 8
+	migrate7To8UpdateQueries = `
+		CREATE TABLE IF NOT EXISTS user_group (
+			id TEXT PRIMARY KEY,
+			name TEXT NOT NULL,
+			created INT NOT NULL
+		);
+		CREATE UNIQUE INDEX idx_user_group_name ON user_group (name);
+		CREATE TABLE IF NOT EXISTS user_group_member (
+			group_id TEXT NOT NULL,
+			user_id TEXT NOT NULL,
+			PRIMARY KEY (group_id, user_id),
+			FOREIGN KEY (group_id) REFERENCES user_group (id) ON DELETE CASCADE,
+			FOREIGN KEY (user_id) REFERENCES user (id) ON DELETE CASCADE
+		);
+		CREATE INDEX idx_user_group_member_user_id ON user_group_member (user_id);
+		CREATE TABLE IF NOT EXISTS user_group_access (
+			group_id TEXT NOT NULL,
+			topic TEXT NOT NULL,
+			read INT NOT NULL,
+			write INT NOT NULL,
+			PRIMARY KEY (group_id, topic),
+			FOREIGN KEY (group_id) REFERENCES user_group (id) ON DELETE CASCADE
+		);
+	`
+
+	// 8 -> 9
+	migrate8To9UpdateQueries = `
+		ALTER TABLE user_token ADD COLUMN scopes TEXT NOT NULL DEFAULT '';
+	`
+
+	// 9 -> 10
+	migrate9To10UpdateQueries = `
+		CREATE TABLE IF NOT EXISTS user_2fa (
+			user_id TEXT PRIMARY KEY,
+			secret TEXT NOT NULL,
+			enabled INT NOT NULL,
+			last_step INT NOT NULL,
+			FOREIGN KEY (user_id) REFERENCES user (id) ON DELETE CASCADE
+		);
+		CREATE TABLE IF NOT EXISTS user_2fa_recovery_code (
+			user_id TEXT NOT NULL,
+			code_hash TEXT NOT NULL,
+			PRIMARY KEY (user_id, code_hash),
+			FOREIGN KEY (user_id) REFERENCES user (id) ON DELETE CASCADE
+		);
+	`
+
+	// 10 -> 11
+	migrate10To11UpdateQueries = `
+		CREATE TABLE IF NOT EXISTS audit_log (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			time INT NOT NULL,
+			actor TEXT NOT NULL,
+			origin TEXT NOT NULL,
+			action TEXT NOT NULL,
+			target TEXT NOT NULL,
+			details TEXT NOT NULL
+		);
+		CREATE INDEX idx_audit_log_time ON audit_log (time);
+	`
+
+	// 11 -> 12
+	migrate11To12UpdateQueries = `
+		CREATE TABLE IF NOT EXISTS user_lockout (
+			user_id TEXT PRIMARY KEY,
+			failures INT NOT NULL,
+			locked_until INT NOT NULL,
+			FOREIGN KEY (user_id) REFERENCES user (id) ON DELETE CASCADE
+		);
+	`
+
+	// 12 -> 13
+	migrate12To13UpdateQueries = `
+		CREATE TABLE IF NOT EXISTS topic_webhook (
+			id TEXT PRIMARY KEY,
+			topic TEXT NOT NULL,
+			url TEXT NOT NULL,
+			secret TEXT NOT NULL,
+			created INT NOT NULL
+		);
+		CREATE INDEX idx_topic_webhook_topic ON topic_webhook (topic);
+	`
+
+	// 13 -> 14
+	migrate13To14UpdateQueries = `
+		CREATE TABLE IF NOT EXISTS user_oidc (
+			user_id TEXT PRIMARY KEY,
+			issuer TEXT NOT NULL,
+			subject TEXT NOT NULL,
+			FOREIGN KEY (user_id) REFERENCES user (id) ON DELETE CASCADE
+		);
+		CREATE UNIQUE INDEX idx_user_oidc_issuer_subject ON user_oidc (issuer, subject);
+	`
+
+	// 14 -> 15
+	migrate14To15UpdateQueries = `
+		CREATE TABLE IF NOT EXISTS topic_signed_url (
+			topic TEXT PRIMARY KEY,
+			generation INT NOT NULL
+		);
+	`
+
+	// 15 -> 16
+	migrate15To16UpdateQueries = `
+		CREATE TABLE IF NOT EXISTS user_ldap (
+			user_id TEXT PRIMARY KEY,
+			FOREIGN KEY (user_id) REFERENCES user (id) ON DELETE CASCADE
+		);
+		INSERT INTO user_ldap (user_id)
+		SELECT DISTINCT u.id
+		FROM user u
+		JOIN audit_log a ON a.target = u.user AND a.origin = 'ldap' AND a.action = 'user.add';
+	`
 )
 
 var (
 	migrations = map[int]func(db *sql.DB) error{
-		1: migrateFrom1,
-		2: migrateFrom2,
-		3: migrateFrom3,
-		4: migrateFrom4,
+		1:  migrateFrom1,
+		2:  migrateFrom2,
+		3:  migrateFrom3,
+		4:  migrateFrom4,
+		5:  migrateFrom5,
+		6:  migrateFrom6,
+		7:  migrateFrom7,
+		8:  migrateFrom8,
+		9:  migrateFrom9,
+		10: migrateFrom10,
+		11: migrateFrom11,
+		12: migrateFrom12,
+		13: migrateFrom13,
+		14: migrateFrom14,
+		15: migrateFrom15,
 	}
 )
 
+// managerQueries holds all queries used by the Manager. Since the SQL dialects of the supported
+// database backends (SQLite, PostgreSQL) differ, each backend brings its own set of queries.
+type managerQueries struct {
+	selectUserByID               string
+	selectUserByName             string
+	selectUserByToken            string
+	selectUserByStripeCustomerID string
+	selectTopicPerms             string
+	insertUser                   string
+	selectUsernames              string
+	selectUserCount              string
+	updateUserPass               string
+	updateUserRole               string
+	updateUserPrefs              string
+	updateUserStats              string
+	updateUserStatsResetAll      string
+	updateUserDeleted            string
+	deleteUsersMarked            string
+	deleteUser                   string
+	upsertUserAccess             string
+	selectUserAllAccess          string
+	selectUserAccess             string
+	selectUserReservations       string
+	selectUserReservationsCount  string
+	selectUserReservationsOwner  string
+	selectUserReservedTopics     string
+	selectReservedTopics         string
+	selectUserHasReservation     string
+	selectOtherAccessCount       string
+	deleteAllAccess              string
+	deleteUserAccess             string
+	deleteTopicAccess            string
+	selectTokenCount             string
+	selectTokens                 string
+	selectToken                  string
+	insertToken                  string
+	updateTokenExpiry            string
+	updateTokenLabel             string
+	updateTokenLastAccess        string
+	deleteToken                  string
+	deleteAllToken               string
+	deleteExpiredTokens          string
+	deleteExcessTokens           string
+	selectPhoneNumbers           string
+	insertPhoneNumber            string
+	deletePhoneNumber            string
+	selectTopicRetentions        string
+	selectTopicRetention         string
+	upsertTopicRetention         string
+	deleteTopicRetention         string
+	selectTopicEscalations       string
+	selectTopicEscalation        string
+	upsertTopicEscalation        string
+	deleteTopicEscalation        string
+	insertGroup                  string
+	selectGroups                 string
+	selectGroupByName            string
+	deleteGroup                  string
+	selectGroupMembers           string
+	selectUserGroups             string
+	insertGroupMember            string
+	deleteGroupMember            string
+	upsertGroupAccess            string
+	selectGroupAccess            string
+	deleteGroupAccess            string
+	deleteGroupTopicAccess       string
+	selectTwoFactor              string
+	upsertTwoFactor              string
+	updateTwoFactorEnabled       string
+	updateTwoFactorLastStep      string
+	deleteTwoFactor              string
+	insertRecoveryCode           string
+	deleteRecoveryCode           string
+	deleteRecoveryCodes          string
+	insertAuditEvent             string
+	selectUsernameByID           string
+	selectAuditEvents            string
+	selectUserLockout            string
+	upsertUserLockout            string
+	updateUserLockoutLocked      string
+	deleteUserLockout            string
+	selectTopicWebhooks          string
+	selectTopicWebhook           string
+	insertTopicWebhook           string
+	deleteTopicWebhook           string
+	deleteTopicWebhooks          string
+	selectUserIDByOIDCSubject    string
+	insertUserOIDC               string
+	selectUserOIDCCount          string
+	selectUserLDAPCount          string
+	insertUserLDAP               string
+	selectSignedURLGeneration    string
+	updateSignedURLGeneration    string
+	insertTier                   string
+	updateTier                   string
+	selectTiers                  string
+	selectTierByCode             string
+	selectTierByPriceID          string
+	updateUserTier               string
+	deleteUserTier               string
+	deleteTier                   string
+	updateBilling                string
+}
+
+var sqliteManagerQueries = &managerQueries{
+	selectUserByID:               selectUserByIDQuery,
+	selectUserByName:             selectUserByNameQuery,
+	selectUserByToken:            selectUserByTokenQuery,
+	selectUserByStripeCustomerID: selectUserByStripeCustomerIDQuery,
+	selectTopicPerms:             selectTopicPermsQuery,
+	insertUser:                   insertUserQuery,
+	selectUsernames:              selectUsernamesQuery,
+	selectUserCount:              selectUserCountQuery,
+	updateUserPass:               updateUserPassQuery,
+	updateUserRole:               updateUserRoleQuery,
+	updateUserPrefs:              updateUserPrefsQuery,
+	updateUserStats:              updateUserStatsQuery,
+	updateUserStatsResetAll:      updateUserStatsResetAllQuery,
+	updateUserDeleted:            updateUserDeletedQuery,
+	deleteUsersMarked:            deleteUsersMarkedQuery,
+	deleteUser:                   deleteUserQuery,
+	upsertUserAccess:             upsertUserAccessQuery,
+	selectUserAllAccess:          selectUserAllAccessQuery,
+	selectUserAccess:             selectUserAccessQuery,
+	selectUserReservations:       selectUserReservationsQuery,
+	selectUserReservationsCount:  selectUserReservationsCountQuery,
+	selectUserReservationsOwner:  selectUserReservationsOwnerQuery,
+	selectUserReservedTopics:     selectUserReservedTopicsQuery,
+	selectReservedTopics:         selectReservedTopicsQuery,
+	selectUserHasReservation:     selectUserHasReservationQuery,
+	selectOtherAccessCount:       selectOtherAccessCountQuery,
+	deleteAllAccess:              deleteAllAccessQuery,
+	deleteUserAccess:             deleteUserAccessQuery,
+	deleteTopicAccess:            deleteTopicAccessQuery,
+	selectTokenCount:             selectTokenCountQuery,
+	selectTokens:                 selectTokensQuery,
+	selectToken:                  selectTokenQuery,
+	insertToken:                  insertTokenQuery,
+	updateTokenExpiry:            updateTokenExpiryQuery,
+	updateTokenLabel:             updateTokenLabelQuery,
+	updateTokenLastAccess:        updateTokenLastAccessQuery,
+	deleteToken:                  deleteTokenQuery,
+	deleteAllToken:               deleteAllTokenQuery,
+	deleteExpiredTokens:          deleteExpiredTokensQuery,
+	deleteExcessTokens:           deleteExcessTokensQuery,
+	selectPhoneNumbers:           selectPhoneNumbersQuery,
+	insertPhoneNumber:            insertPhoneNumberQuery,
+	deletePhoneNumber:            deletePhoneNumberQuery,
+	selectTopicRetentions:        selectTopicRetentionsQuery,
+	selectTopicRetention:         selectTopicRetentionQuery,
+	upsertTopicRetention:         upsertTopicRetentionQuery,
+	deleteTopicRetention:         deleteTopicRetentionQuery,
+	selectTopicEscalations:       selectTopicEscalationsQuery,
+	selectTopicEscalation:        selectTopicEscalationQuery,
+	upsertTopicEscalation:        upsertTopicEscalationQuery,
+	deleteTopicEscalation:        deleteTopicEscalationQuery,
+	insertGroup:                  insertGroupQuery,
+	selectGroups:                 selectGroupsQuery,
+	selectGroupByName:            selectGroupByNameQuery,
+	deleteGroup:                  deleteGroupQuery,
+	selectGroupMembers:           selectGroupMembersQuery,
+	selectUserGroups:             selectUserGroupsQuery,
+	insertGroupMember:            insertGroupMemberQuery,
+	deleteGroupMember:            deleteGroupMemberQuery,
+	upsertGroupAccess:            upsertGroupAccessQuery,
+	selectGroupAccess:            selectGroupAccessQuery,
+	deleteGroupAccess:            deleteGroupAccessQuery,
+	deleteGroupTopicAccess:       deleteGroupTopicAccessQuery,
+	selectTwoFactor:              selectTwoFactorQuery,
+	upsertTwoFactor:              upsertTwoFactorQuery,
+	updateTwoFactorEnabled:       updateTwoFactorEnabledQuery,
+	updateTwoFactorLastStep:      updateTwoFactorLastStepQuery,
+	deleteTwoFactor:              deleteTwoFactorQuery,
+	insertRecoveryCode:           insertRecoveryCodeQuery,
+	deleteRecoveryCode:           deleteRecoveryCodeQuery,
+	deleteRecoveryCodes:          deleteRecoveryCodesQuery,
+	insertAuditEvent:             insertAuditEventQuery,
+	selectUsernameByID:           selectUsernameByIDQuery,
+	selectAuditEvents:            selectAuditEventsQuery,
+	selectUserLockout:            selectUserLockoutQuery,
+	upsertUserLockout:            upsertUserLockoutQuery,
+	updateUserLockoutLocked:      updateUserLockoutLockedQuery,
+	deleteUserLockout:            deleteUserLockoutQuery,
+	selectTopicWebhooks:          selectTopicWebhooksQuery,
+	selectTopicWebhook:           selectTopicWebhookQuery,
+	insertTopicWebhook:           insertTopicWebhookQuery,
+	deleteTopicWebhook:           deleteTopicWebhookQuery,
+	deleteTopicWebhooks:          deleteTopicWebhooksQuery,
+	selectUserIDByOIDCSubject:    selectUserIDByOIDCSubjectQuery,
+	insertUserOIDC:               insertUserOIDCQuery,
+	selectUserOIDCCount:          selectUserOIDCCountQuery,
+	selectUserLDAPCount:          selectUserLDAPCountQuery,
+	insertUserLDAP:               insertUserLDAPQuery,
+	selectSignedURLGeneration:    selectSignedURLGenerationQuery,
+	updateSignedURLGeneration:    updateSignedURLGenerationQuery,
+	insertTier:                   insertTierQuery,
+	updateTier:                   updateTierQuery,
+	selectTiers:                  selectTiersQuery,
+	selectTierByCode:             selectTierByCodeQuery,
+	selectTierByPriceID:          selectTierByPriceIDQuery,
+	updateUserTier:               updateUserTierQuery,
+	deleteUserTier:               deleteUserTierQuery,
+	deleteTier:                   deleteTierQuery,
+	updateBilling:                updateBillingQuery,
+}
+
 // Manager is an implementation of Manager. It stores users and access control list
-// in a SQLite database.
+// in a SQLite or PostgreSQL database.
 type Manager struct {
+	*managerState
+	actor *Actor // Recorded in the audit log for all changes made via this Manager, see WithActor
+}
+
+// managerState is the state shared by a Manager and all Manager instances derived from it via WithActor
+type managerState struct {
 	db            *sql.DB
+	queries       *managerQueries         // Queries in the SQL dialect of the database backend
 	defaultAccess Permission              // Default permission if no ACL matches
 	statsQueue    map[string]*Stats       // "Queue" to asynchronously write user stats to the database (UserID -> Stats)
 	tokenQueue    map[string]*TokenUpdate // "Queue" to asynchronously write token access stats to the database (Token ID -> TokenUpdate)
 	bcryptCost    int                     // Makes testing easier
+	policy        *PasswordPolicy         // Rules for new passwords, may be nil
+	lockoutCount  int                     // Number of consecutive failed logins after which a user is locked, 0 to disable
+	lockoutFor    time.Duration           // Duration for which a user is locked after lockoutCount failed logins
 	mu            sync.Mutex
 }
 
 var _ Auther = (*Manager)(nil)
 
-// NewManager creates a new Manager instance
+// NewManager creates a new Manager instance. If filename is a PostgreSQL connection string
+// (postgres://...), the users are stored in PostgreSQL, otherwise in a SQLite database file.
 func NewManager(filename, startupQueries string, defaultAccess Permission, bcryptCost int, queueWriterInterval time.Duration) (*Manager, error) {
+	if util.IsPostgresURL(filename) {
+		db, err := newPostgresDB(filename, startupQueries)
+		if err != nil {
+			return nil, err
+		}
+		return newManager(db, postgresManagerQueries, defaultAccess, bcryptCost, queueWriterInterval), nil
+	}
 	db, err := sql.Open("sqlite3", filename)
 	if err != nil {
 		return nil, err
@@ -463,20 +1069,52 @@ func NewManager(filename, startupQueries string, defaultAccess Permission, bcryp
 	if err := runStartupQueries(db, startupQueries); err != nil {
 		return nil, err
 	}
+	return newManager(db, sqliteManagerQueries, defaultAccess, bcryptCost, queueWriterInterval), nil
+}
+
+func newManager(db *sql.DB, queries *managerQueries, defaultAccess Permission, bcryptCost int, queueWriterInterval time.Duration) *Manager {
 	manager := &Manager{
-		db:            db,
-		defaultAccess: defaultAccess,
-		statsQueue:    make(map[string]*Stats),
-		tokenQueue:    make(map[string]*TokenUpdate),
-		bcryptCost:    bcryptCost,
+		managerState: &managerState{
+			db:            db,
+			queries:       queries,
+			defaultAccess: defaultAccess,
+			statsQueue:    make(map[string]*Stats),
+			tokenQueue:    make(map[string]*TokenUpdate),
+			bcryptCost:    bcryptCost,
+		},
 	}
 	go manager.asyncQueueWriter(queueWriterInterval)
-	return manager, nil
+	return manager
+}
+
+// WithActor returns a Manager that shares the database and settings with this Manager, but records the given
+// actor in the audit log for all changes made through it. Changes made via a Manager without an actor are
+// recorded with an empty actor and origin.
+func (a *Manager) WithActor(actor *Actor) *Manager {
+	return &Manager{
+		managerState: a.managerState,
+		actor:        actor,
+	}
+}
+
+// SetPasswordPolicy sets the rules that new passwords must satisfy in AddUser and ChangePassword.
+// A nil policy accepts any password. This must be called before the manager is used.
+func (a *Manager) SetPasswordPolicy(policy *PasswordPolicy) {
+	a.policy = policy
+}
+
+// SetLockout enables the account lockout: after the given number of consecutive failed logins, Authenticate
+// rejects all logins of the user for the given duration, or until the user is unlocked via UnlockUser.
+// An attempts value of 0 disables the lockout. This must be called before the manager is used.
+func (a *Manager) SetLockout(attempts int, duration time.Duration) {
+	a.lockoutCount = attempts
+	a.lockoutFor = duration
 }
 
 // Authenticate checks username and password and returns a User if correct, and the user has not been
 // marked as deleted. The method returns in constant-ish time, regardless of whether the user exists or
-// the password is correct or incorrect.
+// the password is correct or incorrect. If the account lockout is enabled (see SetLockout), failed logins
+// are counted, and ErrUserLocked is returned while the user is locked, even if the password is correct.
 func (a *Manager) Authenticate(username, password string) (*User, error) {
 	if username == Everyone {
 		return nil, ErrUnauthenticated
@@ -490,13 +1128,106 @@ func (a *Manager) Authenticate(username, password string) (*User, error) {
 		log.Tag(tag).Field("user_name", username).Trace("Authentication of user failed (2): user marked deleted")
 		bcrypt.CompareHashAndPassword([]byte(userAuthIntentionalSlowDownHash), []byte("intentional slow-down to avoid timing attacks"))
 		return nil, ErrUnauthenticated
+	}
+	failures, lockedUntil, err := a.userLockout(user.ID)
+	if err != nil {
+		return nil, err
+	} else if lockedUntil > time.Now().Unix() {
+		log.Tag(tag).Field("user_name", username).Trace("Authentication of user failed (3): user locked")
+		bcrypt.CompareHashAndPassword([]byte(userAuthIntentionalSlowDownHash), []byte("intentional slow-down to avoid timing attacks"))
+		return nil, ErrUserLocked
 	} else if err := bcrypt.CompareHashAndPassword([]byte(user.Hash), []byte(password)); err != nil {
-		log.Tag(tag).Field("user_name", username).Err(err).Trace("Authentication of user failed (3)")
+		log.Tag(tag).Field("user_name", username).Err(err).Trace("Authentication of user failed (4)")
+		if err := a.addLoginFailure(user.ID); err != nil {
+			log.Tag(tag).Field("user_name", username).Err(err).Warn("Unable to record failed login")
+		}
 		return nil, ErrUnauthenticated
+	} else if failures > 0 {
+		if err := a.resetLoginFailures(user.ID); err != nil {
+			return nil, err
+		}
 	}
 	return user, nil
 }
 
+// userLockout returns the number of consecutive failed logins of the user, and the unix time until which
+// the user is locked. If the account lockout is disabled, no database query is made.
+func (a *Manager) userLockout(userID string) (failures int, lockedUntil int64, err error) {
+	if a.lockoutCount <= 0 {
+		return 0, 0, nil
+	}
+	rows, err := a.db.Query(a.queries.selectUserLockout, userID)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer rows.Close()
+	if !rows.Next() {
+		return 0, 0, nil
+	}
+	if err := rows.Scan(&failures, &lockedUntil); err != nil {
+		return 0, 0, err
+	}
+	return failures, lockedUntil, rows.Err()
+}
+
+// addLoginFailure increases the failed login counter of the user, and locks the user if the
+// counter reaches the configured number of attempts
+func (a *Manager) addLoginFailure(userID string) error {
+	if a.lockoutCount <= 0 {
+		return nil
+	}
+	tx, err := a.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(a.queries.upsertUserLockout, userID); err != nil {
+		return err
+	}
+	var failures int
+	if err := tx.QueryRow(a.queries.selectUserLockout, userID).Scan(&failures, new(int64)); err != nil {
+		return err
+	}
+	if failures >= a.lockoutCount {
+		lockedUntil := time.Now().Add(a.lockoutFor).Unix()
+		log.Tag(tag).Field("user_id", userID).Info("Locking user until %s after %d failed logins", time.Unix(lockedUntil, 0).Format(time.RFC3339), failures)
+		if _, err := tx.Exec(a.queries.updateUserLockoutLocked, lockedUntil, userID); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// resetLoginFailures resets the failed login counter of the user after a successful login
+func (a *Manager) resetLoginFailures(userID string) error {
+	if a.lockoutCount <= 0 {
+		return nil
+	}
+	_, err := a.db.Exec(a.queries.deleteUserLockout, userID)
+	return err
+}
+
+// UnlockUser resets the failed login counter of the user, and removes the lock if the user is
+// currently locked due to too many failed logins
+func (a *Manager) UnlockUser(username string) error {
+	user, err := a.User(username)
+	if err != nil {
+		return err
+	}
+	tx, err := a.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(a.queries.deleteUserLockout, user.ID); err != nil {
+		return err
+	}
+	if err := a.audit(tx, AuditActionUserUnlock, username, ""); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
 // AuthenticateToken checks if the token exists and returns the associated User if it does.
 // The method sets the User.Token value to the token that was used for authentication.
 func (a *Manager) AuthenticateToken(token string) (*User, error) {
@@ -515,7 +1246,14 @@ func (a *Manager) AuthenticateToken(token string) (*User, error) {
 // CreateToken generates a random token for the given user and returns it. The token expires
 // after a fixed duration unless ChangeToken is called. This function also prunes tokens for the
 // given user, if there are too many of them.
-func (a *Manager) CreateToken(userID, label string, expires time.Time, origin netip.Addr) (*Token, error) {
+//
+// If scopes are passed, the token is restricted to the given topic patterns and permissions, in
+// addition to the user's own permissions. If scopes is empty, the token has full access to the account.
+func (a *Manager) CreateToken(userID, label string, expires time.Time, origin netip.Addr, scopes []Grant) (*Token, error) {
+	scopesJSON, err := encodeTokenScopes(scopes)
+	if err != nil {
+		return nil, err
+	}
 	token := util.RandomLowerStringPrefix(tokenPrefix, tokenLength) // Lowercase only to support "<topic>+<token>@<domain>" email addresses
 	tx, err := a.db.Begin()
 	if err != nil {
@@ -523,10 +1261,10 @@ func (a *Manager) CreateToken(userID, label string, expires time.Time, origin ne
 	}
 	defer tx.Rollback()
 	access := time.Now()
-	if _, err := tx.Exec(insertTokenQuery, userID, token, label, access.Unix(), origin.String(), expires.Unix()); err != nil {
+	if _, err := tx.Exec(a.queries.insertToken, userID, token, label, access.Unix(), origin.String(), expires.Unix(), scopesJSON); err != nil {
 		return nil, err
 	}
-	rows, err := tx.Query(selectTokenCountQuery, userID)
+	rows, err := tx.Query(a.queries.selectTokenCount, userID)
 	if err != nil {
 		return nil, err
 	}
@@ -538,13 +1276,19 @@ func (a *Manager) CreateToken(userID, label string, expires time.Time, origin ne
 	if err := rows.Scan(&tokenCount); err != nil {
 		return nil, err
 	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
 	if tokenCount >= tokenMaxCount {
 		// This pruning logic is done in two queries for efficiency. The SELECT above is a lookup
 		// on two indices, whereas the query below is a full table scan.
-		if _, err := tx.Exec(deleteExcessTokensQuery, userID, userID, tokenMaxCount); err != nil {
+		if _, err := tx.Exec(a.queries.deleteExcessTokens, userID, userID, tokenMaxCount); err != nil {
 			return nil, err
 		}
 	}
+	if err := a.auditUserID(tx, AuditActionTokenCreate, userID, fmt.Sprintf("label=%q scopes=%d", label, len(scopes))); err != nil {
+		return nil, err
+	}
 	if err := tx.Commit(); err != nil {
 		return nil, err
 	}
@@ -554,12 +1298,13 @@ func (a *Manager) CreateToken(userID, label string, expires time.Time, origin ne
 		LastAccess: access,
 		LastOrigin: origin,
 		Expires:    expires,
+		Scopes:     scopes,
 	}, nil
 }
 
 // Tokens returns all existing tokens for the user with the given user ID
 func (a *Manager) Tokens(userID string) ([]*Token, error) {
-	rows, err := a.db.Query(selectTokensQuery, userID)
+	rows, err := a.db.Query(a.queries.selectTokens, userID)
 	if err != nil {
 		return nil, err
 	}
@@ -579,7 +1324,7 @@ func (a *Manager) Tokens(userID string) ([]*Token, error) {
 
 // Token returns a specific token for a user
 func (a *Manager) Token(userID, token string) (*Token, error) {
-	rows, err := a.db.Query(selectTokenQuery, userID, token)
+	rows, err := a.db.Query(a.queries.selectToken, userID, token)
 	if err != nil {
 		return nil, err
 	}
@@ -588,12 +1333,12 @@ func (a *Manager) Token(userID, token string) (*Token, error) {
 }
 
 func (a *Manager) readToken(rows *sql.Rows) (*Token, error) {
-	var token, label, lastOrigin string
+	var token, label, lastOrigin, scopesJSON string
 	var lastAccess, expires int64
 	if !rows.Next() {
 		return nil, ErrTokenNotFound
 	}
-	if err := rows.Scan(&token, &label, &lastAccess, &lastOrigin, &expires); err != nil {
+	if err := rows.Scan(&token, &label, &lastAccess, &lastOrigin, &expires, &scopesJSON); err != nil {
 		return nil, err
 	} else if err := rows.Err(); err != nil {
 		return nil, err
@@ -602,15 +1347,63 @@ func (a *Manager) readToken(rows *sql.Rows) (*Token, error) {
 	if err != nil {
 		lastOriginIP = netip.IPv4Unspecified()
 	}
+	scopes, err := decodeTokenScopes(scopesJSON)
+	if err != nil {
+		return nil, err
+	}
 	return &Token{
 		Value:      token,
 		Label:      label,
 		LastAccess: time.Unix(lastAccess, 0),
 		LastOrigin: lastOriginIP,
 		Expires:    time.Unix(expires, 0),
+		Scopes:     scopes,
 	}, nil
 }
 
+// tokenScope is the JSON representation of a token scope (see Token.Scopes) in the user_token table
+type tokenScope struct {
+	Topic      string `json:"topic"`
+	Permission string `json:"permission"`
+}
+
+func encodeTokenScopes(scopes []Grant) (string, error) {
+	if len(scopes) == 0 {
+		return "", nil
+	}
+	encoded := make([]tokenScope, 0, len(scopes))
+	for _, scope := range scopes {
+		if !AllowedTopicPattern(scope.TopicPattern) {
+			return "", ErrInvalidArgument
+		}
+		encoded = append(encoded, tokenScope{Topic: scope.TopicPattern, Permission: scope.Allow.String()})
+	}
+	b, err := json.Marshal(encoded)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+func decodeTokenScopes(s string) ([]Grant, error) {
+	if s == "" {
+		return nil, nil
+	}
+	var encoded []tokenScope
+	if err := json.Unmarshal([]byte(s), &encoded); err != nil {
+		return nil, err
+	}
+	scopes := make([]Grant, 0, len(encoded))
+	for _, scope := range encoded {
+		permission, err := ParsePermission(scope.Permission)
+		if err != nil {
+			return nil, err
+		}
+		scopes = append(scopes, Grant{TopicPattern: scope.Topic, Allow: permission})
+	}
+	return scopes, nil
+}
+
 // ChangeToken updates a token's label and/or expiry date
 func (a *Manager) ChangeToken(userID, token string, label *string, expires *time.Time) (*Token, error) {
 	if token == "" {
@@ -622,15 +1415,25 @@ func (a *Manager) ChangeToken(userID, token string, label *string, expires *time
 	}
 	defer tx.Rollback()
 	if label != nil {
-		if _, err := tx.Exec(updateTokenLabelQuery, *label, userID, token); err != nil {
+		if _, err := tx.Exec(a.queries.updateTokenLabel, *label, userID, token); err != nil {
 			return nil, err
 		}
 	}
 	if expires != nil {
-		if _, err := tx.Exec(updateTokenExpiryQuery, expires.Unix(), userID, token); err != nil {
+		if _, err := tx.Exec(a.queries.updateTokenExpiry, expires.Unix(), userID, token); err != nil {
 			return nil, err
 		}
 	}
+	details := make([]string, 0)
+	if label != nil {
+		details = append(details, fmt.Sprintf("label=%q", *label))
+	}
+	if expires != nil {
+		details = append(details, fmt.Sprintf("expires=%d", expires.Unix()))
+	}
+	if err := a.auditUserID(tx, AuditActionTokenUpdate, userID, strings.Join(details, " ")); err != nil {
+		return nil, err
+	}
 	if err := tx.Commit(); err != nil {
 		return nil, err
 	}
@@ -642,15 +1445,23 @@ func (a *Manager) RemoveToken(userID, token string) error {
 	if token == "" {
 		return errNoTokenProvided
 	}
-	if _, err := a.db.Exec(deleteTokenQuery, userID, token); err != nil {
+	tx, err := a.db.Begin()
+	if err != nil {
 		return err
 	}
-	return nil
+	defer tx.Rollback()
+	if _, err := tx.Exec(a.queries.deleteToken, userID, token); err != nil {
+		return err
+	}
+	if err := a.auditUserID(tx, AuditActionTokenRemove, userID, ""); err != nil {
+		return err
+	}
+	return tx.Commit()
 }
 
 // RemoveExpiredTokens deletes all expired tokens from the database
 func (a *Manager) RemoveExpiredTokens() error {
-	if _, err := a.db.Exec(deleteExpiredTokensQuery, time.Now().Unix()); err != nil {
+	if _, err := a.db.Exec(a.queries.deleteExpiredTokens, time.Now().Unix()); err != nil {
 		return err
 	}
 	return nil
@@ -658,7 +1469,7 @@ func (a *Manager) RemoveExpiredTokens() error {
 
 // PhoneNumbers returns all phone numbers for the user with the given user ID
 func (a *Manager) PhoneNumbers(userID string) ([]string, error) {
-	rows, err := a.db.Query(selectPhoneNumbersQuery, userID)
+	rows, err := a.db.Query(a.queries.selectPhoneNumbers, userID)
 	if err != nil {
 		return nil, err
 	}
@@ -691,8 +1502,8 @@ func (a *Manager) readPhoneNumber(rows *sql.Rows) (string, error) {
 
 // AddPhoneNumber adds a phone number to the user with the given user ID
 func (a *Manager) AddPhoneNumber(userID string, phoneNumber string) error {
-	if _, err := a.db.Exec(insertPhoneNumberQuery, userID, phoneNumber); err != nil {
-		if sqliteErr, ok := err.(sqlite3.Error); ok && sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique {
+	if _, err := a.db.Exec(a.queries.insertPhoneNumber, userID, phoneNumber); err != nil {
+		if isUniqueConstraintError(err) {
 			return ErrPhoneNumberExists
 		}
 		return err
@@ -702,13 +1513,13 @@ func (a *Manager) AddPhoneNumber(userID string, phoneNumber string) error {
 
 // RemovePhoneNumber deletes a phone number from the user with the given user ID
 func (a *Manager) RemovePhoneNumber(userID string, phoneNumber string) error {
-	_, err := a.db.Exec(deletePhoneNumberQuery, userID, phoneNumber)
+	_, err := a.db.Exec(a.queries.deletePhoneNumber, userID, phoneNumber)
 	return err
 }
 
 // RemoveDeletedUsers deletes all users that have been marked deleted for
 func (a *Manager) RemoveDeletedUsers() error {
-	if _, err := a.db.Exec(deleteUsersMarkedQuery, time.Now().Unix()); err != nil {
+	if _, err := a.db.Exec(a.queries.deleteUsersMarked, time.Now().Unix()); err != nil {
 		return err
 	}
 	return nil
@@ -720,7 +1531,7 @@ func (a *Manager) ChangeSettings(userID string, prefs *Prefs) error {
 	if err != nil {
 		return err
 	}
-	if _, err := a.db.Exec(updateUserPrefsQuery, string(b), userID); err != nil {
+	if _, err := a.db.Exec(a.queries.updateUserPrefs, string(b), userID); err != nil {
 		return err
 	}
 	return nil
@@ -730,7 +1541,7 @@ func (a *Manager) ChangeSettings(userID string, prefs *Prefs) error {
 func (a *Manager) ResetStats() error {
 	a.mu.Lock() // Includes database query to avoid races!
 	defer a.mu.Unlock()
-	if _, err := a.db.Exec(updateUserStatsResetAllQuery); err != nil {
+	if _, err := a.db.Exec(a.queries.updateUserStatsResetAll); err != nil {
 		return err
 	}
 	a.statsQueue = make(map[string]*Stats)
@@ -791,7 +1602,7 @@ func (a *Manager) writeUserStatsQueue() error {
 				"calls_count":    update.Calls,
 			}).
 			Trace("Updating stats for user %s", userID)
-		if _, err := tx.Exec(updateUserStatsQuery, update.Messages, update.Emails, update.Calls, userID); err != nil {
+		if _, err := tx.Exec(a.queries.updateUserStats, update.Messages, update.Emails, update.Calls, userID); err != nil {
 			return err
 		}
 	}
@@ -816,7 +1627,7 @@ func (a *Manager) writeTokenUpdateQueue() error {
 	log.Tag(tag).Debug("Writing token update queue for %d token(s)", len(tokenQueue))
 	for tokenID, update := range tokenQueue {
 		log.Tag(tag).Trace("Updating token %s with last access time %v", tokenID, update.LastAccess.Unix())
-		if _, err := tx.Exec(updateTokenLastAccessQuery, update.LastAccess.Unix(), update.LastOrigin.String(), tokenID); err != nil {
+		if _, err := tx.Exec(a.queries.updateTokenLastAccess, update.LastAccess.Unix(), update.LastOrigin.String(), tokenID); err != nil {
 			return err
 		}
 	}
@@ -825,7 +1636,21 @@ func (a *Manager) writeTokenUpdateQueue() error {
 
 // Authorize returns nil if the given user has access to the given topic using the desired
 // permission. The user param may be nil to signal an anonymous user.
+//
+// Access control entries are evaluated in the following order, and the first matching level wins:
+// 1. entries of the user itself, 2. entries of the groups the user is a member of, 3. entries of
+// Everyone, and 4. the default access. Within a level, more specific (longer) topic patterns are
+// preferred over more generic ones. If several group entries match equally specific, their
+// permissions are combined.
+//
+// If the user logged in with a scoped token (see User.Token and Token.Scopes), the token's scopes
+// must allow the access as well. This also applies to admins.
 func (a *Manager) Authorize(user *User, topic string, perm Permission) error {
+	if user != nil && user.Token != "" {
+		if err := a.authorizeTokenScopes(user, topic, perm); err != nil {
+			return err
+		}
+	}
 	if user != nil && user.Role == RoleAdmin {
 		return nil // Admin can do everything
 	}
@@ -834,10 +1659,10 @@ func (a *Manager) Authorize(user *User, topic string, perm Permission) error {
 		username = user.Name
 	}
 	// Select the read/write permissions for this user/topic combo.
-	// - The query may return two rows (one for everyone, and one for the user), but prioritizes the user.
+	// - The query may return rows for the user, its groups and for everyone, but prioritizes the user, then the groups.
 	// - Furthermore, the query prioritizes more specific permissions (longer!) over more generic ones, e.g. "test*" > "*"
 	// - It also prioritizes write permissions over read permissions
-	rows, err := a.db.Query(selectTopicPermsQuery, Everyone, username, topic)
+	rows, err := a.db.Query(a.queries.selectTopicPerms, username, Everyone, username, topic, username, topic)
 	if err != nil {
 		return err
 	}
@@ -846,12 +1671,45 @@ func (a *Manager) Authorize(user *User, topic string, perm Permission) error {
 		return a.resolvePerms(a.defaultAccess, perm)
 	}
 	var read, write bool
-	if err := rows.Scan(&read, &write); err != nil {
+	var priority, topicLength int
+	if err := rows.Scan(&read, &write, &priority, &topicLength); err != nil {
 		return err
-	} else if err := rows.Err(); err != nil {
+	}
+	allow := NewPermission(read, write)
+	for priority == topicPermsPriorityGroup && rows.Next() {
+		var nextRead, nextWrite bool
+		var nextPriority, nextTopicLength int
+		if err := rows.Scan(&nextRead, &nextWrite, &nextPriority, &nextTopicLength); err != nil {
+			return err
+		} else if nextPriority != priority || nextTopicLength != topicLength {
+			break
+		}
+		allow |= NewPermission(nextRead, nextWrite)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	return a.resolvePerms(allow, perm)
+}
+
+// authorizeTokenScopes checks the scopes of the token the user logged in with. If the token has no
+// scopes, it is not restricted. Otherwise, the permissions of all scopes matching the topic are combined.
+func (a *Manager) authorizeTokenScopes(user *User, topic string, perm Permission) error {
+	token, err := a.Token(user.ID, user.Token)
+	if errors.Is(err, ErrTokenNotFound) {
+		return ErrUnauthorized
+	} else if err != nil {
 		return err
+	} else if len(token.Scopes) == 0 {
+		return nil
+	}
+	allow := PermissionDenyAll
+	for _, scope := range token.Scopes {
+		if matchTopicPattern(scope.TopicPattern, topic) {
+			allow |= scope.Allow
+		}
 	}
-	return a.resolvePerms(NewPermission(read, write), perm)
+	return a.resolvePerms(allow, perm)
 }
 
 func (a *Manager) resolvePerms(base, perm Permission) error {
@@ -863,38 +1721,161 @@ func (a *Manager) resolvePerms(base, perm Permission) error {
 	return ErrUnauthorized
 }
 
-// AddUser adds a user with the given username, password and role
+// AddUser adds a user with the given username, password and role. The password must satisfy the
+// password policy, see SetPasswordPolicy.
 func (a *Manager) AddUser(username, password string, role Role) error {
 	if !AllowedUsername(username) || !AllowedRole(role) {
 		return ErrInvalidArgument
 	}
-	hash, err := bcrypt.GenerateFromPassword([]byte(password), a.bcryptCost)
-	if err != nil {
+	if err := a.policy.Validate(username, password); err != nil {
 		return err
 	}
-	userID := util.RandomStringPrefix(userIDPrefix, userIDLength)
-	syncTopic, now := util.RandomStringPrefix(syncTopicPrefix, syncTopicLength), time.Now().Unix()
-	if _, err = a.db.Exec(insertUserQuery, userID, username, hash, role, syncTopic, now); err != nil {
-		if sqliteErr, ok := err.(sqlite3.Error); ok && sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique {
-			return ErrUserExists
-		}
-		return err
+	return a.addUser(username, password, role)
+}
+
+// AddProvisionedUser adds a user that is authenticated by an external identity provider (LDAP, OIDC).
+// The user gets a long random password that is not subject to the password policy.
+func (a *Manager) AddProvisionedUser(username string, role Role) error {
+	if !AllowedUsername(username) || !AllowedRole(role) {
+		return ErrInvalidArgument
 	}
-	return nil
+	return a.addUser(username, util.RandomString(provisionedPasswordLength), role)
 }
 
-// RemoveUser deletes the user with the given username. The function returns nil on success, even
-// if the user did not exist in the first place.
-func (a *Manager) RemoveUser(username string) error {
-	if !AllowedUsername(username) {
+// AddOIDCUser adds a provisioned user (see AddProvisionedUser) and links it to the given OpenID Connect
+// identity, i.e. the issuer and the immutable "sub" claim of the ID token. The user can then be found
+// via UserByOIDCSubject. If a user with the same name already exists, ErrUserExists is returned.
+func (a *Manager) AddOIDCUser(username string, role Role, issuer, subject string) error {
+	if !AllowedUsername(username) || !AllowedRole(role) || issuer == "" || subject == "" {
 		return ErrInvalidArgument
 	}
-	// Rows in user_access, user_token, etc. are deleted via foreign keys
-	if _, err := a.db.Exec(deleteUserQuery, username); err != nil {
+	tx, err := a.db.Begin()
+	if err != nil {
 		return err
 	}
-	return nil
-}
+	defer tx.Rollback()
+	userID, err := a.insertUser(tx, username, util.RandomString(provisionedPasswordLength), role)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec(a.queries.insertUserOIDC, userID, issuer, subject); err != nil {
+		return err
+	}
+	if err := a.audit(tx, AuditActionUserAdd, username, "role="+string(role)); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// UserByOIDCSubject returns the user linked to the given OpenID Connect issuer and subject (see AddOIDCUser),
+// or ErrUserNotFound if no user was provisioned for this identity.
+func (a *Manager) UserByOIDCSubject(issuer, subject string) (*User, error) {
+	var userID string
+	if err := a.db.QueryRow(a.queries.selectUserIDByOIDCSubject, issuer, subject).Scan(&userID); errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrUserNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	return a.UserByID(userID)
+}
+
+// IsOIDCUser returns true if the given user was created via OpenID Connect (see AddOIDCUser), i.e. it has no usable password
+func (a *Manager) IsOIDCUser(user *User) (bool, error) {
+	var count int64
+	if err := a.db.QueryRow(a.queries.selectUserOIDCCount, user.ID).Scan(&count); err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// AddLDAPUser adds a provisioned user (see AddProvisionedUser) and marks it as created from the LDAP directory,
+// so that it can be told apart from a local user with the same name (see IsLDAPUser). If a user with the same
+// name already exists, ErrUserExists is returned.
+func (a *Manager) AddLDAPUser(username string, role Role) error {
+	if !AllowedUsername(username) || !AllowedRole(role) {
+		return ErrInvalidArgument
+	}
+	tx, err := a.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	userID, err := a.insertUser(tx, username, util.RandomString(provisionedPasswordLength), role)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec(a.queries.insertUserLDAP, userID); err != nil {
+		return err
+	}
+	if err := a.audit(tx, AuditActionUserAdd, username, "role="+string(role)); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// IsLDAPUser returns true if the given user was created from the LDAP directory (see AddLDAPUser)
+func (a *Manager) IsLDAPUser(user *User) (bool, error) {
+	var count int64
+	if err := a.db.QueryRow(a.queries.selectUserLDAPCount, user.ID).Scan(&count); err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+func (a *Manager) addUser(username, password string, role Role) error {
+	tx, err := a.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := a.insertUser(tx, username, password, role); err != nil {
+		return err
+	}
+	if err := a.audit(tx, AuditActionUserAdd, username, "role="+string(role)); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (a *Manager) insertUser(tx *sql.Tx, username, password string, role Role) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), a.bcryptCost)
+	if err != nil {
+		return "", err
+	}
+	userID := util.RandomStringPrefix(userIDPrefix, userIDLength)
+	syncTopic, now := util.RandomStringPrefix(syncTopicPrefix, syncTopicLength), time.Now().Unix()
+	if _, err = tx.Exec(a.queries.insertUser, userID, username, hash, role, syncTopic, now); err != nil {
+		if isUniqueConstraintError(err) {
+			return "", ErrUserExists
+		}
+		return "", err
+	}
+	return userID, nil
+}
+
+// RemoveUser deletes the user with the given username. The function returns nil on success, even
+// if the user did not exist in the first place.
+func (a *Manager) RemoveUser(username string) error {
+	if !AllowedUsername(username) {
+		return ErrInvalidArgument
+	}
+	tx, err := a.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err := a.removeReservedTopicsData(tx, a.queries.selectUserReservedTopics, username); err != nil {
+		return err
+	}
+	// Rows in user_access, user_token, etc. are deleted via foreign keys
+	if _, err := tx.Exec(a.queries.deleteUser, username); err != nil {
+		return err
+	}
+	if err := a.audit(tx, AuditActionUserRemove, username, ""); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
 
 // MarkUserRemoved sets the deleted flag on the user, and deletes all access tokens. This prevents
 // successful auth via Authenticate. A background process will delete the user at a later date.
@@ -907,13 +1888,19 @@ func (a *Manager) MarkUserRemoved(user *User) error {
 		return err
 	}
 	defer tx.Rollback()
-	if _, err := tx.Exec(deleteUserAccessQuery, user.Name, user.Name); err != nil {
+	if err := a.removeReservedTopicsData(tx, a.queries.selectUserReservedTopics, user.Name); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(a.queries.deleteUserAccess, user.Name, user.Name); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(a.queries.deleteAllToken, user.ID); err != nil {
 		return err
 	}
-	if _, err := tx.Exec(deleteAllTokenQuery, user.ID); err != nil {
+	if _, err := tx.Exec(a.queries.updateUserDeleted, time.Now().Add(userHardDeleteAfterDuration).Unix(), user.ID); err != nil {
 		return err
 	}
-	if _, err := tx.Exec(updateUserDeletedQuery, time.Now().Add(userHardDeleteAfterDuration).Unix(), user.ID); err != nil {
+	if err := a.audit(tx, AuditActionUserRemove, user.Name, ""); err != nil {
 		return err
 	}
 	return tx.Commit()
@@ -921,7 +1908,7 @@ func (a *Manager) MarkUserRemoved(user *User) error {
 
 // Users returns a list of users. It always also returns the Everyone user ("*").
 func (a *Manager) Users() ([]*User, error) {
-	rows, err := a.db.Query(selectUsernamesQuery)
+	rows, err := a.db.Query(a.queries.selectUsernames)
 	if err != nil {
 		return nil, err
 	}
@@ -950,7 +1937,7 @@ func (a *Manager) Users() ([]*User, error) {
 
 // UsersCount returns the number of users in the databsae
 func (a *Manager) UsersCount() (int64, error) {
-	rows, err := a.db.Query(selectUserCountQuery)
+	rows, err := a.db.Query(a.queries.selectUserCount)
 	if err != nil {
 		return 0, err
 	}
@@ -968,7 +1955,7 @@ func (a *Manager) UsersCount() (int64, error) {
 // User returns the user with the given username if it exists, or ErrUserNotFound otherwise.
 // You may also pass Everyone to retrieve the anonymous user and its Grant list.
 func (a *Manager) User(username string) (*User, error) {
-	rows, err := a.db.Query(selectUserByNameQuery, username)
+	rows, err := a.db.Query(a.queries.selectUserByName, username)
 	if err != nil {
 		return nil, err
 	}
@@ -977,7 +1964,7 @@ func (a *Manager) User(username string) (*User, error) {
 
 // UserByID returns the user with the given ID if it exists, or ErrUserNotFound otherwise
 func (a *Manager) UserByID(id string) (*User, error) {
-	rows, err := a.db.Query(selectUserByIDQuery, id)
+	rows, err := a.db.Query(a.queries.selectUserByID, id)
 	if err != nil {
 		return nil, err
 	}
@@ -986,7 +1973,7 @@ func (a *Manager) UserByID(id string) (*User, error) {
 
 // UserByStripeCustomer returns the user with the given Stripe customer ID if it exists, or ErrUserNotFound otherwise.
 func (a *Manager) UserByStripeCustomer(stripeCustomerID string) (*User, error) {
-	rows, err := a.db.Query(selectUserByStripeCustomerIDQuery, stripeCustomerID)
+	rows, err := a.db.Query(a.queries.selectUserByStripeCustomerID, stripeCustomerID)
 	if err != nil {
 		return nil, err
 	}
@@ -994,7 +1981,7 @@ func (a *Manager) UserByStripeCustomer(stripeCustomerID string) (*User, error) {
 }
 
 func (a *Manager) userByToken(token string) (*User, error) {
-	rows, err := a.db.Query(selectUserByTokenQuery, token, time.Now().Unix())
+	rows, err := a.db.Query(a.queries.selectUserByToken, token, time.Now().Unix())
 	if err != nil {
 		return nil, err
 	}
@@ -1064,7 +2051,7 @@ func (a *Manager) readUser(rows *sql.Rows) (*User, error) {
 
 // AllGrants returns all user-specific access control entries, mapped to their respective user IDs
 func (a *Manager) AllGrants() (map[string][]Grant, error) {
-	rows, err := a.db.Query(selectUserAllAccessQuery)
+	rows, err := a.db.Query(a.queries.selectUserAllAccess)
 	if err != nil {
 		return nil, err
 	}
@@ -1091,7 +2078,7 @@ func (a *Manager) AllGrants() (map[string][]Grant, error) {
 
 // Grants returns all user-specific access control entries
 func (a *Manager) Grants(username string) ([]Grant, error) {
-	rows, err := a.db.Query(selectUserAccessQuery, username)
+	rows, err := a.db.Query(a.queries.selectUserAccess, username)
 	if err != nil {
 		return nil, err
 	}
@@ -1115,7 +2102,7 @@ func (a *Manager) Grants(username string) ([]Grant, error) {
 
 // Reservations returns all user-owned topics, and the associated everyone-access
 func (a *Manager) Reservations(username string) ([]Reservation, error) {
-	rows, err := a.db.Query(selectUserReservationsQuery, Everyone, username)
+	rows, err := a.db.Query(a.queries.selectUserReservations, Everyone, username)
 	if err != nil {
 		return nil, err
 	}
@@ -1141,7 +2128,7 @@ func (a *Manager) Reservations(username string) ([]Reservation, error) {
 
 // HasReservation returns true if the given topic access is owned by the user
 func (a *Manager) HasReservation(username, topic string) (bool, error) {
-	rows, err := a.db.Query(selectUserHasReservationQuery, username, escapeUnderscore(topic))
+	rows, err := a.db.Query(a.queries.selectUserHasReservation, username, escapeUnderscore(topic))
 	if err != nil {
 		return false, err
 	}
@@ -1158,7 +2145,7 @@ func (a *Manager) HasReservation(username, topic string) (bool, error) {
 
 // ReservationsCount returns the number of reservations owned by this user
 func (a *Manager) ReservationsCount(username string) (int64, error) {
-	rows, err := a.db.Query(selectUserReservationsCountQuery, username)
+	rows, err := a.db.Query(a.queries.selectUserReservationsCount, username)
 	if err != nil {
 		return 0, err
 	}
@@ -1176,7 +2163,7 @@ func (a *Manager) ReservationsCount(username string) (int64, error) {
 // ReservationOwner returns user ID of the user that owns this topic, or an
 // empty string if it's not owned by anyone
 func (a *Manager) ReservationOwner(topic string) (string, error) {
-	rows, err := a.db.Query(selectUserReservationsOwnerQuery, escapeUnderscore(topic))
+	rows, err := a.db.Query(a.queries.selectUserReservationsOwner, escapeUnderscore(topic))
 	if err != nil {
 		return "", err
 	}
@@ -1191,16 +2178,28 @@ func (a *Manager) ReservationOwner(topic string) (string, error) {
 	return ownerUserID, nil
 }
 
-// ChangePassword changes a user's password
+// ChangePassword changes a user's password. The password must satisfy the password policy,
+// see SetPasswordPolicy.
 func (a *Manager) ChangePassword(username, password string) error {
+	if err := a.policy.Validate(username, password); err != nil {
+		return err
+	}
 	hash, err := bcrypt.GenerateFromPassword([]byte(password), a.bcryptCost)
 	if err != nil {
 		return err
 	}
-	if _, err := a.db.Exec(updateUserPassQuery, hash, username); err != nil {
+	tx, err := a.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(a.queries.updateUserPass, hash, username); err != nil {
+		return err
+	}
+	if err := a.audit(tx, AuditActionUserChangePassword, username, ""); err != nil {
 		return err
 	}
-	return nil
+	return tx.Commit()
 }
 
 // ChangeRole changes a user's role. When a role is changed from RoleUser to RoleAdmin,
@@ -1209,15 +2208,23 @@ func (a *Manager) ChangeRole(username string, role Role) error {
 	if !AllowedUsername(username) || !AllowedRole(role) {
 		return ErrInvalidArgument
 	}
-	if _, err := a.db.Exec(updateUserRoleQuery, string(role), username); err != nil {
+	tx, err := a.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(a.queries.updateUserRole, string(role), username); err != nil {
 		return err
 	}
 	if role == RoleAdmin {
-		if _, err := a.db.Exec(deleteUserAccessQuery, username, username); err != nil {
+		if _, err := tx.Exec(a.queries.deleteUserAccess, username, username); err != nil {
 			return err
 		}
 	}
-	return nil
+	if err := a.audit(tx, AuditActionUserChangeRole, username, "role="+string(role)); err != nil {
+		return err
+	}
+	return tx.Commit()
 }
 
 // ChangeTier changes a user's tier using the tier code. This function does not delete reservations, messages,
@@ -1232,10 +2239,18 @@ func (a *Manager) ChangeTier(username, tier string) error {
 	} else if err := a.checkReservationsLimit(username, t.ReservationLimit); err != nil {
 		return err
 	}
-	if _, err := a.db.Exec(updateUserTierQuery, tier, username); err != nil {
+	tx, err := a.db.Begin()
+	if err != nil {
 		return err
 	}
-	return nil
+	defer tx.Rollback()
+	if _, err := tx.Exec(a.queries.updateUserTier, tier, username); err != nil {
+		return err
+	}
+	if err := a.audit(tx, AuditActionUserChangeTier, username, "tier="+tier); err != nil {
+		return err
+	}
+	return tx.Commit()
 }
 
 // ResetTier removes the tier from the given user
@@ -1245,8 +2260,18 @@ func (a *Manager) ResetTier(username string) error {
 	} else if err := a.checkReservationsLimit(username, 0); err != nil {
 		return err
 	}
-	_, err := a.db.Exec(deleteUserTierQuery, username)
-	return err
+	tx, err := a.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(a.queries.deleteUserTier, username); err != nil {
+		return err
+	}
+	if err := a.audit(tx, AuditActionUserChangeTier, username, "tier="); err != nil {
+		return err
+	}
+	return tx.Commit()
 }
 
 func (a *Manager) checkReservationsLimit(username string, reservationsLimit int64) error {
@@ -1271,7 +2296,7 @@ func (a *Manager) AllowReservation(username string, topic string) error {
 	if (!AllowedUsername(username) && username != Everyone) || !AllowedTopic(topic) {
 		return ErrInvalidArgument
 	}
-	rows, err := a.db.Query(selectOtherAccessCountQuery, escapeUnderscore(topic), escapeUnderscore(topic), username)
+	rows, err := a.db.Query(a.queries.selectOtherAccessCount, escapeUnderscore(topic), escapeUnderscore(topic), username)
 	if err != nil {
 		return err
 	}
@@ -1298,177 +2323,1044 @@ func (a *Manager) AllowAccess(username string, topicPattern string, permission P
 	} else if !AllowedTopicPattern(topicPattern) {
 		return ErrInvalidArgument
 	}
-	owner := ""
-	if _, err := a.db.Exec(upsertUserAccessQuery, username, toSQLWildcard(topicPattern), permission.IsRead(), permission.IsWrite(), owner, owner); err != nil {
-		return err
-	}
-	return nil
-}
-
-// ResetAccess removes an access control list entry for a specific username/topic, or (if topic is
-// empty) for an entire user. The parameter topicPattern may include wildcards (*).
-func (a *Manager) ResetAccess(username string, topicPattern string) error {
-	if !AllowedUsername(username) && username != Everyone && username != "" {
-		return ErrInvalidArgument
-	} else if !AllowedTopicPattern(topicPattern) && topicPattern != "" {
-		return ErrInvalidArgument
-	}
-	if username == "" && topicPattern == "" {
-		_, err := a.db.Exec(deleteAllAccessQuery, username)
-		return err
-	} else if topicPattern == "" {
-		_, err := a.db.Exec(deleteUserAccessQuery, username, username)
-		return err
-	}
-	_, err := a.db.Exec(deleteTopicAccessQuery, username, username, toSQLWildcard(topicPattern))
-	return err
-}
-
-// AddReservation creates two access control entries for the given topic: one with full read/write access for the
-// given user, and one for Everyone with the permission passed as everyone. The user also owns the entries, and
-// can modify or delete them.
-func (a *Manager) AddReservation(username string, topic string, everyone Permission) error {
-	if !AllowedUsername(username) || username == Everyone || !AllowedTopic(topic) {
-		return ErrInvalidArgument
-	}
 	tx, err := a.db.Begin()
 	if err != nil {
 		return err
 	}
 	defer tx.Rollback()
-	if _, err := tx.Exec(upsertUserAccessQuery, username, escapeUnderscore(topic), true, true, username, username); err != nil {
+	owner := ""
+	if _, err := tx.Exec(a.queries.upsertUserAccess, username, toSQLWildcard(topicPattern), permission.IsRead(), permission.IsWrite(), owner, owner); err != nil {
 		return err
 	}
-	if _, err := tx.Exec(upsertUserAccessQuery, Everyone, escapeUnderscore(topic), everyone.IsRead(), everyone.IsWrite(), username, username); err != nil {
+	if err := a.audit(tx, AuditActionAccessAllow, username, fmt.Sprintf("topic=%s permission=%s", topicPattern, permission)); err != nil {
 		return err
 	}
 	return tx.Commit()
 }
 
-// RemoveReservations deletes the access control entries associated with the given username/topic, as
-// well as all entries with Everyone/topic. This is the counterpart for AddReservation.
-func (a *Manager) RemoveReservations(username string, topics ...string) error {
-	if !AllowedUsername(username) || username == Everyone || len(topics) == 0 {
+// ResetAccess removes an access control list entry for a specific username/topic, or (if topic is
+// empty) for an entire user. The parameter topicPattern may include wildcards (*).
+func (a *Manager) ResetAccess(username string, topicPattern string) error {
+	if !AllowedUsername(username) && username != Everyone && username != "" {
+		return ErrInvalidArgument
+	} else if !AllowedTopicPattern(topicPattern) && topicPattern != "" {
 		return ErrInvalidArgument
-	}
-	for _, topic := range topics {
-		if !AllowedTopic(topic) {
-			return ErrInvalidArgument
-		}
 	}
 	tx, err := a.db.Begin()
 	if err != nil {
 		return err
 	}
 	defer tx.Rollback()
-	for _, topic := range topics {
-		if _, err := tx.Exec(deleteTopicAccessQuery, username, username, escapeUnderscore(topic)); err != nil {
+	var details string
+	if username == "" && topicPattern == "" {
+		if err := a.removeReservedTopicsData(tx, a.queries.selectReservedTopics); err != nil {
 			return err
 		}
-		if _, err := tx.Exec(deleteTopicAccessQuery, Everyone, Everyone, escapeUnderscore(topic)); err != nil {
+		_, err = tx.Exec(a.queries.deleteAllAccess)
+		details = "all users"
+	} else if topicPattern == "" {
+		if err := a.removeReservedTopicsData(tx, a.queries.selectUserReservedTopics, username); err != nil {
 			return err
 		}
+		_, err = tx.Exec(a.queries.deleteUserAccess, username, username)
+	} else {
+		if err := a.removeReservedTopicData(tx, username, toSQLWildcard(topicPattern)); err != nil {
+			return err
+		}
+		_, err = tx.Exec(a.queries.deleteTopicAccess, username, username, toSQLWildcard(topicPattern))
+		details = "topic=" + topicPattern
 	}
-	return tx.Commit()
-}
-
-// DefaultAccess returns the default read/write access if no access control entry matches
-func (a *Manager) DefaultAccess() Permission {
-	return a.defaultAccess
-}
-
-// AddTier creates a new tier in the database
-func (a *Manager) AddTier(tier *Tier) error {
-	if tier.ID == "" {
-		tier.ID = util.RandomStringPrefix(tierIDPrefix, tierIDLength)
-	}
-	if _, err := a.db.Exec(insertTierQuery, tier.ID, tier.Code, tier.Name, tier.MessageLimit, int64(tier.MessageExpiryDuration.Seconds()), tier.EmailLimit, tier.CallLimit, tier.ReservationLimit, tier.AttachmentFileSizeLimit, tier.AttachmentTotalSizeLimit, int64(tier.AttachmentExpiryDuration.Seconds()), tier.AttachmentBandwidthLimit, nullString(tier.StripeMonthlyPriceID), nullString(tier.StripeYearlyPriceID)); err != nil {
-		return err
-	}
-	return nil
-}
-
-// UpdateTier updates a tier's properties in the database
-func (a *Manager) UpdateTier(tier *Tier) error {
-	if _, err := a.db.Exec(updateTierQuery, tier.Name, tier.MessageLimit, int64(tier.MessageExpiryDuration.Seconds()), tier.EmailLimit, tier.CallLimit, tier.ReservationLimit, tier.AttachmentFileSizeLimit, tier.AttachmentTotalSizeLimit, int64(tier.AttachmentExpiryDuration.Seconds()), tier.AttachmentBandwidthLimit, nullString(tier.StripeMonthlyPriceID), nullString(tier.StripeYearlyPriceID), tier.Code); err != nil {
-		return err
-	}
-	return nil
-}
-
-// RemoveTier deletes the tier with the given code
-func (a *Manager) RemoveTier(code string) error {
-	if !AllowedTier(code) {
-		return ErrInvalidArgument
-	}
-	// This fails if any user has this tier
-	if _, err := a.db.Exec(deleteTierQuery, code); err != nil {
+	if err != nil {
 		return err
 	}
-	return nil
-}
-
-// ChangeBilling updates a user's billing fields, namely the Stripe customer ID, and subscription information
-func (a *Manager) ChangeBilling(username string, billing *Billing) error {
-	if _, err := a.db.Exec(updateBillingQuery, nullString(billing.StripeCustomerID), nullString(billing.StripeSubscriptionID), nullString(string(billing.StripeSubscriptionStatus)), nullString(string(billing.StripeSubscriptionInterval)), nullInt64(billing.StripeSubscriptionPaidUntil.Unix()), nullInt64(billing.StripeSubscriptionCancelAt.Unix()), username); err != nil {
+	if err := a.audit(tx, AuditActionAccessReset, username, details); err != nil {
 		return err
 	}
-	return nil
+	return tx.Commit()
 }
 
-// Tiers returns a list of all Tier structs
-func (a *Manager) Tiers() ([]*Tier, error) {
-	rows, err := a.db.Query(selectTiersQuery)
+// Groups returns all groups, including their members and access control entries, sorted by name
+func (a *Manager) Groups() ([]*Group, error) {
+	rows, err := a.db.Query(a.queries.selectGroups)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
-	tiers := make([]*Tier, 0)
-	for {
-		tier, err := a.readTier(rows)
-		if err == ErrTierNotFound {
-			break
-		} else if err != nil {
+	groups := make([]*Group, 0)
+	for rows.Next() {
+		var id, name string
+		if err := rows.Scan(&id, &name); err != nil {
 			return nil, err
 		}
-		tiers = append(tiers, tier)
+		groups = append(groups, &Group{ID: id, Name: name})
 	}
-	return tiers, nil
-}
-
-// Tier returns a Tier based on the code, or ErrTierNotFound if it does not exist
-func (a *Manager) Tier(code string) (*Tier, error) {
-	rows, err := a.db.Query(selectTierByCodeQuery, code)
-	if err != nil {
+	if err := rows.Err(); err != nil {
 		return nil, err
 	}
-	defer rows.Close()
-	return a.readTier(rows)
+	rows.Close()
+	for _, group := range groups {
+		if err := a.readGroupDetails(group); err != nil {
+			return nil, err
+		}
+	}
+	return groups, nil
 }
 
-// TierByStripePrice returns a Tier based on the Stripe price ID, or ErrTierNotFound if it does not exist
-func (a *Manager) TierByStripePrice(priceID string) (*Tier, error) {
-	rows, err := a.db.Query(selectTierByPriceIDQuery, priceID, priceID)
+// Group returns the group with the given name, including its members and access control entries,
+// or ErrGroupNotFound if it does not exist
+func (a *Manager) Group(name string) (*Group, error) {
+	rows, err := a.db.Query(a.queries.selectGroupByName, name)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
-	return a.readTier(rows)
-}
-
-func (a *Manager) readTier(rows *sql.Rows) (*Tier, error) {
-	var id, code, name string
-	var stripeMonthlyPriceID, stripeYearlyPriceID sql.NullString
-	var messagesLimit, messagesExpiryDuration, emailsLimit, callsLimit, reservationsLimit, attachmentFileSizeLimit, attachmentTotalSizeLimit, attachmentExpiryDuration, attachmentBandwidthLimit sql.NullInt64
 	if !rows.Next() {
-		return nil, ErrTierNotFound
+		return nil, ErrGroupNotFound
 	}
-	if err := rows.Scan(&id, &code, &name, &messagesLimit, &messagesExpiryDuration, &emailsLimit, &callsLimit, &reservationsLimit, &attachmentFileSizeLimit, &attachmentTotalSizeLimit, &attachmentExpiryDuration, &attachmentBandwidthLimit, &stripeMonthlyPriceID, &stripeYearlyPriceID); err != nil {
+	group := &Group{}
+	if err := rows.Scan(&group.ID, &group.Name); err != nil {
 		return nil, err
 	} else if err := rows.Err(); err != nil {
 		return nil, err
 	}
-	// When changed, note readUser() as well
+	rows.Close()
+	if err := a.readGroupDetails(group); err != nil {
+		return nil, err
+	}
+	return group, nil
+}
+
+func (a *Manager) readGroupDetails(group *Group) error {
+	rows, err := a.db.Query(a.queries.selectGroupMembers, group.ID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	group.Members = make([]string, 0)
+	for rows.Next() {
+		var username string
+		if err := rows.Scan(&username); err != nil {
+			return err
+		}
+		group.Members = append(group.Members, username)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	rows.Close()
+	rows, err = a.db.Query(a.queries.selectGroupAccess, group.ID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	group.Grants = make([]Grant, 0)
+	for rows.Next() {
+		var topic string
+		var read, write bool
+		if err := rows.Scan(&topic, &read, &write); err != nil {
+			return err
+		}
+		group.Grants = append(group.Grants, Grant{
+			TopicPattern: fromSQLWildcard(topic),
+			Allow:        NewPermission(read, write),
+		})
+	}
+	return rows.Err()
+}
+
+// UserGroups returns the names of all groups the given user is a member of, sorted by name
+func (a *Manager) UserGroups(username string) ([]string, error) {
+	rows, err := a.db.Query(a.queries.selectUserGroups, username)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	groups := make([]string, 0)
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		groups = append(groups, name)
+	}
+	return groups, rows.Err()
+}
+
+// AddGroup creates a new group with the given name, or returns ErrGroupExists if it already exists
+func (a *Manager) AddGroup(name string) error {
+	if !AllowedGroup(name) {
+		return ErrInvalidArgument
+	}
+	tx, err := a.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	groupID := util.RandomStringPrefix(groupIDPrefix, groupIDLength)
+	if _, err := tx.Exec(a.queries.insertGroup, groupID, name, time.Now().Unix()); err != nil {
+		if isUniqueConstraintError(err) {
+			return ErrGroupExists
+		}
+		return err
+	}
+	if err := a.audit(tx, AuditActionGroupAdd, "", "group="+name); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// RemoveGroup deletes the group with the given name, including its memberships and access control entries.
+// The function returns nil on success, even if the group did not exist in the first place.
+func (a *Manager) RemoveGroup(name string) error {
+	if !AllowedGroup(name) {
+		return ErrInvalidArgument
+	}
+	tx, err := a.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	// Rows in user_group_member and user_group_access are deleted via foreign keys
+	if _, err := tx.Exec(a.queries.deleteGroup, name); err != nil {
+		return err
+	}
+	if err := a.audit(tx, AuditActionGroupRemove, "", "group="+name); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// AddGroupMember adds the given user to the group. Adding a user that is already a member is not an error.
+func (a *Manager) AddGroupMember(group, username string) error {
+	if !AllowedGroup(group) || !AllowedUsername(username) {
+		return ErrInvalidArgument
+	}
+	if _, err := a.Group(group); err != nil {
+		return err
+	} else if _, err := a.User(username); err != nil {
+		return err
+	}
+	tx, err := a.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(a.queries.insertGroupMember, group, username); err != nil {
+		return err
+	}
+	if err := a.audit(tx, AuditActionGroupMemberAdd, username, "group="+group); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// RemoveGroupMember removes the given user from the group
+func (a *Manager) RemoveGroupMember(group, username string) error {
+	if !AllowedGroup(group) || !AllowedUsername(username) {
+		return ErrInvalidArgument
+	}
+	tx, err := a.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(a.queries.deleteGroupMember, group, username); err != nil {
+		return err
+	}
+	if err := a.audit(tx, AuditActionGroupMemberRemove, username, "group="+group); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// AllowGroupAccess adds or updates an entry in the access control list of a group. The entry applies to
+// all members of the group, see Authorize for how it is combined with other entries. The parameter
+// topicPattern may include wildcards (*).
+func (a *Manager) AllowGroupAccess(group string, topicPattern string, permission Permission) error {
+	if !AllowedGroup(group) || !AllowedTopicPattern(topicPattern) {
+		return ErrInvalidArgument
+	}
+	if _, err := a.Group(group); err != nil {
+		return err
+	}
+	tx, err := a.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(a.queries.upsertGroupAccess, group, toSQLWildcard(topicPattern), permission.IsRead(), permission.IsWrite()); err != nil {
+		return err
+	}
+	if err := a.audit(tx, AuditActionGroupAccessAllow, "", fmt.Sprintf("group=%s topic=%s permission=%s", group, topicPattern, permission)); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// ResetGroupAccess removes an access control list entry for a specific group/topic, or (if topic is
+// empty) all entries of the group. The parameter topicPattern may include wildcards (*).
+func (a *Manager) ResetGroupAccess(group string, topicPattern string) error {
+	if !AllowedGroup(group) {
+		return ErrInvalidArgument
+	} else if !AllowedTopicPattern(topicPattern) && topicPattern != "" {
+		return ErrInvalidArgument
+	}
+	tx, err := a.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	details := "group=" + group
+	if topicPattern == "" {
+		_, err = tx.Exec(a.queries.deleteGroupAccess, group)
+	} else {
+		_, err = tx.Exec(a.queries.deleteGroupTopicAccess, group, toSQLWildcard(topicPattern))
+		details += " topic=" + topicPattern
+	}
+	if err != nil {
+		return err
+	}
+	if err := a.audit(tx, AuditActionGroupAccessReset, "", details); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// TwoFactorEnabled returns true if the user has enabled two-factor authentication
+func (a *Manager) TwoFactorEnabled(userID string) (bool, error) {
+	_, enabled, _, err := a.twoFactor(userID)
+	if errors.Is(err, ErrTwoFactorNotFound) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	return enabled, nil
+}
+
+// BeginTwoFactor starts the two-factor enrollment of a user by generating a new TOTP secret. The secret is
+// not used for authentication until it is confirmed with a valid code via EnableTwoFactor. If the user has
+// already enabled two-factor authentication, ErrTwoFactorEnabled is returned.
+func (a *Manager) BeginTwoFactor(userID string) (string, error) {
+	if enabled, err := a.TwoFactorEnabled(userID); err != nil {
+		return "", err
+	} else if enabled {
+		return "", ErrTwoFactorEnabled
+	}
+	secret, err := newTOTPSecret()
+	if err != nil {
+		return "", err
+	}
+	if _, err := a.db.Exec(a.queries.upsertTwoFactor, userID, secret, false, 0); err != nil {
+		return "", err
+	}
+	return secret, nil
+}
+
+// EnableTwoFactor completes the two-factor enrollment started with BeginTwoFactor, if the given code is valid.
+// It returns a new set of single-use recovery codes, which can be used instead of a TOTP code.
+func (a *Manager) EnableTwoFactor(userID, code string) ([]string, error) {
+	secret, enabled, lastStep, err := a.twoFactor(userID)
+	if err != nil {
+		return nil, err
+	} else if enabled {
+		return nil, ErrTwoFactorEnabled
+	}
+	step, ok := validateTOTP(secret, code, time.Now(), lastStep)
+	if !ok {
+		return nil, ErrTwoFactorCodeInvalid
+	}
+	recoveryCodes := make([]string, recoveryCodeCount)
+	tx, err := a.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(a.queries.upsertTwoFactor, userID, secret, true, step); err != nil {
+		return nil, err
+	}
+	if _, err := tx.Exec(a.queries.deleteRecoveryCodes, userID); err != nil {
+		return nil, err
+	}
+	for i := range recoveryCodes {
+		recoveryCodes[i] = newRecoveryCode()
+		if _, err := tx.Exec(a.queries.insertRecoveryCode, userID, hashRecoveryCode(recoveryCodes[i])); err != nil {
+			return nil, err
+		}
+	}
+	if err := a.auditUserID(tx, AuditActionTwoFactorEnable, userID, ""); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return recoveryCodes, nil
+}
+
+// VerifyTwoFactor checks the given TOTP code or recovery code of a user with enabled two-factor authentication.
+// TOTP codes cannot be used twice, and recovery codes are deleted once they are used. If the code is invalid,
+// ErrTwoFactorCodeInvalid is returned.
+func (a *Manager) VerifyTwoFactor(userID, code string) error {
+	secret, enabled, lastStep, err := a.twoFactor(userID)
+	if err != nil {
+		return err
+	} else if !enabled {
+		return ErrTwoFactorNotFound
+	}
+	if step, ok := validateTOTP(secret, code, time.Now(), lastStep); ok {
+		res, err := a.db.Exec(a.queries.updateTwoFactorLastStep, step, userID, step)
+		if err != nil {
+			return err
+		} else if rows, _ := res.RowsAffected(); rows == 0 {
+			return ErrTwoFactorCodeInvalid // Code was used concurrently
+		}
+		return nil
+	}
+	res, err := a.db.Exec(a.queries.deleteRecoveryCode, userID, hashRecoveryCode(code))
+	if err != nil {
+		return err
+	} else if rows, _ := res.RowsAffected(); rows == 0 {
+		return ErrTwoFactorCodeInvalid
+	}
+	return nil
+}
+
+// DisableTwoFactor turns off two-factor authentication for the given user, and deletes the TOTP
+// secret and recovery codes. The function returns nil, even if it was not enabled in the first place.
+func (a *Manager) DisableTwoFactor(userID string) error {
+	tx, err := a.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(a.queries.deleteRecoveryCodes, userID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(a.queries.deleteTwoFactor, userID); err != nil {
+		return err
+	}
+	if err := a.auditUserID(tx, AuditActionTwoFactorDisable, userID, ""); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (a *Manager) twoFactor(userID string) (secret string, enabled bool, lastStep int64, err error) {
+	rows, err := a.db.Query(a.queries.selectTwoFactor, userID)
+	if err != nil {
+		return "", false, 0, err
+	}
+	defer rows.Close()
+	if !rows.Next() {
+		return "", false, 0, ErrTwoFactorNotFound
+	}
+	if err := rows.Scan(&secret, &enabled, &lastStep); err != nil {
+		return "", false, 0, err
+	}
+	return secret, enabled, lastStep, rows.Err()
+}
+
+// AddAuditEvent appends an event to the audit log. If the event time is not set, the current time is used.
+// There are intentionally no methods to change or delete audit events.
+func (a *Manager) AddAuditEvent(event *AuditEvent) error {
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+	_, err := a.db.Exec(a.queries.insertAuditEvent, event.Time.Unix(), event.Actor, event.Origin, string(event.Action), event.Target, event.Details)
+	return err
+}
+
+// audit appends an event to the audit log as part of the given transaction, so that a change is never made
+// without being recorded. The actor and origin are taken from the Manager's actor, see WithActor.
+func (a *Manager) audit(tx *sql.Tx, action AuditAction, target, details string) error {
+	var actor, origin string
+	if a.actor != nil {
+		actor, origin = a.actor.Name, a.actor.Origin
+		if a.actor.Reason != "" {
+			details = strings.TrimSpace(details + " reason=" + a.actor.Reason)
+		}
+	}
+	_, err := tx.Exec(a.queries.insertAuditEvent, time.Now().Unix(), actor, origin, string(action), target, details)
+	return err
+}
+
+// auditUserID is like audit, but looks up the target username by user ID
+func (a *Manager) auditUserID(tx *sql.Tx, action AuditAction, userID, details string) error {
+	var username string
+	if err := tx.QueryRow(a.queries.selectUsernameByID, userID).Scan(&username); err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+	return a.audit(tx, action, username, details)
+}
+
+// AuditEvents returns the events in the audit log that match the given filter, newest first
+func (a *Manager) AuditEvents(filter *AuditFilter) ([]*AuditEvent, error) {
+	since, until := int64(0), int64(math.MaxInt64)
+	if !filter.Since.IsZero() {
+		since = filter.Since.Unix()
+	}
+	if !filter.Until.IsZero() {
+		until = filter.Until.Unix()
+	}
+	limit := filter.Limit
+	if limit <= 0 {
+		limit = math.MaxInt32
+	}
+	rows, err := a.db.Query(a.queries.selectAuditEvents, filter.User, string(filter.Action), since, until, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	events := make([]*AuditEvent, 0)
+	for rows.Next() {
+		var id, timestamp int64
+		var actor, origin, action, target, details string
+		if err := rows.Scan(&id, &timestamp, &actor, &origin, &action, &target, &details); err != nil {
+			return nil, err
+		}
+		events = append(events, &AuditEvent{
+			ID:      id,
+			Time:    time.Unix(timestamp, 0),
+			Actor:   actor,
+			Origin:  origin,
+			Action:  AuditAction(action),
+			Target:  target,
+			Details: details,
+		})
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return events, nil
+}
+
+// AddReservation creates two access control entries for the given topic: one with full read/write access for the
+// given user, and one for Everyone with the permission passed as everyone. The user also owns the entries, and
+// can modify or delete them.
+func (a *Manager) AddReservation(username string, topic string, everyone Permission) error {
+	if !AllowedUsername(username) || username == Everyone || !AllowedTopic(topic) {
+		return ErrInvalidArgument
+	}
+	tx, err := a.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err := a.removeStaleTopicData(tx, topic); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(a.queries.upsertUserAccess, username, escapeUnderscore(topic), true, true, username, username); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(a.queries.upsertUserAccess, Everyone, escapeUnderscore(topic), everyone.IsRead(), everyone.IsWrite(), username, username); err != nil {
+		return err
+	}
+	if err := a.audit(tx, AuditActionReservationAdd, username, fmt.Sprintf("topic=%s everyone=%s", topic, everyone)); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// RemoveReservations deletes the access control entries associated with the given username/topic, as
+// well as all entries with Everyone/topic. This is the counterpart for AddReservation.
+func (a *Manager) RemoveReservations(username string, topics ...string) error {
+	if !AllowedUsername(username) || username == Everyone || len(topics) == 0 {
+		return ErrInvalidArgument
+	}
+	for _, topic := range topics {
+		if !AllowedTopic(topic) {
+			return ErrInvalidArgument
+		}
+	}
+	tx, err := a.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, topic := range topics {
+		if _, err := tx.Exec(a.queries.deleteTopicAccess, username, username, escapeUnderscore(topic)); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(a.queries.deleteTopicAccess, Everyone, Everyone, escapeUnderscore(topic)); err != nil {
+			return err
+		}
+		if err := a.removeTopicData(tx, topic); err != nil {
+			return err
+		}
+	}
+	if err := a.audit(tx, AuditActionReservationRemove, username, "topic="+strings.Join(topics, ",")); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// removeReservedTopicsData removes the per-topic data (see removeTopicData) of all topics returned by the
+// given reservations query. It must be called before the reservations themselves are removed.
+func (a *Manager) removeReservedTopicsData(tx *sql.Tx, query string, args ...any) error {
+	rows, err := tx.Query(query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	topics := make([]string, 0)
+	for rows.Next() {
+		var topic string
+		if err := rows.Scan(&topic); err != nil {
+			return err
+		}
+		topics = append(topics, unescapeUnderscore(topic))
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	rows.Close()
+	for _, topic := range topics {
+		if err := a.removeTopicData(tx, topic); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// removeReservedTopicData removes the per-topic data of the given topic (in its escaped SQL form, as stored
+// in user_access), if it is reserved by the given user
+func (a *Manager) removeReservedTopicData(tx *sql.Tx, username, sqlTopic string) error {
+	rows, err := tx.Query(a.queries.selectUserHasReservation, username, sqlTopic)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	var count int64
+	if rows.Next() {
+		if err := rows.Scan(&count); err != nil {
+			return err
+		}
+	}
+	rows.Close()
+	if count == 0 {
+		return nil
+	}
+	return a.removeTopicData(tx, unescapeUnderscore(sqlTopic))
+}
+
+// removeStaleTopicData removes the webhooks and escalation policy that were left behind for a topic that is
+// not reserved by anyone, so that a new owner does not inherit them. Retention policies are kept, since they
+// can also be set by admins for topics that are not reserved.
+func (a *Manager) removeStaleTopicData(tx *sql.Tx, topic string) error {
+	rows, err := tx.Query(a.queries.selectUserReservationsOwner, escapeUnderscore(topic))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	owned := rows.Next()
+	rows.Close()
+	if owned {
+		return nil
+	}
+	if _, err := tx.Exec(a.queries.deleteTopicEscalation, topic); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(a.queries.deleteTopicWebhooks, topic); err != nil {
+		return err
+	}
+	return nil
+}
+
+// removeTopicData removes the data that belongs to a reservation: the topic's retention and escalation
+// policies and its webhooks. It also invalidates the topic's signed URLs.
+func (a *Manager) removeTopicData(tx *sql.Tx, topic string) error {
+	if _, err := tx.Exec(a.queries.deleteTopicRetention, topic); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(a.queries.deleteTopicEscalation, topic); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(a.queries.deleteTopicWebhooks, topic); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(a.queries.updateSignedURLGeneration, topic); err != nil {
+		return err
+	}
+	return nil
+}
+
+// SignedURLGeneration returns the current generation of signed publish URLs for the given topic. Signed URLs
+// carry the generation they were created with, and are only valid as long as it matches (see RevokeSignedURLs).
+func (a *Manager) SignedURLGeneration(topic string) (int64, error) {
+	var generation int64
+	if err := a.db.QueryRow(a.queries.selectSignedURLGeneration, topic).Scan(&generation); errors.Is(err, sql.ErrNoRows) {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+	return generation, nil
+}
+
+// RevokeSignedURLs invalidates all existing signed publish URLs for the given topic by incrementing the topic's
+// signed URL generation. This also happens when a reservation is removed (see RemoveReservations), so that URLs
+// do not become valid again if the topic is reserved again.
+func (a *Manager) RevokeSignedURLs(topic string) error {
+	if !AllowedTopic(topic) {
+		return ErrInvalidArgument
+	}
+	tx, err := a.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(a.queries.updateSignedURLGeneration, topic); err != nil {
+		return err
+	}
+	if err := a.audit(tx, AuditActionSignedURLRevoke, "", "topic="+topic); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// TopicRetentions returns all per-topic retention policies, sorted by topic
+func (a *Manager) TopicRetentions() ([]*TopicRetention, error) {
+	rows, err := a.db.Query(a.queries.selectTopicRetentions)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	retentions := make([]*TopicRetention, 0)
+	for {
+		retention, err := a.readTopicRetention(rows)
+		if err == ErrTopicRetentionNotFound {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		retentions = append(retentions, retention)
+	}
+	return retentions, nil
+}
+
+// TopicRetention returns the retention policy for the given topic, or ErrTopicRetentionNotFound if there is none
+func (a *Manager) TopicRetention(topic string) (*TopicRetention, error) {
+	rows, err := a.db.Query(a.queries.selectTopicRetention, topic)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return a.readTopicRetention(rows)
+}
+
+func (a *Manager) readTopicRetention(rows *sql.Rows) (*TopicRetention, error) {
+	var topic string
+	var maxAge, maxMessages int64
+	if !rows.Next() {
+		return nil, ErrTopicRetentionNotFound
+	}
+	if err := rows.Scan(&topic, &maxAge, &maxMessages); err != nil {
+		return nil, err
+	} else if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return &TopicRetention{
+		Topic:       topic,
+		MaxAge:      time.Duration(maxAge) * time.Second,
+		MaxMessages: maxMessages,
+	}, nil
+}
+
+// ChangeTopicRetention sets the retention policy for a topic. If neither a max age nor a max message
+// count is set, the retention policy is removed.
+func (a *Manager) ChangeTopicRetention(retention *TopicRetention) error {
+	if !AllowedTopic(retention.Topic) || retention.MaxAge < 0 || retention.MaxMessages < 0 {
+		return ErrInvalidArgument
+	} else if retention.MaxAge == 0 && retention.MaxMessages == 0 {
+		return a.ResetTopicRetention(retention.Topic)
+	}
+	tx, err := a.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(a.queries.upsertTopicRetention, retention.Topic, int64(retention.MaxAge.Seconds()), retention.MaxMessages); err != nil {
+		return err
+	}
+	details := fmt.Sprintf("topic=%s max_age=%s max_messages=%d", retention.Topic, retention.MaxAge, retention.MaxMessages)
+	if err := a.audit(tx, AuditActionTopicRetentionChange, "", details); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// ResetTopicRetention removes the retention policy for the given topic, if any
+func (a *Manager) ResetTopicRetention(topic string) error {
+	if !AllowedTopic(topic) {
+		return ErrInvalidArgument
+	}
+	tx, err := a.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(a.queries.deleteTopicRetention, topic); err != nil {
+		return err
+	}
+	if err := a.audit(tx, AuditActionTopicRetentionReset, "", "topic="+topic); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// TopicEscalations returns all per-topic escalation policies, sorted by topic
+func (a *Manager) TopicEscalations() ([]*TopicEscalation, error) {
+	rows, err := a.db.Query(a.queries.selectTopicEscalations)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	escalations := make([]*TopicEscalation, 0)
+	for {
+		escalation, err := a.readTopicEscalation(rows)
+		if err == ErrTopicEscalationNotFound {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		escalations = append(escalations, escalation)
+	}
+	return escalations, nil
+}
+
+// TopicEscalation returns the escalation policy for the given topic, or ErrTopicEscalationNotFound if there is none
+func (a *Manager) TopicEscalation(topic string) (*TopicEscalation, error) {
+	rows, err := a.db.Query(a.queries.selectTopicEscalation, topic)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return a.readTopicEscalation(rows)
+}
+
+func (a *Manager) readTopicEscalation(rows *sql.Rows) (*TopicEscalation, error) {
+	var topic, forwardTopic, email, phoneNumber string
+	var delay int64
+	if !rows.Next() {
+		return nil, ErrTopicEscalationNotFound
+	}
+	if err := rows.Scan(&topic, &delay, &forwardTopic, &email, &phoneNumber); err != nil {
+		return nil, err
+	} else if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return &TopicEscalation{
+		Topic:        topic,
+		Delay:        time.Duration(delay) * time.Second,
+		ForwardTopic: forwardTopic,
+		Email:        email,
+		PhoneNumber:  phoneNumber,
+	}, nil
+}
+
+// ChangeTopicEscalation sets the escalation policy for a topic. If none of the escalation steps are set,
+// the escalation policy is removed.
+func (a *Manager) ChangeTopicEscalation(escalation *TopicEscalation) error {
+	if !AllowedTopic(escalation.Topic) || escalation.Delay < 0 || (escalation.ForwardTopic != "" && !AllowedTopic(escalation.ForwardTopic)) {
+		return ErrInvalidArgument
+	} else if escalation.ForwardTopic == "" && escalation.Email == "" && escalation.PhoneNumber == "" {
+		return a.ResetTopicEscalation(escalation.Topic)
+	} else if escalation.Delay < time.Minute {
+		return ErrInvalidArgument
+	}
+	_, err := a.db.Exec(a.queries.upsertTopicEscalation, escalation.Topic, int64(escalation.Delay.Seconds()), escalation.ForwardTopic, escalation.Email, escalation.PhoneNumber)
+	return err
+}
+
+// ResetTopicEscalation removes the escalation policy for the given topic, if any
+func (a *Manager) ResetTopicEscalation(topic string) error {
+	if !AllowedTopic(topic) {
+		return ErrInvalidArgument
+	}
+	_, err := a.db.Exec(a.queries.deleteTopicEscalation, topic)
+	return err
+}
+
+// AddTopicWebhook registers a new outbound webhook for the given topic, and returns it. The webhook's
+// secret is generated randomly.
+func (a *Manager) AddTopicWebhook(topic, url string) (*TopicWebhook, error) {
+	if !AllowedTopic(topic) || url == "" {
+		return nil, ErrInvalidArgument
+	}
+	webhook := &TopicWebhook{
+		ID:      util.RandomStringPrefix(webhookIDPrefix, webhookIDLength),
+		Topic:   topic,
+		URL:     url,
+		Secret:  util.RandomString(webhookSecretLength),
+		Created: time.Unix(time.Now().Unix(), 0),
+	}
+	if _, err := a.db.Exec(a.queries.insertTopicWebhook, webhook.ID, webhook.Topic, webhook.URL, webhook.Secret, webhook.Created.Unix()); err != nil {
+		return nil, err
+	}
+	return webhook, nil
+}
+
+// TopicWebhooks returns all outbound webhooks of the given topic, sorted by creation time
+func (a *Manager) TopicWebhooks(topic string) ([]*TopicWebhook, error) {
+	rows, err := a.db.Query(a.queries.selectTopicWebhooks, topic)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	webhooks := make([]*TopicWebhook, 0)
+	for {
+		webhook, err := a.readTopicWebhook(rows)
+		if err == ErrTopicWebhookNotFound {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		webhooks = append(webhooks, webhook)
+	}
+	return webhooks, nil
+}
+
+// TopicWebhook returns the webhook with the given ID, or ErrTopicWebhookNotFound if it does not exist
+func (a *Manager) TopicWebhook(id string) (*TopicWebhook, error) {
+	rows, err := a.db.Query(a.queries.selectTopicWebhook, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return a.readTopicWebhook(rows)
+}
+
+func (a *Manager) readTopicWebhook(rows *sql.Rows) (*TopicWebhook, error) {
+	var id, topic, url, secret string
+	var created int64
+	if !rows.Next() {
+		return nil, ErrTopicWebhookNotFound
+	}
+	if err := rows.Scan(&id, &topic, &url, &secret, &created); err != nil {
+		return nil, err
+	} else if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return &TopicWebhook{
+		ID:      id,
+		Topic:   topic,
+		URL:     url,
+		Secret:  secret,
+		Created: time.Unix(created, 0),
+	}, nil
+}
+
+// RemoveTopicWebhook removes the webhook with the given ID from the topic. It returns ErrTopicWebhookNotFound
+// if the topic has no such webhook.
+func (a *Manager) RemoveTopicWebhook(topic, id string) error {
+	result, err := a.db.Exec(a.queries.deleteTopicWebhook, topic, id)
+	if err != nil {
+		return err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	} else if rows == 0 {
+		return ErrTopicWebhookNotFound
+	}
+	return nil
+}
+
+// DefaultAccess returns the default read/write access if no access control entry matches
+func (a *Manager) DefaultAccess() Permission {
+	return a.defaultAccess
+}
+
+// AddTier creates a new tier in the database
+func (a *Manager) AddTier(tier *Tier) error {
+	if tier.ID == "" {
+		tier.ID = util.RandomStringPrefix(tierIDPrefix, tierIDLength)
+	}
+	if _, err := a.db.Exec(a.queries.insertTier, tier.ID, tier.Code, tier.Name, tier.MessageLimit, int64(tier.MessageExpiryDuration.Seconds()), tier.EmailLimit, tier.CallLimit, tier.ReservationLimit, tier.AttachmentFileSizeLimit, tier.AttachmentTotalSizeLimit, int64(tier.AttachmentExpiryDuration.Seconds()), tier.AttachmentBandwidthLimit, nullString(tier.StripeMonthlyPriceID), nullString(tier.StripeYearlyPriceID)); err != nil {
+		return err
+	}
+	return nil
+}
+
+// UpdateTier updates a tier's properties in the database
+func (a *Manager) UpdateTier(tier *Tier) error {
+	if _, err := a.db.Exec(a.queries.updateTier, tier.Name, tier.MessageLimit, int64(tier.MessageExpiryDuration.Seconds()), tier.EmailLimit, tier.CallLimit, tier.ReservationLimit, tier.AttachmentFileSizeLimit, tier.AttachmentTotalSizeLimit, int64(tier.AttachmentExpiryDuration.Seconds()), tier.AttachmentBandwidthLimit, nullString(tier.StripeMonthlyPriceID), nullString(tier.StripeYearlyPriceID), tier.Code); err != nil {
+		return err
+	}
+	return nil
+}
+
+// RemoveTier deletes the tier with the given code
+func (a *Manager) RemoveTier(code string) error {
+	if !AllowedTier(code) {
+		return ErrInvalidArgument
+	}
+	// This fails if any user has this tier
+	if _, err := a.db.Exec(a.queries.deleteTier, code); err != nil {
+		return err
+	}
+	return nil
+}
+
+// ChangeBilling updates a user's billing fields, namely the Stripe customer ID, and subscription information
+func (a *Manager) ChangeBilling(username string, billing *Billing) error {
+	if _, err := a.db.Exec(a.queries.updateBilling, nullString(billing.StripeCustomerID), nullString(billing.StripeSubscriptionID), nullString(string(billing.StripeSubscriptionStatus)), nullString(string(billing.StripeSubscriptionInterval)), nullInt64(billing.StripeSubscriptionPaidUntil.Unix()), nullInt64(billing.StripeSubscriptionCancelAt.Unix()), username); err != nil {
+		return err
+	}
+	return nil
+}
+
+// Tiers returns a list of all Tier structs
+func (a *Manager) Tiers() ([]*Tier, error) {
+	rows, err := a.db.Query(a.queries.selectTiers)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	tiers := make([]*Tier, 0)
+	for {
+		tier, err := a.readTier(rows)
+		if err == ErrTierNotFound {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		tiers = append(tiers, tier)
+	}
+	return tiers, nil
+}
+
+// Tier returns a Tier based on the code, or ErrTierNotFound if it does not exist
+func (a *Manager) Tier(code string) (*Tier, error) {
+	rows, err := a.db.Query(a.queries.selectTierByCode, code)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return a.readTier(rows)
+}
+
+// TierByStripePrice returns a Tier based on the Stripe price ID, or ErrTierNotFound if it does not exist
+func (a *Manager) TierByStripePrice(priceID string) (*Tier, error) {
+	rows, err := a.db.Query(a.queries.selectTierByPriceID, priceID, priceID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return a.readTier(rows)
+}
+
+func (a *Manager) readTier(rows *sql.Rows) (*Tier, error) {
+	var id, code, name string
+	var stripeMonthlyPriceID, stripeYearlyPriceID sql.NullString
+	var messagesLimit, messagesExpiryDuration, emailsLimit, callsLimit, reservationsLimit, attachmentFileSizeLimit, attachmentTotalSizeLimit, attachmentExpiryDuration, attachmentBandwidthLimit sql.NullInt64
+	if !rows.Next() {
+		return nil, ErrTierNotFound
+	}
+	if err := rows.Scan(&id, &code, &name, &messagesLimit, &messagesExpiryDuration, &emailsLimit, &callsLimit, &reservationsLimit, &attachmentFileSizeLimit, &attachmentTotalSizeLimit, &attachmentExpiryDuration, &attachmentBandwidthLimit, &stripeMonthlyPriceID, &stripeYearlyPriceID); err != nil {
+		return nil, err
+	} else if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	// When changed, note readUser() as well
 	return &Tier{
 		ID:                       id,
 		Code:                     code,
@@ -1504,6 +3396,14 @@ func fromSQLWildcard(s string) string {
 	return strings.ReplaceAll(unescapeUnderscore(s), "%", "*")
 }
 
+// matchTopicPattern returns true if the topic matches the given topic pattern. The pattern
+// may contain the wildcard character '*', which matches any number of characters.
+func matchTopicPattern(pattern, topic string) bool {
+	re := "^" + strings.ReplaceAll(regexp.QuoteMeta(pattern), `\*`, ".*") + "$"
+	matched, err := regexp.MatchString(re, topic)
+	return err == nil && matched
+}
+
 func escapeUnderscore(s string) string {
 	return strings.ReplaceAll(s, "_", "\\_")
 }
@@ -1663,6 +3563,182 @@ func migrateFrom4(db *sql.DB) error {
 	return tx.Commit()
 }
 
+func migrateFrom5(db *sql.DB) error {
+	log.Tag(tag).Info("Migrating user database schema: from 5 to 6")
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(migrate5To6UpdateQueries); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(updateSchemaVersion, 6); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func migrateFrom6(db *sql.DB) error {
+	log.Tag(tag).Info("Migrating user database schema: from 6 to 7")
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(migrate6To7UpdateQueries); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(updateSchemaVersion, 7); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func migrateFrom7(db *sql.DB) error {
+	log.Tag(tag).Info("Migrating user database schema: from 7 to 8")
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(migrate7To8UpdateQueries); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(updateSchemaVersion, 8); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func migrateFrom8(db *sql.DB) error {
+	log.Tag(tag).Info("Migrating user database schema: from 8 to 9")
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(migrate8To9UpdateQueries); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(updateSchemaVersion, 9); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func migrateFrom9(db *sql.DB) error {
+	log.Tag(tag).Info("Migrating user database schema: from 9 to 10")
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(migrate9To10UpdateQueries); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(updateSchemaVersion, 10); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func migrateFrom10(db *sql.DB) error {
+	log.Tag(tag).Info("Migrating user database schema: from 10 to 11")
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(migrate10To11UpdateQueries); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(updateSchemaVersion, 11); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func migrateFrom11(db *sql.DB) error {
+	log.Tag(tag).Info("Migrating user database schema: from 11 to 12")
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(migrate11To12UpdateQueries); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(updateSchemaVersion, 12); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func migrateFrom12(db *sql.DB) error {
+	log.Tag(tag).Info("Migrating user database schema: from 12 to 13")
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(migrate12To13UpdateQueries); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(updateSchemaVersion, 13); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func migrateFrom13(db *sql.DB) error {
+	log.Tag(tag).Info("Migrating user database schema: from 13 to 14")
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(migrate13To14UpdateQueries); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(updateSchemaVersion, 14); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func migrateFrom14(db *sql.DB) error {
+	log.Tag(tag).Info("Migrating user database schema: from 14 to 15")
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(migrate14To15UpdateQueries); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(updateSchemaVersion, 15); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func migrateFrom15(db *sql.DB) error {
+	log.Tag(tag).Info("Migrating user database schema: from 15 to 16")
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(migrate15To16UpdateQueries); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(updateSchemaVersion, 16); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
 func nullString(s string) sql.NullString {
 	if s == "" {
 		return sql.NullString{}
@@ -1676,3 +3752,16 @@ func nullInt64(v int64) sql.NullInt64 {
 	}
 	return sql.NullInt64{Int64: v, Valid: true}
 }
+
+// isUniqueConstraintError returns true if the given error is a unique constraint violation
+// reported by either the SQLite or the PostgreSQL driver
+func isUniqueConstraintError(err error) bool {
+	var sqliteErr sqlite3.Error
+	var postgresErr *pq.Error
+	if errors.As(err, &sqliteErr) {
+		return sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique
+	} else if errors.As(err, &postgresErr) {
+		return postgresErr.Code == postgresUniqueViolationCode
+	}
+	return false
+}
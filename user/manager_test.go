@@ -8,6 +8,8 @@ import (
 	"golang.org/x/crypto/bcrypt"
 	"heckel.io/ntfy/v2/util"
 	"net/netip"
+	"net/url"
+	"os"
 	"path/filepath"
 	"strings"
 	"testing"
@@ -17,1052 +19,1815 @@ import (
 const minBcryptTimingMillis = int64(50) // Ideally should be >100ms, but this should also run on a Raspberry Pi without massive resources
 
 func TestManager_FullScenario_Default_DenyAll(t *testing.T) {
-	a := newTestManagerFromFile(t, filepath.Join(t.TempDir(), "user.db"), "", PermissionDenyAll, DefaultUserPasswordBcryptCost, DefaultUserStatsQueueWriterInterval)
-	require.Nil(t, a.AddUser("phil", "phil", RoleAdmin))
-	require.Nil(t, a.AddUser("ben", "ben", RoleUser))
-	require.Nil(t, a.AddUser("john", "john", RoleUser))
-	require.Nil(t, a.AllowAccess("ben", "mytopic", PermissionReadWrite))
-	require.Nil(t, a.AllowAccess("ben", "readme", PermissionRead))
-	require.Nil(t, a.AllowAccess("ben", "writeme", PermissionWrite))
-	require.Nil(t, a.AllowAccess("ben", "everyonewrite", PermissionDenyAll)) // How unfair!
-	require.Nil(t, a.AllowAccess("john", "*", PermissionRead))
-	require.Nil(t, a.AllowAccess("john", "mytopic*", PermissionReadWrite))
-	require.Nil(t, a.AllowAccess("john", "mytopic_ro*", PermissionRead))
-	require.Nil(t, a.AllowAccess("john", "mytopic_deny*", PermissionDenyAll))
-	require.Nil(t, a.AllowAccess(Everyone, "announcements", PermissionRead))
-	require.Nil(t, a.AllowAccess(Everyone, "everyonewrite", PermissionReadWrite))
-	require.Nil(t, a.AllowAccess(Everyone, "up*", PermissionWrite)) // Everyone can write to /up*
-
-	phil, err := a.Authenticate("phil", "phil")
-	require.Nil(t, err)
-	require.Equal(t, "phil", phil.Name)
-	require.True(t, strings.HasPrefix(phil.Hash, "$2a$10$"))
-	require.Equal(t, RoleAdmin, phil.Role)
+	forEachBackend(t, func(t *testing.T, filename string) {
+		a := newTestManagerFromFile(t, filename, "", PermissionDenyAll, DefaultUserPasswordBcryptCost, DefaultUserStatsQueueWriterInterval)
+		require.Nil(t, a.AddUser("phil", "phil", RoleAdmin))
+		require.Nil(t, a.AddUser("ben", "ben", RoleUser))
+		require.Nil(t, a.AddUser("john", "john", RoleUser))
+		require.Nil(t, a.AllowAccess("ben", "mytopic", PermissionReadWrite))
+		require.Nil(t, a.AllowAccess("ben", "readme", PermissionRead))
+		require.Nil(t, a.AllowAccess("ben", "writeme", PermissionWrite))
+		require.Nil(t, a.AllowAccess("ben", "everyonewrite", PermissionDenyAll)) // How unfair!
+		require.Nil(t, a.AllowAccess("john", "*", PermissionRead))
+		require.Nil(t, a.AllowAccess("john", "mytopic*", PermissionReadWrite))
+		require.Nil(t, a.AllowAccess("john", "mytopic_ro*", PermissionRead))
+		require.Nil(t, a.AllowAccess("john", "mytopic_deny*", PermissionDenyAll))
+		require.Nil(t, a.AllowAccess(Everyone, "announcements", PermissionRead))
+		require.Nil(t, a.AllowAccess(Everyone, "everyonewrite", PermissionReadWrite))
+		require.Nil(t, a.AllowAccess(Everyone, "up*", PermissionWrite)) // Everyone can write to /up*
+
+		phil, err := a.Authenticate("phil", "phil")
+		require.Nil(t, err)
+		require.Equal(t, "phil", phil.Name)
+		require.True(t, strings.HasPrefix(phil.Hash, "$2a$10$"))
+		require.Equal(t, RoleAdmin, phil.Role)
 
-	philGrants, err := a.Grants("phil")
-	require.Nil(t, err)
-	require.Equal(t, []Grant{}, philGrants)
+		philGrants, err := a.Grants("phil")
+		require.Nil(t, err)
+		require.Equal(t, []Grant{}, philGrants)
 
-	ben, err := a.Authenticate("ben", "ben")
-	require.Nil(t, err)
-	require.Equal(t, "ben", ben.Name)
-	require.True(t, strings.HasPrefix(ben.Hash, "$2a$10$"))
-	require.Equal(t, RoleUser, ben.Role)
+		ben, err := a.Authenticate("ben", "ben")
+		require.Nil(t, err)
+		require.Equal(t, "ben", ben.Name)
+		require.True(t, strings.HasPrefix(ben.Hash, "$2a$10$"))
+		require.Equal(t, RoleUser, ben.Role)
 
-	benGrants, err := a.Grants("ben")
-	require.Nil(t, err)
-	require.Equal(t, []Grant{
-		{"everyonewrite", PermissionDenyAll},
-		{"mytopic", PermissionReadWrite},
-		{"writeme", PermissionWrite},
-		{"readme", PermissionRead},
-	}, benGrants)
-
-	john, err := a.Authenticate("john", "john")
-	require.Nil(t, err)
-	require.Equal(t, "john", john.Name)
-	require.True(t, strings.HasPrefix(john.Hash, "$2a$10$"))
-	require.Equal(t, RoleUser, john.Role)
+		benGrants, err := a.Grants("ben")
+		require.Nil(t, err)
+		require.Equal(t, []Grant{
+			{"everyonewrite", PermissionDenyAll},
+			{"mytopic", PermissionReadWrite},
+			{"writeme", PermissionWrite},
+			{"readme", PermissionRead},
+		}, benGrants)
+
+		john, err := a.Authenticate("john", "john")
+		require.Nil(t, err)
+		require.Equal(t, "john", john.Name)
+		require.True(t, strings.HasPrefix(john.Hash, "$2a$10$"))
+		require.Equal(t, RoleUser, john.Role)
 
-	johnGrants, err := a.Grants("john")
-	require.Nil(t, err)
-	require.Equal(t, []Grant{
-		{"mytopic_deny*", PermissionDenyAll},
-		{"mytopic_ro*", PermissionRead},
-		{"mytopic*", PermissionReadWrite},
-		{"*", PermissionRead},
-	}, johnGrants)
-
-	notben, err := a.Authenticate("ben", "this is wrong")
-	require.Nil(t, notben)
-	require.Equal(t, ErrUnauthenticated, err)
-
-	// Admin can do everything
-	require.Nil(t, a.Authorize(phil, "sometopic", PermissionWrite))
-	require.Nil(t, a.Authorize(phil, "mytopic", PermissionRead))
-	require.Nil(t, a.Authorize(phil, "readme", PermissionWrite))
-	require.Nil(t, a.Authorize(phil, "writeme", PermissionWrite))
-	require.Nil(t, a.Authorize(phil, "announcements", PermissionWrite))
-	require.Nil(t, a.Authorize(phil, "everyonewrite", PermissionWrite))
-
-	// User cannot do everything
-	require.Nil(t, a.Authorize(ben, "mytopic", PermissionWrite))
-	require.Nil(t, a.Authorize(ben, "mytopic", PermissionRead))
-	require.Nil(t, a.Authorize(ben, "readme", PermissionRead))
-	require.Equal(t, ErrUnauthorized, a.Authorize(ben, "readme", PermissionWrite))
-	require.Equal(t, ErrUnauthorized, a.Authorize(ben, "writeme", PermissionRead))
-	require.Nil(t, a.Authorize(ben, "writeme", PermissionWrite))
-	require.Nil(t, a.Authorize(ben, "writeme", PermissionWrite))
-	require.Equal(t, ErrUnauthorized, a.Authorize(ben, "everyonewrite", PermissionRead))
-	require.Equal(t, ErrUnauthorized, a.Authorize(ben, "everyonewrite", PermissionWrite))
-	require.Nil(t, a.Authorize(ben, "announcements", PermissionRead))
-	require.Equal(t, ErrUnauthorized, a.Authorize(ben, "announcements", PermissionWrite))
-
-	// User john should have
-	//  "deny" to mytopic_deny*,
-	//    "ro" to mytopic_ro*,
-	//    "rw" to mytopic*,
-	//    "ro" to the rest
-	require.Equal(t, ErrUnauthorized, a.Authorize(john, "mytopic_deny_case", PermissionRead))
-	require.Equal(t, ErrUnauthorized, a.Authorize(john, "mytopic_deny_case", PermissionWrite))
-	require.Nil(t, a.Authorize(john, "mytopic_ro_test_case", PermissionRead))
-	require.Equal(t, ErrUnauthorized, a.Authorize(john, "mytopic_ro_test_case", PermissionWrite))
-	require.Nil(t, a.Authorize(john, "mytopic_case1", PermissionRead))
-	require.Nil(t, a.Authorize(john, "mytopic_case1", PermissionWrite))
-	require.Nil(t, a.Authorize(john, "readme", PermissionRead))
-	require.Equal(t, ErrUnauthorized, a.Authorize(john, "writeme", PermissionWrite))
-
-	// Everyone else can do barely anything
-	require.Equal(t, ErrUnauthorized, a.Authorize(nil, "sometopicnotinthelist", PermissionRead))
-	require.Equal(t, ErrUnauthorized, a.Authorize(nil, "sometopicnotinthelist", PermissionWrite))
-	require.Equal(t, ErrUnauthorized, a.Authorize(nil, "mytopic", PermissionRead))
-	require.Equal(t, ErrUnauthorized, a.Authorize(nil, "mytopic", PermissionWrite))
-	require.Equal(t, ErrUnauthorized, a.Authorize(nil, "readme", PermissionRead))
-	require.Equal(t, ErrUnauthorized, a.Authorize(nil, "readme", PermissionWrite))
-	require.Equal(t, ErrUnauthorized, a.Authorize(nil, "writeme", PermissionRead))
-	require.Equal(t, ErrUnauthorized, a.Authorize(nil, "writeme", PermissionWrite))
-	require.Equal(t, ErrUnauthorized, a.Authorize(nil, "announcements", PermissionWrite))
-	require.Nil(t, a.Authorize(nil, "announcements", PermissionRead))
-	require.Nil(t, a.Authorize(nil, "everyonewrite", PermissionRead))
-	require.Nil(t, a.Authorize(nil, "everyonewrite", PermissionWrite))
-	require.Nil(t, a.Authorize(nil, "up1234", PermissionWrite)) // Wildcard permission
-	require.Nil(t, a.Authorize(nil, "up5678", PermissionWrite))
+		johnGrants, err := a.Grants("john")
+		require.Nil(t, err)
+		require.Equal(t, []Grant{
+			{"mytopic_deny*", PermissionDenyAll},
+			{"mytopic_ro*", PermissionRead},
+			{"mytopic*", PermissionReadWrite},
+			{"*", PermissionRead},
+		}, johnGrants)
+
+		notben, err := a.Authenticate("ben", "this is wrong")
+		require.Nil(t, notben)
+		require.Equal(t, ErrUnauthenticated, err)
+
+		// Admin can do everything
+		require.Nil(t, a.Authorize(phil, "sometopic", PermissionWrite))
+		require.Nil(t, a.Authorize(phil, "mytopic", PermissionRead))
+		require.Nil(t, a.Authorize(phil, "readme", PermissionWrite))
+		require.Nil(t, a.Authorize(phil, "writeme", PermissionWrite))
+		require.Nil(t, a.Authorize(phil, "announcements", PermissionWrite))
+		require.Nil(t, a.Authorize(phil, "everyonewrite", PermissionWrite))
+
+		// User cannot do everything
+		require.Nil(t, a.Authorize(ben, "mytopic", PermissionWrite))
+		require.Nil(t, a.Authorize(ben, "mytopic", PermissionRead))
+		require.Nil(t, a.Authorize(ben, "readme", PermissionRead))
+		require.Equal(t, ErrUnauthorized, a.Authorize(ben, "readme", PermissionWrite))
+		require.Equal(t, ErrUnauthorized, a.Authorize(ben, "writeme", PermissionRead))
+		require.Nil(t, a.Authorize(ben, "writeme", PermissionWrite))
+		require.Nil(t, a.Authorize(ben, "writeme", PermissionWrite))
+		require.Equal(t, ErrUnauthorized, a.Authorize(ben, "everyonewrite", PermissionRead))
+		require.Equal(t, ErrUnauthorized, a.Authorize(ben, "everyonewrite", PermissionWrite))
+		require.Nil(t, a.Authorize(ben, "announcements", PermissionRead))
+		require.Equal(t, ErrUnauthorized, a.Authorize(ben, "announcements", PermissionWrite))
+
+		// User john should have
+		//  "deny" to mytopic_deny*,
+		//    "ro" to mytopic_ro*,
+		//    "rw" to mytopic*,
+		//    "ro" to the rest
+		require.Equal(t, ErrUnauthorized, a.Authorize(john, "mytopic_deny_case", PermissionRead))
+		require.Equal(t, ErrUnauthorized, a.Authorize(john, "mytopic_deny_case", PermissionWrite))
+		require.Nil(t, a.Authorize(john, "mytopic_ro_test_case", PermissionRead))
+		require.Equal(t, ErrUnauthorized, a.Authorize(john, "mytopic_ro_test_case", PermissionWrite))
+		require.Nil(t, a.Authorize(john, "mytopic_case1", PermissionRead))
+		require.Nil(t, a.Authorize(john, "mytopic_case1", PermissionWrite))
+		require.Nil(t, a.Authorize(john, "readme", PermissionRead))
+		require.Equal(t, ErrUnauthorized, a.Authorize(john, "writeme", PermissionWrite))
+
+		// Everyone else can do barely anything
+		require.Equal(t, ErrUnauthorized, a.Authorize(nil, "sometopicnotinthelist", PermissionRead))
+		require.Equal(t, ErrUnauthorized, a.Authorize(nil, "sometopicnotinthelist", PermissionWrite))
+		require.Equal(t, ErrUnauthorized, a.Authorize(nil, "mytopic", PermissionRead))
+		require.Equal(t, ErrUnauthorized, a.Authorize(nil, "mytopic", PermissionWrite))
+		require.Equal(t, ErrUnauthorized, a.Authorize(nil, "readme", PermissionRead))
+		require.Equal(t, ErrUnauthorized, a.Authorize(nil, "readme", PermissionWrite))
+		require.Equal(t, ErrUnauthorized, a.Authorize(nil, "writeme", PermissionRead))
+		require.Equal(t, ErrUnauthorized, a.Authorize(nil, "writeme", PermissionWrite))
+		require.Equal(t, ErrUnauthorized, a.Authorize(nil, "announcements", PermissionWrite))
+		require.Nil(t, a.Authorize(nil, "announcements", PermissionRead))
+		require.Nil(t, a.Authorize(nil, "everyonewrite", PermissionRead))
+		require.Nil(t, a.Authorize(nil, "everyonewrite", PermissionWrite))
+		require.Nil(t, a.Authorize(nil, "up1234", PermissionWrite)) // Wildcard permission
+		require.Nil(t, a.Authorize(nil, "up5678", PermissionWrite))
+	})
 }
 
 func TestManager_Access_Order_LengthWriteRead(t *testing.T) {
-	// This test validates issue #914 / #917, i.e. that write permissions are prioritized over read permissions,
-	// and longer ACL rules are prioritized as well.
+	forEachBackend(t, func(t *testing.T, filename string) {
+		// This test validates issue #914 / #917, i.e. that write permissions are prioritized over read permissions,
+		// and longer ACL rules are prioritized as well.
 
-	a := newTestManagerFromFile(t, filepath.Join(t.TempDir(), "user.db"), "", PermissionDenyAll, DefaultUserPasswordBcryptCost, DefaultUserStatsQueueWriterInterval)
-	require.Nil(t, a.AddUser("ben", "ben", RoleUser))
-	require.Nil(t, a.AllowAccess("ben", "test*", PermissionReadWrite))
-	require.Nil(t, a.AllowAccess("ben", "*", PermissionRead))
+		a := newTestManagerFromFile(t, filename, "", PermissionDenyAll, DefaultUserPasswordBcryptCost, DefaultUserStatsQueueWriterInterval)
+		require.Nil(t, a.AddUser("ben", "ben", RoleUser))
+		require.Nil(t, a.AllowAccess("ben", "test*", PermissionReadWrite))
+		require.Nil(t, a.AllowAccess("ben", "*", PermissionRead))
 
-	ben, err := a.Authenticate("ben", "ben")
-	require.Nil(t, err)
-	require.Nil(t, a.Authorize(ben, "any-topic-can-be-read", PermissionRead))
-	require.Nil(t, a.Authorize(ben, "this-too", PermissionRead))
-	require.Nil(t, a.Authorize(ben, "test123", PermissionWrite))
+		ben, err := a.Authenticate("ben", "ben")
+		require.Nil(t, err)
+		require.Nil(t, a.Authorize(ben, "any-topic-can-be-read", PermissionRead))
+		require.Nil(t, a.Authorize(ben, "this-too", PermissionRead))
+		require.Nil(t, a.Authorize(ben, "test123", PermissionWrite))
+	})
 }
 
 func TestManager_AddUser_Invalid(t *testing.T) {
-	a := newTestManager(t, PermissionDenyAll)
-	require.Equal(t, ErrInvalidArgument, a.AddUser("  invalid  ", "pass", RoleAdmin))
-	require.Equal(t, ErrInvalidArgument, a.AddUser("validuser", "pass", "invalid-role"))
+	forEachBackend(t, func(t *testing.T, filename string) {
+		a := newTestManager(t, filename, PermissionDenyAll)
+		require.Equal(t, ErrInvalidArgument, a.AddUser("  invalid  ", "pass", RoleAdmin))
+		require.Equal(t, ErrInvalidArgument, a.AddUser("validuser", "pass", "invalid-role"))
+	})
+}
+
+func TestManager_AddOIDCUser(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, filename string) {
+		a := newTestManager(t, filename, PermissionDenyAll)
+		require.Nil(t, a.AddUser("phil", "phil", RoleAdmin))
+		require.Nil(t, a.AddOIDCUser("ben", RoleUser, "https://idp.example.com", "sub-ben"))
+		require.Equal(t, ErrUserExists, a.AddOIDCUser("phil", RoleUser, "https://idp.example.com", "sub-phil"))
+		require.Equal(t, ErrInvalidArgument, a.AddOIDCUser("john", RoleUser, "https://idp.example.com", ""))
+
+		u, err := a.UserByOIDCSubject("https://idp.example.com", "sub-ben")
+		require.Nil(t, err)
+		require.Equal(t, "ben", u.Name)
+		require.Equal(t, RoleUser, u.Role)
+		oidcUser, err := a.IsOIDCUser(u)
+		require.Nil(t, err)
+		require.True(t, oidcUser)
+		oidcUser, err = a.IsOIDCUser(mustUser(t, a, "phil"))
+		require.Nil(t, err)
+		require.False(t, oidcUser)
+
+		// Failed additions do not leave a link behind, and identities are bound to their issuer
+		_, err = a.UserByOIDCSubject("https://idp.example.com", "sub-phil")
+		require.Equal(t, ErrUserNotFound, err)
+		_, err = a.UserByOIDCSubject("https://other.example.com", "sub-ben")
+		require.Equal(t, ErrUserNotFound, err)
+
+		// Links are removed with the user
+		require.Nil(t, a.RemoveUser("ben"))
+		_, err = a.UserByOIDCSubject("https://idp.example.com", "sub-ben")
+		require.Equal(t, ErrUserNotFound, err)
+	})
 }
 
 func TestManager_AddUser_Timing(t *testing.T) {
-	a := newTestManagerFromFile(t, filepath.Join(t.TempDir(), "user.db"), "", PermissionDenyAll, DefaultUserPasswordBcryptCost, DefaultUserStatsQueueWriterInterval)
-	start := time.Now().UnixMilli()
-	require.Nil(t, a.AddUser("user", "pass", RoleAdmin))
-	require.GreaterOrEqual(t, time.Now().UnixMilli()-start, minBcryptTimingMillis)
+	forEachBackend(t, func(t *testing.T, filename string) {
+		a := newTestManagerFromFile(t, filename, "", PermissionDenyAll, DefaultUserPasswordBcryptCost, DefaultUserStatsQueueWriterInterval)
+		start := time.Now().UnixMilli()
+		require.Nil(t, a.AddUser("user", "pass", RoleAdmin))
+		require.GreaterOrEqual(t, time.Now().UnixMilli()-start, minBcryptTimingMillis)
+	})
 }
 
 func TestManager_AddUser_And_Query(t *testing.T) {
-	a := newTestManagerFromFile(t, filepath.Join(t.TempDir(), "user.db"), "", PermissionDenyAll, DefaultUserPasswordBcryptCost, DefaultUserStatsQueueWriterInterval)
-	require.Nil(t, a.AddUser("user", "pass", RoleAdmin))
-	require.Nil(t, a.ChangeBilling("user", &Billing{
-		StripeCustomerID:            "acct_123",
-		StripeSubscriptionID:        "sub_123",
-		StripeSubscriptionStatus:    stripe.SubscriptionStatusActive,
-		StripeSubscriptionInterval:  stripe.PriceRecurringIntervalMonth,
-		StripeSubscriptionPaidUntil: time.Now().Add(time.Hour),
-		StripeSubscriptionCancelAt:  time.Unix(0, 0),
-	}))
-
-	u, err := a.User("user")
-	require.Nil(t, err)
-	require.Equal(t, "user", u.Name)
+	forEachBackend(t, func(t *testing.T, filename string) {
+		a := newTestManagerFromFile(t, filename, "", PermissionDenyAll, DefaultUserPasswordBcryptCost, DefaultUserStatsQueueWriterInterval)
+		require.Nil(t, a.AddUser("user", "pass", RoleAdmin))
+		require.Nil(t, a.ChangeBilling("user", &Billing{
+			StripeCustomerID:            "acct_123",
+			StripeSubscriptionID:        "sub_123",
+			StripeSubscriptionStatus:    stripe.SubscriptionStatusActive,
+			StripeSubscriptionInterval:  stripe.PriceRecurringIntervalMonth,
+			StripeSubscriptionPaidUntil: time.Now().Add(time.Hour),
+			StripeSubscriptionCancelAt:  time.Unix(0, 0),
+		}))
+
+		u, err := a.User("user")
+		require.Nil(t, err)
+		require.Equal(t, "user", u.Name)
 
-	u2, err := a.UserByID(u.ID)
-	require.Nil(t, err)
-	require.Equal(t, u.Name, u2.Name)
+		u2, err := a.UserByID(u.ID)
+		require.Nil(t, err)
+		require.Equal(t, u.Name, u2.Name)
 
-	u3, err := a.UserByStripeCustomer("acct_123")
-	require.Nil(t, err)
-	require.Equal(t, u.ID, u3.ID)
+		u3, err := a.UserByStripeCustomer("acct_123")
+		require.Nil(t, err)
+		require.Equal(t, u.ID, u3.ID)
+	})
 }
 
 func TestManager_MarkUserRemoved_RemoveDeletedUsers(t *testing.T) {
-	a := newTestManager(t, PermissionDenyAll)
+	forEachBackend(t, func(t *testing.T, filename string) {
+		a := newTestManager(t, filename, PermissionDenyAll)
 
-	// Create user, add reservations and token
-	require.Nil(t, a.AddUser("user", "pass", RoleAdmin))
-	require.Nil(t, a.AddReservation("user", "mytopic", PermissionRead))
+		// Create user, add reservations and token
+		require.Nil(t, a.AddUser("user", "pass", RoleAdmin))
+		require.Nil(t, a.AddReservation("user", "mytopic", PermissionRead))
 
-	u, err := a.User("user")
-	require.Nil(t, err)
-	require.False(t, u.Deleted)
+		u, err := a.User("user")
+		require.Nil(t, err)
+		require.False(t, u.Deleted)
 
-	token, err := a.CreateToken(u.ID, "", time.Now().Add(time.Hour), netip.IPv4Unspecified())
-	require.Nil(t, err)
+		token, err := a.CreateToken(u.ID, "", time.Now().Add(time.Hour), netip.IPv4Unspecified(), nil)
+		require.Nil(t, err)
 
-	u, err = a.Authenticate("user", "pass")
-	require.Nil(t, err)
+		u, err = a.Authenticate("user", "pass")
+		require.Nil(t, err)
 
-	_, err = a.AuthenticateToken(token.Value)
-	require.Nil(t, err)
+		_, err = a.AuthenticateToken(token.Value)
+		require.Nil(t, err)
 
-	reservations, err := a.Reservations("user")
-	require.Nil(t, err)
-	require.Equal(t, 1, len(reservations))
+		reservations, err := a.Reservations("user")
+		require.Nil(t, err)
+		require.Equal(t, 1, len(reservations))
 
-	// Mark deleted: cannot auth anymore, and all reservations are gone
-	require.Nil(t, a.MarkUserRemoved(u))
+		// Mark deleted: cannot auth anymore, and all reservations are gone
+		require.Nil(t, a.MarkUserRemoved(u))
 
-	_, err = a.Authenticate("user", "pass")
-	require.Equal(t, ErrUnauthenticated, err)
+		_, err = a.Authenticate("user", "pass")
+		require.Equal(t, ErrUnauthenticated, err)
 
-	_, err = a.AuthenticateToken(token.Value)
-	require.Equal(t, ErrUnauthenticated, err)
+		_, err = a.AuthenticateToken(token.Value)
+		require.Equal(t, ErrUnauthenticated, err)
 
-	reservations, err = a.Reservations("user")
-	require.Nil(t, err)
-	require.Equal(t, 0, len(reservations))
+		reservations, err = a.Reservations("user")
+		require.Nil(t, err)
+		require.Equal(t, 0, len(reservations))
 
-	// Make sure user is still there
-	u, err = a.User("user")
-	require.Nil(t, err)
-	require.True(t, u.Deleted)
+		// Make sure user is still there
+		u, err = a.User("user")
+		require.Nil(t, err)
+		require.True(t, u.Deleted)
 
-	_, err = a.db.Exec("UPDATE user SET deleted = ? WHERE id = ?", time.Now().Add(-1*(userHardDeleteAfterDuration+time.Hour)).Unix(), u.ID)
-	require.Nil(t, err)
-	require.Nil(t, a.RemoveDeletedUsers())
+		_, err = a.db.Exec(`UPDATE "user" SET deleted = $1 WHERE id = $2`, time.Now().Add(-1*(userHardDeleteAfterDuration+time.Hour)).Unix(), u.ID)
+		require.Nil(t, err)
+		require.Nil(t, a.RemoveDeletedUsers())
 
-	_, err = a.User("user")
-	require.Equal(t, ErrUserNotFound, err)
+		_, err = a.User("user")
+		require.Equal(t, ErrUserNotFound, err)
+	})
 }
 
 func TestManager_CreateToken_Only_Lower(t *testing.T) {
-	a := newTestManager(t, PermissionDenyAll)
+	forEachBackend(t, func(t *testing.T, filename string) {
+		a := newTestManager(t, filename, PermissionDenyAll)
 
-	// Create user, add reservations and token
-	require.Nil(t, a.AddUser("user", "pass", RoleAdmin))
-	u, err := a.User("user")
-	require.Nil(t, err)
+		// Create user, add reservations and token
+		require.Nil(t, a.AddUser("user", "pass", RoleAdmin))
+		u, err := a.User("user")
+		require.Nil(t, err)
 
-	token, err := a.CreateToken(u.ID, "", time.Now().Add(time.Hour), netip.IPv4Unspecified())
-	require.Nil(t, err)
-	require.Equal(t, token.Value, strings.ToLower(token.Value))
+		token, err := a.CreateToken(u.ID, "", time.Now().Add(time.Hour), netip.IPv4Unspecified(), nil)
+		require.Nil(t, err)
+		require.Equal(t, token.Value, strings.ToLower(token.Value))
+	})
 }
 
 func TestManager_UserManagement(t *testing.T) {
-	a := newTestManager(t, PermissionDenyAll)
-	require.Nil(t, a.AddUser("phil", "phil", RoleAdmin))
-	require.Nil(t, a.AddUser("ben", "ben", RoleUser))
-	require.Nil(t, a.AllowAccess("ben", "mytopic", PermissionReadWrite))
-	require.Nil(t, a.AllowAccess("ben", "readme", PermissionRead))
-	require.Nil(t, a.AllowAccess("ben", "writeme", PermissionWrite))
-	require.Nil(t, a.AllowAccess("ben", "everyonewrite", PermissionDenyAll)) // How unfair!
-	require.Nil(t, a.AllowAccess(Everyone, "announcements", PermissionRead))
-	require.Nil(t, a.AllowAccess(Everyone, "everyonewrite", PermissionReadWrite))
-
-	// Query user details
-	phil, err := a.User("phil")
-	require.Nil(t, err)
-	require.Equal(t, "phil", phil.Name)
-	require.True(t, strings.HasPrefix(phil.Hash, "$2a$04$")) // Min cost for testing
-	require.Equal(t, RoleAdmin, phil.Role)
+	forEachBackend(t, func(t *testing.T, filename string) {
+		a := newTestManager(t, filename, PermissionDenyAll)
+		require.Nil(t, a.AddUser("phil", "phil", RoleAdmin))
+		require.Nil(t, a.AddUser("ben", "ben", RoleUser))
+		require.Nil(t, a.AllowAccess("ben", "mytopic", PermissionReadWrite))
+		require.Nil(t, a.AllowAccess("ben", "readme", PermissionRead))
+		require.Nil(t, a.AllowAccess("ben", "writeme", PermissionWrite))
+		require.Nil(t, a.AllowAccess("ben", "everyonewrite", PermissionDenyAll)) // How unfair!
+		require.Nil(t, a.AllowAccess(Everyone, "announcements", PermissionRead))
+		require.Nil(t, a.AllowAccess(Everyone, "everyonewrite", PermissionReadWrite))
+
+		// Query user details
+		phil, err := a.User("phil")
+		require.Nil(t, err)
+		require.Equal(t, "phil", phil.Name)
+		require.True(t, strings.HasPrefix(phil.Hash, "$2a$04$")) // Min cost for testing
+		require.Equal(t, RoleAdmin, phil.Role)
 
-	philGrants, err := a.Grants("phil")
-	require.Nil(t, err)
-	require.Equal(t, []Grant{}, philGrants)
+		philGrants, err := a.Grants("phil")
+		require.Nil(t, err)
+		require.Equal(t, []Grant{}, philGrants)
 
-	ben, err := a.User("ben")
-	require.Nil(t, err)
-	require.Equal(t, "ben", ben.Name)
-	require.True(t, strings.HasPrefix(ben.Hash, "$2a$04$")) // Min cost for testing
-	require.Equal(t, RoleUser, ben.Role)
+		ben, err := a.User("ben")
+		require.Nil(t, err)
+		require.Equal(t, "ben", ben.Name)
+		require.True(t, strings.HasPrefix(ben.Hash, "$2a$04$")) // Min cost for testing
+		require.Equal(t, RoleUser, ben.Role)
 
-	benGrants, err := a.Grants("ben")
-	require.Nil(t, err)
-	require.Equal(t, []Grant{
-		{"everyonewrite", PermissionDenyAll},
-		{"mytopic", PermissionReadWrite},
-		{"writeme", PermissionWrite},
-		{"readme", PermissionRead},
-	}, benGrants)
-
-	everyone, err := a.User(Everyone)
-	require.Nil(t, err)
-	require.Equal(t, "*", everyone.Name)
-	require.Equal(t, "", everyone.Hash)
-	require.Equal(t, RoleAnonymous, everyone.Role)
+		benGrants, err := a.Grants("ben")
+		require.Nil(t, err)
+		require.Equal(t, []Grant{
+			{"everyonewrite", PermissionDenyAll},
+			{"mytopic", PermissionReadWrite},
+			{"writeme", PermissionWrite},
+			{"readme", PermissionRead},
+		}, benGrants)
+
+		everyone, err := a.User(Everyone)
+		require.Nil(t, err)
+		require.Equal(t, "*", everyone.Name)
+		require.Equal(t, "", everyone.Hash)
+		require.Equal(t, RoleAnonymous, everyone.Role)
 
-	everyoneGrants, err := a.Grants(Everyone)
-	require.Nil(t, err)
-	require.Equal(t, []Grant{
-		{"everyonewrite", PermissionReadWrite},
-		{"announcements", PermissionRead},
-	}, everyoneGrants)
-
-	// Ben: Before revoking
-	require.Nil(t, a.AllowAccess("ben", "mytopic", PermissionReadWrite)) // Overwrite!
-	require.Nil(t, a.AllowAccess("ben", "readme", PermissionRead))
-	require.Nil(t, a.AllowAccess("ben", "writeme", PermissionWrite))
-	require.Nil(t, a.Authorize(ben, "mytopic", PermissionRead))
-	require.Nil(t, a.Authorize(ben, "mytopic", PermissionWrite))
-	require.Nil(t, a.Authorize(ben, "readme", PermissionRead))
-	require.Nil(t, a.Authorize(ben, "writeme", PermissionWrite))
-
-	// Revoke access for "ben" to "mytopic", then check again
-	require.Nil(t, a.ResetAccess("ben", "mytopic"))
-	require.Equal(t, ErrUnauthorized, a.Authorize(ben, "mytopic", PermissionWrite)) // Revoked
-	require.Equal(t, ErrUnauthorized, a.Authorize(ben, "mytopic", PermissionRead))  // Revoked
-	require.Nil(t, a.Authorize(ben, "readme", PermissionRead))                      // Unchanged
-	require.Nil(t, a.Authorize(ben, "writeme", PermissionWrite))                    // Unchanged
-
-	// Revoke rest of the access
-	require.Nil(t, a.ResetAccess("ben", ""))
-	require.Equal(t, ErrUnauthorized, a.Authorize(ben, "readme", PermissionRead))    // Revoked
-	require.Equal(t, ErrUnauthorized, a.Authorize(ben, "wrtiteme", PermissionWrite)) // Revoked
-
-	// User list
-	users, err := a.Users()
-	require.Nil(t, err)
-	require.Equal(t, 3, len(users))
-	require.Equal(t, "phil", users[0].Name)
-	require.Equal(t, "ben", users[1].Name)
-	require.Equal(t, "*", users[2].Name)
+		everyoneGrants, err := a.Grants(Everyone)
+		require.Nil(t, err)
+		require.Equal(t, []Grant{
+			{"everyonewrite", PermissionReadWrite},
+			{"announcements", PermissionRead},
+		}, everyoneGrants)
+
+		// Ben: Before revoking
+		require.Nil(t, a.AllowAccess("ben", "mytopic", PermissionReadWrite)) // Overwrite!
+		require.Nil(t, a.AllowAccess("ben", "readme", PermissionRead))
+		require.Nil(t, a.AllowAccess("ben", "writeme", PermissionWrite))
+		require.Nil(t, a.Authorize(ben, "mytopic", PermissionRead))
+		require.Nil(t, a.Authorize(ben, "mytopic", PermissionWrite))
+		require.Nil(t, a.Authorize(ben, "readme", PermissionRead))
+		require.Nil(t, a.Authorize(ben, "writeme", PermissionWrite))
+
+		// Revoke access for "ben" to "mytopic", then check again
+		require.Nil(t, a.ResetAccess("ben", "mytopic"))
+		require.Equal(t, ErrUnauthorized, a.Authorize(ben, "mytopic", PermissionWrite)) // Revoked
+		require.Equal(t, ErrUnauthorized, a.Authorize(ben, "mytopic", PermissionRead))  // Revoked
+		require.Nil(t, a.Authorize(ben, "readme", PermissionRead))                      // Unchanged
+		require.Nil(t, a.Authorize(ben, "writeme", PermissionWrite))                    // Unchanged
+
+		// Revoke rest of the access
+		require.Nil(t, a.ResetAccess("ben", ""))
+		require.Equal(t, ErrUnauthorized, a.Authorize(ben, "readme", PermissionRead))    // Revoked
+		require.Equal(t, ErrUnauthorized, a.Authorize(ben, "wrtiteme", PermissionWrite)) // Revoked
+
+		// User list
+		users, err := a.Users()
+		require.Nil(t, err)
+		require.Equal(t, 3, len(users))
+		require.Equal(t, "phil", users[0].Name)
+		require.Equal(t, "ben", users[1].Name)
+		require.Equal(t, "*", users[2].Name)
 
-	// Remove user
-	require.Nil(t, a.RemoveUser("ben"))
-	_, err = a.User("ben")
-	require.Equal(t, ErrUserNotFound, err)
+		// Remove user
+		require.Nil(t, a.RemoveUser("ben"))
+		_, err = a.User("ben")
+		require.Equal(t, ErrUserNotFound, err)
 
-	users, err = a.Users()
-	require.Nil(t, err)
-	require.Equal(t, 2, len(users))
-	require.Equal(t, "phil", users[0].Name)
-	require.Equal(t, "*", users[1].Name)
+		users, err = a.Users()
+		require.Nil(t, err)
+		require.Equal(t, 2, len(users))
+		require.Equal(t, "phil", users[0].Name)
+		require.Equal(t, "*", users[1].Name)
+	})
 }
 
 func TestManager_ChangePassword(t *testing.T) {
-	a := newTestManager(t, PermissionDenyAll)
-	require.Nil(t, a.AddUser("phil", "phil", RoleAdmin))
+	forEachBackend(t, func(t *testing.T, filename string) {
+		a := newTestManager(t, filename, PermissionDenyAll)
+		require.Nil(t, a.AddUser("phil", "phil", RoleAdmin))
 
-	_, err := a.Authenticate("phil", "phil")
-	require.Nil(t, err)
+		_, err := a.Authenticate("phil", "phil")
+		require.Nil(t, err)
 
-	require.Nil(t, a.ChangePassword("phil", "newpass"))
-	_, err = a.Authenticate("phil", "phil")
-	require.Equal(t, ErrUnauthenticated, err)
-	_, err = a.Authenticate("phil", "newpass")
-	require.Nil(t, err)
+		require.Nil(t, a.ChangePassword("phil", "newpass"))
+		_, err = a.Authenticate("phil", "phil")
+		require.Equal(t, ErrUnauthenticated, err)
+		_, err = a.Authenticate("phil", "newpass")
+		require.Nil(t, err)
+	})
 }
 
 func TestManager_ChangeRole(t *testing.T) {
-	a := newTestManager(t, PermissionDenyAll)
-	require.Nil(t, a.AddUser("ben", "ben", RoleUser))
-	require.Nil(t, a.AllowAccess("ben", "mytopic", PermissionReadWrite))
-	require.Nil(t, a.AllowAccess("ben", "readme", PermissionRead))
+	forEachBackend(t, func(t *testing.T, filename string) {
+		a := newTestManager(t, filename, PermissionDenyAll)
+		require.Nil(t, a.AddUser("ben", "ben", RoleUser))
+		require.Nil(t, a.AllowAccess("ben", "mytopic", PermissionReadWrite))
+		require.Nil(t, a.AllowAccess("ben", "readme", PermissionRead))
 
-	ben, err := a.User("ben")
-	require.Nil(t, err)
-	require.Equal(t, RoleUser, ben.Role)
+		ben, err := a.User("ben")
+		require.Nil(t, err)
+		require.Equal(t, RoleUser, ben.Role)
 
-	benGrants, err := a.Grants("ben")
-	require.Nil(t, err)
-	require.Equal(t, 2, len(benGrants))
+		benGrants, err := a.Grants("ben")
+		require.Nil(t, err)
+		require.Equal(t, 2, len(benGrants))
 
-	require.Nil(t, a.ChangeRole("ben", RoleAdmin))
+		require.Nil(t, a.ChangeRole("ben", RoleAdmin))
 
-	ben, err = a.User("ben")
-	require.Nil(t, err)
-	require.Equal(t, RoleAdmin, ben.Role)
+		ben, err = a.User("ben")
+		require.Nil(t, err)
+		require.Equal(t, RoleAdmin, ben.Role)
 
-	benGrants, err = a.Grants("ben")
-	require.Nil(t, err)
-	require.Equal(t, 0, len(benGrants))
+		benGrants, err = a.Grants("ben")
+		require.Nil(t, err)
+		require.Equal(t, 0, len(benGrants))
+	})
+}
+
+func TestManager_SignedURLGeneration(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, filename string) {
+		a := newTestManager(t, filename, PermissionDenyAll)
+		require.Nil(t, a.AddUser("phil", "phil", RoleUser))
+		require.Nil(t, a.AddReservation("phil", "mytopic", PermissionDenyAll))
+
+		generation, err := a.SignedURLGeneration("mytopic")
+		require.Nil(t, err)
+		require.Equal(t, int64(0), generation)
+
+		require.Nil(t, a.RevokeSignedURLs("mytopic"))
+		require.Nil(t, a.RevokeSignedURLs("mytopic"))
+		generation, err = a.SignedURLGeneration("mytopic")
+		require.Nil(t, err)
+		require.Equal(t, int64(2), generation)
+
+		// Removing the reservation revokes signed URLs as well
+		require.Nil(t, a.RemoveReservations("phil", "mytopic"))
+		generation, err = a.SignedURLGeneration("mytopic")
+		require.Nil(t, err)
+		require.Equal(t, int64(3), generation)
+
+		require.Equal(t, ErrInvalidArgument, a.RevokeSignedURLs("my/topic"))
+	})
 }
 
 func TestManager_Reservations(t *testing.T) {
-	a := newTestManager(t, PermissionDenyAll)
-	require.Nil(t, a.AddUser("phil", "phil", RoleUser))
-	require.Nil(t, a.AddUser("ben", "ben", RoleUser))
-	require.Nil(t, a.AddReservation("ben", "ztopic_", PermissionDenyAll))
-	require.Nil(t, a.AddReservation("ben", "readme", PermissionRead))
-	require.Nil(t, a.AllowAccess("ben", "something-else", PermissionRead))
-
-	reservations, err := a.Reservations("ben")
-	require.Nil(t, err)
-	require.Equal(t, 2, len(reservations))
-	require.Equal(t, Reservation{
-		Topic:    "readme",
-		Owner:    PermissionReadWrite,
-		Everyone: PermissionRead,
-	}, reservations[0])
-	require.Equal(t, Reservation{
-		Topic:    "ztopic_",
-		Owner:    PermissionReadWrite,
-		Everyone: PermissionDenyAll,
-	}, reservations[1])
-
-	b, err := a.HasReservation("ben", "readme")
-	require.Nil(t, err)
-	require.True(t, b)
+	forEachBackend(t, func(t *testing.T, filename string) {
+		a := newTestManager(t, filename, PermissionDenyAll)
+		require.Nil(t, a.AddUser("phil", "phil", RoleUser))
+		require.Nil(t, a.AddUser("ben", "ben", RoleUser))
+		require.Nil(t, a.AddReservation("ben", "ztopic_", PermissionDenyAll))
+		require.Nil(t, a.AddReservation("ben", "readme", PermissionRead))
+		require.Nil(t, a.AllowAccess("ben", "something-else", PermissionRead))
+
+		reservations, err := a.Reservations("ben")
+		require.Nil(t, err)
+		require.Equal(t, 2, len(reservations))
+		require.Equal(t, Reservation{
+			Topic:    "readme",
+			Owner:    PermissionReadWrite,
+			Everyone: PermissionRead,
+		}, reservations[0])
+		require.Equal(t, Reservation{
+			Topic:    "ztopic_",
+			Owner:    PermissionReadWrite,
+			Everyone: PermissionDenyAll,
+		}, reservations[1])
+
+		b, err := a.HasReservation("ben", "readme")
+		require.Nil(t, err)
+		require.True(t, b)
 
-	b, err = a.HasReservation("ben", "ztopic_")
-	require.Nil(t, err)
-	require.True(t, b)
+		b, err = a.HasReservation("ben", "ztopic_")
+		require.Nil(t, err)
+		require.True(t, b)
 
-	b, err = a.HasReservation("ben", "ztopicX") // _ != X (used to be a SQL wildcard issue)
-	require.Nil(t, err)
-	require.False(t, b)
+		b, err = a.HasReservation("ben", "ztopicX") // _ != X (used to be a SQL wildcard issue)
+		require.Nil(t, err)
+		require.False(t, b)
 
-	b, err = a.HasReservation("notben", "readme")
-	require.Nil(t, err)
-	require.False(t, b)
+		b, err = a.HasReservation("notben", "readme")
+		require.Nil(t, err)
+		require.False(t, b)
 
-	b, err = a.HasReservation("ben", "something-else")
-	require.Nil(t, err)
-	require.False(t, b)
+		b, err = a.HasReservation("ben", "something-else")
+		require.Nil(t, err)
+		require.False(t, b)
 
-	count, err := a.ReservationsCount("ben")
-	require.Nil(t, err)
-	require.Equal(t, int64(2), count)
+		count, err := a.ReservationsCount("ben")
+		require.Nil(t, err)
+		require.Equal(t, int64(2), count)
 
-	count, err = a.ReservationsCount("phil")
-	require.Nil(t, err)
-	require.Equal(t, int64(0), count)
+		count, err = a.ReservationsCount("phil")
+		require.Nil(t, err)
+		require.Equal(t, int64(0), count)
 
-	err = a.AllowReservation("phil", "readme")
-	require.Equal(t, errTopicOwnedByOthers, err)
+		err = a.AllowReservation("phil", "readme")
+		require.Equal(t, errTopicOwnedByOthers, err)
 
-	err = a.AllowReservation("phil", "ztopic_")
-	require.Equal(t, errTopicOwnedByOthers, err)
+		err = a.AllowReservation("phil", "ztopic_")
+		require.Equal(t, errTopicOwnedByOthers, err)
 
-	err = a.AllowReservation("phil", "ztopicX")
-	require.Nil(t, err)
+		err = a.AllowReservation("phil", "ztopicX")
+		require.Nil(t, err)
 
-	err = a.AllowReservation("phil", "not-reserved")
-	require.Nil(t, err)
+		err = a.AllowReservation("phil", "not-reserved")
+		require.Nil(t, err)
 
-	// Now remove them again
-	require.Nil(t, a.RemoveReservations("ben", "ztopic_", "readme"))
+		// Now remove them again
+		require.Nil(t, a.RemoveReservations("ben", "ztopic_", "readme"))
 
-	count, err = a.ReservationsCount("ben")
-	require.Nil(t, err)
-	require.Equal(t, int64(0), count)
+		count, err = a.ReservationsCount("ben")
+		require.Nil(t, err)
+		require.Equal(t, int64(0), count)
+	})
 }
 
-func TestManager_ChangeRoleFromTierUserToAdmin(t *testing.T) {
-	a := newTestManager(t, PermissionDenyAll)
-	require.Nil(t, a.AddTier(&Tier{
-		Code:                     "pro",
-		Name:                     "ntfy Pro",
-		StripeMonthlyPriceID:     "price123",
-		MessageLimit:             5_000,
-		MessageExpiryDuration:    3 * 24 * time.Hour,
-		EmailLimit:               50,
-		ReservationLimit:         5,
-		AttachmentFileSizeLimit:  52428800,
-		AttachmentTotalSizeLimit: 524288000,
-		AttachmentExpiryDuration: 24 * time.Hour,
-	}))
-	require.Nil(t, a.AddUser("ben", "ben", RoleUser))
-	require.Nil(t, a.ChangeTier("ben", "pro"))
-	require.Nil(t, a.AddReservation("ben", "mytopic", PermissionDenyAll))
-
-	ben, err := a.User("ben")
-	require.Nil(t, err)
-	require.Equal(t, RoleUser, ben.Role)
-	require.Equal(t, "pro", ben.Tier.Code)
-	require.Equal(t, int64(5000), ben.Tier.MessageLimit)
-	require.Equal(t, 3*24*time.Hour, ben.Tier.MessageExpiryDuration)
-	require.Equal(t, int64(50), ben.Tier.EmailLimit)
-	require.Equal(t, int64(5), ben.Tier.ReservationLimit)
-	require.Equal(t, int64(52428800), ben.Tier.AttachmentFileSizeLimit)
-	require.Equal(t, int64(524288000), ben.Tier.AttachmentTotalSizeLimit)
-	require.Equal(t, 24*time.Hour, ben.Tier.AttachmentExpiryDuration)
+func TestManager_TopicRetention(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, filename string) {
+		a := newTestManager(t, filename, PermissionDenyAll)
+		require.Nil(t, a.AddUser("ben", "ben", RoleUser))
+		require.Nil(t, a.AddReservation("ben", "mytopic", PermissionDenyAll))
 
-	benGrants, err := a.Grants("ben")
-	require.Nil(t, err)
-	require.Equal(t, 1, len(benGrants))
-	require.Equal(t, PermissionReadWrite, benGrants[0].Allow)
+		_, err := a.TopicRetention("mytopic")
+		require.Equal(t, ErrTopicRetentionNotFound, err)
 
-	everyoneGrants, err := a.Grants(Everyone)
-	require.Nil(t, err)
-	require.Equal(t, 1, len(everyoneGrants))
-	require.Equal(t, PermissionDenyAll, everyoneGrants[0].Allow)
+		require.Nil(t, a.ChangeTopicRetention(&TopicRetention{Topic: "mytopic", MaxAge: 2 * time.Hour}))
+		require.Nil(t, a.ChangeTopicRetention(&TopicRetention{Topic: "another_topic", MaxMessages: 10}))
+		require.Nil(t, a.ChangeTopicRetention(&TopicRetention{Topic: "mytopic", MaxAge: 3 * time.Hour, MaxMessages: 5})) // Overwrite
+		require.Equal(t, ErrInvalidArgument, a.ChangeTopicRetention(&TopicRetention{Topic: "mytopic", MaxMessages: -1}))
+		require.Equal(t, ErrInvalidArgument, a.ChangeTopicRetention(&TopicRetention{Topic: "not a topic", MaxMessages: 1}))
 
-	benReservations, err := a.Reservations("ben")
-	require.Nil(t, err)
-	require.Equal(t, 1, len(benReservations))
-	require.Equal(t, "mytopic", benReservations[0].Topic)
-	require.Equal(t, PermissionReadWrite, benReservations[0].Owner)
-	require.Equal(t, PermissionDenyAll, benReservations[0].Everyone)
+		retention, err := a.TopicRetention("mytopic")
+		require.Nil(t, err)
+		require.Equal(t, &TopicRetention{Topic: "mytopic", MaxAge: 3 * time.Hour, MaxMessages: 5}, retention)
 
-	// Switch to admin, this should remove all grants and owned ACL entries
-	require.Nil(t, a.ChangeRole("ben", RoleAdmin))
+		retentions, err := a.TopicRetentions()
+		require.Nil(t, err)
+		require.Equal(t, 2, len(retentions))
+		require.Equal(t, "another_topic", retentions[0].Topic)
+		require.Equal(t, time.Duration(0), retentions[0].MaxAge)
+		require.Equal(t, int64(10), retentions[0].MaxMessages)
+		require.Equal(t, "mytopic", retentions[1].Topic)
+
+		// Setting no limits removes the retention policy
+		require.Nil(t, a.ChangeTopicRetention(&TopicRetention{Topic: "another_topic"}))
+		_, err = a.TopicRetention("another_topic")
+		require.Equal(t, ErrTopicRetentionNotFound, err)
+
+		// Removing the reservation removes the retention policy
+		require.Nil(t, a.RemoveReservations("ben", "mytopic"))
+		retentions, err = a.TopicRetentions()
+		require.Nil(t, err)
+		require.Equal(t, 0, len(retentions))
+	})
+}
 
-	benGrants, err = a.Grants("ben")
-	require.Nil(t, err)
-	require.Equal(t, 0, len(benGrants))
+func TestManager_TopicEscalation(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, filename string) {
+		a := newTestManager(t, filename, PermissionDenyAll)
+		require.Nil(t, a.AddUser("ben", "ben", RoleUser))
+		require.Nil(t, a.AddReservation("ben", "mytopic", PermissionDenyAll))
 
-	everyoneGrants, err = a.Grants(Everyone)
-	require.Nil(t, err)
-	require.Equal(t, 0, len(everyoneGrants))
+		_, err := a.TopicEscalation("mytopic")
+		require.Equal(t, ErrTopicEscalationNotFound, err)
+
+		require.Nil(t, a.ChangeTopicEscalation(&TopicEscalation{Topic: "mytopic", Delay: 5 * time.Minute, ForwardTopic: "oncall"}))
+		require.Nil(t, a.ChangeTopicEscalation(&TopicEscalation{Topic: "another_topic", Delay: time.Hour, Email: "phil@example.com"}))
+		require.Nil(t, a.ChangeTopicEscalation(&TopicEscalation{Topic: "mytopic", Delay: 10 * time.Minute, ForwardTopic: "oncall", PhoneNumber: "+12223334444"})) // Overwrite
+		require.Equal(t, ErrInvalidArgument, a.ChangeTopicEscalation(&TopicEscalation{Topic: "mytopic", Delay: 10 * time.Second, Email: "phil@example.com"}))
+		require.Equal(t, ErrInvalidArgument, a.ChangeTopicEscalation(&TopicEscalation{Topic: "mytopic", Delay: time.Hour, ForwardTopic: "not a topic"}))
+		require.Equal(t, ErrInvalidArgument, a.ChangeTopicEscalation(&TopicEscalation{Topic: "not a topic", Delay: time.Hour, Email: "phil@example.com"}))
+
+		escalation, err := a.TopicEscalation("mytopic")
+		require.Nil(t, err)
+		require.Equal(t, &TopicEscalation{Topic: "mytopic", Delay: 10 * time.Minute, ForwardTopic: "oncall", PhoneNumber: "+12223334444"}, escalation)
+
+		escalations, err := a.TopicEscalations()
+		require.Nil(t, err)
+		require.Equal(t, 2, len(escalations))
+		require.Equal(t, "another_topic", escalations[0].Topic)
+		require.Equal(t, "phil@example.com", escalations[0].Email)
+		require.Equal(t, "mytopic", escalations[1].Topic)
+
+		// Setting no steps removes the escalation policy
+		require.Nil(t, a.ChangeTopicEscalation(&TopicEscalation{Topic: "another_topic", Delay: time.Hour}))
+		_, err = a.TopicEscalation("another_topic")
+		require.Equal(t, ErrTopicEscalationNotFound, err)
+
+		// Removing the reservation removes the escalation policy
+		require.Nil(t, a.RemoveReservations("ben", "mytopic"))
+		escalations, err = a.TopicEscalations()
+		require.Nil(t, err)
+		require.Equal(t, 0, len(escalations))
+	})
+}
+
+func TestManager_Groups(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, filename string) {
+		a := newTestManager(t, filename, PermissionDenyAll)
+		require.Nil(t, a.AddUser("ben", "ben", RoleUser))
+		require.Nil(t, a.AddUser("phil", "phil", RoleUser))
+
+		require.Nil(t, a.AddGroup("ops"))
+		require.Nil(t, a.AddGroup("dev"))
+		require.Equal(t, ErrGroupExists, a.AddGroup("ops"))
+		require.Equal(t, ErrInvalidArgument, a.AddGroup("not a group"))
+
+		require.Nil(t, a.AddGroupMember("ops", "phil"))
+		require.Nil(t, a.AddGroupMember("ops", "ben"))
+		require.Nil(t, a.AddGroupMember("ops", "ben")) // Already a member
+		require.Nil(t, a.AddGroupMember("dev", "ben"))
+		require.Equal(t, ErrGroupNotFound, a.AddGroupMember("sales", "ben"))
+		require.Equal(t, ErrUserNotFound, a.AddGroupMember("ops", "nobody"))
+		require.Equal(t, ErrInvalidArgument, a.AddGroupMember("ops", Everyone))
+
+		require.Nil(t, a.AllowGroupAccess("ops", "alerts*", PermissionReadWrite))
+		require.Nil(t, a.AllowGroupAccess("ops", "status", PermissionRead))
+		require.Nil(t, a.AllowGroupAccess("ops", "status", PermissionWrite)) // Overwrite
+		require.Equal(t, ErrGroupNotFound, a.AllowGroupAccess("sales", "alerts", PermissionRead))
+
+		group, err := a.Group("ops")
+		require.Nil(t, err)
+		require.Equal(t, "ops", group.Name)
+		require.True(t, strings.HasPrefix(group.ID, "gr_"))
+		require.Equal(t, []string{"ben", "phil"}, group.Members)
+		require.Equal(t, []Grant{
+			{TopicPattern: "alerts*", Allow: PermissionReadWrite},
+			{TopicPattern: "status", Allow: PermissionWrite},
+		}, group.Grants)
+
+		groups, err := a.Groups()
+		require.Nil(t, err)
+		require.Equal(t, 2, len(groups))
+		require.Equal(t, "dev", groups[0].Name)
+		require.Equal(t, []string{"ben"}, groups[0].Members)
+		require.Equal(t, 0, len(groups[0].Grants))
+		require.Equal(t, "ops", groups[1].Name)
+
+		userGroups, err := a.UserGroups("ben")
+		require.Nil(t, err)
+		require.Equal(t, []string{"dev", "ops"}, userGroups)
+
+		// Remove member, access and group
+		require.Nil(t, a.RemoveGroupMember("ops", "phil"))
+		require.Nil(t, a.ResetGroupAccess("ops", "status"))
+		group, err = a.Group("ops")
+		require.Nil(t, err)
+		require.Equal(t, []string{"ben"}, group.Members)
+		require.Equal(t, []Grant{{TopicPattern: "alerts*", Allow: PermissionReadWrite}}, group.Grants)
+
+		require.Nil(t, a.ResetGroupAccess("ops", ""))
+		require.Nil(t, a.RemoveGroup("dev"))
+		_, err = a.Group("dev")
+		require.Equal(t, ErrGroupNotFound, err)
+		userGroups, err = a.UserGroups("ben")
+		require.Nil(t, err)
+		require.Equal(t, []string{"ops"}, userGroups)
+
+		// Removing a user removes the membership
+		require.Nil(t, a.RemoveUser("ben"))
+		group, err = a.Group("ops")
+		require.Nil(t, err)
+		require.Equal(t, 0, len(group.Members))
+		require.Equal(t, 0, len(group.Grants))
+	})
+}
+
+func TestManager_Groups_Authorize(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, filename string) {
+		a := newTestManager(t, filename, PermissionDenyAll)
+		require.Nil(t, a.AddUser("ben", "ben", RoleUser))
+		require.Nil(t, a.AddUser("phil", "phil", RoleUser))
+		require.Nil(t, a.AddGroup("ops"))
+		require.Nil(t, a.AddGroup("dev"))
+		require.Nil(t, a.AddGroupMember("ops", "ben"))
+		require.Nil(t, a.AddGroupMember("dev", "ben"))
+		ben, err := a.User("ben")
+		require.Nil(t, err)
+		phil, err := a.User("phil")
+		require.Nil(t, err)
+
+		// Group grants apply to members only
+		require.Nil(t, a.AllowGroupAccess("ops", "alerts*", PermissionRead))
+		require.Nil(t, a.Authorize(ben, "alerts-db", PermissionRead))
+		require.Equal(t, ErrUnauthorized, a.Authorize(ben, "alerts-db", PermissionWrite))
+		require.Equal(t, ErrUnauthorized, a.Authorize(phil, "alerts-db", PermissionRead))
+		require.Equal(t, ErrUnauthorized, a.Authorize(nil, "alerts-db", PermissionRead))
+
+		// Equally specific grants of multiple groups are combined
+		require.Nil(t, a.AllowGroupAccess("dev", "alerts*", PermissionWrite))
+		require.Nil(t, a.Authorize(ben, "alerts-db", PermissionRead))
+		require.Nil(t, a.Authorize(ben, "alerts-db", PermissionWrite))
+
+		// More specific group grants win over more generic ones
+		require.Nil(t, a.AllowGroupAccess("dev", "alerts-secret", PermissionDenyAll))
+		require.Equal(t, ErrUnauthorized, a.Authorize(ben, "alerts-secret", PermissionRead))
+
+		// Group grants win over everyone grants, even if the everyone grant is more specific
+		require.Nil(t, a.AllowAccess(Everyone, "alerts-public", PermissionDenyAll))
+		require.Nil(t, a.Authorize(ben, "alerts-public", PermissionWrite))
+		require.Equal(t, ErrUnauthorized, a.Authorize(phil, "alerts-public", PermissionRead))
+
+		// User grants win over group grants, even if the group grant is more specific
+		require.Nil(t, a.AllowAccess("ben", "alert*", PermissionRead))
+		require.Nil(t, a.Authorize(ben, "alerts-secret", PermissionRead))
+		require.Equal(t, ErrUnauthorized, a.Authorize(ben, "alerts-db", PermissionWrite))
+
+		// Removing the membership removes the access
+		require.Nil(t, a.ResetAccess("ben", ""))
+		require.Nil(t, a.RemoveGroupMember("ops", "ben"))
+		require.Nil(t, a.RemoveGroupMember("dev", "ben"))
+		require.Equal(t, ErrUnauthorized, a.Authorize(ben, "alerts-db", PermissionRead))
+	})
+}
+
+func TestManager_TopicWebhooks(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, filename string) {
+		a := newTestManager(t, filename, PermissionDenyAll)
+		require.Nil(t, a.AddUser("ben", "ben", RoleUser))
+		require.Nil(t, a.AddReservation("ben", "mytopic", PermissionDenyAll))
+
+		webhook1, err := a.AddTopicWebhook("mytopic", "https://example.com/hook1")
+		require.Nil(t, err)
+		require.True(t, strings.HasPrefix(webhook1.ID, "wh_"))
+		require.Len(t, webhook1.Secret, 32)
+		webhook2, err := a.AddTopicWebhook("mytopic", "https://example.com/hook2")
+		require.Nil(t, err)
+		_, err = a.AddTopicWebhook("another_topic", "https://example.com/hook3")
+		require.Nil(t, err)
+		_, err = a.AddTopicWebhook("not a topic", "https://example.com/hook4")
+		require.Equal(t, ErrInvalidArgument, err)
+
+		webhooks, err := a.TopicWebhooks("mytopic")
+		require.Nil(t, err)
+		require.Equal(t, 2, len(webhooks))
+		require.ElementsMatch(t, []*TopicWebhook{webhook1, webhook2}, webhooks) // Same second, so order is not guaranteed
+
+		webhook, err := a.TopicWebhook(webhook2.ID)
+		require.Nil(t, err)
+		require.Equal(t, "https://example.com/hook2", webhook.URL)
+
+		// Webhooks can only be removed via their own topic
+		require.Equal(t, ErrTopicWebhookNotFound, a.RemoveTopicWebhook("another_topic", webhook2.ID))
+		require.Nil(t, a.RemoveTopicWebhook("mytopic", webhook2.ID))
+		_, err = a.TopicWebhook(webhook2.ID)
+		require.Equal(t, ErrTopicWebhookNotFound, err)
+
+		// Removing the reservation removes the webhooks
+		require.Nil(t, a.RemoveReservations("ben", "mytopic"))
+		webhooks, err = a.TopicWebhooks("mytopic")
+		require.Nil(t, err)
+		require.Equal(t, 0, len(webhooks))
+		webhooks, err = a.TopicWebhooks("another_topic")
+		require.Nil(t, err)
+		require.Equal(t, 1, len(webhooks))
+	})
+}
+
+func TestManager_TopicDataRemovedWithOwner(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, filename string) {
+		a := newTestManager(t, filename, PermissionDenyAll)
+		addTopicData := func(topic string) {
+			require.Nil(t, a.ChangeTopicRetention(&TopicRetention{Topic: topic, MaxMessages: 10}))
+			require.Nil(t, a.ChangeTopicEscalation(&TopicEscalation{Topic: topic, Delay: time.Hour, ForwardTopic: "oncall"}))
+			_, err := a.AddTopicWebhook(topic, "https://example.com/hook")
+			require.Nil(t, err)
+		}
+		requireTopicData := func(topic string, exists bool) {
+			_, err := a.TopicRetention(topic)
+			require.Equal(t, exists, err == nil)
+			_, err = a.TopicEscalation(topic)
+			require.Equal(t, exists, err == nil)
+			webhooks, err := a.TopicWebhooks(topic)
+			require.Nil(t, err)
+			require.Equal(t, exists, len(webhooks) > 0)
+		}
+		require.Nil(t, a.AddUser("ben", "ben", RoleUser))
+		require.Nil(t, a.AddUser("phil", "phil", RoleUser))
+		require.Nil(t, a.AddUser("lisa", "lisa", RoleUser))
+		require.Nil(t, a.AddReservation("ben", "bentopic", PermissionDenyAll))
+		require.Nil(t, a.AddReservation("phil", "philtopic1", PermissionDenyAll))
+		require.Nil(t, a.AddReservation("phil", "philtopic2", PermissionDenyAll))
+		require.Nil(t, a.AddReservation("lisa", "lisa_topic", PermissionDenyAll))
+		require.Nil(t, a.AllowAccess("lisa", "othertopic", PermissionReadWrite))
+		for _, topic := range []string{"bentopic", "philtopic1", "philtopic2", "lisa_topic", "othertopic"} {
+			addTopicData(topic)
+		}
+
+		// Removing a user removes the data of their reserved topics
+		require.Nil(t, a.RemoveUser("ben"))
+		requireTopicData("bentopic", false)
+		requireTopicData("philtopic1", true)
+
+		// Resetting the access for a reserved topic removes its data
+		require.Nil(t, a.ResetAccess("phil", "philtopic1"))
+		requireTopicData("philtopic1", false)
+		requireTopicData("philtopic2", true)
+
+		// Marking a user as removed removes the data of their reserved topics, but not of other topics
+		lisa, err := a.User("lisa")
+		require.Nil(t, err)
+		require.Nil(t, a.MarkUserRemoved(lisa))
+		requireTopicData("lisa_topic", false)
+		requireTopicData("othertopic", true)
+
+		// Resetting all access removes the data of all reserved topics
+		require.Nil(t, a.ResetAccess("", ""))
+		requireTopicData("philtopic2", false)
+		requireTopicData("othertopic", true)
+
+		// A new owner does not inherit webhooks and escalations of an unreserved topic, but the retention is kept
+		require.Nil(t, a.AddReservation("phil", "othertopic", PermissionDenyAll))
+		_, err = a.TopicRetention("othertopic")
+		require.Nil(t, err)
+		_, err = a.TopicEscalation("othertopic")
+		require.Equal(t, ErrTopicEscalationNotFound, err)
+		webhooks, err := a.TopicWebhooks("othertopic")
+		require.Nil(t, err)
+		require.Empty(t, webhooks)
+	})
+}
+
+func TestManager_ChangeRoleFromTierUserToAdmin(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, filename string) {
+		a := newTestManager(t, filename, PermissionDenyAll)
+		require.Nil(t, a.AddTier(&Tier{
+			Code:                     "pro",
+			Name:                     "ntfy Pro",
+			StripeMonthlyPriceID:     "price123",
+			MessageLimit:             5_000,
+			MessageExpiryDuration:    3 * 24 * time.Hour,
+			EmailLimit:               50,
+			ReservationLimit:         5,
+			AttachmentFileSizeLimit:  52428800,
+			AttachmentTotalSizeLimit: 524288000,
+			AttachmentExpiryDuration: 24 * time.Hour,
+		}))
+		require.Nil(t, a.AddUser("ben", "ben", RoleUser))
+		require.Nil(t, a.ChangeTier("ben", "pro"))
+		require.Nil(t, a.AddReservation("ben", "mytopic", PermissionDenyAll))
+
+		ben, err := a.User("ben")
+		require.Nil(t, err)
+		require.Equal(t, RoleUser, ben.Role)
+		require.Equal(t, "pro", ben.Tier.Code)
+		require.Equal(t, int64(5000), ben.Tier.MessageLimit)
+		require.Equal(t, 3*24*time.Hour, ben.Tier.MessageExpiryDuration)
+		require.Equal(t, int64(50), ben.Tier.EmailLimit)
+		require.Equal(t, int64(5), ben.Tier.ReservationLimit)
+		require.Equal(t, int64(52428800), ben.Tier.AttachmentFileSizeLimit)
+		require.Equal(t, int64(524288000), ben.Tier.AttachmentTotalSizeLimit)
+		require.Equal(t, 24*time.Hour, ben.Tier.AttachmentExpiryDuration)
+
+		benGrants, err := a.Grants("ben")
+		require.Nil(t, err)
+		require.Equal(t, 1, len(benGrants))
+		require.Equal(t, PermissionReadWrite, benGrants[0].Allow)
+
+		everyoneGrants, err := a.Grants(Everyone)
+		require.Nil(t, err)
+		require.Equal(t, 1, len(everyoneGrants))
+		require.Equal(t, PermissionDenyAll, everyoneGrants[0].Allow)
+
+		benReservations, err := a.Reservations("ben")
+		require.Nil(t, err)
+		require.Equal(t, 1, len(benReservations))
+		require.Equal(t, "mytopic", benReservations[0].Topic)
+		require.Equal(t, PermissionReadWrite, benReservations[0].Owner)
+		require.Equal(t, PermissionDenyAll, benReservations[0].Everyone)
+
+		// Switch to admin, this should remove all grants and owned ACL entries
+		require.Nil(t, a.ChangeRole("ben", RoleAdmin))
+
+		benGrants, err = a.Grants("ben")
+		require.Nil(t, err)
+		require.Equal(t, 0, len(benGrants))
+
+		everyoneGrants, err = a.Grants(Everyone)
+		require.Nil(t, err)
+		require.Equal(t, 0, len(everyoneGrants))
+	})
 }
 
 func TestManager_Token_Valid(t *testing.T) {
-	a := newTestManager(t, PermissionDenyAll)
-	require.Nil(t, a.AddUser("ben", "ben", RoleUser))
+	forEachBackend(t, func(t *testing.T, filename string) {
+		a := newTestManager(t, filename, PermissionDenyAll)
+		require.Nil(t, a.AddUser("ben", "ben", RoleUser))
 
-	u, err := a.User("ben")
-	require.Nil(t, err)
+		u, err := a.User("ben")
+		require.Nil(t, err)
 
-	// Create token for user
-	token, err := a.CreateToken(u.ID, "some label", time.Now().Add(72*time.Hour), netip.IPv4Unspecified())
-	require.Nil(t, err)
-	require.NotEmpty(t, token.Value)
-	require.Equal(t, "some label", token.Label)
-	require.True(t, time.Now().Add(71*time.Hour).Unix() < token.Expires.Unix())
+		// Create token for user
+		token, err := a.CreateToken(u.ID, "some label", time.Now().Add(72*time.Hour), netip.IPv4Unspecified(), nil)
+		require.Nil(t, err)
+		require.NotEmpty(t, token.Value)
+		require.Equal(t, "some label", token.Label)
+		require.True(t, time.Now().Add(71*time.Hour).Unix() < token.Expires.Unix())
 
-	u2, err := a.AuthenticateToken(token.Value)
-	require.Nil(t, err)
-	require.Equal(t, u.Name, u2.Name)
-	require.Equal(t, token.Value, u2.Token)
+		u2, err := a.AuthenticateToken(token.Value)
+		require.Nil(t, err)
+		require.Equal(t, u.Name, u2.Name)
+		require.Equal(t, token.Value, u2.Token)
 
-	token2, err := a.Token(u.ID, token.Value)
-	require.Nil(t, err)
-	require.Equal(t, token.Value, token2.Value)
-	require.Equal(t, "some label", token2.Label)
+		token2, err := a.Token(u.ID, token.Value)
+		require.Nil(t, err)
+		require.Equal(t, token.Value, token2.Value)
+		require.Equal(t, "some label", token2.Label)
 
-	tokens, err := a.Tokens(u.ID)
-	require.Nil(t, err)
-	require.Equal(t, 1, len(tokens))
-	require.Equal(t, "some label", tokens[0].Label)
+		tokens, err := a.Tokens(u.ID)
+		require.Nil(t, err)
+		require.Equal(t, 1, len(tokens))
+		require.Equal(t, "some label", tokens[0].Label)
 
-	tokens, err = a.Tokens("u_notauser")
-	require.Nil(t, err)
-	require.Equal(t, 0, len(tokens))
+		tokens, err = a.Tokens("u_notauser")
+		require.Nil(t, err)
+		require.Equal(t, 0, len(tokens))
 
-	// Remove token and auth again
-	require.Nil(t, a.RemoveToken(u2.ID, u2.Token))
-	u3, err := a.AuthenticateToken(token.Value)
-	require.Equal(t, ErrUnauthenticated, err)
-	require.Nil(t, u3)
+		// Remove token and auth again
+		require.Nil(t, a.RemoveToken(u2.ID, u2.Token))
+		u3, err := a.AuthenticateToken(token.Value)
+		require.Equal(t, ErrUnauthenticated, err)
+		require.Nil(t, u3)
 
-	tokens, err = a.Tokens(u.ID)
-	require.Nil(t, err)
-	require.Equal(t, 0, len(tokens))
+		tokens, err = a.Tokens(u.ID)
+		require.Nil(t, err)
+		require.Equal(t, 0, len(tokens))
+	})
 }
 
-func TestManager_Token_Invalid(t *testing.T) {
-	a := newTestManager(t, PermissionDenyAll)
-	require.Nil(t, a.AddUser("ben", "ben", RoleUser))
+func TestManager_Token_Scopes(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, filename string) {
+		a := newTestManager(t, filename, PermissionDenyAll)
+		require.Nil(t, a.AddUser("phil", "phil", RoleAdmin))
+		require.Nil(t, a.AddUser("ben", "ben", RoleUser))
+		require.Nil(t, a.AllowAccess("ben", "ci-*", PermissionReadWrite))
+		require.Nil(t, a.AllowAccess("ben", "private", PermissionReadWrite))
+
+		// Scoped token for a regular user
+		ben, err := a.User("ben")
+		require.Nil(t, err)
+		token, err := a.CreateToken(ben.ID, "ci", time.Unix(0, 0), netip.IPv4Unspecified(), []Grant{
+			{TopicPattern: "ci-*", Allow: PermissionWrite},
+			{TopicPattern: "ci-results", Allow: PermissionRead},
+			{TopicPattern: "other", Allow: PermissionReadWrite},
+		})
+		require.Nil(t, err)
+		token2, err := a.Token(ben.ID, token.Value)
+		require.Nil(t, err)
+		require.Equal(t, token.Scopes, token2.Scopes)
+
+		u, err := a.AuthenticateToken(token.Value)
+		require.Nil(t, err)
+		require.Nil(t, a.Authorize(u, "ci-builds", PermissionWrite))
+		require.Equal(t, ErrUnauthorized, a.Authorize(u, "ci-builds", PermissionRead))
+		require.Nil(t, a.Authorize(u, "ci-results", PermissionRead))  // Matching scopes are combined
+		require.Nil(t, a.Authorize(u, "ci-results", PermissionWrite)) // Matching scopes are combined
+		require.Equal(t, ErrUnauthorized, a.Authorize(u, "private", PermissionRead))
+		require.Equal(t, ErrUnauthorized, a.Authorize(u, "other", PermissionRead)) // Scopes never grant more than the user has
+
+		// Unscoped tokens and password logins are not affected
+		u, err = a.Authenticate("ben", "ben")
+		require.Nil(t, err)
+		require.Nil(t, a.Authorize(u, "private", PermissionRead))
+		token, err = a.CreateToken(ben.ID, "", time.Unix(0, 0), netip.IPv4Unspecified(), nil)
+		require.Nil(t, err)
+		u, err = a.AuthenticateToken(token.Value)
+		require.Nil(t, err)
+		require.Nil(t, a.Authorize(u, "private", PermissionRead))
 
-	u, err := a.AuthenticateToken(strings.Repeat("x", 32)) // 32 == token length
-	require.Nil(t, u)
-	require.Equal(t, ErrUnauthenticated, err)
+		// Scopes also apply to admins
+		phil, err := a.User("phil")
+		require.Nil(t, err)
+		token, err = a.CreateToken(phil.ID, "", time.Unix(0, 0), netip.IPv4Unspecified(), []Grant{{TopicPattern: "alerts", Allow: PermissionRead}})
+		require.Nil(t, err)
+		u, err = a.AuthenticateToken(token.Value)
+		require.Nil(t, err)
+		require.Nil(t, a.Authorize(u, "alerts", PermissionRead))
+		require.Equal(t, ErrUnauthorized, a.Authorize(u, "alerts", PermissionWrite))
+		require.Equal(t, ErrUnauthorized, a.Authorize(u, "anything", PermissionRead))
 
-	u, err = a.AuthenticateToken("not long enough anyway")
-	require.Nil(t, u)
-	require.Equal(t, ErrUnauthenticated, err)
+		// Invalid scopes
+		_, err = a.CreateToken(ben.ID, "", time.Unix(0, 0), netip.IPv4Unspecified(), []Grant{{TopicPattern: "not/valid", Allow: PermissionRead}})
+		require.Equal(t, ErrInvalidArgument, err)
+	})
 }
 
-func TestManager_Token_NotFound(t *testing.T) {
-	a := newTestManager(t, PermissionDenyAll)
-	_, err := a.Token("u_bla", "notfound")
-	require.Equal(t, ErrTokenNotFound, err)
+func TestManager_TwoFactor(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, filename string) {
+		a := newTestManager(t, filename, PermissionDenyAll)
+		require.Nil(t, a.AddUser("phil", "phil", RoleUser))
+		phil, err := a.User("phil")
+		require.Nil(t, err)
+
+		// Not enabled yet
+		enabled, err := a.TwoFactorEnabled(phil.ID)
+		require.Nil(t, err)
+		require.False(t, enabled)
+		_, err = a.EnableTwoFactor(phil.ID, "123456")
+		require.Equal(t, ErrTwoFactorNotFound, err)
+		require.Equal(t, ErrTwoFactorNotFound, a.VerifyTwoFactor(phil.ID, "123456"))
+
+		// Enroll; the secret is only active once it was confirmed
+		secret, err := a.BeginTwoFactor(phil.ID)
+		require.Nil(t, err)
+		enabled, err = a.TwoFactorEnabled(phil.ID)
+		require.Nil(t, err)
+		require.False(t, enabled)
+		_, err = a.EnableTwoFactor(phil.ID, "000000x")
+		require.Equal(t, ErrTwoFactorCodeInvalid, err)
+		recoveryCodes, err := a.EnableTwoFactor(phil.ID, testTOTPCode(t, secret, time.Now()))
+		require.Nil(t, err)
+		require.Len(t, recoveryCodes, recoveryCodeCount)
+		enabled, err = a.TwoFactorEnabled(phil.ID)
+		require.Nil(t, err)
+		require.True(t, enabled)
+		_, err = a.BeginTwoFactor(phil.ID)
+		require.Equal(t, ErrTwoFactorEnabled, err)
+
+		// TOTP codes cannot be reused
+		require.Equal(t, ErrTwoFactorCodeInvalid, a.VerifyTwoFactor(phil.ID, testTOTPCode(t, secret, time.Now())))
+		require.Nil(t, a.VerifyTwoFactor(phil.ID, testTOTPCode(t, secret, time.Now().Add(totpPeriod))))
+		require.Equal(t, ErrTwoFactorCodeInvalid, a.VerifyTwoFactor(phil.ID, testTOTPCode(t, secret, time.Now().Add(totpPeriod))))
+		require.Equal(t, ErrTwoFactorCodeInvalid, a.VerifyTwoFactor(phil.ID, testTOTPCode(t, secret, time.Now().Add(5*totpPeriod))))
+
+		// Recovery codes can only be used once
+		require.Nil(t, a.VerifyTwoFactor(phil.ID, strings.ToUpper(recoveryCodes[0])))
+		require.Equal(t, ErrTwoFactorCodeInvalid, a.VerifyTwoFactor(phil.ID, recoveryCodes[0]))
+		require.Nil(t, a.VerifyTwoFactor(phil.ID, strings.ReplaceAll(recoveryCodes[1], "-", "")))
+
+		// Disable
+		require.Nil(t, a.DisableTwoFactor(phil.ID))
+		enabled, err = a.TwoFactorEnabled(phil.ID)
+		require.Nil(t, err)
+		require.False(t, enabled)
+		require.Equal(t, ErrTwoFactorNotFound, a.VerifyTwoFactor(phil.ID, recoveryCodes[2]))
+		require.Nil(t, a.DisableTwoFactor(phil.ID))
+
+		// Deleting a user deletes the two-factor secret
+		secret, err = a.BeginTwoFactor(phil.ID)
+		require.Nil(t, err)
+		_, err = a.EnableTwoFactor(phil.ID, testTOTPCode(t, secret, time.Now()))
+		require.Nil(t, err)
+		require.Nil(t, a.RemoveUser("phil"))
+		enabled, err = a.TwoFactorEnabled(phil.ID)
+		require.Nil(t, err)
+		require.False(t, enabled)
+	})
 }
 
-func TestManager_Token_Expire(t *testing.T) {
-	a := newTestManager(t, PermissionDenyAll)
-	require.Nil(t, a.AddUser("ben", "ben", RoleUser))
+func TestManager_PasswordPolicy(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, filename string) {
+		a := newTestManager(t, filename, PermissionDenyAll)
+		a.SetPasswordPolicy(&PasswordPolicy{MinLength: 8, DisallowUsername: true})
 
-	u, err := a.User("ben")
-	require.Nil(t, err)
+		require.ErrorIs(t, a.AddUser("phil", "short", RoleUser), ErrPasswordPolicy)
+		require.ErrorIs(t, a.AddUser("phil", "phil12345", RoleUser), ErrPasswordPolicy)
+		_, err := a.User("phil")
+		require.Equal(t, ErrUserNotFound, err)
 
-	// Create tokens for user
-	token1, err := a.CreateToken(u.ID, "", time.Now().Add(72*time.Hour), netip.IPv4Unspecified())
-	require.Nil(t, err)
-	require.NotEmpty(t, token1.Value)
-	require.True(t, time.Now().Add(71*time.Hour).Unix() < token1.Expires.Unix())
+		require.Nil(t, a.AddUser("phil", "long enough", RoleUser))
+		require.ErrorIs(t, a.ChangePassword("phil", "short"), ErrPasswordPolicy)
+		_, err = a.Authenticate("phil", "long enough")
+		require.Nil(t, err)
+		require.Nil(t, a.ChangePassword("phil", "even longer"))
+		_, err = a.Authenticate("phil", "even longer")
+		require.Nil(t, err)
 
-	token2, err := a.CreateToken(u.ID, "", time.Now().Add(72*time.Hour), netip.IPv4Unspecified())
-	require.Nil(t, err)
-	require.NotEmpty(t, token2.Value)
-	require.NotEqual(t, token1.Value, token2.Value)
-	require.True(t, time.Now().Add(71*time.Hour).Unix() < token2.Expires.Unix())
+		// Provisioned users get a random password, which is not subject to the policy
+		a.SetPasswordPolicy(&PasswordPolicy{MinLength: 100})
+		require.Nil(t, a.AddProvisionedUser("ben", RoleUser))
+		ben, err := a.User("ben")
+		require.Nil(t, err)
+		require.Equal(t, RoleUser, ben.Role)
+	})
+}
 
-	// See that tokens work
-	_, err = a.AuthenticateToken(token1.Value)
-	require.Nil(t, err)
+func TestManager_Lockout(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, filename string) {
+		a := newTestManager(t, filename, PermissionDenyAll)
+		a.SetLockout(3, time.Hour)
+		require.Nil(t, a.AddUser("phil", "phil", RoleUser))
+		require.Nil(t, a.AddUser("ben", "ben", RoleUser))
+
+		// Successful login resets the counter
+		for i := 0; i < 2; i++ {
+			_, err := a.Authenticate("phil", "wrong")
+			require.Equal(t, ErrUnauthenticated, err)
+		}
+		_, err := a.Authenticate("phil", "phil")
+		require.Nil(t, err)
+		for i := 0; i < 2; i++ {
+			_, err := a.Authenticate("phil", "wrong")
+			require.Equal(t, ErrUnauthenticated, err)
+		}
+		_, err = a.Authenticate("phil", "phil")
+		require.Nil(t, err)
 
-	_, err = a.AuthenticateToken(token2.Value)
-	require.Nil(t, err)
+		// Third consecutive failure locks the user, even for the correct password
+		for i := 0; i < 3; i++ {
+			_, err := a.Authenticate("phil", "wrong")
+			require.Equal(t, ErrUnauthenticated, err)
+		}
+		_, err = a.Authenticate("phil", "phil")
+		require.Equal(t, ErrUserLocked, err)
+		_, err = a.Authenticate("phil", "wrong")
+		require.Equal(t, ErrUserLocked, err)
+
+		// Other users are not affected
+		_, err = a.Authenticate("ben", "ben")
+		require.Nil(t, err)
 
-	// Modify token expiration in database
-	_, err = a.db.Exec("UPDATE user_token SET expires = 1 WHERE token = ?", token1.Value)
-	require.Nil(t, err)
+		// Unlock
+		require.Nil(t, a.UnlockUser("phil"))
+		_, err = a.Authenticate("phil", "phil")
+		require.Nil(t, err)
+		require.Equal(t, ErrUserNotFound, a.UnlockUser("nobody"))
+
+		// Lock expires
+		a.SetLockout(1, time.Second)
+		_, err = a.Authenticate("phil", "wrong")
+		require.Equal(t, ErrUnauthenticated, err)
+		_, err = a.Authenticate("phil", "phil")
+		require.Equal(t, ErrUserLocked, err)
+		time.Sleep(2100 * time.Millisecond)
+		_, err = a.Authenticate("phil", "phil")
+		require.Nil(t, err)
 
-	// Now token1 shouldn't work anymore
-	_, err = a.AuthenticateToken(token1.Value)
-	require.Equal(t, ErrUnauthenticated, err)
+		// Lockout disabled
+		a.SetLockout(0, 0)
+		for i := 0; i < 5; i++ {
+			_, err := a.Authenticate("phil", "wrong")
+			require.Equal(t, ErrUnauthenticated, err)
+		}
+		_, err = a.Authenticate("phil", "phil")
+		require.Nil(t, err)
+	})
+}
 
-	result, err := a.db.Query("SELECT * from user_token WHERE token = ?", token1.Value)
-	require.Nil(t, err)
-	require.True(t, result.Next()) // Still a matching row
-	require.Nil(t, result.Close())
+func TestManager_AuditEvents(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, filename string) {
+		a := newTestManager(t, filename, PermissionDenyAll)
+		events, err := a.AuditEvents(&AuditFilter{})
+		require.Nil(t, err)
+		require.Empty(t, events)
 
-	// Expire tokens and check database rows
-	require.Nil(t, a.RemoveExpiredTokens())
+		now := time.Unix(time.Now().Unix(), 0)
+		require.Nil(t, a.AddAuditEvent(&AuditEvent{Time: now.Add(-2 * time.Hour), Origin: AuditOriginCLI, Action: AuditActionUserAdd, Target: "phil", Details: "role=admin"}))
+		require.Nil(t, a.AddAuditEvent(&AuditEvent{Time: now.Add(-time.Hour), Actor: "phil", Origin: "1.2.3.4", Action: AuditActionUserAdd, Target: "ben"}))
+		require.Nil(t, a.AddAuditEvent(&AuditEvent{Time: now, Actor: "phil", Origin: "1.2.3.4", Action: AuditActionAccessAllow, Target: "ben", Details: "topic=alerts permission=read-only"}))
+		require.Nil(t, a.AddAuditEvent(&AuditEvent{Origin: "5.6.7.8", Action: AuditActionLoginFailed, Target: "ben"}))
 
-	result, err = a.db.Query("SELECT * from user_token WHERE token = ?", token1.Value)
-	require.Nil(t, err)
-	require.False(t, result.Next()) // No matching row!
-	require.Nil(t, result.Close())
+		// All events, newest first
+		events, err = a.AuditEvents(&AuditFilter{})
+		require.Nil(t, err)
+		require.Len(t, events, 4)
+		require.Equal(t, AuditActionLoginFailed, events[0].Action)
+		require.Equal(t, "", events[0].Actor)
+		require.Equal(t, "5.6.7.8", events[0].Origin)
+		require.Equal(t, "ben", events[0].Target)
+		require.False(t, events[0].Time.IsZero())
+		require.Equal(t, AuditActionAccessAllow, events[1].Action)
+		require.Equal(t, now, events[1].Time)
+		require.Equal(t, "topic=alerts permission=read-only", events[1].Details)
+		require.Equal(t, AuditOriginCLI, events[3].Origin)
+		require.Greater(t, events[0].ID, events[1].ID)
+
+		// Filters
+		events, err = a.AuditEvents(&AuditFilter{User: "phil"})
+		require.Nil(t, err)
+		require.Len(t, events, 3)
+		events, err = a.AuditEvents(&AuditFilter{User: "ben", Action: AuditActionUserAdd})
+		require.Nil(t, err)
+		require.Len(t, events, 1)
+		require.Equal(t, "phil", events[0].Actor)
+		events, err = a.AuditEvents(&AuditFilter{Since: now.Add(-90 * time.Minute), Until: now.Add(-time.Minute)})
+		require.Nil(t, err)
+		require.Len(t, events, 1)
+		require.Equal(t, "ben", events[0].Target)
+		events, err = a.AuditEvents(&AuditFilter{Limit: 2})
+		require.Nil(t, err)
+		require.Len(t, events, 2)
+		require.Equal(t, AuditActionLoginFailed, events[0].Action)
+
+		// Changes are recorded by the manager itself, and events survive the removal of users
+		require.Nil(t, a.AddUser("ben", "ben", RoleUser))
+		require.Nil(t, a.RemoveUser("ben"))
+		events, err = a.AuditEvents(&AuditFilter{User: "ben"})
+		require.Nil(t, err)
+		require.Len(t, events, 5)
+		require.Equal(t, AuditActionUserRemove, events[0].Action)
+		require.Equal(t, AuditActionUserAdd, events[1].Action)
+		require.Equal(t, "role=user", events[1].Details)
+	})
 }
 
-func TestManager_Token_Extend(t *testing.T) {
-	a := newTestManager(t, PermissionDenyAll)
-	require.Nil(t, a.AddUser("ben", "ben", RoleUser))
+func TestManager_AuditEvents_WithActor(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, filename string) {
+		a := newTestManager(t, filename, PermissionDenyAll)
+		cli := a.WithActor(&Actor{Origin: AuditOriginCLI})
+		require.Nil(t, cli.AddUser("phil", "phil", RoleAdmin))
+		phil, err := a.User("phil")
+		require.Nil(t, err)
 
-	// Try to extend token for user without token
-	u, err := a.User("ben")
-	require.Nil(t, err)
+		// Changes made via the derived manager are attributed to its actor, and share the database
+		m := a.WithActor(&Actor{Name: "phil", Origin: "1.2.3.4", Reason: "test"})
+		require.Nil(t, m.AddGroup("devs"))
+		require.Nil(t, m.AddUser("ben", "ben", RoleUser))
+		require.Nil(t, m.AddGroupMember("devs", "ben"))
+		require.Nil(t, m.AllowGroupAccess("devs", "ci-*", PermissionReadWrite))
+		require.Nil(t, m.ResetGroupAccess("devs", ""))
+		require.Nil(t, m.RemoveGroupMember("devs", "ben"))
+		require.Nil(t, m.RemoveGroup("devs"))
+		require.Nil(t, m.ChangeTopicRetention(&TopicRetention{Topic: "alerts", MaxAge: time.Hour}))
+		require.Nil(t, m.ResetTopicRetention("alerts"))
+		token, err := m.CreateToken(phil.ID, "laptop", time.Unix(0, 0), netip.MustParseAddr("1.2.3.4"), nil)
+		require.Nil(t, err)
+		label := "desktop"
+		_, err = m.ChangeToken(phil.ID, token.Value, &label, nil)
+		require.Nil(t, err)
+		require.Nil(t, m.RemoveToken(phil.ID, token.Value))
 
-	_, err = a.ChangeToken(u.ID, u.Token, util.String("some label"), util.Time(time.Now().Add(time.Hour)))
-	require.Equal(t, errNoTokenProvided, err)
+		events, err := a.AuditEvents(&AuditFilter{})
+		require.Nil(t, err)
+		require.Len(t, events, 13)
+		expected := []struct {
+			action  AuditAction
+			target  string
+			details string
+		}{
+			{AuditActionTokenRemove, "phil", "reason=test"},
+			{AuditActionTokenUpdate, "phil", `label="desktop" reason=test`},
+			{AuditActionTokenCreate, "phil", `label="laptop" scopes=0 reason=test`},
+			{AuditActionTopicRetentionReset, "", "topic=alerts reason=test"},
+			{AuditActionTopicRetentionChange, "", "topic=alerts max_age=1h0m0s max_messages=0 reason=test"},
+			{AuditActionGroupRemove, "", "group=devs reason=test"},
+			{AuditActionGroupMemberRemove, "ben", "group=devs reason=test"},
+			{AuditActionGroupAccessReset, "", "group=devs reason=test"},
+			{AuditActionGroupAccessAllow, "", "group=devs topic=ci-* permission=read-write reason=test"},
+			{AuditActionGroupMemberAdd, "ben", "group=devs reason=test"},
+			{AuditActionUserAdd, "ben", "role=user reason=test"},
+			{AuditActionGroupAdd, "", "group=devs reason=test"},
+		}
+		for i, e := range expected {
+			require.Equal(t, e.action, events[i].Action)
+			require.Equal(t, e.target, events[i].Target)
+			require.Equal(t, e.details, events[i].Details)
+			require.Equal(t, "phil", events[i].Actor)
+			require.Equal(t, "1.2.3.4", events[i].Origin)
+		}
+		require.Equal(t, AuditActionUserAdd, events[12].Action)
+		require.Equal(t, "", events[12].Actor)
+		require.Equal(t, AuditOriginCLI, events[12].Origin)
+	})
+}
 
-	// Create token for user
-	token, err := a.CreateToken(u.ID, "", time.Now().Add(72*time.Hour), netip.IPv4Unspecified())
+func testTOTPCode(t *testing.T, secret string, now time.Time) string {
+	code, err := totpCode(secret, now.Unix()/int64(totpPeriod.Seconds()))
 	require.Nil(t, err)
-	require.NotEmpty(t, token.Value)
+	return code
+}
 
-	userWithToken, err := a.AuthenticateToken(token.Value)
-	require.Nil(t, err)
+func TestManager_Token_Invalid(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, filename string) {
+		a := newTestManager(t, filename, PermissionDenyAll)
+		require.Nil(t, a.AddUser("ben", "ben", RoleUser))
 
-	extendedToken, err := a.ChangeToken(userWithToken.ID, userWithToken.Token, util.String("changed label"), util.Time(time.Now().Add(100*time.Hour)))
-	require.Nil(t, err)
-	require.Equal(t, token.Value, extendedToken.Value)
-	require.Equal(t, "changed label", extendedToken.Label)
-	require.True(t, token.Expires.Unix() < extendedToken.Expires.Unix())
-	require.True(t, time.Now().Add(99*time.Hour).Unix() < extendedToken.Expires.Unix())
+		u, err := a.AuthenticateToken(strings.Repeat("x", 32)) // 32 == token length
+		require.Nil(t, u)
+		require.Equal(t, ErrUnauthenticated, err)
+
+		u, err = a.AuthenticateToken("not long enough anyway")
+		require.Nil(t, u)
+		require.Equal(t, ErrUnauthenticated, err)
+	})
 }
 
-func TestManager_Token_MaxCount_AutoDelete(t *testing.T) {
-	// Tests that tokens are automatically deleted when the maximum number of tokens is reached
+func TestManager_Token_NotFound(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, filename string) {
+		a := newTestManager(t, filename, PermissionDenyAll)
+		_, err := a.Token("u_bla", "notfound")
+		require.Equal(t, ErrTokenNotFound, err)
+	})
+}
 
-	a := newTestManager(t, PermissionDenyAll)
-	require.Nil(t, a.AddUser("ben", "ben", RoleUser))
-	require.Nil(t, a.AddUser("phil", "phil", RoleUser))
+func TestManager_Token_Expire(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, filename string) {
+		a := newTestManager(t, filename, PermissionDenyAll)
+		require.Nil(t, a.AddUser("ben", "ben", RoleUser))
 
-	ben, err := a.User("ben")
-	require.Nil(t, err)
+		u, err := a.User("ben")
+		require.Nil(t, err)
 
-	phil, err := a.User("phil")
-	require.Nil(t, err)
+		// Create tokens for user
+		token1, err := a.CreateToken(u.ID, "", time.Now().Add(72*time.Hour), netip.IPv4Unspecified(), nil)
+		require.Nil(t, err)
+		require.NotEmpty(t, token1.Value)
+		require.True(t, time.Now().Add(71*time.Hour).Unix() < token1.Expires.Unix())
 
-	// Create 2 tokens for phil
-	philTokens := make([]string, 0)
-	token, err := a.CreateToken(phil.ID, "", time.Now().Add(72*time.Hour), netip.IPv4Unspecified())
-	require.Nil(t, err)
-	require.NotEmpty(t, token.Value)
-	philTokens = append(philTokens, token.Value)
+		token2, err := a.CreateToken(u.ID, "", time.Now().Add(72*time.Hour), netip.IPv4Unspecified(), nil)
+		require.Nil(t, err)
+		require.NotEmpty(t, token2.Value)
+		require.NotEqual(t, token1.Value, token2.Value)
+		require.True(t, time.Now().Add(71*time.Hour).Unix() < token2.Expires.Unix())
 
-	token, err = a.CreateToken(phil.ID, "", time.Unix(0, 0), netip.IPv4Unspecified())
-	require.Nil(t, err)
-	require.NotEmpty(t, token.Value)
-	philTokens = append(philTokens, token.Value)
+		// See that tokens work
+		_, err = a.AuthenticateToken(token1.Value)
+		require.Nil(t, err)
+
+		_, err = a.AuthenticateToken(token2.Value)
+		require.Nil(t, err)
+
+		// Modify token expiration in database
+		_, err = a.db.Exec("UPDATE user_token SET expires = 1 WHERE token = $1", token1.Value)
+		require.Nil(t, err)
+
+		// Now token1 shouldn't work anymore
+		_, err = a.AuthenticateToken(token1.Value)
+		require.Equal(t, ErrUnauthenticated, err)
+
+		result, err := a.db.Query("SELECT * from user_token WHERE token = $1", token1.Value)
+		require.Nil(t, err)
+		require.True(t, result.Next()) // Still a matching row
+		require.Nil(t, result.Close())
+
+		// Expire tokens and check database rows
+		require.Nil(t, a.RemoveExpiredTokens())
+
+		result, err = a.db.Query("SELECT * from user_token WHERE token = $1", token1.Value)
+		require.Nil(t, err)
+		require.False(t, result.Next()) // No matching row!
+		require.Nil(t, result.Close())
+	})
+}
+
+func TestManager_Token_Extend(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, filename string) {
+		a := newTestManager(t, filename, PermissionDenyAll)
+		require.Nil(t, a.AddUser("ben", "ben", RoleUser))
+
+		// Try to extend token for user without token
+		u, err := a.User("ben")
+		require.Nil(t, err)
+
+		_, err = a.ChangeToken(u.ID, u.Token, util.String("some label"), util.Time(time.Now().Add(time.Hour)))
+		require.Equal(t, errNoTokenProvided, err)
 
-	// Create 22 tokens for ben (only 20 allowed!)
-	baseTime := time.Now().Add(24 * time.Hour)
-	benTokens := make([]string, 0)
-	for i := 0; i < 22; i++ { //
-		token, err := a.CreateToken(ben.ID, "", time.Now().Add(72*time.Hour), netip.IPv4Unspecified())
+		// Create token for user
+		token, err := a.CreateToken(u.ID, "", time.Now().Add(72*time.Hour), netip.IPv4Unspecified(), nil)
 		require.Nil(t, err)
 		require.NotEmpty(t, token.Value)
-		benTokens = append(benTokens, token.Value)
 
-		// Manually modify expiry date to avoid sorting issues (this is a hack)
-		_, err = a.db.Exec(`UPDATE user_token SET expires=? WHERE token=?`, baseTime.Add(time.Duration(i)*time.Minute).Unix(), token.Value)
+		userWithToken, err := a.AuthenticateToken(token.Value)
 		require.Nil(t, err)
-	}
 
-	// Ben: The first 2 tokens should have been wiped and should not work anymore!
-	_, err = a.AuthenticateToken(benTokens[0])
-	require.Equal(t, ErrUnauthenticated, err)
+		extendedToken, err := a.ChangeToken(userWithToken.ID, userWithToken.Token, util.String("changed label"), util.Time(time.Now().Add(100*time.Hour)))
+		require.Nil(t, err)
+		require.Equal(t, token.Value, extendedToken.Value)
+		require.Equal(t, "changed label", extendedToken.Label)
+		require.True(t, token.Expires.Unix() < extendedToken.Expires.Unix())
+		require.True(t, time.Now().Add(99*time.Hour).Unix() < extendedToken.Expires.Unix())
+	})
+}
 
-	_, err = a.AuthenticateToken(benTokens[1])
-	require.Equal(t, ErrUnauthenticated, err)
+func TestManager_Token_MaxCount_AutoDelete(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, filename string) {
+		// Tests that tokens are automatically deleted when the maximum number of tokens is reached
 
-	// Ben: The other tokens should still work
-	for i := 2; i < 22; i++ {
-		userWithToken, err := a.AuthenticateToken(benTokens[i])
-		require.Nil(t, err, "token[%d]=%s failed", i, benTokens[i])
-		require.Equal(t, "ben", userWithToken.Name)
-		require.Equal(t, benTokens[i], userWithToken.Token)
-	}
+		a := newTestManager(t, filename, PermissionDenyAll)
+		require.Nil(t, a.AddUser("ben", "ben", RoleUser))
+		require.Nil(t, a.AddUser("phil", "phil", RoleUser))
 
-	// Phil: All tokens should still work
-	for i := 0; i < 2; i++ {
-		userWithToken, err := a.AuthenticateToken(philTokens[i])
-		require.Nil(t, err, "token[%d]=%s failed", i, philTokens[i])
-		require.Equal(t, "phil", userWithToken.Name)
-		require.Equal(t, philTokens[i], userWithToken.Token)
-	}
+		ben, err := a.User("ben")
+		require.Nil(t, err)
 
-	var benCount int
-	rows, err := a.db.Query(`SELECT COUNT(*) FROM user_token WHERE user_id=?`, ben.ID)
-	require.Nil(t, err)
-	require.True(t, rows.Next())
-	require.Nil(t, rows.Scan(&benCount))
-	require.Equal(t, 20, benCount)
+		phil, err := a.User("phil")
+		require.Nil(t, err)
 
-	var philCount int
-	rows, err = a.db.Query(`SELECT COUNT(*) FROM user_token WHERE user_id=?`, phil.ID)
-	require.Nil(t, err)
-	require.True(t, rows.Next())
-	require.Nil(t, rows.Scan(&philCount))
-	require.Equal(t, 2, philCount)
+		// Create 2 tokens for phil
+		philTokens := make([]string, 0)
+		token, err := a.CreateToken(phil.ID, "", time.Now().Add(72*time.Hour), netip.IPv4Unspecified(), nil)
+		require.Nil(t, err)
+		require.NotEmpty(t, token.Value)
+		philTokens = append(philTokens, token.Value)
+
+		token, err = a.CreateToken(phil.ID, "", time.Unix(0, 0), netip.IPv4Unspecified(), nil)
+		require.Nil(t, err)
+		require.NotEmpty(t, token.Value)
+		philTokens = append(philTokens, token.Value)
+
+		// Create 22 tokens for ben (only 20 allowed!)
+		baseTime := time.Now().Add(24 * time.Hour)
+		benTokens := make([]string, 0)
+		for i := 0; i < 22; i++ { //
+			token, err := a.CreateToken(ben.ID, "", time.Now().Add(72*time.Hour), netip.IPv4Unspecified(), nil)
+			require.Nil(t, err)
+			require.NotEmpty(t, token.Value)
+			benTokens = append(benTokens, token.Value)
+
+			// Manually modify expiry date to avoid sorting issues (this is a hack)
+			_, err = a.db.Exec(`UPDATE user_token SET expires=$1 WHERE token=$2`, baseTime.Add(time.Duration(i)*time.Minute).Unix(), token.Value)
+			require.Nil(t, err)
+		}
+
+		// Ben: The first 2 tokens should have been wiped and should not work anymore!
+		_, err = a.AuthenticateToken(benTokens[0])
+		require.Equal(t, ErrUnauthenticated, err)
+
+		_, err = a.AuthenticateToken(benTokens[1])
+		require.Equal(t, ErrUnauthenticated, err)
+
+		// Ben: The other tokens should still work
+		for i := 2; i < 22; i++ {
+			userWithToken, err := a.AuthenticateToken(benTokens[i])
+			require.Nil(t, err, "token[%d]=%s failed", i, benTokens[i])
+			require.Equal(t, "ben", userWithToken.Name)
+			require.Equal(t, benTokens[i], userWithToken.Token)
+		}
+
+		// Phil: All tokens should still work
+		for i := 0; i < 2; i++ {
+			userWithToken, err := a.AuthenticateToken(philTokens[i])
+			require.Nil(t, err, "token[%d]=%s failed", i, philTokens[i])
+			require.Equal(t, "phil", userWithToken.Name)
+			require.Equal(t, philTokens[i], userWithToken.Token)
+		}
+
+		var benCount int
+		rows, err := a.db.Query(`SELECT COUNT(*) FROM user_token WHERE user_id=$1`, ben.ID)
+		require.Nil(t, err)
+		require.True(t, rows.Next())
+		require.Nil(t, rows.Scan(&benCount))
+		require.Equal(t, 20, benCount)
+
+		var philCount int
+		rows, err = a.db.Query(`SELECT COUNT(*) FROM user_token WHERE user_id=$1`, phil.ID)
+		require.Nil(t, err)
+		require.True(t, rows.Next())
+		require.Nil(t, rows.Scan(&philCount))
+		require.Equal(t, 2, philCount)
+	})
 }
 
 func TestManager_EnqueueStats_ResetStats(t *testing.T) {
-	a, err := NewManager(filepath.Join(t.TempDir(), "db"), "", PermissionReadWrite, bcrypt.MinCost, 1500*time.Millisecond)
-	require.Nil(t, err)
-	require.Nil(t, a.AddUser("ben", "ben", RoleUser))
+	forEachBackend(t, func(t *testing.T, filename string) {
+		a := newTestManagerFromFile(t, filename, "", PermissionReadWrite, bcrypt.MinCost, 1500*time.Millisecond)
+		require.Nil(t, a.AddUser("ben", "ben", RoleUser))
 
-	// Baseline: No messages or emails
-	u, err := a.User("ben")
-	require.Nil(t, err)
-	require.Equal(t, int64(0), u.Stats.Messages)
-	require.Equal(t, int64(0), u.Stats.Emails)
-	a.EnqueueUserStats(u.ID, &Stats{
-		Messages: 11,
-		Emails:   2,
-	})
+		// Baseline: No messages or emails
+		u, err := a.User("ben")
+		require.Nil(t, err)
+		require.Equal(t, int64(0), u.Stats.Messages)
+		require.Equal(t, int64(0), u.Stats.Emails)
+		a.EnqueueUserStats(u.ID, &Stats{
+			Messages: 11,
+			Emails:   2,
+		})
+
+		// Still no change, because it's queued asynchronously
+		u, err = a.User("ben")
+		require.Nil(t, err)
+		require.Equal(t, int64(0), u.Stats.Messages)
+		require.Equal(t, int64(0), u.Stats.Emails)
 
-	// Still no change, because it's queued asynchronously
-	u, err = a.User("ben")
-	require.Nil(t, err)
-	require.Equal(t, int64(0), u.Stats.Messages)
-	require.Equal(t, int64(0), u.Stats.Emails)
+		// After 2 seconds they should be persisted
+		time.Sleep(2 * time.Second)
 
-	// After 2 seconds they should be persisted
-	time.Sleep(2 * time.Second)
+		u, err = a.User("ben")
+		require.Nil(t, err)
+		require.Equal(t, int64(11), u.Stats.Messages)
+		require.Equal(t, int64(2), u.Stats.Emails)
 
-	u, err = a.User("ben")
-	require.Nil(t, err)
-	require.Equal(t, int64(11), u.Stats.Messages)
-	require.Equal(t, int64(2), u.Stats.Emails)
+		// Now reset stats (enqueued stats will be thrown out)
+		a.EnqueueUserStats(u.ID, &Stats{
+			Messages: 99,
+			Emails:   23,
+		})
+		require.Nil(t, a.ResetStats())
 
-	// Now reset stats (enqueued stats will be thrown out)
-	a.EnqueueUserStats(u.ID, &Stats{
-		Messages: 99,
-		Emails:   23,
+		u, err = a.User("ben")
+		require.Nil(t, err)
+		require.Equal(t, int64(0), u.Stats.Messages)
+		require.Equal(t, int64(0), u.Stats.Emails)
 	})
-	require.Nil(t, a.ResetStats())
-
-	u, err = a.User("ben")
-	require.Nil(t, err)
-	require.Equal(t, int64(0), u.Stats.Messages)
-	require.Equal(t, int64(0), u.Stats.Emails)
 }
 
 func TestManager_EnqueueTokenUpdate(t *testing.T) {
-	a, err := NewManager(filepath.Join(t.TempDir(), "db"), "", PermissionReadWrite, bcrypt.MinCost, 500*time.Millisecond)
-	require.Nil(t, err)
-	require.Nil(t, a.AddUser("ben", "ben", RoleUser))
+	forEachBackend(t, func(t *testing.T, filename string) {
+		a := newTestManagerFromFile(t, filename, "", PermissionReadWrite, bcrypt.MinCost, 500*time.Millisecond)
+		require.Nil(t, a.AddUser("ben", "ben", RoleUser))
 
-	// Create user and token
-	u, err := a.User("ben")
-	require.Nil(t, err)
+		// Create user and token
+		u, err := a.User("ben")
+		require.Nil(t, err)
 
-	token, err := a.CreateToken(u.ID, "", time.Now().Add(time.Hour), netip.IPv4Unspecified())
-	require.Nil(t, err)
+		token, err := a.CreateToken(u.ID, "", time.Now().Add(time.Hour), netip.IPv4Unspecified(), nil)
+		require.Nil(t, err)
 
-	// Queue token update
-	a.EnqueueTokenUpdate(token.Value, &TokenUpdate{
-		LastAccess: time.Unix(111, 0).UTC(),
-		LastOrigin: netip.MustParseAddr("1.2.3.3"),
-	})
+		// Queue token update
+		a.EnqueueTokenUpdate(token.Value, &TokenUpdate{
+			LastAccess: time.Unix(111, 0).UTC(),
+			LastOrigin: netip.MustParseAddr("1.2.3.3"),
+		})
 
-	// Token has not changed yet.
-	token2, err := a.Token(u.ID, token.Value)
-	require.Nil(t, err)
-	require.Equal(t, token.LastAccess.Unix(), token2.LastAccess.Unix())
-	require.Equal(t, token.LastOrigin, token2.LastOrigin)
+		// Token has not changed yet.
+		token2, err := a.Token(u.ID, token.Value)
+		require.Nil(t, err)
+		require.Equal(t, token.LastAccess.Unix(), token2.LastAccess.Unix())
+		require.Equal(t, token.LastOrigin, token2.LastOrigin)
 
-	// After a second or so they should be persisted
-	time.Sleep(time.Second)
+		// After a second or so they should be persisted
+		time.Sleep(time.Second)
 
-	token3, err := a.Token(u.ID, token.Value)
-	require.Nil(t, err)
-	require.Equal(t, time.Unix(111, 0).UTC().Unix(), token3.LastAccess.Unix())
-	require.Equal(t, netip.MustParseAddr("1.2.3.3"), token3.LastOrigin)
+		token3, err := a.Token(u.ID, token.Value)
+		require.Nil(t, err)
+		require.Equal(t, time.Unix(111, 0).UTC().Unix(), token3.LastAccess.Unix())
+		require.Equal(t, netip.MustParseAddr("1.2.3.3"), token3.LastOrigin)
+	})
 }
 
 func TestManager_ChangeSettings(t *testing.T) {
-	a, err := NewManager(filepath.Join(t.TempDir(), "db"), "", PermissionReadWrite, bcrypt.MinCost, 1500*time.Millisecond)
-	require.Nil(t, err)
-	require.Nil(t, a.AddUser("ben", "ben", RoleUser))
+	forEachBackend(t, func(t *testing.T, filename string) {
+		a := newTestManagerFromFile(t, filename, "", PermissionReadWrite, bcrypt.MinCost, 1500*time.Millisecond)
+		require.Nil(t, a.AddUser("ben", "ben", RoleUser))
 
-	// No settings
-	u, err := a.User("ben")
-	require.Nil(t, err)
-	require.Nil(t, u.Prefs.Subscriptions)
-	require.Nil(t, u.Prefs.Notification)
-	require.Nil(t, u.Prefs.Language)
-
-	// Save with new settings
-	prefs := &Prefs{
-		Language: util.String("de"),
-		Notification: &NotificationPrefs{
-			Sound:       util.String("ding"),
-			MinPriority: util.Int(2),
-		},
-		Subscriptions: []*Subscription{
-			{
-				BaseURL:     "https://ntfy.sh",
-				Topic:       "mytopic",
-				DisplayName: util.String("My Topic"),
+		// No settings
+		u, err := a.User("ben")
+		require.Nil(t, err)
+		require.Nil(t, u.Prefs.Subscriptions)
+		require.Nil(t, u.Prefs.Notification)
+		require.Nil(t, u.Prefs.Language)
+
+		// Save with new settings
+		prefs := &Prefs{
+			Language: util.String("de"),
+			Notification: &NotificationPrefs{
+				Sound:       util.String("ding"),
+				MinPriority: util.Int(2),
 			},
-		},
-	}
-	require.Nil(t, a.ChangeSettings(u.ID, prefs))
+			Subscriptions: []*Subscription{
+				{
+					BaseURL:     "https://ntfy.sh",
+					Topic:       "mytopic",
+					DisplayName: util.String("My Topic"),
+				},
+			},
+		}
+		require.Nil(t, a.ChangeSettings(u.ID, prefs))
 
-	// Read again
-	u, err = a.User("ben")
-	require.Nil(t, err)
-	require.Equal(t, util.String("de"), u.Prefs.Language)
-	require.Equal(t, util.String("ding"), u.Prefs.Notification.Sound)
-	require.Equal(t, util.Int(2), u.Prefs.Notification.MinPriority)
-	require.Nil(t, u.Prefs.Notification.DeleteAfter)
-	require.Equal(t, "https://ntfy.sh", u.Prefs.Subscriptions[0].BaseURL)
-	require.Equal(t, "mytopic", u.Prefs.Subscriptions[0].Topic)
-	require.Equal(t, util.String("My Topic"), u.Prefs.Subscriptions[0].DisplayName)
+		// Read again
+		u, err = a.User("ben")
+		require.Nil(t, err)
+		require.Equal(t, util.String("de"), u.Prefs.Language)
+		require.Equal(t, util.String("ding"), u.Prefs.Notification.Sound)
+		require.Equal(t, util.Int(2), u.Prefs.Notification.MinPriority)
+		require.Nil(t, u.Prefs.Notification.DeleteAfter)
+		require.Equal(t, "https://ntfy.sh", u.Prefs.Subscriptions[0].BaseURL)
+		require.Equal(t, "mytopic", u.Prefs.Subscriptions[0].Topic)
+		require.Equal(t, util.String("My Topic"), u.Prefs.Subscriptions[0].DisplayName)
+	})
 }
 
 func TestManager_Tier_Create_Update_List_Delete(t *testing.T) {
-	a := newTestManager(t, PermissionDenyAll)
-
-	// Create tier and user
-	require.Nil(t, a.AddTier(&Tier{
-		Code:                     "supporter",
-		Name:                     "Supporter",
-		MessageLimit:             1,
-		MessageExpiryDuration:    time.Second,
-		EmailLimit:               1,
-		ReservationLimit:         1,
-		AttachmentFileSizeLimit:  1,
-		AttachmentTotalSizeLimit: 1,
-		AttachmentExpiryDuration: time.Second,
-		AttachmentBandwidthLimit: 1,
-		StripeMonthlyPriceID:     "price_1",
-	}))
-	require.Nil(t, a.AddTier(&Tier{
-		Code:                     "pro",
-		Name:                     "Pro",
-		MessageLimit:             123,
-		MessageExpiryDuration:    86400 * time.Second,
-		EmailLimit:               32,
-		ReservationLimit:         2,
-		AttachmentFileSizeLimit:  1231231,
-		AttachmentTotalSizeLimit: 123123,
-		AttachmentExpiryDuration: 10800 * time.Second,
-		AttachmentBandwidthLimit: 21474836480,
-		StripeMonthlyPriceID:     "price_2",
-	}))
-	require.Nil(t, a.AddUser("phil", "phil", RoleUser))
-	require.Nil(t, a.ChangeTier("phil", "pro"))
-
-	ti, err := a.Tier("pro")
-	require.Nil(t, err)
+	forEachBackend(t, func(t *testing.T, filename string) {
+		a := newTestManager(t, filename, PermissionDenyAll)
+
+		// Create tier and user
+		require.Nil(t, a.AddTier(&Tier{
+			Code:                     "supporter",
+			Name:                     "Supporter",
+			MessageLimit:             1,
+			MessageExpiryDuration:    time.Second,
+			EmailLimit:               1,
+			ReservationLimit:         1,
+			AttachmentFileSizeLimit:  1,
+			AttachmentTotalSizeLimit: 1,
+			AttachmentExpiryDuration: time.Second,
+			AttachmentBandwidthLimit: 1,
+			StripeMonthlyPriceID:     "price_1",
+		}))
+		require.Nil(t, a.AddTier(&Tier{
+			Code:                     "pro",
+			Name:                     "Pro",
+			MessageLimit:             123,
+			MessageExpiryDuration:    86400 * time.Second,
+			EmailLimit:               32,
+			ReservationLimit:         2,
+			AttachmentFileSizeLimit:  1231231,
+			AttachmentTotalSizeLimit: 123123,
+			AttachmentExpiryDuration: 10800 * time.Second,
+			AttachmentBandwidthLimit: 21474836480,
+			StripeMonthlyPriceID:     "price_2",
+		}))
+		require.Nil(t, a.AddUser("phil", "phil", RoleUser))
+		require.Nil(t, a.ChangeTier("phil", "pro"))
+
+		ti, err := a.Tier("pro")
+		require.Nil(t, err)
 
-	u, err := a.User("phil")
-	require.Nil(t, err)
+		u, err := a.User("phil")
+		require.Nil(t, err)
 
-	// These are populated by different SQL queries
-	require.Equal(t, ti, u.Tier)
-
-	// Fields
-	require.True(t, strings.HasPrefix(ti.ID, "ti_"))
-	require.Equal(t, "pro", ti.Code)
-	require.Equal(t, "Pro", ti.Name)
-	require.Equal(t, int64(123), ti.MessageLimit)
-	require.Equal(t, 86400*time.Second, ti.MessageExpiryDuration)
-	require.Equal(t, int64(32), ti.EmailLimit)
-	require.Equal(t, int64(2), ti.ReservationLimit)
-	require.Equal(t, int64(1231231), ti.AttachmentFileSizeLimit)
-	require.Equal(t, int64(123123), ti.AttachmentTotalSizeLimit)
-	require.Equal(t, 10800*time.Second, ti.AttachmentExpiryDuration)
-	require.Equal(t, int64(21474836480), ti.AttachmentBandwidthLimit)
-	require.Equal(t, "price_2", ti.StripeMonthlyPriceID)
-
-	// Update tier
-	ti.EmailLimit = 999999
-	require.Nil(t, a.UpdateTier(ti))
-
-	// List tiers
-	tiers, err := a.Tiers()
-	require.Nil(t, err)
-	require.Equal(t, 2, len(tiers))
-
-	ti = tiers[0]
-	require.Equal(t, "supporter", ti.Code)
-	require.Equal(t, "Supporter", ti.Name)
-	require.Equal(t, int64(1), ti.MessageLimit)
-	require.Equal(t, time.Second, ti.MessageExpiryDuration)
-	require.Equal(t, int64(1), ti.EmailLimit)
-	require.Equal(t, int64(1), ti.ReservationLimit)
-	require.Equal(t, int64(1), ti.AttachmentFileSizeLimit)
-	require.Equal(t, int64(1), ti.AttachmentTotalSizeLimit)
-	require.Equal(t, time.Second, ti.AttachmentExpiryDuration)
-	require.Equal(t, int64(1), ti.AttachmentBandwidthLimit)
-	require.Equal(t, "price_1", ti.StripeMonthlyPriceID)
-
-	ti = tiers[1]
-	require.Equal(t, "pro", ti.Code)
-	require.Equal(t, "Pro", ti.Name)
-	require.Equal(t, int64(123), ti.MessageLimit)
-	require.Equal(t, 86400*time.Second, ti.MessageExpiryDuration)
-	require.Equal(t, int64(999999), ti.EmailLimit) // Updatedd!
-	require.Equal(t, int64(2), ti.ReservationLimit)
-	require.Equal(t, int64(1231231), ti.AttachmentFileSizeLimit)
-	require.Equal(t, int64(123123), ti.AttachmentTotalSizeLimit)
-	require.Equal(t, 10800*time.Second, ti.AttachmentExpiryDuration)
-	require.Equal(t, int64(21474836480), ti.AttachmentBandwidthLimit)
-	require.Equal(t, "price_2", ti.StripeMonthlyPriceID)
-
-	ti, err = a.TierByStripePrice("price_1")
-	require.Nil(t, err)
-	require.Equal(t, "supporter", ti.Code)
-	require.Equal(t, "Supporter", ti.Name)
-	require.Equal(t, int64(1), ti.MessageLimit)
-	require.Equal(t, time.Second, ti.MessageExpiryDuration)
-	require.Equal(t, int64(1), ti.EmailLimit)
-	require.Equal(t, int64(1), ti.ReservationLimit)
-	require.Equal(t, int64(1), ti.AttachmentFileSizeLimit)
-	require.Equal(t, int64(1), ti.AttachmentTotalSizeLimit)
-	require.Equal(t, time.Second, ti.AttachmentExpiryDuration)
-	require.Equal(t, int64(1), ti.AttachmentBandwidthLimit)
-	require.Equal(t, "price_1", ti.StripeMonthlyPriceID)
-
-	// Cannot remove tier, since user has this tier
-	require.Error(t, a.RemoveTier("pro"))
-
-	// CAN remove this tier
-	require.Nil(t, a.RemoveTier("supporter"))
-
-	tiers, err = a.Tiers()
-	require.Nil(t, err)
-	require.Equal(t, 1, len(tiers))
-	require.Equal(t, "pro", tiers[0].Code)
-	require.Equal(t, "pro", tiers[0].Code)
+		// These are populated by different SQL queries
+		require.Equal(t, ti, u.Tier)
+
+		// Fields
+		require.True(t, strings.HasPrefix(ti.ID, "ti_"))
+		require.Equal(t, "pro", ti.Code)
+		require.Equal(t, "Pro", ti.Name)
+		require.Equal(t, int64(123), ti.MessageLimit)
+		require.Equal(t, 86400*time.Second, ti.MessageExpiryDuration)
+		require.Equal(t, int64(32), ti.EmailLimit)
+		require.Equal(t, int64(2), ti.ReservationLimit)
+		require.Equal(t, int64(1231231), ti.AttachmentFileSizeLimit)
+		require.Equal(t, int64(123123), ti.AttachmentTotalSizeLimit)
+		require.Equal(t, 10800*time.Second, ti.AttachmentExpiryDuration)
+		require.Equal(t, int64(21474836480), ti.AttachmentBandwidthLimit)
+		require.Equal(t, "price_2", ti.StripeMonthlyPriceID)
+
+		// Update tier
+		ti.EmailLimit = 999999
+		require.Nil(t, a.UpdateTier(ti))
+
+		// List tiers
+		tiers, err := a.Tiers()
+		require.Nil(t, err)
+		require.Equal(t, 2, len(tiers))
+
+		ti = tiers[0]
+		require.Equal(t, "supporter", ti.Code)
+		require.Equal(t, "Supporter", ti.Name)
+		require.Equal(t, int64(1), ti.MessageLimit)
+		require.Equal(t, time.Second, ti.MessageExpiryDuration)
+		require.Equal(t, int64(1), ti.EmailLimit)
+		require.Equal(t, int64(1), ti.ReservationLimit)
+		require.Equal(t, int64(1), ti.AttachmentFileSizeLimit)
+		require.Equal(t, int64(1), ti.AttachmentTotalSizeLimit)
+		require.Equal(t, time.Second, ti.AttachmentExpiryDuration)
+		require.Equal(t, int64(1), ti.AttachmentBandwidthLimit)
+		require.Equal(t, "price_1", ti.StripeMonthlyPriceID)
+
+		ti = tiers[1]
+		require.Equal(t, "pro", ti.Code)
+		require.Equal(t, "Pro", ti.Name)
+		require.Equal(t, int64(123), ti.MessageLimit)
+		require.Equal(t, 86400*time.Second, ti.MessageExpiryDuration)
+		require.Equal(t, int64(999999), ti.EmailLimit) // Updatedd!
+		require.Equal(t, int64(2), ti.ReservationLimit)
+		require.Equal(t, int64(1231231), ti.AttachmentFileSizeLimit)
+		require.Equal(t, int64(123123), ti.AttachmentTotalSizeLimit)
+		require.Equal(t, 10800*time.Second, ti.AttachmentExpiryDuration)
+		require.Equal(t, int64(21474836480), ti.AttachmentBandwidthLimit)
+		require.Equal(t, "price_2", ti.StripeMonthlyPriceID)
+
+		ti, err = a.TierByStripePrice("price_1")
+		require.Nil(t, err)
+		require.Equal(t, "supporter", ti.Code)
+		require.Equal(t, "Supporter", ti.Name)
+		require.Equal(t, int64(1), ti.MessageLimit)
+		require.Equal(t, time.Second, ti.MessageExpiryDuration)
+		require.Equal(t, int64(1), ti.EmailLimit)
+		require.Equal(t, int64(1), ti.ReservationLimit)
+		require.Equal(t, int64(1), ti.AttachmentFileSizeLimit)
+		require.Equal(t, int64(1), ti.AttachmentTotalSizeLimit)
+		require.Equal(t, time.Second, ti.AttachmentExpiryDuration)
+		require.Equal(t, int64(1), ti.AttachmentBandwidthLimit)
+		require.Equal(t, "price_1", ti.StripeMonthlyPriceID)
+
+		// Cannot remove tier, since user has this tier
+		require.Error(t, a.RemoveTier("pro"))
+
+		// CAN remove this tier
+		require.Nil(t, a.RemoveTier("supporter"))
+
+		tiers, err = a.Tiers()
+		require.Nil(t, err)
+		require.Equal(t, 1, len(tiers))
+		require.Equal(t, "pro", tiers[0].Code)
+		require.Equal(t, "pro", tiers[0].Code)
+	})
 }
 
 func TestAccount_Tier_Create_With_ID(t *testing.T) {
-	a := newTestManager(t, PermissionDenyAll)
+	forEachBackend(t, func(t *testing.T, filename string) {
+		a := newTestManager(t, filename, PermissionDenyAll)
 
-	require.Nil(t, a.AddTier(&Tier{
-		ID:   "ti_123",
-		Code: "pro",
-	}))
+		require.Nil(t, a.AddTier(&Tier{
+			ID:   "ti_123",
+			Code: "pro",
+		}))
 
-	ti, err := a.Tier("pro")
-	require.Nil(t, err)
-	require.Equal(t, "ti_123", ti.ID)
+		ti, err := a.Tier("pro")
+		require.Nil(t, err)
+		require.Equal(t, "ti_123", ti.ID)
+	})
 }
 
 func TestManager_Tier_Change_And_Reset(t *testing.T) {
-	a := newTestManager(t, PermissionDenyAll)
-
-	// Create tier and user
-	require.Nil(t, a.AddTier(&Tier{
-		Code:             "supporter",
-		Name:             "Supporter",
-		ReservationLimit: 3,
-	}))
-	require.Nil(t, a.AddTier(&Tier{
-		Code:             "pro",
-		Name:             "Pro",
-		ReservationLimit: 4,
-	}))
-	require.Nil(t, a.AddUser("phil", "phil", RoleUser))
-	require.Nil(t, a.ChangeTier("phil", "pro"))
-
-	// Add 10 reservations (pro tier allows that)
-	for i := 0; i < 4; i++ {
-		require.Nil(t, a.AddReservation("phil", fmt.Sprintf("topic%d", i), PermissionWrite))
-	}
-
-	// Downgrading will not work (too many reservations)
-	require.Equal(t, ErrTooManyReservations, a.ChangeTier("phil", "supporter"))
-
-	// Downgrade after removing a reservation
-	require.Nil(t, a.RemoveReservations("phil", "topic0"))
-	require.Nil(t, a.ChangeTier("phil", "supporter"))
-
-	// Resetting will not work (too many reservations)
-	require.Equal(t, ErrTooManyReservations, a.ResetTier("phil"))
-
-	// Resetting after removing all reservations
-	require.Nil(t, a.RemoveReservations("phil", "topic1", "topic2", "topic3"))
-	require.Nil(t, a.ResetTier("phil"))
+	forEachBackend(t, func(t *testing.T, filename string) {
+		a := newTestManager(t, filename, PermissionDenyAll)
+
+		// Create tier and user
+		require.Nil(t, a.AddTier(&Tier{
+			Code:             "supporter",
+			Name:             "Supporter",
+			ReservationLimit: 3,
+		}))
+		require.Nil(t, a.AddTier(&Tier{
+			Code:             "pro",
+			Name:             "Pro",
+			ReservationLimit: 4,
+		}))
+		require.Nil(t, a.AddUser("phil", "phil", RoleUser))
+		require.Nil(t, a.ChangeTier("phil", "pro"))
+
+		// Add 10 reservations (pro tier allows that)
+		for i := 0; i < 4; i++ {
+			require.Nil(t, a.AddReservation("phil", fmt.Sprintf("topic%d", i), PermissionWrite))
+		}
+
+		// Downgrading will not work (too many reservations)
+		require.Equal(t, ErrTooManyReservations, a.ChangeTier("phil", "supporter"))
+
+		// Downgrade after removing a reservation
+		require.Nil(t, a.RemoveReservations("phil", "topic0"))
+		require.Nil(t, a.ChangeTier("phil", "supporter"))
+
+		// Resetting will not work (too many reservations)
+		require.Equal(t, ErrTooManyReservations, a.ResetTier("phil"))
+
+		// Resetting after removing all reservations
+		require.Nil(t, a.RemoveReservations("phil", "topic1", "topic2", "topic3"))
+		require.Nil(t, a.ResetTier("phil"))
+	})
 }
 
 func TestUser_PhoneNumberAddListRemove(t *testing.T) {
-	a := newTestManager(t, PermissionDenyAll)
+	forEachBackend(t, func(t *testing.T, filename string) {
+		a := newTestManager(t, filename, PermissionDenyAll)
 
-	require.Nil(t, a.AddUser("phil", "phil", RoleUser))
-	phil, err := a.User("phil")
-	require.Nil(t, err)
-	require.Nil(t, a.AddPhoneNumber(phil.ID, "+1234567890"))
+		require.Nil(t, a.AddUser("phil", "phil", RoleUser))
+		phil, err := a.User("phil")
+		require.Nil(t, err)
+		require.Nil(t, a.AddPhoneNumber(phil.ID, "+1234567890"))
 
-	phoneNumbers, err := a.PhoneNumbers(phil.ID)
-	require.Nil(t, err)
-	require.Equal(t, 1, len(phoneNumbers))
-	require.Equal(t, "+1234567890", phoneNumbers[0])
+		phoneNumbers, err := a.PhoneNumbers(phil.ID)
+		require.Nil(t, err)
+		require.Equal(t, 1, len(phoneNumbers))
+		require.Equal(t, "+1234567890", phoneNumbers[0])
 
-	require.Nil(t, a.RemovePhoneNumber(phil.ID, "+1234567890"))
-	phoneNumbers, err = a.PhoneNumbers(phil.ID)
-	require.Nil(t, err)
-	require.Equal(t, 0, len(phoneNumbers))
+		require.Nil(t, a.RemovePhoneNumber(phil.ID, "+1234567890"))
+		phoneNumbers, err = a.PhoneNumbers(phil.ID)
+		require.Nil(t, err)
+		require.Equal(t, 0, len(phoneNumbers))
 
-	// Paranoia check: We do NOT want to keep phone numbers in there
-	rows, err := a.db.Query(`SELECT * FROM user_phone`)
-	require.Nil(t, err)
-	require.False(t, rows.Next())
-	require.Nil(t, rows.Close())
+		// Paranoia check: We do NOT want to keep phone numbers in there
+		rows, err := a.db.Query(`SELECT * FROM user_phone`)
+		require.Nil(t, err)
+		require.False(t, rows.Next())
+		require.Nil(t, rows.Close())
+	})
 }
 
 func TestUser_PhoneNumberAdd_Multiple_Users_Same_Number(t *testing.T) {
-	a := newTestManager(t, PermissionDenyAll)
+	forEachBackend(t, func(t *testing.T, filename string) {
+		a := newTestManager(t, filename, PermissionDenyAll)
 
-	require.Nil(t, a.AddUser("phil", "phil", RoleUser))
-	require.Nil(t, a.AddUser("ben", "ben", RoleUser))
-	phil, err := a.User("phil")
-	require.Nil(t, err)
-	ben, err := a.User("ben")
-	require.Nil(t, err)
-	require.Nil(t, a.AddPhoneNumber(phil.ID, "+1234567890"))
-	require.Nil(t, a.AddPhoneNumber(ben.ID, "+1234567890"))
+		require.Nil(t, a.AddUser("phil", "phil", RoleUser))
+		require.Nil(t, a.AddUser("ben", "ben", RoleUser))
+		phil, err := a.User("phil")
+		require.Nil(t, err)
+		ben, err := a.User("ben")
+		require.Nil(t, err)
+		require.Nil(t, a.AddPhoneNumber(phil.ID, "+1234567890"))
+		require.Nil(t, a.AddPhoneNumber(ben.ID, "+1234567890"))
+	})
 }
 
 func TestManager_Topic_Wildcard_With_Asterisk_Underscore(t *testing.T) {
-	f := filepath.Join(t.TempDir(), "user.db")
-	a := newTestManagerFromFile(t, f, "", PermissionDenyAll, DefaultUserPasswordBcryptCost, DefaultUserStatsQueueWriterInterval)
-	require.Nil(t, a.AllowAccess(Everyone, "*_", PermissionRead))
-	require.Nil(t, a.AllowAccess(Everyone, "__*_", PermissionRead))
-	require.Nil(t, a.Authorize(nil, "allowed_", PermissionRead))
-	require.Nil(t, a.Authorize(nil, "__allowed_", PermissionRead))
-	require.Nil(t, a.Authorize(nil, "_allowed_", PermissionRead)) // The "%" in "%\_" matches the first "_"
-	require.Equal(t, ErrUnauthorized, a.Authorize(nil, "notallowed", PermissionRead))
-	require.Equal(t, ErrUnauthorized, a.Authorize(nil, "_notallowed", PermissionRead))
-	require.Equal(t, ErrUnauthorized, a.Authorize(nil, "__notallowed", PermissionRead))
+	forEachBackend(t, func(t *testing.T, filename string) {
+		a := newTestManagerFromFile(t, filename, "", PermissionDenyAll, DefaultUserPasswordBcryptCost, DefaultUserStatsQueueWriterInterval)
+		require.Nil(t, a.AllowAccess(Everyone, "*_", PermissionRead))
+		require.Nil(t, a.AllowAccess(Everyone, "__*_", PermissionRead))
+		require.Nil(t, a.Authorize(nil, "allowed_", PermissionRead))
+		require.Nil(t, a.Authorize(nil, "__allowed_", PermissionRead))
+		require.Nil(t, a.Authorize(nil, "_allowed_", PermissionRead)) // The "%" in "%\_" matches the first "_"
+		require.Equal(t, ErrUnauthorized, a.Authorize(nil, "notallowed", PermissionRead))
+		require.Equal(t, ErrUnauthorized, a.Authorize(nil, "_notallowed", PermissionRead))
+		require.Equal(t, ErrUnauthorized, a.Authorize(nil, "__notallowed", PermissionRead))
+	})
 }
 
 func TestManager_Topic_Wildcard_With_Underscore(t *testing.T) {
-	f := filepath.Join(t.TempDir(), "user.db")
-	a := newTestManagerFromFile(t, f, "", PermissionDenyAll, DefaultUserPasswordBcryptCost, DefaultUserStatsQueueWriterInterval)
-	require.Nil(t, a.AllowAccess(Everyone, "mytopic_", PermissionReadWrite))
-	require.Nil(t, a.Authorize(nil, "mytopic_", PermissionRead))
-	require.Nil(t, a.Authorize(nil, "mytopic_", PermissionWrite))
-	require.Equal(t, ErrUnauthorized, a.Authorize(nil, "mytopicX", PermissionRead))
-	require.Equal(t, ErrUnauthorized, a.Authorize(nil, "mytopicX", PermissionWrite))
+	forEachBackend(t, func(t *testing.T, filename string) {
+		a := newTestManagerFromFile(t, filename, "", PermissionDenyAll, DefaultUserPasswordBcryptCost, DefaultUserStatsQueueWriterInterval)
+		require.Nil(t, a.AllowAccess(Everyone, "mytopic_", PermissionReadWrite))
+		require.Nil(t, a.Authorize(nil, "mytopic_", PermissionRead))
+		require.Nil(t, a.Authorize(nil, "mytopic_", PermissionWrite))
+		require.Equal(t, ErrUnauthorized, a.Authorize(nil, "mytopicX", PermissionRead))
+		require.Equal(t, ErrUnauthorized, a.Authorize(nil, "mytopicX", PermissionWrite))
+	})
+}
+
+func TestManager_Reopen(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, filename string) {
+		a, err := NewManager(filename, "", PermissionDenyAll, bcrypt.MinCost, DefaultUserStatsQueueWriterInterval)
+		require.Nil(t, err)
+		require.Nil(t, a.AddUser("phil", "phil", RoleUser))
+		require.Nil(t, a.AllowAccess("phil", "mytopic", PermissionReadWrite))
+		require.Nil(t, a.Close())
+
+		a = newTestManager(t, filename, PermissionDenyAll) // Schema exists, no migration required
+		phil, err := a.Authenticate("phil", "phil")
+		require.Nil(t, err)
+		require.Nil(t, a.Authorize(phil, "mytopic", PermissionWrite))
+		require.Equal(t, ErrUserExists, a.AddUser("phil", "phil", RoleUser))
+	})
 }
 
 func TestToFromSQLWildcard(t *testing.T) {
@@ -1321,12 +2086,53 @@ func checkSchemaVersion(t *testing.T, db *sql.DB) {
 	require.Nil(t, rows.Close())
 }
 
-func newTestManager(t *testing.T, defaultAccess Permission) *Manager {
-	return newTestManagerFromFile(t, filepath.Join(t.TempDir(), "user.db"), "", defaultAccess, bcrypt.MinCost, DefaultUserStatsQueueWriterInterval)
+// forEachBackend runs the given test against a SQLite database file, and against a fresh schema of the
+// PostgreSQL database given in the NTFY_TEST_DATABASE_URL environment variable. If the variable is not set,
+// the PostgreSQL variant of the test is skipped.
+func forEachBackend(t *testing.T, f func(t *testing.T, filename string)) {
+	t.Run("sqlite", func(t *testing.T) {
+		f(t, filepath.Join(t.TempDir(), "user.db"))
+	})
+	t.Run("postgres", func(t *testing.T) {
+		f(t, newPostgresTestDatabaseURL(t))
+	})
+}
+
+func newPostgresTestDatabaseURL(t *testing.T) string {
+	databaseURL := os.Getenv("NTFY_TEST_DATABASE_URL")
+	if databaseURL == "" {
+		t.Skip("NTFY_TEST_DATABASE_URL not set, skipping PostgreSQL test")
+	}
+	db, err := sql.Open("postgres", databaseURL)
+	require.Nil(t, err)
+	defer db.Close()
+	schema := fmt.Sprintf("test_%s", strings.ToLower(util.RandomString(10)))
+	_, err = db.Exec(fmt.Sprintf("CREATE SCHEMA %s", schema))
+	require.Nil(t, err)
+	t.Cleanup(func() {
+		db, err := sql.Open("postgres", databaseURL)
+		require.Nil(t, err)
+		defer db.Close()
+		_, err = db.Exec(fmt.Sprintf("DROP SCHEMA %s CASCADE", schema))
+		require.Nil(t, err)
+	})
+	u, err := url.Parse(databaseURL)
+	require.Nil(t, err)
+	q := u.Query()
+	q.Set("search_path", schema)
+	u.RawQuery = q.Encode()
+	return u.String()
+}
+
+func newTestManager(t *testing.T, filename string, defaultAccess Permission) *Manager {
+	return newTestManagerFromFile(t, filename, "", defaultAccess, bcrypt.MinCost, DefaultUserStatsQueueWriterInterval)
 }
 
 func newTestManagerFromFile(t *testing.T, filename, startupQueries string, defaultAccess Permission, bcryptCost int, statsWriterInterval time.Duration) *Manager {
 	a, err := NewManager(filename, startupQueries, defaultAccess, bcryptCost, statsWriterInterval)
 	require.Nil(t, err)
+	t.Cleanup(func() {
+		a.Close()
+	})
 	return a
 }
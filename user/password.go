@@ -0,0 +1,61 @@
+package user
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Password character classes, see PasswordPolicy.MinClasses
+const (
+	passwordClassLower = 1 << iota
+	passwordClassUpper
+	passwordClassDigit
+	passwordClassOther
+)
+
+// passwordClassCount is the number of character classes, i.e. the maximum value of PasswordPolicy.MinClasses
+const passwordClassCount = 4
+
+// Validate checks the given password against the policy, and returns an error wrapping ErrPasswordPolicy
+// that describes the violated rule. A nil policy accepts any password.
+func (p *PasswordPolicy) Validate(username, password string) error {
+	if p == nil {
+		return nil
+	}
+	if utf8.RuneCountInString(password) < p.MinLength {
+		return fmt.Errorf("%w: password must be at least %d characters long", ErrPasswordPolicy, p.MinLength)
+	}
+	if p.MinClasses > 0 && passwordClasses(password) < p.MinClasses {
+		return fmt.Errorf("%w: password must contain at least %d of the following: lowercase letters, uppercase letters, digits, other characters", ErrPasswordPolicy, p.MinClasses)
+	}
+	if p.DisallowUsername && username != "" && strings.Contains(strings.ToLower(password), strings.ToLower(username)) {
+		return fmt.Errorf("%w: password must not contain the username", ErrPasswordPolicy)
+	}
+	return nil
+}
+
+// passwordClasses returns the number of distinct character classes in the password
+func passwordClasses(password string) int {
+	classes := 0
+	for _, r := range password {
+		switch {
+		case unicode.IsLower(r):
+			classes |= passwordClassLower
+		case unicode.IsUpper(r):
+			classes |= passwordClassUpper
+		case unicode.IsDigit(r):
+			classes |= passwordClassDigit
+		default:
+			classes |= passwordClassOther
+		}
+	}
+	count := 0
+	for i := 0; i < passwordClassCount; i++ {
+		if classes&(1<<i) != 0 {
+			count++
+		}
+	}
+	return count
+}
@@ -0,0 +1,39 @@
+package user
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPasswordPolicy_Validate(t *testing.T) {
+	var noPolicy *PasswordPolicy
+	require.Nil(t, noPolicy.Validate("phil", ""))
+	require.Nil(t, (&PasswordPolicy{}).Validate("phil", "phil"))
+
+	policy := &PasswordPolicy{MinLength: 10, MinClasses: 3, DisallowUsername: true}
+	require.Nil(t, policy.Validate("phil", "correct-Horse7"))
+	require.Nil(t, policy.Validate("phil", "Ünïcödé-123"))
+	require.Nil(t, policy.Validate("", "my-Phil-pass1"))
+
+	err := policy.Validate("phil", "short-P1")
+	require.ErrorIs(t, err, ErrPasswordPolicy)
+	require.Equal(t, "password does not meet the password policy: password must be at least 10 characters long", err.Error())
+
+	err = policy.Validate("phil", "onlylowercase-and-dashes")
+	require.ErrorIs(t, err, ErrPasswordPolicy)
+	require.Contains(t, err.Error(), "must contain at least 3 of the following")
+
+	err = policy.Validate("phil", "my-PHIL-pass1")
+	require.ErrorIs(t, err, ErrPasswordPolicy)
+	require.Contains(t, err.Error(), "must not contain the username")
+}
+
+func TestPasswordClasses(t *testing.T) {
+	require.Equal(t, 0, passwordClasses(""))
+	require.Equal(t, 1, passwordClasses("abc"))
+	require.Equal(t, 2, passwordClasses("abcDEF"))
+	require.Equal(t, 3, passwordClasses("abcDEF123"))
+	require.Equal(t, 4, passwordClasses("abcDEF123 "))
+	require.Equal(t, 4, passwordClasses("äÖ٣€"))
+}
@@ -0,0 +1,101 @@
+package user
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"heckel.io/ntfy/v2/util"
+)
+
+// TOTP (RFC 6238) parameters; these are the defaults all common authenticator apps support
+const (
+	totpPeriod       = 30 * time.Second
+	totpDigits       = 6
+	totpSkew         = 1  // Accept codes from one period before and after the current one
+	totpSecretLength = 20 // Bytes, i.e. 160 bits, as recommended by RFC 4226
+
+	recoveryCodeCount  = 10
+	recoveryCodeLength = 10 // Characters, formatted as xxxxx-xxxxx
+)
+
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// TwoFactorURI returns the otpauth:// URI for the given TOTP secret, which authenticator apps can
+// import (usually via a QR code), see https://github.com/google/google-authenticator/wiki/Key-Uri-Format
+func TwoFactorURI(issuer, username, secret string) string {
+	params := url.Values{}
+	params.Set("secret", secret)
+	params.Set("issuer", issuer)
+	label := url.PathEscape(issuer) + ":" + url.PathEscape(username)
+	return fmt.Sprintf("otpauth://totp/%s?%s", label, params.Encode())
+}
+
+// newTOTPSecret generates a random base32-encoded TOTP secret
+func newTOTPSecret() (string, error) {
+	b := make([]byte, totpSecretLength)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return totpEncoding.EncodeToString(b), nil
+}
+
+// totpCode calculates the TOTP code for the given secret and time step
+func totpCode(secret string, step int64) (string, error) {
+	key, err := totpEncoding.DecodeString(strings.ToUpper(secret))
+	if err != nil {
+		return "", err
+	}
+	var counter [8]byte
+	binary.BigEndian.PutUint64(counter[:], uint64(step))
+	h := hmac.New(sha1.New, key)
+	h.Write(counter[:])
+	sum := h.Sum(nil)
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", totpDigits, value%1000000), nil
+}
+
+// validateTOTP checks the given code against the secret, allowing for a bit of clock skew. It returns the
+// time step the code matched, which must be larger than lastStep, so that a code cannot be used twice.
+func validateTOTP(secret, code string, now time.Time, lastStep int64) (int64, bool) {
+	code = strings.ReplaceAll(code, " ", "")
+	if len(code) != totpDigits {
+		return 0, false
+	}
+	current := now.Unix() / int64(totpPeriod.Seconds())
+	for step := current - totpSkew; step <= current+totpSkew; step++ {
+		if step <= lastStep {
+			continue
+		}
+		expected, err := totpCode(secret, step)
+		if err != nil {
+			return 0, false
+		} else if hmac.Equal([]byte(code), []byte(expected)) {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+// newRecoveryCode generates a random single-use recovery code, e.g. 4f0ak-9zq2x
+func newRecoveryCode() string {
+	code := util.RandomLowerStringPrefix("", recoveryCodeLength)
+	return code[:recoveryCodeLength/2] + "-" + code[recoveryCodeLength/2:]
+}
+
+// hashRecoveryCode returns the SHA-256 hash of a recovery code, ignoring case, spaces and dashes. Since recovery
+// codes are random and only stored as hashes, a fast hash function is sufficient (unlike for passwords).
+func hashRecoveryCode(code string) string {
+	normalized := strings.NewReplacer("-", "", " ", "").Replace(strings.ToLower(code))
+	sum := sha256.Sum256([]byte(normalized))
+	return hex.EncodeToString(sum[:])
+}
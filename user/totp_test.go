@@ -0,0 +1,51 @@
+package user
+
+import (
+	"encoding/base32"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTOTP_RFC6238(t *testing.T) {
+	// Test vectors from RFC 6238, Appendix B (SHA1), truncated to 6 digits
+	secret := base32.StdEncoding.EncodeToString([]byte("12345678901234567890"))
+	for unix, expected := range map[int64]string{
+		59:          "287082",
+		1111111109:  "081804",
+		1111111111:  "050471",
+		1234567890:  "005924",
+		2000000000:  "279037",
+		20000000000: "353130",
+	} {
+		code, err := totpCode(secret, unix/30)
+		require.Nil(t, err)
+		require.Equal(t, expected, code)
+	}
+}
+
+func TestTOTP_Validate(t *testing.T) {
+	secret, err := newTOTPSecret()
+	require.Nil(t, err)
+	now := time.Unix(1700000000, 0)
+	step := now.Unix() / 30
+	code, err := totpCode(secret, step)
+	require.Nil(t, err)
+
+	matched, ok := validateTOTP(secret, code, now, 0)
+	require.True(t, ok)
+	require.Equal(t, step, matched)
+	_, ok = validateTOTP(secret, code[:3]+" "+code[3:], now.Add(30*time.Second), 0) // Clock skew, spaces
+	require.True(t, ok)
+	_, ok = validateTOTP(secret, code, now.Add(2*time.Minute), 0) // Too old
+	require.False(t, ok)
+	_, ok = validateTOTP(secret, code, now, step) // Already used
+	require.False(t, ok)
+	_, ok = validateTOTP(secret, "12345", now, 0)
+	require.False(t, ok)
+}
+
+func TestTOTP_URI(t *testing.T) {
+	require.Equal(t, "otpauth://totp/ntfy:phil%20h?issuer=ntfy&secret=ABCDEF", TwoFactorURI("ntfy", "phil h", "ABCDEF"))
+}
@@ -15,7 +15,7 @@ type User struct {
 	ID        string
 	Name      string
 	Hash      string // password hash (bcrypt)
-	Token     string // Only set if token was used to log in
+	Token     string // Only set if token was used to log in, see Token.Scopes
 	Role      Role
 	Prefs     *Prefs
 	Tier      *Tier
@@ -63,6 +63,7 @@ type Token struct {
 	LastAccess time.Time
 	LastOrigin netip.Addr
 	Expires    time.Time
+	Scopes     []Grant // If set, the token is restricted to these topic patterns and permissions
 }
 
 // TokenUpdate holds information about the last access time and origin IP address of a token
@@ -151,6 +152,14 @@ type Grant struct {
 	Allow        Permission
 }
 
+// Group is a named set of users that share access control entries
+type Group struct {
+	ID      string
+	Name    string
+	Members []string // Usernames, sorted
+	Grants  []Grant
+}
+
 // Reservation is a struct that represents the ownership over a topic by a user
 type Reservation struct {
 	Topic    string
@@ -158,6 +167,108 @@ type Reservation struct {
 	Everyone Permission
 }
 
+// TopicRetention is a per-topic retention policy, set by the topic owner or an admin. Messages older than
+// MaxAge, or older than the newest MaxMessages messages, are deleted. A zero value means no limit.
+type TopicRetention struct {
+	Topic       string
+	MaxAge      time.Duration
+	MaxMessages int64
+}
+
+// TopicEscalation is a per-topic escalation policy, set by the topic owner. If a message with the highest priority
+// is not acknowledged within Delay, it is re-published to ForwardTopic, then sent as e-mail to Email, and then
+// PhoneNumber is called, waiting Delay between the steps. Steps that are not set are skipped.
+type TopicEscalation struct {
+	Topic        string
+	Delay        time.Duration
+	ForwardTopic string
+	Email        string
+	PhoneNumber  string
+}
+
+// TopicWebhook is an outbound webhook, registered by the topic owner. Every message published to Topic is
+// POSTed as JSON to URL, signed with Secret (HMAC-SHA256).
+type TopicWebhook struct {
+	ID      string
+	Topic   string
+	URL     string
+	Secret  string
+	Created time.Time
+}
+
+// PasswordPolicy defines the rules for new passwords, see Manager.SetPasswordPolicy. Zero values disable a rule.
+type PasswordPolicy struct {
+	MinLength        int  // Minimum number of characters
+	MinClasses       int  // Minimum number of character classes (lowercase, uppercase, digits, other characters)
+	DisallowUsername bool // If true, the password must not contain the username (case-insensitive)
+}
+
+// AuditEvent is an entry in the append-only audit log, recording a security-relevant change or event
+type AuditEvent struct {
+	ID      int64
+	Time    time.Time
+	Actor   string      // User that performed the action; empty if anonymous, or if done via the CLI
+	Origin  string      // IP address of the actor, or AuditOriginCLI
+	Action  AuditAction // What happened, e.g. AuditActionUserAdd
+	Target  string      // User affected by the action, may be empty
+	Details string      // Additional human-readable details, e.g. the topic and permission
+}
+
+// Actor is who makes changes via a Manager, as recorded in the audit log, see Manager.WithActor
+type Actor struct {
+	Name   string // User that makes the changes; empty if anonymous, or if done via the CLI
+	Origin string // IP address of the actor, AuditOriginCLI or AuditOriginLDAP
+	Reason string // Optional, added to the details of each audit event, e.g. "oidc"
+}
+
+// AuditFilter restricts the events returned by Manager.AuditEvents. Empty fields match all events.
+type AuditFilter struct {
+	User   string // Matches the actor or the target of an event
+	Action AuditAction
+	Since  time.Time
+	Until  time.Time
+	Limit  int
+}
+
+// AuditAction describes what happened in an AuditEvent
+type AuditAction string
+
+// Audit actions
+const (
+	AuditActionUserAdd              = AuditAction("user.add")
+	AuditActionUserRemove           = AuditAction("user.remove")
+	AuditActionUserChangePassword   = AuditAction("user.change-password")
+	AuditActionUserChangeRole       = AuditAction("user.change-role")
+	AuditActionUserChangeTier       = AuditAction("user.change-tier")
+	AuditActionUserUnlock           = AuditAction("user.unlock")
+	AuditActionAccessAllow          = AuditAction("access.allow")
+	AuditActionAccessReset          = AuditAction("access.reset")
+	AuditActionTokenCreate          = AuditAction("token.create")
+	AuditActionTokenUpdate          = AuditAction("token.update")
+	AuditActionTokenRemove          = AuditAction("token.remove")
+	AuditActionReservationAdd       = AuditAction("reservation.add")
+	AuditActionReservationRemove    = AuditAction("reservation.remove")
+	AuditActionTwoFactorEnable      = AuditAction("2fa.enable")
+	AuditActionTwoFactorDisable     = AuditAction("2fa.disable")
+	AuditActionLoginFailed          = AuditAction("login.failed")
+	AuditActionGroupAdd             = AuditAction("group.add")
+	AuditActionGroupRemove          = AuditAction("group.remove")
+	AuditActionGroupMemberAdd       = AuditAction("group.member-add")
+	AuditActionGroupMemberRemove    = AuditAction("group.member-remove")
+	AuditActionGroupAccessAllow     = AuditAction("group.access-allow")
+	AuditActionGroupAccessReset     = AuditAction("group.access-reset")
+	AuditActionTopicRetentionChange = AuditAction("topic.retention-change")
+	AuditActionTopicRetentionReset  = AuditAction("topic.retention-reset")
+	AuditActionSignedURLCreate      = AuditAction("signed-url.create")
+	AuditActionSignedURLRevoke      = AuditAction("signed-url.revoke")
+)
+
+// Audit origins of changes that are not made via the HTTP API
+const (
+	AuditOriginCLI  = "cli"  // Changes made via the "ntfy" command line tool
+	AuditOriginLDAP = "ldap" // Changes made when syncing users and access from LDAP
+)
+
 // Permission represents a read or write permission to a topic
 type Permission uint8
 
@@ -245,6 +356,7 @@ var (
 	allowedTopicRegex        = regexp.MustCompile(`^[-_A-Za-z0-9]{1,64}$`)  // No '*'
 	allowedTopicPatternRegex = regexp.MustCompile(`^[-_*A-Za-z0-9]{1,64}$`) // Adds '*' for wildcards!
 	allowedTierRegex         = regexp.MustCompile(`^[-_A-Za-z0-9]{1,64}$`)
+	allowedGroupRegex        = regexp.MustCompile(`^[-_.A-Za-z0-9]{1,64}$`)
 )
 
 // AllowedRole returns true if the given role can be used for new users
@@ -272,16 +384,37 @@ func AllowedTier(tier string) bool {
 	return allowedTierRegex.MatchString(tier)
 }
 
+// AllowedGroup returns true if the given group name is valid
+func AllowedGroup(group string) bool {
+	return allowedGroupRegex.MatchString(group)
+}
+
+// IsToken returns true if the given string looks like an access token, i.e. if it has the right prefix
+// and length. It does not check whether the token exists.
+func IsToken(s string) bool {
+	return strings.HasPrefix(s, tokenPrefix) && len(s) == tokenLength
+}
+
 // Error constants used by the package
 var (
-	ErrUnauthenticated     = errors.New("unauthenticated")
-	ErrUnauthorized        = errors.New("unauthorized")
-	ErrInvalidArgument     = errors.New("invalid argument")
-	ErrUserNotFound        = errors.New("user not found")
-	ErrUserExists          = errors.New("user already exists")
-	ErrTierNotFound        = errors.New("tier not found")
-	ErrTokenNotFound       = errors.New("token not found")
-	ErrPhoneNumberNotFound = errors.New("phone number not found")
-	ErrTooManyReservations = errors.New("new tier has lower reservation limit")
-	ErrPhoneNumberExists   = errors.New("phone number already exists")
+	ErrUnauthenticated         = errors.New("unauthenticated")
+	ErrUnauthorized            = errors.New("unauthorized")
+	ErrInvalidArgument         = errors.New("invalid argument")
+	ErrUserNotFound            = errors.New("user not found")
+	ErrUserExists              = errors.New("user already exists")
+	ErrTierNotFound            = errors.New("tier not found")
+	ErrTokenNotFound           = errors.New("token not found")
+	ErrPhoneNumberNotFound     = errors.New("phone number not found")
+	ErrTooManyReservations     = errors.New("new tier has lower reservation limit")
+	ErrPhoneNumberExists       = errors.New("phone number already exists")
+	ErrTopicRetentionNotFound  = errors.New("topic retention not found")
+	ErrTopicEscalationNotFound = errors.New("topic escalation not found")
+	ErrTopicWebhookNotFound    = errors.New("topic webhook not found")
+	ErrGroupNotFound           = errors.New("group not found")
+	ErrGroupExists             = errors.New("group already exists")
+	ErrTwoFactorNotFound       = errors.New("two-factor authentication not set up")
+	ErrTwoFactorEnabled        = errors.New("two-factor authentication already enabled")
+	ErrTwoFactorCodeInvalid    = errors.New("two-factor authentication code invalid")
+	ErrPasswordPolicy          = errors.New("password does not meet the password policy")
+	ErrUserLocked              = errors.New("user temporarily locked due to too many failed logins")
 )
@@ -0,0 +1,228 @@
+package util
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	scheduleEveryPrefix  = "every "
+	scheduleCronTZPrefix = "CRON_TZ="
+	scheduleMinInterval  = time.Minute
+	scheduleMaxYears     = 5 // Give up looking for the next occurrence after this many years (e.g. for "0 0 30 2 *")
+)
+
+var (
+	errScheduleIntervalTooSmall = errors.New("interval must be at least one minute")
+	errScheduleInvalid          = errors.New("schedule must be a cron expression with five fields (minute, hour, day of month, month, day of week), a macro such as @daily, or an interval such as 'every 1h'")
+
+	scheduleMacros = map[string]string{
+		"@yearly":   "0 0 1 1 *",
+		"@annually": "0 0 1 1 *",
+		"@monthly":  "0 0 1 * *",
+		"@weekly":   "0 0 * * 0",
+		"@daily":    "0 0 * * *",
+		"@midnight": "0 0 * * *",
+		"@hourly":   "0 * * * *",
+	}
+	cronMonthNames = map[string]int{
+		"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6,
+		"jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12,
+	}
+	cronWeekdayNames = map[string]int{
+		"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6,
+	}
+)
+
+// Schedule is a recurring schedule, as returned by ParseSchedule
+type Schedule interface {
+	// Next returns the first occurrence of the schedule after t, or the zero time if there is none
+	Next(t time.Time) time.Time
+}
+
+// ParseSchedule parses a recurring schedule. The following formats are supported:
+//
+//   - Cron expressions with five fields (minute, hour, day of month, month, day of week), e.g. "30 9 * * mon-fri".
+//     Each field may be "*", a value, a range ("1-5"), a list ("1,15") or a step ("*/15", "0-30/10"). Cron
+//     expressions are evaluated in the server's local time zone, unless prefixed with "CRON_TZ=<zone> ".
+//   - Macros, e.g. "@hourly", "@daily", "@weekly", "@monthly" or "@yearly"
+//   - Intervals, e.g. "every 1h" or "every 2d" (see ParseDuration); the interval must be at least one minute
+func ParseSchedule(spec string) (Schedule, error) {
+	spec = strings.TrimSpace(spec)
+	if strings.HasPrefix(strings.ToLower(spec), scheduleEveryPrefix) {
+		return parseIntervalSchedule(strings.TrimSpace(spec[len(scheduleEveryPrefix):]))
+	}
+	location := time.Local
+	if strings.HasPrefix(spec, scheduleCronTZPrefix) {
+		zone, rest, _ := strings.Cut(spec[len(scheduleCronTZPrefix):], " ")
+		loc, err := time.LoadLocation(zone)
+		if err != nil {
+			return nil, fmt.Errorf("invalid time zone %s", zone)
+		}
+		location, spec = loc, strings.TrimSpace(rest)
+	}
+	if macro, ok := scheduleMacros[strings.ToLower(spec)]; ok {
+		spec = macro
+	}
+	return parseCronSchedule(spec, location)
+}
+
+type intervalSchedule struct {
+	interval time.Duration
+}
+
+func parseIntervalSchedule(s string) (*intervalSchedule, error) {
+	interval, err := ParseDuration(s)
+	if err != nil {
+		return nil, errScheduleInvalid
+	} else if interval < scheduleMinInterval {
+		return nil, errScheduleIntervalTooSmall
+	}
+	return &intervalSchedule{interval: interval}, nil
+}
+
+func (s *intervalSchedule) Next(t time.Time) time.Time {
+	return t.Truncate(time.Second).Add(s.interval)
+}
+
+type cronSchedule struct {
+	minute, hour, dom, month, dow uint64 // Bit sets, e.g. bit 5 in minute set means "at minute 5"
+	domStar, dowStar              bool   // Day of month/week field starts with "*", see dayMatches
+	location                      *time.Location
+}
+
+func parseCronSchedule(spec string, location *time.Location) (*cronSchedule, error) {
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		return nil, errScheduleInvalid
+	}
+	minute, err := parseCronField(fields[0], 0, 59, nil)
+	if err != nil {
+		return nil, err
+	}
+	hour, err := parseCronField(fields[1], 0, 23, nil)
+	if err != nil {
+		return nil, err
+	}
+	dom, err := parseCronField(fields[2], 1, 31, nil)
+	if err != nil {
+		return nil, err
+	}
+	month, err := parseCronField(fields[3], 1, 12, cronMonthNames)
+	if err != nil {
+		return nil, err
+	}
+	dow, err := parseCronField(fields[4], 0, 7, cronWeekdayNames)
+	if err != nil {
+		return nil, err
+	}
+	if dow&(1<<7) != 0 {
+		dow |= 1 // 7 is Sunday, same as 0
+	}
+	return &cronSchedule{
+		minute:   minute,
+		hour:     hour,
+		dom:      dom,
+		month:    month,
+		dow:      dow,
+		domStar:  strings.HasPrefix(fields[2], "*"),
+		dowStar:  strings.HasPrefix(fields[4], "*"),
+		location: location,
+	}, nil
+}
+
+func parseCronField(field string, min, max int, names map[string]int) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(field, ",") {
+		rangeStr, stepStr, hasStep := strings.Cut(part, "/")
+		start, end, step := min, max, 1
+		if rangeStr != "*" {
+			startStr, endStr, hasEnd := strings.Cut(rangeStr, "-")
+			var err error
+			if start, err = parseCronValue(startStr, names); err != nil {
+				return 0, err
+			}
+			if hasEnd {
+				if end, err = parseCronValue(endStr, names); err != nil {
+					return 0, err
+				}
+			} else if !hasStep {
+				end = start
+			}
+		}
+		if hasStep {
+			var err error
+			if step, err = strconv.Atoi(stepStr); err != nil || step <= 0 {
+				return 0, fmt.Errorf("invalid step in cron field %s", field)
+			}
+		}
+		if start < min || end > max || start > end {
+			return 0, fmt.Errorf("cron field %s out of range, must be between %d and %d", field, min, max)
+		}
+		for i := start; i <= end; i += step {
+			bits |= 1 << uint(i)
+		}
+	}
+	return bits, nil
+}
+
+func parseCronValue(s string, names map[string]int) (int, error) {
+	if value, ok := names[strings.ToLower(s)]; ok {
+		return value, nil
+	}
+	value, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid value %s in cron field", s)
+	}
+	return value, nil
+}
+
+// Next returns the first matching minute after t. The algorithm skips ahead field by field, starting with the
+// month, and wraps around to the next year if necessary. It gives up after scheduleMaxYears years.
+func (s *cronSchedule) Next(t time.Time) time.Time {
+	t = t.In(s.location).Truncate(time.Minute).Add(time.Minute)
+	yearLimit := t.Year() + scheduleMaxYears
+wrap:
+	if t.Year() > yearLimit {
+		return time.Time{}
+	}
+	for s.month&(1<<uint(t.Month())) == 0 {
+		t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, s.location)
+		if t.Month() == time.January {
+			goto wrap
+		}
+	}
+	for !s.dayMatches(t) {
+		t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, s.location)
+		if t.Day() == 1 {
+			goto wrap
+		}
+	}
+	for s.hour&(1<<uint(t.Hour())) == 0 {
+		t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, s.location)
+		if t.Hour() == 0 {
+			goto wrap
+		}
+	}
+	for s.minute&(1<<uint(t.Minute())) == 0 {
+		t = t.Add(time.Minute)
+		if t.Minute() == 0 {
+			goto wrap
+		}
+	}
+	return t
+}
+
+// dayMatches implements the cron day matching rule: if either the day of month or the day of week field
+// is unrestricted ("*"), both must match; otherwise, it is enough if one of them matches
+func (s *cronSchedule) dayMatches(t time.Time) bool {
+	domMatch := s.dom&(1<<uint(t.Day())) != 0
+	dowMatch := s.dow&(1<<uint(t.Weekday())) != 0
+	if s.domStar || s.dowStar {
+		return domMatch && dowMatch
+	}
+	return domMatch || dowMatch
+}
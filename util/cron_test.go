@@ -0,0 +1,84 @@
+package util
+
+import (
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestParseSchedule_Cron(t *testing.T) {
+	s, err := ParseSchedule("CRON_TZ=UTC 30 9 * * mon-fri")
+	require.Nil(t, err)
+	require.Equal(t, time.Date(2021, 12, 13, 9, 30, 0, 0, time.UTC), s.Next(base)) // base is a Friday, 10:17
+	require.Equal(t, time.Date(2021, 12, 14, 9, 30, 0, 0, time.UTC), s.Next(time.Date(2021, 12, 13, 9, 30, 0, 0, time.UTC)))
+
+	s, err = ParseSchedule("CRON_TZ=UTC */15 * * * *")
+	require.Nil(t, err)
+	require.Equal(t, time.Date(2021, 12, 10, 10, 30, 0, 0, time.UTC), s.Next(base))
+
+	s, err = ParseSchedule("CRON_TZ=UTC 0 0 1,15 * *")
+	require.Nil(t, err)
+	require.Equal(t, time.Date(2021, 12, 15, 0, 0, 0, 0, time.UTC), s.Next(base))
+	require.Equal(t, time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC), s.Next(time.Date(2021, 12, 15, 0, 0, 0, 0, time.UTC)))
+
+	s, err = ParseSchedule("CRON_TZ=UTC 0 12 29 feb *")
+	require.Nil(t, err)
+	require.Equal(t, time.Date(2024, 2, 29, 12, 0, 0, 0, time.UTC), s.Next(base))
+
+	s, err = ParseSchedule("CRON_TZ=UTC 0 8 * * 7")
+	require.Nil(t, err)
+	require.Equal(t, time.Date(2021, 12, 12, 8, 0, 0, 0, time.UTC), s.Next(base)) // 7 = Sunday
+
+	s, err = ParseSchedule("CRON_TZ=UTC 0 0 13 * fri") // The 13th, or any Friday
+	require.Nil(t, err)
+	require.Equal(t, time.Date(2021, 12, 13, 0, 0, 0, 0, time.UTC), s.Next(base))
+	require.Equal(t, time.Date(2021, 12, 17, 0, 0, 0, 0, time.UTC), s.Next(time.Date(2021, 12, 13, 0, 0, 0, 0, time.UTC)))
+
+	s, err = ParseSchedule("CRON_TZ=UTC 0 0 30 2 *") // Never
+	require.Nil(t, err)
+	require.True(t, s.Next(base).IsZero())
+}
+
+func TestParseSchedule_CronTimeZone(t *testing.T) {
+	s, err := ParseSchedule("CRON_TZ=America/New_York 0 9 * * *")
+	require.Nil(t, err)
+	require.Equal(t, time.Date(2021, 12, 10, 14, 0, 0, 0, time.UTC), s.Next(base).UTC())
+}
+
+func TestParseSchedule_Macros(t *testing.T) {
+	s, err := ParseSchedule("CRON_TZ=UTC @hourly")
+	require.Nil(t, err)
+	require.Equal(t, time.Date(2021, 12, 10, 11, 0, 0, 0, time.UTC), s.Next(base))
+
+	s, err = ParseSchedule("CRON_TZ=UTC @daily")
+	require.Nil(t, err)
+	require.Equal(t, time.Date(2021, 12, 11, 0, 0, 0, 0, time.UTC), s.Next(base))
+
+	s, err = ParseSchedule("CRON_TZ=UTC @weekly")
+	require.Nil(t, err)
+	require.Equal(t, time.Date(2021, 12, 12, 0, 0, 0, 0, time.UTC), s.Next(base))
+
+	s, err = ParseSchedule("CRON_TZ=UTC @monthly")
+	require.Nil(t, err)
+	require.Equal(t, time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC), s.Next(base))
+}
+
+func TestParseSchedule_Every(t *testing.T) {
+	s, err := ParseSchedule("every 1h")
+	require.Nil(t, err)
+	require.Equal(t, base.Add(time.Hour), s.Next(base))
+
+	s, err = ParseSchedule("Every 2d")
+	require.Nil(t, err)
+	require.Equal(t, base.Add(48*time.Hour), s.Next(base))
+
+	_, err = ParseSchedule("every 30s")
+	require.Equal(t, errScheduleIntervalTooSmall, err)
+}
+
+func TestParseSchedule_Invalid(t *testing.T) {
+	for _, spec := range []string{"", "* * * *", "60 * * * *", "* 24 * * *", "* * 0 * *", "* * * 13 *", "* * * * 8", "*/0 * * * *", "5-1 * * * *", "* * * foo *", "every", "every soon", "CRON_TZ=Nowhere/Nope * * * * *", "@sometimes"} {
+		_, err := ParseSchedule(spec)
+		require.NotNil(t, err, "spec %q should fail", spec)
+	}
+}
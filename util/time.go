@@ -54,6 +54,19 @@ func ParseFutureTime(s string, now time.Time) (time.Time, error) {
 	return time.Time{}, errUnparsableTime
 }
 
+// ParsePastTime parses a date/time string to a time.Time in the past. It supports unix timestamps, and
+// durations, which are subtracted from now, e.g. "2h" or "3d"
+func ParsePastTime(s string, now time.Time) (time.Time, error) {
+	s = strings.TrimSpace(s)
+	if t, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return time.Unix(t, 0).UTC(), nil
+	}
+	if d, err := ParseDuration(s); err == nil {
+		return now.Add(-d), nil
+	}
+	return time.Time{}, errUnparsableTime
+}
+
 // ParseDuration is like time.ParseDuration, except that it also understands days (d), which
 // translates to 24 hours, e.g. "2d" or "20h".
 func ParseDuration(s string) (time.Duration, error) {
@@ -79,6 +79,23 @@ func TestParseFutureTime_UnixTime(t *testing.T) {
 	require.Equal(t, time.Date(2021, 12, 11, 0, 51, 51, 0, time.UTC), d)
 }
 
+func TestParsePastTime(t *testing.T) {
+	d, err := ParsePastTime("1639131443", base)
+	require.Nil(t, err)
+	require.Equal(t, time.Date(2021, 12, 10, 10, 17, 23, 0, time.UTC), d)
+
+	d, err = ParsePastTime("3h", base)
+	require.Nil(t, err)
+	require.Equal(t, time.Date(2021, 12, 10, 7, 17, 23, 0, time.UTC), d)
+
+	d, err = ParsePastTime("2 days", base)
+	require.Nil(t, err)
+	require.Equal(t, time.Date(2021, 12, 8, 10, 17, 23, 0, time.UTC), d)
+
+	_, err = ParsePastTime("yesterday", base)
+	require.Equal(t, errUnparsableTime, err)
+}
+
 func TestParseDuration(t *testing.T) {
 	d, err := ParseDuration("2d")
 	require.Nil(t, err)
@@ -47,6 +47,12 @@ func FileExists(filename string) bool {
 	return stat != nil
 }
 
+// IsPostgresURL returns true if the given database filename is a PostgreSQL connection URL
+// (postgres://... or postgresql://...) rather than a path to a SQLite file
+func IsPostgresURL(filename string) bool {
+	return strings.HasPrefix(filename, "postgres://") || strings.HasPrefix(filename, "postgresql://")
+}
+
 // Contains returns true if needle is contained in haystack
 func Contains[T comparable](haystack []T, needle T) bool {
 	for _, s := range haystack {
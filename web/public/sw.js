@@ -21,9 +21,27 @@ import initI18n from "../src/app/i18n";
 
 const broadcastChannel = new BroadcastChannel("web-push-broadcast");
 
+/**
+ * Remove the original message (and all of its previous updates) from the database, and close
+ * any notification that is still displayed for it. Used for "message_update" and "message_delete" events.
+ */
+const removeReplacedNotifications = async (replaces) => {
+  const db = await dbAsync();
+  await db.notifications.filter((n) => n.id === replaces || n.replaces === replaces).delete();
+
+  const notifications = await self.registration.getNotifications();
+  notifications
+    .filter((n) => n.data?.message?.id === replaces || n.data?.message?.replaces === replaces)
+    .forEach((n) => n.close());
+};
+
 const addNotification = async ({ subscriptionId, message }) => {
   const db = await dbAsync();
 
+  if (message.replaces) {
+    await removeReplacedNotifications(message.replaces);
+  }
+
   await db.notifications.add({
     ...message,
     subscriptionId,
@@ -62,6 +80,15 @@ const handlePushMessage = async (data) => {
   );
 };
 
+/**
+ * Handle a received web push message deletion. No notification is shown, since the
+ * delete event only references the original message.
+ */
+const handlePushMessageDelete = async (data) => {
+  const { message } = data;
+  await removeReplacedNotifications(message.replaces);
+};
+
 /**
  * Handle a received web push subscription expiring.
  */
@@ -98,6 +125,8 @@ const handlePushUnknown = async (data) => {
 const handlePush = async (data) => {
   if (data.event === "message") {
     await handlePushMessage(data);
+  } else if (data.event === "message_delete") {
+    await handlePushMessageDelete(data);
   } else if (data.event === "subscription_expiring") {
     await handlePushSubscriptionExpiring(data);
   } else {
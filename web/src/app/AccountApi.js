@@ -46,6 +46,7 @@ class AccountApi {
     const response = await fetchOrThrow(url, {
       method: "POST",
       headers: withBasicAuth({}, user.username, user.password),
+      body: user.code ? JSON.stringify({ code: user.code }) : undefined,
     });
     const json = await response.json(); // May throw SyntaxError
     if (!json.token) {
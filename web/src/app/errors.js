@@ -15,6 +15,14 @@ export class UnauthorizedError extends Error {
   }
 }
 
+export class TwoFactorRequiredError extends Error {
+  static CODE = 40102; // errHTTPUnauthorizedTwoFactorCodeRequired
+
+  constructor() {
+    super("Two-factor authentication code missing or invalid");
+  }
+}
+
 export class UserExistsError extends Error {
   static CODE = 40901; // errHTTPConflictUserExists
 
@@ -50,6 +58,10 @@ export class IncorrectPasswordError extends Error {
 export const throwAppError = async (response) => {
   if (response.status === 401 || response.status === 403) {
     console.log(`[Error] HTTP ${response.status}`, response);
+    const error = await maybeToJson(response);
+    if (error?.code === TwoFactorRequiredError.CODE) {
+      throw new TwoFactorRequiredError();
+    }
     throw new UnauthorizedError();
   }
   const error = await maybeToJson(response);
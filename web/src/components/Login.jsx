@@ -1,5 +1,5 @@
 import * as React from "react";
-import { useState } from "react";
+import { useEffect, useState } from "react";
 import { Typography, TextField, Button, Box, IconButton, InputAdornment } from "@mui/material";
 import WarningAmberIcon from "@mui/icons-material/WarningAmber";
 import { NavLink } from "react-router-dom";
@@ -9,7 +9,7 @@ import accountApi from "../app/AccountApi";
 import AvatarBox from "./AvatarBox";
 import session from "../app/Session";
 import routes from "./routes";
-import { UnauthorizedError } from "../app/errors";
+import { TwoFactorRequiredError, UnauthorizedError } from "../app/errors";
 
 const Login = () => {
   const { t } = useTranslation();
@@ -17,10 +17,23 @@ const Login = () => {
   const [username, setUsername] = useState("");
   const [password, setPassword] = useState("");
   const [showPassword, setShowPassword] = useState(false);
+  const [code, setCode] = useState("");
+  const [codeRequired, setCodeRequired] = useState(false);
+
+  // After logging in via SSO, the server redirects here, with the username and token in the URL fragment
+  useEffect(() => {
+    const params = new URLSearchParams(window.location.hash.substring(1));
+    if (params.get("user") && params.get("token")) {
+      console.log(`[Login] SSO login for user ${params.get("user")} successful`);
+      session.store(params.get("user"), params.get("token")).then(() => {
+        window.location.href = routes.app;
+      });
+    }
+  }, []);
 
   const handleSubmit = async (event) => {
     event.preventDefault();
-    const user = { username, password };
+    const user = { username, password, code };
     try {
       const token = await accountApi.login(user);
       console.log(`[Login] User auth for user ${user.username} successful, token is ${token}`);
@@ -28,7 +41,10 @@ const Login = () => {
       window.location.href = routes.app;
     } catch (e) {
       console.log(`[Login] User auth for user ${user.username} failed`, e);
-      if (e instanceof UnauthorizedError) {
+      if (e instanceof TwoFactorRequiredError) {
+        setError(codeRequired ? t("login_form_code_invalid") : "");
+        setCodeRequired(true);
+      } else if (e instanceof UnauthorizedError) {
         setError(t("Login failed: Invalid username or password"));
       } else {
         setError(e.message);
@@ -83,9 +99,34 @@ const Login = () => {
             ),
           }}
         />
-        <Button type="submit" fullWidth variant="contained" disabled={username === "" || password === ""} sx={{ mt: 2, mb: 2 }}>
+        {codeRequired && (
+          <TextField
+            margin="dense"
+            required
+            fullWidth
+            name="code"
+            label={t("login_form_code")}
+            id="code"
+            value={code}
+            onChange={(ev) => setCode(ev.target.value.trim())}
+            autoComplete="one-time-code"
+            autoFocus
+          />
+        )}
+        <Button
+          type="submit"
+          fullWidth
+          variant="contained"
+          disabled={username === "" || password === "" || (codeRequired && code === "")}
+          sx={{ mt: 2, mb: 2 }}
+        >
           {t("login_form_button_submit")}
         </Button>
+        {config.enable_oidc && (
+          <Button fullWidth variant="outlined" href={`${config.base_url}/v1/account/oidc/login`} sx={{ mb: 2 }}>
+            {t("login_form_button_sso")}
+          </Button>
+        )}
         {error && (
           <Box
             sx={{